@@ -1,6 +1,11 @@
 package main
 
 import (
+	"DigiPassAuthenticationApi/jobs"
+	"DigiPassAuthenticationApi/migrations"
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/password"
+	"DigiPassAuthenticationApi/packages/throttle"
 	"DigiPassAuthenticationApi/routes"
 	"github.com/labstack/echo/v5"
 	"github.com/labstack/echo/v5/middleware"
@@ -11,15 +16,33 @@ import (
 )
 
 func Run() error {
+	breachedCorpus, err := password.CheckBreachedCorpus()
+	if err != nil {
+		return err
+	}
+	if !breachedCorpus {
+		log.Println("WARNING: BREACHED_PASSWORDS_DIR not set - check_breached is enabled by default but passwords are NOT checked against a breach corpus")
+	}
+
 	db := initDB()
+	if err := migrations.Run(db); err != nil {
+		return err
+	}
+	loginThrottle := throttle.FromEnv(db)
+	jobs.StartPurger(db, loginThrottle)
+
 	e := echo.New()
 	e.Use(middleware.RequestLogger())
 	e.Use(middleware.Recover())
 
-	// Middleware to Inject DB into each request context
+	mail := mailer.FromEnv()
+
+	// Middleware to Inject DB, Mailer and the login throttle store into each request context
 	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c *echo.Context) error {
 			c.Set("db", db)
+			c.Set("mailer", mail)
+			c.Set("throttle", loginThrottle)
 			return next(c)
 		}
 	})
@@ -4,7 +4,8 @@ CREATE TABLE "accounts" (
   "email" varchar(255) UNIQUE NOT NULL,
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
-  "status" varchar(50) DEFAULT 'active'
+  "status" varchar(50) DEFAULT 'active',
+  "deleted_at" timestamp
 );
 
 CREATE TABLE "tenants" (
@@ -15,21 +16,25 @@ CREATE TABLE "tenants" (
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "status" varchar(50) DEFAULT 'active',
-  "settings" jsonb
+  "settings" jsonb,
+  "deleted_at" timestamp
 );
 
 CREATE TABLE "clients" (
   "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
   "client_id" varchar(255) UNIQUE NOT NULL,
   "client_secret_hash" varchar(255) NOT NULL,
+  "previous_secret_hash" varchar(255),
+  "previous_secret_expires_at" timestamp,
   "tenant_id" uuid NOT NULL,
   "name" varchar(255) NOT NULL,
   "description" text,
   "redirect_uris" text[] NOT NULL,
-  "grant_types" varchar(50) NOT NULL,
-  "response_types" varchar(50) NOT NULL,
-  "scopes" varchar(100) NOT NULL,
+  "grant_types" text[] NOT NULL,
+  "response_types" text[] NOT NULL,
+  "scopes" text[] NOT NULL,
   "is_confidential" boolean DEFAULT true,
+  "token_claims" text[],
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "status" varchar(50) DEFAULT 'active'
@@ -41,6 +46,7 @@ CREATE TABLE "users" (
   "email" varchar(255) NOT NULL,
   "email_verified" boolean DEFAULT false,
   "password_hash" varchar(255),
+  "password_changed_at" timestamp,
   "given_name" varchar(255),
   "family_name" varchar(255),
   "picture_url" text,
@@ -48,7 +54,8 @@ CREATE TABLE "users" (
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "last_login_at" timestamp,
-  "status" varchar(50) DEFAULT 'active'
+  "status" varchar(50) DEFAULT 'active',
+  "external_id" varchar(255)
 );
 
 CREATE TABLE "authorization_codes" (
@@ -63,7 +70,8 @@ CREATE TABLE "authorization_codes" (
   "expires_at" timestamp NOT NULL,
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "used_at" timestamp,
-  "nonce" varchar(255)
+  "nonce" varchar(255),
+  "session_id" uuid
 );
 
 CREATE TABLE "access_tokens" (
@@ -88,13 +96,18 @@ CREATE TABLE "refresh_tokens" (
   "expires_at" timestamp NOT NULL,
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
   "revoked_at" timestamp,
-  "session_id" uuid
+  "session_id" uuid,
+  "family_id" uuid NOT NULL
 );
 
 CREATE TABLE "sessions" (
   "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
   "user_id" uuid NOT NULL,
   "client_id" uuid,
+  "token_hash" varchar(255) UNIQUE NOT NULL,
+  "csrf_token" varchar(255) NOT NULL,
+  "amr" text[] NOT NULL DEFAULT '{}',
+  "acr" varchar(50),
   "user_agent" text,
   "ip_address" inet,
   "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
@@ -154,6 +167,295 @@ CREATE TABLE "id_tokens" (
   "session_id" uuid
 );
 
+CREATE TABLE "console_sessions" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "account_user_id" uuid NOT NULL,
+  "token_hash" varchar(255) UNIQUE NOT NULL,
+  "csrf_token" varchar(255) NOT NULL,
+  "user_agent" text,
+  "ip_address" inet,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "last_activity_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "expires_at" timestamp NOT NULL,
+  "revoked_at" timestamp
+);
+
+CREATE TABLE "account_user_invitations" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "account_id" uuid NOT NULL,
+  "email" varchar(255) NOT NULL,
+  "role" varchar(50) NOT NULL,
+  "invited_by_id" uuid,
+  "expires_at" timestamp NOT NULL,
+  "accepted_at" timestamp,
+  "revoked_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "tenant_domains" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "domain" varchar(253) NOT NULL,
+  "verification_token" varchar(255) NOT NULL,
+  "verified_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "tenant_slug_aliases" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "slug" varchar(255) UNIQUE NOT NULL,
+  "expires_at" timestamp NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "user_tokens" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid NOT NULL,
+  "purpose" varchar(50) NOT NULL,
+  "token_hash" varchar(255) UNIQUE NOT NULL,
+  "expires_at" timestamp NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "user_password_history" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid NOT NULL,
+  "password_hash" varchar(255) NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "login_throttles" (
+  "key" varchar(255) PRIMARY KEY,
+  "failures" int NOT NULL DEFAULT 0,
+  "last_failure_at" timestamp NOT NULL,
+  "locked_until" timestamp
+);
+
+CREATE TABLE "totp_credentials" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid UNIQUE,
+  "account_user_id" uuid UNIQUE,
+  "secret_sealed" text NOT NULL,
+  "last_used_step" bigint NOT NULL DEFAULT 0,
+  "confirmed_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "recovery_codes" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid,
+  "account_user_id" uuid,
+  "code_hash" varchar(255) UNIQUE NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "webauthn_credentials" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid NOT NULL,
+  "credential_id" text UNIQUE NOT NULL,
+  "public_key" bytea NOT NULL,
+  "sign_count" bigint NOT NULL DEFAULT 0,
+  "aaguid" uuid,
+  "transports" text[] NOT NULL DEFAULT '{}',
+  "attestation_format" varchar(20) NOT NULL,
+  "backup_eligible" boolean NOT NULL DEFAULT false,
+  "backup_state" boolean NOT NULL DEFAULT false,
+  "name" varchar(255),
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "last_used_at" timestamp
+);
+
+CREATE TABLE "webauthn_challenges" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "user_id" uuid,
+  "purpose" varchar(20) NOT NULL,
+  "challenge_hash" varchar(255) UNIQUE NOT NULL,
+  "email" varchar(255),
+  "expires_at" timestamp NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "email_login_challenges" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "email" varchar(255) NOT NULL,
+  "link_token_hash" varchar(255) UNIQUE NOT NULL,
+  "code_hash" varchar(255) NOT NULL,
+  "browser_token_hash" varchar(255) NOT NULL,
+  "client_id" uuid,
+  "return_to" text,
+  "attempts" int NOT NULL DEFAULT 0,
+  "expires_at" timestamp NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "identity_providers" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "type" varchar(20) NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "issuer" text NOT NULL,
+  "client_id" varchar(255) NOT NULL,
+  "client_secret_sealed" text,
+  "scopes" text[] NOT NULL,
+  "sso_url" text,
+  "certificates" text[],
+  "attribute_mapping" jsonb,
+  "allow_idp_initiated" boolean NOT NULL DEFAULT false,
+  "start_tls" boolean NOT NULL DEFAULT false,
+  "bind_dn" text,
+  "bind_password_sealed" text,
+  "base_dn" text,
+  "user_filter" text,
+  "subject_attribute" varchar(255),
+  "sync_groups" boolean NOT NULL DEFAULT false,
+  "group_base_dn" text,
+  "group_filter" text,
+  "domains" text[],
+  "allow_signup" boolean NOT NULL DEFAULT true,
+  "enabled" boolean NOT NULL DEFAULT true,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "external_identities" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid NOT NULL,
+  "provider_id" uuid NOT NULL,
+  "subject" varchar(255) NOT NULL,
+  "email" varchar(255),
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "last_login_at" timestamp
+);
+
+CREATE TABLE "federation_states" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "provider_id" uuid NOT NULL,
+  "state_hash" varchar(255) UNIQUE NOT NULL,
+  "nonce" varchar(255) NOT NULL,
+  "code_verifier" varchar(255) NOT NULL,
+  "client_id" uuid,
+  "return_to" text,
+  "expires_at" timestamp NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "tenant_signing_keys" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "algorithm" varchar(20) NOT NULL,
+  "private_key_sealed" text NOT NULL,
+  "certificate" text NOT NULL,
+  "active" boolean NOT NULL DEFAULT true,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "expires_at" timestamp NOT NULL
+);
+
+CREATE TABLE "saml_service_providers" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "entity_id" varchar(1024) NOT NULL,
+  "acs_urls" text[] NOT NULL,
+  "slo_url" text,
+  "slo_binding" varchar(20),
+  "certificate" text,
+  "name_id_format" varchar(255) NOT NULL,
+  "attribute_mapping" jsonb NOT NULL,
+  "sign_response" boolean NOT NULL DEFAULT false,
+  "enabled" boolean NOT NULL DEFAULT true,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "saml_session_participants" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "session_id" uuid NOT NULL,
+  "service_provider_id" uuid NOT NULL,
+  "name_id" varchar(255) NOT NULL,
+  "name_id_format" varchar(255) NOT NULL,
+  "session_index" varchar(255) UNIQUE NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "consumed_assertions" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "provider_id" uuid NOT NULL,
+  "assertion_id" varchar(255) NOT NULL,
+  "expires_at" timestamp NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "groups" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "description" text,
+  "provider_id" uuid,
+  "external_id" varchar(255),
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "group_members" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "group_id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "scim_tokens" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "tenant_id" uuid NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "token_hash" varchar(255) UNIQUE NOT NULL,
+  "last_used_at" timestamp,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "client_permissions" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "client_id" uuid NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "description" text,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "client_roles" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "client_id" uuid NOT NULL,
+  "name" varchar(255) NOT NULL,
+  "description" text,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP),
+  "updated_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "client_role_permissions" (
+  "role_id" uuid NOT NULL,
+  "permission_id" uuid NOT NULL,
+  PRIMARY KEY ("role_id", "permission_id")
+);
+
+CREATE TABLE "user_client_roles" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "user_id" uuid NOT NULL,
+  "role_id" uuid NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE "group_client_roles" (
+  "id" uuid PRIMARY KEY DEFAULT (gen_random_uuid()),
+  "group_id" uuid NOT NULL,
+  "role_id" uuid NOT NULL,
+  "created_at" timestamp DEFAULT (CURRENT_TIMESTAMP)
+);
+
 CREATE INDEX ON "accounts" ("email");
 
 CREATE INDEX ON "tenants" ("account_id");
@@ -170,6 +472,8 @@ CREATE UNIQUE INDEX ON "users" ("tenant_id", "email");
 
 CREATE INDEX ON "users" ("tenant_id");
 
+CREATE INDEX ON "users" ("external_id");
+
 CREATE UNIQUE INDEX ON "authorization_codes" ("code");
 
 CREATE INDEX ON "authorization_codes" ("expires_at");
@@ -178,6 +482,8 @@ CREATE INDEX ON "authorization_codes" ("client_id");
 
 CREATE INDEX ON "authorization_codes" ("user_id");
 
+CREATE INDEX ON "authorization_codes" ("session_id");
+
 CREATE UNIQUE INDEX ON "access_tokens" ("token_hash");
 
 CREATE INDEX ON "access_tokens" ("expires_at");
@@ -196,6 +502,8 @@ CREATE INDEX ON "refresh_tokens" ("client_id");
 
 CREATE INDEX ON "refresh_tokens" ("session_id");
 
+CREATE INDEX ON "refresh_tokens" ("family_id");
+
 CREATE INDEX ON "sessions" ("user_id");
 
 CREATE INDEX ON "sessions" ("expires_at");
@@ -230,16 +538,116 @@ CREATE INDEX ON "id_tokens" ("session_id");
 
 CREATE INDEX ON "id_tokens" ("expires_at");
 
-COMMENT ON COLUMN "accounts"."status" IS 'active, suspended, deleted';
+CREATE UNIQUE INDEX ON "console_sessions" ("token_hash");
+
+CREATE INDEX ON "console_sessions" ("account_user_id");
+
+CREATE INDEX ON "console_sessions" ("expires_at");
+
+CREATE INDEX ON "account_user_invitations" ("account_id");
+
+CREATE INDEX ON "account_user_invitations" ("account_id", "email");
+
+CREATE UNIQUE INDEX ON "tenant_domains" ("domain") WHERE "verified_at" IS NOT NULL;
+
+CREATE UNIQUE INDEX ON "tenant_domains" ("tenant_id", "domain");
+
+CREATE INDEX ON "tenant_domains" ("tenant_id");
+
+CREATE UNIQUE INDEX ON "tenant_slug_aliases" ("slug");
+
+CREATE INDEX ON "tenant_slug_aliases" ("tenant_id");
+
+CREATE INDEX ON "tenant_slug_aliases" ("expires_at");
+
+CREATE INDEX ON "user_tokens" ("user_id");
+
+CREATE INDEX ON "user_tokens" ("expires_at");
+
+CREATE INDEX ON "user_password_history" ("user_id");
+
+CREATE INDEX ON "login_throttles" ("last_failure_at");
+
+CREATE INDEX ON "recovery_codes" ("user_id");
+
+CREATE INDEX ON "recovery_codes" ("account_user_id");
+
+CREATE INDEX ON "webauthn_credentials" ("user_id");
+
+CREATE INDEX ON "webauthn_challenges" ("tenant_id");
+
+CREATE INDEX ON "webauthn_challenges" ("expires_at");
+
+CREATE INDEX ON "email_login_challenges" ("tenant_id");
+
+CREATE INDEX ON "email_login_challenges" ("browser_token_hash");
+
+CREATE INDEX ON "email_login_challenges" ("expires_at");
+
+CREATE INDEX ON "identity_providers" ("tenant_id");
+
+CREATE INDEX ON "external_identities" ("user_id");
+
+CREATE UNIQUE INDEX ON "external_identities" ("provider_id", "subject");
+
+CREATE INDEX ON "federation_states" ("tenant_id");
+
+CREATE INDEX ON "federation_states" ("provider_id");
+
+CREATE INDEX ON "federation_states" ("expires_at");
+
+CREATE INDEX ON "tenant_signing_keys" ("tenant_id");
+
+CREATE UNIQUE INDEX ON "saml_service_providers" ("tenant_id", "entity_id");
+
+CREATE INDEX ON "saml_session_participants" ("session_id");
+
+CREATE INDEX ON "saml_session_participants" ("service_provider_id");
+
+CREATE UNIQUE INDEX ON "consumed_assertions" ("provider_id", "assertion_id");
+
+CREATE INDEX ON "consumed_assertions" ("expires_at");
+
+CREATE UNIQUE INDEX ON "groups" ("tenant_id", "name");
+
+CREATE INDEX ON "groups" ("provider_id");
+
+CREATE UNIQUE INDEX ON "group_members" ("group_id", "user_id");
+
+CREATE INDEX ON "group_members" ("user_id");
+
+CREATE INDEX ON "scim_tokens" ("tenant_id");
+
+CREATE UNIQUE INDEX ON "client_permissions" ("client_id", "name");
+
+CREATE UNIQUE INDEX ON "client_roles" ("client_id", "name");
+
+CREATE INDEX ON "client_role_permissions" ("permission_id");
+
+CREATE UNIQUE INDEX ON "user_client_roles" ("user_id", "role_id");
+
+CREATE INDEX ON "user_client_roles" ("role_id");
+
+CREATE UNIQUE INDEX ON "group_client_roles" ("group_id", "role_id");
+
+CREATE INDEX ON "group_client_roles" ("role_id");
+
+COMMENT ON COLUMN "accounts"."status" IS 'pending_verification, active, suspended, deleted';
+
+COMMENT ON COLUMN "accounts"."deleted_at" IS 'deletion requested, hard deleted after the cooling-off period';
 
 COMMENT ON COLUMN "tenants"."slug" IS 'tenant identifier in URLs';
 
 COMMENT ON COLUMN "tenants"."settings" IS 'tenant-specific configuration';
 
+COMMENT ON COLUMN "tenants"."deleted_at" IS 'hard deleted after the grace period';
+
 COMMENT ON COLUMN "clients"."client_id" IS 'OAuth client_id';
 
 COMMENT ON COLUMN "clients"."client_secret_hash" IS 'hashed secret';
 
+COMMENT ON COLUMN "clients"."previous_secret_hash" IS 'rotated secret, valid until previous_secret_expires_at';
+
 COMMENT ON COLUMN "clients"."redirect_uris" IS 'allowed redirect URIs';
 
 COMMENT ON COLUMN "clients"."grant_types" IS 'authorization_code, refresh_token, etc.';
@@ -258,10 +666,14 @@ COMMENT ON COLUMN "authorization_codes"."used_at" IS 'prevent code reuse';
 
 COMMENT ON COLUMN "authorization_codes"."nonce" IS 'OpenID Connect nonce';
 
+COMMENT ON COLUMN "authorization_codes"."session_id" IS 'sign-in the tokens are issued from';
+
 COMMENT ON COLUMN "access_tokens"."token_hash" IS 'hash of actual token';
 
 COMMENT ON COLUMN "access_tokens"."user_id" IS 'null for client_credentials';
 
+COMMENT ON COLUMN "refresh_tokens"."family_id" IS 'shared by the tokens rotated from one code, reusing a rotated out token revokes them all';
+
 COMMENT ON COLUMN "account_users"."role" IS 'owner, admin, member';
 
 COMMENT ON COLUMN "audit_logs"."action" IS 'login, logout, token_issued, etc.';
@@ -284,6 +696,88 @@ COMMENT ON COLUMN "id_tokens"."at_hash" IS 'Access Token hash for validation';
 
 COMMENT ON COLUMN "id_tokens"."c_hash" IS 'Code hash for validation';
 
+COMMENT ON COLUMN "console_sessions"."token_hash" IS 'hash of the session cookie value';
+
+COMMENT ON COLUMN "sessions"."token_hash" IS 'hash of the session cookie value';
+
+COMMENT ON COLUMN "account_user_invitations"."role" IS 'owner, admin, member';
+
+COMMENT ON COLUMN "account_user_invitations"."accepted_at" IS 'invitations are single use';
+
+COMMENT ON COLUMN "tenant_domains"."domain" IS 'unique among verified domains, other claims are dropped when one tenant verifies it';
+
+COMMENT ON COLUMN "tenant_domains"."verification_token" IS 'published as a TXT record at _digipass-challenge.<domain>';
+
+COMMENT ON COLUMN "tenant_domains"."verified_at" IS 'only verified domains resolve to the tenant';
+
+COMMENT ON COLUMN "tenant_slug_aliases"."slug" IS 'previous slug, redirects to the tenant until expires_at';
+
+COMMENT ON COLUMN "user_tokens"."purpose" IS 'verify_email, reset_password, unlock_account';
+
+COMMENT ON COLUMN "user_tokens"."token_hash" IS 'hash of the emailed token, the token itself is never stored';
+
+COMMENT ON COLUMN "login_throttles"."key" IS 'user:{tenant}:{email}, console:{email}, ip:{scope}:{ip} or tenant:{tenant}';
+
+COMMENT ON COLUMN "sessions"."amr" IS 'Authentication methods used to sign in (RFC 8176), e.g. {pwd,otp}';
+
+COMMENT ON COLUMN "totp_credentials"."secret_sealed" IS 'AES-GCM sealed TOTP secret, exactly one of user_id and account_user_id is set';
+
+COMMENT ON COLUMN "totp_credentials"."last_used_step" IS 'Highest accepted time step, codes at or below it are rejected as replays';
+
+COMMENT ON COLUMN "webauthn_credentials"."credential_id" IS 'base64url encoded raw credential id';
+
+COMMENT ON COLUMN "webauthn_credentials"."public_key" IS 'COSE_Key of the credential';
+
+COMMENT ON COLUMN "webauthn_challenges"."purpose" IS 'register, signup or login';
+
+COMMENT ON COLUMN "webauthn_challenges"."user_id" IS 'User adding a passkey, or the id reserved for a passkey signup, so no foreign key';
+
+COMMENT ON COLUMN "email_login_challenges"."browser_token_hash" IS 'Hash of the cookie set on the browser that asked for the email, the link and code only work there';
+
+COMMENT ON COLUMN "email_login_challenges"."code_hash" IS 'Hash of the 6 digit code salted with the challenge id, attempts are capped';
+
+COMMENT ON COLUMN "identity_providers"."type" IS 'oidc, google, microsoft, saml or ldap; the presets only fill in the issuer';
+
+COMMENT ON COLUMN "identity_providers"."client_secret_sealed" IS 'Upstream client secret encrypted with the signing secret, it has to be sent to the provider';
+
+COMMENT ON COLUMN "identity_providers"."issuer" IS 'OpenID Connect issuer, the entity ID of a SAML identity provider, or the ldap:// or ldaps:// URL of a directory';
+
+COMMENT ON COLUMN "identity_providers"."certificates" IS 'PEM certificates trusted to sign SAML responses, several allow key rollover; for LDAP the CAs trusted to issue the server certificate';
+
+COMMENT ON COLUMN "identity_providers"."domains" IS 'Email domains whose users are sent straight to this provider from the sign-in page';
+
+COMMENT ON COLUMN "identity_providers"."bind_password_sealed" IS 'LDAP service account password encrypted with the signing secret, user passwords are never stored';
+
+COMMENT ON COLUMN "identity_providers"."user_filter" IS 'LDAP search filter, {username} is replaced by the escaped email typed at sign-in';
+
+COMMENT ON COLUMN "external_identities"."subject" IS 'sub claim of the upstream ID token, unique per provider';
+
+COMMENT ON COLUMN "federation_states"."state_hash" IS 'Hash of the state parameter, also kept in a cookie so the callback must come back to the same browser';
+
+COMMENT ON COLUMN "tenant_signing_keys"."private_key_sealed" IS 'PKCS#8 private key encrypted with the signing secret';
+
+COMMENT ON COLUMN "tenant_signing_keys"."certificate" IS 'Self-signed PEM certificate published in the SAML IdP metadata';
+
+COMMENT ON COLUMN "saml_service_providers"."acs_urls" IS 'HTTP-POST assertion consumer service URLs, the first is the default';
+
+COMMENT ON COLUMN "saml_service_providers"."attribute_mapping" IS 'SAML attribute name to user field released in assertions';
+
+COMMENT ON COLUMN "saml_session_participants"."session_index" IS 'SessionIndex sent in the AuthnStatement, single logout matches on it';
+
+COMMENT ON COLUMN "consumed_assertions"."assertion_id" IS 'ID of a SAML assertion already used to sign in, kept until it expires';
+
+COMMENT ON COLUMN "groups"."provider_id" IS 'Directory the group is synced from, its memberships are replaced at each sign-in; null for groups managed here';
+
+COMMENT ON COLUMN "users"."external_id" IS 'ID of the user in the SCIM provisioning client';
+
+COMMENT ON COLUMN "groups"."external_id" IS 'ID of the group in the SCIM provisioning client';
+
+COMMENT ON COLUMN "scim_tokens"."token_hash" IS 'sha256 of the bearer token, shown once at creation';
+
+COMMENT ON COLUMN "clients"."token_claims" IS 'authorization claims put into its tokens: roles, groups, permissions';
+
+COMMENT ON COLUMN "client_permissions"."name" IS 'e.g. invoices:write, granted through client_roles';
+
 ALTER TABLE "tenants" ADD FOREIGN KEY ("account_id") REFERENCES "accounts" ("id");
 
 ALTER TABLE "clients" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id");
@@ -294,6 +788,8 @@ ALTER TABLE "authorization_codes" ADD FOREIGN KEY ("client_id") REFERENCES "clie
 
 ALTER TABLE "authorization_codes" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
 
+ALTER TABLE "authorization_codes" ADD FOREIGN KEY ("session_id") REFERENCES "sessions" ("id");
+
 ALTER TABLE "access_tokens" ADD FOREIGN KEY ("client_id") REFERENCES "clients" ("id");
 
 ALTER TABLE "access_tokens" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
@@ -331,3 +827,81 @@ ALTER TABLE "id_tokens" ADD FOREIGN KEY ("client_id") REFERENCES "clients" ("id"
 ALTER TABLE "id_tokens" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
 
 ALTER TABLE "id_tokens" ADD FOREIGN KEY ("session_id") REFERENCES "sessions" ("id");
+
+ALTER TABLE "console_sessions" ADD FOREIGN KEY ("account_user_id") REFERENCES "account_users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "account_user_invitations" ADD FOREIGN KEY ("account_id") REFERENCES "accounts" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "account_user_invitations" ADD FOREIGN KEY ("invited_by_id") REFERENCES "account_users" ("id") ON DELETE SET NULL;
+
+ALTER TABLE "tenant_domains" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "tenant_slug_aliases" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "user_tokens" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "user_password_history" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "totp_credentials" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "totp_credentials" ADD FOREIGN KEY ("account_user_id") REFERENCES "account_users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "recovery_codes" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "recovery_codes" ADD FOREIGN KEY ("account_user_id") REFERENCES "account_users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "webauthn_credentials" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "webauthn_challenges" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "email_login_challenges" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "email_login_challenges" ADD FOREIGN KEY ("client_id") REFERENCES "clients" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "identity_providers" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "external_identities" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "external_identities" ADD FOREIGN KEY ("provider_id") REFERENCES "identity_providers" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "federation_states" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "federation_states" ADD FOREIGN KEY ("provider_id") REFERENCES "identity_providers" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "federation_states" ADD FOREIGN KEY ("client_id") REFERENCES "clients" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "tenant_signing_keys" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "saml_service_providers" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "saml_session_participants" ADD FOREIGN KEY ("session_id") REFERENCES "sessions" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "saml_session_participants" ADD FOREIGN KEY ("service_provider_id") REFERENCES "saml_service_providers" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "consumed_assertions" ADD FOREIGN KEY ("provider_id") REFERENCES "identity_providers" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "groups" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "groups" ADD FOREIGN KEY ("provider_id") REFERENCES "identity_providers" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "group_members" ADD FOREIGN KEY ("group_id") REFERENCES "groups" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "group_members" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "scim_tokens" ADD FOREIGN KEY ("tenant_id") REFERENCES "tenants" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "client_permissions" ADD FOREIGN KEY ("client_id") REFERENCES "clients" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "client_roles" ADD FOREIGN KEY ("client_id") REFERENCES "clients" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "client_role_permissions" ADD FOREIGN KEY ("role_id") REFERENCES "client_roles" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "client_role_permissions" ADD FOREIGN KEY ("permission_id") REFERENCES "client_permissions" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "user_client_roles" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "user_client_roles" ADD FOREIGN KEY ("role_id") REFERENCES "client_roles" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "group_client_roles" ADD FOREIGN KEY ("group_id") REFERENCES "groups" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "group_client_roles" ADD FOREIGN KEY ("role_id") REFERENCES "client_roles" ("id") ON DELETE CASCADE;
@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/mailer"
 	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/permissions"
 	"DigiPassAuthenticationApi/services"
 	"DigiPassAuthenticationApi/utils"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v5"
 	"gorm.io/gorm"
 )
@@ -19,8 +25,9 @@ func NewAccountHandler() *AccountHandler {
 
 func (h *AccountHandler) Create(c *echo.Context) error {
 	var req struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -34,7 +41,7 @@ func (h *AccountHandler) Create(c *echo.Context) error {
 	err := utils.WithTransaction(getDBFromContext(c), func(tx *gorm.DB) error {
 		accountService := services.NewAccountService(tx)
 		var err error
-		account, err = accountService.CreateAccount(req.Name, req.Email)
+		account, err = accountService.CreateAccount(req.Name, req.Email, req.Password)
 		if err != nil {
 			return err
 		}
@@ -47,10 +54,199 @@ func (h *AccountHandler) Create(c *echo.Context) error {
 				"error": "Account already exist with given email",
 			})
 		}
+		if errors.Is(err, services.ErrWeakPassword) || errors.Is(err, services.ErrAccountInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
 		})
 	}
 
+	// The account exists by now, a failed email is retried through /verify/resend
+	if err := sendAccountVerification(c, account); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Account created but the verification email could not be sent, request a new one: " + err.Error(),
+		})
+	}
+
 	return c.JSON(http.StatusCreated, account)
 }
+
+func (h *AccountHandler) Verify(c *echo.Context) error {
+	accountService := services.NewAccountService(getDBFromContext(c))
+	account, err := accountService.VerifyAccount(c.QueryParam("token"))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+// ResendVerification mails a new verification link to a pending signup. It answers
+// the same whether or not the email belongs to one.
+func (h *AccountHandler) ResendVerification(c *echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	accountService := services.NewAccountService(getDBFromContext(c))
+	account, err := accountService.PendingAccountByEmail(req.Email)
+	if errors.Is(err, services.ErrRecordNotFound) {
+		return c.NoContent(http.StatusAccepted)
+	}
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	if err := sendAccountVerification(c, account); err != nil {
+		return accountError(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func sendAccountVerification(c *echo.Context, account *models.Account) error {
+	accountService := services.NewAccountService(getDBFromContext(c))
+	token, err := accountService.CreateVerificationToken(account)
+	if err != nil {
+		return err
+	}
+
+	link := publicURL("/v1/account/verify?token=" + url.QueryEscape(token))
+
+	return getMailerFromContext(c).Send(mailer.Message{
+		To:      account.Email,
+		Subject: "Verify your DigiPass account",
+		Body: fmt.Sprintf("Confirm you own this email address to finish creating %s:\n\n%s\n\nThis link expires in %s.",
+			account.Name, link, services.AccountVerificationWindow()),
+	})
+}
+
+func (h *AccountHandler) Get(c *echo.Context) error {
+	accountService := services.NewAccountService(getDBFromContext(c))
+	account, err := accountService.GetAccount(getAccountUserFromContext(c).AccountID)
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+func (h *AccountHandler) Update(c *echo.Context) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	accountService := services.NewAccountService(getDBFromContext(c))
+	account, err := accountService.UpdateAccount(getAccountUserFromContext(c).AccountID, req.Name)
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+func (h *AccountHandler) Suspend(c *echo.Context) error {
+	accountService := services.NewAccountService(getDBFromContext(c))
+	account, err := accountService.SuspendAccount(getAccountUserFromContext(c).AccountID)
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+// Reactivate takes the credentials of an owner or admin, suspended and deleted accounts
+// cannot sign in to the console
+func (h *AccountHandler) Reactivate(c *echo.Context) error {
+	var req struct {
+		Email     string     `json:"email"`
+		Password  string     `json:"password"`
+		Code      string     `json:"code"`
+		AccountID *uuid.UUID `json:"account_id"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	sessionService := services.NewConsoleSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	account, err := sessionService.ReactivateAccount(req.Email, req.Password, req.Code, req.AccountID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		if isLockout(err) {
+			notifyConsoleLockout(c, req.Email, err)
+			return lockoutResponse(c, err)
+		}
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid email or password",
+			})
+		case errors.Is(err, services.ErrMFAInvalidCode):
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrInsufficientRole):
+			return middleware.Forbidden(c, permissions.AccountUpdate)
+		case errors.Is(err, services.ErrAccountSelectionRequired):
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+func (h *AccountHandler) RequestDeletion(c *echo.Context) error {
+	accountService := services.NewAccountService(getDBFromContext(c))
+	account, err := accountService.RequestDeletion(getAccountUserFromContext(c).AccountID)
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]any{
+		"account":  account,
+		"purge_at": account.DeletedAt.Add(services.AccountDeletionCoolingOff),
+	})
+}
+
+func accountError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account not found",
+		})
+	case errors.Is(err, services.ErrVerificationInvalid),
+		errors.Is(err, services.ErrAccountInvalid):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidStatusTransition),
+		errors.Is(err, services.ErrAccountNotVerified):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -1,7 +1,199 @@
 package handlers
 
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"DigiPassAuthenticationApi/services"
+	"DigiPassAuthenticationApi/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+	"gorm.io/gorm"
+)
+
 type AccountUsersHandler struct{}
 
 func NewAccountUsersHandler() *AccountUsersHandler {
 	return &AccountUsersHandler{}
 }
+
+func (h *AccountUsersHandler) List(c *echo.Context) error {
+	accountUser := getAccountUserFromContext(c)
+
+	pageNumber, _ := strconv.Atoi(c.QueryParam("page"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	page := services.NewPage(pageNumber, pageSize)
+
+	accountUsersService := services.NewAccountUsersService(getDBFromContext(c))
+	accountUsers, total, err := accountUsersService.ListAccountUsers(accountUser.AccountID, page)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"account_users": accountUsers,
+		"page":          page.Number,
+		"page_size":     page.Size,
+		"total":         total,
+	})
+}
+
+func (h *AccountUsersHandler) Invite(c *echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	inviter := getAccountUserFromContext(c)
+	accountUsersService := services.NewAccountUsersService(getDBFromContext(c))
+	invitation, token, err := accountUsersService.Invite(inviter, req.Email, req.Role)
+	if err != nil {
+		return accountUsersError(c, err)
+	}
+
+	// The token only goes to the invited email, the inviter never sees it
+	link := publicURL("/v1/accountusers/invitations/accept?token=" + url.QueryEscape(token))
+
+	err = getMailerFromContext(c).Send(mailer.Message{
+		To:      invitation.Email,
+		Subject: "You have been invited to DigiPass",
+		Body: fmt.Sprintf("%s invited you to their DigiPass account as %s. Accept the invitation and choose a password:\n\n%s\n\nThis link expires in %d days.",
+			inviter.Email, invitation.Role, link, int(services.InvitationLifetime.Hours()/24)),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Invitation created but the email could not be sent, invite again: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, invitation)
+}
+
+func (h *AccountUsersHandler) AcceptInvitation(c *echo.Context) error {
+	var req struct {
+		Token    string `json:"token"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	var accountUser *models.AccountUser
+
+	err := utils.WithTransaction(getDBFromContext(c), func(tx *gorm.DB) error {
+		accountUsersService := services.NewAccountUsersService(tx)
+		var err error
+		accountUser, err = accountUsersService.AcceptInvitation(req.Token, req.Email, req.Password)
+		return err
+	})
+
+	if err != nil {
+		return accountUsersError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, accountUser)
+}
+
+func (h *AccountUsersHandler) ChangeRole(c *echo.Context) error {
+	targetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid account user id",
+		})
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	var accountUser *models.AccountUser
+
+	err = utils.WithTransaction(getDBFromContext(c), func(tx *gorm.DB) error {
+		accountUsersService := services.NewAccountUsersService(tx)
+		var err error
+		accountUser, err = accountUsersService.ChangeRole(getAccountUserFromContext(c), targetID, req.Role)
+		return err
+	})
+
+	if err != nil {
+		return accountUsersError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, accountUser)
+}
+
+func (h *AccountUsersHandler) Remove(c *echo.Context) error {
+	targetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid account user id",
+		})
+	}
+
+	err = utils.WithTransaction(getDBFromContext(c), func(tx *gorm.DB) error {
+		accountUsersService := services.NewAccountUsersService(tx)
+		return accountUsersService.RemoveAccountUser(getAccountUserFromContext(c), targetID)
+	})
+
+	if err != nil {
+		return accountUsersError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func accountUsersError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Account user not found",
+		})
+	case errors.Is(err, services.ErrInsufficientRole):
+		return middleware.Forbidden(c, permissions.AccountTransferOwnership)
+	case errors.Is(err, services.ErrInvitationEmailMismatch):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrLastOwner),
+		errors.Is(err, services.ErrAccountUserAlreadyExists):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvitationInvalid),
+		errors.Is(err, services.ErrWeakPassword),
+		errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrInvalidRole):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestInvitationIsEmailed(t *testing.T) {
+	t.Setenv("TOKEN_SIGNING_SECRET", "test-secret")
+	t.Setenv("PUBLIC_BASE_URL", "https://console.example.com")
+
+	db, mock := mockDB(t)
+	mail := mailer.NewMemoryMailer()
+	h := NewAccountUsersHandler()
+	inviter := &models.AccountUser{ID: uuid.New(), AccountID: uuid.New(), Email: "owner@example.com", Role: "owner"}
+	invitationID := uuid.New()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "account_users" WHERE account_id = \$1 AND LOWER\(email\) = \$2`).
+		WithArgs(inviter.AccountID, "ada@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`UPDATE "account_user_invitations" SET "revoked_at"=\$1 WHERE account_id = \$2 AND LOWER\(email\) = \$3 AND accepted_at IS NULL AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), inviter.AccountID, "ada@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "account_user_invitations"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(invitationID))
+
+	c, rec := newContext(db, mail, http.MethodPost, "/v1/accountusers/invitations", strings.NewReader(`{"email":"Ada@Example.com","role":"member"}`))
+	c.Set("accountUser", inviter)
+
+	if err := h.Invite(c); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("Invite = %d, %v", rec.Code, err)
+	}
+
+	// The inviter gets the invitation, the token only goes out by email
+	var response map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response["id"] != invitationID.String() {
+		t.Fatalf("Invite = %s", rec.Body)
+	}
+	if _, ok := response["invitation_token"]; ok {
+		t.Fatalf("Invite returned the token: %s", rec.Body)
+	}
+
+	link, err := url.Parse(linkIn(t, mail, "ada@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+	if link.Scheme+"://"+link.Host+link.Path != "https://console.example.com/v1/accountusers/invitations/accept" || token == "" {
+		t.Fatalf("link = %s", link)
+	}
+	if strings.Contains(rec.Body.String(), token) {
+		t.Fatalf("Invite response contains the emailed token")
+	}
+
+	// Someone else holding the link cannot accept it for their own address
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "account_user_invitations" WHERE id = \$1 .* FOR UPDATE`).
+		WithArgs(invitationID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "email", "role", "expires_at"}).
+			AddRow(invitationID, inviter.AccountID, "ada@example.com", "member", time.Now().Add(time.Hour)))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(map[string]string{"token": token, "email": "mallory@example.com", "password": "a long new passphrase"})
+	c, rec = newContext(db, mail, http.MethodPost, "/v1/accountusers/invitations/accept", strings.NewReader(string(body)))
+	if err := h.AcceptInvitation(c); err != nil || rec.Code != http.StatusForbidden {
+		t.Fatalf("AcceptInvitation for another email = %d %s, %v", rec.Code, rec.Body, err)
+	}
+}
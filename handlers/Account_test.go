@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+func TestAccountVerificationLink(t *testing.T) {
+	t.Setenv("TOKEN_SIGNING_SECRET", "test-secret")
+	t.Setenv("PUBLIC_BASE_URL", "https://console.example.com/")
+
+	db, mock := mockDB(t)
+	mail := mailer.NewMemoryMailer()
+	h := NewAccountHandler()
+	accountID := uuid.New()
+
+	accountRows := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "email", "status", "created_at"}).
+			AddRow(accountID, "Ada", "ada@example.com", status, time.Now().Add(-time.Hour))
+	}
+
+	// A pending signup asks for a new email
+	mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE email = \$1`).
+		WithArgs("ada@example.com", 1).
+		WillReturnRows(accountRows("pending_verification"))
+
+	c, rec := newContext(db, mail, http.MethodPost, "/v1/account/verify/resend", strings.NewReader(`{"email":" ada@example.com "}`))
+	if err := h.ResendVerification(c); err != nil || rec.Code != http.StatusAccepted {
+		t.Fatalf("ResendVerification = %d, %v", rec.Code, err)
+	}
+
+	link, err := url.Parse(linkIn(t, mail, "ada@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Scheme+"://"+link.Host+link.Path != "https://console.example.com/v1/account/verify" {
+		t.Fatalf("link = %s", link)
+	}
+
+	// Following the link activates the account
+	mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+		WithArgs(accountID, 1).
+		WillReturnRows(accountRows("pending_verification"))
+	mock.ExpectExec(`UPDATE "accounts" SET "status"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+		WithArgs("active", sqlmock.AnyArg(), accountID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	c, rec = newContext(db, mail, http.MethodGet, link.RequestURI(), nil)
+	if err := h.Verify(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Verify = %d, %v", rec.Code, err)
+	}
+
+	var account struct {
+		ID     uuid.UUID `json:"id"`
+		Status string    `json:"status"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &account); err != nil || account.ID != accountID || account.Status != "active" {
+		t.Fatalf("Verify = %s", rec.Body)
+	}
+
+	// The link of another flow, or one that was edited, is rejected before the database is asked
+	for _, token := range []string{"", "not-a-token", link.Query().Get("token") + "x"} {
+		c, rec = newContext(db, mail, http.MethodGet, "/v1/account/verify?token="+url.QueryEscape(token), nil)
+		if err := h.Verify(c); err != nil || rec.Code != http.StatusBadRequest {
+			t.Fatalf("Verify(%q) = %d, %v", token, rec.Code, err)
+		}
+	}
+}
+
+func TestResendVerificationOnlyForPendingSignups(t *testing.T) {
+	t.Setenv("TOKEN_SIGNING_SECRET", "test-secret")
+
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+	}{
+		{
+			name: "unknown email",
+			rows: sqlmock.NewRows([]string{"id"}),
+		},
+		{
+			name: "already verified",
+			rows: sqlmock.NewRows([]string{"id", "email", "status", "created_at"}).
+				AddRow(uuid.New(), "ada@example.com", "active", time.Now().Add(-time.Hour)),
+		},
+		{
+			name: "signup past the verification window",
+			rows: sqlmock.NewRows([]string{"id", "email", "status", "created_at"}).
+				AddRow(uuid.New(), "ada@example.com", "pending_verification", time.Now().Add(-72*time.Hour)),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			mail := mailer.NewMemoryMailer()
+
+			mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE email = \$1`).
+				WithArgs("ada@example.com", 1).
+				WillReturnRows(test.rows)
+
+			// The answer is the same either way so it does not reveal which emails have an account
+			c, rec := newContext(db, mail, http.MethodPost, "/v1/account/verify/resend", strings.NewReader(`{"email":"ada@example.com"}`))
+			if err := NewAccountHandler().ResendVerification(c); err != nil || rec.Code != http.StatusAccepted {
+				t.Fatalf("ResendVerification = %d, %v", rec.Code, err)
+			}
+			if sent := mail.Messages(); len(sent) != 0 {
+				t.Fatalf("sent %+v", sent)
+			}
+		})
+	}
+}
+
+func TestAccountItem(t *testing.T) {
+	accountID := uuid.New()
+	accountUser := &models.AccountUser{ID: uuid.New(), AccountID: accountID, Role: "owner"}
+	recently := time.Now().Add(-time.Hour)
+
+	expectAccount := func(mock sqlmock.Sqlmock, status string, deletedAt *time.Time) {
+		mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+			WithArgs(accountID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "status", "deleted_at"}).
+				AddRow(accountID, "Acme", status, deletedAt))
+	}
+
+	h := NewAccountHandler()
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		handler func(c *echo.Context) error
+		expect  func(mock sqlmock.Sqlmock)
+		code    int
+		status  string
+	}{
+		{
+			name:    "get",
+			method:  http.MethodGet,
+			handler: h.Get,
+			expect:  func(mock sqlmock.Sqlmock) { expectAccount(mock, "active", nil) },
+			code:    http.StatusOK,
+			status:  "active",
+		},
+		{
+			name:    "get missing",
+			method:  http.MethodGet,
+			handler: h.Get,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).WithArgs(accountID, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			code: http.StatusNotFound,
+		},
+		{
+			name:    "rename",
+			method:  http.MethodPatch,
+			body:    `{"name":"  Acme Inc  "}`,
+			handler: h.Update,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, "active", nil)
+				mock.ExpectExec(`UPDATE "accounts" SET "name"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+					WithArgs("Acme Inc", sqlmock.AnyArg(), accountID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			code:   http.StatusOK,
+			status: "active",
+		},
+		{
+			name:    "rename to blank",
+			method:  http.MethodPatch,
+			body:    `{"name":"   "}`,
+			handler: h.Update,
+			expect:  func(mock sqlmock.Sqlmock) {},
+			code:    http.StatusBadRequest,
+		},
+		{
+			name:    "rename failing",
+			method:  http.MethodPatch,
+			body:    `{"name":"Acme Inc"}`,
+			handler: h.Update,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, "active", nil)
+				mock.ExpectExec(`UPDATE "accounts"`).WillReturnError(errors.New("connection reset"))
+			},
+			code: http.StatusInternalServerError,
+		},
+		{
+			name:    "suspend",
+			method:  http.MethodPost,
+			handler: h.Suspend,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, "active", nil)
+				mock.ExpectExec(`UPDATE "accounts" SET "status"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+					WithArgs("suspended", sqlmock.AnyArg(), accountID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			code:   http.StatusOK,
+			status: "suspended",
+		},
+		{
+			name:    "suspend twice",
+			method:  http.MethodPost,
+			handler: h.Suspend,
+			expect:  func(mock sqlmock.Sqlmock) { expectAccount(mock, "suspended", nil) },
+			code:    http.StatusConflict,
+		},
+		{
+			name:    "request deletion",
+			method:  http.MethodDelete,
+			handler: h.RequestDeletion,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, "active", nil)
+				mock.ExpectExec(`UPDATE "accounts" SET "deleted_at"=\$1,"status"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+					WithArgs(sqlmock.AnyArg(), "deleted", sqlmock.AnyArg(), accountID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAccount(mock, "deleted", &recently)
+			},
+			code:   http.StatusAccepted,
+			status: "deleted",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			test.expect(mock)
+
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+			}
+			c, rec := newContext(db, nil, test.method, "/v1/account", body)
+			c.Set("accountUser", accountUser)
+
+			if err := test.handler(c); err != nil || rec.Code != test.code {
+				t.Fatalf("status %d %s, %v, want %d", rec.Code, rec.Body, err, test.code)
+			}
+
+			// Deletion wraps the account together with its purge date
+			var response struct {
+				models.Account
+				Wrapped *models.Account `json:"account"`
+			}
+			if test.status != "" {
+				err := json.Unmarshal(rec.Body.Bytes(), &response)
+				if response.Wrapped != nil {
+					response.Account = *response.Wrapped
+				}
+				if err != nil || response.Status != test.status {
+					t.Fatalf("account %s, want status %s", rec.Body, test.status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// AuthorizationHandler manages the permissions and roles of a client and which
+// users and groups hold them. Clients opt into receiving them in tokens with
+// their token_claims setting.
+type AuthorizationHandler struct{}
+
+func NewAuthorizationHandler() *AuthorizationHandler {
+	return &AuthorizationHandler{}
+}
+
+func (h *AuthorizationHandler) ListPermissions(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	permissions, err := authorizationService.ListPermissions(tenant.ID, clientID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, permissions)
+}
+
+func (h *AuthorizationHandler) CreatePermission(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	var req services.PermissionInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	permission, err := authorizationService.CreatePermission(tenant.ID, clientID, req)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, permission)
+}
+
+func (h *AuthorizationHandler) DeletePermission(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	permissionID, err := uuid.Parse(c.Param("permissionId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid permission id",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	if err := authorizationService.DeletePermission(tenant.ID, clientID, permissionID); err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AuthorizationHandler) ListRoles(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	roles, err := authorizationService.ListRoles(tenant.ID, clientID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (h *AuthorizationHandler) CreateRole(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	var req services.RoleInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	role, err := authorizationService.CreateRole(tenant.ID, clientID, req)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, role)
+}
+
+func (h *AuthorizationHandler) UpdateRole(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	roleID, err := uuid.Parse(c.Param("roleId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid role id",
+		})
+	}
+
+	var req services.RoleInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	role, err := authorizationService.UpdateRole(tenant.ID, clientID, roleID, req)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, role)
+}
+
+func (h *AuthorizationHandler) DeleteRole(c *echo.Context) error {
+	tenant, clientID, err := getManagedClient(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	roleID, err := uuid.Parse(c.Param("roleId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid role id",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	if err := authorizationService.DeleteRole(tenant.ID, clientID, roleID); err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AuthorizationHandler) UserRoles(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user id",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	roles, err := authorizationService.UserRoles(tenant.ID, userID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (h *AuthorizationHandler) SetUserRoles(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user id",
+		})
+	}
+
+	var req struct {
+		RoleIDs []uuid.UUID `json:"role_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	if err := authorizationService.SetUserRoles(tenant.ID, userID, req.RoleIDs); err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// UserClaims previews what a token issued to the client would carry for the user,
+// before and after the size limits
+func (h *AuthorizationHandler) UserClaims(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user id",
+		})
+	}
+
+	clientID, err := uuid.Parse(c.QueryParam("client"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid client id",
+		})
+	}
+
+	db := getDBFromContext(c)
+	client, err := services.NewClientService(db).GetClient(tenant.ID, clientID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+	if _, err := services.NewUsersService(db).GetUser(tenant.ID, userID); err != nil {
+		return authorizationError(c, err)
+	}
+
+	authorizationService := services.NewAuthorizationService(db)
+	claims, err := authorizationService.Claims(tenant.ID, client, userID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	tokenClaims, err := authorizationService.TokenClaims(tenant.ID, publicURL("/t/"+tenant.Slug), client, userID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"claims":       claims,
+		"token_claims": tokenClaims,
+	})
+}
+
+func (h *AuthorizationHandler) GroupRoles(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	groupID, err := uuid.Parse(c.Param("groupId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid group id",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	roles, err := authorizationService.GroupRoles(tenant.ID, groupID)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (h *AuthorizationHandler) SetGroupRoles(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return authorizationError(c, err)
+	}
+
+	groupID, err := uuid.Parse(c.Param("groupId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid group id",
+		})
+	}
+
+	var req struct {
+		RoleIDs []uuid.UUID `json:"role_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	authorizationService := services.NewAuthorizationService(getDBFromContext(c))
+	if err := authorizationService.SetGroupRoles(tenant.ID, groupID, req.RoleIDs); err != nil {
+		return authorizationError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func authorizationError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrClientRoleInvalid),
+		errors.Is(err, services.ErrClientPermissionInvalid):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrClientRoleNameTaken),
+		errors.Is(err, services.ErrClientPermissionNameTaken):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+type ClientHandler struct{}
+
+func NewClientHandler() *ClientHandler {
+	return &ClientHandler{}
+}
+
+func (h *ClientHandler) List(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	clients, err := clientService.ListClients(tenant.ID)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, clients)
+}
+
+func (h *ClientHandler) Get(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid client id",
+		})
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	client, err := clientService.GetClient(tenant.ID, clientID)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, client)
+}
+
+func (h *ClientHandler) Create(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	var req services.ClientInput
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	client, secret, err := clientService.CreateClient(tenant, req)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	response := map[string]any{
+		"client": client,
+	}
+	// Shown exactly once, only the hash is stored
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
+
+func (h *ClientHandler) Update(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid client id",
+		})
+	}
+
+	var req services.ClientInput
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	client, err := clientService.UpdateClient(tenant, clientID, req)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, client)
+}
+
+func (h *ClientHandler) Delete(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid client id",
+		})
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	if err := clientService.DeleteClient(tenant.ID, clientID); err != nil {
+		return clientError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ClientHandler) RotateSecret(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid client id",
+		})
+	}
+
+	var req struct {
+		GracePeriodSeconds *int64 `json:"grace_period_seconds"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	grace := services.DefaultSecretRotationGrace
+	if req.GracePeriodSeconds != nil {
+		grace = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	client, secret, err := clientService.RotateSecret(tenant.ID, clientID, grace)
+	if err != nil {
+		return clientError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"client":        client,
+		"client_secret": secret,
+	})
+}
+
+func clientError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrClientTypeImmutable),
+		errors.Is(err, services.ErrPublicClientSecret):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusBadRequest, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+type ConsoleHandler struct{}
+
+func NewConsoleHandler() *ConsoleHandler {
+	return &ConsoleHandler{}
+}
+
+func (h *ConsoleHandler) Login(c *echo.Context) error {
+	var req struct {
+		Email     string     `json:"email"`
+		Password  string     `json:"password"`
+		AccountID *uuid.UUID `json:"account_id"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	sessionService := services.NewConsoleSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.Login(req.Email, req.Password, req.AccountID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			return mfaChallengeResponse(c, mfaErr)
+		}
+		if isLockout(err) {
+			notifyConsoleLockout(c, req.Email, err)
+			return lockoutResponse(c, err)
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid email or password",
+			})
+		}
+		if errors.Is(err, services.ErrAccountNotVerified) ||
+			errors.Is(err, services.ErrAccountSuspended) ||
+			errors.Is(err, services.ErrAccountDeleted) {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, services.ErrAccountSelectionRequired) {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	setConsoleSessionCookie(c, session.ExpiresAt, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"account_user_id": session.AccountUserID,
+		"expires_at":      session.ExpiresAt,
+		"csrf_token":      session.CSRFToken,
+	})
+}
+
+func setConsoleSessionCookie(c *echo.Context, expiresAt time.Time, token string) {
+	c.SetCookie(&http.Cookie{
+		Name:     middleware.ConsoleSessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Unlock lifts a console lockout from the emailed link
+func (h *ConsoleHandler) Unlock(c *echo.Context) error {
+	sessionService := services.NewConsoleSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	if err := sessionService.Unlock(c.QueryParam("token"), c.Request().UserAgent(), c.RealIP()); err != nil {
+		if errors.Is(err, services.ErrTokenInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"status": "unlocked",
+	})
+}
+
+// notifyConsoleLockout emails the unlock link when the login that just failed locked a
+// known AccountUser. The login already failed, so a delivery problem is only logged.
+func notifyConsoleLockout(c *echo.Context, email string, err error) {
+	var lockedErr *services.LockedError
+	if !errors.As(err, &lockedErr) || !lockedErr.NewlyLocked {
+		return
+	}
+
+	var count int64
+	db := getDBFromContext(c)
+	err = db.Model(&models.AccountUser{}).Where("LOWER(email) = ?", strings.ToLower(email)).Count(&count).Error
+	if err != nil || count == 0 {
+		return
+	}
+
+	sessionService := services.NewConsoleSessionService(db, getThrottleFromContext(c))
+	token, err := sessionService.CreateUnlockToken(email)
+	if err == nil {
+		err = getMailerFromContext(c).Send(mailer.Message{
+			To:      email,
+			Subject: "Your DigiPass console login has been locked",
+			Body: fmt.Sprintf("We locked console sign-in for this email until %s after too many failed attempts.\n\n"+
+				"If this was you, unlock it now:\n\n%s\n\nIf it wasn't, consider changing your password.",
+				lockedErr.Until.UTC().Format(time.RFC1123), publicURL("/v1/console/unlock?token="+url.QueryEscape(token))),
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to send console unlock email: %v", err)
+	}
+}
+
+func (h *ConsoleHandler) Logout(c *echo.Context) error {
+	session := getConsoleSessionFromContext(c)
+
+	sessionService := services.NewConsoleSessionService(getDBFromContext(c))
+	if err := sessionService.Revoke(session.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     middleware.ConsoleSessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ConsoleHandler) Me(c *echo.Context) error {
+	session := getConsoleSessionFromContext(c)
+	accountUser := getAccountUserFromContext(c)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"account_user": accountUser,
+		"permissions":  permissions.ForRole(accountUser.Role),
+		"expires_at":   session.ExpiresAt,
+		"csrf_token":   session.CSRFToken,
+	})
+}
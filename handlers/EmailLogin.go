@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+)
+
+// emailLoginCookie binds an email sign-in to the browser that asked for it
+const emailLoginCookie = "digipass_email_login"
+
+// StartEmailLogin emails a magic link and a code. It answers 202 whether or not
+// the email has an account, the first sign-in creates one.
+func (h *UserHandler) StartEmailLogin(c *echo.Context) error {
+	var req struct {
+		Email    string `json:"email"`
+		ClientID string `json:"client_id"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenant := getTenantFromContext(c)
+	if !services.EmailLoginAllowed(tenant) {
+		return emailLoginError(c, services.ErrMFAMethodNotAllowed)
+	}
+
+	clientID, err := lookupSessionClient(c, req.ClientID)
+	if err != nil {
+		return emailLoginError(c, err)
+	}
+
+	browserToken, err := emailLoginBrowserToken(c)
+	if err != nil {
+		return emailLoginError(c, err)
+	}
+
+	emailLoginService := services.NewEmailLoginService(getDBFromContext(c), getThrottleFromContext(c))
+	request, err := emailLoginService.Start(tenant, req.Email, clientID, "", browserToken)
+	if err != nil {
+		return emailLoginError(c, err)
+	}
+
+	if err := sendEmailLogin(c, request); err != nil {
+		return emailLoginError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]any{
+		"status":     "sent",
+		"expires_at": request.ExpiresAt,
+	})
+}
+
+// VerifyEmailLoginCode exchanges the emailed code for a session, or an MFA challenge
+func (h *UserHandler) VerifyEmailLoginCode(c *echo.Context) error {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenant := getTenantFromContext(c)
+	emailLoginService := services.NewEmailLoginService(getDBFromContext(c), getThrottleFromContext(c))
+	user, challenge, err := emailLoginService.VerifyCode(tenant, req.Code, emailLoginCookieValue(c))
+	if err != nil {
+		return emailLoginError(c, err)
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.LoginWithEmail(tenant, user, challenge.ClientID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			return mfaChallengeResponse(c, mfaErr)
+		}
+		return emailLoginError(c, err)
+	}
+
+	setUserSessionCookie(c, session, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"user_id":    session.UserID,
+		"expires_at": session.ExpiresAt,
+		"csrf_token": session.CSRFToken,
+		"amr":        session.AMR,
+	})
+}
+
+func sendEmailLogin(c *echo.Context, request *services.EmailLoginRequest) error {
+	link := issuerLink(c, "/email-login/verify", request.LinkToken)
+	name := tenantDisplayName(getTenantFromContext(c))
+
+	return getMailerFromContext(c).Send(mailer.Message{
+		To:      request.Email,
+		Subject: request.Code + " is your " + name + " sign-in code",
+		Body: fmt.Sprintf("Sign in to %s with this link:\n\n%s\n\nor enter this code: %s\n\n"+
+			"Both expire in %s and only work in the browser where you asked for them. "+
+			"If you did not try to sign in, you can ignore this email.",
+			name, link, request.Code, services.EmailLoginLifetime),
+	})
+}
+
+// emailLoginBrowserToken returns the browser's email sign-in token, creating it on first use
+func emailLoginBrowserToken(c *echo.Context) (string, error) {
+	if token := emailLoginCookieValue(c); token != "" {
+		return token, nil
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	// Lax so the cookie comes along when the link is opened from a mail client
+	c.SetCookie(&http.Cookie{
+		Name:     emailLoginCookie,
+		Value:    token,
+		Path:     cookiePath(c),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return token, nil
+}
+
+func emailLoginCookieValue(c *echo.Context) string {
+	cookie, err := c.Cookie(emailLoginCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func emailLoginError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrEmailLoginInvalid),
+		errors.Is(err, services.ErrEmailLoginOtherBrowser):
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrMFAMethodNotAllowed):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return userError(c, err)
+}
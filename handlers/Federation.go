@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// federationCookie carries the state of an upstream sign-in, the callback must
+// come back to the browser that started it
+const federationCookie = "digipass_federation"
+
+// ListIdentities returns the upstream accounts linked to the signed in user
+func (h *UserHandler) ListIdentities(c *echo.Context) error {
+	federationService := services.NewFederationService(getDBFromContext(c))
+	identities, err := federationService.ListIdentities(getUserFromContext(c).ID)
+	if err != nil {
+		return federationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"identities": identities,
+	})
+}
+
+func (h *UserHandler) UnlinkIdentity(c *echo.Context) error {
+	identityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity ID",
+		})
+	}
+
+	federationService := services.NewFederationService(getDBFromContext(c))
+	if err := federationService.UnlinkIdentity(getUserFromContext(c), identityID); err != nil {
+		return federationError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// federationRedirectURI is the single callback registered with every provider of the tenant
+func federationRedirectURI(c *echo.Context) string {
+	return getIssuerFromContext(c) + "/federation/callback"
+}
+
+// federationEndpoints are the tenant's URLs as an OpenID Connect client and SAML service provider
+func federationEndpoints(c *echo.Context) services.FederationEndpoints {
+	issuer := getIssuerFromContext(c)
+	return services.FederationEndpoints{
+		RedirectURI:  federationRedirectURI(c),
+		SAMLEntityID: issuer + "/federation/saml/metadata",
+		SAMLACSURL:   issuer + "/federation/saml/acs",
+	}
+}
+
+func setFederationCookie(c *echo.Context, state string) {
+	// None so it also comes along on the cross site post of a SAML Response
+	c.SetCookie(&http.Cookie{
+		Name:     federationCookie,
+		Value:    state,
+		Path:     cookiePath(c),
+		MaxAge:   int(services.FederationStateLifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// takeFederationCookie returns the pending state and clears the cookie
+func takeFederationCookie(c *echo.Context) string {
+	cookie, err := c.Cookie(federationCookie)
+	if err != nil {
+		return ""
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     federationCookie,
+		Value:    "",
+		Path:     cookiePath(c),
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	return cookie.Value
+}
+
+func federationError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrLastSignInMethod):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Identity not found",
+		})
+	}
+
+	return userError(c, err)
+}
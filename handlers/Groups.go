@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// GroupHandler manages the groups of a tenant's users. Groups synced from a
+// directory are listed too but their memberships are owned by the directory.
+type GroupHandler struct{}
+
+func NewGroupHandler() *GroupHandler {
+	return &GroupHandler{}
+}
+
+func (h *GroupHandler) List(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	groupService := services.NewGroupService(getDBFromContext(c))
+	groups, err := groupService.ListGroups(tenant.ID)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, groups)
+}
+
+func (h *GroupHandler) Create(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	var req services.GroupInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	groupService := services.NewGroupService(getDBFromContext(c))
+	group, err := groupService.CreateGroup(tenant.ID, req)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, group)
+}
+
+func (h *GroupHandler) Update(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	groupID, err := uuid.Parse(c.Param("groupId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid group id",
+		})
+	}
+
+	var req services.GroupInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	groupService := services.NewGroupService(getDBFromContext(c))
+	group, err := groupService.UpdateGroup(tenant.ID, groupID, req)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, group)
+}
+
+func (h *GroupHandler) Delete(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	groupID, err := uuid.Parse(c.Param("groupId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid group id",
+		})
+	}
+
+	groupService := services.NewGroupService(getDBFromContext(c))
+	if err := groupService.DeleteGroup(tenant.ID, groupID); err != nil {
+		return groupError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *GroupHandler) Members(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	groupID, err := uuid.Parse(c.Param("groupId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid group id",
+		})
+	}
+
+	groupService := services.NewGroupService(getDBFromContext(c))
+	users, err := groupService.ListMembers(tenant.ID, groupID)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+func (h *GroupHandler) SetMembers(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	groupID, err := uuid.Parse(c.Param("groupId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid group id",
+		})
+	}
+
+	var req struct {
+		UserIDs []uuid.UUID `json:"user_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	groupService := services.NewGroupService(getDBFromContext(c))
+	if err := groupService.SetMembers(tenant.ID, groupID, req.UserIDs); err != nil {
+		return groupError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func groupError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrGroupInvalid),
+		errors.Is(err, services.ErrClientRoleInvalid):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrGroupNameTaken),
+		errors.Is(err, services.ErrGroupManagedByDirectory):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -1,10 +1,136 @@
 package handlers
 
 import (
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v5"
 	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func getDBFromContext(c *echo.Context) *gorm.DB {
 	return c.Get("db").(*gorm.DB)
 }
+
+func getAccountUserFromContext(c *echo.Context) *models.AccountUser {
+	return c.Get("accountUser").(*models.AccountUser)
+}
+
+func getConsoleSessionFromContext(c *echo.Context) *models.ConsoleSession {
+	return c.Get("consoleSession").(*models.ConsoleSession)
+}
+
+func getMailerFromContext(c *echo.Context) mailer.Mailer {
+	return c.Get("mailer").(mailer.Mailer)
+}
+
+func getThrottleFromContext(c *echo.Context) throttle.Store {
+	return c.Get("throttle").(throttle.Store)
+}
+
+// publicURL builds an absolute link for emails from PUBLIC_BASE_URL
+func publicURL(path string) string {
+	return services.PublicBaseURL() + path
+}
+
+// issuerLink builds an emailed link carrying token to path under the tenant's issuer.
+// The issuer comes from PUBLIC_BASE_URL and the domain that resolved the tenant, never
+// the request Host, so whoever asks for the email cannot point the link elsewhere.
+func issuerLink(c *echo.Context, path string, token string) string {
+	return getIssuerFromContext(c) + path + "?token=" + url.QueryEscape(token)
+}
+
+func getTenantFromContext(c *echo.Context) *models.Tenant {
+	return c.Get("tenant").(*models.Tenant)
+}
+
+func getIssuerFromContext(c *echo.Context) string {
+	return c.Get("issuer").(string)
+}
+
+// getIssuerPath is the path prefix of the tenant issuer, "/t/{slug}" or "" when resolved by host
+func getIssuerPath(c *echo.Context) string {
+	issuer, err := url.Parse(getIssuerFromContext(c))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(issuer.Path, "/")
+}
+
+func getSessionFromContext(c *echo.Context) *models.Session {
+	return c.Get("session").(*models.Session)
+}
+
+func getUserFromContext(c *echo.Context) *models.User {
+	return c.Get("user").(*models.User)
+}
+
+// getAccessTokenFromContext is the bearer token of RequireUserOrAccessToken, nil when
+// the request was authenticated with a session
+func getAccessTokenFromContext(c *echo.Context) *models.AccessToken {
+	accessToken, _ := c.Get("accessToken").(*models.AccessToken)
+	return accessToken
+}
+
+func isLockout(err error) bool {
+	return errors.Is(err, services.ErrAccountLocked) || errors.Is(err, services.ErrTooManyAttempts)
+}
+
+// lockoutResponse answers a locked (423) or throttled (429) login, both with Retry-After
+func lockoutResponse(c *echo.Context, err error) error {
+	var lockedErr *services.LockedError
+	if errors.As(err, &lockedErr) {
+		setRetryAfter(c, time.Until(lockedErr.Until))
+		return c.JSON(http.StatusLocked, map[string]any{
+			"error":        services.ErrAccountLocked.Error(),
+			"locked_until": lockedErr.Until,
+		})
+	}
+
+	var throttledErr *services.ThrottledError
+	if errors.As(err, &throttledErr) {
+		setRetryAfter(c, throttledErr.RetryAfter)
+	}
+	return c.JSON(http.StatusTooManyRequests, map[string]string{
+		"error": services.ErrTooManyAttempts.Error(),
+	})
+}
+
+func setRetryAfter(c *echo.Context, wait time.Duration) {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// getManagedTenant loads the :id tenant, scoped to the logged in AccountUser's account
+func getManagedTenant(c *echo.Context) (*models.Tenant, error) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, services.ErrRecordNotFound
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	return tenantService.GetTenant(getAccountUserFromContext(c).AccountID, tenantID)
+}
+
+// getManagedClient is getManagedTenant for routes under a :clientId of the tenant,
+// the client itself is checked by the service
+func getManagedClient(c *echo.Context) (*models.Tenant, uuid.UUID, error) {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		return nil, uuid.Nil, services.ErrRecordNotFound
+	}
+	return tenant, clientID, nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/mailer"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v5"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// mockDB answers statements with the rows the test expects
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+// newContext builds a request context with what the global middleware would have set
+func newContext(db *gorm.DB, mail mailer.Mailer, method string, target string, body io.Reader) (*echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.Set("db", db)
+	c.Set("mailer", mail)
+	return c, rec
+}
+
+var emailLink = regexp.MustCompile(`https?://\S+`)
+
+// linkIn returns the link in the last email sent to to
+func linkIn(t *testing.T, mail *mailer.MemoryMailer, to string) string {
+	t.Helper()
+
+	msg, ok := mail.Last(to)
+	if !ok {
+		t.Fatalf("no email sent to %s", to)
+	}
+
+	link := emailLink.FindString(msg.Body)
+	if link == "" {
+		t.Fatalf("no link in %q", msg.Body)
+	}
+	return link
+}
@@ -0,0 +1,907 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/hosted"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/saml"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/services"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// formCSRFCookie holds the double submit token for the hosted forms, they are
+// posted before a session (and its CSRF token) exists
+const formCSRFCookie = "digipass_form"
+
+// HostedHandler serves the tenant branded login and registration pages. The
+// /authorize flow sends users to {issuer}/login?return_to={authorize request}
+// and they are sent back there once signed in.
+type HostedHandler struct{}
+
+func NewHostedHandler() *HostedHandler {
+	return &HostedHandler{}
+}
+
+func (h *HostedHandler) LoginPage(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.QueryParam("return_to"))
+
+	if cookie, err := c.Cookie(middleware.UserSessionCookie); err == nil {
+		sessionService := services.NewSessionService(getDBFromContext(c))
+		tenant := getTenantFromContext(c)
+		if session, err := sessionService.Authenticate(tenant, cookie.Value); err == nil && services.MFASatisfied(tenant, session.AMR) {
+			if returnTo != "" {
+				return c.Redirect(http.StatusSeeOther, returnTo)
+			}
+			return h.render(c, http.StatusOK, "signed_in", hosted.Page{
+				Title:     "Signed in",
+				Email:     session.User.Email,
+				CSRFToken: session.CSRFToken,
+			})
+		}
+	}
+
+	return h.render(c, http.StatusOK, "login", hosted.Page{
+		Title:    "Sign in",
+		ReturnTo: returnTo,
+	})
+}
+
+func (h *HostedHandler) Login(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+	email := c.FormValue("email")
+
+	page := hosted.Page{
+		Title:    "Sign in",
+		ReturnTo: returnTo,
+		Email:    email,
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "login", page)
+	}
+
+	tenant := getTenantFromContext(c)
+
+	// Users of a domain routed to an enterprise provider sign in there, and users of a
+	// routed directory with their directory password
+	provider, err := services.NewFederationService(getDBFromContext(c)).ProviderForEmail(tenant.ID, email)
+	if err != nil && !errors.Is(err, services.ErrRecordNotFound) {
+		return err
+	}
+
+	if provider != nil && provider.Type != "ldap" {
+		return c.Redirect(http.StatusSeeOther, getIssuerPath(c)+"/federation/"+provider.ID.String()+"?return_to="+url.QueryEscape(returnTo))
+	}
+
+	clientID, err := lookupSessionClient(c, returnToClientID(returnTo))
+	if err != nil {
+		clientID = nil
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+
+	var session *models.Session
+	var token string
+	if provider != nil {
+		session, token, err = sessionService.LoginWithLDAP(c.Request().Context(), tenant, provider, email, c.FormValue("password"), clientID, c.Request().UserAgent(), c.RealIP())
+	} else {
+		session, token, err = sessionService.Login(tenant, email, c.FormValue("password"), clientID, c.Request().UserAgent(), c.RealIP())
+	}
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			return h.mfaStep(c, mfaErr, returnTo)
+		}
+		notifyLockout(c, email, err)
+		if isLockout(err) {
+			page.Error = lockoutMessage(err)
+			return h.render(c, http.StatusTooManyRequests, "login", page)
+		}
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			page.Error = "Incorrect email or password."
+			return h.render(c, http.StatusUnauthorized, "login", page)
+		case errors.Is(err, services.ErrUserSuspended):
+			page.Error = "This account has been suspended."
+			return h.render(c, http.StatusForbidden, "login", page)
+		case errors.Is(err, services.ErrMFAPasskeyRequired):
+			page.Error = "Two-step verification is required, sign in with a passkey."
+			return h.render(c, http.StatusForbidden, "login", page)
+		case errors.Is(err, services.ErrPasswordExpired):
+			page.Title = "Change password"
+			page.Error = "Your password has expired, choose a new one to continue."
+			return h.render(c, http.StatusForbidden, "change_password", page)
+		case errors.Is(err, services.ErrIdentityProviderUnreachable):
+			log.Printf("Directory sign-in failed: %v", err)
+			page.Error = "Your organization's directory could not be reached, please try again later."
+			return h.render(c, http.StatusBadGateway, "login", page)
+		case errors.Is(err, services.ErrFederationEmailRequired),
+			errors.Is(err, services.ErrFederationAccountExists),
+			errors.Is(err, services.ErrFederationSignupDisabled):
+			return h.federationFailed(c, err, returnTo)
+		}
+		return err
+	}
+
+	setUserSessionCookie(c, session, token)
+	return h.redirectAfterSignIn(c, returnTo)
+}
+
+func (h *HostedHandler) RegisterPage(c *echo.Context) error {
+	return h.render(c, http.StatusOK, "register", hosted.Page{
+		Title:    "Create account",
+		ReturnTo: safeReturnTo(c, c.QueryParam("return_to")),
+	})
+}
+
+func (h *HostedHandler) Register(c *echo.Context) error {
+	input := services.RegisterInput{
+		Email:      c.FormValue("email"),
+		Password:   c.FormValue("password"),
+		GivenName:  c.FormValue("given_name"),
+		FamilyName: c.FormValue("family_name"),
+	}
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+
+	page := hosted.Page{
+		Title:      "Create account",
+		ReturnTo:   returnTo,
+		Email:      input.Email,
+		GivenName:  input.GivenName,
+		FamilyName: input.FamilyName,
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "register", page)
+	}
+
+	db := getDBFromContext(c)
+	tenant := getTenantFromContext(c)
+
+	user, err := services.NewUsersService(db).Register(tenant, input)
+	if err != nil {
+		var policyErr *services.PasswordPolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			page.Error = "Your password does not meet the requirements:"
+			page.Problems = passwordProblems(policyErr)
+		case errors.Is(err, services.ErrInvalidEmail):
+			page.Error = "Enter a valid email address."
+		case errors.Is(err, services.ErrUserAlreadyExists):
+			page.Error = "An account with this email already exists, sign in instead."
+		default:
+			return err
+		}
+		return h.render(c, http.StatusBadRequest, "register", page)
+	}
+
+	if err := sendUserVerification(c, user); err != nil {
+		return err
+	}
+
+	clientID, err := lookupSessionClient(c, returnToClientID(returnTo))
+	if err != nil {
+		clientID = nil
+	}
+
+	session, token, err := services.NewSessionService(db, getThrottleFromContext(c)).Login(tenant, input.Email, input.Password, clientID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return err
+	}
+
+	setUserSessionCookie(c, session, token)
+	return h.redirectAfterSignIn(c, returnTo)
+}
+
+// VerifyEmailPage is the target of the verification link in the email
+func (h *HostedHandler) VerifyEmailPage(c *echo.Context) error {
+	usersService := services.NewUsersService(getDBFromContext(c))
+	if _, err := usersService.VerifyEmail(getTenantFromContext(c).ID, c.QueryParam("token")); err != nil {
+		if errors.Is(err, services.ErrTokenInvalid) {
+			return h.render(c, http.StatusBadRequest, "message", hosted.Page{
+				Title: "Link expired",
+				Error: "This verification link is invalid, expired or has already been used.",
+			})
+		}
+		return err
+	}
+
+	return h.render(c, http.StatusOK, "message", hosted.Page{
+		Title:   "Email verified",
+		Message: "Thanks, your email address is verified.",
+	})
+}
+
+func (h *HostedHandler) ForgotPasswordPage(c *echo.Context) error {
+	return h.render(c, http.StatusOK, "forgot_password", hosted.Page{
+		Title: "Reset password",
+	})
+}
+
+func (h *HostedHandler) ForgotPassword(c *echo.Context) error {
+	page := hosted.Page{
+		Title: "Reset password",
+		Email: c.FormValue("email"),
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "forgot_password", page)
+	}
+
+	if err := requestPasswordReset(c, page.Email); err != nil {
+		return err
+	}
+
+	return h.render(c, http.StatusOK, "message", hosted.Page{
+		Title:   "Check your email",
+		Message: "If an account exists for " + page.Email + ", we sent it a link to reset the password.",
+	})
+}
+
+// ResetPasswordPage only shows the form, the token is consumed when the form is submitted
+func (h *HostedHandler) ResetPasswordPage(c *echo.Context) error {
+	return h.render(c, http.StatusOK, "reset_password", hosted.Page{
+		Title: "Choose a new password",
+		Token: c.QueryParam("token"),
+	})
+}
+
+func (h *HostedHandler) ResetPassword(c *echo.Context) error {
+	page := hosted.Page{
+		Title: "Choose a new password",
+		Token: c.FormValue("token"),
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "reset_password", page)
+	}
+
+	usersService := services.NewUsersService(getDBFromContext(c))
+	if _, err := usersService.ResetPassword(getTenantFromContext(c), page.Token, c.FormValue("password")); err != nil {
+		var policyErr *services.PasswordPolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			page.Error = "Your password does not meet the requirements:"
+			page.Problems = passwordProblems(policyErr)
+			return h.render(c, http.StatusBadRequest, "reset_password", page)
+		case errors.Is(err, services.ErrTokenInvalid):
+			return h.render(c, http.StatusBadRequest, "message", hosted.Page{
+				Title: "Link expired",
+				Error: "This reset link is invalid, expired or has already been used. Request a new one from the sign in page.",
+			})
+		}
+		return err
+	}
+
+	// Every session was revoked, including this browser's
+	clearUserSessionCookie(c)
+
+	return h.render(c, http.StatusOK, "message", hosted.Page{
+		Title:   "Password changed",
+		Message: "Your password has been changed and you have been signed out everywhere.",
+	})
+}
+
+func (h *HostedHandler) ChangePasswordPage(c *echo.Context) error {
+	return h.render(c, http.StatusOK, "change_password", hosted.Page{
+		Title:    "Change password",
+		ReturnTo: safeReturnTo(c, c.QueryParam("return_to")),
+	})
+}
+
+// ChangePassword replaces the password and signs the user in with the new one
+func (h *HostedHandler) ChangePassword(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+	email := c.FormValue("email")
+	newPassword := c.FormValue("new_password")
+
+	page := hosted.Page{
+		Title:    "Change password",
+		ReturnTo: returnTo,
+		Email:    email,
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "change_password", page)
+	}
+
+	db := getDBFromContext(c)
+	tenant := getTenantFromContext(c)
+
+	if _, err := services.NewUsersService(db, getThrottleFromContext(c)).ChangePassword(tenant, email, c.FormValue("current_password"), newPassword, c.Request().UserAgent(), c.RealIP()); err != nil {
+		notifyLockout(c, email, err)
+		if isLockout(err) {
+			page.Error = lockoutMessage(err)
+			return h.render(c, http.StatusTooManyRequests, "change_password", page)
+		}
+		var policyErr *services.PasswordPolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			page.Error = "Your new password does not meet the requirements:"
+			page.Problems = passwordProblems(policyErr)
+			return h.render(c, http.StatusBadRequest, "change_password", page)
+		case errors.Is(err, services.ErrInvalidCredentials):
+			page.Error = "Incorrect email or current password."
+			return h.render(c, http.StatusUnauthorized, "change_password", page)
+		case errors.Is(err, services.ErrUserSuspended):
+			page.Error = "This account has been suspended."
+			return h.render(c, http.StatusForbidden, "change_password", page)
+		}
+		return err
+	}
+
+	clientID, err := lookupSessionClient(c, returnToClientID(returnTo))
+	if err != nil {
+		clientID = nil
+	}
+
+	session, token, err := services.NewSessionService(db, getThrottleFromContext(c)).Login(tenant, email, newPassword, clientID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return err
+	}
+
+	setUserSessionCookie(c, session, token)
+	return h.redirectAfterSignIn(c, returnTo)
+}
+
+// UnlockPage is the target of the unlock link emailed when an account gets locked
+func (h *HostedHandler) UnlockPage(c *echo.Context) error {
+	usersService := services.NewUsersService(getDBFromContext(c), getThrottleFromContext(c))
+	if _, err := usersService.Unlock(getTenantFromContext(c), c.QueryParam("token"), c.Request().UserAgent(), c.RealIP()); err != nil {
+		if errors.Is(err, services.ErrTokenInvalid) {
+			return h.render(c, http.StatusBadRequest, "message", hosted.Page{
+				Title: "Link expired",
+				Error: "This unlock link is invalid, expired or has already been used.",
+			})
+		}
+		return err
+	}
+
+	return h.render(c, http.StatusOK, "message", hosted.Page{
+		Title:   "Account unlocked",
+		Message: "Your account is unlocked, you can sign in again.",
+	})
+}
+
+func (h *HostedHandler) redirectAfterSignIn(c *echo.Context, returnTo string) error {
+	if returnTo == "" {
+		returnTo = getIssuerPath(c) + "/login"
+	}
+	return c.Redirect(http.StatusSeeOther, returnTo)
+}
+
+func (h *HostedHandler) EmailLoginPage(c *echo.Context) error {
+	if !services.EmailLoginAllowed(getTenantFromContext(c)) {
+		return c.Redirect(http.StatusSeeOther, getIssuerPath(c)+"/login")
+	}
+
+	return h.render(c, http.StatusOK, "email_login", hosted.Page{
+		Title:    "Sign in by email",
+		ReturnTo: safeReturnTo(c, c.QueryParam("return_to")),
+	})
+}
+
+// EmailLogin sends the magic link and code, then asks for the code. The page is the
+// same for unknown emails, the first sign-in creates the user.
+func (h *HostedHandler) EmailLogin(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+	email := c.FormValue("email")
+
+	page := hosted.Page{
+		Title:    "Sign in by email",
+		ReturnTo: returnTo,
+		Email:    email,
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "email_login", page)
+	}
+
+	tenant := getTenantFromContext(c)
+	if !services.EmailLoginAllowed(tenant) {
+		return c.Redirect(http.StatusSeeOther, getIssuerPath(c)+"/login")
+	}
+
+	clientID, err := lookupSessionClient(c, returnToClientID(returnTo))
+	if err != nil {
+		clientID = nil
+	}
+
+	browserToken, err := emailLoginBrowserToken(c)
+	if err != nil {
+		return err
+	}
+
+	emailLoginService := services.NewEmailLoginService(getDBFromContext(c), getThrottleFromContext(c))
+	request, err := emailLoginService.Start(tenant, email, clientID, returnTo, browserToken)
+	if err != nil {
+		switch {
+		case isLockout(err):
+			page.Error = "Too many emails sent to this address, please wait a few minutes and try again."
+			return h.render(c, http.StatusTooManyRequests, "email_login", page)
+		case errors.Is(err, services.ErrInvalidEmail):
+			page.Error = "Enter a valid email address."
+			return h.render(c, http.StatusBadRequest, "email_login", page)
+		}
+		return err
+	}
+
+	if err := sendEmailLogin(c, request); err != nil {
+		return err
+	}
+
+	return h.render(c, http.StatusOK, "email_code", hosted.Page{
+		Title:    "Check your email",
+		ReturnTo: returnTo,
+		Message:  "We sent a sign-in link and a 6 digit code to " + request.Email + ".",
+	})
+}
+
+// EmailLoginCode signs in with the code typed into this browser
+func (h *HostedHandler) EmailLoginCode(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+
+	page := hosted.Page{
+		Title:    "Check your email",
+		ReturnTo: returnTo,
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "email_code", page)
+	}
+
+	tenant := getTenantFromContext(c)
+	emailLoginService := services.NewEmailLoginService(getDBFromContext(c), getThrottleFromContext(c))
+	user, challenge, err := emailLoginService.VerifyCode(tenant, c.FormValue("code"), emailLoginCookieValue(c))
+	if err != nil {
+		if errors.Is(err, services.ErrEmailLoginInvalid) {
+			page.Error = "That code is not valid or has expired."
+			return h.render(c, http.StatusUnauthorized, "email_code", page)
+		}
+		return h.emailLoginFailed(c, err, returnTo)
+	}
+
+	return h.emailSignIn(c, user, challenge.ClientID, returnTo)
+}
+
+// EmailLoginLink is where the emailed link lands, it resumes the flow the browser started
+func (h *HostedHandler) EmailLoginLink(c *echo.Context) error {
+	tenant := getTenantFromContext(c)
+	emailLoginService := services.NewEmailLoginService(getDBFromContext(c), getThrottleFromContext(c))
+	user, challenge, err := emailLoginService.VerifyLink(tenant, c.QueryParam("token"), emailLoginCookieValue(c))
+	if err != nil {
+		return h.emailLoginFailed(c, err, "")
+	}
+
+	return h.emailSignIn(c, user, challenge.ClientID, safeReturnTo(c, challenge.ReturnTo))
+}
+
+func (h *HostedHandler) emailSignIn(c *echo.Context, user *models.User, clientID *uuid.UUID, returnTo string) error {
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.LoginWithEmail(getTenantFromContext(c), user, clientID, c.Request().UserAgent(), c.RealIP())
+	return h.finishSignIn(c, session, token, err, returnTo)
+}
+
+// finishSignIn sets the session cookie and resumes returnTo, or asks for the second
+// factor when the user has an authenticator or the tenant requires one
+func (h *HostedHandler) finishSignIn(c *echo.Context, session *models.Session, token string, err error, returnTo string) error {
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			return h.mfaStep(c, mfaErr, returnTo)
+		}
+		if errors.Is(err, services.ErrMFAPasskeyRequired) {
+			return h.render(c, http.StatusForbidden, "login", hosted.Page{
+				Title:    "Sign in",
+				ReturnTo: returnTo,
+				Error:    "Two-step verification is required, sign in with a passkey.",
+			})
+		}
+		return err
+	}
+
+	setUserSessionCookie(c, session, token)
+	return h.redirectAfterSignIn(c, returnTo)
+}
+
+func (h *HostedHandler) emailLoginFailed(c *echo.Context, err error, returnTo string) error {
+	page := hosted.Page{
+		Title:    "Sign in by email",
+		ReturnTo: returnTo,
+	}
+
+	switch {
+	case errors.Is(err, services.ErrEmailLoginOtherBrowser):
+		page.Error = "Open the sign-in link in the browser where you asked for it, or enter the code from the email there."
+		return h.render(c, http.StatusUnauthorized, "email_login", page)
+	case errors.Is(err, services.ErrEmailLoginInvalid):
+		page.Error = "This sign-in link is invalid, expired or was already used. Request a new one."
+		return h.render(c, http.StatusUnauthorized, "email_login", page)
+	case errors.Is(err, services.ErrUserSuspended):
+		page.Error = "This account has been suspended."
+		return h.render(c, http.StatusForbidden, "email_login", page)
+	}
+	return err
+}
+
+// FederationStart sends the browser to sign in at an upstream identity provider
+func (h *HostedHandler) FederationStart(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.QueryParam("return_to"))
+
+	providerID, err := uuid.Parse(c.Param("providerId"))
+	if err != nil {
+		return h.federationFailed(c, services.ErrRecordNotFound, returnTo)
+	}
+
+	clientID, err := lookupSessionClient(c, returnToClientID(returnTo))
+	if err != nil {
+		clientID = nil
+	}
+
+	federationService := services.NewFederationService(getDBFromContext(c))
+	authURL, state, err := federationService.Start(c.Request().Context(), getTenantFromContext(c), providerID, federationEndpoints(c), clientID, returnTo)
+	if err != nil {
+		return h.federationFailed(c, err, returnTo)
+	}
+
+	setFederationCookie(c, state)
+	return c.Redirect(http.StatusSeeOther, authURL)
+}
+
+// FederationCallback is where providers redirect back to with the authorization code
+func (h *HostedHandler) FederationCallback(c *echo.Context) error {
+	state := c.QueryParam("state")
+	expectedState := takeFederationCookie(c)
+
+	if c.QueryParam("error") != "" {
+		return h.render(c, http.StatusUnauthorized, "login", hosted.Page{
+			Title: "Sign in",
+			Error: "Sign-in with the identity provider was cancelled or refused.",
+		})
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expectedState)) != 1 {
+		return h.federationFailed(c, services.ErrFederationInvalid, "")
+	}
+
+	tenant := getTenantFromContext(c)
+	federationService := services.NewFederationService(getDBFromContext(c))
+	user, pending, err := federationService.Callback(c.Request().Context(), tenant, state, c.QueryParam("code"), federationRedirectURI(c))
+	if err != nil {
+		return h.federationFailed(c, err, "")
+	}
+
+	returnTo := safeReturnTo(c, pending.ReturnTo)
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.LoginWithFederation(tenant, user, pending.ClientID, c.Request().UserAgent(), c.RealIP())
+	return h.finishSignIn(c, session, token, err, returnTo)
+}
+
+// FederationSAMLMetadata publishes the tenant's service provider metadata for enterprise
+// identity providers to import
+func (h *HostedHandler) FederationSAMLMetadata(c *echo.Context) error {
+	signer, err := services.NewSigningKeyService(getDBFromContext(c)).ActiveKey(getTenantFromContext(c))
+	if err != nil {
+		return err
+	}
+
+	endpoints := federationEndpoints(c)
+	descriptor := saml.ServiceProviderDescriptor(endpoints.SAMLEntityID, endpoints.SAMLACSURL, signer.Certificate)
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", []byte(descriptor.Canonical()))
+}
+
+// FederationSAMLACS receives the Response of a SAML identity provider. A RelayState
+// matching the cookie answers our AuthnRequest, anything else is IdP initiated.
+func (h *HostedHandler) FederationSAMLACS(c *echo.Context) error {
+	expectedState := takeFederationCookie(c)
+
+	response, err := saml.DecodePost(c.FormValue("SAMLResponse"))
+	if err != nil {
+		return h.federationFailed(c, services.ErrFederationInvalid, "")
+	}
+
+	state := c.FormValue("RelayState")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expectedState)) != 1 {
+		state = ""
+	}
+
+	tenant := getTenantFromContext(c)
+	federationService := services.NewFederationService(getDBFromContext(c))
+	user, pending, err := federationService.SAMLCallback(tenant, state, response, federationEndpoints(c))
+	if err != nil {
+		return h.federationFailed(c, err, "")
+	}
+
+	returnTo := safeReturnTo(c, pending.ReturnTo)
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.LoginWithFederation(tenant, user, pending.ClientID, c.Request().UserAgent(), c.RealIP())
+	return h.finishSignIn(c, session, token, err, returnTo)
+}
+
+func (h *HostedHandler) federationFailed(c *echo.Context, err error, returnTo string) error {
+	page := hosted.Page{
+		Title:    "Sign in",
+		ReturnTo: returnTo,
+	}
+
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		page.Error = "This sign-in option is not available."
+		return h.render(c, http.StatusNotFound, "login", page)
+	case errors.Is(err, services.ErrFederationInvalid):
+		page.Error = "This sign-in attempt expired, please try again."
+		return h.render(c, http.StatusUnauthorized, "login", page)
+	case errors.Is(err, services.ErrFederationFailed),
+		errors.Is(err, services.ErrIdentityProviderUnreachable):
+		log.Printf("Federated sign-in failed: %v", err)
+		page.Error = "Sign-in with the identity provider did not work, please try again."
+		return h.render(c, http.StatusBadGateway, "login", page)
+	case errors.Is(err, services.ErrFederationEmailRequired):
+		page.Error = "The identity provider did not share your email address."
+		return h.render(c, http.StatusForbidden, "login", page)
+	case errors.Is(err, services.ErrFederationAccountExists):
+		page.Error = "An account with this email already exists, sign in with your password instead."
+		return h.render(c, http.StatusConflict, "login", page)
+	case errors.Is(err, services.ErrFederationSignupDisabled):
+		page.Error = "There is no account linked to this identity."
+		return h.render(c, http.StatusForbidden, "login", page)
+	case errors.Is(err, services.ErrUserSuspended):
+		page.Error = "This account has been suspended."
+		return h.render(c, http.StatusForbidden, "login", page)
+	}
+	return err
+}
+
+// MFA is the second step of a hosted sign-in for users with an authenticator
+func (h *HostedHandler) MFA(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+
+	page := hosted.Page{
+		Title:    "Two-step verification",
+		ReturnTo: returnTo,
+		Token:    c.FormValue("mfa_token"),
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "mfa", page)
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.VerifyMFA(getTenantFromContext(c), page.Token, c.FormValue("code"), c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		var lockedErr *services.LockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			page.Error = "Too many incorrect codes. Try again after " + lockedErr.Until.UTC().Format("15:04 MST") + "."
+			return h.render(c, http.StatusTooManyRequests, "mfa", page)
+		case errors.Is(err, services.ErrMFAInvalidCode):
+			page.Error = "That code is not valid, please try again."
+			return h.render(c, http.StatusUnauthorized, "mfa", page)
+		case errors.Is(err, services.ErrMFAChallengeInvalid),
+			errors.Is(err, services.ErrMFANotEnabled):
+			return h.render(c, http.StatusUnauthorized, "login", hosted.Page{
+				Title:    "Sign in",
+				ReturnTo: returnTo,
+				Error:    "Your sign-in attempt expired, please sign in again.",
+			})
+		case errors.Is(err, services.ErrUserSuspended):
+			return h.render(c, http.StatusForbidden, "login", hosted.Page{
+				Title:    "Sign in",
+				ReturnTo: returnTo,
+				Error:    "This account has been suspended.",
+			})
+		}
+		return err
+	}
+
+	setUserSessionCookie(c, session, token)
+	return h.redirectAfterSignIn(c, returnTo)
+}
+
+// mfaStep asks for the code of the user's authenticator, or has them add one first
+// when the tenant requires MFA and they have none
+func (h *HostedHandler) mfaStep(c *echo.Context, mfaErr *services.MFARequiredError, returnTo string) error {
+	page := hosted.Page{
+		Title:    "Two-step verification",
+		ReturnTo: returnTo,
+		Token:    mfaErr.Token,
+	}
+
+	if !mfaErr.Enroll {
+		return h.render(c, http.StatusOK, "mfa", page)
+	}
+
+	tenant := getTenantFromContext(c)
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	enrollment, err := sessionService.StartMFAEnrollment(tenant, mfaErr.Token, tenantDisplayName(tenant))
+	if err != nil {
+		return err
+	}
+
+	page.Title = "Set up two-step verification"
+	page.Secret = enrollment.Secret
+	return h.render(c, http.StatusOK, "mfa_enroll", page)
+}
+
+// MFAEnroll confirms the authenticator added during a sign-in and shows the
+// recovery codes once before resuming returnTo
+func (h *HostedHandler) MFAEnroll(c *echo.Context) error {
+	returnTo := safeReturnTo(c, c.FormValue("return_to"))
+
+	page := hosted.Page{
+		Title:    "Set up two-step verification",
+		ReturnTo: returnTo,
+		Token:    c.FormValue("mfa_token"),
+		Secret:   c.FormValue("secret"),
+	}
+
+	if !validFormCSRF(c) {
+		page.Error = "Your session expired, please try again."
+		return h.render(c, http.StatusForbidden, "mfa_enroll", page)
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, codes, err := sessionService.CompleteMFAEnrollment(getTenantFromContext(c), page.Token, c.FormValue("code"), c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMFAInvalidCode):
+			page.Error = "That code is not valid, please try again."
+			return h.render(c, http.StatusUnauthorized, "mfa_enroll", page)
+		case errors.Is(err, services.ErrMFAChallengeInvalid),
+			errors.Is(err, services.ErrMFANotEnabled):
+			return h.render(c, http.StatusUnauthorized, "login", hosted.Page{
+				Title:    "Sign in",
+				ReturnTo: returnTo,
+				Error:    "Your sign-in attempt expired, please sign in again.",
+			})
+		case errors.Is(err, services.ErrUserSuspended):
+			return h.render(c, http.StatusForbidden, "login", hosted.Page{
+				Title:    "Sign in",
+				ReturnTo: returnTo,
+				Error:    "This account has been suspended.",
+			})
+		}
+		return err
+	}
+
+	setUserSessionCookie(c, session, token)
+	return h.render(c, http.StatusOK, "recovery_codes", hosted.Page{
+		Title:    "Recovery codes",
+		ReturnTo: returnTo,
+		Codes:    codes,
+	})
+}
+
+// render fills in the tenant branding and form CSRF token and writes the page
+func (h *HostedHandler) render(c *echo.Context, status int, name string, page hosted.Page) error {
+	tenant := getTenantFromContext(c)
+
+	page.Branding = hosted.Branding{
+		Name:         tenantDisplayName(tenant),
+		LogoURL:      tenant.Settings.Branding.LogoURL,
+		PrimaryColor: tenant.Settings.Branding.PrimaryColor,
+	}
+	page.BasePath = getIssuerPath(c)
+	page.Passkeys = services.PasskeysAllowed(tenant)
+	page.EmailLogin = services.EmailLoginAllowed(tenant)
+
+	if name == "login" {
+		providers, err := services.NewFederationService(getDBFromContext(c)).EnabledProviders(tenant.ID)
+		if err != nil {
+			return err
+		}
+		for _, provider := range providers {
+			page.Providers = append(page.Providers, hosted.IdentityProvider{ID: provider.ID.String(), Name: provider.Name})
+		}
+	}
+
+	if page.CSRFToken == "" {
+		token, err := formCSRFToken(c)
+		if err != nil {
+			return err
+		}
+		page.CSRFToken = token
+	}
+
+	var body bytes.Buffer
+	if err := hosted.Render(&body, name, page); err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+	return c.HTMLBlob(status, body.Bytes())
+}
+
+// formCSRFToken reuses the browser's form token or issues a new one
+func formCSRFToken(c *echo.Context) (string, error) {
+	if cookie, err := c.Cookie(formCSRFCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     formCSRFCookie,
+		Value:    token,
+		Path:     cookiePath(c),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+func validFormCSRF(c *echo.Context) bool {
+	cookie, err := c.Cookie(formCSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(c.FormValue(middleware.CSRFFormField))) == 1
+}
+
+// safeReturnTo only accepts a relative path on this issuer so the pages cannot be used as an open redirect
+func safeReturnTo(c *echo.Context, returnTo string) string {
+	if returnTo == "" || !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		return ""
+	}
+
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+
+	if base := getIssuerPath(c); base != "" && u.Path != base && !strings.HasPrefix(u.Path, base+"/") {
+		return ""
+	}
+
+	return returnTo
+}
+
+func lockoutMessage(err error) string {
+	var lockedErr *services.LockedError
+	if errors.As(err, &lockedErr) {
+		return "Too many failed attempts. Your account is locked until " + lockedErr.Until.UTC().Format("15:04 MST") +
+			", we sent you an email to unlock it sooner."
+	}
+	return "Too many attempts, please wait a moment and try again."
+}
+
+func passwordProblems(policyErr *services.PasswordPolicyError) []string {
+	problems := make([]string, 0, len(policyErr.Problems))
+	for _, problem := range policyErr.Problems {
+		problems = append(problems, "Password "+problem)
+	}
+	return problems
+}
+
+// returnToClientID picks the client_id out of the authorize request being resumed
+func returnToClientID(returnTo string) string {
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("client_id")
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// IdentityProviderHandler manages a tenant's upstream OpenID Connect and SAML providers
+// and LDAP directories. OpenID Connect providers must be registered with
+// {issuer}/federation/callback as redirect URI, SAML providers import the service
+// provider metadata at {issuer}/federation/saml/metadata.
+type IdentityProviderHandler struct{}
+
+func NewIdentityProviderHandler() *IdentityProviderHandler {
+	return &IdentityProviderHandler{}
+}
+
+func (h *IdentityProviderHandler) List(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	federationService := services.NewFederationService(getDBFromContext(c))
+	providers, err := federationService.ListProviders(tenant.ID)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, providers)
+}
+
+func (h *IdentityProviderHandler) Create(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	var req services.IdentityProviderInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	federationService := services.NewFederationService(getDBFromContext(c))
+	provider, err := federationService.CreateProvider(c.Request().Context(), tenant.ID, req)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, provider)
+}
+
+func (h *IdentityProviderHandler) Update(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	providerID, err := uuid.Parse(c.Param("providerId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity provider id",
+		})
+	}
+
+	var req services.IdentityProviderInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	federationService := services.NewFederationService(getDBFromContext(c))
+	provider, err := federationService.UpdateProvider(c.Request().Context(), tenant.ID, providerID, req)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, provider)
+}
+
+func (h *IdentityProviderHandler) Delete(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return identityProviderError(c, err)
+	}
+
+	providerID, err := uuid.Parse(c.Param("providerId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity provider id",
+		})
+	}
+
+	federationService := services.NewFederationService(getDBFromContext(c))
+	if err := federationService.DeleteProvider(tenant.ID, providerID); err != nil {
+		return identityProviderError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func identityProviderError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrIdentityProviderInvalid),
+		errors.Is(err, services.ErrIdentityProviderUnreachable):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
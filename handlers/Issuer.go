@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+)
+
+type IssuerHandler struct{}
+
+func NewIssuerHandler() *IssuerHandler {
+	return &IssuerHandler{}
+}
+
+// Discovery serves the tenant's OpenID provider metadata
+func (h *IssuerHandler) Discovery(c *echo.Context) error {
+	tenant := getTenantFromContext(c)
+	issuer := getIssuerFromContext(c)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"issuer":                                         issuer,
+		"authorization_endpoint":                         issuer + "/authorize",
+		"token_endpoint":                                 issuer + "/token",
+		"jwks_uri":                                       issuer + "/.well-known/jwks.json",
+		"grant_types_supported":                          tenant.Settings.AllowedGrantTypes,
+		"response_types_supported":                       []string{"code"},
+		"subject_types_supported":                        []string{"public"},
+		"scopes_supported":                               []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"id_token_signing_alg_values_supported":          []string{jwt.Algorithm},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"acr_values_supported":                           []string{models.ACRSingleFactor, models.ACRMultiFactor},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+)
+
+// consoleMFAIssuer labels console accounts in authenticator apps
+const consoleMFAIssuer = "DigiPass"
+
+// VerifyMFA exchanges the challenge of a password login and a TOTP or recovery code for a session
+func (h *UserHandler) VerifyMFA(c *echo.Context) error {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.VerifyMFA(getTenantFromContext(c), req.MFAToken, req.Code, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	setUserSessionCookie(c, session, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"user_id":    session.UserID,
+		"expires_at": session.ExpiresAt,
+		"csrf_token": session.CSRFToken,
+		"amr":        session.AMR,
+	})
+}
+
+// StartMFAEnrollment returns an authenticator secret to a user who signed in to a
+// tenant that requires MFA without having one, the login gave them the mfa_token
+func (h *UserHandler) StartMFAEnrollment(c *echo.Context) error {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenant := getTenantFromContext(c)
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	enrollment, err := sessionService.StartMFAEnrollment(tenant, req.MFAToken, tenantDisplayName(tenant))
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, enrollment)
+}
+
+// CompleteMFAEnrollment confirms the authenticator and finishes the login, the
+// recovery codes are never shown again
+func (h *UserHandler) CompleteMFAEnrollment(c *echo.Context) error {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, codes, err := sessionService.CompleteMFAEnrollment(getTenantFromContext(c), req.MFAToken, req.Code, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	setUserSessionCookie(c, session, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"user_id":        session.UserID,
+		"expires_at":     session.ExpiresAt,
+		"csrf_token":     session.CSRFToken,
+		"amr":            session.AMR,
+		"recovery_codes": codes,
+	})
+}
+
+func (h *UserHandler) MFAStatus(c *echo.Context) error {
+	return mfaStatus(c, services.UserMFAOwner(getUserFromContext(c).ID), getTenantFromContext(c).Settings.MFA.Required)
+}
+
+// StartTOTP returns a new authenticator secret, it is only enabled once confirmed
+func (h *UserHandler) StartTOTP(c *echo.Context) error {
+	tenant := getTenantFromContext(c)
+	if !services.TOTPAllowed(tenant) {
+		return mfaError(c, services.ErrMFAMethodNotAllowed)
+	}
+
+	user := getUserFromContext(c)
+	return startTOTP(c, services.UserMFAOwner(user.ID), tenantDisplayName(tenant), user.Email)
+}
+
+func (h *UserHandler) ConfirmTOTP(c *echo.Context) error {
+	return confirmTOTP(c, services.UserMFAOwner(getUserFromContext(c).ID))
+}
+
+// DisableTOTP requires a current code and a re-authentication: the password, or a
+// fresh passkey or email sign-in for users without one
+func (h *UserHandler) DisableTOTP(c *echo.Context) error {
+	tenant := getTenantFromContext(c)
+	user := getUserFromContext(c)
+	usersService := services.NewUsersService(getDBFromContext(c), getThrottleFromContext(c))
+
+	return disableTOTP(c, services.UserMFAOwner(user.ID), tenant.Settings.Lockout, func(plainPassword string) error {
+		return usersService.Reauthenticate(tenant, user, getSessionFromContext(c), plainPassword, c.Request().UserAgent(), c.RealIP())
+	})
+}
+
+func (h *UserHandler) RegenerateRecoveryCodes(c *echo.Context) error {
+	return regenerateRecoveryCodes(c, services.UserMFAOwner(getUserFromContext(c).ID), getTenantFromContext(c).Settings.Lockout)
+}
+
+// VerifyMFA completes a console login for an AccountUser with an authenticator
+func (h *ConsoleHandler) VerifyMFA(c *echo.Context) error {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	sessionService := services.NewConsoleSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.VerifyMFA(req.MFAToken, req.Code, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	setConsoleSessionCookie(c, session.ExpiresAt, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"account_user_id": session.AccountUserID,
+		"expires_at":      session.ExpiresAt,
+		"csrf_token":      session.CSRFToken,
+	})
+}
+
+func (h *ConsoleHandler) MFAStatus(c *echo.Context) error {
+	return mfaStatus(c, services.AccountUserMFAOwner(getAccountUserFromContext(c).ID), false)
+}
+
+func (h *ConsoleHandler) StartTOTP(c *echo.Context) error {
+	accountUser := getAccountUserFromContext(c)
+	return startTOTP(c, services.AccountUserMFAOwner(accountUser.ID), consoleMFAIssuer, accountUser.Email)
+}
+
+func (h *ConsoleHandler) ConfirmTOTP(c *echo.Context) error {
+	return confirmTOTP(c, services.AccountUserMFAOwner(getAccountUserFromContext(c).ID))
+}
+
+// DisableTOTP requires the password again as well as a current code
+func (h *ConsoleHandler) DisableTOTP(c *echo.Context) error {
+	accountUser := getAccountUserFromContext(c)
+	sessionService := services.NewConsoleSessionService(getDBFromContext(c), getThrottleFromContext(c))
+
+	return disableTOTP(c, services.AccountUserMFAOwner(accountUser.ID), models.DefaultLockoutSettings(), func(plainPassword string) error {
+		return sessionService.Reauthenticate(accountUser, plainPassword, c.Request().UserAgent(), c.RealIP())
+	})
+}
+
+func (h *ConsoleHandler) RegenerateRecoveryCodes(c *echo.Context) error {
+	return regenerateRecoveryCodes(c, services.AccountUserMFAOwner(getAccountUserFromContext(c).ID), models.DefaultLockoutSettings())
+}
+
+func mfaStatus(c *echo.Context, owner services.MFAOwner, required bool) error {
+	mfaService := services.NewMFAService(getDBFromContext(c), getThrottleFromContext(c))
+
+	enabled, err := mfaService.HasTOTP(owner)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	remaining, err := mfaService.RecoveryCodesRemaining(owner)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"totp_enabled":             enabled,
+		"recovery_codes_remaining": remaining,
+		"enrollment_required":      required && !enabled,
+	})
+}
+
+func startTOTP(c *echo.Context, owner services.MFAOwner, issuer string, account string) error {
+	mfaService := services.NewMFAService(getDBFromContext(c), getThrottleFromContext(c))
+	enrollment, err := mfaService.StartTOTPEnrollment(owner, issuer, account)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, enrollment)
+}
+
+// confirmTOTP enables the authenticator, the recovery codes are never shown again
+func confirmTOTP(c *echo.Context, owner services.MFAOwner) error {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	mfaService := services.NewMFAService(getDBFromContext(c), getThrottleFromContext(c))
+	codes, err := mfaService.ConfirmTOTP(owner, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"recovery_codes": codes,
+	})
+}
+
+func disableTOTP(c *echo.Context, owner services.MFAOwner, settings models.LockoutSettings, reauthenticate func(string) error) error {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := reauthenticate(req.Password); err != nil {
+		return mfaError(c, err)
+	}
+
+	mfaService := services.NewMFAService(getDBFromContext(c), getThrottleFromContext(c))
+	if err := mfaService.DisableTOTP(owner, req.Code, settings); err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func regenerateRecoveryCodes(c *echo.Context, owner services.MFAOwner, settings models.LockoutSettings) error {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	mfaService := services.NewMFAService(getDBFromContext(c), getThrottleFromContext(c))
+	codes, err := mfaService.RegenerateRecoveryCodes(owner, req.Code, settings)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"recovery_codes": codes,
+	})
+}
+
+// mfaChallengeResponse tells the client to collect a second factor, the token is
+// posted back to the mfa verify endpoint with the code. With enrollment_required it
+// goes to the mfa enroll endpoints instead.
+func mfaChallengeResponse(c *echo.Context, err *services.MFARequiredError) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"mfa_required":        true,
+		"enrollment_required": err.Enroll,
+		"mfa_token":           err.Token,
+		"expires_in":          int(services.MFAChallengeLifetime.Seconds()),
+	})
+}
+
+func mfaError(c *echo.Context, err error) error {
+	if isLockout(err) {
+		return lockoutResponse(c, err)
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Invalid password",
+		})
+	case errors.Is(err, services.ErrMFAInvalidCode),
+		errors.Is(err, services.ErrMFAChallengeInvalid),
+		errors.Is(err, services.ErrReauthenticationRequired):
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrUserSuspended),
+		errors.Is(err, services.ErrMFAMethodNotAllowed):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
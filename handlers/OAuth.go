@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/hosted"
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/labstack/echo/v5"
+)
+
+// Authorize is the authorization endpoint of the code flow. Users without a session
+// are sent to the login page and come back here once signed in.
+func (h *HostedHandler) Authorize(c *echo.Context) error {
+	request := services.AuthorizeRequest{
+		ResponseType:        c.QueryParam("response_type"),
+		ClientID:            c.QueryParam("client_id"),
+		RedirectURI:         c.QueryParam("redirect_uri"),
+		Scope:               c.QueryParam("scope"),
+		State:               c.QueryParam("state"),
+		Nonce:               c.QueryParam("nonce"),
+		CodeChallenge:       c.QueryParam("code_challenge"),
+		CodeChallengeMethod: c.QueryParam("code_challenge_method"),
+		Prompt:              c.QueryParam("prompt"),
+	}
+
+	tenant := getTenantFromContext(c)
+	oauthService := services.NewOAuthService(getDBFromContext(c))
+
+	client, err := oauthService.AuthorizeClient(tenant, request)
+	if err != nil {
+		return h.authorizeFailed(c, err)
+	}
+
+	if err := oauthService.ValidateAuthorizeRequest(tenant, client, request); err != nil {
+		return authorizeRedirect(c, request, err)
+	}
+
+	session, err := h.currentSession(c)
+	if err != nil {
+		return err
+	}
+
+	if session == nil {
+		if request.Prompt == "none" {
+			return authorizeRedirect(c, request, &services.OAuthError{Code: "login_required", Description: "the user is not signed in"})
+		}
+		return c.Redirect(http.StatusSeeOther, getIssuerPath(c)+"/login?return_to="+url.QueryEscape(c.Request().URL.RequestURI()))
+	}
+
+	code, err := oauthService.CreateCode(tenant, client, session, request)
+	if err != nil {
+		return err
+	}
+
+	return redirectWith(c, request.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {request.State},
+		"iss":   {getIssuerFromContext(c)},
+	})
+}
+
+// authorizeFailed shows errors that must not be sent to an unverified redirect_uri
+func (h *HostedHandler) authorizeFailed(c *echo.Context, err error) error {
+	page := hosted.Page{Title: "Sign in"}
+
+	var oauthErr *services.OAuthError
+	switch {
+	case errors.Is(err, services.ErrInvalidClient):
+		page.Error = "This application is not available."
+		return h.render(c, http.StatusBadRequest, "message", page)
+	case errors.As(err, &oauthErr):
+		page.Error = "The application sent an invalid sign-in request: " + oauthErr.Description + "."
+		return h.render(c, http.StatusBadRequest, "message", page)
+	}
+	return err
+}
+
+// currentSession is the browser's session, nil when it is not signed in. A session
+// without the second factor the tenant requires counts as not signed in, so codes and
+// assertions are only issued after the user signs in again with one.
+func (h *HostedHandler) currentSession(c *echo.Context) (*models.Session, error) {
+	cookie, err := c.Cookie(middleware.UserSessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+
+	tenant := getTenantFromContext(c)
+	session, err := services.NewSessionService(getDBFromContext(c)).Authenticate(tenant, cookie.Value)
+	if errors.Is(err, services.ErrSessionInvalid) {
+		return nil, nil
+	}
+	if err == nil && !services.MFASatisfied(tenant, session.AMR) {
+		return nil, nil
+	}
+	return session, err
+}
+
+// authorizeRedirect sends an error back to the client (RFC 6749 4.1.2.1)
+func authorizeRedirect(c *echo.Context, request services.AuthorizeRequest, err error) error {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		return err
+	}
+
+	return redirectWith(c, request.RedirectURI, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+		"state":             {request.State},
+		"iss":               {getIssuerFromContext(c)},
+	})
+}
+
+// redirectWith adds params to the query of a registered redirect URI, empty values are left out
+func redirectWith(c *echo.Context, redirectURI string, params url.Values) error {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return err
+	}
+
+	query := target.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query[name] = values
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	return c.Redirect(http.StatusFound, target.String())
+}
+
+// Token is the token endpoint. Clients authenticate with HTTP Basic or the
+// client_id and client_secret form fields, public clients with client_id alone.
+func (h *IssuerHandler) Token(c *echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	clientID, secret, basic := c.Request().BasicAuth()
+	if basic {
+		// Basic credentials are form encoded first (RFC 6749 2.3.1)
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			return tokenError(c, &services.OAuthError{Code: "invalid_client", Description: "client authentication failed"})
+		}
+	} else {
+		clientID = c.FormValue("client_id")
+		secret = c.FormValue("client_secret")
+	}
+
+	tenant := getTenantFromContext(c)
+	oauthService := services.NewOAuthService(getDBFromContext(c))
+
+	client, err := oauthService.AuthenticateClient(tenant, clientID, secret)
+	if err != nil {
+		return tokenError(c, err)
+	}
+
+	var response *services.TokenResponse
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		response, err = oauthService.ExchangeCode(tenant, getIssuerFromContext(c), client, c.FormValue("code"), c.FormValue("redirect_uri"), c.FormValue("code_verifier"))
+	case "refresh_token":
+		response, err = oauthService.Refresh(tenant, getIssuerFromContext(c), client, c.FormValue("refresh_token"))
+	default:
+		err = &services.OAuthError{Code: "unsupported_grant_type", Description: "grant_type must be authorization_code or refresh_token"}
+	}
+	if err != nil {
+		return tokenError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// JWKS serves the public keys of the tenant's tokens
+func (h *IssuerHandler) JWKS(c *echo.Context) error {
+	keys, err := services.NewSigningKeyService(getDBFromContext(c)).VerificationKeys(getTenantFromContext(c).ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	set := make([]jwt.JWK, 0, len(ids))
+	for _, id := range ids {
+		set = append(set, jwt.PublicJWK(id, keys[id]))
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"keys": set,
+	})
+}
+
+// tokenError answers in the format of RFC 6749 5.2
+func tokenError(c *echo.Context, err error) error {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":             "server_error",
+			"error_description": err.Error(),
+		})
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+
+	return c.JSON(status, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestAuthorizeRequiresMFA(t *testing.T) {
+	tenant := &models.Tenant{ID: uuid.New(), Slug: "acme", Settings: models.DefaultTenantSettings()}
+	tenant.Settings.MFA = models.MFASettings{Required: true, Methods: []string{"totp"}}
+	userID := uuid.New()
+
+	// A password session from before the tenant required MFA does not get a code
+	tests := []struct {
+		prompt   string
+		location string
+	}{
+		{location: "/t/acme/login?return_to="},
+		{prompt: "none", location: "https://app.example.com/callback?error=login_required"},
+	}
+
+	for _, test := range tests {
+		t.Run("prompt="+test.prompt, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			mock.ExpectQuery(`SELECT \* FROM "clients" WHERE tenant_id = \$1 AND client_id = \$2`).
+				WithArgs(tenant.ID, "app", 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "client_id", "tenant_id", "redirect_uris", "grant_types", "response_types", "scopes", "is_confidential", "status"}).
+					AddRow(uuid.New(), "app", tenant.ID, "{https://app.example.com/callback}", "{authorization_code}", "{code}", "{openid}", true, "active"))
+			mock.ExpectQuery(`SELECT \* FROM "sessions" WHERE token_hash = \$1 AND revoked_at IS NULL`).
+				WithArgs(tokens.Hash("session-1"), 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amr", "expires_at", "last_activity_at"}).
+					AddRow(uuid.New(), userID, "{pwd}", time.Now().Add(time.Hour), time.Now()))
+			mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "status"}).AddRow(userID, tenant.ID, "active"))
+
+			query := url.Values{
+				"response_type": {"code"},
+				"client_id":     {"app"},
+				"redirect_uri":  {"https://app.example.com/callback"},
+				"scope":         {"openid"},
+				"prompt":        {test.prompt},
+			}
+			c, rec := newContext(db, nil, http.MethodGet, "/t/acme/authorize?"+query.Encode(), nil)
+			c.Request().AddCookie(&http.Cookie{Name: middleware.UserSessionCookie, Value: "session-1"})
+			c.Set("tenant", tenant)
+			c.Set("issuer", "https://auth.example.com/t/acme")
+
+			if err := NewHostedHandler().Authorize(c); err != nil {
+				t.Fatalf("Authorize = %v", err)
+			}
+
+			location := rec.Header().Get("Location")
+			if rec.Code/100 != 3 || !strings.HasPrefix(location, test.location) {
+				t.Fatalf("Authorize = %d to %s, want a redirect to %s...", rec.Code, location, test.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/webauthn"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// PasskeyRegistrationOptions starts adding a passkey to the signed in user
+func (h *UserHandler) PasskeyRegistrationOptions(c *echo.Context) error {
+	tenant := getTenantFromContext(c)
+	if !services.PasskeysAllowed(tenant) {
+		return passkeyError(c, services.ErrMFAMethodNotAllowed)
+	}
+
+	cfg, err := passkeyConfig(c)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	options, err := passkeyService.RegistrationOptions(cfg, tenant, getUserFromContext(c))
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+func (h *UserHandler) RegisterPasskey(c *echo.Context) error {
+	var req struct {
+		Name       string                        `json:"name"`
+		Credential webauthn.RegistrationResponse `json:"credential"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	cfg, err := passkeyConfig(c)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	credential, err := passkeyService.Register(cfg, getTenantFromContext(c), getUserFromContext(c), req.Credential, req.Name)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, credential)
+}
+
+func (h *UserHandler) ListPasskeys(c *echo.Context) error {
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	credentials, err := passkeyService.ListCredentials(getUserFromContext(c).ID)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"passkeys": credentials,
+	})
+}
+
+func (h *UserHandler) DeletePasskey(c *echo.Context) error {
+	credentialID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid passkey ID",
+		})
+	}
+
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	if err := passkeyService.DeleteCredential(getUserFromContext(c), credentialID); err != nil {
+		return passkeyError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PasskeySignupOptions starts a passwordless registration
+func (h *UserHandler) PasskeySignupOptions(c *echo.Context) error {
+	var req services.SignupInput
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenant := getTenantFromContext(c)
+	if !services.PasskeysAllowed(tenant) {
+		return passkeyError(c, services.ErrMFAMethodNotAllowed)
+	}
+
+	cfg, err := passkeyConfig(c)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	options, err := passkeyService.SignupOptions(cfg, tenant, req)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+// PasskeySignup creates a passkey only user and signs them in. The email was fixed
+// when the options were issued and still has to be verified.
+func (h *UserHandler) PasskeySignup(c *echo.Context) error {
+	var req struct {
+		services.SignupInput
+		ClientID   string                        `json:"client_id"`
+		Credential webauthn.RegistrationResponse `json:"credential"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	clientID, err := lookupSessionClient(c, req.ClientID)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	cfg, err := passkeyConfig(c)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	tenant := getTenantFromContext(c)
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	user, err := passkeyService.Signup(cfg, tenant, req.SignupInput, req.Credential)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	// The account exists and the passkey works, a mail problem should not fail the signup
+	if err := sendUserVerification(c, user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.PasskeySession(tenant, user, clientID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	setUserSessionCookie(c, session, token)
+
+	return c.JSON(http.StatusCreated, map[string]any{
+		"user":       user,
+		"expires_at": session.ExpiresAt,
+		"csrf_token": session.CSRFToken,
+	})
+}
+
+// PasskeyLoginOptions starts a passkey sign-in, email is optional
+func (h *UserHandler) PasskeyLoginOptions(c *echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenant := getTenantFromContext(c)
+	if !services.PasskeysAllowed(tenant) {
+		return passkeyError(c, services.ErrMFAMethodNotAllowed)
+	}
+
+	cfg, err := passkeyConfig(c)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	passkeyService := services.NewPasskeyService(getDBFromContext(c))
+	options, err := passkeyService.LoginOptions(cfg, tenant, req.Email)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+func (h *UserHandler) PasskeyLogin(c *echo.Context) error {
+	var req struct {
+		ClientID   string                     `json:"client_id"`
+		Credential webauthn.AssertionResponse `json:"credential"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	clientID, err := lookupSessionClient(c, req.ClientID)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	cfg, err := passkeyConfig(c)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.LoginWithPasskey(getTenantFromContext(c), cfg, req.Credential, clientID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return passkeyError(c, err)
+	}
+
+	setUserSessionCookie(c, session, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"user_id":    session.UserID,
+		"expires_at": session.ExpiresAt,
+		"csrf_token": session.CSRFToken,
+		"amr":        session.AMR,
+	})
+}
+
+// passkeyConfig derives the relying party from the issuer, so passkeys follow the
+// host the tenant is served on (shared host or custom domain)
+func passkeyConfig(c *echo.Context) (webauthn.Config, error) {
+	issuer, err := url.Parse(getIssuerFromContext(c))
+	if err != nil {
+		return webauthn.Config{}, err
+	}
+
+	return webauthn.Config{
+		RPID:   issuer.Hostname(),
+		RPName: tenantDisplayName(getTenantFromContext(c)),
+		Origin: issuer.Scheme + "://" + issuer.Host,
+	}, nil
+}
+
+func passkeyError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPasskeyInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": services.ErrPasskeyInvalid.Error(),
+		})
+	case errors.Is(err, services.ErrMFAMethodNotAllowed):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrPasskeyAlreadyRegistered),
+		errors.Is(err, services.ErrLastSignInMethod):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Passkey not found",
+		})
+	}
+
+	return userError(c, err)
+}
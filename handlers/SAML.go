@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/hosted"
+	"DigiPassAuthenticationApi/packages/saml"
+	"DigiPassAuthenticationApi/services"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// samlEntityID is the tenant's SAML entity ID, the URL its metadata is served at
+func samlEntityID(c *echo.Context) string {
+	return getIssuerFromContext(c) + "/saml/metadata"
+}
+
+// SAMLMetadata publishes the tenant's identity provider metadata for service providers to import
+func (h *HostedHandler) SAMLMetadata(c *echo.Context) error {
+	tenant := getTenantFromContext(c)
+
+	signer, err := services.NewSigningKeyService(getDBFromContext(c)).ActiveKey(tenant)
+	if err != nil {
+		return err
+	}
+
+	issuer := getIssuerFromContext(c)
+	metadata := &saml.IdentityProviderMetadata{
+		EntityID:      samlEntityID(c),
+		SSOURL:        issuer + "/saml/sso",
+		SLOURL:        issuer + "/saml/slo",
+		Certificates:  []*x509.Certificate{signer.Certificate},
+		NameIDFormats: []string{saml.NameIDFormatEmail, saml.NameIDFormatPersist},
+	}
+
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", []byte(metadata.Element().Canonical()))
+}
+
+// SAMLSSO receives an AuthnRequest with the HTTP-Redirect or HTTP-POST binding and
+// continues at SAMLResume, where the session cookie is sent even after a cross site post
+func (h *HostedHandler) SAMLSSO(c *echo.Context) error {
+	var message []byte
+	var rawQuery string
+	var err error
+
+	if c.Request().Method == http.MethodPost {
+		message, err = saml.DecodePost(c.FormValue("SAMLRequest"))
+	} else {
+		message, err = saml.DecodeRedirect(c.QueryParam("SAMLRequest"))
+		rawQuery = c.Request().URL.RawQuery
+	}
+
+	if err != nil {
+		return h.samlFailed(c, services.ErrSAMLRequestInvalid)
+	}
+
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	request, err := samlService.ReceiveAuthnRequest(getTenantFromContext(c), message, rawQuery, getIssuerFromContext(c)+"/saml/sso", c.FormValue("RelayState"))
+	if err != nil {
+		return h.samlFailed(c, err)
+	}
+	return h.resumeSAML(c, request)
+}
+
+// SAMLIdPInitiated signs the user in to a service provider from a link on the IdP side
+func (h *HostedHandler) SAMLIdPInitiated(c *echo.Context) error {
+	providerID, err := uuid.Parse(c.Param("spId"))
+	if err != nil {
+		return h.samlFailed(c, services.ErrRecordNotFound)
+	}
+
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	request, err := samlService.StartIdPInitiated(getTenantFromContext(c), providerID, c.QueryParam("RelayState"))
+	if err != nil {
+		return h.samlFailed(c, err)
+	}
+	return h.resumeSAML(c, request)
+}
+
+func (h *HostedHandler) resumeSAML(c *echo.Context, request *services.SAMLRequest) error {
+	token, err := request.Token()
+	if err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, samlResumeURL(c, token))
+}
+
+func samlResumeURL(c *echo.Context, token string) string {
+	return getIssuerPath(c) + "/saml/sso/resume?request=" + url.QueryEscape(token)
+}
+
+// SAMLResume answers a pending request once the browser has a session, sending the
+// user to sign in first when it has none
+func (h *HostedHandler) SAMLResume(c *echo.Context) error {
+	token := c.QueryParam("request")
+	request, err := services.ParseSAMLRequestToken(token)
+	if err != nil {
+		return h.samlFailed(c, err)
+	}
+
+	tenant := getTenantFromContext(c)
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	resumeURL := samlResumeURL(c, token)
+
+	session, err := h.currentSession(c)
+	if err != nil {
+		return err
+	}
+
+	// ForceAuthn wants a sign-in that happened after the request arrived
+	stale := session != nil && request.ForceAuthn && session.CreatedAt.Before(time.Unix(request.ReceivedAt, 0))
+
+	if session == nil || stale {
+		if request.IsPassive {
+			response, err := samlService.ErrorResponse(tenant, request, samlEntityID(c), saml.StatusNoPassive)
+			if err != nil {
+				return h.samlFailed(c, err)
+			}
+			return h.samlPost(c, response)
+		}
+
+		if stale {
+			return h.render(c, http.StatusOK, "login", hosted.Page{
+				Title:    "Sign in",
+				ReturnTo: resumeURL,
+				Email:    session.User.Email,
+				Error:    "The application asks you to sign in again.",
+			})
+		}
+		return c.Redirect(http.StatusSeeOther, getIssuerPath(c)+"/login?return_to="+url.QueryEscape(resumeURL))
+	}
+
+	response, err := samlService.IssueResponse(tenant, session, request, samlEntityID(c))
+	if err != nil {
+		return h.samlFailed(c, err)
+	}
+	return h.samlPost(c, response)
+}
+
+// SAMLSLO receives a LogoutRequest from a service provider, or the LogoutResponse
+// answering one sent during single logout
+func (h *HostedHandler) SAMLSLO(c *echo.Context) error {
+	post := c.Request().Method == http.MethodPost
+	decode := func(param string) ([]byte, error) {
+		if post {
+			return saml.DecodePost(c.FormValue(param))
+		}
+		return saml.DecodeRedirect(c.QueryParam(param))
+	}
+
+	if c.FormValue("SAMLResponse") != "" {
+		// Responses only arrive in the frames of the logout page, nothing waits for them
+		return h.render(c, http.StatusOK, "message", hosted.Page{
+			Title:   "Signed out",
+			Message: "You have been signed out.",
+		})
+	}
+
+	message, err := decode("SAMLRequest")
+	if err != nil {
+		return h.samlFailed(c, services.ErrSAMLRequestInvalid)
+	}
+
+	var rawQuery string
+	if !post {
+		rawQuery = c.Request().URL.RawQuery
+	}
+
+	tenant := getTenantFromContext(c)
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	logout, err := samlService.ReceiveLogoutRequest(tenant, message, rawQuery, getIssuerFromContext(c)+"/saml/slo", c.FormValue("RelayState"), samlEntityID(c))
+	if err != nil {
+		return h.samlFailed(c, err)
+	}
+
+	// The cookie only comes along with the redirect binding, drop it once its session ended
+	if cookie, err := c.Cookie(middleware.UserSessionCookie); err == nil {
+		if _, err := services.NewSessionService(getDBFromContext(c)).Authenticate(tenant, cookie.Value); err != nil {
+			clearUserSessionCookie(c)
+		}
+	}
+
+	page := hosted.Page{
+		Title:    "Signing out",
+		Message:  "You are being signed out of your applications.",
+		ReturnTo: getIssuerPath(c) + "/login",
+		Logouts:  samlForms(logout.Notify),
+	}
+
+	if logout.Response != nil {
+		if logout.Response.Fields != nil {
+			page.Post = &hosted.Form{Action: logout.Response.URL, Fields: logout.Response.Fields}
+		} else {
+			page.ReturnTo = logout.Response.URL
+		}
+	}
+	return h.render(c, http.StatusOK, "saml_logout", page)
+}
+
+func (h *HostedHandler) samlPost(c *echo.Context, response *services.SAMLMessage) error {
+	return h.render(c, http.StatusOK, "saml_post", hosted.Page{
+		Title:   "Signing in",
+		Message: "Taking you back to the application.",
+		Post:    &hosted.Form{Action: response.URL, Fields: response.Fields},
+	})
+}
+
+func samlForms(messages []services.SAMLMessage) []hosted.Form {
+	forms := make([]hosted.Form, 0, len(messages))
+	for _, message := range messages {
+		forms = append(forms, hosted.Form{Action: message.URL, Fields: message.Fields})
+	}
+	return forms
+}
+
+func (h *HostedHandler) samlFailed(c *echo.Context, err error) error {
+	page := hosted.Page{Title: "Sign in"}
+
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		page.Error = "This application is not available."
+		return h.render(c, http.StatusNotFound, "message", page)
+	case errors.Is(err, services.ErrSAMLRequestInvalid):
+		log.Printf("SAML request rejected: %v", err)
+		page.Error = "The application sent a request that is invalid or expired, go back to it and try again."
+		return h.render(c, http.StatusBadRequest, "message", page)
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// SAMLServiceProviderHandler manages the applications signing in through the
+// tenant's SAML identity provider, whose metadata is at {issuer}/saml/metadata.
+type SAMLServiceProviderHandler struct{}
+
+func NewSAMLServiceProviderHandler() *SAMLServiceProviderHandler {
+	return &SAMLServiceProviderHandler{}
+}
+
+func (h *SAMLServiceProviderHandler) List(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	providers, err := samlService.ListServiceProviders(tenant.ID)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, providers)
+}
+
+func (h *SAMLServiceProviderHandler) Create(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	var req services.SAMLServiceProviderInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	provider, err := samlService.CreateServiceProvider(tenant.ID, req)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, provider)
+}
+
+func (h *SAMLServiceProviderHandler) Update(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	providerID, err := uuid.Parse(c.Param("spId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service provider id",
+		})
+	}
+
+	var req services.SAMLServiceProviderInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	provider, err := samlService.UpdateServiceProvider(tenant.ID, providerID, req)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, provider)
+}
+
+func (h *SAMLServiceProviderHandler) Delete(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	providerID, err := uuid.Parse(c.Param("spId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service provider id",
+		})
+	}
+
+	samlService := services.NewSAMLService(getDBFromContext(c))
+	if err := samlService.DeleteServiceProvider(tenant.ID, providerID); err != nil {
+		return samlServiceProviderError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func samlServiceProviderError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrSAMLServiceProviderInvalid):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrSAMLEntityIDTaken):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
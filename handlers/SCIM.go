@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/scim"
+	"DigiPassAuthenticationApi/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// maxSCIMBodySize bounds a request body, a PUT of a large group is the biggest
+const maxSCIMBodySize = 4 << 20
+
+// SCIMHandler serves the SCIM 2.0 provisioning API of a tenant issuer under
+// {issuer}/scim/v2 (RFC 7644). Clients authenticate with a SCIM token issued in the
+// console. The userName of a user is its sign-in email, and deleting a user suspends it.
+type SCIMHandler struct{}
+
+func NewSCIMHandler() *SCIMHandler {
+	return &SCIMHandler{}
+}
+
+func (h *SCIMHandler) ServiceProviderConfig(c *echo.Context) error {
+	return scimJSON(c, http.StatusOK, scim.NewServiceProviderConfig(scimBase(c), services.SCIMMaxCount))
+}
+
+func (h *SCIMHandler) ResourceTypes(c *echo.Context) error {
+	var resources []any
+	for _, resourceType := range scim.NewResourceTypes(scimBase(c)) {
+		resources = append(resources, resourceType)
+	}
+	return scimJSON(c, http.StatusOK, scim.NewListResponse(int64(len(resources)), 1, resources))
+}
+
+func (h *SCIMHandler) Schemas(c *echo.Context) error {
+	var resources []any
+	for _, schema := range scim.NewSchemas(scimBase(c)) {
+		resources = append(resources, schema)
+	}
+	return scimJSON(c, http.StatusOK, scim.NewListResponse(int64(len(resources)), 1, resources))
+}
+
+func (h *SCIMHandler) ListUsers(c *echo.Context) error {
+	query, err := scimQuery(c)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	users, total, err := scimService.ListUsers(getTenantFromContext(c).ID, query)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	withGroups := !scimExcluded(c, "groups")
+	groups := map[uuid.UUID][]models.Group{}
+	if withGroups {
+		userIDs := make([]uuid.UUID, 0, len(users))
+		for _, user := range users {
+			userIDs = append(userIDs, user.ID)
+		}
+		if groups, err = scimService.GroupsOfUsers(userIDs); err != nil {
+			return scimError(c, err)
+		}
+	}
+
+	resources := make([]any, 0, len(users))
+	for i := range users {
+		resources = append(resources, renderSCIMUser(scimBase(c), &users[i], groups[users[i].ID]))
+	}
+	return scimJSON(c, http.StatusOK, scim.NewListResponse(total, query.StartIndex, resources))
+}
+
+func (h *SCIMHandler) GetUser(c *echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	user, err := scimService.GetUser(getTenantFromContext(c).ID, userID)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimUserResponse(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) CreateUser(c *echo.Context) error {
+	var req scim.User
+	if err := decodeSCIM(c, &req); err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	user, err := scimService.CreateUser(getTenantFromContext(c), req)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimUserResponse(c, http.StatusCreated, user)
+}
+
+func (h *SCIMHandler) ReplaceUser(c *echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	var req scim.User
+	if err := decodeSCIM(c, &req); err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	user, err := scimService.ReplaceUser(getTenantFromContext(c).ID, userID, c.Request().Header.Get("If-Match"), req)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimUserResponse(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) PatchUser(c *echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	var req scim.PatchRequest
+	if err := decodeSCIMPatch(c, &req); err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	user, err := scimService.PatchUser(getTenantFromContext(c).ID, userID, c.Request().Header.Get("If-Match"), req.Operations)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimUserResponse(c, http.StatusOK, user)
+}
+
+// DeleteUser deprovisions the user: it is suspended and signed out everywhere
+func (h *SCIMHandler) DeleteUser(c *echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	if err := scimService.DeprovisionUser(getTenantFromContext(c).ID, userID, c.Request().Header.Get("If-Match")); err != nil {
+		return scimError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) ListGroups(c *echo.Context) error {
+	query, err := scimQuery(c)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	groups, total, err := scimService.ListGroups(getTenantFromContext(c).ID, query)
+	if err != nil {
+		return scimError(c, err)
+	}
+
+	withMembers := !scimExcluded(c, "members")
+	members := map[uuid.UUID][]models.User{}
+	if withMembers {
+		groupIDs := make([]uuid.UUID, 0, len(groups))
+		for _, group := range groups {
+			groupIDs = append(groupIDs, group.ID)
+		}
+		if members, err = scimService.MembersOfGroups(groupIDs); err != nil {
+			return scimError(c, err)
+		}
+	}
+
+	resources := make([]any, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, renderSCIMGroup(scimBase(c), &groups[i], members[groups[i].ID]))
+	}
+	return scimJSON(c, http.StatusOK, scim.NewListResponse(total, query.StartIndex, resources))
+}
+
+func (h *SCIMHandler) GetGroup(c *echo.Context) error {
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	group, err := scimService.GetGroup(getTenantFromContext(c).ID, groupID)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimGroupResponse(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) CreateGroup(c *echo.Context) error {
+	var req scim.Group
+	if err := decodeSCIM(c, &req); err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	group, err := scimService.CreateGroup(getTenantFromContext(c).ID, req)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimGroupResponse(c, http.StatusCreated, group)
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *echo.Context) error {
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	var req scim.Group
+	if err := decodeSCIM(c, &req); err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	group, err := scimService.ReplaceGroup(getTenantFromContext(c).ID, groupID, c.Request().Header.Get("If-Match"), req)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimGroupResponse(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) PatchGroup(c *echo.Context) error {
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	var req scim.PatchRequest
+	if err := decodeSCIMPatch(c, &req); err != nil {
+		return scimError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	group, err := scimService.PatchGroup(getTenantFromContext(c).ID, groupID, c.Request().Header.Get("If-Match"), req.Operations)
+	if err != nil {
+		return scimError(c, err)
+	}
+	return scimGroupResponse(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *echo.Context) error {
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return scimError(c, services.ErrRecordNotFound)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	if err := scimService.DeleteGroup(getTenantFromContext(c).ID, groupID, c.Request().Header.Get("If-Match")); err != nil {
+		return scimError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func scimUserResponse(c *echo.Context, status int, user *models.User) error {
+	var groups []models.Group
+	if !scimExcluded(c, "groups") {
+		groupsOfUsers, err := services.NewSCIMService(getDBFromContext(c)).GroupsOfUsers([]uuid.UUID{user.ID})
+		if err != nil {
+			return scimError(c, err)
+		}
+		groups = groupsOfUsers[user.ID]
+	}
+
+	resource := renderSCIMUser(scimBase(c), user, groups)
+	return scimResource(c, status, resource, resource.Meta)
+}
+
+func scimGroupResponse(c *echo.Context, status int, group *models.Group) error {
+	var members []models.User
+	if !scimExcluded(c, "members") {
+		membersOfGroups, err := services.NewSCIMService(getDBFromContext(c)).MembersOfGroups([]uuid.UUID{group.ID})
+		if err != nil {
+			return scimError(c, err)
+		}
+		members = membersOfGroups[group.ID]
+	}
+
+	resource := renderSCIMGroup(scimBase(c), group, members)
+	return scimResource(c, status, resource, resource.Meta)
+}
+
+// scimResource answers with a single resource and its ETag. A GET whose If-None-Match
+// lists the current version gets 304 Not Modified.
+func scimResource(c *echo.Context, status int, resource any, meta *scim.Meta) error {
+	c.Response().Header().Set("ETag", meta.Version)
+
+	if status == http.StatusCreated {
+		c.Response().Header().Set("Location", meta.Location)
+	}
+
+	if ifNoneMatch := c.Request().Header.Get("If-None-Match"); c.Request().Method == http.MethodGet && ifNoneMatch != "" {
+		if scim.MatchesVersion(ifNoneMatch, meta.Version) {
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+	return scimJSON(c, status, resource)
+}
+
+func renderSCIMUser(base string, user *models.User, groups []models.Group) scim.User {
+	active := scim.Bool(user.Status == "active")
+	displayName := strings.TrimSpace(user.GivenName + " " + user.FamilyName)
+
+	resource := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          user.ID.String(),
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		DisplayName: displayName,
+		Emails:      []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Locale:      user.Locale,
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     base + "/Users/" + user.ID.String(),
+			Version:      scim.Version(user.UpdatedAt),
+		},
+	}
+
+	if user.GivenName != "" || user.FamilyName != "" {
+		resource.Name = &scim.Name{Formatted: displayName, GivenName: user.GivenName, FamilyName: user.FamilyName}
+	}
+
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, scim.Reference{
+			Value:   group.ID.String(),
+			Ref:     base + "/Groups/" + group.ID.String(),
+			Display: group.Name,
+		})
+	}
+	return resource
+}
+
+func renderSCIMGroup(base string, group *models.Group, members []models.User) scim.Group {
+	resource := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          group.ID.String(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.Name,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     base + "/Groups/" + group.ID.String(),
+			Version:      scim.Version(group.UpdatedAt),
+		},
+	}
+
+	for _, member := range members {
+		resource.Members = append(resource.Members, scim.Reference{
+			Value:   member.ID.String(),
+			Ref:     base + "/Users/" + member.ID.String(),
+			Display: member.Email,
+			Type:    "User",
+		})
+	}
+	return resource
+}
+
+func scimBase(c *echo.Context) string {
+	return getIssuerFromContext(c) + "/scim/v2"
+}
+
+// scimQuery reads the filter, startIndex and count parameters of a list request
+func scimQuery(c *echo.Context) (services.SCIMQuery, error) {
+	startIndex, count := 1, services.SCIMDefaultCount
+
+	if value := c.QueryParam("startIndex"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return services.SCIMQuery{}, fmt.Errorf("%w: startIndex must be an integer", services.ErrSCIMInvalidValue)
+		}
+		startIndex = parsed
+	}
+
+	if value := c.QueryParam("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return services.SCIMQuery{}, fmt.Errorf("%w: count must be an integer", services.ErrSCIMInvalidValue)
+		}
+		count = parsed
+	}
+
+	return services.NewSCIMQuery(c.QueryParam("filter"), startIndex, count), nil
+}
+
+// scimExcluded reports whether the excludedAttributes parameter names attribute
+func scimExcluded(c *echo.Context, attribute string) bool {
+	for _, excluded := range strings.Split(c.QueryParam("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(excluded), attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSCIM reads a JSON body whatever its content type, clients send application/scim+json
+func decodeSCIM(c *echo.Context, v any) error {
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxSCIMBodySize)
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("%w: request body is not a valid resource", scim.ErrInvalidSyntax)
+	}
+	return nil
+}
+
+func decodeSCIMPatch(c *echo.Context, req *scim.PatchRequest) error {
+	if err := decodeSCIM(c, req); err != nil {
+		return err
+	}
+
+	if len(req.Operations) == 0 {
+		return fmt.Errorf("%w: Operations is required", scim.ErrInvalidSyntax)
+	}
+	return nil
+}
+
+func scimJSON(c *echo.Context, status int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, scim.ContentType, body)
+}
+
+func scimError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return scimJSON(c, http.StatusNotFound, scim.NewError(http.StatusNotFound, "", "Resource not found"))
+	case errors.Is(err, services.ErrUserAlreadyExists),
+		errors.Is(err, services.ErrGroupNameTaken):
+		return scimJSON(c, http.StatusConflict, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, err.Error()))
+	case errors.Is(err, services.ErrSCIMVersionMismatch):
+		return scimJSON(c, http.StatusPreconditionFailed, scim.NewError(http.StatusPreconditionFailed, "", err.Error()))
+	case errors.Is(err, services.ErrSCIMInvalidValue):
+		return scimJSON(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	case errors.Is(err, scim.ErrInvalidFilter):
+		return scimJSON(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidFilter, err.Error()))
+	case errors.Is(err, scim.ErrInvalidPath):
+		return scimJSON(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, err.Error()))
+	case errors.Is(err, scim.ErrNoTarget):
+		return scimJSON(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorNoTarget, err.Error()))
+	case errors.Is(err, scim.ErrInvalidSyntax):
+		return scimJSON(c, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, err.Error()))
+	}
+
+	return scimJSON(c, http.StatusInternalServerError, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// SCIMTokenHandler manages the bearer tokens a tenant gives its provisioning clients
+// for the SCIM API at {issuer}/scim/v2
+type SCIMTokenHandler struct{}
+
+func NewSCIMTokenHandler() *SCIMTokenHandler {
+	return &SCIMTokenHandler{}
+}
+
+func (h *SCIMTokenHandler) List(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return scimTokenError(c, err)
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	scimTokens, err := scimService.ListTokens(tenant.ID)
+	if err != nil {
+		return scimTokenError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, scimTokens)
+}
+
+// Create returns the token itself once, only its hash is kept
+func (h *SCIMTokenHandler) Create(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return scimTokenError(c, err)
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	scimToken, token, err := scimService.CreateToken(tenant.ID, req.Name)
+	if err != nil {
+		return scimTokenError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]any{
+		"scim_token": scimToken,
+		"token":      token,
+		"base_url":   publicURL("/t/" + tenant.Slug + "/scim/v2"),
+	})
+}
+
+func (h *SCIMTokenHandler) Delete(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return scimTokenError(c, err)
+	}
+
+	tokenID, err := uuid.Parse(c.Param("tokenId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid SCIM token id",
+		})
+	}
+
+	scimService := services.NewSCIMService(getDBFromContext(c))
+	if err := scimService.DeleteToken(tenant.ID, tokenID); err != nil {
+		return scimTokenError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func scimTokenError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrSCIMInvalidValue):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
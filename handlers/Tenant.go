@@ -1,7 +1,239 @@
 package handlers
 
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
 type TenantHandler struct{}
 
 func NewTenantHandler() *TenantHandler {
 	return &TenantHandler{}
 }
+
+func (h *TenantHandler) List(c *echo.Context) error {
+	accountUser := getAccountUserFromContext(c)
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenants, err := tenantService.ListTenants(accountUser.AccountID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, tenants)
+}
+
+func (h *TenantHandler) Get(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenant, err := tenantService.GetTenant(getAccountUserFromContext(c).AccountID, tenantID)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tenant)
+}
+
+func (h *TenantHandler) Create(c *echo.Context) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenant, err := tenantService.CreateTenant(getAccountUserFromContext(c).AccountID, req.Name)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, tenant)
+}
+
+func (h *TenantHandler) Rename(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenant, err := tenantService.RenameTenant(getAccountUserFromContext(c).AccountID, tenantID, req.Name)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tenant)
+}
+
+func (h *TenantHandler) ChangeSlug(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	var req struct {
+		Slug string `json:"slug"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenant, err := tenantService.ChangeSlug(getAccountUserFromContext(c).AccountID, tenantID, req.Slug)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tenant)
+}
+
+func (h *TenantHandler) UpdateStatus(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenant, err := tenantService.UpdateStatus(getAccountUserFromContext(c).AccountID, tenantID, req.Status)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tenant)
+}
+
+func (h *TenantHandler) Delete(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	tenant, err := tenantService.DeleteTenant(getAccountUserFromContext(c).AccountID, tenantID)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"tenant":   tenant,
+		"purge_at": tenant.DeletedAt.Add(services.TenantDeletionGracePeriod),
+	})
+}
+
+func (h *TenantHandler) GetSettings(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	settings, err := tenantService.GetSettings(getAccountUserFromContext(c).AccountID, tenantID)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+func (h *TenantHandler) UpdateSettings(c *echo.Context) error {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tenant id",
+		})
+	}
+
+	// Read the raw body, the patch is merged over the stored settings rather than bound
+	patch, err := io.ReadAll(io.LimitReader(c.Request().Body, 64*1024))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	tenantService := services.NewTenantService(getDBFromContext(c))
+	settings, err := tenantService.UpdateSettings(getAccountUserFromContext(c).AccountID, tenantID, patch)
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+func tenantError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Tenant not found",
+		})
+	case errors.Is(err, services.ErrTenantNameTaken),
+		errors.Is(err, services.ErrSlugTaken),
+		errors.Is(err, services.ErrInvalidStatusTransition):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrTenantInvalid),
+		errors.Is(err, models.ErrInvalidTenantSettings),
+		errors.Is(err, models.ErrSlugFormat),
+		errors.Is(err, models.ErrSlugReserved),
+		errors.Is(err, models.ErrSlugBlocked):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/packages/domains"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+type TenantDomainHandler struct{}
+
+func NewTenantDomainHandler() *TenantDomainHandler {
+	return &TenantDomainHandler{}
+}
+
+func (h *TenantDomainHandler) List(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	domainService := services.NewTenantDomainService(getDBFromContext(c))
+	tenantDomains, err := domainService.ListDomains(tenant.ID)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tenantDomains)
+}
+
+func (h *TenantDomainHandler) Create(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	var req struct {
+		Domain string `json:"domain"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	domainService := services.NewTenantDomainService(getDBFromContext(c))
+	tenantDomain, err := domainService.AddDomain(tenant.ID, req.Domain)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]any{
+		"domain": tenantDomain,
+		"verification_record": map[string]string{
+			"type":  "TXT",
+			"name":  domains.RecordName(tenantDomain.Domain),
+			"value": domains.RecordValue(tenantDomain.VerificationToken),
+		},
+	})
+}
+
+func (h *TenantDomainHandler) Verify(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	domainID, err := uuid.Parse(c.Param("domainId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid domain id",
+		})
+	}
+
+	domainService := services.NewTenantDomainService(getDBFromContext(c))
+	tenantDomain, err := domainService.VerifyDomain(c.Request().Context(), tenant.ID, domainID)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tenantDomain)
+}
+
+func (h *TenantDomainHandler) Delete(c *echo.Context) error {
+	tenant, err := getManagedTenant(c)
+	if err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	domainID, err := uuid.Parse(c.Param("domainId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid domain id",
+		})
+	}
+
+	domainService := services.NewTenantDomainService(getDBFromContext(c))
+	if err := domainService.RemoveDomain(tenant.ID, domainID); err != nil {
+		return tenantDomainError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func tenantDomainError(c *echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	case errors.Is(err, services.ErrDomainTaken):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, domains.ErrInvalidDomain),
+		errors.Is(err, services.ErrDomainNotVerified):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/hosted"
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// UserHandler serves the end user JSON API of a tenant issuer, the hosted pages live in HostedHandler
+type UserHandler struct{}
+
+func NewUserHandler() *UserHandler {
+	return &UserHandler{}
+}
+
+func (h *UserHandler) Register(c *echo.Context) error {
+	var req services.RegisterInput
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	usersService := services.NewUsersService(getDBFromContext(c))
+	user, err := usersService.Register(getTenantFromContext(c), req)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	if err := sendUserVerification(c, user); err != nil {
+		return userError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+func (h *UserHandler) VerifyEmail(c *echo.Context) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	usersService := services.NewUsersService(getDBFromContext(c))
+	user, err := usersService.VerifyEmail(getTenantFromContext(c).ID, req.Token)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification emails a fresh verification link to the signed in User
+func (h *UserHandler) ResendVerification(c *echo.Context) error {
+	user := getUserFromContext(c)
+
+	if user.EmailVerified {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if err := sendUserVerification(c, user); err != nil {
+		return userError(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// ForgotPassword always answers 202 so it cannot be used to find out which emails have an account
+func (h *UserHandler) ForgotPassword(c *echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := requestPasswordReset(c, req.Email); err != nil {
+		return userError(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (h *UserHandler) ResetPassword(c *echo.Context) error {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	usersService := services.NewUsersService(getDBFromContext(c))
+	if _, err := usersService.ResetPassword(getTenantFromContext(c), req.Token, req.Password); err != nil {
+		return userError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *UserHandler) Login(c *echo.Context) error {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		ClientID string `json:"client_id"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	clientID, err := lookupSessionClient(c, req.ClientID)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	sessionService := services.NewSessionService(getDBFromContext(c), getThrottleFromContext(c))
+	session, token, err := sessionService.Login(getTenantFromContext(c), req.Email, req.Password, clientID, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			return mfaChallengeResponse(c, mfaErr)
+		}
+		notifyLockout(c, req.Email, err)
+		return userError(c, err)
+	}
+
+	setUserSessionCookie(c, session, token)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"user_id":    session.UserID,
+		"expires_at": session.ExpiresAt,
+		"csrf_token": session.CSRFToken,
+	})
+}
+
+// Logout ends the session, hosted page forms are redirected back to the login page
+func (h *UserHandler) Logout(c *echo.Context) error {
+	session := getSessionFromContext(c)
+
+	sessionService := services.NewSessionService(getDBFromContext(c))
+	if err := sessionService.Revoke(session.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	clearUserSessionCookie(c)
+
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+		// A browser is here, so the SAML service providers can be signed out as well
+		samlService := services.NewSAMLService(getDBFromContext(c))
+		notify, err := samlService.LogoutSession(getTenantFromContext(c), session.ID, samlEntityID(c))
+		if err != nil {
+			return err
+		}
+
+		if len(notify) > 0 {
+			return NewHostedHandler().render(c, http.StatusOK, "saml_logout", hosted.Page{
+				Title:    "Signing out",
+				Message:  "You are being signed out of your applications.",
+				ReturnTo: getIssuerPath(c) + "/login",
+				Logouts:  samlForms(notify),
+			})
+		}
+		return c.Redirect(http.StatusSeeOther, getIssuerPath(c)+"/login")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *UserHandler) Me(c *echo.Context) error {
+	session := getSessionFromContext(c)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"user":       getUserFromContext(c),
+		"expires_at": session.ExpiresAt,
+		"csrf_token": session.CSRFToken,
+	})
+}
+
+// Authorization returns every group, role and permission the user holds for client_id.
+// Tokens point here when those claims do not fit in them. Called with an access token
+// it answers for the client the token was issued to.
+func (h *UserHandler) Authorization(c *echo.Context) error {
+	db := getDBFromContext(c)
+	tenant := getTenantFromContext(c)
+
+	var client *models.Client
+	if accessToken := getAccessTokenFromContext(c); accessToken != nil {
+		clientID := c.QueryParam("client_id")
+		if clientID != "" && clientID != accessToken.Client.ClientID {
+			return userError(c, services.ErrInvalidClient)
+		}
+		client = &accessToken.Client
+	} else {
+		var err error
+		client, err = services.NewClientService(db).GetClientByClientID(tenant.ID, c.QueryParam("client_id"))
+		if err != nil {
+			if errors.Is(err, services.ErrRecordNotFound) {
+				err = services.ErrInvalidClient
+			}
+			return userError(c, err)
+		}
+	}
+
+	authorizationService := services.NewAuthorizationService(db)
+	claims, err := authorizationService.Claims(tenant.ID, client, getUserFromContext(c).ID)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, claims)
+}
+
+// ChangePassword takes the current password instead of a session so expired passwords can be replaced
+func (h *UserHandler) ChangePassword(c *echo.Context) error {
+	var req struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	usersService := services.NewUsersService(getDBFromContext(c), getThrottleFromContext(c))
+	_, err := usersService.ChangePassword(getTenantFromContext(c), req.Email, req.CurrentPassword, req.NewPassword, c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		notifyLockout(c, req.Email, err)
+		return userError(c, err)
+	}
+
+	clearUserSessionCookie(c)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func sendUserVerification(c *echo.Context, user *models.User) error {
+	tokenService := services.NewUserTokenService(getDBFromContext(c))
+	token, err := tokenService.Issue(user.ID, services.TokenPurposeVerifyEmail, services.EmailVerificationTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := issuerLink(c, "/verify-email", token)
+
+	return getMailerFromContext(c).Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address for " + tenantDisplayName(getTenantFromContext(c)),
+		Body: fmt.Sprintf("Confirm this is your email address:\n\n%s\n\nThis link expires in %s.",
+			link, services.EmailVerificationTokenLifetime),
+	})
+}
+
+// requestPasswordReset emails a reset link when the email belongs to an active User, and silently does nothing otherwise
+func requestPasswordReset(c *echo.Context, email string) error {
+	db := getDBFromContext(c)
+	tenant := getTenantFromContext(c)
+
+	user, err := services.NewUsersService(db).GetUserByEmail(tenant.ID, email)
+	if errors.Is(err, services.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Status != "active" {
+		return nil
+	}
+
+	token, err := services.NewUserTokenService(db).Issue(user.ID, services.TokenPurposeResetPassword, services.PasswordResetTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := issuerLink(c, "/reset-password", token)
+
+	return getMailerFromContext(c).Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password for " + tenantDisplayName(tenant),
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. Choose a new password here:\n\n%s\n\n"+
+			"This link expires in %s. If this wasn't you, you can ignore this email.",
+			link, services.PasswordResetTokenLifetime),
+	})
+}
+
+// notifyLockout emails the unlock link when err is the failure that just locked the
+// account. The login already failed, so a delivery problem is only logged.
+func notifyLockout(c *echo.Context, email string, err error) {
+	var lockedErr *services.LockedError
+	if !errors.As(err, &lockedErr) || !lockedErr.NewlyLocked {
+		return
+	}
+
+	db := getDBFromContext(c)
+	tenant := getTenantFromContext(c)
+
+	user, err := services.NewUsersService(db).GetUserByEmail(tenant.ID, email)
+	if err != nil {
+		return
+	}
+
+	token, err := services.NewUserTokenService(db).Issue(user.ID, services.TokenPurposeUnlockAccount, services.UnlockTokenLifetime)
+	if err == nil {
+		link := issuerLink(c, "/unlock", token)
+		err = getMailerFromContext(c).Send(mailer.Message{
+			To:      user.Email,
+			Subject: "Your account for " + tenantDisplayName(tenant) + " has been locked",
+			Body: fmt.Sprintf("We locked your account until %s after too many failed sign-in attempts.\n\n"+
+				"If this was you, unlock it now:\n\n%s\n\nIf it wasn't, consider resetting your password.",
+				lockedErr.Until.UTC().Format(time.RFC1123), link),
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to send unlock email: %v", err)
+	}
+}
+
+// Unlock lifts a lockout from the emailed link
+func (h *UserHandler) Unlock(c *echo.Context) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	usersService := services.NewUsersService(getDBFromContext(c), getThrottleFromContext(c))
+	if _, err := usersService.Unlock(getTenantFromContext(c), req.Token, c.Request().UserAgent(), c.RealIP()); err != nil {
+		return userError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func tenantDisplayName(tenant *models.Tenant) string {
+	if tenant.Settings.Branding.DisplayName != "" {
+		return tenant.Settings.Branding.DisplayName
+	}
+	return tenant.Name
+}
+
+// lookupSessionClient resolves the optional public client_id a login came from
+func lookupSessionClient(c *echo.Context, clientID string) (*uuid.UUID, error) {
+	if clientID == "" {
+		return nil, nil
+	}
+
+	clientService := services.NewClientService(getDBFromContext(c))
+	client, err := clientService.GetClientByClientID(getTenantFromContext(c).ID, clientID)
+	if err != nil {
+		if errors.Is(err, services.ErrRecordNotFound) {
+			return nil, services.ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	return &client.ID, nil
+}
+
+// The session cookie is scoped to the issuer path so tenants sharing a host never see each other's cookie.
+// Lax rather than Strict because clients send users to /authorize with top level navigations.
+func setUserSessionCookie(c *echo.Context, session *models.Session, token string) {
+	c.SetCookie(&http.Cookie{
+		Name:     middleware.UserSessionCookie,
+		Value:    token,
+		Path:     cookiePath(c),
+		Expires:  session.ExpiresAt,
+		MaxAge:   int(time.Until(session.ExpiresAt).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearUserSessionCookie(c *echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     middleware.UserSessionCookie,
+		Value:    "",
+		Path:     cookiePath(c),
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func cookiePath(c *echo.Context) string {
+	if path := getIssuerPath(c); path != "" {
+		return path
+	}
+	return "/"
+}
+
+func userError(c *echo.Context, err error) error {
+	if isLockout(err) {
+		return lockoutResponse(c, err)
+	}
+
+	var policyErr *services.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error":    services.ErrWeakPassword.Error(),
+			"problems": policyErr.Problems,
+		})
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Invalid email or password",
+		})
+	case errors.Is(err, services.ErrUserSuspended),
+		errors.Is(err, services.ErrPasswordExpired),
+		errors.Is(err, services.ErrMFAPasskeyRequired):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrUserAlreadyExists):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrWeakPassword),
+		errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrTokenInvalid),
+		errors.Is(err, services.ErrInvalidClient):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/mailer"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/services"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v5"
+)
+
+// captured matches any argument and keeps it for the test to inspect
+type captured struct {
+	value driver.Value
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+// expectIssue expects a one time token to be issued for the user and captures its hash
+func expectIssue(mock sqlmock.Sqlmock, userID uuid.UUID, purpose string) *captured {
+	hash := &captured{}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "user_tokens" SET "expires_at"=\$1 WHERE user_id = \$2 AND purpose = \$3 AND used_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), userID, purpose).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WithArgs(userID, purpose, hash, sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	return hash
+}
+
+// tokenLink checks the emailed link points at path under the issuer and returns its token
+func tokenLink(t *testing.T, mail *mailer.MemoryMailer, to string, want string) string {
+	t.Helper()
+
+	link, err := url.Parse(linkIn(t, mail, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Scheme+"://"+link.Host+link.Path != want || link.Query().Get("token") == "" {
+		t.Fatalf("link = %s, want %s?token=...", link, want)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	tenant := &models.Tenant{ID: uuid.New(), Name: "Acme", Settings: models.DefaultTenantSettings()}
+	userID := uuid.New()
+
+	tests := []struct {
+		name   string
+		status string
+		sent   bool
+	}{
+		{name: "active user", status: "active", sent: true},
+		{name: "suspended user", status: "suspended"},
+		{name: "unknown email"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			mail := mailer.NewMemoryMailer()
+
+			rows := sqlmock.NewRows([]string{"id", "tenant_id", "email", "status"})
+			if test.status != "" {
+				rows.AddRow(userID, tenant.ID, "ada@example.com", test.status)
+			}
+			mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND LOWER\(email\) = \$2`).
+				WithArgs(tenant.ID, "ada@example.com", 1).
+				WillReturnRows(rows)
+
+			var hash *captured
+			if test.sent {
+				hash = expectIssue(mock, userID, services.TokenPurposeResetPassword)
+			}
+
+			c, rec := newContext(db, mail, http.MethodPost, "/t/acme/users/password/forgot", strings.NewReader(`{"email":"Ada@Example.com"}`))
+			c.Set("tenant", tenant)
+			c.Set("issuer", "https://auth.example.com/t/acme")
+
+			// The answer is the same either way so it does not reveal which emails have an account
+			if err := NewUserHandler().ForgotPassword(c); err != nil || rec.Code != http.StatusAccepted {
+				t.Fatalf("ForgotPassword = %d, %v", rec.Code, err)
+			}
+
+			if !test.sent {
+				if sent := mail.Messages(); len(sent) != 0 {
+					t.Fatalf("sent %+v", sent)
+				}
+				return
+			}
+
+			token := tokenLink(t, mail, "ada@example.com", "https://auth.example.com/t/acme/reset-password")
+			if hash.value != tokens.Hash(token) {
+				t.Fatalf("emailed a token whose hash %s was not stored", tokens.Hash(token))
+			}
+		})
+	}
+}
+
+func TestPasswordResetLinkIgnoresHost(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "https://auth.example.com")
+	accountID, tenantID, userID := uuid.New(), uuid.New(), uuid.New()
+
+	// The Host header is the caller's to choose, the link must not follow it
+	for _, host := range []string{"auth.example.com", "evil.example", "evil.example:8443"} {
+		t.Run(host, func(t *testing.T) {
+			db, mock := mockDB(t)
+			mail := mailer.NewMemoryMailer()
+
+			mock.ExpectQuery(`SELECT \* FROM "tenants" WHERE slug = \$1`).
+				WithArgs("acme", 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "slug", "name", "status"}).
+					AddRow(tenantID, accountID, "acme", "Acme", "active"))
+			mock.ExpectQuery(`SELECT "status" FROM "accounts" WHERE id = \$1`).
+				WithArgs(accountID).
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("active"))
+			mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND LOWER\(email\) = \$2`).
+				WithArgs(tenantID, "ada@example.com", 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "status"}).
+					AddRow(userID, tenantID, "ada@example.com", "active"))
+			expectIssue(mock, userID, services.TokenPurposeResetPassword)
+
+			e := echo.New()
+			e.POST("/t/:slug/users/password/forgot", NewUserHandler().ForgotPassword, func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c *echo.Context) error {
+					c.Set("db", db)
+					c.Set("mailer", mail)
+					return next(c)
+				}
+			}, middleware.ResolveTenant())
+
+			req := httptest.NewRequest(http.MethodPost, "/t/acme/users/password/forgot", strings.NewReader(`{"email":"ada@example.com"}`))
+			req.Host = host
+			req.Header.Set("X-Forwarded-Proto", "http")
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("ForgotPassword = %d %s", rec.Code, rec.Body)
+			}
+			tokenLink(t, mail, "ada@example.com", "https://auth.example.com/t/acme/reset-password")
+		})
+	}
+}
+
+func TestEmailVerificationLink(t *testing.T) {
+	db, mock := mockDB(t)
+	mail := mailer.NewMemoryMailer()
+	tenant := &models.Tenant{ID: uuid.New(), Name: "Acme", Settings: models.DefaultTenantSettings()}
+	user := &models.User{ID: uuid.New(), TenantID: tenant.ID, Email: "ada@example.com"}
+
+	hash := expectIssue(mock, user.ID, services.TokenPurposeVerifyEmail)
+
+	c, rec := newContext(db, mail, http.MethodPost, "/users/verify-email/resend", nil)
+	c.Set("tenant", tenant)
+	c.Set("issuer", "https://login.acme.com")
+	c.Set("user", user)
+
+	if err := NewUserHandler().ResendVerification(c); err != nil || rec.Code != http.StatusAccepted {
+		t.Fatalf("ResendVerification = %d, %v", rec.Code, err)
+	}
+
+	token := tokenLink(t, mail, "ada@example.com", "https://login.acme.com/verify-email")
+	if hash.value != tokens.Hash(token) {
+		t.Fatalf("emailed a token whose hash %s was not stored", tokens.Hash(token))
+	}
+
+	// Nothing to send once the email is verified
+	user.EmailVerified = true
+	c, rec = newContext(db, mail, http.MethodPost, "/users/verify-email/resend", nil)
+	c.Set("tenant", tenant)
+	c.Set("issuer", "https://login.acme.com")
+	c.Set("user", user)
+
+	if err := NewUserHandler().ResendVerification(c); err != nil || rec.Code != http.StatusNoContent || len(mail.Messages()) != 1 {
+		t.Fatalf("ResendVerification when verified = %d, %v, sent %d", rec.Code, err, len(mail.Messages()))
+	}
+}
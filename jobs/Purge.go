@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/services"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const PurgeInterval = time.Hour
+
+// StartPurger periodically hard deletes resources whose deletion grace period has passed
+func StartPurger(db *gorm.DB, loginThrottle throttle.Store) {
+	go func() {
+		ticker := time.NewTicker(PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			runPurge(db, loginThrottle, time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+func runPurge(db *gorm.DB, loginThrottle throttle.Store, now time.Time) {
+	as := services.NewAccountService(db)
+	purged, err := as.PurgeDeletedAccounts(now)
+	if err != nil {
+		log.Printf("Account purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d deleted accounts", purged)
+	}
+
+	purged, err = as.PurgeUnverifiedAccounts(now)
+	if err != nil {
+		log.Printf("Unverified account purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d unverified accounts", purged)
+	}
+
+	ts := services.NewTenantService(db)
+	purged, err = ts.PurgeDeletedTenants(now)
+	if err != nil {
+		log.Printf("Tenant purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d deleted tenants", purged)
+	}
+
+	purged, err = ts.PurgeExpiredSlugAliases(now)
+	if err != nil {
+		log.Printf("Slug alias purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired slug aliases", purged)
+	}
+
+	purged, err = services.NewUserTokenService(db).PurgeExpiredTokens(now)
+	if err != nil {
+		log.Printf("User token purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired user tokens", purged)
+	}
+
+	purged, err = services.NewPasskeyService(db).PurgeExpiredChallenges(now)
+	if err != nil {
+		log.Printf("Passkey challenge purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired passkey challenges", purged)
+	}
+
+	purged, err = services.NewEmailLoginService(db, loginThrottle).PurgeExpiredChallenges(now)
+	if err != nil {
+		log.Printf("Email sign-in purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired email sign-ins", purged)
+	}
+
+	purged, err = services.NewFederationService(db).PurgeExpiredStates(now)
+	if err != nil {
+		log.Printf("Federation state purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired federation states", purged)
+	}
+
+	purged, err = services.NewFederationService(db).PurgeConsumedAssertions(now)
+	if err != nil {
+		log.Printf("Consumed assertion purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d consumed SAML assertions", purged)
+	}
+
+	purged, err = services.NewLoginGuard(db, loginThrottle).PurgeStale(now)
+	if err != nil {
+		log.Printf("Login throttle purge failed: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d stale login throttle counters", purged)
+	}
+}
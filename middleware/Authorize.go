@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+)
+
+// RequirePermission rejects the request with 403 unless the logged in AccountUser's
+// role grants permission. Must run after RequireConsoleSession.
+func RequirePermission(permission permissions.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			accountUser, ok := c.Get("accountUser").(*models.AccountUser)
+			if !ok || accountUser == nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Not logged in",
+				})
+			}
+
+			if !permissions.Has(accountUser.Role, permission) {
+				return Forbidden(c, permission)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// Forbidden writes the standard 403 body, also used by handlers for checks that
+// depend on the target resource (e.g. only owners may grant the owner role)
+func Forbidden(c *echo.Context, permission permissions.Permission) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error":               "Forbidden",
+		"required_permission": string(permission),
+	})
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v5"
+)
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission permissions.Permission
+		want       int
+	}{
+		{role: "owner", permission: permissions.AccountDelete, want: http.StatusNoContent},
+		{role: "owner", permission: permissions.ClientsManage, want: http.StatusNoContent},
+		{role: "admin", permission: permissions.TenantsManage, want: http.StatusNoContent},
+		{role: "admin", permission: permissions.AccountDelete, want: http.StatusForbidden},
+		{role: "admin", permission: permissions.AccountTransferOwnership, want: http.StatusForbidden},
+		{role: "member", permission: permissions.ClientsRead, want: http.StatusNoContent},
+		{role: "member", permission: permissions.ClientsManage, want: http.StatusForbidden},
+		{role: "member", permission: permissions.AccountUsersManage, want: http.StatusForbidden},
+		{role: "", permission: permissions.AccountRead, want: http.StatusForbidden},
+		{role: "superuser", permission: permissions.AccountRead, want: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		e := echo.New()
+		e.POST("/v1/clients", func(c *echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		}, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c *echo.Context) error {
+				c.Set("accountUser", &models.AccountUser{Role: test.role})
+				return next(c)
+			}
+		}, RequirePermission(test.permission))
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/clients", nil))
+
+		if rec.Code != test.want {
+			t.Errorf("%s with %s: status %d, want %d", test.role, test.permission, rec.Code, test.want)
+			continue
+		}
+
+		// A denied request names the permission it was missing
+		if test.want == http.StatusForbidden {
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["required_permission"] != string(test.permission) {
+				t.Errorf("%s with %s: body %s", test.role, test.permission, rec.Body)
+			}
+		}
+	}
+}
+
+func TestRequirePermissionWithoutSession(t *testing.T) {
+	e := echo.New()
+	e.GET("/v1/account", func(c *echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, RequirePermission(permissions.AccountRead))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/account", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"DigiPassAuthenticationApi/services"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	ConsoleSessionCookie = "digipass_console"
+	CSRFHeader           = "X-CSRF-Token"
+)
+
+// RequireConsoleSession authenticates the console session cookie and puts the
+// session ("consoleSession") and its AccountUser ("accountUser") into the context.
+// State changing requests must echo the session CSRF token in the X-CSRF-Token header.
+func RequireConsoleSession() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			cookie, err := c.Cookie(ConsoleSessionCookie)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Not logged in",
+				})
+			}
+
+			sessionService := services.NewConsoleSessionService(c.Get("db").(*gorm.DB))
+			session, err := sessionService.Authenticate(cookie.Value)
+			if err != nil {
+				if errors.Is(err, services.ErrSessionInvalid) {
+					return c.JSON(http.StatusUnauthorized, map[string]string{
+						"error": "Session is invalid or expired",
+					})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": err.Error(),
+				})
+			}
+
+			if !isSafeMethod(c.Request().Method) {
+				csrfToken := c.Request().Header.Get(CSRFHeader)
+				if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(session.CSRFToken)) != 1 {
+					return c.JSON(http.StatusForbidden, map[string]string{
+						"error": "Missing or invalid CSRF token",
+					})
+				}
+			}
+
+			c.Set("consoleSession", session)
+			c.Set("accountUser", &session.AccountUser)
+			return next(c)
+		}
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
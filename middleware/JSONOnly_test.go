@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v5"
+)
+
+func TestRequireJSON(t *testing.T) {
+	e := echo.New()
+	e.POST("/users/login", func(c *echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, RequireJSON())
+
+	for contentType, want := range map[string]int{
+		"application/json":                  http.StatusNoContent,
+		"application/json; charset=utf-8":   http.StatusNoContent,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+		"multipart/form-data; boundary=x":   http.StatusUnsupportedMediaType,
+		"text/plain":                        http.StatusUnsupportedMediaType,
+		"":                                  http.StatusUnsupportedMediaType,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/login", strings.NewReader(`{}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("Content-Type %q: status %d, want %d", contentType, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/scim"
+	"DigiPassAuthenticationApi/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"gorm.io/gorm"
+)
+
+// RequireSCIMToken authenticates the bearer token of a SCIM client, issued to the
+// tenant in the console, and puts it into the context as "scimToken". It must run
+// after ResolveTenant. Errors use the SCIM error format.
+func RequireSCIMToken() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			scheme, token, _ := strings.Cut(c.Request().Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				token = ""
+			}
+
+			tenant := c.Get("tenant").(*models.Tenant)
+			scimService := services.NewSCIMService(c.Get("db").(*gorm.DB))
+			scimToken, err := scimService.AuthenticateToken(tenant.ID, strings.TrimSpace(token))
+			if err != nil {
+				status, detail := http.StatusInternalServerError, err.Error()
+				if errors.Is(err, services.ErrSCIMTokenInvalid) {
+					c.Response().Header().Set("WWW-Authenticate", `Bearer realm="SCIM"`)
+					status, detail = http.StatusUnauthorized, "Missing or invalid bearer token"
+				}
+
+				body, _ := json.Marshal(scim.NewError(status, "", detail))
+				return c.Blob(status, scim.ContentType, body)
+			}
+
+			c.Set("scimToken", scimToken)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/services"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"gorm.io/gorm"
+)
+
+// ResolveTenant finds the tenant an issuer request is for and puts it into the
+// context as "tenant" along with its issuer URL (services.IssuerURL) as "issuer".
+// Routes mounted under /t/:slug resolve by path, everything else resolves by host
+// ({slug}.TENANT_BASE_DOMAIN or a verified custom domain). Suspended tenants and
+// accounts are rejected and slugs that were changed redirect to the new slug while
+// their alias is live.
+func ResolveTenant() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			db := c.Get("db").(*gorm.DB)
+
+			var tenant *models.Tenant
+			var host string
+			var err error
+
+			tenantService := services.NewTenantService(db)
+
+			if slug := c.Param("slug"); slug != "" {
+				tenant, err = tenantService.GetTenantBySlug(slug)
+
+				// Old slugs redirect to the current one while their alias is live
+				if errors.Is(err, services.ErrRecordNotFound) {
+					if aliased, aliasErr := tenantService.ResolveSlugAlias(slug); aliasErr == nil {
+						target := strings.Replace(c.Request().URL.RequestURI(), "/t/"+slug, "/t/"+aliased.Slug, 1)
+						return c.Redirect(http.StatusTemporaryRedirect, target)
+					}
+				}
+			} else {
+				host = c.Request().Host
+				port := ""
+				if h, p, splitErr := net.SplitHostPort(host); splitErr == nil {
+					host, port = h, ":"+p
+				}
+				tenant, err = services.NewTenantDomainService(db).ResolveHost(host)
+
+				if slug, ok := services.SlugFromHost(host); ok && errors.Is(err, services.ErrRecordNotFound) {
+					if aliased, aliasErr := tenantService.ResolveSlugAlias(slug); aliasErr == nil {
+						target := c.Scheme() + "://" + aliased.Slug + "." + services.TenantBaseDomain() + port + c.Request().URL.RequestURI()
+						return c.Redirect(http.StatusTemporaryRedirect, target)
+					}
+				}
+			}
+
+			if err == nil {
+				err = tenantService.EnsureActive(tenant)
+			}
+
+			if err != nil {
+				switch {
+				case errors.Is(err, services.ErrRecordNotFound):
+					return c.JSON(http.StatusNotFound, map[string]string{
+						"error": "Tenant not found",
+					})
+				case errors.Is(err, services.ErrTenantSuspended),
+					errors.Is(err, services.ErrAccountSuspended),
+					errors.Is(err, services.ErrAccountNotVerified):
+					return c.JSON(http.StatusForbidden, map[string]string{
+						"error": "Tenant is not active",
+					})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": err.Error(),
+				})
+			}
+
+			// Only the host that resolved the tenant goes into the issuer, links built from
+			// it are emailed and must not follow a Host header the caller made up
+			c.Set("tenant", tenant)
+			c.Set("issuer", services.IssuerURL(tenant, host))
+			return next(c)
+		}
+	}
+}
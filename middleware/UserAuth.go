@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/services"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	UserSessionCookie = "digipass_session"
+	CSRFFormField     = "csrf_token"
+)
+
+// RequireUserSession authenticates an end user session cookie on a tenant issuer
+// and puts the session ("session") and its User ("user") into the context. It must
+// run after ResolveTenant. State changing requests must send the session CSRF token
+// in the X-CSRF-Token header or, for hosted page forms, the csrf_token field.
+func RequireUserSession() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			cookie, err := c.Cookie(UserSessionCookie)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Not logged in",
+				})
+			}
+
+			tenant := c.Get("tenant").(*models.Tenant)
+			sessionService := services.NewSessionService(c.Get("db").(*gorm.DB))
+			session, err := sessionService.Authenticate(tenant, cookie.Value)
+			if err != nil {
+				if errors.Is(err, services.ErrSessionInvalid) {
+					return c.JSON(http.StatusUnauthorized, map[string]string{
+						"error": "Session is invalid or expired",
+					})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": err.Error(),
+				})
+			}
+
+			if !isSafeMethod(c.Request().Method) {
+				csrfToken := c.Request().Header.Get(CSRFHeader)
+				if csrfToken == "" {
+					csrfToken = c.FormValue(CSRFFormField)
+				}
+				if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(session.CSRFToken)) != 1 {
+					return c.JSON(http.StatusForbidden, map[string]string{
+						"error": "Missing or invalid CSRF token",
+					})
+				}
+			}
+
+			c.Set("session", session)
+			c.Set("user", &session.User)
+			return next(c)
+		}
+	}
+}
+
+// RequireUserOrAccessToken accepts a bearer access token issued by the tenant, so
+// resource servers of a client can call the endpoint, and falls back to
+// RequireUserSession without one. A token puts its record ("accessToken") and User
+// ("user") into the context.
+func RequireUserOrAccessToken() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withSession := RequireUserSession()(next)
+
+		return func(c *echo.Context) error {
+			scheme, token, found := strings.Cut(c.Request().Header.Get("Authorization"), " ")
+			if !found || !strings.EqualFold(scheme, "Bearer") {
+				return withSession(c)
+			}
+
+			tenant := c.Get("tenant").(*models.Tenant)
+			oauthService := services.NewOAuthService(c.Get("db").(*gorm.DB))
+			accessToken, err := oauthService.AuthenticateAccessToken(tenant, c.Get("issuer").(string), strings.TrimSpace(token))
+			if err != nil {
+				if errors.Is(err, services.ErrAccessTokenInvalid) {
+					c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					return c.JSON(http.StatusUnauthorized, map[string]string{
+						"error": "Access token is invalid or expired",
+					})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": err.Error(),
+				})
+			}
+
+			c.Set("accessToken", accessToken)
+			c.Set("user", accessToken.User)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var clientArrayColumns = []string{"redirect_uris", "grant_types", "response_types", "scopes"}
+
+// migrateClientArrays converts the clients list columns from text/varchar holding
+// JSON, comma or space separated values into text[] columns
+func migrateClientArrays(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range clientArrayColumns {
+			var dataType string
+			err := tx.Raw(`SELECT data_type FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'clients' AND column_name = ?`, column).
+				Scan(&dataType).Error
+			if err != nil {
+				return err
+			}
+
+			// Missing table or already migrated
+			if dataType == "" || dataType == "ARRAY" {
+				continue
+			}
+
+			if err := convertClientColumn(tx, column); err != nil {
+				return fmt.Errorf("clients.%s: %w", column, err)
+			}
+		}
+		return nil
+	})
+}
+
+func convertClientColumn(tx *gorm.DB, column string) error {
+	staging := column + "_array"
+
+	if err := tx.Exec(fmt.Sprintf(`ALTER TABLE clients ADD COLUMN %s text[] NOT NULL DEFAULT '{}'`, staging)).Error; err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID    uuid.UUID
+		Value string
+	}
+	if err := tx.Raw(fmt.Sprintf(`SELECT id, COALESCE(%s, '') AS value FROM clients`, column)).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		list := models.ParseLegacyList(row.Value)
+		if err := tx.Exec(fmt.Sprintf(`UPDATE clients SET %s = ? WHERE id = ?`, staging), list, row.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	statements := []string{
+		fmt.Sprintf(`ALTER TABLE clients DROP COLUMN %s`, column),
+		fmt.Sprintf(`ALTER TABLE clients RENAME COLUMN %s TO %s`, staging, column),
+		fmt.Sprintf(`ALTER TABLE clients ALTER COLUMN %s DROP DEFAULT`, column),
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMigrateClientArrays(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := uuid.New(), uuid.New()
+
+	expectDataType := func(column string, dataType string) {
+		mock.ExpectQuery(`SELECT data_type FROM information_schema.columns`).
+			WithArgs(column).
+			WillReturnRows(sqlmock.NewRows([]string{"data_type"}).AddRow(dataType))
+	}
+
+	mock.ExpectBegin()
+
+	// Only text columns are converted, rerunning the migration is a no-op
+	expectDataType("redirect_uris", "text")
+	mock.ExpectExec(`ALTER TABLE clients ADD COLUMN redirect_uris_array text\[\] NOT NULL DEFAULT '\{\}'`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT id, COALESCE\(redirect_uris, ''\) AS value FROM clients`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "value"}).
+			AddRow(first, `["https://app.example.com/cb?a=1,b=2","https://app.example.com/cb?a=1,b=2"]`).
+			AddRow(second, "https://a.example.com/cb, https://b.example.com/cb"))
+	mock.ExpectExec(`UPDATE clients SET redirect_uris_array = \$1 WHERE id = \$2`).
+		WithArgs(`{"https://app.example.com/cb?a=1,b=2"}`, first).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE clients SET redirect_uris_array = \$1 WHERE id = \$2`).
+		WithArgs(`{"https://a.example.com/cb","https://b.example.com/cb"}`, second).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`ALTER TABLE clients DROP COLUMN redirect_uris`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE clients RENAME COLUMN redirect_uris_array TO redirect_uris`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE clients ALTER COLUMN redirect_uris DROP DEFAULT`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	expectDataType("grant_types", "ARRAY")
+	expectDataType("response_types", "ARRAY")
+	expectDataType("scopes", "")
+	mock.ExpectCommit()
+
+	if err := migrateClientArrays(db); err != nil {
+		t.Fatalf("migrateClientArrays = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
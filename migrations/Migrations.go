@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+type migration struct {
+	name string
+	run  func(db *gorm.DB) error
+}
+
+// Every migration must be idempotent, they run on each start up
+var all = []migration{
+	{name: "client_arrays", run: migrateClientArrays},
+}
+
+func Run(db *gorm.DB) error {
+	for _, m := range all {
+		if err := m.run(db); err != nil {
+			log.Printf("Migration %s failed: %v", m.name, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ChallengePrefix is the label the TXT record is published under, e.g. _digipass-challenge.login.acme.com
+const ChallengePrefix = "_digipass-challenge."
+
+var ErrInvalidDomain = errors.New("invalid domain name")
+
+// TXTResolver is satisfied by *net.Resolver, StubResolver stands in for it in tests
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DefaultResolver is used for live verification
+var DefaultResolver TXTResolver = net.DefaultResolver
+
+// RecordName returns the DNS name the verification TXT record must be created at
+func RecordName(domain string) string {
+	return ChallengePrefix + domain
+}
+
+// RecordValue returns the TXT value that proves control of the domain
+func RecordValue(token string) string {
+	return "digipass-verification=" + token
+}
+
+// Verify reports whether the domain publishes the expected verification record
+func Verify(ctx context.Context, resolver TXTResolver, domain string, token string) (bool, error) {
+	records, err := resolver.LookupTXT(ctx, RecordName(domain))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	expected := RecordValue(token)
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Normalize lowercases a host name, strips a trailing dot and validates its labels
+func Normalize(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) == 0 || len(domain) > 253 || !strings.Contains(domain, ".") {
+		return "", ErrInvalidDomain
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", ErrInvalidDomain
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", ErrInvalidDomain
+			}
+		}
+	}
+
+	return domain, nil
+}
+
+// StubResolver answers TXT lookups from memory
+type StubResolver struct {
+	mu      sync.Mutex
+	records map[string][]string
+}
+
+func NewStubResolver() *StubResolver {
+	return &StubResolver{records: map[string][]string{}}
+}
+
+func (r *StubResolver) SetTXT(name string, values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records[name] = values
+}
+
+func (r *StubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values, ok := r.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return append([]string(nil), values...), nil
+}
@@ -0,0 +1,120 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// failingResolver stands in for a DNS server that cannot be reached
+type failingResolver struct{}
+
+func (failingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		records map[string][]string
+		want    bool
+	}{
+		{
+			name:    "published",
+			records: map[string][]string{"_digipass-challenge.login.acme.com": {"digipass-verification=token-1"}},
+			want:    true,
+		},
+		{
+			name:    "padded with whitespace",
+			records: map[string][]string{"_digipass-challenge.login.acme.com": {" digipass-verification=token-1 "}},
+			want:    true,
+		},
+		{
+			name:    "among other records",
+			records: map[string][]string{"_digipass-challenge.login.acme.com": {"v=spf1 -all", "digipass-verification=token-1"}},
+			want:    true,
+		},
+		{
+			name:    "token of another tenant",
+			records: map[string][]string{"_digipass-challenge.login.acme.com": {"digipass-verification=token-2"}},
+		},
+		{
+			name:    "token without the prefix",
+			records: map[string][]string{"_digipass-challenge.login.acme.com": {"token-1"}},
+		},
+		{
+			name:    "published at the domain instead of the challenge name",
+			records: map[string][]string{"login.acme.com": {"digipass-verification=token-1"}},
+		},
+		{
+			name:    "published for the parent domain",
+			records: map[string][]string{"_digipass-challenge.acme.com": {"digipass-verification=token-1"}},
+		},
+		{
+			name: "no record",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := NewStubResolver()
+			for name, values := range test.records {
+				resolver.SetTXT(name, values...)
+			}
+
+			ok, err := Verify(context.Background(), resolver, "login.acme.com", "token-1")
+			if err != nil || ok != test.want {
+				t.Fatalf("Verify = %v, %v, want %v", ok, err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyResolverFailure(t *testing.T) {
+	// A lookup that fails is not the same as a missing record, the caller can retry
+	ok, err := Verify(context.Background(), failingResolver{}, "login.acme.com", "token-1")
+
+	var dnsErr *net.DNSError
+	if ok || !errors.As(err, &dnsErr) {
+		t.Fatalf("Verify = %v, %v, want a DNS error", ok, err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{domain: "login.acme.com", want: "login.acme.com"},
+		{domain: " Login.ACME.com. ", want: "login.acme.com"},
+		{domain: "xn--bcher-kva.example", want: "xn--bcher-kva.example"},
+		{domain: "a-b.example.com", want: "a-b.example.com"},
+
+		{domain: ""},
+		{domain: "localhost"},
+		{domain: "login..acme.com"},
+		{domain: ".acme.com"},
+		{domain: "-login.acme.com"},
+		{domain: "login-.acme.com"},
+		{domain: "login_1.acme.com"},
+		{domain: "bücher.example"},
+		{domain: "login.acme.com/path"},
+		{domain: "login.acme.com:443"},
+		{domain: "*.acme.com"},
+	}
+
+	for _, test := range tests {
+		got, err := Normalize(test.domain)
+		if test.want == "" {
+			if !errors.Is(err, ErrInvalidDomain) {
+				t.Errorf("Normalize(%q) = %q, %v, want ErrInvalidDomain", test.domain, got, err)
+			}
+			continue
+		}
+
+		if err != nil || got != test.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", test.domain, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package hosted renders the tenant branded sign-in pages served by each issuer
+package hosted
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+//go:embed templates/*.html
+var files embed.FS
+
+var pages = map[string]*template.Template{}
+
+func init() {
+	for _, name := range []string{"login", "register", "signed_in", "forgot_password", "reset_password", "change_password", "mfa", "mfa_enroll", "recovery_codes", "email_login", "email_code", "message", "saml_post", "saml_logout"} {
+		pages[name] = template.Must(template.ParseFS(files, "templates/layout.html", "templates/"+name+".html"))
+	}
+}
+
+// Branding is the tenant look applied to every page
+type Branding struct {
+	Name         string
+	LogoURL      string
+	PrimaryColor string
+}
+
+// Page is the data every template receives, pages only use the fields they need
+type Page struct {
+	Branding   Branding
+	Title      string
+	BasePath   string // issuer path prefix, "" or "/t/{slug}"
+	CSRFToken  string
+	ReturnTo   string
+	Email      string
+	GivenName  string
+	FamilyName string
+	Token      string // one time token carried by the reset password and mfa forms
+	Secret     string // authenticator secret shown while enrolling
+	Message    string
+	Error      string
+	Problems   []string
+	Codes      []string           // recovery codes shown once after enrolling an authenticator
+	Passkeys   bool               // the tenant allows passkey sign-in
+	EmailLogin bool               // the tenant allows sign-in with an emailed link or code
+	Providers  []IdentityProvider // upstream sign-in buttons, login page only
+	Post       *Form              // form the browser submits on its own, e.g. a SAML response
+	Logouts    []Form             // service providers notified of a single logout
+}
+
+// Form is a message the browser carries to another site. Without fields it is sent
+// by loading Action, otherwise the fields are posted to it.
+type Form struct {
+	Action string
+	Fields map[string]string
+}
+
+// IdentityProvider is an upstream provider offered on the login page
+type IdentityProvider struct {
+	ID   string
+	Name string
+}
+
+// Render writes the named page wrapped in the shared layout
+func Render(w io.Writer, name string, page Page) error {
+	t, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("unknown hosted page %q", name)
+	}
+
+	if page.Branding.PrimaryColor == "" {
+		page.Branding.PrimaryColor = "#2f54eb"
+	}
+
+	return t.ExecuteTemplate(w, "layout", page)
+}
@@ -0,0 +1,103 @@
+package jwt
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Names of the authorization claims a client can ask to receive in its tokens
+const (
+	ClaimRoles       = "roles"
+	ClaimGroups      = "groups"
+	ClaimPermissions = "permissions"
+)
+
+var AuthorizationClaimNames = []string{ClaimRoles, ClaimGroups, ClaimPermissions}
+
+const (
+	// MaxClaimValues is the most values one claim carries inline
+	MaxClaimValues = 150
+	// MaxAuthorizationClaimsSize caps the JSON size of the claims, tokens travel in headers
+	MaxAuthorizationClaimsSize = 4096
+)
+
+// ClaimSource is where a client fetches a claim left out of the token
+type ClaimSource struct {
+	Endpoint string `json:"endpoint"`
+}
+
+// AuthorizationClaims are the groups of the user and the roles and permissions it
+// holds for the client the token is issued to. A claim too large for the token is
+// replaced by a distributed claim (OpenID Connect Core 5.6.2): its name is listed
+// in _claim_names and points to a source the client can fetch the full list from.
+type AuthorizationClaims struct {
+	Roles        []string               `json:"roles,omitempty"`
+	Groups       []string               `json:"groups,omitempty"`
+	Permissions  []string               `json:"permissions,omitempty"`
+	ClaimNames   map[string]string      `json:"_claim_names,omitempty"`
+	ClaimSources map[string]ClaimSource `json:"_claim_sources,omitempty"`
+}
+
+// Limit keeps the claims named in include and moves the ones over MaxClaimValues to
+// endpoint. While the rest is over MaxAuthorizationClaimsSize the largest remaining
+// claim is moved too.
+func (a AuthorizationClaims) Limit(include []string, endpoint string) AuthorizationClaims {
+	limited := AuthorizationClaims{}
+	claims := map[string]*[]string{
+		ClaimRoles:       &limited.Roles,
+		ClaimGroups:      &limited.Groups,
+		ClaimPermissions: &limited.Permissions,
+	}
+	values := map[string][]string{
+		ClaimRoles:       a.Roles,
+		ClaimGroups:      a.Groups,
+		ClaimPermissions: a.Permissions,
+	}
+
+	for _, name := range AuthorizationClaimNames {
+		if !slices.Contains(include, name) || len(values[name]) == 0 {
+			continue
+		}
+
+		if len(values[name]) > MaxClaimValues {
+			limited.distribute(name, endpoint)
+			continue
+		}
+		*claims[name] = values[name]
+	}
+
+	for limited.size() > MaxAuthorizationClaimsSize {
+		largest, size := "", 0
+		for _, name := range AuthorizationClaimNames {
+			if len(*claims[name]) == 0 {
+				continue
+			}
+			if encoded, _ := json.Marshal(*claims[name]); len(encoded) > size {
+				largest, size = name, len(encoded)
+			}
+		}
+		if largest == "" {
+			break
+		}
+
+		*claims[largest] = nil
+		limited.distribute(largest, endpoint)
+	}
+
+	return limited
+}
+
+func (a *AuthorizationClaims) distribute(name string, endpoint string) {
+	if a.ClaimNames == nil {
+		a.ClaimNames = map[string]string{}
+		a.ClaimSources = map[string]ClaimSource{}
+	}
+
+	a.ClaimNames[name] = "authorization"
+	a.ClaimSources["authorization"] = ClaimSource{Endpoint: endpoint}
+}
+
+func (a AuthorizationClaims) size() int {
+	encoded, _ := json.Marshal(a)
+	return len(encoded)
+}
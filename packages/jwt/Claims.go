@@ -0,0 +1,39 @@
+package jwt
+
+// Token types, the typ header of each kind of token
+const (
+	TypeIDToken     = "JWT"
+	TypeAccessToken = "at+jwt"
+)
+
+// AccessTokenClaims are the claims of a JWT access token (RFC 9068). aud is the
+// client_id, resource servers of the client accept it. The authorization claims are
+// top level, as RFC 9068 2.2.3.1 has them.
+type AccessTokenClaims struct {
+	PublicClaims
+	AuthorizationClaims
+	ClientID string   `json:"client_id"`
+	Scope    string   `json:"scope"`
+	AuthTime int64    `json:"auth_time,omitempty"`
+	ACR      string   `json:"acr,omitempty"`
+	AMR      []string `json:"amr,omitempty"`
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token. The profile and email
+// claims are only set when their scope was granted.
+type IDTokenClaims struct {
+	PublicClaims
+	AuthorizationClaims
+	AuthTime      int64    `json:"auth_time"`
+	Nonce         string   `json:"nonce,omitempty"`
+	ACR           string   `json:"acr,omitempty"`
+	AMR           []string `json:"amr,omitempty"`
+	AZP           string   `json:"azp"`
+	ATHash        string   `json:"at_hash,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified *bool    `json:"email_verified,omitempty"`
+	GivenName     string   `json:"given_name,omitempty"`
+	FamilyName    string   `json:"family_name,omitempty"`
+	Picture       string   `json:"picture,omitempty"`
+	Locale        string   `json:"locale,omitempty"`
+}
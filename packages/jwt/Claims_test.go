@@ -0,0 +1,48 @@
+package jwt
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestAuthorizationClaimsAreTopLevel(t *testing.T) {
+	authorization := AuthorizationClaims{
+		Roles:  []string{"admin"},
+		Groups: make([]string, MaxClaimValues+1),
+	}.Limit(AuthorizationClaimNames, "https://id.example.com/t/acme/users/me/authorization?client_id=app")
+
+	for name, claims := range map[string]any{
+		"access token": AccessTokenClaims{PublicClaims: PublicClaims{Sub: "user-1"}, AuthorizationClaims: authorization},
+		"id token":     IDTokenClaims{PublicClaims: PublicClaims{Sub: "user-1"}, AuthorizationClaims: authorization},
+	} {
+		encoded, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &payload); err != nil {
+			t.Fatal(err)
+		}
+
+		var roles []string
+		if err := json.Unmarshal(payload["roles"], &roles); err != nil || !slices.Equal(roles, []string{"admin"}) {
+			t.Errorf("%s: roles = %s", name, payload["roles"])
+		}
+		if _, ok := payload["groups"]; ok {
+			t.Errorf("%s: groups over the limit are inline", name)
+		}
+
+		var claimNames map[string]string
+		if err := json.Unmarshal(payload["_claim_names"], &claimNames); err != nil || claimNames["groups"] != "authorization" {
+			t.Errorf("%s: _claim_names = %s", name, payload["_claim_names"])
+		}
+		if _, ok := payload["_claim_sources"]; !ok {
+			t.Errorf("%s: _claim_sources missing", name)
+		}
+		if _, ok := payload["private"]; ok {
+			t.Errorf("%s: claims are nested under private", name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T, id string) Key {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{ID: id, Private: private}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	key := testKey(t, "k1")
+	now := time.Unix(1_800_000_000, 0)
+	keys := map[string]*rsa.PublicKey{key.ID: &key.Private.PublicKey}
+
+	token, err := Sign(key, TypeAccessToken, AccessTokenClaims{
+		PublicClaims: PublicClaims{Iss: "https://id.example.com/t/acme", Sub: "user-1", Aud: "app", Exp: now.Add(time.Hour).Unix(), Iat: now.Unix()},
+		ClientID:     "app",
+		Scope:        "openid email",
+		AMR:          []string{"email"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims AccessTokenClaims
+	if err := Verify(token, TypeAccessToken, keys, now, &claims); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	if claims.Sub != "user-1" || claims.Scope != "openid email" || len(claims.AMR) != 1 || claims.AMR[0] != "email" {
+		t.Fatalf("claims = %+v", claims)
+	}
+
+	if err := Verify(token, TypeAccessToken, keys, now.Add(time.Hour), &claims); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expired token = %v, want ErrInvalidToken", err)
+	}
+
+	// An ID token is not an access token even with the same key
+	if err := Verify(token, TypeIDToken, keys, now, &claims); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("wrong typ = %v, want ErrInvalidToken", err)
+	}
+
+	other := testKey(t, "k1")
+	if err := Verify(token, TypeAccessToken, map[string]*rsa.PublicKey{"k1": &other.Private.PublicKey}, now, &claims); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("wrong key = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyRefusesOtherAlgorithms(t *testing.T) {
+	key := testKey(t, "k1")
+	now := time.Unix(1_800_000_000, 0)
+	keys := map[string]*rsa.PublicKey{key.ID: &key.Private.PublicKey}
+
+	token, err := Sign(key, TypeAccessToken, PublicClaims{Sub: "user-1", Exp: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	for _, alg := range []string{"none", "HS256", "RS512", "PS256"} {
+		header, _ := json.Marshal(map[string]string{"alg": alg, "typ": TypeAccessToken, "kid": key.ID})
+		forged := base64.RawURLEncoding.EncodeToString(header) + "." + parts[1] + "." + parts[2]
+
+		var claims PublicClaims
+		if err := Verify(forged, TypeAccessToken, keys, now, &claims); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("alg %s = %v, want ErrInvalidToken", alg, err)
+		}
+	}
+
+	var claims PublicClaims
+	if err := Verify(parts[0]+"."+parts[1]+".", TypeAccessToken, keys, now, &claims); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("empty signature = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestHalfHash(t *testing.T) {
+	// The c_hash example of OpenID Connect Core A.4, at_hash is computed the same way
+	code := "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"
+	if got := HalfHash(code); got != "LDktKdoQak3Pk0cnXxCltA" {
+		t.Fatalf("HalfHash = %s", got)
+	}
+}
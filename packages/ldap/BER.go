@@ -0,0 +1,179 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrProtocol = errors.New("invalid LDAP message")
+
+// Directory answers beyond this are not something a sign-in needs to read
+const maxMessageSize = 4 << 20
+
+// BER identifier octets used by LDAP (RFC 4511 section 5.1)
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// element is one decoded tag-length-value
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// encode writes a definite length TLV, the only form LDAP allows
+func encode(tag byte, content ...[]byte) []byte {
+	size := 0
+	for _, part := range content {
+		size += len(part)
+	}
+
+	out := []byte{tag}
+	switch {
+	case size < 0x80:
+		out = append(out, byte(size))
+	case size <= 0xff:
+		out = append(out, 0x81, byte(size))
+	case size <= 0xffff:
+		out = append(out, 0x82, byte(size>>8), byte(size))
+	default:
+		out = append(out, 0x84, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	}
+
+	for _, part := range content {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func encodeString(tag byte, value string) []byte {
+	return encode(tag, []byte(value))
+}
+
+// encodeInteger writes value as the shortest two's complement
+func encodeInteger(tag byte, value int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(value)}, content...)
+		if (value >= -0x80 && value < 0x80) || len(content) == 8 {
+			break
+		}
+		value >>= 8
+	}
+	return encode(tag, content)
+}
+
+func encodeBoolean(value bool) []byte {
+	if value {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0x00})
+}
+
+// readElement reads one complete message from the connection
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, unexpectedEOF(err)
+	}
+
+	size := int(first)
+	if first&0x80 != 0 {
+		octets := int(first & 0x7f)
+		if octets == 0 || octets > 4 {
+			return element{}, fmt.Errorf("%w: unsupported length", ErrProtocol)
+		}
+
+		size = 0
+		for range octets {
+			b, err := r.ReadByte()
+			if err != nil {
+				return element{}, unexpectedEOF(err)
+			}
+			size = size<<8 | int(b)
+		}
+	}
+
+	if size > maxMessageSize {
+		return element{}, fmt.Errorf("%w: message of %d bytes is too large", ErrProtocol, size)
+	}
+
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, unexpectedEOF(err)
+	}
+	return element{tag: tag, content: content}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// children decodes the content of a constructed element
+func (e element) children() ([]element, error) {
+	var children []element
+	data := e.content
+
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("%w: truncated element", ErrProtocol)
+		}
+
+		tag, first := data[0], data[1]
+		data = data[2:]
+
+		size := int(first)
+		if first&0x80 != 0 {
+			octets := int(first & 0x7f)
+			if octets == 0 || octets > 4 || octets > len(data) {
+				return nil, fmt.Errorf("%w: unsupported length", ErrProtocol)
+			}
+
+			size = 0
+			for _, b := range data[:octets] {
+				size = size<<8 | int(b)
+			}
+			data = data[octets:]
+		}
+
+		if size < 0 || size > len(data) {
+			return nil, fmt.Errorf("%w: element longer than its parent", ErrProtocol)
+		}
+
+		children = append(children, element{tag: tag, content: data[:size]})
+		data = data[size:]
+	}
+	return children, nil
+}
+
+func (e element) integer() (int64, error) {
+	if len(e.content) == 0 || len(e.content) > 8 {
+		return 0, fmt.Errorf("%w: invalid integer", ErrProtocol)
+	}
+
+	// Sign extend from the first octet
+	value := int64(int8(e.content[0]))
+	for _, b := range e.content[1:] {
+		value = value<<8 | int64(b)
+	}
+	return value, nil
+}
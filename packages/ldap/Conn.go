@@ -0,0 +1,391 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result codes the connector tells apart (RFC 4511 appendix A)
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultInvalidCredentials = 49
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// startTLSOID names the StartTLS extended operation (RFC 4511 section 4.14)
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ResultError is an operation the directory refused
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+// IsInvalidCredentials reports whether err is a bind refused for a wrong DN or password
+func IsInvalidCredentials(err error) bool {
+	var resultErr *ResultError
+	return errors.As(err, &resultErr) && resultErr.Code == ResultInvalidCredentials
+}
+
+// Config is where and how to reach a directory. URL is ldap://host[:389] or
+// ldaps://host[:636]; StartTLS upgrades a plain ldap:// connection before anything
+// is sent. RootCAs nil trusts the system roots.
+type Config struct {
+	URL      string
+	StartTLS bool
+	RootCAs  *x509.CertPool
+	Timeout  time.Duration
+}
+
+// Conn is a connection to a directory carrying one operation at a time
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	timeout   time.Duration
+	messageID int64
+}
+
+// SearchRequest asks for the entries below BaseDN matching Filter
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+// Entry is a search result. Attribute names are as the directory returned them,
+// Get looks them up ignoring case.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of attribute, or "" when the entry has none
+func (e *Entry) Get(attribute string) string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Values returns every value of attribute
+func (e *Entry) Values(attribute string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Dial connects to the directory, over TLS for ldaps:// or when StartTLS is set
+func Dial(ctx context.Context, config Config) (*Conn, error) {
+	parsed, err := url.Parse(config.URL)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid LDAP URL %q", config.URL)
+	}
+
+	port := parsed.Port()
+	switch parsed.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		if config.StartTLS {
+			return nil, errors.New("StartTLS cannot be used with ldaps://")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", parsed.Scheme)
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: parsed.Hostname(),
+		RootCAs:    config.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	address := net.JoinHostPort(parsed.Hostname(), port)
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	if parsed.Scheme == "ldaps" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if config.StartTLS {
+		if err := c.startTLS(ctx, tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startTLS asks for the upgrade and then handshakes on the same connection
+func (c *Conn) startTLS(ctx context.Context, tlsConfig *tls.Config) error {
+	response, err := c.roundTrip(encode(classApplication|constructed|23, encodeString(classContext|0, startTLSOID)))
+	if err != nil {
+		return err
+	}
+
+	if err := checkResult(response, classApplication|constructed|24); err != nil {
+		return fmt.Errorf("StartTLS refused: %w", err)
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates the connection with a simple bind. An empty password would be
+// an unauthenticated bind that succeeds for any DN (RFC 4513 section 5.1.2), so it is
+// refused unless dn is empty too, which binds anonymously.
+func (c *Conn) Bind(dn string, password string) error {
+	if password == "" && dn != "" {
+		return &ResultError{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+
+	request := encode(classApplication|constructed|0,
+		encodeInteger(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(classContext|0, password),
+	)
+
+	response, err := c.roundTrip(request)
+	if err != nil {
+		return err
+	}
+	return checkResult(response, classApplication|constructed|1)
+}
+
+// Search returns the matching entries. Hitting SizeLimit is not an error, the
+// entries sent until then are returned.
+func (c *Conn) Search(request SearchRequest) ([]Entry, error) {
+	filter, err := compileFilter(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make([][]byte, 0, len(request.Attributes))
+	for _, attribute := range request.Attributes {
+		attributes = append(attributes, encodeString(tagOctetString, attribute))
+	}
+
+	messageID, err := c.send(encode(classApplication|constructed|3,
+		encodeString(tagOctetString, request.BaseDN),
+		encodeInteger(tagEnumerated, int64(request.Scope)),
+		encodeInteger(tagEnumerated, 0), // never dereference aliases
+		encodeInteger(tagInteger, int64(request.SizeLimit)),
+		encodeInteger(tagInteger, int64(c.timeout/time.Second)),
+		encodeBoolean(false),
+		filter,
+		encode(tagSequence, attributes...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for {
+		op, err := c.receive(messageID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.tag {
+		case classApplication | constructed | 4:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+
+		case classApplication | constructed | 19:
+			// Referrals to other servers are not followed
+
+		case classApplication | constructed | 5:
+			err := checkResult(op, op.tag)
+			var resultErr *ResultError
+			if errors.As(err, &resultErr) && resultErr.Code == ResultSizeLimitExceeded {
+				return entries, nil
+			}
+			return entries, err
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected search response", ErrProtocol)
+		}
+	}
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.send(encode(classApplication | 2))
+	return c.conn.Close()
+}
+
+func (c *Conn) roundTrip(op []byte) (element, error) {
+	messageID, err := c.send(op)
+	if err != nil {
+		return element{}, err
+	}
+	return c.receive(messageID)
+}
+
+func (c *Conn) send(op []byte) (int64, error) {
+	c.messageID++
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	if _, err := c.conn.Write(encode(tagSequence, encodeInteger(tagInteger, c.messageID), op)); err != nil {
+		return 0, err
+	}
+	return c.messageID, nil
+}
+
+// receive reads the next message and returns its protocol operation
+func (c *Conn) receive(messageID int64) (element, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return element{}, err
+	}
+
+	message, err := readElement(c.reader)
+	if err != nil {
+		return element{}, err
+	}
+
+	parts, err := message.children()
+	if err != nil {
+		return element{}, err
+	}
+
+	if message.tag != tagSequence || len(parts) < 2 || parts[0].tag != tagInteger {
+		return element{}, fmt.Errorf("%w: malformed message", ErrProtocol)
+	}
+
+	id, err := parts[0].integer()
+	if err != nil {
+		return element{}, err
+	}
+
+	if id == 0 {
+		// Notice of disconnection, the server is going away
+		return element{}, fmt.Errorf("%w: server closed the connection: %v", ErrProtocol, checkResult(parts[1], parts[1].tag))
+	}
+
+	if id != messageID {
+		return element{}, fmt.Errorf("%w: response to another message", ErrProtocol)
+	}
+	return parts[1], nil
+}
+
+// checkResult reads the LDAPResult of a response with the expected tag
+func checkResult(op element, tag byte) error {
+	if op.tag != tag {
+		return fmt.Errorf("%w: unexpected response", ErrProtocol)
+	}
+
+	parts, err := op.children()
+	if err != nil {
+		return err
+	}
+
+	if len(parts) < 3 || parts[0].tag != tagEnumerated {
+		return fmt.Errorf("%w: malformed result", ErrProtocol)
+	}
+
+	code, err := parts[0].integer()
+	if err != nil {
+		return err
+	}
+
+	if code != ResultSuccess {
+		return &ResultError{Code: int(code), Message: string(parts[2].content)}
+	}
+	return nil
+}
+
+func parseEntry(op element) (Entry, error) {
+	parts, err := op.children()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if len(parts) != 2 || parts[0].tag != tagOctetString || parts[1].tag != tagSequence {
+		return Entry{}, fmt.Errorf("%w: malformed entry", ErrProtocol)
+	}
+
+	entry := Entry{DN: string(parts[0].content), Attributes: map[string][]string{}}
+
+	attributes, err := parts[1].children()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	for _, attribute := range attributes {
+		fields, err := attribute.children()
+		if err != nil {
+			return Entry{}, err
+		}
+
+		if len(fields) != 2 || fields[0].tag != tagOctetString || fields[1].tag != tagSet {
+			return Entry{}, fmt.Errorf("%w: malformed attribute", ErrProtocol)
+		}
+
+		values, err := fields[1].children()
+		if err != nil {
+			return Entry{}, err
+		}
+
+		name := string(fields[0].content)
+		for _, value := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], string(value.content))
+		}
+	}
+	return entry, nil
+}
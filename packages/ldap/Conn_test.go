@@ -0,0 +1,376 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testServiceDN = "cn=svc,dc=example,dc=com"
+	testUserDN    = "uid=ada,ou=people,dc=example,dc=com"
+)
+
+// fakeDirectory is an LDAP server on a loopback listener. Binds succeed for the DNs
+// in passwords, every search answers with entries, and the requests are recorded.
+type fakeDirectory struct {
+	passwords         map[string]string
+	entries           []Entry
+	referral          bool // a continuation reference before the entries
+	sizeLimitExceeded bool
+	tlsConfig         *tls.Config // StartTLS is refused when nil
+
+	mu      sync.Mutex
+	binds   []string
+	filters [][]byte
+}
+
+func (d *fakeDirectory) start(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+
+	for {
+		message, err := readElement(reader)
+		if err != nil {
+			return
+		}
+
+		parts, err := message.children()
+		if err != nil || len(parts) < 2 {
+			return
+		}
+		id, _ := parts[0].integer()
+		op := parts[1]
+
+		respond := func(responses ...[]byte) {
+			for _, response := range responses {
+				conn.Write(encode(tagSequence, encodeInteger(tagInteger, id), response))
+			}
+		}
+
+		fields, _ := op.children()
+		switch op.tag {
+		case classApplication | 2:
+			return
+
+		case classApplication | constructed | 0:
+			dn, password := string(fields[1].content), string(fields[2].content)
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+
+			code := ResultInvalidCredentials
+			if expected, ok := d.passwords[dn]; ok && expected == password {
+				code = ResultSuccess
+			}
+			respond(ldapResult(classApplication|constructed|1, code, ""))
+
+		case classApplication | constructed | 3:
+			d.mu.Lock()
+			d.filters = append(d.filters, encode(fields[6].tag, fields[6].content))
+			d.mu.Unlock()
+
+			if d.referral {
+				respond(encode(classApplication|constructed|19, encodeString(tagOctetString, "ldap://other.example.com/dc=example,dc=com")))
+			}
+			for _, entry := range d.entries {
+				respond(searchEntry(entry))
+			}
+
+			code := ResultSuccess
+			if d.sizeLimitExceeded {
+				code = ResultSizeLimitExceeded
+			}
+			respond(ldapResult(classApplication|constructed|5, code, ""))
+
+		case classApplication | constructed | 23:
+			if d.tlsConfig == nil {
+				respond(ldapResult(classApplication|constructed|24, 53, "StartTLS is not configured"))
+				continue
+			}
+
+			respond(ldapResult(classApplication|constructed|24, ResultSuccess, ""))
+			secure := tls.Server(conn, d.tlsConfig)
+			if err := secure.Handshake(); err != nil {
+				return
+			}
+			conn, reader = secure, bufio.NewReader(secure)
+
+		default:
+			return
+		}
+	}
+}
+
+func ldapResult(tag byte, code int, message string) []byte {
+	return encode(tag, encodeInteger(tagEnumerated, int64(code)), encodeString(tagOctetString, ""), encodeString(tagOctetString, message))
+}
+
+func searchEntry(entry Entry) []byte {
+	var attributes [][]byte
+	for name, values := range entry.Attributes {
+		encoded := make([][]byte, len(values))
+		for i, value := range values {
+			encoded[i] = encodeString(tagOctetString, value)
+		}
+		attributes = append(attributes, encode(tagSequence, encodeString(tagOctetString, name), encode(tagSet, encoded...)))
+	}
+	return encode(classApplication|constructed|4, encodeString(tagOctetString, entry.DN), encode(tagSequence, attributes...))
+}
+
+// testServerTLS returns a certificate for 127.0.0.1 and a pool that trusts it
+func testServerTLS(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "directory"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
+
+func testDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{"": "", testServiceDN: "service secret", testUserDN: "correct horse"},
+		entries: []Entry{{DN: testUserDN, Attributes: map[string][]string{
+			"mail":      {"ada@example.com"},
+			"entryUUID": {"8d2a1b4e-0c55-4bd6-9a3c-6f0fbbf0d1a2"},
+			"cn":        {"Ada Lovelace", "Ada"},
+		}}},
+	}
+}
+
+func dialTest(t *testing.T, config Config) *Conn {
+	t.Helper()
+
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+	conn, err := Dial(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Dial = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestSearchThenBind(t *testing.T) {
+	directory := testDirectory()
+	conn := dialTest(t, Config{URL: directory.start(t)})
+
+	// The service account finds the entry, then a bind as that entry checks the password
+	if err := conn.Bind(testServiceDN, "service secret"); err != nil {
+		t.Fatalf("service bind = %v", err)
+	}
+
+	filter := "(&(objectClass=person)(mail=" + EscapeFilter("ada@example.com") + "))"
+	entries, err := conn.Search(SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: filter, Attributes: []string{"mail", "entryUUID"}, SizeLimit: 2})
+	if err != nil {
+		t.Fatalf("Search = %v", err)
+	}
+	if len(entries) != 1 || entries[0].DN != testUserDN {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[0].Get("MAIL") != "ada@example.com" || !slices.Equal(entries[0].Values("cn"), []string{"Ada Lovelace", "Ada"}) || entries[0].Get("sn") != "" {
+		t.Fatalf("entry = %+v", entries[0])
+	}
+
+	if err := conn.Bind(entries[0].DN, "wrong"); !IsInvalidCredentials(err) {
+		t.Fatalf("bind with a wrong password = %v, want invalid credentials", err)
+	}
+	if err := conn.Bind(entries[0].DN, ""); !IsInvalidCredentials(err) {
+		t.Fatalf("bind with an empty password = %v, want invalid credentials", err)
+	}
+	if err := conn.Bind(entries[0].DN, "correct horse"); err != nil {
+		t.Fatalf("user bind = %v", err)
+	}
+
+	want, _ := compileFilter(filter)
+	directory.mu.Lock()
+	defer directory.mu.Unlock()
+
+	// The empty password never reaches the directory, it would be an unauthenticated bind
+	if !slices.Equal(directory.binds, []string{testServiceDN, testUserDN, testUserDN}) {
+		t.Fatalf("binds = %q", directory.binds)
+	}
+	if len(directory.filters) != 1 || string(directory.filters[0]) != string(want) {
+		t.Fatalf("filters = %x, want %x", directory.filters, want)
+	}
+}
+
+func TestSearchReturnsEveryEntry(t *testing.T) {
+	directory := testDirectory()
+	directory.entries = append(directory.entries, Entry{DN: "uid=ada2,ou=people,dc=example,dc=com", Attributes: map[string][]string{"mail": {"ada@example.com"}}})
+	directory.referral = true
+	directory.sizeLimitExceeded = true
+
+	conn := dialTest(t, Config{URL: directory.start(t)})
+	if err := conn.Bind("", ""); err != nil {
+		t.Fatalf("anonymous bind = %v", err)
+	}
+
+	// Hitting the size limit still returns what was sent, the caller sees two entries
+	// and refuses the ambiguous match
+	entries, err := conn.Search(SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: "(mail=ada@example.com)", SizeLimit: 2})
+	if err != nil {
+		t.Fatalf("Search = %v", err)
+	}
+	if len(entries) != 2 || entries[0].DN != testUserDN || entries[1].DN != "uid=ada2,ou=people,dc=example,dc=com" {
+		t.Fatalf("entries = %+v", entries)
+	}
+
+	if _, err := conn.Search(SearchRequest{Filter: "(mail=ada"}); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("Search with a bad filter = %v, want ErrInvalidFilter", err)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	serverTLS, roots := testServerTLS(t)
+	_, otherRoots := testServerTLS(t)
+
+	directory := testDirectory()
+	directory.tlsConfig = serverTLS
+	url := directory.start(t)
+
+	conn := dialTest(t, Config{URL: url, StartTLS: true, RootCAs: roots})
+	if _, ok := conn.conn.(*tls.Conn); !ok {
+		t.Fatal("connection was not upgraded")
+	}
+	if err := conn.Bind(testServiceDN, "service secret"); err != nil {
+		t.Fatalf("bind over TLS = %v", err)
+	}
+
+	if _, err := Dial(context.Background(), Config{URL: url, StartTLS: true, RootCAs: otherRoots, Timeout: 5 * time.Second}); err == nil {
+		t.Fatal("StartTLS accepted a certificate that is not trusted")
+	}
+
+	refusing := testDirectory()
+	_, err := Dial(context.Background(), Config{URL: refusing.start(t), StartTLS: true, RootCAs: roots, Timeout: 5 * time.Second})
+	var resultErr *ResultError
+	if !errors.As(err, &resultErr) || resultErr.Code != 53 {
+		t.Fatalf("Dial to a directory refusing StartTLS = %v", err)
+	}
+
+	// Nothing but the StartTLS request goes out in clear
+	refusing.mu.Lock()
+	defer refusing.mu.Unlock()
+	if len(refusing.binds) != 0 {
+		t.Fatalf("binds sent in clear = %q", refusing.binds)
+	}
+}
+
+func TestDialRejectsConfig(t *testing.T) {
+	configs := map[string]Config{
+		"unsupported scheme":  {URL: "http://directory.example.com"},
+		"no host":             {URL: "ldap://"},
+		"StartTLS over ldaps": {URL: "ldaps://directory.example.com", StartTLS: true},
+		"not a URL":           {URL: "ldap://[::1"},
+	}
+
+	for name, config := range configs {
+		if conn, err := Dial(context.Background(), config); err == nil {
+			conn.Close()
+			t.Errorf("%s: Dial succeeded", name)
+		}
+	}
+}
+
+func TestReceiveRejectsUnexpectedMessages(t *testing.T) {
+	tests := map[string][]byte{
+		"response to another message": encode(tagSequence, encodeInteger(tagInteger, 99), ldapResult(classApplication|constructed|1, ResultSuccess, "")),
+		"notice of disconnection": encode(tagSequence, encodeInteger(tagInteger, 0),
+			encode(classApplication|constructed|24, encodeInteger(tagEnumerated, 52), encodeString(tagOctetString, ""), encodeString(tagOctetString, "shutting down"))),
+		"wrong response type": encode(tagSequence, encodeInteger(tagInteger, 1), ldapResult(classApplication|constructed|5, ResultSuccess, "")),
+		"malformed result":    encode(tagSequence, encodeInteger(tagInteger, 1), encode(classApplication|constructed|1, encodeInteger(tagEnumerated, 0))),
+		"too large":           {tagSequence, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"unsupported length":  {tagSequence, 0x80},
+	}
+
+	for name, response := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				if _, err := readElement(bufio.NewReader(server)); err == nil {
+					server.Write(response)
+				}
+			}()
+
+			conn := &Conn{conn: client, reader: bufio.NewReader(client), timeout: 5 * time.Second}
+			if err := conn.Bind(testServiceDN, "service secret"); !errors.Is(err, ErrProtocol) {
+				t.Fatalf("Bind = %v, want ErrProtocol", err)
+			}
+		})
+	}
+
+	t.Run("connection closed mid message", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer server.Close()
+			if _, err := readElement(bufio.NewReader(server)); err == nil {
+				server.Write([]byte{tagSequence, 0x10, 0x02})
+			}
+		}()
+
+		conn := &Conn{conn: client, reader: bufio.NewReader(client), timeout: 5 * time.Second}
+		if err := conn.Bind(testServiceDN, "service secret"); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+			t.Fatalf("Bind = %v, want unexpected EOF", err)
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidFilter = errors.New("invalid LDAP filter")
+
+// Filters are written by tenant admins, deeper nesting is a mistake or an attack
+const maxFilterDepth = 16
+
+// EscapeFilter makes value safe to put in a filter as an assertion value (RFC 4515
+// section 3), so a username cannot change what the filter matches
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			b.WriteString(`\` + hex.EncodeToString([]byte{c}))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ValidateFilter checks that filter parses, for configuration that is only used later
+func ValidateFilter(filter string) error {
+	_, err := compileFilter(filter)
+	return err
+}
+
+// compileFilter encodes the string form of a search filter (RFC 4515)
+func compileFilter(filter string) ([]byte, error) {
+	encoded, rest, err := compileItem(strings.TrimSpace(filter), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest != "" {
+		return nil, fmt.Errorf("%w: unexpected %q after the filter", ErrInvalidFilter, rest)
+	}
+	return encoded, nil
+}
+
+// compileItem encodes the parenthesized filter at the front of s and returns the rest
+func compileItem(s string, depth int) ([]byte, string, error) {
+	if depth > maxFilterDepth {
+		return nil, "", fmt.Errorf("%w: nested too deep", ErrInvalidFilter)
+	}
+
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("%w: expected (", ErrInvalidFilter)
+	}
+	s = s[1:]
+
+	if s == "" {
+		return nil, "", fmt.Errorf("%w: unexpected end", ErrInvalidFilter)
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(classContext | constructed | 0)
+		if s[0] == '|' {
+			tag = classContext | constructed | 1
+		}
+
+		var parts [][]byte
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			part, rest, err := compileItem(s, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			s = rest
+		}
+
+		if len(parts) == 0 {
+			return nil, "", fmt.Errorf("%w: empty and/or", ErrInvalidFilter)
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("%w: expected )", ErrInvalidFilter)
+		}
+		return encode(tag, parts...), s[1:], nil
+
+	case '!':
+		part, rest, err := compileItem(s[1:], depth+1)
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("%w: expected )", ErrInvalidFilter)
+		}
+		return encode(classContext|constructed|2, part), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("%w: expected )", ErrInvalidFilter)
+	}
+
+	encoded, err := compileSimple(s[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return encoded, s[end+1:], nil
+}
+
+// compileSimple encodes an attribute assertion such as mail=a@b.c, cn=Ann* or uid=*
+func compileSimple(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("%w: %q is not an assertion", ErrInvalidFilter, item)
+	}
+
+	attribute, value := item[:eq], item[eq+1:]
+	tag := byte(classContext | constructed | 3) // equalityMatch
+
+	switch attribute[len(attribute)-1] {
+	case '>':
+		tag = classContext | constructed | 5
+		attribute = attribute[:len(attribute)-1]
+	case '<':
+		tag = classContext | constructed | 6
+		attribute = attribute[:len(attribute)-1]
+	case '~':
+		tag = classContext | constructed | 8
+		attribute = attribute[:len(attribute)-1]
+	case ':':
+		return compileExtensible(attribute[:len(attribute)-1], value)
+	}
+
+	if !validAttribute(attribute) {
+		return nil, fmt.Errorf("%w: invalid attribute %q", ErrInvalidFilter, attribute)
+	}
+
+	if tag == classContext|constructed|3 && value == "*" {
+		return encodeString(classContext|7, attribute), nil // present
+	}
+
+	if tag == classContext|constructed|3 && strings.Contains(value, "*") {
+		return compileSubstrings(attribute, value)
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return encode(tag, encodeString(tagOctetString, attribute), encodeString(tagOctetString, unescaped)), nil
+}
+
+func compileSubstrings(attribute string, value string) ([]byte, error) {
+	pieces := strings.Split(value, "*")
+
+	var substrings [][]byte
+	for i, piece := range pieces {
+		if piece == "" {
+			continue
+		}
+
+		unescaped, err := unescapeFilterValue(piece)
+		if err != nil {
+			return nil, err
+		}
+
+		tag := byte(classContext | 1) // any
+		switch i {
+		case 0:
+			tag = classContext | 0 // initial
+		case len(pieces) - 1:
+			tag = classContext | 2 // final
+		}
+		substrings = append(substrings, encodeString(tag, unescaped))
+	}
+
+	if len(substrings) == 0 {
+		return nil, fmt.Errorf("%w: empty substring assertion", ErrInvalidFilter)
+	}
+	return encode(classContext|constructed|4, encodeString(tagOctetString, attribute), encode(tagSequence, substrings...)), nil
+}
+
+// compileExtensible encodes attr:dn:rule:=value and its shorter forms, e.g. the
+// userAccountControl bit test Active Directory uses to leave out disabled accounts
+func compileExtensible(description string, value string) ([]byte, error) {
+	fields := strings.Split(description, ":")
+	attribute, options := fields[0], fields[1:]
+
+	var rule string
+	dnAttributes := false
+	for _, option := range options {
+		switch {
+		case option == "dn" && !dnAttributes && rule == "":
+			dnAttributes = true
+		case rule == "" && validAttribute(option):
+			rule = option
+		default:
+			return nil, fmt.Errorf("%w: invalid extensible match %q", ErrInvalidFilter, description)
+		}
+	}
+
+	if attribute == "" && rule == "" || attribute != "" && !validAttribute(attribute) {
+		return nil, fmt.Errorf("%w: invalid extensible match %q", ErrInvalidFilter, description)
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts [][]byte
+	if rule != "" {
+		parts = append(parts, encodeString(classContext|1, rule))
+	}
+	if attribute != "" {
+		parts = append(parts, encodeString(classContext|2, attribute))
+	}
+	parts = append(parts, encodeString(classContext|3, unescaped))
+	if dnAttributes {
+		parts = append(parts, encode(classContext|4, []byte{0xff}))
+	}
+	return encode(classContext|constructed|9, parts...), nil
+}
+
+// unescapeFilterValue decodes the \XX escapes of an assertion value
+func unescapeFilterValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '(', ')', '*':
+			return "", fmt.Errorf("%w: unescaped %q in a value", ErrInvalidFilter, c)
+		case '\\':
+			if i+2 >= len(value) {
+				return "", fmt.Errorf("%w: truncated escape", ErrInvalidFilter)
+			}
+			decoded, err := hex.DecodeString(value[i+1 : i+3])
+			if err != nil {
+				return "", fmt.Errorf("%w: invalid escape", ErrInvalidFilter)
+			}
+			b.Write(decoded)
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// validAttribute accepts attribute descriptions: a name or OID with options
+func validAttribute(attribute string) bool {
+	if attribute == "" {
+		return false
+	}
+	for _, c := range attribute {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == ';') {
+			return false
+		}
+	}
+	return true
+}
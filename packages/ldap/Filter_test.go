@@ -0,0 +1,117 @@
+package ldap
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func equality(attribute string, value string) []byte {
+	return encode(classContext|constructed|3, encodeString(tagOctetString, attribute), encodeString(tagOctetString, value))
+}
+
+func TestEscapeFilter(t *testing.T) {
+	tests := map[string]string{
+		"ada@example.com":  "ada@example.com",
+		"zoë":              "zoë",
+		"*":                `\2a`,
+		"a)(uid=*":         `a\29\28uid=\2a`,
+		`back\slash`:       `back\5cslash`,
+		"nul\x00":          `nul\00`,
+		"(|(mail=*))@x.io": `\28|\28mail=\2a\29\29@x.io`,
+	}
+
+	for value, want := range tests {
+		if got := EscapeFilter(value); got != want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestEscapedValueCannotChangeTheFilter(t *testing.T) {
+	// Each of these would widen or end the user filter if put in unescaped
+	usernames := []string{
+		"*",
+		"*)(objectClass=*",
+		"admin)(|(mail=*",
+		"ada@example.com))(|(uid=*",
+		`x\2a`,
+		"nul\x00@example.com",
+	}
+
+	for _, username := range usernames {
+		filter := strings.ReplaceAll("(&(objectClass=person)(mail={username}))", "{username}", EscapeFilter(username))
+
+		compiled, err := compileFilter(filter)
+		if err != nil {
+			t.Errorf("%q: compileFilter(%q) = %v", username, filter, err)
+			continue
+		}
+
+		want := encode(classContext|constructed|0, equality("objectClass", "person"), equality("mail", username))
+		if !bytes.Equal(compiled, want) {
+			t.Errorf("%q: %q does not compare mail with the username as typed", username, filter)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{filter: "(uid=ada)", want: equality("uid", "ada")},
+		{filter: " (uid=ada) ", want: equality("uid", "ada")},
+		{filter: "(uid=*)", want: encodeString(classContext|7, "uid")},
+		{filter: "(cn=Ann*a*son)", want: encode(classContext|constructed|4, encodeString(tagOctetString, "cn"),
+			encode(tagSequence, encodeString(classContext|0, "Ann"), encodeString(classContext|1, "a"), encodeString(classContext|2, "son")))},
+		{filter: "(createTimestamp>=20240101000000Z)", want: encode(classContext|constructed|5, encodeString(tagOctetString, "createTimestamp"), encodeString(tagOctetString, "20240101000000Z"))},
+		{filter: "(!(cn=x))", want: encode(classContext|constructed|2, equality("cn", "x"))},
+		{filter: "(|(member=a)(uniqueMember=a))", want: encode(classContext|constructed|1, equality("member", "a"), equality("uniqueMember", "a"))},
+		{filter: "(userAccountControl:1.2.840.113556.1.4.803:=2)", want: encode(classContext|constructed|9,
+			encodeString(classContext|1, "1.2.840.113556.1.4.803"), encodeString(classContext|2, "userAccountControl"), encodeString(classContext|3, "2"))},
+		{filter: "(ou:dn:=people)", want: encode(classContext|constructed|9,
+			encodeString(classContext|2, "ou"), encodeString(classContext|3, "people"), encode(classContext|4, []byte{0xff}))},
+		{filter: `(cn=a\28b\29)`, want: equality("cn", "a(b)")},
+	}
+
+	for _, test := range tests {
+		got, err := compileFilter(test.filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q) = %v", test.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("compileFilter(%q) = %x, want %x", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestCompileFilterRejectsInvalidFilters(t *testing.T) {
+	filters := []string{
+		"",
+		"uid=ada",
+		"(uid=ada",
+		"(uid=ada))",
+		"(uid=ada)(cn=x)",
+		"(&)",
+		"(&(uid=a)",
+		"(!(uid=a)(cn=b))",
+		"(uid=a(b)",
+		"(=ada)",
+		"(u id=ada)",
+		`(uid=\zz)`,
+		`(uid=\2)`,
+		"(cn=**)",
+		"(:=x)",
+		"(cn:a:b:=x)",
+		strings.Repeat("(!", maxFilterDepth+2) + "(cn=x)" + strings.Repeat(")", maxFilterDepth+2),
+	}
+
+	for _, filter := range filters {
+		if err := ValidateFilter(filter); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("ValidateFilter(%q) = %v, want ErrInvalidFilter", filter, err)
+		}
+	}
+}
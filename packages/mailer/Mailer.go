@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"log"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email, implementations are picked with MAILER
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv builds the configured Mailer: "file" (default, writes to MAILER_DIR), "memory"
+// or "smtp" (SMTP_ADDR host:port, SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM)
+func FromEnv() Mailer {
+	switch os.Getenv("MAILER") {
+	case "memory":
+		log.Println("Using in-memory mailer, emails are not delivered")
+		return NewMemoryMailer()
+	case "smtp":
+		m, err := NewSMTPMailer(os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+		if err != nil {
+			log.Fatalf("Invalid SMTP mailer configuration: %v", err)
+		}
+		log.Printf("Using SMTP mailer via %s", os.Getenv("SMTP_ADDR"))
+		return m
+	}
+
+	dir := os.Getenv("MAILER_DIR")
+	if dir == "" {
+		dir = "outbox"
+	}
+	log.Printf("Using file mailer, emails are written to %s", dir)
+	return NewFileMailer(dir)
+}
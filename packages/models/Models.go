@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"math/rand/v2"
+	"strings"
 	"time"
 )
 
@@ -85,52 +86,90 @@ var mediumWords = []string{
 
 // Account represents the main account/organization
 type Account struct {
-	ID        uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Name      string    `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
-	Email     string    `json:"email" db:"email" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required,email"`
-	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
-	Status    string    `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
+	ID        uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Name      string     `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
+	Email     string     `json:"email" db:"email" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required,email"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	Status    string     `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=pending_verification active suspended deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"` // Hard deleted after the cooling-off period
 
 	// Relationships
-	Tenants      []Tenant      `json:"tenants,omitempty" gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
-	AccountUsers []AccountUser `json:"account_users,omitempty" gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	Tenants                []Tenant                `json:"tenants,omitempty" gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	AccountUsers           []AccountUser           `json:"account_users,omitempty" gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	AccountUserInvitations []AccountUserInvitation `json:"account_user_invitations,omitempty" gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
 }
 
 // Tenant represents an isolated environment within an account
 type Tenant struct {
+	ID        uuid.UUID      `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	AccountID uuid.UUID      `json:"account_id" db:"account_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Slug      string         `json:"slug" db:"slug" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	Name      string         `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	Status    string         `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
+	Settings  TenantSettings `json:"settings" db:"settings" gorm:"type:jsonb"` // Versioned, see TenantSettings.go
+	DeletedAt *time.Time     `json:"deleted_at,omitempty" db:"deleted_at"`     // Purged after the deletion grace period
+
+	// Relationships
+	Account              Account               `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+	Clients              []Client              `json:"clients,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	Users                []User                `json:"users,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	AuditLogs            []AuditLog            `json:"audit_logs,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	Domains              []TenantDomain        `json:"domains,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	SlugAliases          []TenantSlugAlias     `json:"slug_aliases,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	IdentityProviders    []IdentityProvider    `json:"identity_providers,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	SigningKeys          []TenantSigningKey    `json:"-" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	SAMLServiceProviders []SAMLServiceProvider `json:"saml_service_providers,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	Groups               []Group               `json:"groups,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	SCIMTokens           []SCIMToken           `json:"-" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+}
+
+// TenantDomain represents a customer owned host name that serves a tenant's issuer
+type TenantDomain struct {
+	ID                uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID          uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_tenant_domains_claim" validate:"required"`
+	Domain            string     `json:"domain" db:"domain" gorm:"type:varchar(253);not null;uniqueIndex:idx_tenant_domains_claim;uniqueIndex:idx_tenant_domains_verified,where:verified_at IS NOT NULL" validate:"required,hostname"` // unique once verified, tenants may claim it until then
+	VerificationToken string     `json:"verification_token" db:"verification_token" gorm:"type:varchar(255);not null" validate:"required"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Tenant Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+}
+
+// TenantSlugAlias keeps a tenant's previous slug redirecting for a while after a rename
+type TenantSlugAlias struct {
 	ID        uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	AccountID uuid.UUID `json:"account_id" db:"account_id" gorm:"type:uuid;not null;index" validate:"required"`
+	TenantID  uuid.UUID `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
 	Slug      string    `json:"slug" db:"slug" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
-	Name      string    `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
-	Status    string    `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
-	Settings  []byte    `json:"settings,omitempty" db:"settings" gorm:"type:jsonb"` // Use json.RawMessage or custom type
 
 	// Relationships
-	Account   Account    `json:"account,omitempty" gorm:"foreignKey:AccountID"`
-	Clients   []Client   `json:"clients,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
-	Users     []User     `json:"users,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
-	AuditLogs []AuditLog `json:"audit_logs,omitempty" gorm:"foreignKey:TenantID;constraint:OnDelete:CASCADE"`
+	Tenant Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
 }
 
 // Client represents an OAuth client application
 type Client struct {
-	ID               uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	ClientID         string    `json:"client_id" db:"client_id" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
-	ClientSecretHash string    `json:"-" db:"client_secret_hash" gorm:"type:varchar(255);not null" validate:"required"` // Never expose in JSON
-	TenantID         uuid.UUID `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
-	Name             string    `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
-	Description      string    `json:"description,omitempty" db:"description" gorm:"type:text"`
-	RedirectURIs     string    `json:"redirect_uris" db:"redirect_uris" gorm:"type:text;not null" validate:"required"` // Store as JSON string
-	GrantTypes       string    `json:"grant_types" db:"grant_types" gorm:"type:text;not null" validate:"required"`
-	ResponseTypes    string    `json:"response_types" db:"response_types" gorm:"type:text;not null" validate:"required"`
-	Scopes           string    `json:"scopes" db:"scopes" gorm:"type:text;not null" validate:"required"`
-	IsConfidential   bool      `json:"is_confidential" db:"is_confidential" gorm:"default:true"`
-	CreatedAt        time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt        time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
-	Status           string    `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
+	ID                      uuid.UUID   `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	ClientID                string      `json:"client_id" db:"client_id" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	ClientSecretHash        string      `json:"-" db:"client_secret_hash" gorm:"type:varchar(255);not null" validate:"required"` // Never expose in JSON
+	PreviousSecretHash      string      `json:"-" db:"previous_secret_hash" gorm:"type:varchar(255)"`                            // Rotated out secret, accepted until PreviousSecretExpiresAt
+	PreviousSecretExpiresAt *time.Time  `json:"previous_secret_expires_at,omitempty" db:"previous_secret_expires_at"`
+	TenantID                uuid.UUID   `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Name                    string      `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
+	Description             string      `json:"description,omitempty" db:"description" gorm:"type:text"`
+	RedirectURIs            StringArray `json:"redirect_uris" db:"redirect_uris" gorm:"type:text[];not null" validate:"required"`
+	GrantTypes              StringArray `json:"grant_types" db:"grant_types" gorm:"type:text[];not null" validate:"required"`
+	ResponseTypes           StringArray `json:"response_types" db:"response_types" gorm:"type:text[];not null" validate:"required"`
+	Scopes                  StringArray `json:"scopes" db:"scopes" gorm:"type:text[];not null" validate:"required"`
+	IsConfidential          bool        `json:"is_confidential" db:"is_confidential" gorm:"default:true"`
+	TokenClaims             StringArray `json:"token_claims" db:"token_claims" gorm:"type:text[]"` // authorization claims put into its tokens: roles, groups, permissions
+	CreatedAt               time.Time   `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt               time.Time   `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	Status                  string      `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
 
 	// Relationships
 	Tenant             Tenant              `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
@@ -140,39 +179,51 @@ type Client struct {
 	IDTokens           []IDToken           `json:"id_tokens,omitempty" gorm:"foreignKey:ClientID;constraint:OnDelete:CASCADE"`
 	Sessions           []Session           `json:"sessions,omitempty" gorm:"foreignKey:ClientID;constraint:OnDelete:CASCADE"`
 	UserConsents       []UserConsent       `json:"user_consents,omitempty" gorm:"foreignKey:ClientID;constraint:OnDelete:CASCADE"`
+	Roles              []ClientRole        `json:"-" gorm:"foreignKey:ClientID;constraint:OnDelete:CASCADE"`
+	Permissions        []ClientPermission  `json:"-" gorm:"foreignKey:ClientID;constraint:OnDelete:CASCADE"`
 }
 
 // User represents an end user with OpenID Connect identity
 type User struct {
-	ID            uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	TenantID      uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
-	Email         string     `json:"email" db:"email" gorm:"type:varchar(255);not null" validate:"required,email"`
-	EmailVerified bool       `json:"email_verified" db:"email_verified" gorm:"default:false"`
-	PasswordHash  string     `json:"-" db:"password_hash" gorm:"type:varchar(255)"` // Null for social logins
-	GivenName     string     `json:"given_name,omitempty" db:"given_name" gorm:"type:varchar(255)"`
-	FamilyName    string     `json:"family_name,omitempty" db:"family_name" gorm:"type:varchar(255)"`
-	PictureURL    string     `json:"picture_url,omitempty" db:"picture_url" gorm:"type:text"`
-	Locale        string     `json:"locale,omitempty" db:"locale" gorm:"type:varchar(10)"`
-	CreatedAt     time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
-	LastLoginAt   *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
-	Status        string     `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
+	ID                uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID          uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Email             string     `json:"email" db:"email" gorm:"type:varchar(255);not null" validate:"required,email"`
+	EmailVerified     bool       `json:"email_verified" db:"email_verified" gorm:"default:false"`
+	PasswordHash      string     `json:"-" db:"password_hash" gorm:"type:varchar(255)"` // Null for social logins
+	PasswordChangedAt *time.Time `json:"password_changed_at,omitempty" db:"password_changed_at"`
+	GivenName         string     `json:"given_name,omitempty" db:"given_name" gorm:"type:varchar(255)"`
+	FamilyName        string     `json:"family_name,omitempty" db:"family_name" gorm:"type:varchar(255)"`
+	PictureURL        string     `json:"picture_url,omitempty" db:"picture_url" gorm:"type:text"`
+	Locale            string     `json:"locale,omitempty" db:"locale" gorm:"type:varchar(10)"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	LastLoginAt       *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	Status            string     `json:"status" db:"status" gorm:"type:varchar(50);default:'active'" validate:"oneof=active suspended deleted"`
+	ExternalID        string     `json:"external_id,omitempty" db:"external_id" gorm:"type:varchar(255);index"` // the provisioning client's ID, see SCIMToken
 
 	// Relationships
-	Tenant             Tenant              `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
-	AuthorizationCodes []AuthorizationCode `json:"authorization_codes,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	AccessTokens       []AccessToken       `json:"access_tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	RefreshTokens      []RefreshToken      `json:"refresh_tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	IDTokens           []IDToken           `json:"id_tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	Sessions           []Session           `json:"sessions,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	UserConsents       []UserConsent       `json:"user_consents,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	AuditLogs          []AuditLog          `json:"audit_logs,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	Tenant             Tenant                `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	AuthorizationCodes []AuthorizationCode   `json:"authorization_codes,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	AccessTokens       []AccessToken         `json:"access_tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	RefreshTokens      []RefreshToken        `json:"refresh_tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	IDTokens           []IDToken             `json:"id_tokens,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Sessions           []Session             `json:"sessions,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	UserConsents       []UserConsent         `json:"user_consents,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	AuditLogs          []AuditLog            `json:"audit_logs,omitempty" gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	Tokens             []UserToken           `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	PasswordHistory    []UserPasswordHistory `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	TOTPCredentials    []TOTPCredential      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Passkeys           []WebAuthnCredential  `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	RecoveryCodes      []RecoveryCode        `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	ExternalIdentities []ExternalIdentity    `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	GroupMemberships   []GroupMember         `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	ClientRoles        []UserClientRole      `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 }
 
 // AuthorizationCode represents a short-lived authorization code
 type AuthorizationCode struct {
 	ID                  uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Code                string     `json:"code" db:"code" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	Code                string     `json:"-" db:"code" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"` // hash of the code, tokens.Hash
 	ClientID            uuid.UUID  `json:"client_id" db:"client_id" gorm:"type:uuid;not null;index" validate:"required"`
 	UserID              uuid.UUID  `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
 	RedirectURI         string     `json:"redirect_uri" db:"redirect_uri" gorm:"type:text;not null" validate:"required,url"`
@@ -183,10 +234,12 @@ type AuthorizationCode struct {
 	CreatedAt           time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UsedAt              *time.Time `json:"used_at,omitempty" db:"used_at"`
 	Nonce               string     `json:"nonce,omitempty" db:"nonce" gorm:"type:varchar(255)"`
+	SessionID           *uuid.UUID `json:"session_id,omitempty" db:"session_id" gorm:"type:uuid;index"` // the sign-in the tokens are issued from, for auth_time, acr and amr
 
 	// Relationships
 	Client   Client    `json:"client,omitempty" gorm:"foreignKey:ClientID"`
 	User     User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Session  *Session  `json:"session,omitempty" gorm:"foreignKey:SessionID"`
 	IDTokens []IDToken `json:"id_tokens,omitempty" gorm:"foreignKey:AuthorizationCodeID"`
 }
 
@@ -221,6 +274,7 @@ type RefreshToken struct {
 	CreatedAt     time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
 	SessionID     *uuid.UUID `json:"session_id,omitempty" db:"session_id" gorm:"type:uuid;index"`
+	FamilyID      uuid.UUID  `json:"family_id" db:"family_id" gorm:"type:uuid;not null;index"` // tokens rotated from the same code
 
 	// Relationships
 	AccessToken *AccessToken `json:"access_token,omitempty" gorm:"foreignKey:AccessTokenID"`
@@ -256,22 +310,27 @@ type IDToken struct {
 
 // Session represents a user session
 type Session struct {
-	ID             uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	UserID         uuid.UUID  `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
-	ClientID       *uuid.UUID `json:"client_id,omitempty" db:"client_id" gorm:"type:uuid;index"`
-	UserAgent      string     `json:"user_agent,omitempty" db:"user_agent" gorm:"type:text"`
-	IPAddress      string     `json:"ip_address,omitempty" db:"ip_address" gorm:"type:varchar(45)"`
-	CreatedAt      time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	LastActivityAt time.Time  `json:"last_activity_at" db:"last_activity_at" gorm:"autoCreateTime"`
-	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
-	RevokedAt      *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ID             uuid.UUID   `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID         uuid.UUID   `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
+	ClientID       *uuid.UUID  `json:"client_id,omitempty" db:"client_id" gorm:"type:uuid;index"`
+	TokenHash      string      `json:"-" db:"token_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	CSRFToken      string      `json:"-" db:"csrf_token" gorm:"type:varchar(255);not null" validate:"required"`
+	AMR            StringArray `json:"amr" db:"amr" gorm:"type:text[];not null"` // authentication methods (RFC 8176) used to sign in
+	ACR            string      `json:"acr,omitempty" db:"acr" gorm:"type:varchar(50)"`
+	UserAgent      string      `json:"user_agent,omitempty" db:"user_agent" gorm:"type:text"`
+	IPAddress      string      `json:"ip_address,omitempty" db:"ip_address" gorm:"type:varchar(45)"`
+	CreatedAt      time.Time   `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	LastActivityAt time.Time   `json:"last_activity_at" db:"last_activity_at" gorm:"autoCreateTime"`
+	ExpiresAt      time.Time   `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	RevokedAt      *time.Time  `json:"revoked_at,omitempty" db:"revoked_at"`
 
 	// Relationships
-	User          User           `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	Client        *Client        `json:"client,omitempty" gorm:"foreignKey:ClientID"`
-	AccessTokens  []AccessToken  `json:"access_tokens,omitempty" gorm:"foreignKey:SessionID"`
-	RefreshTokens []RefreshToken `json:"refresh_tokens,omitempty" gorm:"foreignKey:SessionID"`
-	IDTokens      []IDToken      `json:"id_tokens,omitempty" gorm:"foreignKey:SessionID"`
+	User             User                     `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Client           *Client                  `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+	AccessTokens     []AccessToken            `json:"access_tokens,omitempty" gorm:"foreignKey:SessionID"`
+	RefreshTokens    []RefreshToken           `json:"refresh_tokens,omitempty" gorm:"foreignKey:SessionID"`
+	IDTokens         []IDToken                `json:"id_tokens,omitempty" gorm:"foreignKey:SessionID"`
+	SAMLParticipants []SAMLSessionParticipant `json:"-" gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
 }
 
 // UserConsent represents user consent to client access
@@ -299,8 +358,413 @@ type AccountUser struct {
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
 
 	// Relationships
-	Account   Account    `json:"account,omitempty" gorm:"foreignKey:AccountID"`
-	AuditLogs []AuditLog `json:"audit_logs,omitempty" gorm:"foreignKey:AccountUserID;constraint:OnDelete:SET NULL"`
+	Account         Account          `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+	AuditLogs       []AuditLog       `json:"audit_logs,omitempty" gorm:"foreignKey:AccountUserID;constraint:OnDelete:SET NULL"`
+	ConsoleSessions []ConsoleSession `json:"console_sessions,omitempty" gorm:"foreignKey:AccountUserID;constraint:OnDelete:CASCADE"`
+	TOTPCredentials []TOTPCredential `json:"-" gorm:"foreignKey:AccountUserID;constraint:OnDelete:CASCADE"`
+	RecoveryCodes   []RecoveryCode   `json:"-" gorm:"foreignKey:AccountUserID;constraint:OnDelete:CASCADE"`
+}
+
+// AccountUserInvitation represents a pending invite for a teammate to join an account
+type AccountUserInvitation struct {
+	ID          uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	AccountID   uuid.UUID  `json:"account_id" db:"account_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Email       string     `json:"email" db:"email" gorm:"type:varchar(255);not null" validate:"required,email"`
+	Role        string     `json:"role" db:"role" gorm:"type:varchar(50);not null" validate:"required,oneof=owner admin member"`
+	InvitedByID *uuid.UUID `json:"invited_by_id,omitempty" db:"invited_by_id" gorm:"type:uuid"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at" gorm:"not null" validate:"required"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty" db:"accepted_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Account   Account      `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+	InvitedBy *AccountUser `json:"invited_by,omitempty" gorm:"foreignKey:InvitedByID"`
+}
+
+// UserToken is a hashed, single use token emailed to a tenant User (email verification, password reset)
+type UserToken struct {
+	ID        uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Purpose   string     `json:"purpose" db:"purpose" gorm:"type:varchar(50);not null" validate:"required,oneof=verify_email reset_password unlock_account"`
+	TokenHash string     `json:"-" db:"token_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// UserPasswordHistory keeps the hashes of a User's previous passwords to prevent reuse
+type UserPasswordHistory struct {
+	ID           uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID       uuid.UUID `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
+	PasswordHash string    `json:"-" db:"password_hash" gorm:"type:varchar(255);not null" validate:"required"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// TOTPCredential is an authenticator app enrolled by a tenant User or an AccountUser,
+// exactly one of UserID and AccountUserID is set. It only counts once confirmed.
+type TOTPCredential struct {
+	ID            uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID        *uuid.UUID `json:"user_id,omitempty" db:"user_id" gorm:"type:uuid;uniqueIndex"`
+	AccountUserID *uuid.UUID `json:"account_user_id,omitempty" db:"account_user_id" gorm:"type:uuid;uniqueIndex"`
+	SecretSealed  string     `json:"-" db:"secret_sealed" gorm:"type:text;not null" validate:"required"`
+	LastUsedStep  int64      `json:"-" db:"last_used_step" gorm:"not null;default:0"`
+	ConfirmedAt   *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	User        *User        `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	AccountUser *AccountUser `json:"account_user,omitempty" gorm:"foreignKey:AccountUserID"`
+}
+
+// RecoveryCode is a single use fallback for a lost authenticator, only its hash is stored
+type RecoveryCode struct {
+	ID            uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID        *uuid.UUID `json:"user_id,omitempty" db:"user_id" gorm:"type:uuid;index"`
+	AccountUserID *uuid.UUID `json:"account_user_id,omitempty" db:"account_user_id" gorm:"type:uuid;index"`
+	CodeHash      string     `json:"-" db:"code_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	UsedAt        *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	User        *User        `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	AccountUser *AccountUser `json:"account_user,omitempty" gorm:"foreignKey:AccountUserID"`
+}
+
+// WebAuthnCredential is a passkey registered by a tenant User. Users may have only
+// passkeys, in which case PasswordHash is empty.
+type WebAuthnCredential struct {
+	ID                uuid.UUID   `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID            uuid.UUID   `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
+	CredentialID      string      `json:"credential_id" db:"credential_id" gorm:"type:text;not null;uniqueIndex" validate:"required"` // base64url raw id
+	PublicKey         []byte      `json:"-" db:"public_key" gorm:"type:bytea;not null" validate:"required"`                           // COSE_Key
+	SignCount         int64       `json:"-" db:"sign_count" gorm:"not null;default:0"`
+	AAGUID            uuid.UUID   `json:"aaguid" db:"aaguid" gorm:"type:uuid"`
+	Transports        StringArray `json:"transports" db:"transports" gorm:"type:text[];not null"`
+	AttestationFormat string      `json:"attestation_format" db:"attestation_format" gorm:"type:varchar(20);not null" validate:"oneof=none packed"`
+	BackupEligible    bool        `json:"backup_eligible" db:"backup_eligible" gorm:"not null;default:false"`
+	BackupState       bool        `json:"backup_state" db:"backup_state" gorm:"not null;default:false"`
+	Name              string      `json:"name" db:"name" gorm:"type:varchar(255)"`
+	CreatedAt         time.Time   `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	LastUsedAt        *time.Time  `json:"last_used_at,omitempty" db:"last_used_at"`
+
+	// Relationships
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// WebAuthnChallenge is a pending passkey ceremony, consumed by its first response.
+// UserID is the user adding a passkey, or for a signup the ID reserved for the new
+// user, so it has no foreign key.
+type WebAuthnChallenge struct {
+	ID            uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID      uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	UserID        *uuid.UUID `json:"user_id,omitempty" db:"user_id" gorm:"type:uuid"`
+	Purpose       string     `json:"purpose" db:"purpose" gorm:"type:varchar(20);not null" validate:"required,oneof=register signup login"`
+	ChallengeHash string     `json:"-" db:"challenge_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	Email         string     `json:"email,omitempty" db:"email" gorm:"type:varchar(255)"` // signup only
+	ExpiresAt     time.Time  `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Tenant Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+}
+
+// EmailLoginChallenge is a pending passwordless sign-in by email. It carries a magic
+// link and a 6 digit code, both hashed, and is bound to the browser that asked for it.
+type EmailLoginChallenge struct {
+	ID               uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID         uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Email            string     `json:"email" db:"email" gorm:"type:varchar(255);not null" validate:"required,email"`
+	LinkTokenHash    string     `json:"-" db:"link_token_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	CodeHash         string     `json:"-" db:"code_hash" gorm:"type:varchar(255);not null" validate:"required"`
+	BrowserTokenHash string     `json:"-" db:"browser_token_hash" gorm:"type:varchar(255);not null;index" validate:"required"`
+	ClientID         *uuid.UUID `json:"client_id,omitempty" db:"client_id" gorm:"type:uuid"`
+	ReturnTo         string     `json:"return_to,omitempty" db:"return_to" gorm:"type:text"`
+	Attempts         int        `json:"attempts" db:"attempts" gorm:"not null;default:0"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	UsedAt           *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Tenant Tenant  `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	Client *Client `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+}
+
+// IdentityProvider is an upstream OpenID Connect provider, SAML identity provider or
+// LDAP directory tenant users can sign in with. Google and Microsoft are presets of
+// the generic oidc type.
+type IdentityProvider struct {
+	ID                 uuid.UUID   `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID           uuid.UUID   `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Type               string      `json:"type" db:"type" gorm:"type:varchar(20);not null" validate:"required,oneof=oidc google microsoft saml ldap"`
+	Name               string      `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"` // shown on the sign-in button
+	Issuer             string      `json:"issuer" db:"issuer" gorm:"type:text;not null" validate:"required"`     // OpenID Connect issuer, SAML entity ID or LDAP URL
+	ClientID           string      `json:"client_id,omitempty" db:"client_id" gorm:"type:varchar(255);not null"`
+	ClientSecretSealed string      `json:"-" db:"client_secret_sealed" gorm:"type:text"` // tokens.Seal, empty for public clients
+	Scopes             StringArray `json:"scopes,omitempty" db:"scopes" gorm:"type:text[];not null"`
+	SSOURL             string      `json:"sso_url,omitempty" db:"sso_url" gorm:"type:text"`                             // SAML HTTP-Redirect sign-in endpoint
+	Certificates       StringArray `json:"certificates,omitempty" db:"certificates" gorm:"type:text[]"`                 // PEM, trusted to sign SAML responses or to issue the LDAP server certificate
+	AttributeMapping   StringMap   `json:"attribute_mapping,omitempty" db:"attribute_mapping" gorm:"type:jsonb"`        // SAML or LDAP attribute name -> user field
+	StartTLS           bool        `json:"start_tls,omitempty" db:"start_tls" gorm:"not null;default:false"`            // upgrade a plain ldap:// connection
+	BindDN             string      `json:"bind_dn,omitempty" db:"bind_dn" gorm:"type:text"`                             // LDAP service account that searches for users
+	BindPasswordSealed string      `json:"-" db:"bind_password_sealed" gorm:"type:text"`                                // tokens.Seal
+	BaseDN             string      `json:"base_dn,omitempty" db:"base_dn" gorm:"type:text"`                             // LDAP subtree users are searched in
+	UserFilter         string      `json:"user_filter,omitempty" db:"user_filter" gorm:"type:text"`                     // {username} is the email typed at sign-in
+	SubjectAttribute   string      `json:"subject_attribute,omitempty" db:"subject_attribute" gorm:"type:varchar(255)"` // stable LDAP user ID, e.g. entryUUID or objectGUID
+	SyncGroups         bool        `json:"sync_groups,omitempty" db:"sync_groups" gorm:"not null;default:false"`        // copy LDAP group memberships at each sign-in
+	GroupBaseDN        string      `json:"group_base_dn,omitempty" db:"group_base_dn" gorm:"type:text"`
+	GroupFilter        string      `json:"group_filter,omitempty" db:"group_filter" gorm:"type:text"`                                             // {dn} is the DN of the user
+	AllowIdPInitiated  bool        `json:"allow_idp_initiated" db:"allow_idp_initiated" gorm:"column:allow_idp_initiated;not null;default:false"` // accept SAML responses nobody asked for
+	Domains            StringArray `json:"domains" db:"domains" gorm:"type:text[]"`                                                               // email domains sent straight to this provider
+	AllowSignup        bool        `json:"allow_signup" db:"allow_signup" gorm:"not null;default:true"`                                           // create users on first sign-in
+	Enabled            bool        `json:"enabled" db:"enabled" gorm:"not null;default:true"`
+	CreatedAt          time.Time   `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt          time.Time   `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Relationships
+	Tenant             Tenant              `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	ExternalIdentities []ExternalIdentity  `json:"-" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+	FederationStates   []FederationState   `json:"-" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+	ConsumedAssertions []ConsumedAssertion `json:"-" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+	Groups             []Group             `json:"-" gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+}
+
+// ExternalIdentity links a User to the subject of an upstream provider
+type ExternalIdentity struct {
+	ID          uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index" validate:"required"`
+	ProviderID  uuid.UUID  `json:"provider_id" db:"provider_id" gorm:"type:uuid;not null;uniqueIndex:idx_external_identities_subject" validate:"required"`
+	Subject     string     `json:"subject" db:"subject" gorm:"type:varchar(255);not null;uniqueIndex:idx_external_identities_subject" validate:"required"`
+	Email       string     `json:"email,omitempty" db:"email" gorm:"type:varchar(255)"` // as last seen upstream
+	CreatedAt   time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+
+	// Relationships
+	User     User             `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Provider IdentityProvider `json:"provider,omitempty" gorm:"foreignKey:ProviderID"`
+}
+
+// FederationState is an upstream sign-in in flight, looked up by the state parameter
+// when the provider redirects back. The nonce and PKCE verifier never leave the server.
+type FederationState struct {
+	ID           uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID     uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	ProviderID   uuid.UUID  `json:"provider_id" db:"provider_id" gorm:"type:uuid;not null;index" validate:"required"`
+	StateHash    string     `json:"-" db:"state_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	Nonce        string     `json:"-" db:"nonce" gorm:"type:varchar(255);not null" validate:"required"` // ID token nonce, or the SAML AuthnRequest ID
+	CodeVerifier string     `json:"-" db:"code_verifier" gorm:"type:varchar(255);not null"`             // PKCE, empty for SAML
+	ClientID     *uuid.UUID `json:"client_id,omitempty" db:"client_id" gorm:"type:uuid"`
+	ReturnTo     string     `json:"return_to,omitempty" db:"return_to" gorm:"type:text"`
+	ExpiresAt    time.Time  `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Tenant   Tenant           `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	Provider IdentityProvider `json:"provider,omitempty" gorm:"foreignKey:ProviderID"`
+	Client   *Client          `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+}
+
+// ConsumedAssertion remembers a SAML assertion until it expires, so a Response
+// captured on its way to the assertion consumer service cannot be posted again
+type ConsumedAssertion struct {
+	ID          uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	ProviderID  uuid.UUID `json:"provider_id" db:"provider_id" gorm:"type:uuid;not null;uniqueIndex:idx_consumed_assertions_assertion" validate:"required"`
+	AssertionID string    `json:"assertion_id" db:"assertion_id" gorm:"type:varchar(255);not null;uniqueIndex:idx_consumed_assertions_assertion" validate:"required"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Provider IdentityProvider `json:"provider,omitempty" gorm:"foreignKey:ProviderID"`
+}
+
+// Group is a set of users of a tenant. Groups synced from a directory belong to its
+// provider, their memberships are replaced at each sign-in.
+type Group struct {
+	ID          uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID    uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;uniqueIndex:idx_groups_name" validate:"required"`
+	Name        string     `json:"name" db:"name" gorm:"type:varchar(255);not null;uniqueIndex:idx_groups_name" validate:"required"`
+	Description string     `json:"description,omitempty" db:"description" gorm:"type:text"`
+	ProviderID  *uuid.UUID `json:"provider_id,omitempty" db:"provider_id" gorm:"type:uuid;index"`   // directory the group is synced from
+	ExternalID  string     `json:"external_id,omitempty" db:"external_id" gorm:"type:varchar(255)"` // the provisioning client's ID
+	CreatedAt   time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Relationships
+	Tenant      Tenant            `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	Provider    *IdentityProvider `json:"provider,omitempty" gorm:"foreignKey:ProviderID"`
+	Members     []GroupMember     `json:"-" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+	ClientRoles []GroupClientRole `json:"-" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+}
+
+// GroupMember puts a User in a Group
+type GroupMember struct {
+	ID        uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	GroupID   uuid.UUID `json:"group_id" db:"group_id" gorm:"type:uuid;not null;uniqueIndex:idx_group_members_member" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_group_members_member;index" validate:"required"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Group Group `json:"group,omitempty" gorm:"foreignKey:GroupID"`
+	User  User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// ClientPermission is an action a Client, or the API it stands for, lets its callers
+// perform, such as "invoices:write". It is granted through a ClientRole.
+type ClientPermission struct {
+	ID          uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	ClientID    uuid.UUID `json:"client_id" db:"client_id" gorm:"type:uuid;not null;uniqueIndex:idx_client_permissions_name" validate:"required"`
+	Name        string    `json:"name" db:"name" gorm:"type:varchar(255);not null;uniqueIndex:idx_client_permissions_name" validate:"required"`
+	Description string    `json:"description,omitempty" db:"description" gorm:"type:text"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Client Client `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+}
+
+// ClientRole is an application role of a Client, assigned to users directly or
+// through their groups
+type ClientRole struct {
+	ID          uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	ClientID    uuid.UUID `json:"client_id" db:"client_id" gorm:"type:uuid;not null;uniqueIndex:idx_client_roles_name" validate:"required"`
+	Name        string    `json:"name" db:"name" gorm:"type:varchar(255);not null;uniqueIndex:idx_client_roles_name" validate:"required"`
+	Description string    `json:"description,omitempty" db:"description" gorm:"type:text"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Relationships
+	Client      Client             `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+	Permissions []ClientPermission `json:"permissions" gorm:"many2many:client_role_permissions;joinForeignKey:RoleID;joinReferences:PermissionID;constraint:OnDelete:CASCADE"`
+}
+
+// ClientRolePermission grants a ClientPermission to a ClientRole
+type ClientRolePermission struct {
+	RoleID       uuid.UUID `json:"role_id" db:"role_id" gorm:"primaryKey;type:uuid"`
+	PermissionID uuid.UUID `json:"permission_id" db:"permission_id" gorm:"primaryKey;type:uuid;index"`
+}
+
+// UserClientRole assigns a ClientRole to a User
+type UserClientRole struct {
+	ID        uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_user_client_roles_role" validate:"required"`
+	RoleID    uuid.UUID `json:"role_id" db:"role_id" gorm:"type:uuid;not null;uniqueIndex:idx_user_client_roles_role;index" validate:"required"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	User User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Role ClientRole `json:"role,omitempty" gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+}
+
+// GroupClientRole assigns a ClientRole to every member of a Group
+type GroupClientRole struct {
+	ID        uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	GroupID   uuid.UUID `json:"group_id" db:"group_id" gorm:"type:uuid;not null;uniqueIndex:idx_group_client_roles_role" validate:"required"`
+	RoleID    uuid.UUID `json:"role_id" db:"role_id" gorm:"type:uuid;not null;uniqueIndex:idx_group_client_roles_role;index" validate:"required"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Group Group      `json:"group,omitempty" gorm:"foreignKey:GroupID"`
+	Role  ClientRole `json:"role,omitempty" gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+}
+
+// SCIMToken is a bearer token a tenant issues to the system provisioning its users
+// and groups over SCIM, such as an HR system or an enterprise identity provider
+type SCIMToken struct {
+	ID         uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID   uuid.UUID  `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Name       string     `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
+	TokenHash  string     `json:"-" db:"token_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Tenant Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+}
+
+// TenantSigningKey is a tenant's RSA key pair with a self-signed certificate. The
+// active key signs SAML messages and tokens, its certificate is in the IdP metadata
+// and its public key in the JWKS.
+type TenantSigningKey struct {
+	ID               uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID         uuid.UUID `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;index" validate:"required"`
+	Algorithm        string    `json:"algorithm" db:"algorithm" gorm:"type:varchar(20);not null" validate:"required,oneof=RS256"`
+	PrivateKeySealed string    `json:"-" db:"private_key_sealed" gorm:"type:text;not null" validate:"required"`    // PKCS#8, tokens.Seal
+	Certificate      string    `json:"certificate" db:"certificate" gorm:"type:text;not null" validate:"required"` // PEM
+	Active           bool      `json:"active" db:"active" gorm:"not null;default:true"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	ExpiresAt        time.Time `json:"expires_at" db:"expires_at" gorm:"not null"` // certificate NotAfter
+
+	// Relationships
+	Tenant Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+}
+
+// SAMLServiceProvider is an application signing tenant users in through the tenant's
+// SAML identity provider
+type SAMLServiceProvider struct {
+	ID               uuid.UUID   `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TenantID         uuid.UUID   `json:"tenant_id" db:"tenant_id" gorm:"type:uuid;not null;uniqueIndex:idx_saml_service_providers_entity" validate:"required"`
+	Name             string      `json:"name" db:"name" gorm:"type:varchar(255);not null" validate:"required"`
+	EntityID         string      `json:"entity_id" db:"entity_id" gorm:"type:varchar(1024);not null;uniqueIndex:idx_saml_service_providers_entity" validate:"required"`
+	ACSURLs          StringArray `json:"acs_urls" db:"acs_urls" gorm:"type:text[];not null" validate:"required"` // HTTP-POST, the first is the default
+	SLOURL           string      `json:"slo_url,omitempty" db:"slo_url" gorm:"type:text"`
+	SLOBinding       string      `json:"slo_binding,omitempty" db:"slo_binding" gorm:"type:varchar(20)" validate:"omitempty,oneof=post redirect"`
+	Certificate      string      `json:"certificate,omitempty" db:"certificate" gorm:"type:text"` // PEM, verifies signed requests
+	NameIDFormat     string      `json:"name_id_format" db:"name_id_format" gorm:"type:varchar(255);not null" validate:"required"`
+	AttributeMapping StringMap   `json:"attribute_mapping" db:"attribute_mapping" gorm:"type:jsonb;not null"` // SAML attribute name -> user field
+	SignResponse     bool        `json:"sign_response" db:"sign_response" gorm:"not null;default:false"`      // sign the Response as well as the Assertion
+	Enabled          bool        `json:"enabled" db:"enabled" gorm:"not null;default:true"`
+	CreatedAt        time.Time   `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt        time.Time   `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Relationships
+	Tenant       Tenant                   `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	Participants []SAMLSessionParticipant `json:"-" gorm:"foreignKey:ServiceProviderID;constraint:OnDelete:CASCADE"`
+}
+
+// SAMLSessionParticipant records that a Session signed in to a service provider, so
+// single logout knows which sessions a LogoutRequest ends and whom to notify
+type SAMLSessionParticipant struct {
+	ID                uuid.UUID `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	SessionID         uuid.UUID `json:"session_id" db:"session_id" gorm:"type:uuid;not null;index" validate:"required"`
+	ServiceProviderID uuid.UUID `json:"service_provider_id" db:"service_provider_id" gorm:"type:uuid;not null;index" validate:"required"`
+	NameID            string    `json:"name_id" db:"name_id" gorm:"type:varchar(255);not null" validate:"required"`
+	NameIDFormat      string    `json:"name_id_format" db:"name_id_format" gorm:"type:varchar(255);not null" validate:"required"`
+	SessionIndex      string    `json:"session_index" db:"session_index" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Session         Session             `json:"session,omitempty" gorm:"foreignKey:SessionID"`
+	ServiceProvider SAMLServiceProvider `json:"service_provider,omitempty" gorm:"foreignKey:ServiceProviderID"`
+}
+
+// LoginThrottle counts failed logins per key (user, IP or tenant) for brute-force protection
+type LoginThrottle struct {
+	Key           string     `json:"key" db:"key" gorm:"primaryKey;type:varchar(255)"`
+	Failures      int        `json:"failures" db:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at" gorm:"not null;index"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// ConsoleSession represents a logged in AccountUser on the management console
+type ConsoleSession struct {
+	ID             uuid.UUID  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	AccountUserID  uuid.UUID  `json:"account_user_id" db:"account_user_id" gorm:"type:uuid;not null;index" validate:"required"`
+	TokenHash      string     `json:"-" db:"token_hash" gorm:"type:varchar(255);not null;uniqueIndex" validate:"required"`
+	CSRFToken      string     `json:"-" db:"csrf_token" gorm:"type:varchar(255);not null" validate:"required"`
+	UserAgent      string     `json:"user_agent,omitempty" db:"user_agent" gorm:"type:text"`
+	IPAddress      string     `json:"ip_address,omitempty" db:"ip_address" gorm:"type:varchar(45)"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	LastActivityAt time.Time  `json:"last_activity_at" db:"last_activity_at" gorm:"autoCreateTime"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at" gorm:"not null;index" validate:"required"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+
+	// Relationships
+	AccountUser AccountUser `json:"account_user,omitempty" gorm:"foreignKey:AccountUserID"`
 }
 
 // AuditLog represents security and compliance audit trail
@@ -324,28 +788,128 @@ type AuditLog struct {
 }
 
 // TableName Overrides
-func (Account) TableName() string           { return "accounts" }
-func (Tenant) TableName() string            { return "tenants" }
-func (Client) TableName() string            { return "clients" }
-func (User) TableName() string              { return "users" }
-func (AuthorizationCode) TableName() string { return "authorization_codes" }
-func (AccessToken) TableName() string       { return "access_tokens" }
-func (RefreshToken) TableName() string      { return "refresh_tokens" }
-func (IDToken) TableName() string           { return "id_tokens" }
-func (Session) TableName() string           { return "sessions" }
-func (UserConsent) TableName() string       { return "user_consents" }
-func (AccountUser) TableName() string       { return "account_users" }
-func (AuditLog) TableName() string          { return "audit_logs" }
+func (Account) TableName() string                { return "accounts" }
+func (Tenant) TableName() string                 { return "tenants" }
+func (Client) TableName() string                 { return "clients" }
+func (User) TableName() string                   { return "users" }
+func (AuthorizationCode) TableName() string      { return "authorization_codes" }
+func (AccessToken) TableName() string            { return "access_tokens" }
+func (RefreshToken) TableName() string           { return "refresh_tokens" }
+func (IDToken) TableName() string                { return "id_tokens" }
+func (Session) TableName() string                { return "sessions" }
+func (UserConsent) TableName() string            { return "user_consents" }
+func (AccountUser) TableName() string            { return "account_users" }
+func (AuditLog) TableName() string               { return "audit_logs" }
+func (ConsoleSession) TableName() string         { return "console_sessions" }
+func (AccountUserInvitation) TableName() string  { return "account_user_invitations" }
+func (TenantDomain) TableName() string           { return "tenant_domains" }
+func (TenantSlugAlias) TableName() string        { return "tenant_slug_aliases" }
+func (UserToken) TableName() string              { return "user_tokens" }
+func (UserPasswordHistory) TableName() string    { return "user_password_history" }
+func (LoginThrottle) TableName() string          { return "login_throttles" }
+func (TOTPCredential) TableName() string         { return "totp_credentials" }
+func (RecoveryCode) TableName() string           { return "recovery_codes" }
+func (WebAuthnCredential) TableName() string     { return "webauthn_credentials" }
+func (WebAuthnChallenge) TableName() string      { return "webauthn_challenges" }
+func (EmailLoginChallenge) TableName() string    { return "email_login_challenges" }
+func (IdentityProvider) TableName() string       { return "identity_providers" }
+func (ExternalIdentity) TableName() string       { return "external_identities" }
+func (FederationState) TableName() string        { return "federation_states" }
+func (ConsumedAssertion) TableName() string      { return "consumed_assertions" }
+func (TenantSigningKey) TableName() string       { return "tenant_signing_keys" }
+func (SAMLServiceProvider) TableName() string    { return "saml_service_providers" }
+func (SAMLSessionParticipant) TableName() string { return "saml_session_participants" }
+func (Group) TableName() string                  { return "groups" }
+func (GroupMember) TableName() string            { return "group_members" }
+func (SCIMToken) TableName() string              { return "scim_tokens" }
+func (ClientPermission) TableName() string       { return "client_permissions" }
+func (ClientRole) TableName() string             { return "client_roles" }
+func (ClientRolePermission) TableName() string   { return "client_role_permissions" }
+func (UserClientRole) TableName() string         { return "user_client_roles" }
+func (GroupClientRole) TableName() string        { return "group_client_roles" }
+
+// Session Functions
+
+// Authentication method references (RFC 8176) and the assurance levels they reach
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa" // a passkey with user verification is possession plus PIN or biometric
+	AMREmail       = "email"
+	AMRFederated   = "fed" // signed in at an upstream identity provider
+
+	ACRSingleFactor = "urn:digipass:acr:1fa"
+	ACRMultiFactor  = "urn:digipass:acr:mfa"
+)
+
+// ACRForAMR is multi-factor once two different methods were used
+func ACRForAMR(amr []string) string {
+	distinct := map[string]bool{}
+	for _, method := range amr {
+		distinct[method] = true
+	}
+	if len(distinct) >= 2 {
+		return ACRMultiFactor
+	}
+	return ACRSingleFactor
+}
+
+// ApplySession copies how the user authenticated into the ID token, AMR is stored space separated
+func (t *IDToken) ApplySession(session *Session) {
+	t.SessionID = &session.ID
+	t.ACR = session.ACR
+	t.AMR = strings.Join(session.AMR, " ")
+}
+
+// AMRClaim returns the amr claim as a JSON array value
+func (t IDToken) AMRClaim() []string {
+	return strings.Fields(t.AMR)
+}
+
+// Client Functions
+
+// HasRedirectURI requires an exact match, no prefix or wildcard matching
+func (c Client) HasRedirectURI(redirectURI string) bool {
+	return c.RedirectURIs.Contains(redirectURI)
+}
+
+func (c Client) AllowsGrantType(grantType string) bool {
+	return c.GrantTypes.Contains(grantType)
+}
+
+func (c Client) AllowsResponseType(responseType string) bool {
+	return c.ResponseTypes.Contains(responseType)
+}
+
+func (c Client) HasScope(scope string) bool {
+	return c.Scopes.Contains(scope)
+}
+
+// AllowsScopes reports whether every requested scope is registered for the client
+func (c Client) AllowsScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !c.HasScope(scope) {
+			return false
+		}
+	}
+	return true
+}
 
 // Tenant Functions
 func (Tenant) CreateSlug() string {
 	//word-word-numbers
 	//3-4 letters-5-6 letters-4numbers
-	//
-	firstWord := shortWords[rand.IntN(len(shortWords))]
-	secondWord := mediumWords[rand.IntN(len(mediumWords))]
+	//Redraw until neither word is on the blocklist
+	for {
+		firstWord := shortWords[rand.IntN(len(shortWords))]
+		secondWord := mediumWords[rand.IntN(len(mediumWords))]
 
-	numbers := rand.IntN(9000) + 1000
+		numbers := rand.IntN(9000) + 1000
 
-	return fmt.Sprintf("%s-%s-%d", firstWord, secondWord, numbers)
+		slug := fmt.Sprintf("%s-%s-%d", firstWord, secondWord, numbers)
+		if !IsBlockedSlug(slug) {
+			return slug
+		}
+	}
 }
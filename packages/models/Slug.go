@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrSlugFormat   = errors.New("slug must be 3-63 lowercase letters, digits or single hyphens and start and end with a letter or digit")
+	ErrSlugReserved = errors.New("slug is reserved")
+	ErrSlugBlocked  = errors.New("slug contains a blocked word")
+)
+
+// Slugs double as subdomain labels so they follow DNS label rules
+var slugFormat = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{1,61}[a-z0-9])$`)
+
+// reservedSlugs collide with our own routes and host names
+var reservedSlugs = []string{
+	"admin", "api", "app", "assets", "auth", "console", "dashboard", "digipass",
+	"docs", "help", "login", "logout", "mail", "oauth", "oidc", "root", "saml",
+	"scim", "static", "status", "support", "system", "t", "v1", "well-known", "www",
+}
+
+// blockedWords never appear as a hyphen separated part of a slug
+var blockedWords = []string{
+	"abuse", "angry", "bleed", "blood", "cheat", "crime", "cruel", "daft", "dead",
+	"death", "demon", "drown", "drunk", "dumb", "dummy", "enemy", "evil", "fake",
+	"foul", "fury", "grim", "lame", "lazy", "mean", "mock", "moan", "poor", "rude",
+	"sore", "ugly", "weak",
+}
+
+// blockedSubstrings are rejected anywhere in a slug, including inside other words
+var blockedSubstrings = []string{
+	"fuck", "shit", "cunt", "bitch", "nazi", "slut", "whore", "porn",
+}
+
+// ValidateSlug checks a requested custom slug
+func ValidateSlug(slug string) error {
+	if !slugFormat.MatchString(slug) || strings.Contains(slug, "--") {
+		return ErrSlugFormat
+	}
+
+	if slices.Contains(reservedSlugs, slug) {
+		return ErrSlugReserved
+	}
+
+	if IsBlockedSlug(slug) {
+		return ErrSlugBlocked
+	}
+
+	return nil
+}
+
+// IsBlockedSlug reports whether the slug contains a blocked word, generated slugs are checked too
+func IsBlockedSlug(slug string) bool {
+	for _, part := range strings.Split(slug, "-") {
+		if slices.Contains(blockedWords, part) {
+			return true
+		}
+	}
+
+	for _, word := range blockedSubstrings {
+		if strings.Contains(slug, word) {
+			return true
+		}
+	}
+
+	return false
+}
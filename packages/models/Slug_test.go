@@ -0,0 +1,44 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want error
+	}{
+		{slug: "acme"},
+		{slug: "acme-corp-2"},
+		{slug: "a1b"},
+		{slug: strings.Repeat("a", 63)},
+		// Slugs double as DNS labels
+		{slug: "ab", want: ErrSlugFormat},
+		{slug: strings.Repeat("a", 64), want: ErrSlugFormat},
+		{slug: "Acme", want: ErrSlugFormat},
+		{slug: "-acme", want: ErrSlugFormat},
+		{slug: "acme-", want: ErrSlugFormat},
+		{slug: "acme--corp", want: ErrSlugFormat},
+		{slug: "acme_corp", want: ErrSlugFormat},
+		{slug: "acme.corp", want: ErrSlugFormat},
+		{slug: "", want: ErrSlugFormat},
+		// Our own routes and host names
+		{slug: "console", want: ErrSlugReserved},
+		{slug: "well-known", want: ErrSlugReserved},
+		{slug: "www", want: ErrSlugReserved},
+		// Blocked words as a part, blocked substrings anywhere
+		{slug: "evil-corp", want: ErrSlugBlocked},
+		{slug: "my-fake-bank", want: ErrSlugBlocked},
+		{slug: "devil-corp"},
+		{slug: "acmeporn", want: ErrSlugBlocked},
+	}
+
+	for _, test := range tests {
+		if err := ValidateSlug(test.slug); !errors.Is(err, test.want) {
+			t.Errorf("ValidateSlug(%s) = %v, want %v", test.slug, err, test.want)
+		}
+	}
+}
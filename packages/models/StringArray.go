@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StringArray maps a Postgres text[] column to a Go slice
+type StringArray []string
+
+// Value encodes the slice as a Postgres array literal, every element is quoted
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, element := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		for _, r := range element {
+			if r == '"' || r == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String(), nil
+}
+
+// Scan decodes a Postgres array literal such as {a,"b c",NULL}, NULL elements are dropped
+func (a *StringArray) Scan(value any) error {
+	var literal string
+	switch v := value.(type) {
+	case nil:
+		*a = StringArray{}
+		return nil
+	case []byte:
+		literal = string(v)
+	case string:
+		literal = v
+	default:
+		return fmt.Errorf("unsupported string array type %T", value)
+	}
+
+	elements, err := parseArrayLiteral(literal)
+	if err != nil {
+		return err
+	}
+
+	*a = elements
+	return nil
+}
+
+func (a StringArray) Contains(value string) bool {
+	return slices.Contains(a, value)
+}
+
+var errArrayLiteral = errors.New("invalid postgres array literal")
+
+func parseArrayLiteral(literal string) (StringArray, error) {
+	if len(literal) < 2 || literal[0] != '{' || literal[len(literal)-1] != '}' {
+		return nil, errArrayLiteral
+	}
+
+	body := literal[1 : len(literal)-1]
+	elements := StringArray{}
+	if body == "" {
+		return elements, nil
+	}
+
+	var current strings.Builder
+	quoted, inQuotes, escaped := false, false, false
+
+	flush := func() {
+		element := current.String()
+		if quoted || element != "NULL" {
+			elements = append(elements, element)
+		}
+		current.Reset()
+		quoted = false
+	}
+
+	for _, r := range body {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case r == ',' && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if inQuotes || escaped {
+		return nil, errArrayLiteral
+	}
+	flush()
+
+	return elements, nil
+}
+
+// ParseLegacyList reads the formats clients used to be stored in before the
+// array columns: a JSON array, a Postgres array literal, or a comma and/or
+// whitespace separated string. Empty and duplicate entries are removed.
+func ParseLegacyList(value string) StringArray {
+	value = strings.TrimSpace(value)
+
+	var items []string
+	switch {
+	case strings.HasPrefix(value, "["):
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			items = splitList(strings.Trim(value, "[]"))
+		}
+	case strings.HasPrefix(value, "{"):
+		parsed, err := parseArrayLiteral(value)
+		if err != nil {
+			items = splitList(strings.Trim(value, "{}"))
+		} else {
+			items = parsed
+		}
+	default:
+		items = splitList(value)
+	}
+
+	list := StringArray{}
+	for _, item := range items {
+		item = strings.Trim(strings.TrimSpace(item), `"`)
+		if item != "" && !list.Contains(item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
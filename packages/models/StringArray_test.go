@@ -0,0 +1,110 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStringArrayRoundTrip(t *testing.T) {
+	arrays := []StringArray{
+		{},
+		{"openid"},
+		{"openid", "profile", "email"},
+		{"https://app.example.com/callback?a=1,b=2", "http://localhost:3000/cb"},
+		{`say "hi"`, `back\slash`, "two words", "{braces}", "NULL", "", "café"},
+	}
+
+	for _, array := range arrays {
+		value, err := array.Value()
+		if err != nil {
+			t.Fatalf("Value(%q) = %v", array, err)
+		}
+
+		var scanned StringArray
+		if err := scanned.Scan([]byte(value.(string))); err != nil || !reflect.DeepEqual(scanned, array) {
+			t.Errorf("Scan(%s) = %q, %v, want %q", value, scanned, err, array)
+		}
+	}
+}
+
+func TestStringArrayScan(t *testing.T) {
+	tests := []struct {
+		value any
+		want  StringArray
+	}{
+		{value: nil, want: StringArray{}},
+		{value: "{}", want: StringArray{}},
+		{value: "{a,b}", want: StringArray{"a", "b"}},
+		// Postgres only quotes when it has to, unquoted NULL is a null element
+		{value: `{openid,"two words",NULL,"NULL"}`, want: StringArray{"openid", "two words", "NULL"}},
+		{value: []byte(`{"a\"b","c\\d"}`), want: StringArray{`a"b`, `c\d`}},
+	}
+
+	for _, test := range tests {
+		var scanned StringArray
+		if err := scanned.Scan(test.value); err != nil || !reflect.DeepEqual(scanned, test.want) {
+			t.Errorf("Scan(%v) = %q, %v, want %q", test.value, scanned, err, test.want)
+		}
+	}
+
+	for _, value := range []any{"", "a,b", "{a", `{"open}`, `{a\}`, 42} {
+		var scanned StringArray
+		if err := scanned.Scan(value); err == nil {
+			t.Errorf("Scan(%v) = %q, want an error", value, scanned)
+		}
+	}
+
+	if value, err := StringArray(nil).Value(); err != nil || value != "{}" {
+		t.Errorf("nil Value = %v, %v, want {}", value, err)
+	}
+}
+
+func TestParseLegacyList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  StringArray
+	}{
+		{value: "", want: StringArray{}},
+		{value: "   ", want: StringArray{}},
+		{value: `["https://a.example.com/cb","https://b.example.com/cb"]`, want: StringArray{"https://a.example.com/cb", "https://b.example.com/cb"}},
+		{value: `{authorization_code,refresh_token}`, want: StringArray{"authorization_code", "refresh_token"}},
+		{value: `{"openid","profile"}`, want: StringArray{"openid", "profile"}},
+		{value: "openid profile email", want: StringArray{"openid", "profile", "email"}},
+		{value: "openid,profile, email", want: StringArray{"openid", "profile", "email"}},
+		{value: " code \n", want: StringArray{"code"}},
+		// Empty and duplicate entries are dropped, order is kept
+		{value: "openid,,openid profile", want: StringArray{"openid", "profile"}},
+		{value: `["openid","","openid"]`, want: StringArray{"openid"}},
+		// Broken JSON and array literals still give up their entries
+		{value: `["openid", "profile"`, want: StringArray{"openid", "profile"}},
+		{value: `[openid, profile]`, want: StringArray{"openid", "profile"}},
+		{value: `{"openid,profile}`, want: StringArray{"openid", "profile"}},
+	}
+
+	for _, test := range tests {
+		if got := ParseLegacyList(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseLegacyList(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseLegacyListRoundTrip(t *testing.T) {
+	lists := []StringArray{
+		{"openid"},
+		{"authorization_code", "refresh_token"},
+		{"https://app.example.com/callback?x=1,2", "http://localhost:8080/cb"},
+	}
+
+	// Whatever format a list was stored in, it migrates to the same array
+	for _, list := range lists {
+		literal, _ := list.Value()
+		encoded, _ := json.Marshal(list)
+
+		for _, stored := range []string{literal.(string), string(encoded)} {
+			if got := ParseLegacyList(stored); !reflect.DeepEqual(got, list) {
+				t.Errorf("ParseLegacyList(%s) = %q, want %q", stored, got, list)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// StringMap maps a jsonb object of strings to a Go map
+type StringMap map[string]string
+
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(map[string]string(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *StringMap) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = StringMap{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported string map type %T", value)
+	}
+
+	decoded := StringMap{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*m = decoded
+	return nil
+}
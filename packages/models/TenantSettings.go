@@ -0,0 +1,363 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TenantSettingsVersion is bumped whenever the stored layout changes, older
+// documents are upgraded by tenantSettingsMigrations when they are read
+const TenantSettingsVersion = 3
+
+var (
+	SupportedGrantTypes = []string{"authorization_code", "refresh_token"} // the grants the token endpoint implements
+	SupportedMFAMethods = []string{"totp", "webauthn", "email"}
+)
+
+// MaxPasswordHistorySize bounds password_policy.history_size and how many old hashes are kept per user
+const MaxPasswordHistorySize = 24
+
+var ErrInvalidTenantSettings = errors.New("invalid tenant settings")
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// TenantSettings is the typed jsonb document stored in tenants.settings
+type TenantSettings struct {
+	Version           int                    `json:"version"`
+	Tokens            TokenSettings          `json:"tokens"`
+	AllowedGrantTypes []string               `json:"allowed_grant_types"`
+	PasswordPolicy    PasswordPolicySettings `json:"password_policy"`
+	MFA               MFASettings            `json:"mfa"`
+	Branding          BrandingSettings       `json:"branding"`
+	Session           SessionSettings        `json:"session"`
+	Lockout           LockoutSettings        `json:"lockout"`
+}
+
+// TokenSettings lifetimes are in seconds
+type TokenSettings struct {
+	AccessTokenTTL       uint32 `json:"access_token_ttl"`
+	IDTokenTTL           uint32 `json:"id_token_ttl"`
+	RefreshTokenTTL      uint32 `json:"refresh_token_ttl"` // 0 disables refresh tokens
+	AuthorizationCodeTTL uint32 `json:"authorization_code_ttl"`
+}
+
+type PasswordPolicySettings struct {
+	MinLength        int  `json:"min_length"`
+	RequireUppercase bool `json:"require_uppercase"`
+	RequireLowercase bool `json:"require_lowercase"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	HistorySize      int  `json:"history_size"`   // the last N passwords cannot be reused, 0 disables
+	MaxAgeDays       int  `json:"max_age_days"`   // passwords expire after this many days, 0 disables
+	CheckBreached    bool `json:"check_breached"` // reject passwords found in the breached password corpus
+}
+
+type MFASettings struct {
+	Required bool     `json:"required"`
+	Methods  []string `json:"methods"`
+}
+
+type BrandingSettings struct {
+	DisplayName  string `json:"display_name"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"`
+}
+
+// SessionSettings timeouts are in seconds
+type SessionSettings struct {
+	IdleTimeout     uint32 `json:"idle_timeout"`
+	AbsoluteTimeout uint32 `json:"absolute_timeout"`
+}
+
+// LockoutSettings tune brute-force protection, durations are in seconds
+type LockoutSettings struct {
+	MaxFailures          int    `json:"max_failures"`           // failures per user before a temporary lock, 0 disables locking
+	Duration             uint32 `json:"duration"`               // how long a user or IP stays locked
+	Window               uint32 `json:"window"`                 // failures older than this are forgotten
+	DelayAfter           int    `json:"delay_after"`            // failures before progressive delays start
+	MaxDelay             uint32 `json:"max_delay"`              // upper bound of the progressive delay
+	MaxFailuresPerIP     int    `json:"max_failures_per_ip"`    // failures from one IP before it is locked, 0 disables
+	TenantDelayThreshold int    `json:"tenant_delay_threshold"` // tenant wide failures before every attempt is delayed, 0 disables
+}
+
+func DefaultLockoutSettings() LockoutSettings {
+	return LockoutSettings{
+		MaxFailures:          10,
+		Duration:             15 * 60,
+		Window:               15 * 60,
+		DelayAfter:           3,
+		MaxDelay:             30,
+		MaxFailuresPerIP:     50,
+		TenantDelayThreshold: 1000,
+	}
+}
+
+func DefaultTenantSettings() TenantSettings {
+	return TenantSettings{
+		Version: TenantSettingsVersion,
+		Tokens: TokenSettings{
+			AccessTokenTTL:       3600,
+			IDTokenTTL:           3600,
+			RefreshTokenTTL:      30 * 86400,
+			AuthorizationCodeTTL: 60,
+		},
+		AllowedGrantTypes: []string{"authorization_code", "refresh_token"},
+		PasswordPolicy: PasswordPolicySettings{
+			MinLength:     10,
+			CheckBreached: true,
+		},
+		MFA: MFASettings{
+			Required: false,
+			Methods:  []string{"totp"},
+		},
+		Session: SessionSettings{
+			IdleTimeout:     30 * 60,
+			AbsoluteTimeout: 7 * 86400,
+		},
+		Lockout: DefaultLockoutSettings(),
+	}
+}
+
+// tenantSettingsMigrations upgrade a raw document from version N to N+1
+var tenantSettingsMigrations = map[int]func(doc map[string]any){
+	// Version 0 is any document written before settings were versioned,
+	// its keys already match the version 1 layout
+	0: func(doc map[string]any) {},
+	// Version 2 added password history, max age and the breached password check.
+	// Existing tenants keep accepting the passwords they accepted before.
+	1: func(doc map[string]any) {
+		policy, ok := doc["password_policy"].(map[string]any)
+		if !ok {
+			policy = map[string]any{}
+			doc["password_policy"] = policy
+		}
+		if _, set := policy["check_breached"]; !set {
+			policy["check_breached"] = false
+		}
+	},
+	// Version 3 added lockout, the defaults apply to existing tenants
+	2: func(doc map[string]any) {},
+}
+
+// ParseTenantSettings migrates a stored document forward and merges it over the defaults
+func ParseTenantSettings(data []byte) (TenantSettings, error) {
+	settings := DefaultTenantSettings()
+	if len(data) == 0 || string(data) == "null" {
+		return settings, nil
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return settings, fmt.Errorf("invalid tenant settings: %w", err)
+	}
+
+	version := 0
+	if v, ok := doc["version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version > TenantSettingsVersion {
+		return settings, fmt.Errorf("tenant settings version %d is newer than supported version %d", version, TenantSettingsVersion)
+	}
+
+	for ; version < TenantSettingsVersion; version++ {
+		if migrate, ok := tenantSettingsMigrations[version]; ok {
+			migrate(doc)
+		}
+	}
+	doc["version"] = TenantSettingsVersion
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return settings, err
+	}
+
+	// Unmarshalling over the defaults keeps them for any key the document omits
+	if err := json.Unmarshal(migrated, &settings); err != nil {
+		return DefaultTenantSettings(), fmt.Errorf("invalid tenant settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// ApplyPatch returns a copy of the settings with a JSON merge patch (RFC 7386) applied
+func (s TenantSettings) ApplyPatch(patch []byte) (TenantSettings, error) {
+	current, err := json.Marshal(s)
+	if err != nil {
+		return s, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return s, err
+	}
+
+	var patchDoc map[string]any
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return s, fmt.Errorf("invalid settings patch: %w", err)
+	}
+
+	// The version is owned by the server
+	delete(patchDoc, "version")
+
+	merged, err := json.Marshal(mergePatch(doc, patchDoc))
+	if err != nil {
+		return s, err
+	}
+
+	return ParseTenantSettings(merged)
+}
+
+func mergePatch(target map[string]any, patch map[string]any) map[string]any {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+
+		patchChild, isObject := value.(map[string]any)
+		targetChild, targetIsObject := target[key].(map[string]any)
+		if isObject && targetIsObject {
+			target[key] = mergePatch(targetChild, patchChild)
+			continue
+		}
+
+		target[key] = value
+	}
+	return target
+}
+
+// Validate returns every problem at once so the console can show them together
+func (s TenantSettings) Validate() error {
+	var problems []string
+	add := func(field string, msg string) {
+		problems = append(problems, field+": "+msg)
+	}
+
+	if s.Tokens.AccessTokenTTL < 60 || s.Tokens.AccessTokenTTL > 86400 {
+		add("tokens.access_token_ttl", "must be between 60 and 86400 seconds")
+	}
+	if s.Tokens.IDTokenTTL < 60 || s.Tokens.IDTokenTTL > 86400 {
+		add("tokens.id_token_ttl", "must be between 60 and 86400 seconds")
+	}
+	if s.Tokens.RefreshTokenTTL > 365*86400 {
+		add("tokens.refresh_token_ttl", "must be at most one year")
+	}
+	if s.Tokens.AuthorizationCodeTTL < 10 || s.Tokens.AuthorizationCodeTTL > 600 {
+		add("tokens.authorization_code_ttl", "must be between 10 and 600 seconds")
+	}
+
+	if len(s.AllowedGrantTypes) == 0 {
+		add("allowed_grant_types", "must contain at least one grant type")
+	}
+	for _, grantType := range s.AllowedGrantTypes {
+		if !slices.Contains(SupportedGrantTypes, grantType) {
+			add("allowed_grant_types", fmt.Sprintf("unsupported grant type %q", grantType))
+		}
+	}
+	if slices.Contains(s.AllowedGrantTypes, "refresh_token") && s.Tokens.RefreshTokenTTL == 0 {
+		add("tokens.refresh_token_ttl", "must be set when refresh_token is an allowed grant type")
+	}
+
+	if s.PasswordPolicy.MinLength < 8 || s.PasswordPolicy.MinLength > 128 {
+		add("password_policy.min_length", "must be between 8 and 128")
+	}
+	if s.PasswordPolicy.HistorySize < 0 || s.PasswordPolicy.HistorySize > MaxPasswordHistorySize {
+		add("password_policy.history_size", fmt.Sprintf("must be between 0 and %d", MaxPasswordHistorySize))
+	}
+	if s.PasswordPolicy.MaxAgeDays < 0 || s.PasswordPolicy.MaxAgeDays > 3650 {
+		add("password_policy.max_age_days", "must be between 0 and 3650")
+	}
+
+	for _, method := range s.MFA.Methods {
+		if !slices.Contains(SupportedMFAMethods, method) {
+			add("mfa.methods", fmt.Sprintf("unsupported method %q", method))
+		}
+	}
+	if s.MFA.Required && len(s.MFA.Methods) == 0 {
+		add("mfa.methods", "must contain at least one method when mfa is required")
+	}
+
+	if len(s.Branding.DisplayName) > 100 {
+		add("branding.display_name", "must be at most 100 characters")
+	}
+	if s.Branding.LogoURL != "" {
+		u, err := url.Parse(s.Branding.LogoURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			add("branding.logo_url", "must be an https URL")
+		}
+	}
+	if s.Branding.PrimaryColor != "" && !hexColor.MatchString(s.Branding.PrimaryColor) {
+		add("branding.primary_color", "must be a hex color like #1a2b3c")
+	}
+
+	if s.Session.AbsoluteTimeout < 300 || s.Session.AbsoluteTimeout > 90*86400 {
+		add("session.absolute_timeout", "must be between 300 seconds and 90 days")
+	}
+	if s.Session.IdleTimeout < 60 || s.Session.IdleTimeout > s.Session.AbsoluteTimeout {
+		add("session.idle_timeout", "must be at least 60 seconds and no longer than absolute_timeout")
+	}
+
+	if s.Lockout.MaxFailures < 0 || s.Lockout.MaxFailures > 1000 {
+		add("lockout.max_failures", "must be between 0 and 1000")
+	}
+	if s.Lockout.MaxFailures > 0 && (s.Lockout.Duration < 60 || s.Lockout.Duration > 86400) {
+		add("lockout.duration", "must be between 60 and 86400 seconds")
+	}
+	if s.Lockout.Window < 60 || s.Lockout.Window > 86400 {
+		add("lockout.window", "must be between 60 and 86400 seconds")
+	}
+	if s.Lockout.DelayAfter < 1 {
+		add("lockout.delay_after", "must be at least 1")
+	}
+	if s.Lockout.MaxDelay > 300 {
+		add("lockout.max_delay", "must be at most 300 seconds")
+	}
+	if s.Lockout.MaxFailuresPerIP < 0 {
+		add("lockout.max_failures_per_ip", "must not be negative")
+	}
+	if s.Lockout.TenantDelayThreshold < 0 {
+		add("lockout.tenant_delay_threshold", "must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidTenantSettings, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Scan implements sql.Scanner so the jsonb column is read as typed, migrated settings
+func (s *TenantSettings) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported tenant settings type %T", value)
+	}
+
+	settings, err := ParseTenantSettings(data)
+	if err != nil {
+		return err
+	}
+
+	*s = settings
+	return nil
+}
+
+// Value implements driver.Valuer, unset settings are stored as the defaults
+func (s TenantSettings) Value() (driver.Value, error) {
+	if s.Version == 0 {
+		s = DefaultTenantSettings()
+	}
+	return json.Marshal(s)
+}
@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Client is this server acting as a relying party of an upstream provider
+type Client struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+	Metadata     *Metadata
+	HTTPClient   *http.Client
+}
+
+// TokenResponse is the token endpoint answer, only the ID token is used
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// CodeChallenge is the S256 PKCE challenge for verifier (RFC 7636)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the browser is sent to sign in upstream
+func (c *Client) AuthCodeURL(state string, nonce string, verifier string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.ClientID},
+		"redirect_uri":  {c.RedirectURI},
+		"scope":         {strings.Join(c.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	if verifier != "" && c.Metadata.SupportsPKCE() {
+		query.Set("code_challenge", CodeChallenge(verifier))
+		query.Set("code_challenge_method", "S256")
+	}
+
+	separator := "?"
+	if strings.Contains(c.Metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return c.Metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange redeems the authorization code at the token endpoint
+func (c *Client) Exchange(ctx context.Context, code string, verifier string) (*TokenResponse, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {c.RedirectURI},
+	}
+
+	if verifier != "" && c.Metadata.SupportsPKCE() {
+		form.Set("code_verifier", verifier)
+	}
+
+	// client_secret_basic is the default (RFC 8414), post only when that is all the provider takes
+	basic := c.ClientSecret != "" && (len(c.Metadata.TokenEndpointAuthMethodsSupported) == 0 ||
+		slices.Contains(c.Metadata.TokenEndpointAuthMethodsSupported, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			form.Set("client_secret", c.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &failure)
+		return nil, fmt.Errorf("%w: %d %s %s", ErrTokenRequest, resp.StatusCode, failure.Error, failure.ErrorDescription)
+	}
+
+	var tokens TokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrTokenRequest)
+	}
+	return &tokens, nil
+}
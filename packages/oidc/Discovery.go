@@ -0,0 +1,124 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDiscovery    = errors.New("openid provider discovery failed")
+	ErrTokenRequest = errors.New("token request failed")
+	ErrInvalidToken = errors.New("invalid id token")
+)
+
+// TenantIDPlaceholder appears in the issuer of multi-tenant providers (Microsoft
+// common and organizations endpoints), the ID token carries the real tenant in tid
+const TenantIDPlaceholder = "{tenantid}"
+
+// maxResponseSize bounds what is read from an upstream provider
+const maxResponseSize = 1 << 20
+
+// DefaultClient is used when no HTTP client is given, it does not follow the
+// provider forever
+var DefaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Metadata is the subset of the OpenID Provider metadata a relying party needs
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Discover fetches {issuer}/.well-known/openid-configuration and checks it belongs to issuer
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	var metadata Metadata
+	if err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+
+	if !IssuerMatches(metadata.Issuer, issuer) {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, metadata.Issuer, issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+
+	return &metadata, nil
+}
+
+// IssuerMatches compares the issuer a provider announced with the configured one.
+// Multi-tenant providers announce a {tenantid} template for every tenant path.
+func IssuerMatches(announced string, configured string) bool {
+	announced = strings.TrimSuffix(announced, "/")
+	configured = strings.TrimSuffix(configured, "/")
+	if announced == configured {
+		return true
+	}
+
+	prefix, suffix, ok := strings.Cut(announced, TenantIDPlaceholder)
+	if !ok {
+		return false
+	}
+	return strings.HasPrefix(configured, prefix) && strings.HasSuffix(configured, suffix) &&
+		len(configured) > len(prefix)+len(suffix)
+}
+
+// SupportsPKCE reports whether the provider takes S256 code challenges. Providers
+// that do not list the methods are sent one anyway, unknown parameters are ignored.
+func (m *Metadata) SupportsPKCE() bool {
+	if len(m.CodeChallengeMethodsSupported) == 0 {
+		return true
+	}
+	for _, method := range m.CodeChallengeMethodsSupported {
+		if method == "S256" {
+			return true
+		}
+	}
+	return false
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	if client == nil {
+		client = DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrDiscovery, url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the upstream clock may be off
+const clockSkew = time.Minute
+
+// Claims are the ID token claims used to find or provision a user
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         float64  `json:"exp"`
+	IssuedAt          float64  `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     Bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	Picture           string   `json:"picture"`
+	Locale            string   `json:"locale"`
+	PreferredUsername string   `json:"preferred_username"`
+	TenantID          string   `json:"tid"` // Microsoft directory, fills the {tenantid} issuer template
+}
+
+// Audience is a single string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Bool accepts true and "true", some providers send email_verified as a string
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// Expected is what the ID token must match
+type Expected struct {
+	Issuer   string // as configured, may contain {tenantid}
+	ClientID string
+	Nonce    string
+	Now      time.Time
+}
+
+// VerifyIDToken checks the signature against keys and validates the claims as in
+// OpenID Connect Core 3.1.3.7. Symmetric (HS*) and unsigned tokens are refused.
+func VerifyIDToken(raw string, keys *KeySet, expected Expected) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: header", ErrInvalidToken)
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature", ErrInvalidToken)
+	}
+
+	if !verifySignature(header.Alg, keys.find(header.Kid), []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, fmt.Errorf("%w: signature", ErrInvalidToken)
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: payload", ErrInvalidToken)
+	}
+
+	if err := claims.validate(expected); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (c *Claims) validate(expected Expected) error {
+	issuer := strings.TrimSuffix(expected.Issuer, "/")
+	if strings.Contains(issuer, TenantIDPlaceholder) {
+		if c.TenantID == "" {
+			return fmt.Errorf("%w: missing tid", ErrInvalidToken)
+		}
+		issuer = strings.ReplaceAll(issuer, TenantIDPlaceholder, c.TenantID)
+	}
+
+	if strings.TrimSuffix(c.Issuer, "/") != issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Issuer)
+	}
+
+	if c.Subject == "" {
+		return fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+
+	if !slices.Contains(c.Audience, expected.ClientID) {
+		return fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+
+	if (len(c.Audience) > 1 || c.AuthorizedParty != "") && c.AuthorizedParty != expected.ClientID {
+		return fmt.Errorf("%w: authorized party", ErrInvalidToken)
+	}
+
+	now := expected.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if c.ExpiresAt == 0 || !now.Add(-clockSkew).Before(time.Unix(int64(c.ExpiresAt), 0)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	if c.IssuedAt != 0 && time.Unix(int64(c.IssuedAt), 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(expected.Nonce)) != 1 {
+		return fmt.Errorf("%w: nonce", ErrInvalidToken)
+	}
+	return nil
+}
+
+// verifySignature tries every candidate key, tokens without a kid may match any of them
+func verifySignature(alg string, candidates []JSONWebKey, signed []byte, signature []byte) bool {
+	for _, jwk := range candidates {
+		if jwk.Alg != "" && jwk.Alg != alg {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		if verifyWithKey(alg, key, signed, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyWithKey(alg string, key crypto.PublicKey, signed []byte, signature []byte) bool {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+
+		hashFunc, digest := digestFor(alg[2:], signed)
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(rsaKey, hashFunc, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hashFunc, digest, signature) == nil
+	case "ES256", "ES384", "ES512":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+
+		curveBits := map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}[alg]
+		if ecKey.Curve.Params().BitSize != curveBits {
+			return false
+		}
+
+		// JOSE signatures are r|s, each padded to the curve size
+		size := (curveBits + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+
+		_, digest := digestFor(alg[2:], signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(ecKey, digest, r, s)
+	case "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(edKey, signed, signature)
+	}
+	return false
+}
+
+func digestFor(bits string, data []byte) (crypto.Hash, []byte) {
+	var h hash.Hash
+	var hashFunc crypto.Hash
+	switch bits {
+	case "384":
+		h, hashFunc = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashFunc = sha512.New(), crypto.SHA512
+	default:
+		h, hashFunc = sha256.New(), crypto.SHA256
+	}
+	h.Write(data)
+	return hashFunc, h.Sum(nil)
+}
@@ -0,0 +1,164 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	provider := newMockProvider(t)
+	keys, err := FetchKeys(context.Background(), provider.server.Client(), provider.server.URL+"/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	expected := Expected{Issuer: provider.server.URL, ClientID: testClientID, Nonce: "nonce-1", Now: now}
+
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":            provider.server.URL,
+			"sub":            "upstream-1",
+			"aud":            testClientID,
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          "nonce-1",
+			"email":          "ada@example.com",
+			"email_verified": true,
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	// Alg confusion: the RSA public key, as the JWKS publishes it, used as an HMAC secret
+	publicDER, _ := x509.MarshalPKIXPublicKey(&provider.rsaKey.PublicKey)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name     string
+		token    string
+		expected Expected
+		want     bool
+	}{
+		{name: "RS256", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(nil)), expected: expected, want: true},
+		{name: "ES256", token: signToken("ES256", "ec-1", provider.ecKey, claims(nil)), expected: expected, want: true},
+		{name: "no kid tries every key", token: signToken("ES256", "", provider.ecKey, claims(nil)), expected: expected, want: true},
+		{name: "issuer with a trailing slash", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["iss"] = provider.server.URL + "/" })), expected: expected, want: true},
+		{name: "audience list with azp", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["aud"], c["azp"] = []string{testClientID, "other"}, testClientID })), expected: expected, want: true},
+		{name: "expired within the clock skew", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() })), expected: expected, want: true},
+
+		// Claims
+		{name: "other nonce", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["nonce"] = "nonce-2" })), expected: expected},
+		{name: "no nonce", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { delete(c, "nonce") })), expected: expected},
+		{name: "other audience", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["aud"] = "other" })), expected: expected},
+		{name: "audience list without azp", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["aud"] = []string{testClientID, "other"} })), expected: expected},
+		{name: "azp of another client", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["azp"] = "other" })), expected: expected},
+		{name: "other issuer", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), expected: expected},
+		{name: "issuer prefix", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["iss"] = provider.server.URL + "/tenant" })), expected: expected},
+		{name: "expired", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), expected: expected},
+		{name: "no expiry", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { delete(c, "exp") })), expected: expected},
+		{name: "issued in the future", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { c["iat"] = now.Add(5 * time.Minute).Unix() })), expected: expected},
+		{name: "no subject", token: signToken("RS256", "rsa-1", provider.rsaKey, claims(func(c map[string]any) { delete(c, "sub") })), expected: expected},
+
+		// Signatures
+		{name: "HS256 keyed with the RSA public key", token: signToken("HS256", "rsa-1", publicDER, claims(nil)), expected: expected},
+		{name: "HS256 keyed with the JWK modulus", token: signToken("HS256", "rsa-1", provider.rsaKey.N.Bytes(), claims(nil)), expected: expected},
+		{name: "alg none", token: signToken("none", "", nil, claims(nil)), expected: expected},
+		{name: "ES256 header over an RSA signature", token: retagged(signToken("RS256", "rsa-1", provider.rsaKey, claims(nil)), "ES256"), expected: expected},
+		{name: "RS256 header naming the EC key", token: signToken("RS256", "ec-1", provider.rsaKey, claims(nil)), expected: expected},
+		{name: "signed by an unpublished key", token: signToken("RS256", "rsa-1", otherKey, claims(nil)), expected: expected},
+		{name: "unknown kid", token: signToken("RS256", "rsa-2", provider.rsaKey, claims(nil)), expected: expected},
+		{name: "claims changed after signing", token: tampered(signToken("RS256", "rsa-1", provider.rsaKey, claims(nil)), claims(func(c map[string]any) { c["sub"] = "admin" })), expected: expected},
+		{name: "malformed", token: "a.b", expected: expected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := VerifyIDToken(test.token, keys, test.expected)
+			if !test.want {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("VerifyIDToken = %+v, %v, want ErrInvalidToken", verified, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("VerifyIDToken = %v", err)
+			}
+			if verified.Subject != "upstream-1" || verified.Email != "ada@example.com" || !verified.EmailVerified {
+				t.Fatalf("claims = %+v", verified)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenSkipsEncryptionKeys(t *testing.T) {
+	provider := newMockProvider(t)
+
+	encryption := rsaJWK("rsa-1", &provider.rsaKey.PublicKey)
+	encryption.Use = "enc"
+	keys := &KeySet{Keys: []JSONWebKey{encryption}}
+
+	token := signToken("RS256", "rsa-1", provider.rsaKey, map[string]any{"iss": "https://idp.example.com", "sub": "1", "aud": testClientID, "exp": time.Now().Add(time.Minute).Unix()})
+	if _, err := VerifyIDToken(token, keys, Expected{Issuer: "https://idp.example.com", ClientID: testClientID}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyIDToken = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyIDTokenTenantTemplate(t *testing.T) {
+	provider := newMockProvider(t)
+	keys := &KeySet{Keys: []JSONWebKey{rsaJWK("rsa-1", &provider.rsaKey.PublicKey)}}
+	expected := Expected{Issuer: "https://login.example.com/{tenantid}/v2.0", ClientID: testClientID}
+
+	claims := map[string]any{"iss": "https://login.example.com/72f988bf/v2.0", "sub": "1", "aud": testClientID, "exp": time.Now().Add(time.Minute).Unix(), "tid": "72f988bf"}
+	if _, err := VerifyIDToken(signToken("RS256", "rsa-1", provider.rsaKey, claims), keys, expected); err != nil {
+		t.Fatalf("VerifyIDToken = %v", err)
+	}
+
+	// The issuer must name the directory the token says it comes from
+	other := maps.Clone(claims)
+	other["tid"] = "0000aaaa"
+	if _, err := VerifyIDToken(signToken("RS256", "rsa-1", provider.rsaKey, other), keys, expected); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyIDToken with another tid = %v, want ErrInvalidToken", err)
+	}
+
+	delete(other, "tid")
+	if _, err := VerifyIDToken(signToken("RS256", "rsa-1", provider.rsaKey, other), keys, expected); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyIDToken without tid = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestEmailVerifiedAsString(t *testing.T) {
+	tests := map[string]Bool{`true`: true, `"true"`: true, `false`: false, `"false"`: false, `"yes"`: false, `null`: false}
+
+	for data, want := range tests {
+		var got Bool
+		if err := got.UnmarshalJSON([]byte(data)); err != nil || got != want {
+			t.Errorf("Bool(%s) = %v, %v, want %v", data, got, err, want)
+		}
+	}
+}
+
+// retagged swaps the alg of a signed token's header and keeps the signature
+func retagged(token string, alg string) string {
+	parts := strings.Split(token, ".")
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","kid":"rsa-1"}`))
+	return header + "." + parts[1] + "." + parts[2]
+}
+
+// tampered replaces the payload of a signed token with claims
+func tampered(token string, claims map[string]any) string {
+	parts := strings.Split(token, ".")
+	forged := strings.Split(signToken("none", "", nil, claims), ".")
+	return parts[0] + "." + forged[1] + "." + parts[2]
+}
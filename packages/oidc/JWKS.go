@@ -0,0 +1,116 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported json web key")
+
+// JSONWebKey is one entry of a provider's jwks_uri (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type KeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// FetchKeys downloads the provider's signing keys
+func FetchKeys(ctx context.Context, client *http.Client, jwksURI string) (*KeySet, error) {
+	var keys KeySet
+	if err := getJSON(ctx, client, jwksURI, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// find returns the signing keys that may have produced a token with kid, all of
+// them when the token names no key
+func (s *KeySet) find(kid string) []JSONWebKey {
+	var found []JSONWebKey
+	for _, key := range s.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if kid == "" || key.Kid == kid {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+// PublicKey decodes the key into an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil || len(n) < 256 {
+			return nil, fmt.Errorf("%w: RSA modulus", ErrUnsupportedKey)
+		}
+
+		e, err := decodeSegment(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: RSA exponent", ErrUnsupportedKey)
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", ErrUnsupportedKey, k.Crv)
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		x, errX := decodeSegment(k.X)
+		y, errY := decodeSegment(k.Y)
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("%w: EC point", ErrUnsupportedKey)
+		}
+
+		point := append([]byte{4}, append(x, y...)...)
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		return key, nil
+	case "OKP":
+		x, err := decodeSegment(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: OKP key", ErrUnsupportedKey)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("%w: kty %q", ErrUnsupportedKey, k.Kty)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
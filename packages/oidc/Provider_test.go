@@ -0,0 +1,358 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testClientID     = "relying-party"
+	testClientSecret = "s3cret:with+reserved/chars"
+	testCode         = "code-1"
+)
+
+// testKeys are generated once, RSA key generation dominates the test time otherwise
+var testKeys = sync.OnceValues(func() (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return rsaKey, ecKey
+})
+
+// mockProvider is an OpenID provider on httptest: discovery, JWKS and a token
+// endpoint that checks the client and the PKCE verifier
+type mockProvider struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	issuer      string   // announced in discovery, the server URL unless set
+	authMethods []string // token_endpoint_auth_methods_supported
+	pkce        []string // code_challenge_methods_supported
+	challenge   string   // the code_challenge the authorization request carried
+	idToken     string   // returned by the token endpoint
+
+	mu        sync.Mutex
+	tokenForm url.Values
+	basicAuth [2]string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	p := &mockProvider{}
+	p.rsaKey, p.ecKey = testKeys()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := p.issuer
+		if issuer == "" {
+			issuer = p.server.URL
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                issuer,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"code_challenge_methods_supported":      p.pkce,
+			"token_endpoint_auth_methods_supported": p.authMethods,
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, KeySet{Keys: []JSONWebKey{rsaJWK("rsa-1", &p.rsaKey.PublicKey), ecJWK("ec-1", &p.ecKey.PublicKey)}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	p.mu.Lock()
+	p.tokenForm = r.PostForm
+	username, password, basic := r.BasicAuth()
+	if basic {
+		username, _ = url.QueryUnescape(username)
+		password, _ = url.QueryUnescape(password)
+		p.basicAuth = [2]string{username, password}
+	}
+	p.mu.Unlock()
+
+	if !basic {
+		username, password = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if username != testClientID || password != testClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != testCode {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if p.challenge != "" && CodeChallenge(r.PostForm.Get("code_verifier")) != p.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TokenResponse{AccessToken: "upstream-access", TokenType: "Bearer", IDToken: p.idToken, ExpiresIn: 3600})
+}
+
+func (p *mockProvider) client(t *testing.T) *Client {
+	t.Helper()
+
+	metadata, err := Discover(context.Background(), p.server.Client(), p.server.URL)
+	if err != nil {
+		t.Fatalf("Discover = %v", err)
+	}
+	return &Client{
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURI:  "https://id.example.com/t/acme/federation/callback",
+		Scopes:       []string{"openid", "email"},
+		Metadata:     metadata,
+		HTTPClient:   p.server.Client(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) JSONWebKey {
+	point, err := key.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	return JSONWebKey{
+		Kty: "EC",
+		Kid: kid,
+		Use: "sig",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(point[1:33]),
+		Y:   base64.RawURLEncoding.EncodeToString(point[33:]),
+	}
+}
+
+// signToken produces a compact JWS. key is an *rsa.PrivateKey, an *ecdsa.PrivateKey,
+// the []byte secret for HS256, or nil for alg none.
+func signToken(alg string, kid string, key any, claims map[string]any) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestDiscover(t *testing.T) {
+	provider := newMockProvider(t)
+
+	metadata, err := Discover(context.Background(), provider.server.Client(), provider.server.URL+"/")
+	if err != nil {
+		t.Fatalf("Discover = %v", err)
+	}
+	if metadata.Issuer != provider.server.URL || metadata.TokenEndpoint != provider.server.URL+"/token" || metadata.JWKSURI != provider.server.URL+"/jwks" {
+		t.Fatalf("metadata = %+v", metadata)
+	}
+
+	// A document served at one issuer but naming another is someone else's
+	provider.issuer = "https://accounts.example.com"
+	if _, err := Discover(context.Background(), provider.server.Client(), provider.server.URL); !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("Discover with another issuer = %v, want ErrDiscovery", err)
+	}
+
+	if _, err := Discover(context.Background(), provider.server.Client(), provider.server.URL+"/missing"); !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("Discover of a missing document = %v, want ErrDiscovery", err)
+	}
+}
+
+func TestIssuerMatches(t *testing.T) {
+	tests := []struct {
+		announced  string
+		configured string
+		want       bool
+	}{
+		{announced: "https://accounts.example.com", configured: "https://accounts.example.com/", want: true},
+		{announced: "https://accounts.example.com", configured: "https://accounts.example.com.evil.example"},
+		{announced: "https://login.example.com/{tenantid}/v2.0", configured: "https://login.example.com/72f988bf/v2.0", want: true},
+		{announced: "https://login.example.com/{tenantid}/v2.0", configured: "https://login.example.com//v2.0"},
+		{announced: "https://login.example.com/{tenantid}/v2.0", configured: "https://evil.example/72f988bf/v2.0"},
+	}
+
+	for _, test := range tests {
+		if got := IssuerMatches(test.announced, test.configured); got != test.want {
+			t.Errorf("IssuerMatches(%q, %q) = %v, want %v", test.announced, test.configured, got, test.want)
+		}
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	provider := newMockProvider(t)
+	client := provider.client(t)
+
+	parsed, err := url.Parse(client.AuthCodeURL("state-1", "nonce-1", "verifier-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("state") != "state-1" || query.Get("nonce") != "nonce-1" || query.Get("client_id") != testClientID || query.Get("scope") != "openid email" {
+		t.Fatalf("AuthCodeURL = %s", parsed)
+	}
+	if query.Get("code_challenge") != CodeChallenge("verifier-1") || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("AuthCodeURL = %s, want an S256 challenge", parsed)
+	}
+
+	// RFC 7636 appendix B
+	if got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("CodeChallenge = %s", got)
+	}
+
+	client.Metadata.CodeChallengeMethodsSupported = []string{"plain"}
+	client.Metadata.AuthorizationEndpoint += "?prompt=login"
+	parsed, _ = url.Parse(client.AuthCodeURL("state-1", "nonce-1", "verifier-1"))
+	if parsed.Query().Has("code_challenge") || parsed.Query().Get("prompt") != "login" {
+		t.Fatalf("AuthCodeURL = %s", parsed)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	provider := newMockProvider(t)
+	provider.challenge = CodeChallenge("verifier-1")
+	provider.idToken = "id-token"
+
+	t.Run("client_secret_basic and PKCE", func(t *testing.T) {
+		response, err := provider.client(t).Exchange(context.Background(), testCode, "verifier-1")
+		if err != nil {
+			t.Fatalf("Exchange = %v", err)
+		}
+		if response.IDToken != "id-token" {
+			t.Fatalf("response = %+v", response)
+		}
+
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		if provider.basicAuth != [2]string{testClientID, testClientSecret} || provider.tokenForm.Has("client_secret") {
+			t.Fatalf("client authentication = %v, form %v", provider.basicAuth, provider.tokenForm)
+		}
+	})
+
+	t.Run("client_secret_post", func(t *testing.T) {
+		provider.authMethods = []string{"client_secret_post"}
+		defer func() { provider.authMethods = nil }()
+
+		if _, err := provider.client(t).Exchange(context.Background(), testCode, "verifier-1"); err != nil {
+			t.Fatalf("Exchange = %v", err)
+		}
+
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		if provider.tokenForm.Get("client_secret") != testClientSecret {
+			t.Fatalf("form = %v", provider.tokenForm)
+		}
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		_, err := provider.client(t).Exchange(context.Background(), testCode, "verifier-2")
+		if !errors.Is(err, ErrTokenRequest) || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Fatalf("Exchange = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		client := provider.client(t)
+		client.ClientSecret = "guess"
+		if _, err := client.Exchange(context.Background(), testCode, "verifier-1"); !errors.Is(err, ErrTokenRequest) {
+			t.Fatalf("Exchange = %v, want ErrTokenRequest", err)
+		}
+	})
+
+	t.Run("no id_token", func(t *testing.T) {
+		provider.idToken = ""
+		defer func() { provider.idToken = "id-token" }()
+
+		if _, err := provider.client(t).Exchange(context.Background(), testCode, "verifier-1"); !errors.Is(err, ErrTokenRequest) {
+			t.Fatalf("Exchange = %v, want ErrTokenRequest", err)
+		}
+	})
+}
+
+func TestFetchKeys(t *testing.T) {
+	provider := newMockProvider(t)
+
+	keys, err := FetchKeys(context.Background(), provider.server.Client(), provider.server.URL+"/jwks")
+	if err != nil {
+		t.Fatalf("FetchKeys = %v", err)
+	}
+	if len(keys.find("")) != 2 || len(keys.find("ec-1")) != 1 || len(keys.find("other")) != 0 {
+		t.Fatalf("keys = %+v", keys)
+	}
+
+	for _, key := range keys.Keys {
+		if _, err := key.PublicKey(); err != nil {
+			t.Fatalf("PublicKey(%s) = %v", key.Kid, err)
+		}
+	}
+
+	if _, err := FetchKeys(context.Background(), provider.server.Client(), provider.server.URL+"/missing"); !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("FetchKeys = %v, want ErrDiscovery", err)
+	}
+}
@@ -0,0 +1,87 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedPasswordsDir returns the directory holding the offline breached password
+// corpus, configured with BREACHED_PASSWORDS_DIR. Checks are skipped when it is unset.
+func BreachedPasswordsDir() string {
+	return os.Getenv("BREACHED_PASSWORDS_DIR")
+}
+
+// CheckBreachedCorpus is run at startup. It fails when BREACHED_PASSWORDS_DIR is set but
+// cannot be read, and reports whether the corpus is configured at all so the caller can
+// warn that tenants with check_breached enabled are not actually protected.
+func CheckBreachedCorpus() (bool, error) {
+	dir := BreachedPasswordsDir()
+	if dir == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false, fmt.Errorf("BREACHED_PASSWORDS_DIR: %w", err)
+	}
+	if !info.IsDir() {
+		return false, fmt.Errorf("BREACHED_PASSWORDS_DIR: %s is not a directory", dir)
+	}
+	return true, nil
+}
+
+// IsBreached looks the password up in the configured corpus, see CheckBreached
+func IsBreached(password string) (bool, error) {
+	dir := BreachedPasswordsDir()
+	if dir == "" {
+		return false, nil
+	}
+	return CheckBreached(dir, password)
+}
+
+// CheckBreached looks the password up in a k-anonymity range corpus laid out like the
+// Pwned Passwords range API: one file per 5 character SHA-1 prefix (e.g. "21BD1" or
+// "21BD1.txt") whose lines are the remaining 35 characters of the hash, optionally
+// followed by ":count". Only the file for the password's own prefix is read and a
+// missing prefix file means the password was not found.
+func CheckBreached(dir string, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	var file *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		file, err = os.Open(filepath.Join(dir, name))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read breached password corpus: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password corpus: %w", err)
+	}
+	return false, nil
+}
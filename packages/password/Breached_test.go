@@ -0,0 +1,87 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCorpus stores the password hashes the way the range API serves them
+func writeCorpus(t *testing.T, dir string, name func(prefix string) string, passwords ...string) {
+	t.Helper()
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+		line := "0000000000000000000000000000000000A:3\r\n" + digest[5:] + ":42\r\n"
+		if err := os.WriteFile(filepath.Join(dir, name(digest[:5])), []byte(line), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckBreached(t *testing.T) {
+	dir := t.TempDir()
+	writeCorpus(t, dir, func(prefix string) string { return prefix }, "password123")
+	writeCorpus(t, dir, func(prefix string) string { return prefix + ".txt" }, "letmein")
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{password: "password123", want: true},
+		{password: "letmein", want: true},
+		{password: "Password123", want: false},
+		{password: "correct horse battery", want: false},
+	}
+
+	for _, test := range tests {
+		if breached, err := CheckBreached(dir, test.password); err != nil || breached != test.want {
+			t.Errorf("CheckBreached(%s) = %v, %v, want %v", test.password, breached, err, test.want)
+		}
+	}
+}
+
+func TestIsBreachedUsesConfiguredCorpus(t *testing.T) {
+	dir := t.TempDir()
+	writeCorpus(t, dir, func(prefix string) string { return prefix }, "password123")
+
+	t.Setenv("BREACHED_PASSWORDS_DIR", "")
+	if breached, err := IsBreached("password123"); err != nil || breached {
+		t.Fatalf("IsBreached without a corpus = %v, %v", breached, err)
+	}
+
+	t.Setenv("BREACHED_PASSWORDS_DIR", dir)
+	if breached, err := IsBreached("password123"); err != nil || !breached {
+		t.Fatalf("IsBreached = %v, %v, want true", breached, err)
+	}
+}
+
+func TestCheckBreachedCorpus(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir        string
+		configured bool
+		fails      bool
+	}{
+		{dir: "", configured: false},
+		{dir: dir, configured: true},
+		{dir: filepath.Join(dir, "missing"), fails: true},
+		{dir: file, fails: true},
+	}
+
+	for _, test := range tests {
+		t.Setenv("BREACHED_PASSWORDS_DIR", test.dir)
+		configured, err := CheckBreachedCorpus()
+		if configured != test.configured || (err != nil) != test.fails {
+			t.Errorf("CheckBreachedCorpus(%s) = %v, %v", test.dir, configured, err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Params are the argon2id cost parameters encoded alongside every hash
+type Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follows the OWASP argon2id recommendation (m=64MiB, t=3, p=2)
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Bounds on the parameters read back from a stored hash. Verify runs them on every
+// sign-in, so an imported or tampered hash must not be able to panic argon2 or make
+// it allocate without limit.
+const (
+	maxArgon2Memory     = 1024 * 1024 // KiB, 1GiB
+	maxArgon2Iterations = 32
+	maxPBKDF2Iterations = 10_000_000
+	minKeyLength        = 16
+	maxKeyLength        = 128
+)
+
+var (
+	ErrInvalidHash         = errors.New("invalid password hash format")
+	ErrUnsupportedHash     = errors.New("unsupported password hash algorithm")
+	ErrIncompatibleVersion = errors.New("incompatible argon2 version")
+)
+
+// Hash encodes the password as $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func Hash(password string) (string, error) {
+	return HashWithParams(password, DefaultParams)
+}
+
+func HashWithParams(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against an encoded hash. needsRehash is true when the
+// password matched but the hash is not argon2id with the current DefaultParams,
+// callers should then store a fresh Hash(password).
+func Verify(password string, encoded string) (match bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		return true, p != DefaultParams, nil
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+		}
+		return true, true, nil
+
+	case strings.HasPrefix(encoded, "$pbkdf2-"):
+		ok, err := verifyPBKDF2(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+
+	return false, false, ErrUnsupportedHash
+}
+
+func decodeArgon2id(encoded string) (Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return Params{}, nil, nil, ErrIncompatibleVersion
+	}
+
+	var p Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	if p.Iterations < 1 || p.Iterations > maxArgon2Iterations ||
+		p.Parallelism < 1 ||
+		p.Memory < 8*uint32(p.Parallelism) || p.Memory > maxArgon2Memory {
+		return Params{}, nil, nil, ErrUnsupportedHash
+	}
+
+	salt, err := base64.RawStdEncoding.Strict().DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	p.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.Strict().DecodeString(parts[5])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	p.KeyLength = uint32(len(key))
+	if len(salt) < 8 || len(key) < minKeyLength || len(key) > maxKeyLength {
+		return Params{}, nil, nil, ErrUnsupportedHash
+	}
+
+	return p, salt, key, nil
+}
+
+// verifyPBKDF2 accepts imported hashes as $pbkdf2-sha256$i=600000$<salt>$<key>
+// (sha512 is also accepted), salt and key are unpadded standard base64
+func verifyPBKDF2(password string, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false, ErrInvalidHash
+	}
+
+	var h func() hash.Hash
+	switch parts[1] {
+	case "pbkdf2-sha256":
+		h = sha256.New
+	case "pbkdf2-sha512":
+		h = sha512.New
+	default:
+		return false, ErrUnsupportedHash
+	}
+
+	iterations, err := strconv.Atoi(strings.TrimPrefix(parts[2], "i="))
+	if err != nil || iterations <= 0 {
+		return false, ErrInvalidHash
+	}
+	if iterations > maxPBKDF2Iterations {
+		return false, ErrUnsupportedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	if len(key) < minKeyLength || len(key) > maxKeyLength {
+		return false, ErrUnsupportedHash
+	}
+
+	other, err := pbkdf2.Key(h, password, salt, iterations, len(key))
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
@@ -0,0 +1,61 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	encoded, err := HashWithParams("correct horse battery", Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match, needsRehash, err := Verify("correct horse battery", encoded)
+	if err != nil || !match || !needsRehash {
+		t.Fatalf("Verify = %v, %v, %v; want match needing rehash", match, needsRehash, err)
+	}
+
+	match, _, err = Verify("wrong horse battery", encoded)
+	if err != nil || match {
+		t.Fatalf("Verify wrong password = %v, %v", match, err)
+	}
+}
+
+func TestVerifyRejectsOutOfRangeArgon2Params(t *testing.T) {
+	salt := "c2FsdHNhbHRzYWx0c2FsdA"
+	key := "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	for name, params := range map[string]string{
+		"zero parallelism": "m=1024,t=1,p=0",
+		"zero iterations":  "m=1024,t=0,p=1",
+		"huge memory":      "m=4294967295,t=1,p=1",
+		"tiny memory":      "m=1,t=1,p=4",
+		"many iterations":  "m=1024,t=100000,p=1",
+	} {
+		encoded := strings.Join([]string{"", "argon2id", "v=19", params, salt, key}, "$")
+		if _, _, err := Verify("password", encoded); !errors.Is(err, ErrUnsupportedHash) {
+			t.Errorf("%s: err = %v, want ErrUnsupportedHash", name, err)
+		}
+	}
+}
+
+func TestVerifyRejectsOutOfRangePBKDF2(t *testing.T) {
+	encoded := "$pbkdf2-sha256$i=2000000000$c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5"
+	if _, _, err := Verify("password", encoded); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("err = %v, want ErrUnsupportedHash", err)
+	}
+}
+
+func TestLengthCountsCharacters(t *testing.T) {
+	// MaxLength characters are accepted although most of them take two bytes
+	long := strings.Repeat("é", MaxLength-3) + "Aa1"
+	if err := ValidateStrength(long, ""); errors.Is(err, ErrTooLong) {
+		t.Fatalf("ValidateStrength rejected %d characters as too long", MaxLength)
+	}
+
+	if err := ValidateStrength(long+"x", ""); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("ValidateStrength(%d characters) = %v, want ErrTooLong", MaxLength+1, err)
+	}
+}
@@ -0,0 +1,50 @@
+package password
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CheckPolicy returns one message per rule of the tenant password policy the password breaks
+func CheckPolicy(policy models.PasswordPolicySettings, password string) []string {
+	var problems []string
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", policy.MinLength))
+	}
+
+	if utf8.RuneCountInString(password) > MaxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters", MaxLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if policy.RequireLowercase && !lower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if policy.RequireUppercase && !upper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		problems = append(problems, "must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	return problems
+}
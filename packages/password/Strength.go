@@ -0,0 +1,64 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lengths count characters (runes), not bytes
+const (
+	MinLength = 10
+	MaxLength = 128
+)
+
+var (
+	ErrTooShort      = errors.New("password must be at least 10 characters")
+	ErrTooLong       = errors.New("password must be at most 128 characters")
+	ErrTooSimple     = errors.New("password must contain at least 3 of: lowercase, uppercase, digit, symbol")
+	ErrContainsEmail = errors.New("password must not contain the email address")
+)
+
+// ValidateStrength enforces the minimum password strength for new passwords
+func ValidateStrength(password string, email string) error {
+	if utf8.RuneCountInString(password) < MinLength {
+		return ErrTooShort
+	}
+
+	if utf8.RuneCountInString(password) > MaxLength {
+		return ErrTooLong
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < 3 {
+		return ErrTooSimple
+	}
+
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok && len(local) >= 3 {
+		if strings.Contains(strings.ToLower(password), local) {
+			return ErrContainsEmail
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package permissions
+
+type Permission string
+
+const (
+	AccountRead              Permission = "account:read"
+	AccountUpdate            Permission = "account:update"
+	AccountDelete            Permission = "account:delete"
+	AccountTransferOwnership Permission = "account:transfer_ownership"
+
+	AccountUsersRead   Permission = "account_users:read"
+	AccountUsersManage Permission = "account_users:manage"
+
+	TenantsRead   Permission = "tenants:read"
+	TenantsManage Permission = "tenants:manage"
+
+	ClientsRead   Permission = "clients:read"
+	ClientsManage Permission = "clients:manage"
+)
+
+// readOnly is every permission a member gets
+var readOnly = []Permission{
+	AccountRead,
+	AccountUsersRead,
+	TenantsRead,
+	ClientsRead,
+}
+
+// matrix maps an AccountUser role to the permissions it is granted.
+// Owners are the only role allowed to delete the account or transfer ownership.
+var matrix = map[string][]Permission{
+	"owner": append([]Permission{
+		AccountUpdate,
+		AccountDelete,
+		AccountTransferOwnership,
+		AccountUsersManage,
+		TenantsManage,
+		ClientsManage,
+	}, readOnly...),
+	"admin": append([]Permission{
+		AccountUsersManage,
+		TenantsManage,
+		ClientsManage,
+	}, readOnly...),
+	"member": readOnly,
+}
+
+// Has reports whether role is granted permission, unknown roles get nothing
+func Has(role string, permission Permission) bool {
+	for _, p := range matrix[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// ForRole returns a copy of the permissions granted to role
+func ForRole(role string) []Permission {
+	return append([]Permission(nil), matrix[role]...)
+}
@@ -0,0 +1,87 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+)
+
+// maxMessageSize bounds an inflated message, deflate bombs are cheap to send
+const maxMessageSize = 256 << 10
+
+var ErrInvalidMessage = errors.New("saml message is invalid")
+
+// DecodeRedirect reads a SAMLRequest or SAMLResponse query value, base64 of raw deflate
+func DecodeRedirect(value string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	data, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxMessageSize+1))
+	if err != nil || len(data) > maxMessageSize {
+		return nil, ErrInvalidMessage
+	}
+	return data, nil
+}
+
+// DecodePost reads a SAMLRequest or SAMLResponse form value, plain base64
+func DecodePost(value string) ([]byte, error) {
+	value = strings.Join(strings.Fields(value), "")
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) > maxMessageSize {
+		return nil, ErrInvalidMessage
+	}
+	return data, nil
+}
+
+// EncodePost is the form value of a message sent with the HTTP-POST binding
+func EncodePost(message *Element) string {
+	return base64.StdEncoding.EncodeToString([]byte(message.Canonical()))
+}
+
+// RedirectURL sends message with the HTTP-Redirect binding, param is SAMLRequest or
+// SAMLResponse. The message is signed with a detached query signature when signer is set.
+func RedirectURL(location string, param string, message *Element, relayState string, signer *Signer) (string, error) {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := writer.Write([]byte(message.Canonical())); err != nil {
+		return "", err
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	query := param + "=" + url.QueryEscape(base64.StdEncoding.EncodeToString(compressed.Bytes()))
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+
+	if signer != nil {
+		query, err = signer.signRedirectQuery(query)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	return location + separator + query, nil
+}
@@ -0,0 +1,275 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const namespaceXML = "http://www.w3.org/XML/1998/namespace"
+
+// node is an element of a received document. Prefixes are kept as written so the
+// element can be canonicalized exactly as the sender did when signing it.
+type node struct {
+	prefix   string
+	local    string
+	attrs    []rawAttr
+	scope    map[string]string // namespaces in scope, "" is the default namespace
+	children []any             // *node, string (character data) or xml.ProcInst
+}
+
+type rawAttr struct {
+	prefix string
+	local  string
+	value  string
+}
+
+// space is the namespace URI of the element
+func (n *node) space() string {
+	return n.scope[n.prefix]
+}
+
+func (n *node) is(space string, local string) bool {
+	return n.local == local && n.space() == space
+}
+
+func (n *node) attr(local string) string {
+	for _, attr := range n.attrs {
+		if attr.prefix == "" && attr.local == local {
+			return attr.value
+		}
+	}
+	return ""
+}
+
+// elements returns the child elements with the given name
+func (n *node) elements(space string, local string) []*node {
+	var found []*node
+	for _, child := range n.children {
+		if element, ok := child.(*node); ok && element.is(space, local) {
+			found = append(found, element)
+		}
+	}
+	return found
+}
+
+// element returns the only child with the given name, nil when there is none or several
+func (n *node) element(space string, local string) *node {
+	if found := n.elements(space, local); len(found) == 1 {
+		return found[0]
+	}
+	return nil
+}
+
+func (n *node) text() string {
+	var b strings.Builder
+	for _, child := range n.children {
+		if text, ok := child.(string); ok {
+			b.WriteString(text)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parseDocument reads a message into a tree, keeping prefixes. Documents with a DTD
+// are refused and comments are dropped, as canonicalization without comments does.
+func parseDocument(data []byte) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	var root *node
+	var stack []*node
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			parentScope := map[string]string{"xml": namespaceXML}
+			if len(stack) > 0 {
+				parentScope = stack[len(stack)-1].scope
+			} else if root != nil {
+				return nil, fmt.Errorf("%w: more than one root element", ErrInvalidMessage)
+			}
+
+			n := &node{prefix: t.Name.Space, local: t.Name.Local, scope: parentScope}
+			declared := false
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns",
+					attr.Name.Space == "xmlns":
+					if !declared {
+						n.scope = make(map[string]string, len(parentScope)+1)
+						for prefix, uri := range parentScope {
+							n.scope[prefix] = uri
+						}
+						declared = true
+					}
+					if attr.Name.Space == "xmlns" {
+						n.scope[attr.Name.Local] = attr.Value
+					} else {
+						n.scope[""] = attr.Value
+					}
+				default:
+					n.attrs = append(n.attrs, rawAttr{prefix: attr.Name.Space, local: attr.Name.Local, value: attr.Value})
+				}
+			}
+
+			if _, ok := n.scope[n.prefix]; n.prefix != "" && !ok {
+				return nil, fmt.Errorf("%w: undeclared prefix %q", ErrInvalidMessage, n.prefix)
+			}
+			for _, attr := range n.attrs {
+				if _, ok := n.scope[attr.prefix]; attr.prefix != "" && !ok {
+					return nil, fmt.Errorf("%w: undeclared prefix %q", ErrInvalidMessage, attr.prefix)
+				}
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: unexpected end element", ErrInvalidMessage)
+			}
+			open := stack[len(stack)-1]
+			if open.prefix != t.Name.Space || open.local != t.Name.Local {
+				return nil, fmt.Errorf("%w: mismatched end element", ErrInvalidMessage)
+			}
+			stack = stack[:len(stack)-1]
+
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, string(t))
+			}
+
+		case xml.ProcInst:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, t.Copy())
+			}
+
+		case xml.Directive:
+			return nil, fmt.Errorf("%w: DTDs are not allowed", ErrInvalidMessage)
+		}
+	}
+
+	if root == nil || len(stack) > 0 {
+		return nil, fmt.Errorf("%w: incomplete document", ErrInvalidMessage)
+	}
+	return root, nil
+}
+
+// canonicalize renders n as the apex of an exclusive XML canonicalization without
+// comments. inclusive are the InclusiveNamespaces prefixes ("#default" for the
+// default namespace) and exclude is left out, for the enveloped signature transform.
+func canonicalize(n *node, inclusive []string, exclude *node) string {
+	var b strings.Builder
+	n.canonical(&b, map[string]string{}, inclusive, exclude)
+	return b.String()
+}
+
+func (n *node) canonical(b *strings.Builder, rendered map[string]string, inclusive []string, exclude *node) {
+	// Namespaces visibly utilized by the element and its attributes, plus the inclusive ones
+	used := []string{n.prefix}
+	for _, attr := range n.attrs {
+		if attr.prefix != "" && attr.prefix != "xml" && !slices.Contains(used, attr.prefix) {
+			used = append(used, attr.prefix)
+		}
+	}
+	for _, prefix := range inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if _, ok := n.scope[prefix]; ok && !slices.Contains(used, prefix) {
+			used = append(used, prefix)
+		}
+	}
+	slices.Sort(used)
+
+	b.WriteByte('<')
+	b.WriteString(n.qualified(n.prefix, n.local))
+
+	scope, copied := rendered, false
+	for _, prefix := range used {
+		uri := n.scope[prefix]
+		previous, ok := rendered[prefix]
+		if (ok && previous == uri) || (!ok && prefix == "" && uri == "") {
+			continue
+		}
+
+		if prefix == "" {
+			b.WriteString(` xmlns="` + escapeAttr(uri) + `"`)
+		} else {
+			b.WriteString(" xmlns:" + prefix + `="` + escapeAttr(uri) + `"`)
+		}
+
+		if !copied {
+			scope = make(map[string]string, len(rendered)+1)
+			for k, v := range rendered {
+				scope[k] = v
+			}
+			copied = true
+		}
+		scope[prefix] = uri
+	}
+
+	attrs := slices.Clone(n.attrs)
+	slices.SortFunc(attrs, func(a, c rawAttr) int {
+		if diff := strings.Compare(n.attrSpace(a), n.attrSpace(c)); diff != 0 {
+			return diff
+		}
+		return strings.Compare(a.local, c.local)
+	})
+	for _, attr := range attrs {
+		b.WriteString(" " + n.qualified(attr.prefix, attr.local) + `="` + escapeAttr(attr.value) + `"`)
+	}
+	b.WriteByte('>')
+
+	for _, child := range n.children {
+		switch c := child.(type) {
+		case *node:
+			if c != exclude {
+				c.canonical(b, scope, inclusive, exclude)
+			}
+		case string:
+			b.WriteString(escapeText(c))
+		case xml.ProcInst:
+			b.WriteString("<?" + c.Target)
+			if len(c.Inst) > 0 {
+				b.WriteString(" " + string(c.Inst))
+			}
+			b.WriteString("?>")
+		}
+	}
+
+	b.WriteString("</" + n.qualified(n.prefix, n.local) + ">")
+}
+
+func (n *node) attrSpace(attr rawAttr) string {
+	if attr.prefix == "" {
+		return ""
+	}
+	return n.scope[attr.prefix]
+}
+
+func (n *node) qualified(prefix string, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
@@ -0,0 +1,172 @@
+package saml
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDocumentRefusesDTDs(t *testing.T) {
+	documents := map[string]string{
+		"doctype": `<!DOCTYPE Response><samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"/>`,
+		"entity expansion": `<?xml version="1.0"?><!DOCTYPE lolz [<!ENTITY lol "lol"><!ENTITY lol2 "&lol;&lol;&lol;">]>` +
+			`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">&lol2;</samlp:Response>`,
+		"external entity": `<!DOCTYPE r [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><r>&xxe;</r>`,
+	}
+
+	for name, document := range documents {
+		if _, err := parseDocument([]byte(document)); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: parseDocument = %v, want ErrInvalidMessage", name, err)
+		}
+	}
+}
+
+func TestParseDocumentRefusesMalformedDocuments(t *testing.T) {
+	documents := map[string]string{
+		"undeclared prefix":           `<samlp:Response/>`,
+		"undeclared attribute prefix": `<r xmlns:a="urn:a" b:x="1"/>`,
+		"two roots":                   `<a/><b/>`,
+		"unclosed":                    `<a><b></b>`,
+		"mismatched end":              `<a:r xmlns:a="urn:a" xmlns:b="urn:a"></b:r>`,
+		"empty":                       ``,
+	}
+
+	for name, document := range documents {
+		if _, err := parseDocument([]byte(document)); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: parseDocument = %v, want ErrInvalidMessage", name, err)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		apex      []string // local names of the path from the root to the apex
+		inclusive []string
+		want      string
+	}{
+		{
+			name:     "unused namespaces are dropped and empty elements expanded",
+			document: `<a:r xmlns:a="urn:a" xmlns:b="urn:b" xmlns="urn:d"><a:e/></a:r>`,
+			want:     `<a:r xmlns:a="urn:a"><a:e></a:e></a:r>`,
+		},
+		{
+			name:     "apex declares the namespaces it inherits",
+			document: `<p:Response xmlns:p="urn:p" xmlns:s="urn:s"><s:Assertion ID="x"><s:Issuer>i</s:Issuer></s:Assertion></p:Response>`,
+			apex:     []string{"Assertion"},
+			want:     `<s:Assertion xmlns:s="urn:s" ID="x"><s:Issuer>i</s:Issuer></s:Assertion>`,
+		},
+		{
+			name:     "redeclaring the same namespace is not repeated",
+			document: `<s:a xmlns:s="urn:s"><s:b xmlns:s="urn:s"></s:b></s:a>`,
+			want:     `<s:a xmlns:s="urn:s"><s:b></s:b></s:a>`,
+		},
+		{
+			name:     "prefix rebound to another namespace",
+			document: `<s:a xmlns:s="urn:s"><s:b xmlns:s="urn:other"></s:b></s:a>`,
+			want:     `<s:a xmlns:s="urn:s"><s:b xmlns:s="urn:other"></s:b></s:a>`,
+		},
+		{
+			name:     "default namespace undeclared with xmlns empty",
+			document: `<a xmlns="urn:x"><b xmlns=""><c></c></b></a>`,
+			want:     `<a xmlns="urn:x"><b xmlns=""><c></c></b></a>`,
+		},
+		{
+			name:     "xmlns empty at the apex is not output",
+			document: `<a xmlns="urn:x"><b xmlns=""><c></c></b></a>`,
+			apex:     []string{"b"},
+			want:     `<b><c></c></b>`,
+		},
+		{
+			name:     "xmlns empty without a default namespace in scope is not output",
+			document: `<a><b xmlns=""></b></a>`,
+			want:     `<a><b></b></a>`,
+		},
+		{
+			name:      "inclusive prefixes are output even when unused",
+			document:  `<s:a xmlns:s="urn:s" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:u="urn:u"><s:b></s:b></s:a>`,
+			inclusive: []string{"xs"},
+			want:      `<s:a xmlns:s="urn:s" xmlns:xs="http://www.w3.org/2001/XMLSchema"><s:b></s:b></s:a>`,
+		},
+		{
+			name:      "inclusive default namespace",
+			document:  `<r xmlns:s="urn:s" xmlns="urn:d"><s:a></s:a></r>`,
+			apex:      []string{"a"},
+			inclusive: []string{"#default"},
+			want:      `<s:a xmlns="urn:d" xmlns:s="urn:s"></s:a>`,
+		},
+		{
+			name:      "inclusive prefix not in scope is ignored",
+			document:  `<s:a xmlns:s="urn:s"></s:a>`,
+			inclusive: []string{"xs"},
+			want:      `<s:a xmlns:s="urn:s"></s:a>`,
+		},
+		{
+			name:     "attributes sort unqualified first, then by namespace URI",
+			document: `<a:e xmlns:a="urn:z" xmlns:b="urn:a" b:x="1" a:y="2" z="3" c="4"></a:e>`,
+			want:     `<a:e xmlns:a="urn:z" xmlns:b="urn:a" c="4" z="3" b:x="1" a:y="2"></a:e>`,
+		},
+		{
+			name:     "xml prefix is never declared",
+			document: `<r xml:lang="en"></r>`,
+			want:     `<r xml:lang="en"></r>`,
+		},
+		{
+			name:     "escaping of text and attributes",
+			document: "<r a=\"&lt;&amp;&quot;&#x9;&#xA;&#xD;'&gt;\">&lt;&amp;&gt;\"'&#xD;</r>",
+			want:     "<r a=\"&lt;&amp;&quot;&#x9;&#xA;&#xD;'>\">&lt;&amp;&gt;\"'&#xD;</r>",
+		},
+		{
+			name:     "comments are dropped and processing instructions kept",
+			document: `<r><!-- note --><?pi data?>text</r>`,
+			want:     `<r><?pi data?>text</r>`,
+		},
+		{
+			name:     "character references and CDATA become text",
+			document: `<r>&#65;<![CDATA[<b>]]></r>`,
+			want:     `<r>A&lt;b&gt;</r>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := parseDocument([]byte(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			apex := root
+			for _, local := range test.apex {
+				var next *node
+				for _, child := range apex.children {
+					if element, ok := child.(*node); ok && element.local == local {
+						next = element
+					}
+				}
+				if next == nil {
+					t.Fatalf("no %s element", local)
+				}
+				apex = next
+			}
+
+			if got := canonicalize(apex, test.inclusive, nil); got != test.want {
+				t.Fatalf("canonicalize =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeMatchesElementRendering(t *testing.T) {
+	// Messages built here are sent in their canonical form, parsing them back must not change a byte
+	element := NewElement("samlp:Response", "ID", "_1", "Version", "2.0").
+		AddText("saml:Issuer", `a & <b> "c"`).
+		Add(NewElement("samlp:Status").Add(NewElement("samlp:StatusCode", "Value", StatusSuccess)))
+
+	root, err := parseDocument([]byte(element.Canonical()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := canonicalize(root, nil, nil); got != element.Canonical() {
+		t.Fatalf("canonicalize =\n%s\nwant\n%s", got, element.Canonical())
+	}
+}
@@ -0,0 +1,230 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// IdentityProviderMetadata describes a tenant acting as IdP
+type IdentityProviderMetadata struct {
+	EntityID      string
+	SSOURL        string
+	SLOURL        string
+	Certificates  []*x509.Certificate
+	NameIDFormats []string
+}
+
+// Element builds the md:EntityDescriptor, children follow the schema order
+func (m *IdentityProviderMetadata) Element() *Element {
+	descriptor := NewElement("md:IDPSSODescriptor",
+		"WantAuthnRequestsSigned", "false",
+		"protocolSupportEnumeration", NamespaceProtocol,
+	)
+
+	for _, certificate := range m.Certificates {
+		descriptor.Add(NewElement("md:KeyDescriptor", "use", "signing").Add(
+			NewElement("ds:KeyInfo").Add(
+				NewElement("ds:X509Data").AddText("ds:X509Certificate", base64.StdEncoding.EncodeToString(certificate.Raw)),
+			),
+		))
+	}
+
+	for _, binding := range []string{BindingHTTPRedirect, BindingHTTPPost} {
+		descriptor.Add(NewElement("md:SingleLogoutService", "Binding", binding, "Location", m.SLOURL))
+	}
+
+	for _, format := range m.NameIDFormats {
+		descriptor.AddText("md:NameIDFormat", format)
+	}
+
+	for _, binding := range []string{BindingHTTPRedirect, BindingHTTPPost} {
+		descriptor.Add(NewElement("md:SingleSignOnService", "Binding", binding, "Location", m.SSOURL))
+	}
+
+	return NewElement("md:EntityDescriptor", "entityID", m.EntityID).Add(descriptor)
+}
+
+type keyDescriptor struct {
+	Use          string   `xml:"use,attr"`
+	Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+}
+
+// signingCertificates returns the PEM certificates of the keys usable for signing
+func signingCertificates(keys []keyDescriptor) ([]string, error) {
+	var certificates []string
+	for _, key := range keys {
+		if key.Use != "" && key.Use != "signing" {
+			continue
+		}
+		for _, encoded := range key.Certificates {
+			certificate, err := CertificatePEM(encoded)
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates, nil
+}
+
+// Endpoint is a service location of a given binding
+type Endpoint struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr"`
+}
+
+// ServiceProviderMetadata is what is imported from an SP's EntityDescriptor
+type ServiceProviderMetadata struct {
+	EntityID             string
+	AssertionConsumerURL []string // HTTP-POST endpoints, the default first
+	SingleLogoutURL      string
+	SingleLogoutBinding  string
+	SigningCertificate   string // PEM
+	NameIDFormat         string
+}
+
+type entityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor *struct {
+		KeyDescriptors            []keyDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+		SingleLogoutServices      []Endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleLogoutService"`
+		NameIDFormats             []string        `xml:"urn:oasis:names:tc:SAML:2.0:metadata NameIDFormat"`
+		AssertionConsumerServices []Endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata AssertionConsumerService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SPSSODescriptor"`
+	IDPSSODescriptor *struct {
+		KeyDescriptors      []keyDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+		SingleSignOnService []Endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+// ParseServiceProviderMetadata reads an SP EntityDescriptor. Only the HTTP-POST
+// assertion consumer services are kept, responses are never sent another way.
+func ParseServiceProviderMetadata(data []byte) (*ServiceProviderMetadata, error) {
+	var descriptor entityDescriptor
+	if err := unmarshal(data, &descriptor); err != nil {
+		return nil, err
+	}
+
+	sp := descriptor.SPSSODescriptor
+	if descriptor.EntityID == "" || sp == nil {
+		return nil, fmt.Errorf("%w: not a service provider EntityDescriptor", ErrInvalidMessage)
+	}
+
+	metadata := &ServiceProviderMetadata{EntityID: descriptor.EntityID}
+
+	for _, acs := range sp.AssertionConsumerServices {
+		if acs.Binding != BindingHTTPPost || acs.Location == "" {
+			continue
+		}
+		if acs.IsDefault {
+			metadata.AssertionConsumerURL = append([]string{acs.Location}, metadata.AssertionConsumerURL...)
+		} else {
+			metadata.AssertionConsumerURL = append(metadata.AssertionConsumerURL, acs.Location)
+		}
+	}
+
+	if len(metadata.AssertionConsumerURL) == 0 {
+		return nil, fmt.Errorf("%w: no HTTP-POST AssertionConsumerService", ErrInvalidMessage)
+	}
+
+	// Prefer POST for logout too, redirect URLs get long once signed
+	for _, binding := range []string{BindingHTTPPost, BindingHTTPRedirect} {
+		for _, slo := range sp.SingleLogoutServices {
+			if slo.Binding == binding && metadata.SingleLogoutURL == "" {
+				metadata.SingleLogoutURL = slo.Location
+				metadata.SingleLogoutBinding = binding
+			}
+		}
+	}
+
+	certificates, err := signingCertificates(sp.KeyDescriptors)
+	if err != nil {
+		return nil, err
+	}
+	if len(certificates) > 0 {
+		metadata.SigningCertificate = certificates[0]
+	}
+
+	if len(sp.NameIDFormats) > 0 {
+		metadata.NameIDFormat = strings.TrimSpace(sp.NameIDFormats[0])
+	}
+	return metadata, nil
+}
+
+// ParseIdentityProviderMetadata reads an IdP EntityDescriptor, e.g. from Okta, Entra ID
+// or ADFS. Requests are sent with the HTTP-Redirect binding, so that endpoint is required.
+// The certificates are returned as PEM, all of them are trusted to allow key rollover.
+func ParseIdentityProviderMetadata(data []byte) (entityID string, ssoURL string, certificates []string, err error) {
+	var descriptor entityDescriptor
+	if err := unmarshal(data, &descriptor); err != nil {
+		return "", "", nil, err
+	}
+
+	idp := descriptor.IDPSSODescriptor
+	if descriptor.EntityID == "" || idp == nil {
+		return "", "", nil, fmt.Errorf("%w: not an identity provider EntityDescriptor", ErrInvalidMessage)
+	}
+
+	for _, sso := range idp.SingleSignOnService {
+		if sso.Binding == BindingHTTPRedirect && sso.Location != "" {
+			ssoURL = sso.Location
+			break
+		}
+	}
+
+	if ssoURL == "" {
+		return "", "", nil, fmt.Errorf("%w: no HTTP-Redirect SingleSignOnService", ErrInvalidMessage)
+	}
+
+	certificates, err = signingCertificates(idp.KeyDescriptors)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if len(certificates) == 0 {
+		return "", "", nil, fmt.Errorf("%w: no signing certificate", ErrInvalidMessage)
+	}
+	return descriptor.EntityID, ssoURL, certificates, nil
+}
+
+// ServiceProviderDescriptor builds the md:EntityDescriptor of a tenant acting as SP,
+// signing its AuthnRequests with certificate and taking Responses at acsURL
+func ServiceProviderDescriptor(entityID string, acsURL string, certificate *x509.Certificate) *Element {
+	return NewElement("md:EntityDescriptor", "entityID", entityID).Add(
+		NewElement("md:SPSSODescriptor",
+			"AuthnRequestsSigned", "true",
+			"WantAssertionsSigned", "true",
+			"protocolSupportEnumeration", NamespaceProtocol,
+		).Add(
+			NewElement("md:KeyDescriptor", "use", "signing").Add(
+				NewElement("ds:KeyInfo").Add(
+					NewElement("ds:X509Data").AddText("ds:X509Certificate", base64.StdEncoding.EncodeToString(certificate.Raw)),
+				),
+			),
+		).
+			AddText("md:NameIDFormat", NameIDFormatEmail).
+			AddText("md:NameIDFormat", NameIDFormatPersist).
+			Add(NewElement("md:AssertionConsumerService", "Binding", BindingHTTPPost, "Location", acsURL, "index", "0", "isDefault", "true")),
+	)
+}
+
+// CertificatePEM converts the base64 DER found in metadata to PEM
+func CertificatePEM(encoded string) (string, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return "", fmt.Errorf("%w: certificate", ErrInvalidMessage)
+	}
+
+	if _, err := x509.ParseCertificate(der); err != nil {
+		return "", fmt.Errorf("%w: certificate: %v", ErrInvalidMessage, err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
@@ -0,0 +1,255 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const (
+	StatusSuccess         = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	StatusRequester       = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+	StatusResponder       = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	StatusNoPassive       = "urn:oasis:names:tc:SAML:2.0:status:NoPassive"
+	StatusRequestDenied   = "urn:oasis:names:tc:SAML:2.0:status:RequestDenied"
+	StatusPartialLogout   = "urn:oasis:names:tc:SAML:2.0:status:PartialLogout"
+	NameIDFormatEmail     = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatPersist   = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatUnspec    = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	NameIDFormatTransient = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+	AuthnContextPassword  = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	AuthnContextUnspec    = "urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified"
+	AttributeFormatBasic  = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	ConfirmationBearer    = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// clockSkew is how far a service provider's clock may be off
+const clockSkew = 3 * time.Minute
+
+// maxRequestAge is how old an incoming request may be
+const maxRequestAge = 10 * time.Minute
+
+type NameID struct {
+	Format string `xml:"Format,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// AuthnRequest is the part of a service provider's sign-in request the IdP uses
+type AuthnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	ForceAuthn                  bool     `xml:"ForceAuthn,attr"`
+	IsPassive                   bool     `xml:"IsPassive,attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                *struct {
+		Format string `xml:"Format,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// LogoutRequest asks to end the sessions of NameID
+type LogoutRequest struct {
+	XMLName        xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutRequest"`
+	ID             string   `xml:"ID,attr"`
+	Version        string   `xml:"Version,attr"`
+	IssueInstant   string   `xml:"IssueInstant,attr"`
+	Destination    string   `xml:"Destination,attr"`
+	Issuer         string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameID         NameID   `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	SessionIndexes []string `xml:"urn:oasis:names:tc:SAML:2.0:protocol SessionIndex"`
+}
+
+// LogoutResponse answers a LogoutRequest the IdP sent
+type LogoutResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutResponse"`
+	ID           string   `xml:"ID,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+}
+
+func ParseAuthnRequest(data []byte, now time.Time) (*AuthnRequest, error) {
+	var request AuthnRequest
+	if err := unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	if request.ID == "" || request.Issuer == "" {
+		return nil, fmt.Errorf("%w: ID and Issuer are required", ErrInvalidMessage)
+	}
+
+	if err := checkMessage(request.Version, request.IssueInstant, now); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func ParseLogoutRequest(data []byte, now time.Time) (*LogoutRequest, error) {
+	var request LogoutRequest
+	if err := unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	if request.ID == "" || request.Issuer == "" || request.NameID.Value == "" {
+		return nil, fmt.Errorf("%w: ID, Issuer and NameID are required", ErrInvalidMessage)
+	}
+
+	if err := checkMessage(request.Version, request.IssueInstant, now); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func ParseLogoutResponse(data []byte) (*LogoutResponse, error) {
+	var response LogoutResponse
+	if err := unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// unmarshal refuses documents with a DTD, SAML messages never need one
+func unmarshal(data []byte, v any) error {
+	if bytes.Contains(data, []byte("<!DOCTYPE")) || bytes.Contains(data, []byte("<!ENTITY")) {
+		return fmt.Errorf("%w: DTDs are not allowed", ErrInvalidMessage)
+	}
+
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	return nil
+}
+
+func checkMessage(version string, issueInstant string, now time.Time) error {
+	if version != "2.0" {
+		return fmt.Errorf("%w: unsupported version %q", ErrInvalidMessage, version)
+	}
+
+	issued, err := time.Parse(time.RFC3339Nano, issueInstant)
+	if err != nil {
+		return fmt.Errorf("%w: IssueInstant", ErrInvalidMessage)
+	}
+
+	if issued.After(now.Add(clockSkew)) || issued.Before(now.Add(-maxRequestAge)) {
+		return fmt.Errorf("%w: IssueInstant is out of range", ErrInvalidMessage)
+	}
+	return nil
+}
+
+// Attribute is a user attribute released to a service provider
+type Attribute struct {
+	Name   string
+	Values []string
+}
+
+// Assertion is what the IdP states about a signed in user
+type Assertion struct {
+	Issuer        string
+	Audience      string
+	Recipient     string // assertion consumer service URL
+	InResponseTo  string // empty for IdP initiated sign-in
+	NameID        NameID
+	SessionIndex  string
+	AuthnInstant  time.Time
+	AuthnContext  string
+	Attributes    []Attribute
+	IssueInstant  time.Time
+	ValidFor      time.Duration
+	SessionExpiry time.Time
+}
+
+// Element builds the unsigned saml:Assertion
+func (a *Assertion) Element() *Element {
+	issued := Instant(a.IssueInstant)
+	expires := Instant(a.IssueInstant.Add(a.ValidFor))
+
+	confirmationData := NewElement("saml:SubjectConfirmationData", "NotOnOrAfter", expires, "Recipient", a.Recipient)
+	if a.InResponseTo != "" {
+		confirmationData.Attrs = append(confirmationData.Attrs, Attr{Name: "InResponseTo", Value: a.InResponseTo})
+	}
+
+	authnStatement := NewElement("saml:AuthnStatement", "AuthnInstant", Instant(a.AuthnInstant), "SessionIndex", a.SessionIndex)
+	if !a.SessionExpiry.IsZero() {
+		authnStatement.Attrs = append(authnStatement.Attrs, Attr{Name: "SessionNotOnOrAfter", Value: Instant(a.SessionExpiry)})
+	}
+	authnStatement.Add(NewElement("saml:AuthnContext").AddText("saml:AuthnContextClassRef", a.AuthnContext))
+
+	assertion := NewElement("saml:Assertion", "ID", NewID(), "IssueInstant", issued, "Version", "2.0").
+		AddText("saml:Issuer", a.Issuer).
+		Add(
+			NewElement("saml:Subject").
+				AddText("saml:NameID", a.NameID.Value, "Format", a.NameID.Format).
+				Add(NewElement("saml:SubjectConfirmation", "Method", ConfirmationBearer).Add(confirmationData)),
+			NewElement("saml:Conditions", "NotBefore", issued, "NotOnOrAfter", expires).Add(
+				NewElement("saml:AudienceRestriction").AddText("saml:Audience", a.Audience),
+			),
+			authnStatement,
+		)
+
+	if len(a.Attributes) > 0 {
+		statement := NewElement("saml:AttributeStatement")
+		for _, attribute := range a.Attributes {
+			element := NewElement("saml:Attribute", "Name", attribute.Name, "NameFormat", AttributeFormatBasic)
+			for _, value := range attribute.Values {
+				element.AddText("saml:AttributeValue", value)
+			}
+			statement.Add(element)
+		}
+		assertion.Add(statement)
+	}
+	return assertion
+}
+
+// NewResponse wraps an assertion, or carries only a failure status when assertion is nil
+func NewResponse(destination string, inResponseTo string, issuer string, status string, assertion *Element, now time.Time) *Element {
+	response := newStatusResponse("samlp:Response", destination, inResponseTo, issuer, status, now)
+	if assertion != nil {
+		response.Add(assertion)
+	}
+	return response
+}
+
+func NewLogoutResponse(destination string, inResponseTo string, issuer string, status string, now time.Time) *Element {
+	return newStatusResponse("samlp:LogoutResponse", destination, inResponseTo, issuer, status, now)
+}
+
+// NewAuthnRequest asks an identity provider to sign the user in and post the Response to acsURL
+func NewAuthnRequest(destination string, issuer string, acsURL string, now time.Time) *Element {
+	return NewElement("samlp:AuthnRequest",
+		"AssertionConsumerServiceURL", acsURL,
+		"Destination", destination,
+		"ID", NewID(),
+		"IssueInstant", Instant(now),
+		"ProtocolBinding", BindingHTTPPost,
+		"Version", "2.0",
+	).
+		AddText("saml:Issuer", issuer).
+		Add(NewElement("samlp:NameIDPolicy", "AllowCreate", "true"))
+}
+
+func NewLogoutRequest(destination string, issuer string, nameID NameID, sessionIndex string, now time.Time) *Element {
+	return NewElement("samlp:LogoutRequest", "Destination", destination, "ID", NewID(), "IssueInstant", Instant(now), "Version", "2.0").
+		AddText("saml:Issuer", issuer).
+		AddText("saml:NameID", nameID.Value, "Format", nameID.Format).
+		AddText("samlp:SessionIndex", sessionIndex)
+}
+
+func newStatusResponse(name string, destination string, inResponseTo string, issuer string, status string, now time.Time) *Element {
+	response := NewElement(name, "Destination", destination, "ID", NewID(), "IssueInstant", Instant(now), "Version", "2.0")
+	if inResponseTo != "" {
+		response.Attrs = append(response.Attrs, Attr{Name: "InResponseTo", Value: inResponseTo})
+	}
+
+	return response.
+		AddText("saml:Issuer", issuer).
+		Add(NewElement("samlp:Status").Add(NewElement("samlp:StatusCode", "Value", status)))
+}
@@ -0,0 +1,305 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrResponseStatus is a Response in which the identity provider refused the sign-in
+var ErrResponseStatus = errors.New("identity provider did not sign the user in")
+
+// Expected is what a Response received by the service provider is checked against
+type Expected struct {
+	EntityID     string // service provider entity ID, the audience
+	ACSURL       string // where the Response was received
+	Issuer       string // identity provider entity ID
+	Certificates []*x509.Certificate
+	InResponseTo string // ID of the AuthnRequest, empty for an unsolicited Response
+	Now          time.Time
+}
+
+// VerifiedAssertion is what a validated assertion says about the user
+type VerifiedAssertion struct {
+	ID           string
+	NameID       NameID
+	SessionIndex string
+	Attributes   map[string][]string
+	ExpiresAt    time.Time // after this the assertion can no longer be replayed
+}
+
+type assertion struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID           string   `xml:"ID,attr"`
+	Version      string   `xml:"Version,attr"`
+	IssueInstant string   `xml:"IssueInstant,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject      struct {
+		NameID        *NameID `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+		Confirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   *struct {
+				NotBefore    string `xml:"NotBefore,attr"`
+				NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+				Recipient    string `xml:"Recipient,attr"`
+				InResponseTo string `xml:"InResponseTo,attr"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions *struct {
+		NotBefore            string `xml:"NotBefore,attr"`
+		NotOnOrAfter         string `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	AuthnStatements []struct {
+		SessionIndex        string `xml:"SessionIndex,attr"`
+		SessionNotOnOrAfter string `xml:"SessionNotOnOrAfter,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnStatement"`
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement>Attribute"`
+}
+
+// ResponseIssuer reads the Issuer of a Response without verifying anything, to
+// pick the identity provider whose certificates ParseResponse then checks
+func ResponseIssuer(data []byte) (string, error) {
+	var response struct {
+		XMLName   xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+		Issuer    string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Assertion struct {
+			Issuer string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	}
+
+	if err := unmarshal(data, &response); err != nil {
+		return "", err
+	}
+
+	if issuer := strings.TrimSpace(response.Issuer); issuer != "" {
+		return issuer, nil
+	}
+	return strings.TrimSpace(response.Assertion.Issuer), nil
+}
+
+// ParseResponse validates a Response sent to the service provider with the HTTP-POST
+// binding (SAML profiles 4.1.4). The Response, the Assertion or both must carry a valid
+// signature; claims are only read from the signed element so that nothing wrapped
+// around it is trusted. Encrypted assertions are not supported.
+func ParseResponse(data []byte, expected Expected) (*VerifiedAssertion, error) {
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !root.is(NamespaceProtocol, "Response") {
+		return nil, fmt.Errorf("%w: not a Response", ErrInvalidMessage)
+	}
+
+	if err := uniqueIDs(root, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	if root.attr("Version") != "2.0" {
+		return nil, fmt.Errorf("%w: unsupported version", ErrInvalidMessage)
+	}
+
+	if destination := root.attr("Destination"); destination != "" && destination != expected.ACSURL {
+		return nil, fmt.Errorf("%w: Destination does not match", ErrInvalidMessage)
+	}
+
+	if root.attr("InResponseTo") != expected.InResponseTo {
+		return nil, fmt.Errorf("%w: InResponseTo does not match", ErrInvalidMessage)
+	}
+
+	if issuer := root.element(NamespaceAssertion, "Issuer"); issuer != nil && issuer.text() != expected.Issuer {
+		return nil, fmt.Errorf("%w: Issuer does not match", ErrInvalidMessage)
+	}
+
+	signed := false
+	signature, err := root.signature()
+	if err != nil {
+		return nil, err
+	}
+
+	if signature != nil {
+		if err := verifySignature(root, signature, expected.Certificates); err != nil {
+			return nil, err
+		}
+		signed = true
+	}
+
+	if status := responseStatus(root); status != StatusSuccess {
+		return nil, fmt.Errorf("%w: %s", ErrResponseStatus, status)
+	}
+
+	if len(root.elements(NamespaceAssertion, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("%w: encrypted assertions are not supported", ErrInvalidMessage)
+	}
+
+	assertionNode := root.element(NamespaceAssertion, "Assertion")
+	if assertionNode == nil {
+		return nil, fmt.Errorf("%w: exactly one Assertion is expected", ErrInvalidMessage)
+	}
+
+	signature, err = assertionNode.signature()
+	if err != nil {
+		return nil, err
+	}
+
+	if signature != nil {
+		if err := verifySignature(assertionNode, signature, expected.Certificates); err != nil {
+			return nil, err
+		}
+		signed = true
+	}
+
+	if !signed {
+		return nil, fmt.Errorf("%w: neither the Response nor the Assertion is signed", ErrInvalidSignature)
+	}
+
+	// Read the assertion from its canonical form, exactly the bytes that were verified
+	var parsed assertion
+	if err := xml.Unmarshal([]byte(canonicalize(assertionNode, nil, signature)), &parsed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	return parsed.check(expected)
+}
+
+// uniqueIDs refuses documents where two elements share an ID, which is how signature
+// wrapping attacks make a reference resolve to another element than the one read
+func uniqueIDs(n *node, seen map[string]bool) error {
+	if id := n.attr("ID"); id != "" {
+		if seen[id] {
+			return fmt.Errorf("%w: duplicate ID", ErrInvalidMessage)
+		}
+		seen[id] = true
+	}
+
+	for _, child := range n.children {
+		if element, ok := child.(*node); ok {
+			if err := uniqueIDs(element, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func responseStatus(root *node) string {
+	if status := root.element(NamespaceProtocol, "Status"); status != nil {
+		if code := status.element(NamespaceProtocol, "StatusCode"); code != nil {
+			return code.attr("Value")
+		}
+	}
+	return ""
+}
+
+func (a *assertion) check(expected Expected) (*VerifiedAssertion, error) {
+	now := expected.Now
+
+	if a.Version != "2.0" || a.ID == "" {
+		return nil, fmt.Errorf("%w: assertion ID and Version are required", ErrInvalidMessage)
+	}
+
+	if strings.TrimSpace(a.Issuer) != expected.Issuer {
+		return nil, fmt.Errorf("%w: assertion Issuer does not match", ErrInvalidMessage)
+	}
+
+	if issued, err := parseInstant(a.IssueInstant); err != nil || issued.After(now.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: assertion IssueInstant", ErrInvalidMessage)
+	}
+
+	if a.Subject.NameID == nil || strings.TrimSpace(a.Subject.NameID.Value) == "" {
+		return nil, fmt.Errorf("%w: NameID is missing", ErrInvalidMessage)
+	}
+
+	var expiresAt time.Time
+	confirmed := false
+	for _, confirmation := range a.Subject.Confirmations {
+		data := confirmation.Data
+		if confirmation.Method != ConfirmationBearer || data == nil {
+			continue
+		}
+
+		notOnOrAfter, err := parseInstant(data.NotOnOrAfter)
+		if err != nil || !now.Before(notOnOrAfter.Add(clockSkew)) {
+			continue
+		}
+
+		if data.NotBefore != "" {
+			if notBefore, err := parseInstant(data.NotBefore); err != nil || now.Add(clockSkew).Before(notBefore) {
+				continue
+			}
+		}
+
+		if data.Recipient != expected.ACSURL || data.InResponseTo != expected.InResponseTo {
+			continue
+		}
+
+		confirmed = true
+		expiresAt = notOnOrAfter.Add(clockSkew)
+		break
+	}
+
+	if !confirmed {
+		return nil, fmt.Errorf("%w: no valid bearer SubjectConfirmation", ErrInvalidMessage)
+	}
+
+	if conditions := a.Conditions; conditions != nil {
+		if conditions.NotBefore != "" {
+			notBefore, err := parseInstant(conditions.NotBefore)
+			if err != nil || now.Add(clockSkew).Before(notBefore) {
+				return nil, fmt.Errorf("%w: assertion is not valid yet", ErrInvalidMessage)
+			}
+		}
+
+		if conditions.NotOnOrAfter != "" {
+			notOnOrAfter, err := parseInstant(conditions.NotOnOrAfter)
+			if err != nil || !now.Before(notOnOrAfter.Add(clockSkew)) {
+				return nil, fmt.Errorf("%w: assertion has expired", ErrInvalidMessage)
+			}
+		}
+
+		for _, restriction := range conditions.AudienceRestrictions {
+			if !slices.Contains(restriction.Audiences, expected.EntityID) {
+				return nil, fmt.Errorf("%w: assertion is meant for another audience", ErrInvalidMessage)
+			}
+		}
+	}
+
+	verified := &VerifiedAssertion{
+		ID:         a.ID,
+		NameID:     NameID{Format: a.Subject.NameID.Format, Value: strings.TrimSpace(a.Subject.NameID.Value)},
+		Attributes: map[string][]string{},
+		ExpiresAt:  expiresAt,
+	}
+
+	for _, statement := range a.AuthnStatements {
+		if statement.SessionNotOnOrAfter != "" {
+			if sessionEnd, err := parseInstant(statement.SessionNotOnOrAfter); err != nil || !now.Before(sessionEnd) {
+				return nil, fmt.Errorf("%w: identity provider session has ended", ErrInvalidMessage)
+			}
+		}
+		if verified.SessionIndex == "" {
+			verified.SessionIndex = statement.SessionIndex
+		}
+	}
+
+	for _, attribute := range a.Attributes {
+		for _, value := range attribute.Values {
+			verified.Attributes[attribute.Name] = append(verified.Attributes[attribute.Name], strings.TrimSpace(value))
+		}
+	}
+	return verified, nil
+}
+
+func parseInstant(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(value))
+}
@@ -0,0 +1,143 @@
+package saml
+
+import (
+	"crypto/x509"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseResponse(t *testing.T) {
+	signer := testSigner(t)
+	issued := time.Now().UTC().Truncate(time.Second)
+
+	expected := func(change func(*Expected)) Expected {
+		e := Expected{
+			EntityID:     testSPEntityID,
+			ACSURL:       testACSURL,
+			Issuer:       testIdPEntityID,
+			Certificates: []*x509.Certificate{signer.Certificate},
+			InResponseTo: testRequestID,
+			Now:          issued.Add(time.Minute),
+		}
+		if change != nil {
+			change(&e)
+		}
+		return e
+	}
+
+	assertion := func(change func(*Assertion)) *Assertion {
+		a := testAssertion(issued)
+		if change != nil {
+			change(a)
+		}
+		return a
+	}
+
+	// The Response and its assertion answer different requests
+	mismatched := assertion(func(a *Assertion) { a.InResponseTo = "_other" }).Element()
+	if err := signer.Sign(mismatched, 1); err != nil {
+		t.Fatal(err)
+	}
+	mismatchedResponse := NewResponse(testACSURL, testRequestID, testIdPEntityID, StatusSuccess, mismatched, issued).Canonical()
+
+	refused := NewResponse(testACSURL, testRequestID, testIdPEntityID, StatusResponder, nil, issued)
+	if err := signer.Sign(refused, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		response string
+		expected Expected
+		want     error
+	}{
+		{name: "signed assertion", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(nil)},
+		{name: "signed response", response: signedResponse(t, signer, assertion(nil), false, true), expected: expected(nil)},
+		{name: "both signed", response: signedResponse(t, signer, assertion(nil), true, true), expected: expected(nil)},
+		{name: "unsigned", response: signedResponse(t, signer, assertion(nil), false, false), expected: expected(nil), want: ErrInvalidSignature},
+		{name: "untrusted certificate", response: signedResponse(t, testSigner(t), assertion(nil), true, true), expected: expected(nil), want: ErrInvalidSignature},
+
+		// Audience
+		{name: "other audience", response: signedResponse(t, signer, assertion(func(a *Assertion) { a.Audience = "https://other.example.com" }), true, false), expected: expected(nil), want: ErrInvalidMessage},
+		{name: "audience of another SP entity", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.EntityID = "https://sp.example.com/other" }), want: ErrInvalidMessage},
+
+		// Time window, 5 minutes plus the 3 minute clock skew
+		{name: "within the clock skew after expiry", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.Now = issued.Add(7 * time.Minute) })},
+		{name: "expired", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.Now = issued.Add(8 * time.Minute) }), want: ErrInvalidMessage},
+		{name: "within the clock skew before issue", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.Now = issued.Add(-2 * time.Minute) })},
+		{name: "not valid yet", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.Now = issued.Add(-4 * time.Minute) }), want: ErrInvalidMessage},
+		{name: "identity provider session ended", response: signedResponse(t, signer, assertion(func(a *Assertion) { a.SessionExpiry = issued.Add(30 * time.Second) }), true, false), expected: expected(nil), want: ErrInvalidMessage},
+
+		// InResponseTo
+		{name: "answer to another request", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.InResponseTo = "_request2" }), want: ErrInvalidMessage},
+		{name: "assertion for another request", response: mismatchedResponse, expected: expected(nil), want: ErrInvalidMessage},
+		{name: "unsolicited", response: signedResponse(t, signer, assertion(func(a *Assertion) { a.InResponseTo = "" }), true, false), expected: expected(func(e *Expected) { e.InResponseTo = "" })},
+		{name: "unsolicited when a request is pending", response: signedResponse(t, signer, assertion(func(a *Assertion) { a.InResponseTo = "" }), true, false), expected: expected(nil), want: ErrInvalidMessage},
+		{name: "solicited presented as unsolicited", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.InResponseTo = "" }), want: ErrInvalidMessage},
+
+		// Recipient and Destination
+		{name: "other recipient", response: signedResponse(t, signer, assertion(func(a *Assertion) { a.Recipient = "https://other.example.com/acs" }), true, false), expected: expected(nil), want: ErrInvalidMessage},
+		{name: "other destination", response: signedResponse(t, signer, assertion(nil), true, false), expected: expected(func(e *Expected) { e.ACSURL = "https://sp.example.com/saml/acs2" }), want: ErrInvalidMessage},
+
+		// Issuer and status
+		{name: "other issuer", response: signedResponse(t, signer, assertion(func(a *Assertion) { a.Issuer = "https://evil.example.com" }), true, false), expected: expected(nil), want: ErrInvalidMessage},
+		{name: "sign-in refused", response: refused.Canonical(), expected: expected(nil), want: ErrResponseStatus},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := ParseResponse([]byte(test.response), test.expected)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("ParseResponse = %+v, %v, want %v", verified, err, test.want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseResponse = %v", err)
+			}
+			if verified.NameID.Value != "ada@example.com" || verified.SessionIndex != "_session1" || !slices.Equal(verified.Attributes["email"], []string{"ada@example.com"}) {
+				t.Fatalf("verified = %+v", verified)
+			}
+			if want := issued.Add(5*time.Minute + clockSkew); !verified.ExpiresAt.Equal(want) {
+				t.Fatalf("ExpiresAt = %v, want %v", verified.ExpiresAt, want)
+			}
+		})
+	}
+}
+
+func TestParseOktaResponse(t *testing.T) {
+	response, certificate := oktaFixture(t)
+	expected := Expected{
+		EntityID:     "https://dev.sudo.wtf:8443/v1/teams/asa",
+		ACSURL:       "https://dev.sudo.wtf:8443/v1/_saml_callback",
+		Issuer:       "http://www.okta.com/exkrfkzzb7NyB3UeP0h7",
+		Certificates: []*x509.Certificate{certificate},
+		InResponseTo: "_ffea96b1-44a2-4a86-9683-45807984ab5b",
+		Now:          time.Date(2020, 9, 1, 17, 51, 30, 0, time.UTC),
+	}
+
+	verified, err := ParseResponse([]byte(response), expected)
+	if err != nil {
+		t.Fatalf("ParseResponse = %v", err)
+	}
+	if verified.ID != "id149481635007855341483658231" || verified.NameID.Value != "phoebe.yu@okta.com" || verified.NameID.Format != NameIDFormatEmail {
+		t.Fatalf("verified = %+v", verified)
+	}
+	if !slices.Equal(verified.Attributes["FirstName"], []string{"Phoebe"}) || !slices.Equal(verified.Attributes["SSHUserName"], []string{""}) {
+		t.Fatalf("attributes = %v", verified.Attributes)
+	}
+
+	issuer, err := ResponseIssuer([]byte(response))
+	if err != nil || issuer != expected.Issuer {
+		t.Fatalf("ResponseIssuer = %q, %v", issuer, err)
+	}
+
+	expected.Now = expected.Now.Add(time.Hour)
+	if _, err := ParseResponse([]byte(response), expected); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("ParseResponse an hour later = %v, want ErrInvalidMessage", err)
+	}
+}
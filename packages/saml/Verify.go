@@ -0,0 +1,159 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	AlgorithmRSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	AlgorithmSHA512    = "http://www.w3.org/2001/04/xmlenc#sha512"
+	namespaceExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+)
+
+var signatureHashes = map[string]crypto.Hash{
+	AlgorithmRSASHA256: crypto.SHA256,
+	AlgorithmRSASHA512: crypto.SHA512,
+}
+
+var digestHashes = map[string]crypto.Hash{
+	AlgorithmSHA256: crypto.SHA256,
+	AlgorithmSHA512: crypto.SHA512,
+}
+
+// signature returns the ds:Signature child of n, nil when n is not signed
+func (n *node) signature() (*node, error) {
+	signatures := n.elements(NamespaceDSig, "Signature")
+	if len(signatures) > 1 {
+		return nil, fmt.Errorf("%w: more than one signature", ErrInvalidSignature)
+	}
+
+	if len(signatures) == 0 {
+		return nil, nil
+	}
+	return signatures[0], nil
+}
+
+// verifySignature checks the enveloped signature of n against the trusted
+// certificates. The certificate in KeyInfo is ignored, anyone can put one there.
+// Only the profile SAML uses is accepted: one reference to n itself with the
+// enveloped and exclusive canonicalization transforms, RSA with SHA-256 or SHA-512.
+func verifySignature(n *node, signature *node, certificates []*x509.Certificate) error {
+	signedInfo := signature.element(NamespaceDSig, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("%w: SignedInfo is missing", ErrInvalidSignature)
+	}
+
+	c14n := signedInfo.element(NamespaceDSig, "CanonicalizationMethod")
+	if c14n == nil || c14n.attr("Algorithm") != AlgorithmExcC14N {
+		return fmt.Errorf("%w: unsupported canonicalization", ErrInvalidSignature)
+	}
+
+	method := signedInfo.element(NamespaceDSig, "SignatureMethod")
+	if method == nil {
+		return fmt.Errorf("%w: SignatureMethod is missing", ErrInvalidSignature)
+	}
+
+	signatureHash, ok := signatureHashes[method.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, method.attr("Algorithm"))
+	}
+
+	references := signedInfo.elements(NamespaceDSig, "Reference")
+	if len(references) != 1 {
+		return fmt.Errorf("%w: exactly one reference is expected", ErrInvalidSignature)
+	}
+
+	reference := references[0]
+	if id := n.attr("ID"); id == "" || reference.attr("URI") != "#"+id {
+		return fmt.Errorf("%w: reference does not cover the signed element", ErrInvalidSignature)
+	}
+
+	var inclusive []string
+	enveloped := false
+	if transforms := reference.element(NamespaceDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.elements(NamespaceDSig, "Transform") {
+			switch transform.attr("Algorithm") {
+			case AlgorithmEnveloped:
+				enveloped = true
+			case AlgorithmExcC14N:
+				inclusive = inclusivePrefixes(transform)
+			default:
+				return fmt.Errorf("%w: unsupported transform %q", ErrInvalidSignature, transform.attr("Algorithm"))
+			}
+		}
+	}
+
+	if !enveloped {
+		return fmt.Errorf("%w: enveloped signature transform is missing", ErrInvalidSignature)
+	}
+
+	digestMethod := reference.element(NamespaceDSig, "DigestMethod")
+	digestValue := reference.element(NamespaceDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return fmt.Errorf("%w: digest is missing", ErrInvalidSignature)
+	}
+
+	digestHash, ok := digestHashes[digestMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("%w: unsupported digest %q", ErrInvalidSignature, digestMethod.attr("Algorithm"))
+	}
+
+	expectedDigest, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return fmt.Errorf("%w: DigestValue", ErrInvalidSignature)
+	}
+
+	if !bytes.Equal(sum(digestHash, canonicalize(n, inclusive, signature)), expectedDigest) {
+		return fmt.Errorf("%w: digest does not match", ErrInvalidSignature)
+	}
+
+	signatureValue := signature.element(NamespaceDSig, "SignatureValue")
+	if signatureValue == nil {
+		return fmt.Errorf("%w: SignatureValue is missing", ErrInvalidSignature)
+	}
+
+	value, err := decodeBase64(signatureValue.text())
+	if err != nil {
+		return fmt.Errorf("%w: SignatureValue", ErrInvalidSignature)
+	}
+
+	digest := sum(signatureHash, canonicalize(signedInfo, inclusivePrefixes(c14n), nil))
+	for _, certificate := range certificates {
+		key, ok := certificate.PublicKey.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(key, signatureHash, digest, value) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: not signed by a trusted certificate", ErrInvalidSignature)
+}
+
+// inclusivePrefixes reads the InclusiveNamespaces PrefixList of an exclusive canonicalization
+func inclusivePrefixes(method *node) []string {
+	if list := method.element(namespaceExcC14N, "InclusiveNamespaces"); list != nil {
+		return strings.Fields(list.attr("PrefixList"))
+	}
+	return nil
+}
+
+func sum(hash crypto.Hash, data string) []byte {
+	switch hash {
+	case crypto.SHA512:
+		digest := sha512.Sum512([]byte(data))
+		return digest[:]
+	default:
+		digest := sha256.Sum256([]byte(data))
+		return digest[:]
+	}
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+}
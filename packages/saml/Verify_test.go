@@ -0,0 +1,331 @@
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIdPEntityID = "https://idp.example.com/saml"
+	testSPEntityID  = "https://sp.example.com/saml"
+	testACSURL      = "https://sp.example.com/saml/acs"
+	testRequestID   = "_request1"
+)
+
+func testSigner(t *testing.T) *Signer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signer{Key: key, Certificate: certificate}
+}
+
+func testAssertion(now time.Time) *Assertion {
+	return &Assertion{
+		Issuer:       testIdPEntityID,
+		Audience:     testSPEntityID,
+		Recipient:    testACSURL,
+		InResponseTo: testRequestID,
+		NameID:       NameID{Format: NameIDFormatEmail, Value: "ada@example.com"},
+		SessionIndex: "_session1",
+		AuthnInstant: now,
+		AuthnContext: AuthnContextPassword,
+		Attributes:   []Attribute{{Name: "email", Values: []string{"ada@example.com"}}},
+		IssueInstant: now,
+		ValidFor:     5 * time.Minute,
+	}
+}
+
+// signedResponse builds a Response to the test SP, signing the assertion and the
+// response as asked. The assertion is signed first so the response signature covers it.
+func signedResponse(t *testing.T, signer *Signer, assertion *Assertion, signAssertion bool, signResponse bool) string {
+	t.Helper()
+
+	assertionElement := assertion.Element()
+	if signAssertion {
+		if err := signer.Sign(assertionElement, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response := NewResponse(testACSURL, assertion.InResponseTo, assertion.Issuer, StatusSuccess, assertionElement, assertion.IssueInstant)
+	if signResponse {
+		if err := signer.Sign(response, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.Canonical()
+}
+
+// verifyDocument checks the signature of the root element and of its assertion
+func verifyDocument(data string, certificates ...*x509.Certificate) error {
+	root, err := parseDocument([]byte(data))
+	if err != nil {
+		return err
+	}
+
+	for _, n := range []*node{root, root.element(NamespaceAssertion, "Assertion")} {
+		signature, err := n.signature()
+		if err != nil {
+			return err
+		}
+		if signature == nil {
+			return errors.New(n.local + " is not signed")
+		}
+		if err := verifySignature(n, signature, certificates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	signer := testSigner(t)
+	response := signedResponse(t, signer, testAssertion(time.Now()), true, true)
+
+	if err := verifyDocument(response, signer.Certificate); err != nil {
+		t.Fatalf("verify = %v", err)
+	}
+
+	// Serializations with the same canonical form verify too
+	reserialized := strings.NewReplacer(
+		`></ds:CanonicalizationMethod>`, `/>`,
+		`></ds:SignatureMethod>`, `/>`,
+		`></ds:Transform>`, `/>`,
+		`></ds:DigestMethod>`, `/>`,
+		`<saml:Subject>`, `<saml:Subject xmlns:saml="`+NamespaceAssertion+`" xmlns:unused="urn:unused">`,
+	).Replace(response)
+	reserialized = `<?xml version="1.0" encoding="UTF-8"?>` + "\n<!-- sent by the IdP -->" + reserialized
+
+	if err := verifyDocument(reserialized, signer.Certificate); err != nil {
+		t.Fatalf("verify reserialized = %v", err)
+	}
+}
+
+func TestVerifySignatureRejects(t *testing.T) {
+	signer := testSigner(t)
+	attacker := testSigner(t)
+	response := signedResponse(t, signer, testAssertion(time.Now()), true, true)
+
+	tests := []struct {
+		name     string
+		document string
+		trusted  *x509.Certificate
+	}{
+		{name: "untrusted certificate", document: response, trusted: attacker.Certificate},
+		{name: "signed with the certificate in KeyInfo", document: signedResponse(t, attacker, testAssertion(time.Now()), true, true), trusted: signer.Certificate},
+		{name: "changed NameID", document: strings.Replace(response, "ada@example.com</saml:NameID>", "eve@example.com</saml:NameID>", 1), trusted: signer.Certificate},
+		{name: "changed attribute", document: strings.Replace(response, `Name="email"`, `Name="admin"`, 1), trusted: signer.Certificate},
+		{name: "whitespace added to signed content", document: strings.Replace(response, "<saml:Subject>", "<saml:Subject> ", 1), trusted: signer.Certificate},
+		{name: "changed digest method", document: strings.Replace(response, AlgorithmSHA256, AlgorithmSHA512, 1), trusted: signer.Certificate},
+		{name: "SHA-1 signature method", document: strings.ReplaceAll(response, AlgorithmRSASHA256, "http://www.w3.org/2000/09/xmldsig#rsa-sha1"), trusted: signer.Certificate},
+		{name: "inclusive canonicalization", document: strings.Replace(response, `<ds:CanonicalizationMethod Algorithm="`+AlgorithmExcC14N, `<ds:CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315`, 1), trusted: signer.Certificate},
+		{name: "XSLT transform", document: strings.Replace(response, AlgorithmEnveloped, "http://www.w3.org/TR/1999/REC-xslt-19991116", 1), trusted: signer.Certificate},
+		{name: "reference to another element", document: strings.Replace(response, `<ds:Reference URI="#`, `<ds:Reference URI="#x`, 1), trusted: signer.Certificate},
+		{name: "empty reference", document: strings.Replace(response, `<ds:Reference URI="#`+responseID(t, response)+`"`, `<ds:Reference URI=""`, 1), trusted: signer.Certificate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := verifyDocument(test.document, test.trusted); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("verify = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureRejectsTwoSignatures(t *testing.T) {
+	signer := testSigner(t)
+	assertion := testAssertion(time.Now()).Element()
+	if err := signer.Sign(assertion, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Sign(assertion, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := parseDocument([]byte(assertion.Canonical()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.signature(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("signature = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func responseID(t *testing.T, document string) string {
+	t.Helper()
+	root, err := parseDocument([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root.attr("ID")
+}
+
+func oktaFixture(t *testing.T) (string, *x509.Certificate) {
+	t.Helper()
+
+	response, err := os.ReadFile("testdata/okta_response.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := os.ReadFile("testdata/okta_certificate.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(encoded)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(response), certificate
+}
+
+// The Okta response (from the goxmldsig test suite, Apache-2.0) signs both the Response and the Assertion, with an InclusiveNamespaces
+// PrefixList and namespace declarations that exclusive canonicalization has to move
+func TestVerifyOktaResponse(t *testing.T) {
+	response, certificate := oktaFixture(t)
+
+	if err := verifyDocument(response, certificate); err != nil {
+		t.Fatalf("verify = %v", err)
+	}
+
+	if err := verifyDocument(response, testSigner(t).Certificate); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("verify with another certificate = %v, want ErrInvalidSignature", err)
+	}
+
+	tampered := strings.Replace(response, ">phoebe.yu@okta.com</saml2:NameID>", ">admin@okta.com</saml2:NameID>", 1)
+	if err := verifyDocument(tampered, certificate); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("verify tampered = %v, want ErrInvalidSignature", err)
+	}
+
+	// Dropping the inclusive xs prefix changes the canonical form Okta signed
+	withoutInclusive := strings.ReplaceAll(response, `PrefixList="xs"`, `PrefixList=""`)
+	if err := verifyDocument(withoutInclusive, certificate); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("verify without InclusiveNamespaces = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestParseResponseRejectsSignatureWrapping(t *testing.T) {
+	signer := testSigner(t)
+	now := time.Now()
+	expected := Expected{
+		EntityID:     testSPEntityID,
+		ACSURL:       testACSURL,
+		Issuer:       testIdPEntityID,
+		Certificates: []*x509.Certificate{signer.Certificate},
+		InResponseTo: testRequestID,
+		Now:          now,
+	}
+
+	signedAssertion := testAssertion(now).Element()
+	if err := signer.Sign(signedAssertion, 1); err != nil {
+		t.Fatal(err)
+	}
+	signedAssertionXML := signedAssertion.Canonical()
+
+	evil := testAssertion(now)
+	evil.NameID.Value = "admin@example.com"
+	evilAssertion := evil.Element()
+	evilXML := evilAssertion.Canonical()
+
+	// The evil assertion copies the ID the signature references
+	sameID := strings.Replace(evilXML, `ID="`+evilAssertion.Attr("ID")+`"`, `ID="`+signedAssertion.Attr("ID")+`"`, 1)
+
+	signedResponseXML := signedResponse(t, signer, testAssertion(now), false, true)
+
+	wrap := func(children ...string) string {
+		response := NewResponse(testACSURL, testRequestID, testIdPEntityID, StatusSuccess, nil, now).Canonical()
+		return strings.Replace(response, "</samlp:Response>", strings.Join(children, "")+"</samlp:Response>", 1)
+	}
+
+	tests := []struct {
+		name     string
+		document string
+		want     error
+	}{
+		{
+			name:     "duplicate IDs",
+			document: wrap(sameID, `<samlp:Extensions>`+signedAssertionXML+`</samlp:Extensions>`),
+			want:     ErrInvalidMessage,
+		},
+		{
+			name:     "signed assertion hidden in Extensions",
+			document: wrap(`<samlp:Extensions>`+signedAssertionXML+`</samlp:Extensions>`, evilXML),
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "unsigned assertion next to a signed one",
+			document: wrap(signedAssertionXML, evilXML),
+			want:     ErrInvalidMessage,
+		},
+		{
+			name:     "signed assertion inside the evil one",
+			document: wrap(strings.Replace(evilXML, "</saml:Assertion>", signedAssertionXML+"</saml:Assertion>", 1)),
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "signed response wrapped in an unsigned one",
+			document: wrap(`<samlp:Extensions>`+signedResponseXML+`</samlp:Extensions>`, evilXML),
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "unsigned assertion added to a signed response",
+			document: strings.Replace(signedResponseXML, "</samlp:Response>", evilXML+"</samlp:Response>", 1),
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "signed response assertion replaced",
+			document: strings.Replace(signedResponseXML, "ada@example.com</saml:NameID>", "admin@example.com</saml:NameID>", 1),
+			want:     ErrInvalidSignature,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := ParseResponse([]byte(test.document), expected)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("ParseResponse = %+v, %v, want %v", verified, err, test.want)
+			}
+		})
+	}
+
+	// The untouched messages are accepted, so the cases above fail for the wrapping
+	for name, document := range map[string]string{
+		"signed assertion": wrap(signedAssertionXML),
+		"signed response":  signedResponseXML,
+	} {
+		verified, err := ParseResponse([]byte(document), expected)
+		if err != nil || verified.NameID.Value != "ada@example.com" {
+			t.Errorf("%s: ParseResponse = %+v, %v", name, verified, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package saml
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	NamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	NamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	NamespaceDSig      = "http://www.w3.org/2000/09/xmldsig#"
+)
+
+// namespaces are the prefixes elements built here may use
+var namespaces = map[string]string{
+	"saml":  NamespaceAssertion,
+	"samlp": NamespaceProtocol,
+	"md":    NamespaceMetadata,
+	"ds":    NamespaceDSig,
+}
+
+// Element is an XML element built by this package. It renders straight to the
+// exclusive canonical form (xml-exc-c14n without comments), so the bytes that get
+// signed are the bytes that are sent and no general canonicalizer is needed.
+// Attributes must be unprefixed, the element name carries one of the known prefixes.
+type Element struct {
+	Name     string // e.g. "saml:Assertion"
+	Attrs    []Attr
+	Children []Node
+}
+
+type Attr struct {
+	Name  string
+	Value string
+}
+
+// Node is an *Element or Text
+type Node interface {
+	render(b *strings.Builder, inScope map[string]string)
+}
+
+// Text is character data
+type Text string
+
+// NewElement builds an element, attrs are name value pairs
+func NewElement(name string, attrs ...string) *Element {
+	e := &Element{Name: name}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		e.Attrs = append(e.Attrs, Attr{Name: attrs[i], Value: attrs[i+1]})
+	}
+	return e
+}
+
+// Add appends children and returns e for chaining
+func (e *Element) Add(children ...Node) *Element {
+	e.Children = append(e.Children, children...)
+	return e
+}
+
+// AddText appends a child element holding only text
+func (e *Element) AddText(name string, value string, attrs ...string) *Element {
+	return e.Add(NewElement(name, attrs...).Add(Text(value)))
+}
+
+// Attr returns the value of an attribute, "" if it is missing
+func (e *Element) Attr(name string) string {
+	for _, attr := range e.Attrs {
+		if attr.Name == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Canonical renders e as the apex of an exclusive canonicalization, namespace
+// declarations are emitted where a prefix is first used
+func (e *Element) Canonical() string {
+	var b strings.Builder
+	e.render(&b, map[string]string{})
+	return b.String()
+}
+
+func (e *Element) render(b *strings.Builder, inScope map[string]string) {
+	b.WriteByte('<')
+	b.WriteString(e.Name)
+
+	if prefix, _, ok := strings.Cut(e.Name, ":"); ok && inScope[prefix] != namespaces[prefix] {
+		b.WriteString(" xmlns:" + prefix + `="` + escapeAttr(namespaces[prefix]) + `"`)
+
+		scope := make(map[string]string, len(inScope)+1)
+		for k, v := range inScope {
+			scope[k] = v
+		}
+		scope[prefix] = namespaces[prefix]
+		inScope = scope
+	}
+
+	// Unprefixed attributes have no namespace and sort by name
+	attrs := slices.Clone(e.Attrs)
+	slices.SortFunc(attrs, func(a, b Attr) int { return strings.Compare(a.Name, b.Name) })
+	for _, attr := range attrs {
+		b.WriteString(" " + attr.Name + `="` + escapeAttr(attr.Value) + `"`)
+	}
+	b.WriteByte('>')
+
+	for _, child := range e.Children {
+		child.render(b, inScope)
+	}
+
+	b.WriteString("</" + e.Name + ">")
+}
+
+func (t Text) render(b *strings.Builder, _ map[string]string) {
+	b.WriteString(escapeText(string(t)))
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
+
+// NewID returns a message or assertion ID, it must not start with a digit (xs:ID)
+func NewID() string {
+	b := make([]byte, 20)
+	rand.Read(b) // never fails
+	return "_" + hex.EncodeToString(b)
+}
+
+// Instant formats a time as SAML expects, UTC with second precision
+func Instant(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
@@ -0,0 +1,180 @@
+package scim
+
+// The documents a client reads to learn what this service provider supports
+// (RFC 7644 section 4). They describe what is implemented, not all of RFC 7643.
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupport            `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+// NewServiceProviderConfig describes the API served at base, maxResults is the largest page
+func NewServiceProviderConfig(base string, maxResults int) ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          supported{Supported: true},
+		Filter:         filterSupport{Supported: true, MaxResults: maxResults},
+		ChangePassword: supported{Supported: false},
+		Sort:           supported{Supported: false},
+		ETag:           supported{Supported: true},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "A SCIM token issued to the tenant, sent as Authorization: Bearer",
+			Primary:     true,
+		}},
+		Meta: Meta{ResourceType: "ServiceProviderConfig", Location: base + "/ServiceProviderConfig"},
+	}
+}
+
+type ResourceType struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+	Meta     Meta     `json:"meta"`
+}
+
+func NewResourceTypes(base string) []ResourceType {
+	return []ResourceType{
+		{
+			Schemas:  []string{SchemaResourceType},
+			ID:       "User",
+			Name:     "User",
+			Endpoint: "/Users",
+			Schema:   SchemaUser,
+			Meta:     Meta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/User"},
+		},
+		{
+			Schemas:  []string{SchemaResourceType},
+			ID:       "Group",
+			Name:     "Group",
+			Endpoint: "/Groups",
+			Schema:   SchemaGroup,
+			Meta:     Meta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/Group"},
+		},
+	}
+}
+
+type Attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []Attribute `json:"subAttributes,omitempty"`
+}
+
+type Schema struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Attributes []Attribute `json:"attributes"`
+	Meta       Meta        `json:"meta"`
+}
+
+func attribute(name string, kind string, mutability string) Attribute {
+	return Attribute{Name: name, Type: kind, Mutability: mutability, Returned: "default", Uniqueness: "none"}
+}
+
+func NewSchemas(base string) []Schema {
+	userName := attribute("userName", "string", "readWrite")
+	userName.Required, userName.Uniqueness = true, "server"
+
+	name := attribute("name", "complex", "readWrite")
+	name.SubAttributes = []Attribute{
+		attribute("givenName", "string", "readWrite"),
+		attribute("familyName", "string", "readWrite"),
+	}
+
+	emails := attribute("emails", "complex", "readOnly")
+	emails.MultiValued = true
+	emails.SubAttributes = []Attribute{
+		attribute("value", "string", "readOnly"),
+		attribute("type", "string", "readOnly"),
+		attribute("primary", "boolean", "readOnly"),
+	}
+
+	externalID := attribute("externalId", "string", "readWrite")
+	externalID.CaseExact = true
+
+	userGroups := attribute("groups", "complex", "readOnly")
+	userGroups.MultiValued = true
+	userGroups.SubAttributes = []Attribute{
+		attribute("value", "string", "readOnly"),
+		attribute("$ref", "reference", "readOnly"),
+		attribute("display", "string", "readOnly"),
+	}
+
+	displayName := attribute("displayName", "string", "readWrite")
+	displayName.Required, displayName.Uniqueness = true, "server"
+
+	members := attribute("members", "complex", "readWrite")
+	members.MultiValued = true
+	members.SubAttributes = []Attribute{
+		attribute("value", "string", "immutable"),
+		attribute("$ref", "reference", "immutable"),
+		attribute("display", "string", "readOnly"),
+		attribute("type", "string", "immutable"),
+	}
+
+	return []Schema{
+		{
+			Schemas: []string{SchemaSchema},
+			ID:      SchemaUser,
+			Name:    "User",
+			Attributes: []Attribute{
+				userName,
+				externalID,
+				name,
+				attribute("displayName", "string", "readOnly"),
+				emails,
+				attribute("locale", "string", "readWrite"),
+				attribute("preferredLanguage", "string", "readWrite"),
+				attribute("active", "boolean", "readWrite"),
+				userGroups,
+			},
+			Meta: Meta{ResourceType: "Schema", Location: base + "/Schemas/" + SchemaUser},
+		},
+		{
+			Schemas:    []string{SchemaSchema},
+			ID:         SchemaGroup,
+			Name:       "Group",
+			Attributes: []Attribute{displayName, externalID, members},
+			Meta:       Meta{ResourceType: "Schema", Location: base + "/Schemas/" + SchemaGroup},
+		},
+	}
+}
@@ -0,0 +1,389 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid SCIM filter")
+	ErrInvalidPath   = errors.New("invalid SCIM path")
+	ErrInvalidSyntax = errors.New("invalid SCIM request")
+	ErrNoTarget      = errors.New("SCIM operation has no target")
+)
+
+// Filters come from provisioning clients, anything longer or deeper is not a real query
+const (
+	maxFilterLength = 2048
+	maxFilterDepth  = 16
+)
+
+// Filter is a parsed filter expression (RFC 7644 section 3.4.2.2). Logical operators
+// (and, or, not) have Operands; comparisons have Attribute, Operator and Value, where
+// pr has no Value.
+//
+// Attributes are lowercased paths with the core schema URN removed, e.g. "name.givenname".
+// Value paths are flattened: emails[type eq "work"] becomes emails.type eq "work", which
+// matches the same resources as long as the complex attribute has one value per resource.
+type Filter struct {
+	Operator  string
+	Attribute string
+	Value     any // string, bool, float64 or nil for null
+	Operands  []*Filter
+}
+
+var comparisonOperators = []string{"eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le"}
+
+// ParseFilter parses the filter query parameter
+func ParseFilter(filter string) (*Filter, error) {
+	if len(filter) > maxFilterLength {
+		return nil, fmt.Errorf("%w: too long", ErrInvalidFilter)
+	}
+
+	p := &filterParser{input: filter}
+	parsed, err := p.parseOr(0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if !p.done() {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, p.input[p.pos:])
+	}
+	return parsed, nil
+}
+
+// Path is the target of a PATCH operation: an attribute, optionally narrowed to the
+// values matching Filter and to one of their sub-attributes, as in
+// emails[type eq "work"].value or members[value eq "2819c223"].
+type Path struct {
+	Attribute    string // lowercased, e.g. "emails", "name" or "userName"
+	SubAttribute string // lowercased, e.g. "givenname" of name.givenName
+	Filter       *Filter
+}
+
+// ParsePath parses the path of a PATCH operation (RFC 7644 section 3.5.2)
+func ParsePath(path string) (Path, error) {
+	p := &filterParser{input: strings.TrimSpace(path)}
+
+	attribute, err := p.attributePath("")
+	if err != nil {
+		return Path{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+
+	var parsed Path
+	parsed.Attribute, parsed.SubAttribute = splitAttribute(attribute)
+
+	if p.peek() == '[' {
+		if parsed.SubAttribute != "" {
+			return Path{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+
+		p.pos++
+		parsed.Filter, err = p.parseOr(1, parsed.Attribute)
+		if err != nil {
+			return Path{}, err
+		}
+		if p.peek() != ']' {
+			return Path{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		p.pos++
+
+		if p.peek() == '.' {
+			p.pos++
+			sub, err := p.attributeName()
+			if err != nil {
+				return Path{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+			}
+			parsed.SubAttribute = sub
+		}
+	}
+
+	if !p.done() {
+		return Path{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return parsed, nil
+}
+
+// Name is the dotted attribute the path ends at, e.g. "name.givenname" or "emails.value"
+func (p Path) Name() string {
+	if p.SubAttribute == "" {
+		return p.Attribute
+	}
+	return p.Attribute + "." + p.SubAttribute
+}
+
+type filterParser struct {
+	input string
+	pos   int
+}
+
+// parseOr parses an or of ands, "and" binding tighter. parent is the attribute of the
+// value path being parsed, its sub-attributes are qualified with it.
+func (p *filterParser) parseOr(depth int, parent string) (*Filter, error) {
+	left, err := p.parseAnd(depth, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.parseAnd(depth, parent)
+		if err != nil {
+			return nil, err
+		}
+		left = &Filter{Operator: "or", Operands: []*Filter{left, right}}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd(depth int, parent string) (*Filter, error) {
+	left, err := p.parseUnary(depth, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.parseUnary(depth, parent)
+		if err != nil {
+			return nil, err
+		}
+		left = &Filter{Operator: "and", Operands: []*Filter{left, right}}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary(depth int, parent string) (*Filter, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("%w: nested too deep", ErrInvalidFilter)
+	}
+
+	p.skipSpace()
+	if p.keyword("not") {
+		p.skipSpace()
+		if p.peek() != '(' {
+			return nil, fmt.Errorf("%w: expected ( after not", ErrInvalidFilter)
+		}
+		inner, err := p.parseGroup(depth, parent)
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Operator: "not", Operands: []*Filter{inner}}, nil
+	}
+
+	if p.peek() == '(' {
+		return p.parseGroup(depth, parent)
+	}
+
+	attribute, err := p.attributePath(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	// A value path: the filter applies to the sub-attributes of attribute
+	if p.peek() == '[' {
+		if _, sub := splitAttribute(attribute); parent != "" || sub != "" {
+			return nil, fmt.Errorf("%w: invalid value path", ErrInvalidFilter)
+		}
+		p.pos++
+		inner, err := p.parseOr(depth+1, attribute)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ']' {
+			return nil, fmt.Errorf("%w: expected ]", ErrInvalidFilter)
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	operator := strings.ToLower(p.word())
+	if operator == "pr" {
+		return &Filter{Operator: "pr", Attribute: attribute}, nil
+	}
+
+	if !isComparison(operator) {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, operator)
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Operator: operator, Attribute: attribute, Value: value}, nil
+}
+
+func (p *filterParser) parseGroup(depth int, parent string) (*Filter, error) {
+	p.pos++
+	inner, err := p.parseOr(depth+1, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.peek() != ')' {
+		return nil, fmt.Errorf("%w: expected )", ErrInvalidFilter)
+	}
+	p.pos++
+	return inner, nil
+}
+
+// attributePath reads [URN ":"] name ["." name], dropping the core schema URNs
+func (p *filterParser) attributePath(parent string) (string, error) {
+	p.skipSpace()
+
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(" ()[]", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	attribute := p.input[start:p.pos]
+
+	// Extension attributes keep their URN so they never match a core attribute
+	var extension string
+	if strings.HasPrefix(strings.ToLower(attribute), "urn:") {
+		colon := strings.LastIndexByte(attribute, ':')
+		if schema := attribute[:colon]; !strings.EqualFold(schema, SchemaUser) && !strings.EqualFold(schema, SchemaGroup) {
+			extension = schema + ":"
+		}
+		attribute = attribute[colon+1:]
+	}
+
+	name, sub, dotted := strings.Cut(attribute, ".")
+	if !validName(name) || dotted && !validName(sub) {
+		return "", fmt.Errorf("%w: invalid attribute %q", ErrInvalidFilter, p.input[start:p.pos])
+	}
+
+	if parent != "" {
+		if dotted || extension != "" {
+			return "", fmt.Errorf("%w: invalid attribute %q", ErrInvalidFilter, p.input[start:p.pos])
+		}
+		return parent + "." + strings.ToLower(attribute), nil
+	}
+	return strings.ToLower(extension + attribute), nil
+}
+
+// splitAttribute separates the sub-attribute of a path, after any URN
+func splitAttribute(attribute string) (string, string) {
+	colon := strings.LastIndexByte(attribute, ':')
+	name, sub, _ := strings.Cut(attribute[colon+1:], ".")
+	return attribute[:colon+1] + name, sub
+}
+
+func (p *filterParser) attributeName() (string, error) {
+	start := p.pos
+	for !p.done() && p.input[p.pos] != ' ' {
+		p.pos++
+	}
+
+	name := p.input[start:p.pos]
+	if !validName(name) {
+		return "", ErrInvalidPath
+	}
+	return strings.ToLower(name), nil
+}
+
+// value reads a JSON string, number, true, false or null
+func (p *filterParser) value() (any, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, fmt.Errorf("%w: missing value", ErrInvalidFilter)
+	}
+
+	var raw string
+	if p.input[p.pos] == '"' {
+		end := p.pos + 1
+		for end < len(p.input) && p.input[end] != '"' {
+			if p.input[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.input) {
+			return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+		}
+		raw = p.input[p.pos : end+1]
+		p.pos = end + 1
+	} else {
+		raw = p.word()
+		switch strings.ToLower(raw) {
+		case "true", "false", "null":
+			raw = strings.ToLower(raw)
+		}
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, fmt.Errorf("%w: invalid value %s", ErrInvalidFilter, raw)
+	}
+	if _, ok := value.(map[string]any); ok {
+		return nil, fmt.Errorf("%w: invalid value %s", ErrInvalidFilter, raw)
+	}
+	if _, ok := value.([]any); ok {
+		return nil, fmt.Errorf("%w: invalid value %s", ErrInvalidFilter, raw)
+	}
+	return value, nil
+}
+
+// keyword consumes the word if it is kw, in any case, followed by a space or parenthesis
+func (p *filterParser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.input) && !strings.ContainsRune(" (", rune(p.input[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(" ()[]", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *filterParser) skipSpace() {
+	for !p.done() && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *filterParser) peek() byte {
+	p.skipSpace()
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func isComparison(operator string) bool {
+	for _, candidate := range comparisonOperators {
+		if operator == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// validName accepts ATTRNAME (RFC 7643 section 2.1)
+func validName(name string) bool {
+	if name == "" || !(name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z' || name[0] == '$') {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '$') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// sexp writes a filter as (operator attribute value) so trees compare as strings
+func sexp(f *Filter) string {
+	switch f.Operator {
+	case "and", "or", "not":
+		parts := []string{f.Operator}
+		for _, operand := range f.Operands {
+			parts = append(parts, sexp(operand))
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case "pr":
+		return "(pr " + f.Attribute + ")"
+	}
+	if s, ok := f.Value.(string); ok {
+		return fmt.Sprintf("(%s %s %q)", f.Operator, f.Attribute, s)
+	}
+	return fmt.Sprintf("(%s %s %v)", f.Operator, f.Attribute, f.Value)
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		// Every operator
+		{filter: `userName eq "ada@example.com"`, want: `(eq username "ada@example.com")`},
+		{filter: `userName ne "ada@example.com"`, want: `(ne username "ada@example.com")`},
+		{filter: `userName co "example"`, want: `(co username "example")`},
+		{filter: `userName sw "ada"`, want: `(sw username "ada")`},
+		{filter: `userName ew ".com"`, want: `(ew username ".com")`},
+		{filter: `meta.created gt "2024-01-01T00:00:00Z"`, want: `(gt meta.created "2024-01-01T00:00:00Z")`},
+		{filter: `meta.created ge "2024-01-01T00:00:00Z"`, want: `(ge meta.created "2024-01-01T00:00:00Z")`},
+		{filter: `meta.lastModified lt "2024-01-01T00:00:00Z"`, want: `(lt meta.lastmodified "2024-01-01T00:00:00Z")`},
+		{filter: `meta.lastModified le "2024-01-01T00:00:00Z"`, want: `(le meta.lastmodified "2024-01-01T00:00:00Z")`},
+		{filter: `title pr`, want: `(pr title)`},
+
+		// Operators and keywords in any case, values of every JSON type
+		{filter: `userName EQ "x" AND active Eq TRUE`, want: `(and (eq username "x") (eq active true))`},
+		{filter: `externalId eq null`, want: `(eq externalid <nil>)`},
+		{filter: `x.y gt 1.5`, want: `(gt x.y 1.5)`},
+
+		// and binds tighter than or, parentheses and not override it
+		{filter: `a eq 1 or b eq 2 and c eq 3`, want: `(or (eq a 1) (and (eq b 2) (eq c 3)))`},
+		{filter: `a eq 1 and b eq 2 or c eq 3`, want: `(or (and (eq a 1) (eq b 2)) (eq c 3))`},
+		{filter: `(a eq 1 or b eq 2) and c eq 3`, want: `(and (or (eq a 1) (eq b 2)) (eq c 3))`},
+		{filter: `not (a eq 1) and b pr`, want: `(and (not (eq a 1)) (pr b))`},
+		{filter: `not(a eq 1 or b eq 2)`, want: `(not (or (eq a 1) (eq b 2)))`},
+		{filter: `a eq 1 or b eq 2 or c eq 3`, want: `(or (or (eq a 1) (eq b 2)) (eq c 3))`},
+
+		// Quoted strings are JSON, keywords and brackets inside them are data
+		{filter: `displayName eq "say \"hi\""`, want: `(eq displayname "say \"hi\"")`},
+		{filter: `displayName eq "back\\slash"`, want: `(eq displayname "back\\slash")`},
+		{filter: `displayName eq "café"`, want: `(eq displayname "café")`},
+		{filter: `displayName eq "a or b) and (c"`, want: `(eq displayname "a or b) and (c")`},
+		{filter: `displayName eq ""`, want: `(eq displayname "")`},
+
+		// Core schema URNs are dropped, extension URNs kept
+		{filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "x"`, want: `(eq username "x")`},
+		{filter: `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber eq "7"`, want: `(eq urn:ietf:params:scim:schemas:extension:enterprise:2.0:user:employeenumber "7")`},
+
+		// Value paths qualify the attributes inside the brackets
+		{filter: `emails[type eq "work" and value co "@example.com"]`, want: `(and (eq emails.type "work") (co emails.value "@example.com"))`},
+		{filter: `emails[type eq "work"] or userName eq "x"`, want: `(or (eq emails.type "work") (eq username "x"))`},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) = %v", test.filter, err)
+			continue
+		}
+		if got := sexp(filter); got != test.want {
+			t.Errorf("ParseFilter(%s) = %s, want %s", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestParseFilterRejectsMalformedInput(t *testing.T) {
+	filters := []string{
+		``,
+		`   `,
+		`userName`,
+		`userName eq`,
+		`userName xx "x"`,
+		`userName eq "open`,
+		`userName eq "trailing\`,
+		`userName eq 'single'`,
+		`userName eq bare`,
+		`userName eq {"a":1}`,
+		`userName eq [1]`,
+		`1name eq "x"`,
+		`a.b.c eq "x"`,
+		`user-name$ eq "x" or`,
+		`and`,
+		`a eq 1 and`,
+		`a eq 1 or or b eq 2`,
+		`(a eq 1`,
+		`a eq 1)`,
+		`()`,
+		`not a eq 1`,
+		`not`,
+		`emails[type eq "work"`,
+		`emails[type eq "work"]]`,
+		`emails[type[value eq 1]]`,
+		`name.givenName[x eq 1]`,
+		`emails[urn:x:y eq 1]`,
+		`a eq 1 b eq 2`,
+		strings.Repeat("(", maxFilterDepth+2) + `a eq 1` + strings.Repeat(")", maxFilterDepth+2),
+		`a eq "` + strings.Repeat("x", maxFilterLength) + `"`,
+	}
+
+	for _, filter := range filters {
+		if parsed, err := ParseFilter(filter); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("ParseFilter(%.40s) = %+v, %v, want ErrInvalidFilter", filter, parsed, err)
+		}
+	}
+}
+
+func TestParseFilterNeverPanics(t *testing.T) {
+	// Every cut of a valid filter is malformed in some way, none may crash the parser
+	filter := `not (emails[type eq "work" and value co "a\"b"]) or (userName sw "ada" and meta.created gt "2024-01-01T00:00:00Z") or title pr`
+	for i := range filter {
+		ParseFilter(filter[:i])
+		ParseFilter(filter[i:])
+		ParsePath(filter[:i])
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		name   string
+		filter string
+	}{
+		{path: "userName", name: "username"},
+		{path: "name.givenName", name: "name.givenname"},
+		{path: `emails[type eq "work"]`, name: "emails", filter: `(eq emails.type "work")`},
+		{path: `emails[type eq "work"].value`, name: "emails.value", filter: `(eq emails.type "work")`},
+		{path: `members[value eq "2819c223"]`, name: "members", filter: `(eq members.value "2819c223")`},
+	}
+
+	for _, test := range tests {
+		path, err := ParsePath(test.path)
+		if err != nil || path.Name() != test.name {
+			t.Errorf("ParsePath(%s) = %+v, %v, want %s", test.path, path, err, test.name)
+			continue
+		}
+
+		var filter string
+		if path.Filter != nil {
+			filter = sexp(path.Filter)
+		}
+		if filter != test.filter {
+			t.Errorf("ParsePath(%s) filter = %s, want %s", test.path, filter, test.filter)
+		}
+	}
+
+	for _, path := range []string{"", "name.givenName[x eq 1]", `emails[type eq "work"`, `emails[type eq "work"].`, "a b"} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%q) succeeded", path)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of SCIM requests and responses (RFC 7644 section 3.1)
+const ContentType = "application/scim+json"
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// scimType values of an Error (RFC 7644 section 3.12)
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorUniqueness    = "uniqueness"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorNoTarget      = "noTarget"
+	ErrorInvalidValue  = "invalidValue"
+)
+
+// Error is the body of every SCIM error response. Status repeats the HTTP status as a string.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func NewError(status int, scimType string, detail string) Error {
+	return Error{Schemas: []string{SchemaError}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail}
+}
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created,omitzero"`
+	LastModified time.Time `json:"lastModified,omitzero"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Reference points at another resource, a group member or a group of a user
+type Reference struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+type User struct {
+	Schemas           []string    `json:"schemas"`
+	ID                string      `json:"id,omitempty"`
+	ExternalID        string      `json:"externalId,omitempty"`
+	UserName          string      `json:"userName"`
+	Name              *Name       `json:"name,omitempty"`
+	DisplayName       string      `json:"displayName,omitempty"`
+	Emails            []Email     `json:"emails,omitempty"`
+	Locale            string      `json:"locale,omitempty"`
+	PreferredLanguage string      `json:"preferredLanguage,omitempty"`
+	Active            *Bool       `json:"active,omitempty"`
+	Groups            []Reference `json:"groups,omitempty"`
+	Meta              *Meta       `json:"meta,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int64    `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+func NewListResponse(total int64, startIndex int, resources []any) ListResponse {
+	if resources == nil {
+		resources = []any{}
+	}
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// PatchRequest is the body of a PATCH (RFC 7644 section 3.5.2)
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation changes the attribute at Path, or the attributes named by the keys
+// of Value when Path is empty. Op is add, replace or remove in any case.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Operation returns the lowercased op after checking it is one of add, replace and remove
+func (o PatchOperation) Operation() (string, error) {
+	op := strings.ToLower(o.Op)
+	switch op {
+	case "add", "replace":
+		return op, nil
+	case "remove":
+		if o.Path == "" {
+			return "", fmt.Errorf("%w: remove needs a path", ErrNoTarget)
+		}
+		return op, nil
+	}
+	return "", fmt.Errorf("%w: unknown op %q", ErrInvalidSyntax, o.Op)
+}
+
+// Bool accepts true, false and the "True" and "False" strings some clients send
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var value bool
+	if err := json.Unmarshal(data, &value); err == nil {
+		*b = Bool(value)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	parsed, err := strconv.ParseBool(strings.ToLower(text))
+	if err != nil {
+		return err
+	}
+	*b = Bool(parsed)
+	return nil
+}
+
+// Version is the weak entity tag of a resource last modified at modified
+func Version(modified time.Time) string {
+	return `W/"` + strconv.FormatInt(modified.UnixMicro(), 36) + `"`
+}
+
+// MatchesVersion reports whether an If-Match or If-None-Match header lists version or *.
+// Tags compare weakly, with or without the W/ prefix.
+func MatchesVersion(header string, version string) bool {
+	version = strings.TrimPrefix(version, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == version {
+			return true
+		}
+	}
+	return false
+}
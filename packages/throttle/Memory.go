@@ -0,0 +1,70 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in process, for tests and single instance deployments
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]Counter
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[string]Counter{}}
+}
+
+func (s *MemoryStore) Get(key string) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counters[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(key string, window time.Duration, now time.Time) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter := s.counters[key]
+	if now.Sub(counter.LastFailureAt) > window {
+		counter.Failures = 0
+	}
+	counter.Failures++
+	counter.LastFailureAt = now
+
+	s.counters[key] = counter
+	return counter, nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter := s.counters[key]
+	counter.LockedUntil = &until
+	s.counters[key] = counter
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *MemoryStore) Cleanup(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for key, counter := range s.counters {
+		if counter.LastFailureAt.Before(before) && (counter.LockedUntil == nil || counter.LockedUntil.Before(before)) {
+			delete(s.counters, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
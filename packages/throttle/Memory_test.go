@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreCountsWithinWindow(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= 3; i++ {
+		counter, err := store.RecordFailure("user:a", time.Minute, now.Add(time.Duration(i)*10*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counter.Failures != i {
+			t.Fatalf("failure %d counted as %d", i, counter.Failures)
+		}
+	}
+
+	// The previous failure is older than the window so the count starts over
+	counter, _ := store.RecordFailure("user:a", time.Minute, now.Add(5*time.Minute))
+	if counter.Failures != 1 {
+		t.Fatalf("failures after the window = %d, want 1", counter.Failures)
+	}
+
+	if other, _ := store.Get("user:b"); other.Failures != 0 || other.LockedUntil != nil {
+		t.Fatalf("unknown key = %+v, want a zero counter", other)
+	}
+}
+
+func TestMemoryStoreLockAndReset(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store.RecordFailure("user:a", time.Minute, now)
+	if err := store.Lock("user:a", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	counter, _ := store.Get("user:a")
+	if !counter.Locked(now) || counter.Locked(now.Add(time.Minute)) {
+		t.Fatalf("lock until %v not honoured", counter.LockedUntil)
+	}
+	if counter.Failures != 1 {
+		t.Fatalf("Lock changed failures to %d", counter.Failures)
+	}
+
+	store.Reset("user:a")
+	if counter, _ := store.Get("user:a"); counter.Failures != 0 || counter.Locked(now) {
+		t.Fatalf("counter after Reset = %+v", counter)
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store.RecordFailure("stale", time.Minute, now.Add(-2*time.Hour))
+	store.RecordFailure("recent", time.Minute, now)
+	store.RecordFailure("locked", time.Minute, now.Add(-2*time.Hour))
+	store.Lock("locked", now.Add(time.Hour))
+
+	removed, err := store.Cleanup(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("Cleanup removed %d counters, want 1", removed)
+	}
+	if counter, _ := store.Get("locked"); counter.Failures != 1 {
+		t.Fatal("Cleanup dropped a counter that is still locked")
+	}
+	if counter, _ := store.Get("recent"); counter.Failures != 1 {
+		t.Fatal("Cleanup dropped a recent counter")
+	}
+}
@@ -0,0 +1,76 @@
+package throttle
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps counters in the login_throttles table so every instance shares them
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(key string) (Counter, error) {
+	var row models.LoginThrottle
+
+	err := s.db.Where("key = ?", key).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Counter{}, nil
+	}
+	if err != nil {
+		return Counter{}, err
+	}
+
+	return counterFromRow(row), nil
+}
+
+// RecordFailure is a single upsert so concurrent failures are all counted
+func (s *PostgresStore) RecordFailure(key string, window time.Duration, now time.Time) (Counter, error) {
+	var row models.LoginThrottle
+
+	err := s.db.Raw(`INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING key, failures, last_failure_at, locked_until`,
+		key, now, now.Add(-window)).Scan(&row).Error
+	if err != nil {
+		return Counter{}, err
+	}
+
+	return counterFromRow(row), nil
+}
+
+func (s *PostgresStore) Lock(key string, until time.Time) error {
+	return s.db.Exec(`INSERT INTO login_throttles (key, failures, last_failure_at, locked_until)
+		VALUES (?, 0, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET locked_until = EXCLUDED.locked_until`,
+		key, time.Now(), until).Error
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.LoginThrottle{}).Error
+}
+
+func (s *PostgresStore) Cleanup(before time.Time) (int64, error) {
+	result := s.db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", before, before).
+		Delete(&models.LoginThrottle{})
+
+	return result.RowsAffected, result.Error
+}
+
+func counterFromRow(row models.LoginThrottle) Counter {
+	return Counter{
+		Failures:      row.Failures,
+		LastFailureAt: row.LastFailureAt,
+		LockedUntil:   row.LockedUntil,
+	}
+}
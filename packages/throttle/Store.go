@@ -0,0 +1,46 @@
+// Package throttle tracks failed login attempts per key (user, IP, tenant) for
+// brute-force protection. The store is pluggable, Postgres by default.
+package throttle
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Counter is the failure state of one key
+type Counter struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Locked reports whether the key is locked at now
+func (c Counter) Locked(now time.Time) bool {
+	return c.LockedUntil != nil && now.Before(*c.LockedUntil)
+}
+
+type Store interface {
+	// Get returns the counter of key, a zero Counter when there is none
+	Get(key string) (Counter, error)
+	// RecordFailure counts a failure, the count restarts when the previous failure is older than window
+	RecordFailure(key string, window time.Duration, now time.Time) (Counter, error)
+	// Lock blocks the key until the given time
+	Lock(key string, until time.Time) error
+	// Reset forgets the key, used after a successful login or an unlock
+	Reset(key string) error
+	// Cleanup drops counters whose last failure and lock are older than before
+	Cleanup(before time.Time) (int64, error)
+}
+
+// FromEnv builds the configured Store: "postgres" (default) or "memory" (THROTTLE_STORE)
+func FromEnv(db *gorm.DB) Store {
+	switch os.Getenv("THROTTLE_STORE") {
+	case "memory":
+		log.Println("Using in-memory login throttle store, counters are per process")
+		return NewMemoryStore()
+	}
+	return NewPostgresStore(db)
+}
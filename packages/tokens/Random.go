@@ -0,0 +1,32 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// DefaultLength is the number of random bytes used for opaque tokens (256 bits)
+const DefaultLength = 32
+
+// Generate returns a URL safe random token built from n bytes of entropy
+func Generate(n ...int) (string, error) {
+	length := DefaultLength
+	if len(n) > 0 {
+		length = n[0]
+	}
+
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash returns the hex sha256 of a token, opaque tokens are only ever stored hashed
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
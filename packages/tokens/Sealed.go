@@ -0,0 +1,64 @@
+package tokens
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrInvalidSealed = errors.New("invalid sealed value")
+
+// Seal encrypts secrets that must be readable again (e.g. TOTP seeds) with AES-GCM,
+// keyed from the signing secret. The result is base64url(nonce|ciphertext).
+func Seal(plaintext string) (string, error) {
+	aead, err := sealCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal
+func Open(sealed string) (string, error) {
+	aead, err := sealCipher()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", ErrInvalidSealed
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrInvalidSealed
+	}
+	return string(plaintext), nil
+}
+
+func sealCipher() (cipher.AEAD, error) {
+	secret, err := signingSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// Derived separately so the encryption key never equals the HMAC key
+	key := sha256.Sum256(append([]byte("digipass-seal|"), secret...))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,63 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The SHA-1 vectors from RFC 6238 appendix B, truncated to the 6 digits we issue
+func TestCodeRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(secret, Step(time.Unix(test.unix, 0)))
+		if err != nil || code != test.code {
+			t.Errorf("Code at %d = %s, %v, want %s", test.unix, code, err, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		code string
+		step int64
+		ok   bool
+	}{
+		{code: "050471", step: Step(now), ok: true},
+		{code: " 050 471\t", step: Step(now), ok: true},
+		// One step either side absorbs clock drift
+		{code: "081804", step: Step(now) - 1, ok: true},
+		{code: "287082"},
+		{code: "05047"},
+		{code: "0504710"},
+		{code: "abcdef"},
+		{code: ""},
+	}
+
+	for _, test := range tests {
+		step, ok := Validate(secret, test.code, now, 1)
+		if ok != test.ok || step != test.step {
+			t.Errorf("Validate(%q) = %d, %v, want %d, %v", test.code, step, ok, test.step, test.ok)
+		}
+	}
+
+	if _, ok := Validate("not base32!", "050471", now, 1); ok {
+		t.Errorf("Validate with an invalid secret succeeded")
+	}
+}
@@ -0,0 +1,147 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Attestation types reported for a registered credential
+const (
+	AttestationNone  = "none"
+	AttestationSelf  = "self"
+	AttestationBasic = "basic" // signed by an attestation certificate, the chain is not checked against a trust store
+)
+
+var (
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+	ErrInvalidAttestation     = errors.New("invalid attestation statement")
+)
+
+// id-fido-gen-ce-aaguid, the AAGUID an attestation certificate was issued for
+var oidFIDOAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	Format    string
+	Statement map[any]any
+	AuthData  []byte
+}
+
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	entries, err := cborMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+	}
+
+	format, _ := entries["fmt"].(string)
+	statement, okStatement := entries["attStmt"].(map[any]any)
+	authData, okAuthData := entries["authData"].([]byte)
+	if format == "" || !okStatement || !okAuthData {
+		return nil, fmt.Errorf("%w: missing fmt, attStmt or authData", ErrInvalidAttestation)
+	}
+
+	return &attestationObject{Format: format, Statement: statement, AuthData: authData}, nil
+}
+
+// verifyAttestation checks the statement for the formats we accept and returns the attestation type
+func verifyAttestation(object *attestationObject, authData *AuthenticatorData, clientDataHash []byte) (string, error) {
+	switch object.Format {
+	case "none":
+		if len(object.Statement) != 0 {
+			return "", fmt.Errorf("%w: none attestation with a statement", ErrInvalidAttestation)
+		}
+		return AttestationNone, nil
+
+	case "packed":
+		return verifyPacked(object, authData, clientDataHash)
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedAttestation, object.Format)
+}
+
+// verifyPacked implements the packed attestation statement format, WebAuthn §8.2
+func verifyPacked(object *attestationObject, authData *AuthenticatorData, clientDataHash []byte) (string, error) {
+	alg, okAlg := object.Statement["alg"].(int64)
+	signature, okSig := object.Statement["sig"].([]byte)
+	if !okAlg || !okSig {
+		return "", fmt.Errorf("%w: packed statement needs alg and sig", ErrInvalidAttestation)
+	}
+
+	signed := append(append([]byte(nil), object.AuthData...), clientDataHash...)
+
+	chain, hasChain := object.Statement["x5c"].([]any)
+	if !hasChain {
+		// Self attestation, signed by the credential key itself
+		if alg != authData.parsedKey.Algorithm {
+			return "", fmt.Errorf("%w: alg does not match the credential key", ErrInvalidAttestation)
+		}
+
+		if err := authData.parsedKey.Verify(signed, signature); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+		}
+		return AttestationSelf, nil
+	}
+
+	if len(chain) == 0 {
+		return "", fmt.Errorf("%w: empty x5c", ErrInvalidAttestation)
+	}
+
+	der, ok := chain[0].([]byte)
+	if !ok {
+		return "", fmt.Errorf("%w: x5c entry is not a certificate", ErrInvalidAttestation)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+	}
+
+	algorithm, err := x509Algorithm(alg)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cert.CheckSignature(algorithm, signed, signature); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+	}
+
+	if err := checkPackedCertificate(cert, authData.AAGUID); err != nil {
+		return "", err
+	}
+
+	return AttestationBasic, nil
+}
+
+// checkPackedCertificate applies the attestation certificate requirements of WebAuthn §8.2.1
+func checkPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return fmt.Errorf("%w: attestation certificate must be version 3", ErrInvalidAttestation)
+	}
+
+	if cert.IsCA {
+		return fmt.Errorf("%w: attestation certificate must not be a CA", ErrInvalidAttestation)
+	}
+
+	if len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return fmt.Errorf("%w: attestation certificate subject OU", ErrInvalidAttestation)
+	}
+
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidFIDOAAGUID) {
+			continue
+		}
+
+		if extension.Critical {
+			return fmt.Errorf("%w: aaguid extension must not be critical", ErrInvalidAttestation)
+		}
+
+		var certAAGUID []byte
+		if _, err := asn1.Unmarshal(extension.Value, &certAAGUID); err != nil || !bytes.Equal(certAAGUID, aaguid) {
+			return fmt.Errorf("%w: certificate aaguid does not match", ErrInvalidAttestation)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent    byte = 0x01
+	FlagUserVerified   byte = 0x04
+	FlagBackupEligible byte = 0x08
+	FlagBackupState    byte = 0x10
+	FlagAttestedData   byte = 0x40
+	FlagExtensionData  byte = 0x80
+)
+
+var ErrInvalidAuthenticatorData = errors.New("invalid authenticator data")
+
+// AuthenticatorData is the signed structure every ceremony returns
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Only present on registration
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key bytes as stored
+	parsedKey    *PublicKey
+}
+
+func (d *AuthenticatorData) UserPresent() bool    { return d.Flags&FlagUserPresent != 0 }
+func (d *AuthenticatorData) UserVerified() bool   { return d.Flags&FlagUserVerified != 0 }
+func (d *AuthenticatorData) BackupEligible() bool { return d.Flags&FlagBackupEligible != 0 }
+func (d *AuthenticatorData) BackupState() bool    { return d.Flags&FlagBackupState != 0 }
+
+// ParseAuthenticatorData decodes rpIdHash | flags | signCount | [attested credential data] | [extensions]
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidAuthenticatorData)
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if authData.Flags&FlagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: truncated attested credential data", ErrInvalidAuthenticatorData)
+		}
+
+		authData.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, fmt.Errorf("%w: bad credential id length", ErrInvalidAuthenticatorData)
+		}
+		authData.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		// The COSE key is followed directly by the extensions, its encoded length is only known after decoding
+		value, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: credential public key: %v", ErrInvalidAuthenticatorData, err)
+		}
+
+		entries, ok := value.(map[any]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: credential public key is not a map", ErrInvalidAuthenticatorData)
+		}
+
+		authData.parsedKey, err = publicKeyFromMap(entries)
+		if err != nil {
+			return nil, err
+		}
+		authData.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if authData.Flags&FlagExtensionData != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions: %v", ErrInvalidAuthenticatorData, err)
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidAuthenticatorData)
+	}
+
+	return authData, nil
+}
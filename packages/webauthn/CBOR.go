@@ -0,0 +1,164 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidCBOR = errors.New("invalid CBOR")
+
+// Nesting beyond this is never produced by authenticators and only costs stack
+const maxCBORDepth = 16
+
+// decodeCBOR reads one data item from the front of data (RFC 8949) and returns the
+// rest. It covers what attestation objects and COSE keys use: integers, byte and
+// text strings, arrays, maps, booleans and null. Map keys are int64 or string.
+// Integers decode as int64, unsigned values above MaxInt64 are rejected.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deep", ErrInvalidCBOR)
+	}
+
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	// Simple values have no argument to read
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22, 23:
+			return nil, data[1:], nil
+		}
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, info)
+	}
+
+	arg, rest, err := cborArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return int64(arg), rest, nil
+
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return -1 - int64(arg), rest, nil
+
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: string longer than data", ErrInvalidCBOR)
+		}
+		value := rest[:arg]
+		if major == 3 {
+			return string(value), rest[arg:], nil
+		}
+		return append([]byte(nil), value...), rest[arg:], nil
+
+	case 4:
+		// Every item takes at least one byte, longer claims are malformed
+		if arg > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: array longer than data", ErrInvalidCBOR)
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			var item any
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+
+	case 5:
+		if arg > uint64(len(rest))/2 {
+			return nil, nil, fmt.Errorf("%w: map longer than data", ErrInvalidCBOR)
+		}
+		entries := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type %T", ErrInvalidCBOR, key)
+			}
+
+			if _, ok := entries[key]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key %v", ErrInvalidCBOR, key)
+			}
+
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: unsupported major type %d", ErrInvalidCBOR, major)
+}
+
+// cborArgument reads the length or value that follows the initial byte.
+// Indefinite lengths (info 31) are not used by authenticators and are refused.
+func cborArgument(data []byte) (uint64, []byte, error) {
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+
+	return 0, nil, fmt.Errorf("%w: bad argument encoding", ErrInvalidCBOR)
+}
+
+// cborMap decodes data that must be exactly one map
+func cborMap(data []byte) (map[any]any, error) {
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidCBOR)
+	}
+
+	entries, ok := value.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a map", ErrInvalidCBOR)
+	}
+	return entries, nil
+}
@@ -0,0 +1,123 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// encodeCBOR writes the subset decodeCBOR reads, for building authenticator output in tests
+func encodeCBOR(value any) []byte {
+	header := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg <= 0xff:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+		case arg <= 0xffffffff:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+	}
+
+	switch v := value.(type) {
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []any:
+		encoded := header(4, uint64(len(v)))
+		for _, item := range v {
+			encoded = append(encoded, encodeCBOR(item)...)
+		}
+		return encoded
+	case map[any]any:
+		encoded := header(5, uint64(len(v)))
+		for key, item := range v {
+			encoded = append(encoded, encodeCBOR(key)...)
+			encoded = append(encoded, encodeCBOR(item)...)
+		}
+		return encoded
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic(fmt.Sprintf("encodeCBOR: %T", value))
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	value := map[any]any{
+		int64(1):  int64(2),
+		int64(-1): int64(-257),
+		"bytes":   bytes.Repeat([]byte{0xab}, 300),
+		"text":    "packed",
+		"array":   []any{int64(70000), int64(1) << 40, true, false, nil},
+	}
+
+	decoded, rest, err := decodeCBOR(append(encodeCBOR(value), 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, []byte{0xff}) {
+		t.Fatalf("rest = %x", rest)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(value) {
+		t.Fatalf("decodeCBOR =\n%v\nwant\n%v", decoded, value)
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	nested := append(bytes.Repeat([]byte{0x81}, maxCBORDepth+1), 0x01)
+
+	tests := map[string][]byte{
+		"empty":                  {},
+		"truncated argument":     {0x19, 0x01},
+		"string past the end":    {0x44, 0x01, 0x02},
+		"array past the end":     {0x83, 0x01},
+		"map past the end":       {0xa2, 0x01, 0x02},
+		"indefinite length":      {0x9f, 0x01, 0xff},
+		"integer overflow":       {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"negative overflow":      {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"duplicate map key":      {0xa2, 0x01, 0x02, 0x01, 0x03},
+		"byte string map key":    {0xa1, 0x41, 0x00, 0x01},
+		"tag":                    {0xc0, 0x61, 0x61},
+		"float":                  {0xf9, 0x3c, 0x00},
+		"nested too deep":        nested,
+		"missing map value":      {0xa1, 0x01},
+		"reserved argument (28)": {0x1c},
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if value, _, err := decodeCBOR(data); !errors.Is(err, ErrInvalidCBOR) {
+				t.Fatalf("decodeCBOR = %v, %v, want ErrInvalidCBOR", value, err)
+			}
+		})
+	}
+}
+
+func TestCBORMapRejectsTrailingDataAndOtherTypes(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"trailing data": append(encodeCBOR(map[any]any{"fmt": "none"}), 0x00),
+		"array":         encodeCBOR([]any{"fmt"}),
+	} {
+		if _, err := cborMap(data); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("%s: cborMap = %v, want ErrInvalidCBOR", name, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) offered in pubKeyCredParams, most preferred first
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported credential public key")
+	ErrBadSignature   = errors.New("signature verification failed")
+)
+
+// COSE key map labels and values
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseCrv = -1 // EC2 and OKP
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1 // RSA
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// PublicKey is a credential public key decoded from its COSE form
+type PublicKey struct {
+	Algorithm int64
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key as stored for a credential
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	entries, err := cborMap(cose)
+	if err != nil {
+		return nil, err
+	}
+	return publicKeyFromMap(entries)
+}
+
+func publicKeyFromMap(entries map[any]any) (*PublicKey, error) {
+	kty, _ := entries[int64(coseKty)].(int64)
+	alg, _ := entries[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := entries[int64(coseCrv)].(int64)
+		x, _ := entries[int64(coseX)].([]byte)
+		y, _ := entries[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: bad EC2 key", ErrUnsupportedKey)
+		}
+
+		// Uncompressed point form rejects coordinates that are not on the curve
+		point := append([]byte{4}, append(x, y...)...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		return &PublicKey{Algorithm: alg, Key: key}, nil
+
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := entries[int64(coseCrv)].(int64)
+		x, _ := entries[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: bad OKP key", ErrUnsupportedKey)
+		}
+		return &PublicKey{Algorithm: alg, Key: ed25519.PublicKey(x)}, nil
+
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := entries[int64(coseN)].([]byte)
+		e, _ := entries[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: bad RSA key", ErrUnsupportedKey)
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return &PublicKey{Algorithm: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedKey, kty, alg)
+}
+
+// Verify checks a WebAuthn signature over data with the key's algorithm
+func (k *PublicKey) Verify(data []byte, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+
+	return ErrBadSignature
+}
+
+// x509Algorithm maps a COSE algorithm to the matching certificate signature algorithm
+func x509Algorithm(alg int64) (x509.SignatureAlgorithm, error) {
+	switch alg {
+	case AlgES256:
+		return x509.ECDSAWithSHA256, nil
+	case AlgEdDSA:
+		return x509.PureEd25519, nil
+	case AlgRS256:
+		return x509.SHA256WithRSA, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("%w: alg %d", ErrUnsupportedKey, alg)
+}
@@ -0,0 +1,211 @@
+// Package webauthn verifies WebAuthn (passkey) registration and authentication
+// ceremonies. It accepts "none" and "packed" attestation and ES256, EdDSA and
+// RS256 credential keys. Challenge storage is left to the caller.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const ChallengeSize = 32
+
+var (
+	ErrInvalidClientData   = errors.New("invalid client data")
+	ErrChallengeMismatch   = errors.New("challenge does not match")
+	ErrOriginMismatch      = errors.New("origin is not allowed")
+	ErrRPIDMismatch        = errors.New("relying party id does not match")
+	ErrUserNotPresent      = errors.New("user presence was not confirmed")
+	ErrUserNotVerified     = errors.New("user verification is required")
+	ErrSignCountRegression = errors.New("signature counter went backwards, the authenticator may be cloned")
+)
+
+// Config identifies the relying party, RPID is the issuer host and Origin its scheme and host
+type Config struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// CollectedClientData is the clientDataJSON the browser signs over
+type CollectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// Credential is what a successful registration stores
+type Credential struct {
+	ID                []byte
+	PublicKey         []byte // COSE_Key
+	SignCount         uint32
+	AAGUID            []byte
+	AttestationFormat string
+	AttestationType   string
+	UserVerified      bool
+	BackupEligible    bool
+	BackupState       bool
+}
+
+// Assertion is the outcome of a successful authentication
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackupState  bool
+}
+
+// NewChallenge returns random bytes for one ceremony
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ChallengeFromClientData extracts the challenge so the caller can find its stored
+// ceremony before verifying. Nothing in the client data is trusted yet.
+func ChallengeFromClientData(clientDataJSON []byte) ([]byte, error) {
+	var clientData CollectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientData, err)
+	}
+
+	challenge, err := decodeURLBytes(clientData.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientData, err)
+	}
+	return challenge, nil
+}
+
+// VerifyRegistration runs the registration ceremony checks of WebAuthn §7.1
+func (cfg Config) VerifyRegistration(challenge []byte, clientDataJSON []byte, attestationObjectBytes []byte, requireUserVerification bool) (*Credential, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	object, err := parseAttestationObject(attestationObjectBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	authData, err := ParseAuthenticatorData(object.AuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.verifyFlags(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+
+	if authData.CredentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrInvalidAuthenticatorData)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	attestationType, err := verifyAttestation(object, authData, clientDataHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:                authData.CredentialID,
+		PublicKey:         authData.PublicKey,
+		SignCount:         authData.SignCount,
+		AAGUID:            authData.AAGUID,
+		AttestationFormat: object.Format,
+		AttestationType:   attestationType,
+		UserVerified:      authData.UserVerified(),
+		BackupEligible:    authData.BackupEligible(),
+		BackupState:       authData.BackupState(),
+	}, nil
+}
+
+// VerifyAssertion runs the authentication ceremony checks of WebAuthn §7.2 against a
+// stored credential key and counter. A counter of zero on both sides means the
+// authenticator does not implement one, which is normal for synced passkeys.
+func (cfg Config) VerifyAssertion(challenge []byte, publicKey []byte, storedSignCount uint32, clientDataJSON []byte, authenticatorData []byte, signature []byte, requireUserVerification bool) (*Assertion, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.verifyFlags(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorData...), clientDataHash[:]...)
+	if err := key.Verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	if (authData.SignCount != 0 || storedSignCount != 0) && authData.SignCount <= storedSignCount {
+		return nil, ErrSignCountRegression
+	}
+
+	return &Assertion{
+		SignCount:    authData.SignCount,
+		UserVerified: authData.UserVerified(),
+		BackupState:  authData.BackupState(),
+	}, nil
+}
+
+func (cfg Config) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var clientData CollectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClientData, err)
+	}
+
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: type %q", ErrInvalidClientData, clientData.Type)
+	}
+
+	received, err := decodeURLBytes(clientData.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if clientData.Origin != cfg.Origin || clientData.CrossOrigin {
+		return ErrOriginMismatch
+	}
+
+	return nil
+}
+
+func (cfg Config) verifyFlags(authData *AuthenticatorData, requireUserVerification bool) error {
+	rpIDHash := sha256.Sum256([]byte(cfg.RPID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+
+	if !authData.UserPresent() {
+		return ErrUserNotPresent
+	}
+
+	if requireUserVerification && !authData.UserVerified() {
+		return ErrUserNotVerified
+	}
+
+	// A credential cannot be backed up without being eligible for it
+	if authData.BackupState() && !authData.BackupEligible() {
+		return fmt.Errorf("%w: backup state without eligibility", ErrInvalidAuthenticatorData)
+	}
+
+	return nil
+}
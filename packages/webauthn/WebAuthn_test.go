@@ -0,0 +1,446 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{RPID: "id.example.com", RPName: "Example", Origin: "https://id.example.com"}
+
+var testAAGUID = []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+
+// softAuthenticator is an ES256 authenticator in memory, it answers create() and
+// get() the way a security key would
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+
+	// Packed attestation with an x5c chain when set, self attestation otherwise
+	attestationKey  *ecdsa.PrivateKey
+	attestationCert []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: []byte("credential-1")}
+}
+
+// withAttestationCertificate gives the authenticator a batch attestation certificate,
+// change adjusts the template before it is signed
+func (a *softAuthenticator) withAttestationCertificate(t *testing.T, change func(*x509.Certificate)) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aaguid, err := asn1.Marshal(testAAGUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{Country: []string{"US"}, Organization: []string{"Example Keys"}, OrganizationalUnit: []string{"Authenticator Attestation"}, CommonName: "Example Key"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidFIDOAAGUID, Value: aaguid}},
+	}
+	if change != nil {
+		change(template)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.attestationKey, a.attestationCert = key, der
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	point, err := a.key.PublicKey.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	return encodeCBOR(map[any]any{
+		int64(coseKty): int64(ktyEC2),
+		int64(coseAlg): AlgES256,
+		int64(coseCrv): int64(crvP256),
+		int64(coseX):   point[1:33],
+		int64(coseY):   point[33:],
+	})
+}
+
+// ceremony is what the browser and the authenticator put in their output, the
+// defaults describe a genuine ceremony for testConfig
+type ceremony struct {
+	Type        string
+	Challenge   []byte
+	Origin      string
+	CrossOrigin bool
+	RPID        string
+	Flags       byte
+	Format      string
+}
+
+func (c ceremony) clientDataJSON() []byte {
+	encoded, _ := json.Marshal(CollectedClientData{
+		Type:        c.Type,
+		Challenge:   base64.RawURLEncoding.EncodeToString(c.Challenge),
+		Origin:      c.Origin,
+		CrossOrigin: c.CrossOrigin,
+	})
+	return encoded
+}
+
+func (a *softAuthenticator) authenticatorData(c ceremony, attested bool) []byte {
+	flags := c.Flags
+	if attested {
+		flags |= FlagAttestedData
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, testAAGUID...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) sign(key *ecdsa.PrivateKey, authData []byte, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signature
+}
+
+// create answers navigator.credentials.create()
+func (a *softAuthenticator) create(c ceremony) (clientDataJSON []byte, attestationObject []byte) {
+	clientDataJSON = c.clientDataJSON()
+	authData := a.authenticatorData(c, true)
+
+	statement := map[any]any{}
+	if c.Format == "packed" {
+		statement["alg"] = AlgES256
+		if a.attestationKey != nil {
+			statement["sig"] = a.sign(a.attestationKey, authData, clientDataJSON)
+			statement["x5c"] = []any{a.attestationCert}
+		} else {
+			statement["sig"] = a.sign(a.key, authData, clientDataJSON)
+		}
+	}
+
+	return clientDataJSON, encodeCBOR(map[any]any{"fmt": c.Format, "attStmt": statement, "authData": authData})
+}
+
+// get answers navigator.credentials.get(), counting the signature
+func (a *softAuthenticator) get(c ceremony) (clientDataJSON []byte, authenticatorData []byte, signature []byte) {
+	if a.signCount != 0 {
+		a.signCount++
+	}
+
+	clientDataJSON = c.clientDataJSON()
+	authenticatorData = a.authenticatorData(c, false)
+	return clientDataJSON, authenticatorData, a.sign(a.key, authenticatorData, clientDataJSON)
+}
+
+func genuine(ceremonyType string, challenge []byte) ceremony {
+	return ceremony{
+		Type:      ceremonyType,
+		Challenge: challenge,
+		Origin:    testConfig.Origin,
+		RPID:      testConfig.RPID,
+		Flags:     FlagUserPresent | FlagUserVerified | FlagBackupEligible | FlagBackupState,
+		Format:    "none",
+	}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	challenge := []byte("registration-challenge-32-bytes!")
+
+	with := func(change func(*ceremony)) ceremony {
+		c := genuine("webauthn.create", challenge)
+		change(&c)
+		return c
+	}
+
+	tests := []struct {
+		name          string
+		authenticator *softAuthenticator
+		ceremony      ceremony
+		requireUV     bool
+		want          error
+		wantType      string
+	}{
+		{name: "none attestation", authenticator: newSoftAuthenticator(t), ceremony: genuine("webauthn.create", challenge), requireUV: true, wantType: AttestationNone},
+		{name: "packed self attestation", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Format = "packed" }), requireUV: true, wantType: AttestationSelf},
+		{name: "packed x5c attestation", authenticator: newSoftAuthenticator(t).withAttestationCertificate(t, nil), ceremony: with(func(c *ceremony) { c.Format = "packed" }), requireUV: true, wantType: AttestationBasic},
+		{name: "x5c certificate for another AAGUID", authenticator: newSoftAuthenticator(t).withAttestationCertificate(t, func(c *x509.Certificate) {
+			other, _ := asn1.Marshal(make([]byte, 16))
+			c.ExtraExtensions = []pkix.Extension{{Id: oidFIDOAAGUID, Value: other}}
+		}), ceremony: with(func(c *ceremony) { c.Format = "packed" }), want: ErrInvalidAttestation},
+		{name: "x5c certificate without the attestation OU", authenticator: newSoftAuthenticator(t).withAttestationCertificate(t, func(c *x509.Certificate) {
+			c.Subject.OrganizationalUnit = nil
+		}), ceremony: with(func(c *ceremony) { c.Format = "packed" }), want: ErrInvalidAttestation},
+		{name: "x5c CA certificate", authenticator: newSoftAuthenticator(t).withAttestationCertificate(t, func(c *x509.Certificate) {
+			c.IsCA, c.BasicConstraintsValid = true, true
+		}), ceremony: with(func(c *ceremony) { c.Format = "packed" }), want: ErrInvalidAttestation},
+		{name: "unsupported format", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Format = "tpm" }), want: ErrUnsupportedAttestation},
+
+		{name: "user verification not required", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Flags &^= FlagUserVerified }), wantType: AttestationNone},
+		{name: "user not verified", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Flags &^= FlagUserVerified }), requireUV: true, want: ErrUserNotVerified},
+		{name: "user not present", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Flags &^= FlagUserPresent }), want: ErrUserNotPresent},
+		{name: "backed up without eligibility", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Flags &^= FlagBackupEligible }), want: ErrInvalidAuthenticatorData},
+
+		{name: "other relying party", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.RPID = "evil.example.com" }), want: ErrRPIDMismatch},
+		{name: "parent domain as relying party", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.RPID = "example.com" }), want: ErrRPIDMismatch},
+		{name: "other origin", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Origin = "https://evil.example.com" }), want: ErrOriginMismatch},
+		{name: "http origin", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Origin = "http://id.example.com" }), want: ErrOriginMismatch},
+		{name: "cross origin iframe", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.CrossOrigin = true }), want: ErrOriginMismatch},
+		{name: "other challenge", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Challenge = []byte("another-challenge") }), want: ErrChallengeMismatch},
+		{name: "authentication client data", authenticator: newSoftAuthenticator(t), ceremony: with(func(c *ceremony) { c.Type = "webauthn.get" }), want: ErrInvalidClientData},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientDataJSON, attestationObject := test.authenticator.create(test.ceremony)
+
+			credential, err := testConfig.VerifyRegistration(challenge, clientDataJSON, attestationObject, test.requireUV)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("VerifyRegistration = %+v, %v, want %v", credential, err, test.want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("VerifyRegistration = %v", err)
+			}
+			if credential.AttestationType != test.wantType || credential.AttestationFormat != test.ceremony.Format {
+				t.Fatalf("attestation = %s %s, want %s %s", credential.AttestationFormat, credential.AttestationType, test.ceremony.Format, test.wantType)
+			}
+			if !bytes.Equal(credential.ID, test.authenticator.credentialID) || !bytes.Equal(credential.AAGUID, testAAGUID) {
+				t.Fatalf("credential = %+v", credential)
+			}
+
+			key, err := ParsePublicKey(credential.PublicKey)
+			if err != nil || !test.authenticator.key.PublicKey.Equal(key.Key) {
+				t.Fatalf("stored key = %+v, %v", key, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejectsForgedAttestation(t *testing.T) {
+	challenge := []byte("registration-challenge-32-bytes!")
+	authenticator := newSoftAuthenticator(t)
+	c := genuine("webauthn.create", challenge)
+	c.Format = "packed"
+
+	clientDataJSON, attestationObject := authenticator.create(c)
+	object, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A self attestation signed over other client data does not cover this registration
+	other := genuine("webauthn.create", []byte("other"))
+	other.Format = "packed"
+	otherClientData, _ := authenticator.create(other)
+	object.Statement["sig"] = authenticator.sign(authenticator.key, object.AuthData, otherClientData)
+
+	forged := encodeCBOR(map[any]any{"fmt": object.Format, "attStmt": object.Statement, "authData": object.AuthData})
+	if _, err := testConfig.VerifyRegistration(challenge, clientDataJSON, forged, true); !errors.Is(err, ErrInvalidAttestation) {
+		t.Fatalf("VerifyRegistration = %v, want ErrInvalidAttestation", err)
+	}
+
+	// none attestation must not carry a statement
+	withStatement := encodeCBOR(map[any]any{"fmt": "none", "attStmt": map[any]any{"alg": AlgES256}, "authData": object.AuthData})
+	if _, err := testConfig.VerifyRegistration(challenge, clientDataJSON, withStatement, true); !errors.Is(err, ErrInvalidAttestation) {
+		t.Fatalf("VerifyRegistration = %v, want ErrInvalidAttestation", err)
+	}
+}
+
+func TestVerifyRegistrationRejectsMalformedInput(t *testing.T) {
+	challenge := []byte("registration-challenge-32-bytes!")
+	authenticator := newSoftAuthenticator(t)
+	clientDataJSON, attestationObject := authenticator.create(genuine("webauthn.create", challenge))
+
+	object, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authData := object.AuthData
+	keyStart := 37 + 16 + 2 + len(authenticator.credentialID)
+
+	replaceAuthData := func(data []byte) []byte {
+		return encodeCBOR(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": data})
+	}
+
+	tests := []struct {
+		name              string
+		clientDataJSON    []byte
+		attestationObject []byte
+		want              error
+	}{
+		{name: "client data is not JSON", clientDataJSON: []byte("{"), attestationObject: attestationObject, want: ErrInvalidClientData},
+		{name: "attestation object is not CBOR", clientDataJSON: clientDataJSON, attestationObject: []byte{0xa3, 0x63}, want: ErrInvalidAttestation},
+		{name: "attestation object without authData", clientDataJSON: clientDataJSON, attestationObject: encodeCBOR(map[any]any{"fmt": "none", "attStmt": map[any]any{}}), want: ErrInvalidAttestation},
+		{name: "authData too short", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(authData[:36]), want: ErrInvalidAuthenticatorData},
+		{name: "no attested credential", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(append(append([]byte(nil), authData[:32]...), FlagUserPresent|FlagUserVerified, 0, 0, 0, 0)), want: ErrInvalidAuthenticatorData},
+		{name: "credential id longer than authData", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(append(append([]byte(nil), authData[:53]...), 0x03, 0xff)), want: ErrInvalidAuthenticatorData},
+		{name: "truncated credential key", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(authData[:len(authData)-5]), want: ErrInvalidAuthenticatorData},
+		{name: "credential key is not a map", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(append(append([]byte(nil), authData[:keyStart]...), encodeCBOR([]any{int64(1)})...)), want: ErrInvalidAuthenticatorData},
+		{name: "trailing data after the key", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(append(append([]byte(nil), authData...), 0x00)), want: ErrInvalidAuthenticatorData},
+		{name: "unsupported key type", clientDataJSON: clientDataJSON, attestationObject: replaceAuthData(append(append([]byte(nil), authData[:keyStart]...), encodeCBOR(map[any]any{int64(coseKty): int64(ktyEC2), int64(coseAlg): int64(-35)})...)), want: ErrUnsupportedKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if credential, err := testConfig.VerifyRegistration(challenge, test.clientDataJSON, test.attestationObject, true); !errors.Is(err, test.want) {
+				t.Fatalf("VerifyRegistration = %+v, %v, want %v", credential, err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	challenge := []byte("authentication-challenge-bytes!!")
+
+	with := func(change func(*ceremony)) ceremony {
+		c := genuine("webauthn.get", challenge)
+		change(&c)
+		return c
+	}
+
+	tests := []struct {
+		name            string
+		signCount       uint32 // the authenticator's counter before get()
+		storedSignCount uint32
+		ceremony        ceremony
+		requireUV       bool
+		want            error
+	}{
+		{name: "counter advances", signCount: 4, storedSignCount: 4, ceremony: genuine("webauthn.get", challenge), requireUV: true},
+		{name: "no counter on either side", ceremony: genuine("webauthn.get", challenge), requireUV: true},
+		{name: "counter went backwards", signCount: 2, storedSignCount: 9, ceremony: genuine("webauthn.get", challenge), want: ErrSignCountRegression},
+		{name: "counter repeated", signCount: 8, storedSignCount: 9, ceremony: genuine("webauthn.get", challenge), want: ErrSignCountRegression},
+		{name: "counter stopped", storedSignCount: 9, ceremony: genuine("webauthn.get", challenge), want: ErrSignCountRegression},
+
+		{name: "user verification not required", ceremony: with(func(c *ceremony) { c.Flags &^= FlagUserVerified })},
+		{name: "user not verified", ceremony: with(func(c *ceremony) { c.Flags &^= FlagUserVerified }), requireUV: true, want: ErrUserNotVerified},
+		{name: "user not present", ceremony: with(func(c *ceremony) { c.Flags &^= FlagUserPresent }), want: ErrUserNotPresent},
+		{name: "other relying party", ceremony: with(func(c *ceremony) { c.RPID = "evil.example.com" }), want: ErrRPIDMismatch},
+		{name: "other origin", ceremony: with(func(c *ceremony) { c.Origin = "https://id.example.com.evil.example" }), want: ErrOriginMismatch},
+		{name: "cross origin iframe", ceremony: with(func(c *ceremony) { c.CrossOrigin = true }), want: ErrOriginMismatch},
+		{name: "other challenge", ceremony: with(func(c *ceremony) { c.Challenge = []byte("replayed-challenge") }), want: ErrChallengeMismatch},
+		{name: "registration client data", ceremony: with(func(c *ceremony) { c.Type = "webauthn.create" }), want: ErrInvalidClientData},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t)
+			authenticator.signCount = test.signCount
+			clientDataJSON, authenticatorData, signature := authenticator.get(test.ceremony)
+
+			assertion, err := testConfig.VerifyAssertion(challenge, authenticator.coseKey(), test.storedSignCount, clientDataJSON, authenticatorData, signature, test.requireUV)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("VerifyAssertion = %+v, %v, want %v", assertion, err, test.want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("VerifyAssertion = %v", err)
+			}
+			if assertion.SignCount != authenticator.signCount || assertion.UserVerified != (test.ceremony.Flags&FlagUserVerified != 0) {
+				t.Fatalf("assertion = %+v", assertion)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejectsBadSignatures(t *testing.T) {
+	challenge := []byte("authentication-challenge-bytes!!")
+	authenticator := newSoftAuthenticator(t)
+	other := newSoftAuthenticator(t)
+
+	clientDataJSON, authenticatorData, signature := authenticator.get(genuine("webauthn.get", challenge))
+
+	tampered := append([]byte(nil), authenticatorData...)
+	tampered[36] ^= 0x01
+
+	tests := []struct {
+		name              string
+		publicKey         []byte
+		clientDataJSON    []byte
+		authenticatorData []byte
+		signature         []byte
+		want              error
+	}{
+		{name: "another credential's key", publicKey: other.coseKey(), clientDataJSON: clientDataJSON, authenticatorData: authenticatorData, signature: signature, want: ErrBadSignature},
+		{name: "counter changed after signing", publicKey: authenticator.coseKey(), clientDataJSON: clientDataJSON, authenticatorData: tampered, signature: signature, want: ErrBadSignature},
+		{name: "signature is not DER", publicKey: authenticator.coseKey(), clientDataJSON: clientDataJSON, authenticatorData: authenticatorData, signature: signature[1:], want: ErrBadSignature},
+		{name: "stored key is not CBOR", publicKey: []byte{0xa5, 0x01}, clientDataJSON: clientDataJSON, authenticatorData: authenticatorData, signature: signature, want: ErrInvalidCBOR},
+		{name: "authenticator data too short", publicKey: authenticator.coseKey(), clientDataJSON: clientDataJSON, authenticatorData: authenticatorData[:36], signature: signature, want: ErrInvalidAuthenticatorData},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if assertion, err := testConfig.VerifyAssertion(challenge, test.publicKey, 0, test.clientDataJSON, test.authenticatorData, test.signature, true); !errors.Is(err, test.want) {
+				t.Fatalf("VerifyAssertion = %+v, %v, want %v", assertion, err, test.want)
+			}
+		})
+	}
+}
+
+func TestChallengeFromClientData(t *testing.T) {
+	challenge := []byte("registration-challenge-32-bytes!")
+
+	got, err := ChallengeFromClientData(genuine("webauthn.create", challenge).clientDataJSON())
+	if err != nil || !bytes.Equal(got, challenge) {
+		t.Fatalf("ChallengeFromClientData = %q, %v", got, err)
+	}
+
+	// Browsers have sent padded base64url
+	padded := []byte(`{"type":"webauthn.get","challenge":"` + base64.URLEncoding.EncodeToString([]byte("ab")) + `"}`)
+	if got, err := ChallengeFromClientData(padded); err != nil || string(got) != "ab" {
+		t.Fatalf("ChallengeFromClientData(padded) = %q, %v", got, err)
+	}
+
+	if _, err := ChallengeFromClientData([]byte(`{"challenge":"not base64!"}`)); !errors.Is(err, ErrInvalidClientData) {
+		t.Fatalf("ChallengeFromClientData = %v, want ErrInvalidClientData", err)
+	}
+}
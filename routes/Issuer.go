@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"DigiPassAuthenticationApi/handlers"
+	"DigiPassAuthenticationApi/middleware"
+	"github.com/labstack/echo/v5"
+)
+
+// RegisterIssuerRoutes mounts the tenant facing endpoints, the group must run middleware.ResolveTenant
+func RegisterIssuerRoutes(g *echo.Group) {
+	//Handler
+	issuerHandler := handlers.NewIssuerHandler()
+	userHandler := handlers.NewUserHandler()
+	hostedHandler := handlers.NewHostedHandler()
+	scimHandler := handlers.NewSCIMHandler()
+
+	g.GET("/.well-known/openid-configuration", issuerHandler.Discovery)
+	g.GET("/.well-known/jwks.json", issuerHandler.JWKS)
+
+	// OAuth 2.0 and OpenID Connect, the token endpoint takes form bodies from clients
+	g.GET("/authorize", hostedHandler.Authorize)
+	g.POST("/token", issuerHandler.Token)
+
+	// End user JSON API, endpoints without a session only take JSON bodies (no CSRF token yet)
+	g.POST("/users/register", userHandler.Register, middleware.RequireJSON())
+	g.POST("/users/login", userHandler.Login, middleware.RequireJSON())
+	g.GET("/users/me", userHandler.Me, middleware.RequireUserSession())
+	g.GET("/users/me/authorization", userHandler.Authorization, middleware.RequireUserOrAccessToken())
+	g.POST("/logout", userHandler.Logout, middleware.RequireUserSession())
+	g.POST("/users/verify-email", userHandler.VerifyEmail, middleware.RequireJSON())
+	g.POST("/users/verify-email/resend", userHandler.ResendVerification, middleware.RequireUserSession())
+	g.POST("/users/password/forgot", userHandler.ForgotPassword, middleware.RequireJSON())
+	g.POST("/users/password/reset", userHandler.ResetPassword, middleware.RequireJSON())
+	g.POST("/users/password/change", userHandler.ChangePassword, middleware.RequireJSON())
+	g.POST("/users/unlock", userHandler.Unlock, middleware.RequireJSON())
+	g.POST("/users/mfa/verify", userHandler.VerifyMFA, middleware.RequireJSON())
+	g.POST("/users/mfa/enroll", userHandler.StartMFAEnrollment, middleware.RequireJSON())
+	g.POST("/users/mfa/enroll/confirm", userHandler.CompleteMFAEnrollment, middleware.RequireJSON())
+	g.GET("/users/mfa", userHandler.MFAStatus, middleware.RequireUserSession())
+	g.POST("/users/mfa/totp", userHandler.StartTOTP, middleware.RequireUserSession())
+	g.POST("/users/mfa/totp/confirm", userHandler.ConfirmTOTP, middleware.RequireUserSession())
+	g.POST("/users/mfa/totp/disable", userHandler.DisableTOTP, middleware.RequireUserSession())
+	g.POST("/users/mfa/recovery-codes", userHandler.RegenerateRecoveryCodes, middleware.RequireUserSession())
+	g.POST("/users/email-login", userHandler.StartEmailLogin, middleware.RequireJSON())
+	g.POST("/users/email-login/code", userHandler.VerifyEmailLoginCode, middleware.RequireJSON())
+	g.POST("/users/passkeys/signup/options", userHandler.PasskeySignupOptions, middleware.RequireJSON())
+	g.POST("/users/passkeys/signup", userHandler.PasskeySignup, middleware.RequireJSON())
+	g.POST("/users/passkeys/login/options", userHandler.PasskeyLoginOptions, middleware.RequireJSON())
+	g.POST("/users/passkeys/login", userHandler.PasskeyLogin, middleware.RequireJSON())
+	g.GET("/users/passkeys", userHandler.ListPasskeys, middleware.RequireUserSession())
+	g.POST("/users/passkeys/options", userHandler.PasskeyRegistrationOptions, middleware.RequireUserSession())
+	g.POST("/users/passkeys", userHandler.RegisterPasskey, middleware.RequireUserSession())
+	g.DELETE("/users/passkeys/:id", userHandler.DeletePasskey, middleware.RequireUserSession())
+	g.GET("/users/identities", userHandler.ListIdentities, middleware.RequireUserSession())
+	g.DELETE("/users/identities/:id", userHandler.UnlinkIdentity, middleware.RequireUserSession())
+
+	// Hosted pages
+	g.GET("/login", hostedHandler.LoginPage)
+	g.POST("/login", hostedHandler.Login)
+	g.POST("/mfa", hostedHandler.MFA)
+	g.POST("/mfa/enroll", hostedHandler.MFAEnroll)
+	g.GET("/email-login", hostedHandler.EmailLoginPage)
+	g.POST("/email-login", hostedHandler.EmailLogin)
+	g.POST("/email-login/code", hostedHandler.EmailLoginCode)
+	g.GET("/email-login/verify", hostedHandler.EmailLoginLink)
+	g.GET("/federation/callback", hostedHandler.FederationCallback)
+	g.GET("/federation/saml/metadata", hostedHandler.FederationSAMLMetadata)
+	g.POST("/federation/saml/acs", hostedHandler.FederationSAMLACS)
+	g.GET("/federation/:providerId", hostedHandler.FederationStart)
+	g.GET("/register", hostedHandler.RegisterPage)
+	g.POST("/register", hostedHandler.Register)
+	g.GET("/verify-email", hostedHandler.VerifyEmailPage)
+	g.GET("/forgot-password", hostedHandler.ForgotPasswordPage)
+	g.POST("/forgot-password", hostedHandler.ForgotPassword)
+	g.GET("/reset-password", hostedHandler.ResetPasswordPage)
+	g.POST("/reset-password", hostedHandler.ResetPassword)
+	g.GET("/change-password", hostedHandler.ChangePasswordPage)
+	g.POST("/change-password", hostedHandler.ChangePassword)
+	g.GET("/unlock", hostedHandler.UnlockPage)
+
+	// SAML identity provider
+	g.GET("/saml/metadata", hostedHandler.SAMLMetadata)
+	g.GET("/saml/sso", hostedHandler.SAMLSSO)
+	g.POST("/saml/sso", hostedHandler.SAMLSSO)
+	g.GET("/saml/sso/resume", hostedHandler.SAMLResume)
+	g.GET("/saml/idp/:spId", hostedHandler.SAMLIdPInitiated)
+	g.GET("/saml/slo", hostedHandler.SAMLSLO)
+	g.POST("/saml/slo", hostedHandler.SAMLSLO)
+
+	// SCIM provisioning
+	scim := g.Group("/scim/v2", middleware.RequireSCIMToken())
+	scim.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+	scim.GET("/ResourceTypes", scimHandler.ResourceTypes)
+	scim.GET("/Schemas", scimHandler.Schemas)
+	scim.GET("/Users", scimHandler.ListUsers)
+	scim.POST("/Users", scimHandler.CreateUser)
+	scim.GET("/Users/:id", scimHandler.GetUser)
+	scim.PUT("/Users/:id", scimHandler.ReplaceUser)
+	scim.PATCH("/Users/:id", scimHandler.PatchUser)
+	scim.DELETE("/Users/:id", scimHandler.DeleteUser)
+	scim.GET("/Groups", scimHandler.ListGroups)
+	scim.POST("/Groups", scimHandler.CreateGroup)
+	scim.GET("/Groups/:id", scimHandler.GetGroup)
+	scim.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+	scim.PATCH("/Groups/:id", scimHandler.PatchGroup)
+	scim.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"DigiPassAuthenticationApi/middleware"
 	v1 "DigiPassAuthenticationApi/routes/v1"
 	"github.com/labstack/echo/v5"
 )
@@ -8,7 +9,16 @@ import (
 func SetUpRoutes(e *echo.Echo) {
 	apiv1 := e.Group("/v1")
 
+	// Management routes must run behind middleware.RequireConsoleSession and
+	// declare their permission with middleware.RequirePermission
+
 	v1.RegisterAccountRoutes(apiv1)
 	v1.RegisterAccountUsersRoutes(apiv1)
 	v1.RegisterTenantRoutes(apiv1)
+	v1.RegisterConsoleRoutes(apiv1)
+	v1.RegisterClientRoutes(apiv1)
+
+	// Tenant issuers, by path (/t/{slug}/...) or by host ({slug}.TENANT_BASE_DOMAIN or a custom domain)
+	RegisterIssuerRoutes(e.Group("/t/:slug", middleware.ResolveTenant()))
+	RegisterIssuerRoutes(e.Group("", middleware.ResolveTenant()))
 }
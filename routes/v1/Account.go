@@ -2,6 +2,8 @@ package v1
 
 import (
 	"DigiPassAuthenticationApi/handlers"
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/permissions"
 	"github.com/labstack/echo/v5"
 )
 
@@ -12,4 +14,14 @@ func RegisterAccountRoutes(e *echo.Group) {
 	accountHandler := handlers.NewAccountHandler()
 
 	v1Account.POST("/new", accountHandler.Create)
+	v1Account.GET("/verify", accountHandler.Verify)
+	v1Account.POST("/verify/resend", accountHandler.ResendVerification)
+	// Takes the credentials itself, suspended and deleted accounts cannot sign in
+	v1Account.POST("/reactivate", accountHandler.Reactivate)
+
+	manage := v1Account.Group("", middleware.RequireConsoleSession())
+	manage.GET("", accountHandler.Get, middleware.RequirePermission(permissions.AccountRead))
+	manage.PATCH("", accountHandler.Update, middleware.RequirePermission(permissions.AccountUpdate))
+	manage.POST("/suspend", accountHandler.Suspend, middleware.RequirePermission(permissions.AccountUpdate))
+	manage.POST("/deletion", accountHandler.RequestDeletion, middleware.RequirePermission(permissions.AccountDelete))
 }
@@ -2,6 +2,8 @@ package v1
 
 import (
 	"DigiPassAuthenticationApi/handlers"
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/permissions"
 	"github.com/labstack/echo/v5"
 )
 
@@ -11,5 +13,12 @@ func RegisterAccountUsersRoutes(e *echo.Group) {
 	//Handler
 	accountUsersHandler := handlers.NewAccountUsersHandler()
 
-	println("Printing for placeholder right now", v1AccountUsers, accountUsersHandler)
+	// Public, the invitation token is the credential
+	v1AccountUsers.POST("/invitations/accept", accountUsersHandler.AcceptInvitation)
+
+	manage := v1AccountUsers.Group("", middleware.RequireConsoleSession())
+	manage.GET("", accountUsersHandler.List, middleware.RequirePermission(permissions.AccountUsersRead))
+	manage.POST("/invitations", accountUsersHandler.Invite, middleware.RequirePermission(permissions.AccountUsersManage))
+	manage.PATCH("/:id/role", accountUsersHandler.ChangeRole, middleware.RequirePermission(permissions.AccountUsersManage))
+	manage.DELETE("/:id", accountUsersHandler.Remove, middleware.RequirePermission(permissions.AccountUsersManage))
 }
@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"DigiPassAuthenticationApi/handlers"
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"github.com/labstack/echo/v5"
+)
+
+func RegisterClientRoutes(e *echo.Group) {
+	v1Clients := e.Group("/tenant/:id/clients", middleware.RequireConsoleSession())
+
+	//Handler
+	clientHandler := handlers.NewClientHandler()
+	authorizationHandler := handlers.NewAuthorizationHandler()
+
+	v1Clients.GET("", clientHandler.List, middleware.RequirePermission(permissions.ClientsRead))
+	v1Clients.POST("", clientHandler.Create, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.GET("/:clientId", clientHandler.Get, middleware.RequirePermission(permissions.ClientsRead))
+	v1Clients.PATCH("/:clientId", clientHandler.Update, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.DELETE("/:clientId", clientHandler.Delete, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.POST("/:clientId/secret/rotate", clientHandler.RotateSecret, middleware.RequirePermission(permissions.ClientsManage))
+
+	v1Clients.GET("/:clientId/permissions", authorizationHandler.ListPermissions, middleware.RequirePermission(permissions.ClientsRead))
+	v1Clients.POST("/:clientId/permissions", authorizationHandler.CreatePermission, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.DELETE("/:clientId/permissions/:permissionId", authorizationHandler.DeletePermission, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.GET("/:clientId/roles", authorizationHandler.ListRoles, middleware.RequirePermission(permissions.ClientsRead))
+	v1Clients.POST("/:clientId/roles", authorizationHandler.CreateRole, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.PATCH("/:clientId/roles/:roleId", authorizationHandler.UpdateRole, middleware.RequirePermission(permissions.ClientsManage))
+	v1Clients.DELETE("/:clientId/roles/:roleId", authorizationHandler.DeleteRole, middleware.RequirePermission(permissions.ClientsManage))
+}
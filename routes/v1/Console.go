@@ -0,0 +1,25 @@
+package v1
+
+import (
+	"DigiPassAuthenticationApi/handlers"
+	"DigiPassAuthenticationApi/middleware"
+	"github.com/labstack/echo/v5"
+)
+
+func RegisterConsoleRoutes(e *echo.Group) {
+	v1Console := e.Group("/console")
+
+	//Handler
+	consoleHandler := handlers.NewConsoleHandler()
+
+	v1Console.POST("/login", consoleHandler.Login)
+	v1Console.GET("/unlock", consoleHandler.Unlock)
+	v1Console.POST("/logout", consoleHandler.Logout, middleware.RequireConsoleSession())
+	v1Console.GET("/me", consoleHandler.Me, middleware.RequireConsoleSession())
+	v1Console.POST("/mfa/verify", consoleHandler.VerifyMFA)
+	v1Console.GET("/mfa", consoleHandler.MFAStatus, middleware.RequireConsoleSession())
+	v1Console.POST("/mfa/totp", consoleHandler.StartTOTP, middleware.RequireConsoleSession())
+	v1Console.POST("/mfa/totp/confirm", consoleHandler.ConfirmTOTP, middleware.RequireConsoleSession())
+	v1Console.POST("/mfa/totp/disable", consoleHandler.DisableTOTP, middleware.RequireConsoleSession())
+	v1Console.POST("/mfa/recovery-codes", consoleHandler.RegenerateRecoveryCodes, middleware.RequireConsoleSession())
+}
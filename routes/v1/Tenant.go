@@ -2,14 +2,62 @@ package v1
 
 import (
 	"DigiPassAuthenticationApi/handlers"
+	"DigiPassAuthenticationApi/middleware"
+	"DigiPassAuthenticationApi/packages/permissions"
 	"github.com/labstack/echo/v5"
 )
 
 func RegisterTenantRoutes(e *echo.Group) {
-	v1Tenant := e.Group("/tenant")
+	v1Tenant := e.Group("/tenant", middleware.RequireConsoleSession())
 
 	//Handler
-	tenantHandler := handlers.NewAccountUsersHandler()
+	tenantHandler := handlers.NewTenantHandler()
+	tenantDomainHandler := handlers.NewTenantDomainHandler()
+	identityProviderHandler := handlers.NewIdentityProviderHandler()
+	samlServiceProviderHandler := handlers.NewSAMLServiceProviderHandler()
+	scimTokenHandler := handlers.NewSCIMTokenHandler()
+	groupHandler := handlers.NewGroupHandler()
+	authorizationHandler := handlers.NewAuthorizationHandler()
 
-	println("Printing for placeholder right now", v1Tenant, tenantHandler)
+	v1Tenant.GET("", tenantHandler.List, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.POST("", tenantHandler.Create, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.GET("/:id", tenantHandler.Get, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.PATCH("/:id", tenantHandler.Rename, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.PUT("/:id/slug", tenantHandler.ChangeSlug, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.PATCH("/:id/status", tenantHandler.UpdateStatus, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.DELETE("/:id", tenantHandler.Delete, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.GET("/:id/settings", tenantHandler.GetSettings, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.PATCH("/:id/settings", tenantHandler.UpdateSettings, middleware.RequirePermission(permissions.TenantsManage))
+
+	v1Tenant.GET("/:id/domains", tenantDomainHandler.List, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.POST("/:id/domains", tenantDomainHandler.Create, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.POST("/:id/domains/:domainId/verify", tenantDomainHandler.Verify, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.DELETE("/:id/domains/:domainId", tenantDomainHandler.Delete, middleware.RequirePermission(permissions.TenantsManage))
+
+	v1Tenant.GET("/:id/identity-providers", identityProviderHandler.List, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.POST("/:id/identity-providers", identityProviderHandler.Create, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.PATCH("/:id/identity-providers/:providerId", identityProviderHandler.Update, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.DELETE("/:id/identity-providers/:providerId", identityProviderHandler.Delete, middleware.RequirePermission(permissions.TenantsManage))
+
+	v1Tenant.GET("/:id/saml/service-providers", samlServiceProviderHandler.List, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.POST("/:id/saml/service-providers", samlServiceProviderHandler.Create, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.PATCH("/:id/saml/service-providers/:spId", samlServiceProviderHandler.Update, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.DELETE("/:id/saml/service-providers/:spId", samlServiceProviderHandler.Delete, middleware.RequirePermission(permissions.TenantsManage))
+
+	v1Tenant.GET("/:id/scim/tokens", scimTokenHandler.List, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.POST("/:id/scim/tokens", scimTokenHandler.Create, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.DELETE("/:id/scim/tokens/:tokenId", scimTokenHandler.Delete, middleware.RequirePermission(permissions.TenantsManage))
+
+	v1Tenant.GET("/:id/groups", groupHandler.List, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.POST("/:id/groups", groupHandler.Create, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.PATCH("/:id/groups/:groupId", groupHandler.Update, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.DELETE("/:id/groups/:groupId", groupHandler.Delete, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.GET("/:id/groups/:groupId/members", groupHandler.Members, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.PUT("/:id/groups/:groupId/members", groupHandler.SetMembers, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.GET("/:id/groups/:groupId/roles", authorizationHandler.GroupRoles, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.PUT("/:id/groups/:groupId/roles", authorizationHandler.SetGroupRoles, middleware.RequirePermission(permissions.TenantsManage))
+
+	v1Tenant.GET("/:id/users/:userId/roles", authorizationHandler.UserRoles, middleware.RequirePermission(permissions.TenantsRead))
+	v1Tenant.PUT("/:id/users/:userId/roles", authorizationHandler.SetUserRoles, middleware.RequirePermission(permissions.TenantsManage))
+	v1Tenant.GET("/:id/users/:userId/claims", authorizationHandler.UserClaims, middleware.RequirePermission(permissions.TenantsRead))
 }
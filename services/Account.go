@@ -2,11 +2,18 @@ package services
 
 import (
 	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"os"
+	"strings"
+	"time"
 )
 
+const DefaultAccountVerificationWindow = 48 * time.Hour
+
 type AccountService struct {
 	db *gorm.DB
 }
@@ -15,21 +22,26 @@ func NewAccountService(db *gorm.DB) *AccountService {
 	return &AccountService{db: db}
 }
 
-func (s *AccountService) CreateAccount(name string, email string) (*models.Account, error) {
-	if name == "" || email == "" {
-		return nil, errors.New("Name and email are required")
+func (s *AccountService) CreateAccount(name string, email string, plainPassword string) (*models.Account, error) {
+	if name == "" || email == "" || plainPassword == "" {
+		return nil, fmt.Errorf("%w: name, email and password are required", ErrAccountInvalid)
 	}
 
-	//0. Check to see if account already exist
+	//0. Check to see if account already exist, an expired unverified signup frees the email
 	if account, _ := s.GetAccountByEmail(email); account != nil {
-		return nil, ErrAccountAlreadyExists
+		if !s.isExpiredSignup(account, time.Now()) {
+			return nil, ErrAccountAlreadyExists
+		}
+		if err := s.db.Delete(account).Error; err != nil {
+			return nil, err
+		}
 	}
 
-	//1. Create Account
+	//1. Create Account, it stays pending until the owner proves they own the email
 	account := &models.Account{
 		Name:   name,
 		Email:  email,
-		Status: "active",
+		Status: "pending_verification",
 	}
 
 	if err := s.db.Create(account).Error; err != nil {
@@ -57,7 +69,7 @@ func (s *AccountService) CreateAccount(name string, email string) (*models.Accou
 
 	//3. Link User to AccountUser Table
 	aus := NewAccountUsersService(s.db)
-	accountUserErr := aus.CreateUser(account.ID, account.Email, "abc123", "owner")
+	accountUserErr := aus.CreateUser(account.ID, account.Email, plainPassword, "owner")
 	if accountUserErr != nil {
 		return nil, accountUserErr
 	}
@@ -80,3 +92,184 @@ func (s *AccountService) GetAccountByEmail(email string) (*models.Account, error
 
 	return &account, nil
 }
+
+// AccountVerificationWindow is how long a signup has to verify its email,
+// configured with ACCOUNT_VERIFICATION_WINDOW (e.g. "24h")
+func AccountVerificationWindow() time.Duration {
+	if window, err := time.ParseDuration(os.Getenv("ACCOUNT_VERIFICATION_WINDOW")); err == nil && window > 0 {
+		return window
+	}
+	return DefaultAccountVerificationWindow
+}
+
+// CreateVerificationToken signs the account id for the email verification link
+func (s *AccountService) CreateVerificationToken(account *models.Account) (string, error) {
+	return tokens.Sign("verify-account", account.ID.String(), AccountVerificationWindow())
+}
+
+// PendingAccountByEmail finds a signup that can still be verified, it is the only
+// kind of account a new verification email is sent for
+func (s *AccountService) PendingAccountByEmail(email string) (*models.Account, error) {
+	account, err := s.GetAccountByEmail(strings.TrimSpace(email))
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Status != "pending_verification" || s.isExpiredSignup(account, time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	return account, nil
+}
+
+// VerifyAccount activates a pending account from its verification token
+func (s *AccountService) VerifyAccount(token string) (*models.Account, error) {
+	subject, err := tokens.VerifySigned("verify-account", token)
+	if err != nil {
+		return nil, ErrVerificationInvalid
+	}
+
+	accountID, err := uuid.Parse(subject)
+	if err != nil {
+		return nil, ErrVerificationInvalid
+	}
+
+	account, err := s.GetAccount(accountID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, ErrVerificationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Status != "pending_verification" {
+		return account, nil
+	}
+
+	if err := s.db.Model(account).Update("status", "active").Error; err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// PurgeUnverifiedAccounts removes signups that never verified their email
+func (s *AccountService) PurgeUnverifiedAccounts(now time.Time) (int64, error) {
+	result := s.db.Where("status = ? AND created_at < ?", "pending_verification", now.Add(-AccountVerificationWindow())).
+		Delete(&models.Account{})
+
+	return result.RowsAffected, result.Error
+}
+
+func (s *AccountService) isExpiredSignup(account *models.Account, now time.Time) bool {
+	return account.Status == "pending_verification" && now.Sub(account.CreatedAt) > AccountVerificationWindow()
+}
+
+// AccountDeletionCoolingOff is how long a deletion request can be cancelled before the purge
+const AccountDeletionCoolingOff = 14 * 24 * time.Hour
+
+func (s *AccountService) GetAccount(id uuid.UUID) (*models.Account, error) {
+	var account models.Account
+
+	err := s.db.Where("id = ?", id).First(&account).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func (s *AccountService) UpdateAccount(id uuid.UUID, name string) (*models.Account, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrAccountInvalid)
+	}
+
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(account).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// SuspendAccount blocks logins and token issuance for every tenant of the account,
+// tenants keep their own status so reactivation restores them as they were
+func (s *AccountService) SuspendAccount(id uuid.UUID) (*models.Account, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Status != "active" {
+		return nil, ErrInvalidStatusTransition
+	}
+
+	if err := s.db.Model(account).Update("status", "suspended").Error; err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// ReactivateAccount lifts a suspension or cancels a pending deletion
+func (s *AccountService) ReactivateAccount(id uuid.UUID) (*models.Account, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch account.Status {
+	case "active":
+		return account, nil
+	case "pending_verification":
+		return nil, ErrAccountNotVerified
+	case "deleted":
+		if account.DeletedAt == nil || time.Since(*account.DeletedAt) > AccountDeletionCoolingOff {
+			return nil, ErrInvalidStatusTransition
+		}
+	}
+
+	err = s.db.Model(account).Updates(map[string]any{"status": "active", "deleted_at": nil}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetAccount(id)
+}
+
+// RequestDeletion marks the account deleted, it is purged once the cooling-off period passes
+func (s *AccountService) RequestDeletion(id uuid.UUID) (*models.Account, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Status == "deleted" {
+		return account, nil
+	}
+
+	err = s.db.Model(account).Updates(map[string]any{"status": "deleted", "deleted_at": time.Now()}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetAccount(id)
+}
+
+// PurgeDeletedAccounts hard deletes accounts past the cooling-off period, relying on
+// OnDelete:CASCADE to remove their tenants, account users and everything below them
+func (s *AccountService) PurgeDeletedAccounts(now time.Time) (int64, error) {
+	result := s.db.Where("status = ? AND deleted_at < ?", "deleted", now.Add(-AccountDeletionCoolingOff)).
+		Delete(&models.Account{})
+
+	return result.RowsAffected, result.Error
+}
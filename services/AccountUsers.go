@@ -2,10 +2,16 @@ package services
 
 import (
 	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/password"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"DigiPassAuthenticationApi/packages/tokens"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
 )
 
 type AccountUsersService struct {
@@ -20,11 +26,20 @@ func isValidRole(role string) bool {
 	return role == "owner" || role == "admin" || role == "member"
 }
 
-func (s *AccountUsersService) CreateUser(accountID uuid.UUID, email string, passwordHash string, role string) error {
+func (s *AccountUsersService) CreateUser(accountID uuid.UUID, email string, plainPassword string, role string) error {
 	if email == "" || !isValidRole(role) {
 		return errors.New("Required Inputs do not match for AccountUsersService.CreateUser")
 	}
 
+	if err := password.ValidateStrength(plainPassword, email); err != nil {
+		return fmt.Errorf("%w: %v", ErrWeakPassword, err)
+	}
+
+	passwordHash, err := password.Hash(plainPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
 	newAccountUser := &models.AccountUser{
 		AccountID:    accountID,
 		Email:        email,
@@ -38,3 +53,254 @@ func (s *AccountUsersService) CreateUser(accountID uuid.UUID, email string, pass
 
 	return nil
 }
+
+// VerifyPassword checks the password and transparently upgrades outdated hashes
+func (s *AccountUsersService) VerifyPassword(user *models.AccountUser, plainPassword string) error {
+	match, needsRehash, err := password.Verify(plainPassword, user.PasswordHash)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		return ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		newHash, err := password.Hash(plainPassword)
+		if err != nil {
+			return fmt.Errorf("failed to rehash password: %w", err)
+		}
+
+		err = s.db.Model(user).Update("password_hash", newHash).Error
+		if err != nil {
+			return fmt.Errorf("failed to store rehashed password: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const InvitationLifetime = 7 * 24 * time.Hour
+
+// Invite records a pending invitation and returns the signed token for the invite link,
+// it is only ever sent to the invited email. Inviting an email again revokes any earlier
+// pending invitation.
+func (s *AccountUsersService) Invite(inviter *models.AccountUser, email string, role string) (*models.AccountUserInvitation, string, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isValidRole(role) {
+		return nil, "", ErrInvalidRole
+	}
+
+	if role == "owner" && !permissions.Has(inviter.Role, permissions.AccountTransferOwnership) {
+		return nil, "", ErrInsufficientRole
+	}
+
+	var count int64
+	err = s.db.Model(&models.AccountUser{}).
+		Where("account_id = ? AND LOWER(email) = ?", inviter.AccountID, strings.ToLower(email)).
+		Count(&count).Error
+	if err != nil {
+		return nil, "", err
+	}
+	if count > 0 {
+		return nil, "", ErrAccountUserAlreadyExists
+	}
+
+	err = s.db.Model(&models.AccountUserInvitation{}).
+		Where("account_id = ? AND LOWER(email) = ? AND accepted_at IS NULL AND revoked_at IS NULL", inviter.AccountID, strings.ToLower(email)).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	invitation := &models.AccountUserInvitation{
+		AccountID:   inviter.AccountID,
+		Email:       email,
+		Role:        role,
+		InvitedByID: &inviter.ID,
+		ExpiresAt:   time.Now().Add(InvitationLifetime),
+	}
+
+	if err := s.db.Create(invitation).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	token, err := tokens.Sign("invite", invitation.ID.String(), InvitationLifetime)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return invitation, token, nil
+}
+
+// AcceptInvitation redeems an invite token once and creates the AccountUser with its
+// password. email must be the invited one, a forwarded link cannot sign up someone else.
+func (s *AccountUsersService) AcceptInvitation(token string, email string, plainPassword string) (*models.AccountUser, error) {
+	subject, err := tokens.VerifySigned("invite", token)
+	if err != nil {
+		return nil, ErrInvitationInvalid
+	}
+
+	invitationID, err := uuid.Parse(subject)
+	if err != nil {
+		return nil, ErrInvitationInvalid
+	}
+
+	var invitation models.AccountUserInvitation
+	err = s.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invitation, "id = ?", invitationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvitationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.AcceptedAt != nil || invitation.RevokedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvitationInvalid
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(email), invitation.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	if err := s.CreateUser(invitation.AccountID, invitation.Email, plainPassword, invitation.Role); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(&invitation).Update("accepted_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetAccountUserByEmail(invitation.AccountID, invitation.Email)
+}
+
+func (s *AccountUsersService) GetAccountUserByEmail(accountID uuid.UUID, email string) (*models.AccountUser, error) {
+	var accountUser models.AccountUser
+
+	err := s.db.Where("account_id = ? AND LOWER(email) = ?", accountID, strings.ToLower(email)).First(&accountUser).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountUser, nil
+}
+
+// GetAccountUser looks up an AccountUser scoped to the caller's account
+func (s *AccountUsersService) GetAccountUser(accountID uuid.UUID, id uuid.UUID) (*models.AccountUser, error) {
+	var accountUser models.AccountUser
+
+	err := s.db.Where("account_id = ? AND id = ?", accountID, id).First(&accountUser).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountUser, nil
+}
+
+func (s *AccountUsersService) ListAccountUsers(accountID uuid.UUID, page Page) ([]models.AccountUser, int64, error) {
+	var total int64
+	if err := s.db.Model(&models.AccountUser{}).Where("account_id = ?", accountID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var accountUsers []models.AccountUser
+	err := s.db.Where("account_id = ?", accountID).
+		Order("created_at ASC").
+		Offset(page.Offset()).
+		Limit(page.Size).
+		Find(&accountUsers).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return accountUsers, total, nil
+}
+
+// ChangeRole updates a teammate's role. Only owners may grant or take away the
+// owner role, and the last owner can never be demoted.
+func (s *AccountUsersService) ChangeRole(actor *models.AccountUser, targetID uuid.UUID, role string) (*models.AccountUser, error) {
+	if !isValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	target, err := s.GetAccountUser(actor.AccountID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Role == role {
+		return target, nil
+	}
+
+	if (target.Role == "owner" || role == "owner") && !permissions.Has(actor.Role, permissions.AccountTransferOwnership) {
+		return nil, ErrInsufficientRole
+	}
+
+	if target.Role == "owner" {
+		if err := s.ensureAnotherOwner(actor.AccountID, target.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.Model(target).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// RemoveAccountUser deletes a teammate and their console sessions
+func (s *AccountUsersService) RemoveAccountUser(actor *models.AccountUser, targetID uuid.UUID) error {
+	target, err := s.GetAccountUser(actor.AccountID, targetID)
+	if err != nil {
+		return err
+	}
+
+	if target.Role == "owner" {
+		if !permissions.Has(actor.Role, permissions.AccountTransferOwnership) {
+			return ErrInsufficientRole
+		}
+		if err := s.ensureAnotherOwner(actor.AccountID, target.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := NewConsoleSessionService(s.db).RevokeAllForAccountUser(target.ID); err != nil {
+		return err
+	}
+
+	return s.db.Delete(target).Error
+}
+
+// ensureAnotherOwner locks the account's owner rows so two concurrent requests
+// cannot each remove "the other" owner and leave the account without one
+func (s *AccountUsersService) ensureAnotherOwner(accountID uuid.UUID, excludingID uuid.UUID) error {
+	var owners []models.AccountUser
+	err := s.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("account_id = ? AND role = ?", accountID, "owner").
+		Find(&owners).Error
+	if err != nil {
+		return err
+	}
+
+	for _, owner := range owners {
+		if owner.ID != excludingID {
+			return nil
+		}
+	}
+
+	return ErrLastOwner
+}
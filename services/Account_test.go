@@ -0,0 +1,134 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestVerifyAccount(t *testing.T) {
+	t.Setenv("TOKEN_SIGNING_SECRET", "test-secret")
+
+	accountID := uuid.New()
+	valid, err := NewAccountService(nil).CreateVerificationToken(&models.Account{ID: accountID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(purpose string, subject string, ttl time.Duration) string {
+		token, err := tokens.Sign(purpose, subject, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	accountRows := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "email", "status"}).AddRow(accountID, "ada@example.com", status)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		rows   *sqlmock.Rows
+		update bool
+		want   error
+	}{
+		{name: "pending account is activated", token: valid, rows: accountRows("pending_verification"), update: true},
+		{name: "used link leaves the account as it is", token: valid, rows: accountRows("active")},
+		{name: "suspended account stays suspended", token: valid, rows: accountRows("suspended")},
+		{name: "signup purged in the meantime", token: valid, rows: sqlmock.NewRows([]string{"id"}), want: ErrVerificationInvalid},
+
+		// Rejected before the database is asked
+		{name: "expired", token: sign("verify-account", accountID.String(), -time.Minute), want: ErrVerificationInvalid},
+		{name: "token of another flow", token: sign("invite", accountID.String(), time.Hour), want: ErrVerificationInvalid},
+		{name: "subject is not an account id", token: sign("verify-account", "ada@example.com", time.Hour), want: ErrVerificationInvalid},
+		{name: "signature changed", token: valid[:len(valid)-2] + "xx", want: ErrVerificationInvalid},
+		{name: "empty", token: "", want: ErrVerificationInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			if test.rows != nil {
+				mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+					WithArgs(accountID, 1).
+					WillReturnRows(test.rows)
+			}
+			if test.update {
+				mock.ExpectExec(`UPDATE "accounts" SET "status"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+					WithArgs("active", sqlmock.AnyArg(), accountID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			account, err := NewAccountService(db).VerifyAccount(test.token)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("VerifyAccount = %+v, %v, want %v", account, err, test.want)
+				}
+				return
+			}
+
+			if err != nil || account.ID != accountID {
+				t.Fatalf("VerifyAccount = %+v, %v", account, err)
+			}
+			if test.update && account.Status != "active" {
+				t.Fatalf("status = %s, want active", account.Status)
+			}
+		})
+	}
+}
+
+func TestAccountVerificationWindow(t *testing.T) {
+	tests := map[string]time.Duration{"": DefaultAccountVerificationWindow, "24h": 24 * time.Hour, "-1h": DefaultAccountVerificationWindow, "tomorrow": DefaultAccountVerificationWindow}
+
+	for value, want := range tests {
+		t.Setenv("ACCOUNT_VERIFICATION_WINDOW", value)
+		if got := AccountVerificationWindow(); got != want {
+			t.Errorf("AccountVerificationWindow(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestReactivateAccountTransitions(t *testing.T) {
+	accountID := uuid.New()
+	recently, longAgo := time.Now().Add(-time.Hour), time.Now().Add(-AccountDeletionCoolingOff-time.Hour)
+
+	tests := []struct {
+		status    string
+		deletedAt *time.Time
+		want      error
+	}{
+		{status: "suspended"},
+		{status: "deleted", deletedAt: &recently},
+		{status: "deleted", deletedAt: &longAgo, want: ErrInvalidStatusTransition},
+		{status: "pending_verification", want: ErrAccountNotVerified},
+	}
+
+	for _, test := range tests {
+		db, mock := mockDB(t)
+		mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+			WithArgs(accountID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "deleted_at"}).AddRow(accountID, test.status, test.deletedAt))
+
+		// Only a suspension or a deletion still in its cooling-off period can be undone
+		if test.want == nil {
+			mock.ExpectExec(`UPDATE "accounts" SET "deleted_at"=\$1,"status"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+				WithArgs(nil, "active", sqlmock.AnyArg(), accountID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+				WithArgs(accountID, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(accountID, "active"))
+		}
+
+		account, err := NewAccountService(db).ReactivateAccount(accountID)
+		if !errors.Is(err, test.want) || test.want == nil && account.Status != "active" {
+			t.Errorf("ReactivateAccount from %s = %+v, %v, want %v", test.status, account, err, test.want)
+		}
+	}
+}
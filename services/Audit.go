@@ -0,0 +1,33 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type AuditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record writes an audit entry, metadata is stored as jsonb and may be nil
+func (s *AuditService) Record(entry *models.AuditLog, metadata map[string]any) error {
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		entry.Metadata = data
+	}
+
+	if err := s.db.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RoleInput creates or updates a client role, nil fields are left unchanged on
+// update. Permissions are names of permissions of the same client.
+type RoleInput struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type PermissionInput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AuthorizationService manages what tenants authorize their users to do in their
+// own applications: permissions and roles defined per client, assigned to users
+// directly or through groups, and put into the tokens issued to the client.
+type AuthorizationService struct {
+	db *gorm.DB
+}
+
+func NewAuthorizationService(db *gorm.DB) *AuthorizationService {
+	return &AuthorizationService{db: db}
+}
+
+func (s *AuthorizationService) ListPermissions(tenantID uuid.UUID, clientID uuid.UUID) ([]models.ClientPermission, error) {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return nil, err
+	}
+
+	var permissions []models.ClientPermission
+	err := s.db.Where("client_id = ?", clientID).Order("name").Find(&permissions).Error
+
+	return permissions, err
+}
+
+func (s *AuthorizationService) CreatePermission(tenantID uuid.UUID, clientID uuid.UUID, input PermissionInput) (*models.ClientPermission, error) {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return nil, err
+	}
+
+	permission := &models.ClientPermission{
+		ClientID:    clientID,
+		Name:        strings.TrimSpace(input.Name),
+		Description: input.Description,
+	}
+	if err := validateClaimValue(permission.Name); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrClientPermissionInvalid, err)
+	}
+
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(permission)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrClientPermissionNameTaken
+	}
+	return permission, nil
+}
+
+// DeletePermission removes the permission, it is taken out of every role granting it
+func (s *AuthorizationService) DeletePermission(tenantID uuid.UUID, clientID uuid.UUID, id uuid.UUID) error {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return err
+	}
+
+	result := s.db.Where("id = ? AND client_id = ?", id, clientID).Delete(&models.ClientPermission{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *AuthorizationService) ListRoles(tenantID uuid.UUID, clientID uuid.UUID) ([]models.ClientRole, error) {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return nil, err
+	}
+
+	var roles []models.ClientRole
+	err := s.db.Preload("Permissions", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		Where("client_id = ?", clientID).
+		Order("name").
+		Find(&roles).Error
+
+	return roles, err
+}
+
+func (s *AuthorizationService) GetRole(tenantID uuid.UUID, clientID uuid.UUID, id uuid.UUID) (*models.ClientRole, error) {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return nil, err
+	}
+
+	var role models.ClientRole
+	err := s.db.Preload("Permissions", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		Where("id = ? AND client_id = ?", id, clientID).
+		First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (s *AuthorizationService) CreateRole(tenantID uuid.UUID, clientID uuid.UUID, input RoleInput) (*models.ClientRole, error) {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return nil, err
+	}
+
+	role := &models.ClientRole{ClientID: clientID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		return saveRole(tx, role, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRole(tenantID, clientID, role.ID)
+}
+
+func (s *AuthorizationService) UpdateRole(tenantID uuid.UUID, clientID uuid.UUID, id uuid.UUID, input RoleInput) (*models.ClientRole, error) {
+	role, err := s.GetRole(tenantID, clientID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		return saveRole(tx, role, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRole(tenantID, clientID, id)
+}
+
+// DeleteRole removes the role, users and groups holding it lose it
+func (s *AuthorizationService) DeleteRole(tenantID uuid.UUID, clientID uuid.UUID, id uuid.UUID) error {
+	if err := s.ensureClient(tenantID, clientID); err != nil {
+		return err
+	}
+
+	result := s.db.Where("id = ? AND client_id = ?", id, clientID).Delete(&models.ClientRole{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UserRoles are the roles assigned to the user directly, not those of its groups
+func (s *AuthorizationService) UserRoles(tenantID uuid.UUID, userID uuid.UUID) ([]models.ClientRole, error) {
+	if _, err := NewUsersService(s.db).GetUser(tenantID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.assignedRoles(s.db.Model(&models.UserClientRole{}).Select("role_id").Where("user_id = ?", userID))
+}
+
+// SetUserRoles makes roleIDs the exact roles assigned to the user directly
+func (s *AuthorizationService) SetUserRoles(tenantID uuid.UUID, userID uuid.UUID, roleIDs []uuid.UUID) error {
+	if _, err := NewUsersService(s.db).GetUser(tenantID, userID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureTenantRoles(tx, tenantID, roleIDs); err != nil {
+			return err
+		}
+
+		removed := tx.Where("user_id = ?", userID)
+		if len(roleIDs) > 0 {
+			removed = removed.Where("role_id NOT IN ?", roleIDs)
+		}
+		if err := removed.Delete(&models.UserClientRole{}).Error; err != nil {
+			return err
+		}
+
+		for _, roleID := range roleIDs {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserClientRole{UserID: userID, RoleID: roleID}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *AuthorizationService) GroupRoles(tenantID uuid.UUID, groupID uuid.UUID) ([]models.ClientRole, error) {
+	if _, err := NewGroupService(s.db).GetGroup(tenantID, groupID); err != nil {
+		return nil, err
+	}
+
+	return s.assignedRoles(s.db.Model(&models.GroupClientRole{}).Select("role_id").Where("group_id = ?", groupID))
+}
+
+// SetGroupRoles makes roleIDs the exact roles every member of the group holds through it
+func (s *AuthorizationService) SetGroupRoles(tenantID uuid.UUID, groupID uuid.UUID, roleIDs []uuid.UUID) error {
+	if _, err := NewGroupService(s.db).GetGroup(tenantID, groupID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureTenantRoles(tx, tenantID, roleIDs); err != nil {
+			return err
+		}
+
+		removed := tx.Where("group_id = ?", groupID)
+		if len(roleIDs) > 0 {
+			removed = removed.Where("role_id NOT IN ?", roleIDs)
+		}
+		if err := removed.Delete(&models.GroupClientRole{}).Error; err != nil {
+			return err
+		}
+
+		for _, roleID := range roleIDs {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.GroupClientRole{GroupID: groupID, RoleID: roleID}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Claims resolves everything the user holds for the client: the names of all its
+// groups, its roles of the client whether assigned directly or through a group, and
+// the permissions those roles grant.
+func (s *AuthorizationService) Claims(tenantID uuid.UUID, client *models.Client, userID uuid.UUID) (jwt.AuthorizationClaims, error) {
+	var claims jwt.AuthorizationClaims
+	if client.TenantID != tenantID {
+		return claims, ErrRecordNotFound
+	}
+
+	groupIDs := s.db.Model(&models.GroupMember{}).Select("group_id").Where("user_id = ?", userID)
+	err := s.db.Model(&models.Group{}).
+		Where("tenant_id = ? AND id IN (?)", tenantID, groupIDs).
+		Order("name").
+		Pluck("name", &claims.Groups).Error
+	if err != nil {
+		return claims, err
+	}
+
+	roleIDs := s.db.Model(&models.ClientRole{}).Select("id").
+		Where("client_id = ?", client.ID).
+		Where(s.db.Where("id IN (?)", s.db.Model(&models.UserClientRole{}).Select("role_id").Where("user_id = ?", userID)).
+			Or("id IN (?)", s.db.Model(&models.GroupClientRole{}).Select("role_id").Where("group_id IN (?)", groupIDs)))
+
+	err = s.db.Model(&models.ClientRole{}).Where("id IN (?)", roleIDs).Order("name").Pluck("name", &claims.Roles).Error
+	if err != nil {
+		return claims, err
+	}
+
+	err = s.db.Model(&models.ClientPermission{}).
+		Distinct("name").
+		Where("id IN (?)", s.db.Model(&models.ClientRolePermission{}).Select("permission_id").Where("role_id IN (?)", roleIDs)).
+		Order("name").
+		Pluck("name", &claims.Permissions).Error
+
+	return claims, err
+}
+
+// TokenClaims are the claims the client asked for in its settings, limited to fit a
+// token. What does not fit is left at AuthorizationClaimsEndpoint, where the client
+// fetches the full claims with the access token or the user's session.
+func (s *AuthorizationService) TokenClaims(tenantID uuid.UUID, issuer string, client *models.Client, userID uuid.UUID) (jwt.AuthorizationClaims, error) {
+	if len(client.TokenClaims) == 0 {
+		return jwt.AuthorizationClaims{}, nil
+	}
+
+	claims, err := s.Claims(tenantID, client, userID)
+	if err != nil {
+		return claims, err
+	}
+	return claims.Limit(client.TokenClaims, AuthorizationClaimsEndpoint(issuer, client)), nil
+}
+
+func AuthorizationClaimsEndpoint(issuer string, client *models.Client) string {
+	return issuer + "/users/me/authorization?client_id=" + url.QueryEscape(client.ClientID)
+}
+
+func (s *AuthorizationService) ensureClient(tenantID uuid.UUID, clientID uuid.UUID) error {
+	_, err := NewClientService(s.db).GetClient(tenantID, clientID)
+	return err
+}
+
+func (s *AuthorizationService) assignedRoles(roleIDs *gorm.DB) ([]models.ClientRole, error) {
+	var roles []models.ClientRole
+	err := s.db.Preload("Permissions", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		Where("id IN (?)", roleIDs).
+		Order("client_id, name").
+		Find(&roles).Error
+
+	return roles, err
+}
+
+func saveRole(tx *gorm.DB, role *models.ClientRole, input RoleInput) error {
+	if input.Name != nil {
+		role.Name = strings.TrimSpace(*input.Name)
+	}
+	if err := validateClaimValue(role.Name); err != nil {
+		return fmt.Errorf("%w: %v", ErrClientRoleInvalid, err)
+	}
+
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+
+	var taken int64
+	err := tx.Model(&models.ClientRole{}).
+		Where("client_id = ? AND name = ? AND id <> ?", role.ClientID, role.Name, role.ID).
+		Count(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrClientRoleNameTaken
+	}
+
+	// The join rows are written below, not by Save
+	if err := tx.Omit("Permissions").Save(role).Error; err != nil {
+		return err
+	}
+
+	if input.Permissions == nil {
+		return nil
+	}
+
+	var permissions []models.ClientPermission
+	if len(input.Permissions) > 0 {
+		err := tx.Where("client_id = ? AND name IN ?", role.ClientID, input.Permissions).Find(&permissions).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	granted := map[string]bool{}
+	for _, permission := range permissions {
+		granted[permission.Name] = true
+	}
+	for _, name := range input.Permissions {
+		if !granted[name] {
+			return fmt.Errorf("%w: unknown permission %q", ErrClientRoleInvalid, name)
+		}
+	}
+
+	if err := tx.Where("role_id = ?", role.ID).Delete(&models.ClientRolePermission{}).Error; err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		err := tx.Create(&models.ClientRolePermission{RoleID: role.ID, PermissionID: permission.ID}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureTenantRoles fails unless every role belongs to a client of the tenant
+func ensureTenantRoles(tx *gorm.DB, tenantID uuid.UUID, roleIDs []uuid.UUID) error {
+	if len(roleIDs) == 0 {
+		return nil
+	}
+
+	unique := map[uuid.UUID]bool{}
+	for _, roleID := range roleIDs {
+		unique[roleID] = true
+	}
+
+	var found int64
+	err := tx.Model(&models.ClientRole{}).
+		Where("id IN ? AND client_id IN (?)", roleIDs, tx.Model(&models.Client{}).Select("id").Where("tenant_id = ?", tenantID)).
+		Count(&found).Error
+	if err != nil {
+		return err
+	}
+
+	if found != int64(len(unique)) {
+		return fmt.Errorf("%w: roles must belong to clients of this tenant", ErrClientRoleInvalid)
+	}
+	return nil
+}
+
+// validateClaimValue keeps role and permission names usable as token claim values
+func validateClaimValue(name string) error {
+	if name == "" || len(name) > 255 {
+		return errors.New("name is required and at most 255 characters")
+	}
+	if strings.ContainsAny(name, " \t\r\n\"\\") {
+		return errors.New("name cannot contain spaces, quotes or backslashes")
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSecretRotationGrace = 24 * time.Hour
+	MaxSecretRotationGrace     = 30 * 24 * time.Hour
+)
+
+var (
+	SupportedResponseTypes = []string{"code"}
+	DefaultClientScopes    = []string{"openid", "profile", "email"}
+)
+
+// ClientInput is the create/update payload, nil fields are left unchanged on update
+type ClientInput struct {
+	Name           *string  `json:"name"`
+	Description    *string  `json:"description"`
+	RedirectURIs   []string `json:"redirect_uris"`
+	GrantTypes     []string `json:"grant_types"`
+	ResponseTypes  []string `json:"response_types"`
+	Scopes         []string `json:"scopes"`
+	IsConfidential *bool    `json:"is_confidential"`
+	TokenClaims    []string `json:"token_claims"`
+}
+
+type ClientService struct {
+	db *gorm.DB
+}
+
+func NewClientService(db *gorm.DB) *ClientService {
+	return &ClientService{db: db}
+}
+
+// CreateClient registers a client under the tenant. For confidential clients the
+// returned secret is the only time it is ever visible, only its hash is stored.
+func (s *ClientService) CreateClient(tenant *models.Tenant, input ClientInput) (*models.Client, string, error) {
+	client := &models.Client{
+		TenantID:       tenant.ID,
+		IsConfidential: true,
+		Status:         "active",
+	}
+
+	if input.GrantTypes == nil {
+		input.GrantTypes = []string{"authorization_code", "refresh_token"}
+	}
+	if input.ResponseTypes == nil {
+		input.ResponseTypes = []string{"code"}
+	}
+	if input.Scopes == nil {
+		input.Scopes = DefaultClientScopes
+	}
+
+	if err := s.applyInput(tenant, client, input); err != nil {
+		return nil, "", err
+	}
+
+	clientID, err := tokens.Generate(18)
+	if err != nil {
+		return nil, "", err
+	}
+	client.ClientID = clientID
+
+	var secret string
+	if client.IsConfidential {
+		secret, err = generateClientSecret()
+		if err != nil {
+			return nil, "", err
+		}
+		client.ClientSecretHash = tokens.Hash(secret)
+	}
+
+	if err := s.db.Create(client).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create client: %w", err)
+	}
+
+	// is_confidential defaults to true in the database so a false value is skipped on insert
+	if !client.IsConfidential {
+		if err := s.db.Model(client).Update("is_confidential", false).Error; err != nil {
+			return nil, "", err
+		}
+	}
+
+	return client, secret, nil
+}
+
+func (s *ClientService) ListClients(tenantID uuid.UUID) ([]models.Client, error) {
+	var clients []models.Client
+
+	err := s.db.Where("tenant_id = ?", tenantID).Order("created_at ASC").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// GetClient looks up a client by its row id, scoped to the tenant
+func (s *ClientService) GetClient(tenantID uuid.UUID, id uuid.UUID) (*models.Client, error) {
+	var client models.Client
+
+	err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&client).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// GetClientByClientID looks up a client by its public client_id, scoped to the tenant
+func (s *ClientService) GetClientByClientID(tenantID uuid.UUID, clientID string) (*models.Client, error) {
+	var client models.Client
+
+	err := s.db.Where("tenant_id = ? AND client_id = ?", tenantID, clientID).First(&client).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (s *ClientService) UpdateClient(tenant *models.Tenant, id uuid.UUID, input ClientInput) (*models.Client, error) {
+	client, err := s.GetClient(tenant.ID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Switching between public and confidential changes how the client authenticates,
+	// it has to be recreated instead
+	if input.IsConfidential != nil && *input.IsConfidential != client.IsConfidential {
+		return nil, ErrClientTypeImmutable
+	}
+
+	if err := s.applyInput(tenant, client, input); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(client).Error; err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// DeleteClient removes the client, its codes and tokens go with it via OnDelete:CASCADE
+func (s *ClientService) DeleteClient(tenantID uuid.UUID, id uuid.UUID) error {
+	client, err := s.GetClient(tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Delete(client).Error
+}
+
+// RotateSecret issues a new secret, the current one stays valid for grace so
+// deployments can roll over without downtime
+func (s *ClientService) RotateSecret(tenantID uuid.UUID, id uuid.UUID, grace time.Duration) (*models.Client, string, error) {
+	client, err := s.GetClient(tenantID, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !client.IsConfidential {
+		return nil, "", ErrPublicClientSecret
+	}
+
+	if grace < 0 || grace > MaxSecretRotationGrace {
+		return nil, "", fmt.Errorf("grace period must be between 0 and %s", MaxSecretRotationGrace)
+	}
+
+	secret, err := generateClientSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	updates := map[string]any{
+		"client_secret_hash":         tokens.Hash(secret),
+		"previous_secret_hash":       "",
+		"previous_secret_expires_at": nil,
+	}
+	if grace > 0 {
+		updates["previous_secret_hash"] = client.ClientSecretHash
+		updates["previous_secret_expires_at"] = time.Now().Add(grace)
+	}
+
+	if err := s.db.Model(client).Updates(updates).Error; err != nil {
+		return nil, "", err
+	}
+
+	client, err = s.GetClient(tenantID, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+// VerifySecret accepts the current secret or, during a rotation grace period, the previous one
+func (s *ClientService) VerifySecret(client *models.Client, secret string) bool {
+	if !client.IsConfidential || secret == "" {
+		return false
+	}
+
+	hash := tokens.Hash(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(client.ClientSecretHash)) == 1 {
+		return true
+	}
+
+	return client.PreviousSecretHash != "" &&
+		client.PreviousSecretExpiresAt != nil &&
+		time.Now().Before(*client.PreviousSecretExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(client.PreviousSecretHash)) == 1
+}
+
+func (s *ClientService) applyInput(tenant *models.Tenant, client *models.Client, input ClientInput) error {
+	if input.Name != nil {
+		client.Name = strings.TrimSpace(*input.Name)
+	}
+	if client.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidClient)
+	}
+
+	if input.Description != nil {
+		client.Description = *input.Description
+	}
+
+	if input.IsConfidential != nil {
+		client.IsConfidential = *input.IsConfidential
+	}
+
+	if input.RedirectURIs != nil {
+		for _, redirectURI := range input.RedirectURIs {
+			if err := ValidateRedirectURI(redirectURI); err != nil {
+				return err
+			}
+		}
+		client.RedirectURIs = models.StringArray(input.RedirectURIs)
+	}
+
+	if input.GrantTypes != nil {
+		for _, grantType := range input.GrantTypes {
+			if !slices.Contains(models.SupportedGrantTypes, grantType) {
+				return fmt.Errorf("%w: unsupported grant type %q", ErrInvalidClient, grantType)
+			}
+			if !slices.Contains(tenant.Settings.AllowedGrantTypes, grantType) {
+				return fmt.Errorf("%w: grant type %q is not allowed for this tenant", ErrInvalidClient, grantType)
+			}
+		}
+		client.GrantTypes = models.StringArray(input.GrantTypes)
+	}
+
+	if input.ResponseTypes != nil {
+		for _, responseType := range input.ResponseTypes {
+			if !slices.Contains(SupportedResponseTypes, responseType) {
+				return fmt.Errorf("%w: unsupported response type %q", ErrInvalidClient, responseType)
+			}
+		}
+		client.ResponseTypes = models.StringArray(input.ResponseTypes)
+	}
+
+	if input.Scopes != nil {
+		for _, scope := range input.Scopes {
+			if scope == "" || strings.ContainsAny(scope, " \t\n\"\\") {
+				return fmt.Errorf("%w: invalid scope %q", ErrInvalidClient, scope)
+			}
+		}
+		client.Scopes = models.StringArray(input.Scopes)
+	}
+
+	if input.TokenClaims != nil {
+		for _, claim := range input.TokenClaims {
+			if !slices.Contains(jwt.AuthorizationClaimNames, claim) {
+				return fmt.Errorf("%w: unsupported token claim %q", ErrInvalidClient, claim)
+			}
+		}
+		client.TokenClaims = models.StringArray(input.TokenClaims)
+	}
+
+	if len(client.RedirectURIs) == 0 && client.AllowsGrantType("authorization_code") {
+		return fmt.Errorf("%w: at least one redirect URI is required", ErrInvalidClient)
+	}
+
+	return nil
+}
+
+// ValidateRedirectURI requires an absolute URI without a fragment, served over
+// https unless it points at the local machine (native and dev apps)
+func ValidateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%w: redirect URI %q must be absolute", ErrInvalidClient, redirectURI)
+	}
+
+	if u.Fragment != "" || strings.Contains(redirectURI, "#") {
+		return fmt.Errorf("%w: redirect URI %q must not contain a fragment", ErrInvalidClient, redirectURI)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if isLoopbackHost(u.Hostname()) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: redirect URI %q must use https (http is only allowed for localhost)", ErrInvalidClient, redirectURI)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func generateClientSecret() (string, error) {
+	secret, err := tokens.Generate(32)
+	if err != nil {
+		return "", err
+	}
+	return "dps_" + secret, nil
+}
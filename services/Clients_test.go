@@ -0,0 +1,167 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		redirectURI string
+		valid       bool
+	}{
+		{redirectURI: "https://app.example.com/callback", valid: true},
+		{redirectURI: "https://app.example.com/callback?tenant=acme", valid: true},
+		{redirectURI: "http://localhost:3000/callback", valid: true},
+		{redirectURI: "http://127.0.0.1/callback", valid: true},
+		{redirectURI: "http://[::1]:8080/callback", valid: true},
+		{redirectURI: "http://app.example.com/callback"},
+		{redirectURI: "http://localhost.example.com/callback"},
+		{redirectURI: "https://app.example.com/callback#done"},
+		{redirectURI: "https://app.example.com/callback#"},
+		{redirectURI: "/callback"},
+		{redirectURI: "https:///callback"},
+		{redirectURI: "javascript:alert(1)"},
+		{redirectURI: "com.example.app:/callback"},
+		{redirectURI: ""},
+	}
+
+	for _, test := range tests {
+		err := ValidateRedirectURI(test.redirectURI)
+		if test.valid && err != nil || !test.valid && !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("ValidateRedirectURI(%s) = %v, want valid %v", test.redirectURI, err, test.valid)
+		}
+	}
+}
+
+func TestApplyClientInputGrantTypes(t *testing.T) {
+	name := "App"
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	tenant.Settings.AllowedGrantTypes = []string{"authorization_code"}
+
+	tests := []struct {
+		grantTypes   []string
+		redirectURIs []string
+		valid        bool
+	}{
+		{grantTypes: []string{"authorization_code"}, redirectURIs: []string{"https://app.example.com/callback"}, valid: true},
+		// Supported, but the tenant has not allowed it
+		{grantTypes: []string{"authorization_code", "refresh_token"}, redirectURIs: []string{"https://app.example.com/callback"}},
+		// Grants the token endpoint does not implement
+		{grantTypes: []string{"client_credentials"}},
+		{grantTypes: []string{"password"}},
+		{grantTypes: []string{"implicit"}},
+		{grantTypes: []string{"urn:ietf:params:oauth:grant-type:device_code"}},
+		// The authorization code flow needs somewhere to send the code
+		{grantTypes: []string{"authorization_code"}},
+	}
+
+	for _, test := range tests {
+		client := &models.Client{IsConfidential: true}
+		err := NewClientService(nil).applyInput(tenant, client, ClientInput{Name: &name, GrantTypes: test.grantTypes, RedirectURIs: test.redirectURIs})
+		if test.valid && err != nil || !test.valid && !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("applyInput(%v) = %v, want valid %v", test.grantTypes, err, test.valid)
+		}
+	}
+}
+
+func TestVerifySecretDuringRotationGrace(t *testing.T) {
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name   string
+		client models.Client
+		secret string
+		want   bool
+	}{
+		{name: "current", client: models.Client{IsConfidential: true, ClientSecretHash: tokens.Hash("new")}, secret: "new", want: true},
+		{name: "wrong", client: models.Client{IsConfidential: true, ClientSecretHash: tokens.Hash("new")}, secret: "old"},
+		{name: "empty", client: models.Client{IsConfidential: true, ClientSecretHash: tokens.Hash("")}, secret: ""},
+		{name: "public", client: models.Client{ClientSecretHash: tokens.Hash("new")}, secret: "new"},
+		{
+			name:   "previous in grace",
+			client: models.Client{IsConfidential: true, ClientSecretHash: tokens.Hash("new"), PreviousSecretHash: tokens.Hash("old"), PreviousSecretExpiresAt: &future},
+			secret: "old",
+			want:   true,
+		},
+		{
+			name:   "previous after grace",
+			client: models.Client{IsConfidential: true, ClientSecretHash: tokens.Hash("new"), PreviousSecretHash: tokens.Hash("old"), PreviousSecretExpiresAt: &past},
+			secret: "old",
+		},
+		{
+			name:   "previous without expiry",
+			client: models.Client{IsConfidential: true, ClientSecretHash: tokens.Hash("new"), PreviousSecretHash: tokens.Hash("old")},
+			secret: "old",
+		},
+	}
+
+	for _, test := range tests {
+		if got := NewClientService(nil).VerifySecret(&test.client, test.secret); got != test.want {
+			t.Errorf("%s: VerifySecret = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRotateSecret(t *testing.T) {
+	tenantID, id := uuid.New(), uuid.New()
+
+	expectClient := func(mock sqlmock.Sqlmock, secretHash string) {
+		mock.ExpectQuery(`SELECT \* FROM "clients" WHERE tenant_id = \$1 AND id = \$2`).
+			WithArgs(tenantID, id, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "client_secret_hash", "is_confidential"}).
+				AddRow(id, tenantID, secretHash, true))
+	}
+
+	t.Run("grace", func(t *testing.T) {
+		db, mock := mockDB(t)
+		previousExpiry := &captured{}
+
+		// The old hash moves aside with an expiry, the new one takes its place
+		expectClient(mock, tokens.Hash("old"))
+		newHash := &captured{}
+		mock.ExpectExec(`UPDATE "clients" SET "client_secret_hash"=\$1,"previous_secret_expires_at"=\$2,"previous_secret_hash"=\$3,"updated_at"=\$4 WHERE "id" = \$5`).
+			WithArgs(newHash, previousExpiry, tokens.Hash("old"), sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectClient(mock, "")
+
+		before := time.Now()
+		_, secret, err := NewClientService(db).RotateSecret(tenantID, id, time.Hour)
+		if err != nil || secret == "" || newHash.value != tokens.Hash(secret) {
+			t.Fatalf("RotateSecret = %s, %v", secret, err)
+		}
+		if expiresAt, ok := previousExpiry.value.(time.Time); !ok || expiresAt.Before(before.Add(time.Hour)) || expiresAt.After(time.Now().Add(time.Hour)) {
+			t.Fatalf("previous secret expires at %v, want an hour from now", previousExpiry.value)
+		}
+	})
+
+	t.Run("no grace", func(t *testing.T) {
+		db, mock := mockDB(t)
+
+		// Without a grace period the old secret stops working at once
+		expectClient(mock, tokens.Hash("old"))
+		mock.ExpectExec(`UPDATE "clients" SET "client_secret_hash"=\$1,"previous_secret_expires_at"=\$2,"previous_secret_hash"=\$3,"updated_at"=\$4 WHERE "id" = \$5`).
+			WithArgs(sqlmock.AnyArg(), nil, "", sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectClient(mock, "")
+
+		if _, _, err := NewClientService(db).RotateSecret(tenantID, id, 0); err != nil {
+			t.Fatalf("RotateSecret = %v", err)
+		}
+	})
+
+	t.Run("grace too long", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectClient(mock, tokens.Hash("old"))
+
+		if _, _, err := NewClientService(db).RotateSecret(tenantID, id, MaxSecretRotationGrace+time.Second); err == nil {
+			t.Fatalf("RotateSecret accepted a grace period over the maximum")
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/password"
+	"DigiPassAuthenticationApi/packages/permissions"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ConsoleSessionLifetime = 12 * time.Hour
+	ConsoleSessionIdle     = 2 * time.Hour
+)
+
+// Used to keep response times similar when the email does not exist
+var dummyPasswordHash, _ = password.Hash("digipass-dummy-password")
+
+// ConsoleUnlockLifetime is how long the console unlock link stays valid
+const ConsoleUnlockLifetime = time.Hour
+
+type ConsoleSessionService struct {
+	db    *gorm.DB
+	store throttle.Store
+}
+
+// NewConsoleSessionService optionally takes the login throttle store, Postgres by default
+func NewConsoleSessionService(db *gorm.DB, store ...throttle.Store) *ConsoleSessionService {
+	return &ConsoleSessionService{db: db, store: throttleStore(db, store)}
+}
+
+// Login verifies the AccountUser credentials and starts a console session.
+// accountID is only required when the email belongs to more than one account.
+// The returned token is the cookie value and is never stored in plain text.
+func (s *ConsoleSessionService) Login(email string, plainPassword string, accountID *uuid.UUID, userAgent string, ipAddress string) (*models.ConsoleSession, string, error) {
+	user, account, err := s.checkCredentials(email, plainPassword, accountID, userAgent, ipAddress)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch account.Status {
+	case "active":
+	case "pending_verification":
+		return nil, "", ErrAccountNotVerified
+	case "suspended":
+		return nil, "", ErrAccountSuspended
+	case "deleted":
+		return nil, "", ErrAccountDeleted
+	default:
+		return nil, "", ErrInvalidCredentials
+	}
+
+	enabled, err := NewMFAService(s.db, s.store).HasTOTP(AccountUserMFAOwner(user.ID))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if enabled {
+		return nil, "", newMFAChallenge(mfaChallengeConsole, user.ID.String())
+	}
+
+	return s.createSession(user.ID, userAgent, ipAddress)
+}
+
+// ReactivateAccount lifts a suspension or cancels a pending deletion for an AccountUser
+// allowed to update the account. Such accounts cannot sign in, so the credentials and
+// the authenticator code, when the user has one, are checked here instead.
+func (s *ConsoleSessionService) ReactivateAccount(email string, plainPassword string, code string, accountID *uuid.UUID, userAgent string, ipAddress string) (*models.Account, error) {
+	user, account, err := s.checkCredentials(email, plainPassword, accountID, userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Status == "pending_verification" {
+		return nil, ErrAccountNotVerified
+	}
+
+	if !permissions.Has(user.Role, permissions.AccountUpdate) {
+		return nil, ErrInsufficientRole
+	}
+
+	mfaService := NewMFAService(s.db, s.store)
+	enabled, err := mfaService.HasTOTP(AccountUserMFAOwner(user.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		if err := mfaService.Verify(AccountUserMFAOwner(user.ID), code, models.DefaultLockoutSettings()); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewAccountService(s.db).ReactivateAccount(account.ID)
+}
+
+// checkCredentials finds the AccountUser the password belongs to and their account,
+// whatever its status. Failures count towards the console lockout.
+func (s *ConsoleSessionService) checkCredentials(email string, plainPassword string, accountID *uuid.UUID, userAgent string, ipAddress string) (*models.AccountUser, *models.Account, error) {
+	if email == "" || plainPassword == "" {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	guard := NewLoginGuard(s.db, s.store)
+	attempt := consoleLoginAttempt(email, userAgent, ipAddress)
+
+	now := time.Now()
+	if err := guard.Check(attempt, now); err != nil {
+		return nil, nil, err
+	}
+
+	query := s.db.Where("LOWER(email) = ?", strings.ToLower(email))
+	if accountID != nil {
+		query = query.Where("account_id = ?", *accountID)
+	}
+
+	var users []models.AccountUser
+	if err := query.Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if len(users) == 0 {
+		password.Verify(plainPassword, dummyPasswordHash)
+		if lockErr := guard.Failed(attempt, nil, nil, now); lockErr != nil {
+			return nil, nil, lockErr
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	// Every candidate is checked before anything is said about how many accounts use
+	// the email, only someone who knows a password learns that a choice is needed
+	aus := NewAccountUsersService(s.db)
+	var matched []models.AccountUser
+	for i := range users {
+		err := aus.VerifyPassword(&users[i], plainPassword)
+		if err == nil {
+			matched = append(matched, users[i])
+			continue
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, nil, err
+		}
+	}
+
+	if len(matched) == 0 {
+		var failedUserID *uuid.UUID
+		if len(users) == 1 {
+			failedUserID = &users[0].ID
+		}
+		if lockErr := guard.Failed(attempt, nil, failedUserID, now); lockErr != nil {
+			return nil, nil, lockErr
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	if len(matched) > 1 {
+		return nil, nil, ErrAccountSelectionRequired
+	}
+
+	user := matched[0]
+	if err := guard.Succeeded(attempt); err != nil {
+		return nil, nil, err
+	}
+
+	account, err := NewAccountService(s.db).GetAccount(user.AccountID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &user, account, nil
+}
+
+// VerifyMFA finishes a console login that returned an MFARequiredError
+func (s *ConsoleSessionService) VerifyMFA(challenge string, code string, userAgent string, ipAddress string) (*models.ConsoleSession, string, error) {
+	fields, err := verifyMFAChallenge(mfaChallengeConsole, challenge)
+	if err != nil || len(fields) != 1 {
+		return nil, "", ErrMFAChallengeInvalid
+	}
+
+	accountUserID, err := uuid.Parse(fields[0])
+	if err != nil {
+		return nil, "", ErrMFAChallengeInvalid
+	}
+
+	if err := NewMFAService(s.db, s.store).Verify(AccountUserMFAOwner(accountUserID), code, models.DefaultLockoutSettings()); err != nil {
+		return nil, "", err
+	}
+
+	return s.createSession(accountUserID, userAgent, ipAddress)
+}
+
+func (s *ConsoleSessionService) createSession(accountUserID uuid.UUID, userAgent string, ipAddress string) (*models.ConsoleSession, string, error) {
+	token, err := tokens.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	csrfToken, err := tokens.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &models.ConsoleSession{
+		AccountUserID:  accountUserID,
+		TokenHash:      tokens.Hash(token),
+		CSRFToken:      csrfToken,
+		UserAgent:      userAgent,
+		IPAddress:      ipAddress,
+		LastActivityAt: now,
+		ExpiresAt:      now.Add(ConsoleSessionLifetime),
+	}
+
+	if err := s.db.Create(session).Error; err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// Authenticate resolves a cookie token to an active session and its AccountUser
+func (s *ConsoleSessionService) Authenticate(token string) (*models.ConsoleSession, error) {
+	if token == "" {
+		return nil, ErrSessionInvalid
+	}
+
+	var session models.ConsoleSession
+	err := s.db.Preload("AccountUser").
+		Where("token_hash = ? AND revoked_at IS NULL", tokens.Hash(token)).
+		First(&session).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastActivityAt) > ConsoleSessionIdle {
+		return nil, ErrSessionInvalid
+	}
+
+	// Avoid a write on every request, a minute of precision is plenty for idle tracking
+	if now.Sub(session.LastActivityAt) > time.Minute {
+		session.LastActivityAt = now
+		if err := s.db.Model(&session).Update("last_activity_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+func (s *ConsoleSessionService) Revoke(sessionID uuid.UUID) error {
+	return s.db.Model(&models.ConsoleSession{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// CreateUnlockToken signs the email of a locked console login for the unlock link
+func (s *ConsoleSessionService) CreateUnlockToken(email string) (string, error) {
+	return tokens.Sign("unlock-console", strings.ToLower(email), ConsoleUnlockLifetime)
+}
+
+// Unlock lifts a console lockout from the emailed link
+func (s *ConsoleSessionService) Unlock(token string, userAgent string, ipAddress string) error {
+	email, err := tokens.VerifySigned("unlock-console", token)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+
+	var accountUserID *uuid.UUID
+	var user models.AccountUser
+	if err := s.db.Where("LOWER(email) = ?", email).First(&user).Error; err == nil {
+		accountUserID = &user.ID
+	}
+
+	return NewLoginGuard(s.db, s.store).Unlock(consoleLoginAttempt(email, userAgent, ipAddress), nil, accountUserID)
+}
+
+// Reauthenticate confirms a sensitive change by the signed in AccountUser with their
+// password, failures count towards the same lockout as console logins
+func (s *ConsoleSessionService) Reauthenticate(user *models.AccountUser, plainPassword string, userAgent string, ipAddress string) error {
+	guard := NewLoginGuard(s.db, s.store)
+	attempt := consoleLoginAttempt(user.Email, userAgent, ipAddress)
+
+	now := time.Now()
+	if err := guard.Check(attempt, now); err != nil {
+		return err
+	}
+
+	err := ErrInvalidCredentials
+	if plainPassword != "" {
+		err = NewAccountUsersService(s.db).VerifyPassword(user, plainPassword)
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		if lockErr := guard.Failed(attempt, nil, &user.ID, now); lockErr != nil {
+			return lockErr
+		}
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	return guard.Succeeded(attempt)
+}
+
+func consoleLoginAttempt(email string, userAgent string, ipAddress string) LoginAttempt {
+	return LoginAttempt{
+		Email:     email,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Settings:  models.DefaultLockoutSettings(),
+	}
+}
+
+// RevokeAllForAccountUser ends every console session, used when access is removed
+func (s *ConsoleSessionService) RevokeAllForAccountUser(accountUserID uuid.UUID) error {
+	return s.db.Model(&models.ConsoleSession{}).
+		Where("account_user_id = ? AND revoked_at IS NULL", accountUserID).
+		Update("revoked_at", time.Now()).Error
+}
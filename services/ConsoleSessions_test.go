@@ -0,0 +1,101 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/password"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestConsoleLoginOnlyForActiveAccounts(t *testing.T) {
+	// Hashed with the current parameters so a successful check does not rehash
+	hash, err := password.Hash("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountID, userID := uuid.New(), uuid.New()
+
+	tests := []struct {
+		status string
+		want   error
+	}{
+		{status: "pending_verification", want: ErrAccountNotVerified},
+		{status: "suspended", want: ErrAccountSuspended},
+		{status: "deleted", want: ErrAccountDeleted},
+		{status: "unknown", want: ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.status, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			mock.ExpectQuery(`SELECT \* FROM "account_users" WHERE LOWER\(email\) = \$1`).
+				WithArgs("owner@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "email", "password_hash", "role"}).
+					AddRow(userID, accountID, "owner@example.com", hash, "owner"))
+			mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+				WithArgs(accountID, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(accountID, test.status))
+
+			// The password is right, the account still gets no session
+			session, _, err := NewConsoleSessionService(db, throttle.NewMemoryStore()).Login("owner@example.com", "correct horse battery", nil, "", "203.0.113.7")
+			if !errors.Is(err, test.want) {
+				t.Fatalf("Login = %+v, %v, want %v", session, err, test.want)
+			}
+		})
+	}
+}
+
+func TestConsoleReactivateAccount(t *testing.T) {
+	hash, err := password.Hash("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountID, userID := uuid.New(), uuid.New()
+
+	expectCredentials := func(mock sqlmock.Sqlmock, role string) {
+		mock.ExpectQuery(`SELECT \* FROM "account_users" WHERE LOWER\(email\) = \$1`).
+			WithArgs("owner@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "email", "password_hash", "role"}).
+				AddRow(userID, accountID, "owner@example.com", hash, role))
+		mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+			WithArgs(accountID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(accountID, "suspended"))
+	}
+
+	t.Run("member", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectCredentials(mock, "member")
+
+		if _, err := NewConsoleSessionService(db, throttle.NewMemoryStore()).ReactivateAccount("owner@example.com", "correct horse battery", "", nil, "", "203.0.113.7"); !errors.Is(err, ErrInsufficientRole) {
+			t.Fatalf("ReactivateAccount = %v, want ErrInsufficientRole", err)
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectCredentials(mock, "owner")
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "totp_credentials" WHERE account_user_id = \$1 AND confirmed_at IS NOT NULL`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+			WithArgs(accountID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(accountID, "suspended"))
+		mock.ExpectExec(`UPDATE "accounts" SET "deleted_at"=\$1,"status"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+			WithArgs(nil, "active", sqlmock.AnyArg(), accountID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT \* FROM "accounts" WHERE id = \$1`).
+			WithArgs(accountID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(accountID, "active"))
+
+		account, err := NewConsoleSessionService(db, throttle.NewMemoryStore()).ReactivateAccount("owner@example.com", "correct horse battery", "", nil, "", "203.0.113.7")
+		if err != nil || account.Status != "active" {
+			t.Fatalf("ReactivateAccount = %+v, %v", account, err)
+		}
+	})
+}
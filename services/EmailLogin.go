@@ -0,0 +1,263 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	EmailLoginLifetime    = 10 * time.Minute
+	EmailLoginMaxAttempts = 5
+
+	// At most emailLoginSendLimit emails per address within emailLoginSendWindow
+	emailLoginSendLimit  = 5
+	emailLoginSendWindow = 15 * time.Minute
+)
+
+// EmailLoginAllowed reports whether the tenant enabled sign-in by email ("email" in mfa.methods)
+func EmailLoginAllowed(tenant *models.Tenant) bool {
+	return slices.Contains(tenant.Settings.MFA.Methods, "email")
+}
+
+// EmailLoginRequest is what has to be emailed, the secrets are never stored in plain text
+type EmailLoginRequest struct {
+	Email     string
+	LinkToken string
+	Code      string
+	ExpiresAt time.Time
+}
+
+// EmailLoginService signs users in with a magic link or a 6 digit code sent by
+// email. Both only work in the browser that asked for them, identified by a
+// cookie token the handler keeps.
+type EmailLoginService struct {
+	db    *gorm.DB
+	store throttle.Store
+}
+
+// NewEmailLoginService optionally takes the login throttle store, Postgres by default
+func NewEmailLoginService(db *gorm.DB, store ...throttle.Store) *EmailLoginService {
+	return &EmailLoginService{db: db, store: throttleStore(db, store)}
+}
+
+// Start creates a challenge for email, replacing the pending ones of the same browser.
+// returnTo must already be validated, it is where the link lands after sign-in.
+func (s *EmailLoginService) Start(tenant *models.Tenant, email string, clientID *uuid.UUID, returnTo string, browserToken string) (*EmailLoginRequest, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if browserToken == "" {
+		return nil, ErrEmailLoginInvalid
+	}
+
+	// Counted for unknown emails too, it protects inboxes rather than accounts
+	now := time.Now()
+	counter, err := s.store.RecordFailure("email-login:"+tenant.ID.String()+":"+email, emailLoginSendWindow, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if counter.Failures > emailLoginSendLimit {
+		return nil, &ThrottledError{RetryAfter: emailLoginSendWindow}
+	}
+
+	linkToken, err := tokens.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := generateEmailCode()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &models.EmailLoginChallenge{
+		ID:               uuid.New(),
+		TenantID:         tenant.ID,
+		Email:            email,
+		LinkTokenHash:    tokens.Hash(linkToken),
+		BrowserTokenHash: tokens.Hash(browserToken),
+		ClientID:         clientID,
+		ReturnTo:         returnTo,
+		ExpiresAt:        now.Add(EmailLoginLifetime),
+	}
+	challenge.CodeHash = hashEmailCode(challenge.ID, code)
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.EmailLoginChallenge{}).
+			Where("tenant_id = ? AND browser_token_hash = ? AND used_at IS NULL", tenant.ID, challenge.BrowserTokenHash).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(challenge).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailLoginRequest{Email: email, LinkToken: linkToken, Code: code, ExpiresAt: challenge.ExpiresAt}, nil
+}
+
+// VerifyLink signs in with the emailed link, opened in the browser that asked for it
+func (s *EmailLoginService) VerifyLink(tenant *models.Tenant, linkToken string, browserToken string) (*models.User, *models.EmailLoginChallenge, error) {
+	if linkToken == "" {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	var challenge models.EmailLoginChallenge
+	err := s.db.Where("link_token_hash = ? AND tenant_id = ?", tokens.Hash(linkToken), tenant.ID).First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if challenge.UsedAt != nil || !time.Now().Before(challenge.ExpiresAt) {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(challenge.BrowserTokenHash), []byte(tokens.Hash(browserToken))) != 1 {
+		return nil, nil, ErrEmailLoginOtherBrowser
+	}
+
+	user, err := s.complete(tenant, &challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, &challenge, nil
+}
+
+// VerifyCode signs in with the emailed code typed into the browser that asked for it
+func (s *EmailLoginService) VerifyCode(tenant *models.Tenant, code string, browserToken string) (*models.User, *models.EmailLoginChallenge, error) {
+	if browserToken == "" {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	var challenge models.EmailLoginChallenge
+	err := s.db.
+		Where("tenant_id = ? AND browser_token_hash = ? AND used_at IS NULL AND expires_at > ?", tenant.ID, tokens.Hash(browserToken), time.Now()).
+		Order("created_at DESC").
+		First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Count the attempt before comparing, six digits must not be guessable
+	result := s.db.Model(&models.EmailLoginChallenge{}).
+		Where("id = ? AND attempts < ? AND used_at IS NULL", challenge.ID, EmailLoginMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(challenge.CodeHash), []byte(hashEmailCode(challenge.ID, code))) != 1 {
+		return nil, nil, ErrEmailLoginInvalid
+	}
+
+	user, err := s.complete(tenant, &challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, &challenge, nil
+}
+
+// complete uses up the challenge and returns its user, creating a passwordless
+// user on first sign-in. Either way the email address is now proven.
+func (s *EmailLoginService) complete(tenant *models.Tenant, challenge *models.EmailLoginChallenge) (*models.User, error) {
+	result := s.db.Model(&models.EmailLoginChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrEmailLoginInvalid
+	}
+
+	usersService := NewUsersService(s.db, s.store)
+	user, err := usersService.GetUserByEmail(tenant.ID, challenge.Email)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		user = &models.User{
+			TenantID:      tenant.ID,
+			Email:         challenge.Email,
+			EmailVerified: true,
+			Status:        "active",
+		}
+
+		if err := s.db.Create(user).Error; err != nil {
+			// Lost a race against a concurrent signup, use that user
+			existing, lookupErr := usersService.GetUserByEmail(tenant.ID, challenge.Email)
+			if lookupErr != nil {
+				return nil, fmt.Errorf("failed to create user: %w", err)
+			}
+			user = existing
+		}
+	}
+
+	if user.Status == "suspended" {
+		return nil, ErrUserSuspended
+	}
+
+	if user.Status != "active" {
+		return nil, ErrEmailLoginInvalid
+	}
+
+	if !user.EmailVerified {
+		if err := s.db.Model(user).Update("email_verified", true).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// PurgeExpiredChallenges removes email sign-ins that can no longer be used
+func (s *EmailLoginService) PurgeExpiredChallenges(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.EmailLoginChallenge{})
+
+	return result.RowsAffected, result.Error
+}
+
+func generateEmailCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashEmailCode salts the code with its challenge, a bare hash of six digits is a lookup table
+func hashEmailCode(challengeID uuid.UUID, code string) string {
+	return tokens.Hash(challengeID.String() + ":" + code)
+}
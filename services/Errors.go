@@ -3,6 +3,72 @@ package services
 import "errors"
 
 var (
-	ErrAccountAlreadyExists = errors.New("account already exists")
-	ErrRecordNotFound = errors.New("record not found")
-)
\ No newline at end of file
+	ErrAccountAlreadyExists        = errors.New("account already exists")
+	ErrAccountInvalid              = errors.New("account is invalid")
+	ErrRecordNotFound              = errors.New("record not found")
+	ErrWeakPassword                = errors.New("password does not meet strength requirements")
+	ErrInvalidCredentials          = errors.New("invalid credentials")
+	ErrAccountSelectionRequired    = errors.New("email belongs to multiple accounts, account_id is required")
+	ErrSessionInvalid              = errors.New("session is invalid or expired")
+	ErrInvitationInvalid           = errors.New("invitation is invalid, expired or already used")
+	ErrInvitationEmailMismatch     = errors.New("invitation was sent to a different email address")
+	ErrAccountUserAlreadyExists    = errors.New("account user already exists")
+	ErrInsufficientRole            = errors.New("role does not allow this change")
+	ErrInvalidRole                 = errors.New("role must be one of owner, admin, member")
+	ErrLastOwner                   = errors.New("account must keep at least one owner")
+	ErrTenantNameTaken             = errors.New("tenant name already used in this account")
+	ErrSlugTaken                   = errors.New("slug is already in use")
+	ErrTenantInvalid               = errors.New("tenant is invalid")
+	ErrTenantSuspended             = errors.New("tenant is suspended")
+	ErrAccountSuspended            = errors.New("account is suspended")
+	ErrAccountDeleted              = errors.New("account is scheduled for deletion")
+	ErrAccountNotVerified          = errors.New("account email has not been verified")
+	ErrVerificationInvalid         = errors.New("verification link is invalid or expired")
+	ErrDomainTaken                 = errors.New("domain is already registered")
+	ErrDomainNotVerified           = errors.New("domain verification record not found")
+	ErrInvalidClient               = errors.New("invalid client")
+	ErrClientTypeImmutable         = errors.New("a client cannot switch between public and confidential")
+	ErrPublicClientSecret          = errors.New("public clients do not have a secret")
+	ErrInvalidStatusTransition     = errors.New("status change is not allowed")
+	ErrUserAlreadyExists           = errors.New("a user with this email already exists")
+	ErrUserSuspended               = errors.New("user is suspended")
+	ErrInvalidEmail                = errors.New("email address is invalid")
+	ErrTokenInvalid                = errors.New("link is invalid, expired or already used")
+	ErrPasswordExpired             = errors.New("password has expired and must be changed")
+	ErrAccountLocked               = errors.New("account is temporarily locked after too many failed sign-in attempts")
+	ErrTooManyAttempts             = errors.New("too many sign-in attempts")
+	ErrMFARequired                 = errors.New("a second factor is required to complete sign-in")
+	ErrMFAInvalidCode              = errors.New("verification code is invalid")
+	ErrMFAChallengeInvalid         = errors.New("sign-in attempt is invalid or expired, sign in again")
+	ErrMFAAlreadyEnabled           = errors.New("an authenticator is already enabled")
+	ErrMFANotEnabled               = errors.New("no authenticator is enabled")
+	ErrReauthenticationRequired    = errors.New("sign in again with a passkey or email link to confirm this change")
+	ErrMFAMethodNotAllowed         = errors.New("this authentication method is not enabled for the tenant")
+	ErrMFAPasskeyRequired          = errors.New("this tenant requires a second factor, sign in with a passkey")
+	ErrPasskeyInvalid              = errors.New("passkey could not be verified")
+	ErrPasskeyAlreadyRegistered    = errors.New("this passkey is already registered")
+	ErrLastSignInMethod            = errors.New("cannot remove the only way to sign in")
+	ErrEmailLoginInvalid           = errors.New("sign-in link or code is invalid, expired or already used")
+	ErrEmailLoginOtherBrowser      = errors.New("open the sign-in link in the browser where you requested it")
+	ErrIdentityProviderInvalid     = errors.New("identity provider configuration is invalid")
+	ErrIdentityProviderUnreachable = errors.New("identity provider could not be reached")
+	ErrFederationInvalid           = errors.New("sign-in attempt is invalid or expired, start again")
+	ErrFederationFailed            = errors.New("sign-in with the identity provider failed")
+	ErrFederationEmailRequired     = errors.New("the identity provider did not share an email address")
+	ErrFederationAccountExists     = errors.New("an account with this email already exists, sign in to it first")
+	ErrFederationSignupDisabled    = errors.New("no account is linked to this identity")
+	ErrSAMLServiceProviderInvalid  = errors.New("service provider configuration is invalid")
+	ErrSAMLEntityIDTaken           = errors.New("a service provider with this entity ID already exists")
+	ErrSAMLRequestInvalid          = errors.New("SAML request is invalid")
+	ErrSCIMTokenInvalid            = errors.New("SCIM token is invalid")
+	ErrSCIMInvalidValue            = errors.New("attribute value is invalid")
+	ErrSCIMVersionMismatch         = errors.New("resource has changed since it was read")
+	ErrGroupNameTaken              = errors.New("a group with this name already exists")
+	ErrGroupInvalid                = errors.New("group is invalid")
+	ErrGroupManagedByDirectory     = errors.New("group is synced from a directory and cannot be changed here")
+	ErrClientRoleInvalid           = errors.New("role is invalid")
+	ErrClientRoleNameTaken         = errors.New("a role with this name already exists for the client")
+	ErrClientPermissionInvalid     = errors.New("permission is invalid")
+	ErrClientPermissionNameTaken   = errors.New("a permission with this name already exists for the client")
+	ErrAccessTokenInvalid          = errors.New("access token is invalid or expired")
+)
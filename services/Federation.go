@@ -0,0 +1,598 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/oidc"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FederationStateLifetime is how long the user has to sign in upstream
+const FederationStateLifetime = 10 * time.Minute
+
+// identityProviderIssuers are the issuers of the preset provider types. Microsoft
+// defaults to the multi-tenant endpoint, set the issuer to restrict it to one directory.
+var identityProviderIssuers = map[string]string{
+	"google":    "https://accounts.google.com",
+	"microsoft": "https://login.microsoftonline.com/common/v2.0",
+}
+
+var defaultIdentityProviderNames = map[string]string{
+	"google":    "Google",
+	"microsoft": "Microsoft",
+}
+
+var defaultFederationScopes = []string{"openid", "email", "profile"}
+
+// IdentityProviderInput creates or updates an identity provider, empty fields keep
+// their value on update. Issuer is the entity ID of a SAML provider and Metadata its
+// EntityDescriptor XML, which fills in the SAML fields left empty. For an LDAP
+// directory Issuer is its ldap:// or ldaps:// URL and Certificates the CAs trusted
+// for its server certificate.
+type IdentityProviderInput struct {
+	Type              string            `json:"type"`
+	Name              string            `json:"name"`
+	Issuer            string            `json:"issuer"`
+	ClientID          string            `json:"client_id"`
+	ClientSecret      string            `json:"client_secret"`
+	Scopes            []string          `json:"scopes"`
+	Metadata          string            `json:"metadata"`
+	SSOURL            string            `json:"sso_url"`
+	Certificates      []string          `json:"certificates"`
+	AttributeMapping  map[string]string `json:"attribute_mapping"`
+	AllowIdPInitiated *bool             `json:"allow_idp_initiated"`
+	StartTLS          *bool             `json:"start_tls"`
+	BindDN            string            `json:"bind_dn"`
+	BindPassword      string            `json:"bind_password"`
+	BaseDN            string            `json:"base_dn"`
+	UserFilter        string            `json:"user_filter"`
+	SubjectAttribute  string            `json:"subject_attribute"`
+	SyncGroups        *bool             `json:"sync_groups"`
+	GroupBaseDN       string            `json:"group_base_dn"`
+	GroupFilter       string            `json:"group_filter"`
+	Domains           []string          `json:"domains"`
+	AllowSignup       *bool             `json:"allow_signup"`
+	Enabled           *bool             `json:"enabled"`
+}
+
+// FederationEndpoints are the URLs of the tenant registered with upstream providers
+type FederationEndpoints struct {
+	RedirectURI  string // OpenID Connect callback
+	SAMLEntityID string // service provider entity ID, its metadata URL
+	SAMLACSURL   string // assertion consumer service
+}
+
+// FederationService signs tenant users in with upstream OpenID Connect providers,
+// linking them to a User through an ExternalIdentity
+type FederationService struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+// NewFederationService optionally takes the HTTP client used to reach providers
+func NewFederationService(db *gorm.DB, client ...*http.Client) *FederationService {
+	s := &FederationService{db: db, client: oidc.DefaultClient}
+	if len(client) > 0 && client[0] != nil {
+		s.client = client[0]
+	}
+	return s
+}
+
+func (s *FederationService) ListProviders(tenantID uuid.UUID) ([]models.IdentityProvider, error) {
+	var providers []models.IdentityProvider
+	err := s.db.Where("tenant_id = ?", tenantID).Order("created_at").Find(&providers).Error
+
+	return providers, err
+}
+
+// EnabledProviders are the providers offered on the sign-in page. Directories are
+// not, their users type their password in the sign-in form.
+func (s *FederationService) EnabledProviders(tenantID uuid.UUID) ([]models.IdentityProvider, error) {
+	var providers []models.IdentityProvider
+	err := s.db.Where("tenant_id = ? AND enabled AND type <> ?", tenantID, "ldap").Order("created_at").Find(&providers).Error
+
+	return providers, err
+}
+
+func (s *FederationService) GetProvider(tenantID uuid.UUID, providerID uuid.UUID) (*models.IdentityProvider, error) {
+	var provider models.IdentityProvider
+	err := s.db.Where("id = ? AND tenant_id = ?", providerID, tenantID).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// CreateProvider adds a provider after checking its discovery document can be fetched
+func (s *FederationService) CreateProvider(ctx context.Context, tenantID uuid.UUID, input IdentityProviderInput) (*models.IdentityProvider, error) {
+	provider := &models.IdentityProvider{
+		TenantID:    tenantID,
+		Type:        input.Type,
+		AllowSignup: true,
+		Enabled:     true,
+	}
+
+	if _, ok := identityProviderIssuers[provider.Type]; !ok && !slices.Contains([]string{"oidc", "saml", "ldap"}, provider.Type) {
+		return nil, fmt.Errorf("%w: type must be oidc, google, microsoft, saml or ldap", ErrIdentityProviderInvalid)
+	}
+
+	if input.Issuer == "" {
+		input.Issuer = identityProviderIssuers[provider.Type]
+	}
+	if input.Name == "" {
+		input.Name = defaultIdentityProviderNames[provider.Type]
+	}
+	switch provider.Type {
+	case "saml":
+		provider.AttributeMapping = defaultSAMLUpstreamMapping
+	case "ldap":
+		provider.AttributeMapping = defaultLDAPMapping
+		provider.UserFilter = defaultLDAPUserFilter
+		provider.SubjectAttribute = defaultLDAPSubjectAttribute
+		provider.GroupFilter = defaultLDAPGroupFilter
+	default:
+		if input.Scopes == nil {
+			input.Scopes = defaultFederationScopes
+		}
+	}
+
+	if err := s.applyInput(ctx, provider, input); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(provider).Error; err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (s *FederationService) UpdateProvider(ctx context.Context, tenantID uuid.UUID, providerID uuid.UUID, input IdentityProviderInput) (*models.IdentityProvider, error) {
+	provider, err := s.GetProvider(tenantID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Type != "" && input.Type != provider.Type {
+		return nil, fmt.Errorf("%w: type cannot be changed", ErrIdentityProviderInvalid)
+	}
+
+	if err := s.applyInput(ctx, provider, input); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(provider).Error; err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// DeleteProvider removes the provider and every identity linked through it. Users
+// left without a way to sign in can still reset a password by email.
+func (s *FederationService) DeleteProvider(tenantID uuid.UUID, providerID uuid.UUID) error {
+	result := s.db.Where("id = ? AND tenant_id = ?", providerID, tenantID).Delete(&models.IdentityProvider{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *FederationService) applyInput(ctx context.Context, provider *models.IdentityProvider, input IdentityProviderInput) error {
+	if input.Name != "" {
+		provider.Name = strings.TrimSpace(input.Name)
+	}
+	if input.AllowSignup != nil {
+		provider.AllowSignup = *input.AllowSignup
+	}
+	if input.Enabled != nil {
+		provider.Enabled = *input.Enabled
+	}
+	if input.Domains != nil {
+		if err := s.applyDomains(provider, input.Domains); err != nil {
+			return err
+		}
+	}
+
+	switch provider.Type {
+	case "saml":
+		return applySAMLInput(provider, input)
+	case "ldap":
+		return s.applyLDAPInput(ctx, provider, input)
+	}
+
+	if input.ClientID != "" {
+		provider.ClientID = strings.TrimSpace(input.ClientID)
+	}
+	if input.ClientSecret != "" {
+		sealed, err := tokens.Seal(input.ClientSecret)
+		if err != nil {
+			return err
+		}
+		provider.ClientSecretSealed = sealed
+	}
+	if input.Scopes != nil {
+		provider.Scopes = normalizeFederationScopes(input.Scopes)
+	}
+
+	issuerChanged := input.Issuer != "" && strings.TrimSuffix(input.Issuer, "/") != provider.Issuer
+	if issuerChanged {
+		issuer, err := validateIssuer(input.Issuer)
+		if err != nil {
+			return err
+		}
+		provider.Issuer = issuer
+	}
+
+	if provider.Name == "" || provider.ClientID == "" || provider.Issuer == "" {
+		return fmt.Errorf("%w: name, issuer and client_id are required", ErrIdentityProviderInvalid)
+	}
+
+	if issuerChanged {
+		if _, err := oidc.Discover(ctx, s.client, provider.Issuer); err != nil {
+			return fmt.Errorf("%w: %v", ErrIdentityProviderUnreachable, err)
+		}
+	}
+	return nil
+}
+
+// validateIssuer requires an absolute https URL without query or fragment
+func validateIssuer(issuer string) (string, error) {
+	issuer = strings.TrimSuffix(strings.TrimSpace(issuer), "/")
+
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("%w: issuer must be an absolute URL", ErrIdentityProviderInvalid)
+	}
+
+	if !isSecureURL(parsed) {
+		return "", fmt.Errorf("%w: issuer must use https", ErrIdentityProviderInvalid)
+	}
+	return issuer, nil
+}
+
+// isSecureURL accepts https, and plain http for a host on this machine
+func isSecureURL(u *url.URL) bool {
+	return u.Scheme == "https" || (u.Scheme == "http" && isLocalHost(u.Hostname()))
+}
+
+func isLocalHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// normalizeFederationScopes always asks for openid, without it there is no ID token
+func normalizeFederationScopes(scopes []string) models.StringArray {
+	normalized := models.StringArray{"openid"}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope != "" && !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized
+}
+
+// Start records a sign-in at providerID and returns the upstream URL to send the
+// browser to, and the state the handler must also keep in a cookie. returnTo must
+// already be validated.
+func (s *FederationService) Start(ctx context.Context, tenant *models.Tenant, providerID uuid.UUID, endpoints FederationEndpoints, clientID *uuid.UUID, returnTo string) (string, string, error) {
+	provider, err := s.GetProvider(tenant.ID, providerID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !provider.Enabled {
+		return "", "", ErrRecordNotFound
+	}
+
+	switch provider.Type {
+	case "saml":
+		return s.startSAML(tenant, provider, endpoints, clientID, returnTo)
+	case "ldap":
+		// Directory users sign in with the password form
+		return "", "", ErrRecordNotFound
+	}
+
+	client, err := s.relyingParty(ctx, provider, endpoints.RedirectURI)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := tokens.Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := tokens.Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := tokens.Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.db.Create(&models.FederationState{
+		TenantID:     tenant.ID,
+		ProviderID:   provider.ID,
+		StateHash:    tokens.Hash(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ClientID:     clientID,
+		ReturnTo:     returnTo,
+		ExpiresAt:    time.Now().Add(FederationStateLifetime),
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	return client.AuthCodeURL(state, nonce, verifier), state, nil
+}
+
+// Callback finishes an upstream sign-in: it consumes the state, redeems the code,
+// validates the ID token and returns the linked or newly provisioned user
+func (s *FederationService) Callback(ctx context.Context, tenant *models.Tenant, state string, code string, redirectURI string) (*models.User, *models.FederationState, error) {
+	if code == "" {
+		return nil, nil, ErrFederationInvalid
+	}
+
+	pending, provider, err := s.takeState(tenant, state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if provider.Type == "saml" || provider.Type == "ldap" {
+		return nil, nil, ErrFederationInvalid
+	}
+
+	client, err := s.relyingParty(ctx, provider, redirectURI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := client.Exchange(ctx, code, pending.CodeVerifier)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	keys, err := oidc.FetchKeys(ctx, s.client, client.Metadata.JWKSURI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrIdentityProviderUnreachable, err)
+	}
+
+	claims, err := oidc.VerifyIDToken(response.IDToken, keys, oidc.Expected{
+		Issuer:   client.Metadata.Issuer,
+		ClientID: provider.ClientID,
+		Nonce:    pending.Nonce,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	user, err := s.resolveUser(tenant, provider, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, pending, nil
+}
+
+// takeState consumes a pending upstream sign-in and returns it with its provider
+func (s *FederationService) takeState(tenant *models.Tenant, state string) (*models.FederationState, *models.IdentityProvider, error) {
+	if state == "" {
+		return nil, nil, ErrFederationInvalid
+	}
+
+	var pending models.FederationState
+	err := s.db.Where("state_hash = ? AND tenant_id = ?", tokens.Hash(state), tenant.ID).First(&pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrFederationInvalid
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The state is single use, whatever happens next
+	result := s.db.Where("id = ?", pending.ID).Delete(&models.FederationState{})
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	if result.RowsAffected == 0 || !time.Now().Before(pending.ExpiresAt) {
+		return nil, nil, ErrFederationInvalid
+	}
+
+	provider, err := s.GetProvider(tenant.ID, pending.ProviderID)
+	if errors.Is(err, ErrRecordNotFound) || (err == nil && !provider.Enabled) {
+		return nil, nil, ErrFederationInvalid
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return &pending, provider, nil
+}
+
+// resolveUser finds the user linked to the upstream subject. A first sign-in links
+// to the user with the same email only when the provider vouches for the address,
+// otherwise anyone able to set an unverified email upstream could take the account.
+func (s *FederationService) resolveUser(tenant *models.Tenant, provider *models.IdentityProvider, claims *oidc.Claims) (*models.User, error) {
+	usersService := NewUsersService(s.db)
+	now := time.Now()
+
+	var identity models.ExternalIdentity
+	err := s.db.Where("provider_id = ? AND subject = ?", provider.ID, claims.Subject).First(&identity).Error
+	if err == nil {
+		user, err := usersService.GetUser(tenant.ID, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.db.Model(&identity).Updates(map[string]any{"last_login_at": now, "email": claims.Email}).Error
+		if err != nil {
+			return nil, err
+		}
+		return checkFederatedUser(user)
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email, err := normalizeEmail(claims.Email)
+	if err != nil {
+		return nil, ErrFederationEmailRequired
+	}
+
+	user, err := usersService.GetUserByEmail(tenant.ID, email)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user != nil && !claims.EmailVerified {
+		return nil, ErrFederationAccountExists
+	}
+
+	if user == nil && !provider.AllowSignup {
+		return nil, ErrFederationSignupDisabled
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if user == nil {
+			user = &models.User{
+				TenantID:      tenant.ID,
+				Email:         email,
+				EmailVerified: bool(claims.EmailVerified),
+				GivenName:     claims.GivenName,
+				FamilyName:    claims.FamilyName,
+				PictureURL:    claims.Picture,
+				Status:        "active",
+			}
+			if len(claims.Locale) <= 10 {
+				user.Locale = claims.Locale
+			}
+
+			if err := tx.Create(user).Error; err != nil {
+				return fmt.Errorf("failed to create user: %w", err)
+			}
+		} else if !user.EmailVerified {
+			// The provider just proved the address
+			if err := tx.Model(user).Update("email_verified", true).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			UserID:      user.ID,
+			ProviderID:  provider.ID,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return checkFederatedUser(user)
+}
+
+func checkFederatedUser(user *models.User) (*models.User, error) {
+	switch user.Status {
+	case "active":
+		return user, nil
+	case "suspended":
+		return nil, ErrUserSuspended
+	}
+	return nil, ErrFederationInvalid
+}
+
+// relyingParty discovers the provider and opens its client secret
+func (s *FederationService) relyingParty(ctx context.Context, provider *models.IdentityProvider, redirectURI string) (*oidc.Client, error) {
+	metadata, err := oidc.Discover(ctx, s.client, provider.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentityProviderUnreachable, err)
+	}
+
+	var secret string
+	if provider.ClientSecretSealed != "" {
+		secret, err = tokens.Open(provider.ClientSecretSealed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	scopes := provider.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultFederationScopes
+	}
+
+	return &oidc.Client{
+		ClientID:     provider.ClientID,
+		ClientSecret: secret,
+		RedirectURI:  redirectURI,
+		Scopes:       scopes,
+		Metadata:     metadata,
+		HTTPClient:   s.client,
+	}, nil
+}
+
+// ListIdentities returns the upstream accounts linked to the user
+func (s *FederationService) ListIdentities(userID uuid.UUID) ([]models.ExternalIdentity, error) {
+	var identities []models.ExternalIdentity
+	err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+
+	return identities, err
+}
+
+// UnlinkIdentity removes a linked upstream account, unless it is the user's only way to sign in
+func (s *FederationService) UnlinkIdentity(user *models.User, identityID uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var identities, passkeys int64
+		if err := tx.Model(&models.ExternalIdentity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&passkeys).Error; err != nil {
+			return err
+		}
+
+		if user.PasswordHash == "" && passkeys == 0 && identities <= 1 {
+			return ErrLastSignInMethod
+		}
+
+		result := tx.Where("id = ? AND user_id = ?", identityID, user.ID).Delete(&models.ExternalIdentity{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// PurgeExpiredStates removes upstream sign-ins nobody came back from
+func (s *FederationService) PurgeExpiredStates(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.FederationState{})
+
+	return result.RowsAffected, result.Error
+}
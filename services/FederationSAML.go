@@ -0,0 +1,336 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/oidc"
+	"DigiPassAuthenticationApi/packages/saml"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// mappedUserFields are the user fields a SAML or LDAP attribute can fill
+var mappedUserFields = []string{"email", "given_name", "family_name", "locale", "picture_url"}
+
+// defaultSAMLUpstreamMapping covers the attribute names of Okta, Entra ID, ADFS and Google
+var defaultSAMLUpstreamMapping = models.StringMap{
+	"email":     "email",
+	"mail":      "email",
+	"firstName": "given_name",
+	"givenName": "given_name",
+	"lastName":  "family_name",
+	"sn":        "family_name",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress": "email",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname":    "given_name",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname":      "family_name",
+}
+
+// applySAMLInput sets the fields of a SAML identity provider. Metadata fills in the
+// entity ID, sign-in URL and certificates left empty.
+func applySAMLInput(provider *models.IdentityProvider, input IdentityProviderInput) error {
+	if input.Metadata != "" {
+		entityID, ssoURL, certificates, err := saml.ParseIdentityProviderMetadata([]byte(input.Metadata))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrIdentityProviderInvalid, err)
+		}
+
+		if input.Issuer == "" {
+			input.Issuer = entityID
+		}
+		if input.SSOURL == "" {
+			input.SSOURL = ssoURL
+		}
+		if input.Certificates == nil {
+			input.Certificates = certificates
+		}
+	}
+
+	if input.Issuer != "" {
+		provider.Issuer = strings.TrimSpace(input.Issuer)
+	}
+	if input.SSOURL != "" {
+		parsed, err := url.Parse(input.SSOURL)
+		if err != nil || parsed.Host == "" || parsed.Fragment != "" || !isSecureURL(parsed) {
+			return fmt.Errorf("%w: sso_url must be an absolute https URL", ErrIdentityProviderInvalid)
+		}
+		provider.SSOURL = input.SSOURL
+	}
+	if input.Certificates != nil {
+		provider.Certificates = models.StringArray{}
+		for _, certificate := range input.Certificates {
+			if _, err := parseCertificatePEM(certificate); err != nil {
+				return fmt.Errorf("%w: certificates must be PEM encoded X.509 certificates", ErrIdentityProviderInvalid)
+			}
+			provider.Certificates = append(provider.Certificates, certificate)
+		}
+	}
+	if input.AttributeMapping != nil {
+		provider.AttributeMapping = models.StringMap{}
+		for name, field := range input.AttributeMapping {
+			if name == "" || !slices.Contains(mappedUserFields, field) {
+				return fmt.Errorf("%w: attribute_mapping values must be one of %s", ErrIdentityProviderInvalid, strings.Join(mappedUserFields, ", "))
+			}
+			provider.AttributeMapping[name] = field
+		}
+	}
+	if input.AllowIdPInitiated != nil {
+		provider.AllowIdPInitiated = *input.AllowIdPInitiated
+	}
+
+	if provider.Name == "" || provider.Issuer == "" || provider.SSOURL == "" || len(provider.Certificates) == 0 {
+		return fmt.Errorf("%w: name, issuer, sso_url and certificates are required", ErrIdentityProviderInvalid)
+	}
+	return nil
+}
+
+// applyDomains sets the email domains routed to the provider, a domain can only
+// belong to one provider of the tenant
+func (s *FederationService) applyDomains(provider *models.IdentityProvider, domains []string) error {
+	provider.Domains = models.StringArray{}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || strings.Contains(domain, "@") || !strings.Contains(domain, ".") {
+			return fmt.Errorf("%w: %q is not an email domain", ErrIdentityProviderInvalid, domain)
+		}
+
+		if slices.Contains(provider.Domains, domain) {
+			continue
+		}
+
+		var taken int64
+		err := s.db.Model(&models.IdentityProvider{}).
+			Where("tenant_id = ? AND id <> ? AND ? = ANY(domains)", provider.TenantID, provider.ID, domain).
+			Count(&taken).Error
+		if err != nil {
+			return err
+		}
+
+		if taken > 0 {
+			return fmt.Errorf("%w: %s is already routed to another provider", ErrIdentityProviderInvalid, domain)
+		}
+		provider.Domains = append(provider.Domains, domain)
+	}
+	return nil
+}
+
+// ProviderForEmail returns the enabled provider the domain of email is routed to
+func (s *FederationService) ProviderForEmail(tenantID uuid.UUID, email string) (*models.IdentityProvider, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+
+	var provider models.IdentityProvider
+	err := s.db.Where("tenant_id = ? AND enabled AND ? = ANY(domains)", tenantID, domain).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// startSAML sends a signed AuthnRequest with the HTTP-Redirect binding, the state
+// travels as RelayState and the request ID is kept to match the Response
+func (s *FederationService) startSAML(tenant *models.Tenant, provider *models.IdentityProvider, endpoints FederationEndpoints, clientID *uuid.UUID, returnTo string) (string, string, error) {
+	signer, err := NewSigningKeyService(s.db).ActiveKey(tenant)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := tokens.Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	request := saml.NewAuthnRequest(provider.SSOURL, endpoints.SAMLEntityID, endpoints.SAMLACSURL, time.Now())
+
+	err = s.db.Create(&models.FederationState{
+		TenantID:   tenant.ID,
+		ProviderID: provider.ID,
+		StateHash:  tokens.Hash(state),
+		Nonce:      request.Attr("ID"),
+		ClientID:   clientID,
+		ReturnTo:   returnTo,
+		ExpiresAt:  time.Now().Add(FederationStateLifetime),
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := saml.RedirectURL(provider.SSOURL, "SAMLRequest", request, state, signer)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// SAMLCallback validates a Response posted to the assertion consumer service and
+// returns the linked or newly provisioned user. state is empty for a Response the
+// provider sent on its own, accepted only when the provider allows it.
+func (s *FederationService) SAMLCallback(tenant *models.Tenant, state string, response []byte, endpoints FederationEndpoints) (*models.User, *models.FederationState, error) {
+	var pending *models.FederationState
+	var provider *models.IdentityProvider
+	var err error
+
+	if state != "" {
+		pending, provider, err = s.takeState(tenant, state)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if provider.Type != "saml" {
+			return nil, nil, ErrFederationInvalid
+		}
+	} else {
+		provider, err = s.unsolicitedProvider(tenant, response)
+		if err != nil {
+			return nil, nil, err
+		}
+		pending = &models.FederationState{TenantID: tenant.ID, ProviderID: provider.ID}
+	}
+
+	verified, err := verifySAMLResponse(provider, pending, response, endpoints, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.consumeAssertion(provider, verified); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.resolveUser(tenant, provider, samlClaims(provider, verified))
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, pending, nil
+}
+
+// verifySAMLResponse checks a Response against the provider's certificates and the
+// request it answers, pending.Nonce is empty for an unsolicited Response
+func verifySAMLResponse(provider *models.IdentityProvider, pending *models.FederationState, response []byte, endpoints FederationEndpoints, now time.Time) (*saml.VerifiedAssertion, error) {
+	certificates := make([]*x509.Certificate, 0, len(provider.Certificates))
+	for _, encoded := range provider.Certificates {
+		certificate, err := parseCertificatePEM(encoded)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	verified, err := saml.ParseResponse(response, saml.Expected{
+		EntityID:     endpoints.SAMLEntityID,
+		ACSURL:       endpoints.SAMLACSURL,
+		Issuer:       provider.Issuer,
+		Certificates: certificates,
+		InResponseTo: pending.Nonce,
+		Now:          now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	if verified.NameID.Format == saml.NameIDFormatTransient {
+		return nil, fmt.Errorf("%w: a transient NameID cannot identify the user", ErrFederationFailed)
+	}
+	return verified, nil
+}
+
+// consumeAssertion makes an assertion single use, it is remembered until it could
+// no longer be accepted
+func (s *FederationService) consumeAssertion(provider *models.IdentityProvider, verified *saml.VerifiedAssertion) error {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ConsumedAssertion{
+		ProviderID:  provider.ID,
+		AssertionID: verified.ID,
+		ExpiresAt:   verified.ExpiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrFederationInvalid
+	}
+	return nil
+}
+
+// unsolicitedProvider finds the provider that issued a Response nobody asked for
+func (s *FederationService) unsolicitedProvider(tenant *models.Tenant, response []byte) (*models.IdentityProvider, error) {
+	issuer, err := saml.ResponseIssuer(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	var provider models.IdentityProvider
+	err = s.db.Where("tenant_id = ? AND type = ? AND issuer = ? AND enabled", tenant.ID, "saml", issuer).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFederationInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !provider.AllowIdPInitiated {
+		return nil, ErrFederationInvalid
+	}
+	return &provider, nil
+}
+
+// samlClaims maps the assertion to the claims an ID token would carry
+func samlClaims(provider *models.IdentityProvider, verified *saml.VerifiedAssertion) *oidc.Claims {
+	claims := &oidc.Claims{Subject: verified.NameID.Value}
+
+	for name, field := range provider.AttributeMapping {
+		values := verified.Attributes[name]
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+
+		switch field {
+		case "email":
+			claims.Email = values[0]
+		case "given_name":
+			claims.GivenName = values[0]
+		case "family_name":
+			claims.FamilyName = values[0]
+		case "locale":
+			claims.Locale = values[0]
+		case "picture_url":
+			claims.Picture = values[0]
+		}
+	}
+
+	if claims.Email == "" && verified.NameID.Format == saml.NameIDFormatEmail {
+		claims.Email = verified.NameID.Value
+	}
+
+	claims.EmailVerified = vouchesFor(provider, claims.Email)
+	return claims
+}
+
+// vouchesFor reports whether email is in a domain routed to the provider
+func vouchesFor(provider *models.IdentityProvider, email string) oidc.Bool {
+	at := strings.LastIndex(email, "@")
+	return oidc.Bool(at >= 0 && slices.Contains(provider.Domains, strings.ToLower(email[at+1:])))
+}
+
+// PurgeConsumedAssertions forgets assertions that could no longer be replayed anyway
+func (s *FederationService) PurgeConsumedAssertions(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.ConsumedAssertion{})
+
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/saml"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+var testSAMLEndpoints = FederationEndpoints{
+	SAMLEntityID: "https://id.example.com/t/acme/federation/saml/metadata",
+	SAMLACSURL:   "https://id.example.com/t/acme/federation/saml/acs",
+}
+
+const testIdPIssuer = "https://idp.example.com/saml"
+
+func testIdPSigner(t *testing.T) (*saml.Signer, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &saml.Signer{Key: key, Certificate: certificate}, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// idpResponse is a Response with a signed assertion, as the IdP would post it to the ACS
+func idpResponse(t *testing.T, signer *saml.Signer, inResponseTo string, nameID saml.NameID, now time.Time) []byte {
+	t.Helper()
+
+	assertion := (&saml.Assertion{
+		Issuer:       testIdPIssuer,
+		Audience:     testSAMLEndpoints.SAMLEntityID,
+		Recipient:    testSAMLEndpoints.SAMLACSURL,
+		InResponseTo: inResponseTo,
+		NameID:       nameID,
+		SessionIndex: "_session1",
+		AuthnInstant: now,
+		AuthnContext: saml.AuthnContextPassword,
+		IssueInstant: now,
+		ValidFor:     5 * time.Minute,
+	}).Element()
+	if err := signer.Sign(assertion, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(saml.NewResponse(testSAMLEndpoints.SAMLACSURL, inResponseTo, testIdPIssuer, saml.StatusSuccess, assertion, now).Canonical())
+}
+
+func TestVerifySAMLResponse(t *testing.T) {
+	signer, certificate := testIdPSigner(t)
+	rotated, rotatedCertificate := testIdPSigner(t)
+	now := time.Now()
+
+	provider := &models.IdentityProvider{ID: uuid.New(), Type: "saml", Issuer: testIdPIssuer, Certificates: models.StringArray{certificate}}
+	rotatedProvider := &models.IdentityProvider{ID: uuid.New(), Type: "saml", Issuer: testIdPIssuer, Certificates: models.StringArray{certificate, rotatedCertificate}}
+
+	solicited := &models.FederationState{Nonce: "_request1"}
+	unsolicited := &models.FederationState{}
+	email := saml.NameID{Format: saml.NameIDFormatEmail, Value: "ada@example.com"}
+
+	tests := []struct {
+		name     string
+		provider *models.IdentityProvider
+		pending  *models.FederationState
+		response []byte
+		now      time.Time
+		want     error
+	}{
+		{name: "solicited", provider: provider, pending: solicited, response: idpResponse(t, signer, "_request1", email, now), now: now},
+		{name: "unsolicited", provider: provider, pending: unsolicited, response: idpResponse(t, signer, "", email, now), now: now},
+		{name: "answer to another request", provider: provider, pending: solicited, response: idpResponse(t, signer, "_request2", email, now), now: now, want: ErrFederationFailed},
+		{name: "solicited response posted without its state", provider: provider, pending: unsolicited, response: idpResponse(t, signer, "_request1", email, now), now: now, want: ErrFederationFailed},
+		{name: "unsolicited response posted with a state", provider: provider, pending: solicited, response: idpResponse(t, signer, "", email, now), now: now, want: ErrFederationFailed},
+		{name: "expired", provider: provider, pending: solicited, response: idpResponse(t, signer, "_request1", email, now), now: now.Add(10 * time.Minute), want: ErrFederationFailed},
+		{name: "signed by a rotated key", provider: rotatedProvider, pending: solicited, response: idpResponse(t, rotated, "_request1", email, now), now: now},
+		{name: "signed by a key the provider does not trust", provider: provider, pending: solicited, response: idpResponse(t, rotated, "_request1", email, now), now: now, want: ErrFederationFailed},
+		{name: "transient NameID", provider: provider, pending: solicited, response: idpResponse(t, signer, "_request1", saml.NameID{Format: saml.NameIDFormatTransient, Value: "_t1"}, now), now: now, want: ErrFederationFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := verifySAMLResponse(test.provider, test.pending, test.response, testSAMLEndpoints, test.now)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("verifySAMLResponse = %+v, %v, want %v", verified, err, test.want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("verifySAMLResponse = %v", err)
+			}
+			if claims := samlClaims(test.provider, verified); claims.Subject != "ada@example.com" || claims.Email != "ada@example.com" {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestConsumeAssertionRejectsReplay(t *testing.T) {
+	db, mock := mockDB(t)
+	service := NewFederationService(db)
+
+	provider := &models.IdentityProvider{ID: uuid.New()}
+	verified := &saml.VerifiedAssertion{ID: "_assertion1", ExpiresAt: time.Now().Add(8 * time.Minute)}
+
+	// The unique index on (provider_id, assertion_id) turns the second insert into a no-op
+	mock.ExpectQuery(`INSERT INTO "consumed_assertions" .* ON CONFLICT DO NOTHING`).
+		WithArgs(provider.ID, verified.ID, verified.ExpiresAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectQuery(`INSERT INTO "consumed_assertions" .* ON CONFLICT DO NOTHING`).
+		WithArgs(provider.ID, verified.ID, verified.ExpiresAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if err := service.consumeAssertion(provider, verified); err != nil {
+		t.Fatalf("first use = %v", err)
+	}
+	if err := service.consumeAssertion(provider, verified); !errors.Is(err, ErrFederationInvalid) {
+		t.Fatalf("replay = %v, want ErrFederationInvalid", err)
+	}
+}
+
+func TestUnsolicitedProvider(t *testing.T) {
+	signer, certificate := testIdPSigner(t)
+	tenant := &models.Tenant{ID: uuid.New()}
+	response := idpResponse(t, signer, "", saml.NameID{Format: saml.NameIDFormatEmail, Value: "ada@example.com"}, time.Now())
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr error
+	}{
+		{
+			name: "provider allows IdP initiated sign-in",
+			rows: sqlmock.NewRows([]string{"id", "tenant_id", "type", "issuer", "certificates", "allow_idp_initiated", "enabled"}).
+				AddRow(uuid.New(), tenant.ID, "saml", testIdPIssuer, "{\""+certificate+"\"}", true, true),
+		},
+		{
+			name: "provider only answers its own requests",
+			rows: sqlmock.NewRows([]string{"id", "tenant_id", "type", "issuer", "allow_idp_initiated", "enabled"}).
+				AddRow(uuid.New(), tenant.ID, "saml", testIdPIssuer, false, true),
+			wantErr: ErrFederationInvalid,
+		},
+		{
+			name:    "unknown issuer",
+			rows:    sqlmock.NewRows([]string{"id"}),
+			wantErr: ErrFederationInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			mock.ExpectQuery(`SELECT \* FROM "identity_providers" WHERE tenant_id = \$1 AND type = \$2 AND issuer = \$3 AND enabled`).
+				WithArgs(tenant.ID, "saml", testIdPIssuer, 1).
+				WillReturnRows(test.rows)
+
+			provider, err := NewFederationService(db).unsolicitedProvider(tenant, response)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("unsolicitedProvider = %+v, %v, want %v", provider, err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unsolicitedProvider = %v", err)
+			}
+
+			verified, err := verifySAMLResponse(provider, &models.FederationState{}, response, testSAMLEndpoints, time.Now())
+			if err != nil || verified.NameID.Value != "ada@example.com" {
+				t.Fatalf("verifySAMLResponse = %+v, %v", verified, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/oidc"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+var upstreamKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// upstreamProvider is an OpenID provider on httptest. Its token endpoint redeems
+// "code-1" for idToken when the PKCE verifier matches "verifier-1".
+type upstreamProvider struct {
+	server  *httptest.Server
+	idToken string
+}
+
+func newUpstreamProvider(t *testing.T) *upstreamProvider {
+	t.Helper()
+
+	p := &upstreamProvider{}
+	key := upstreamKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.Metadata{
+			Issuer:                        p.server.URL,
+			AuthorizationEndpoint:         p.server.URL + "/authorize",
+			TokenEndpoint:                 p.server.URL + "/token",
+			JWKSURI:                       p.server.URL + "/jwks",
+			CodeChallengeMethodsSupported: []string{"S256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.KeySet{Keys: []oidc.JSONWebKey{{
+			Kty: "RSA",
+			Kid: "rsa-1",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code-1" || oidc.CodeChallenge(r.PostFormValue("code_verifier")) != oidc.CodeChallenge("verifier-1") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(oidc.TokenResponse{AccessToken: "upstream-access", TokenType: "Bearer", IDToken: p.idToken})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// upstreamIDToken signs claims with RS256, or with HS256 keyed by secret when given
+func upstreamIDToken(claims map[string]any, secret []byte) string {
+	alg := "RS256"
+	if secret != nil {
+		alg = "HS256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "rsa-1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	if secret != nil {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256([]byte(signed))
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, upstreamKey(), crypto.SHA256, digest[:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestCallback(t *testing.T) {
+	provider := newUpstreamProvider(t)
+	tenant := &models.Tenant{ID: uuid.New()}
+	providerID, userID := uuid.New(), uuid.New()
+	publicDER, _ := x509.MarshalPKIXPublicKey(&upstreamKey().PublicKey)
+
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":            provider.server.URL,
+			"sub":            "upstream-1",
+			"aud":            "relying-party",
+			"exp":            time.Now().Add(5 * time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          "nonce-1",
+			"email":          "Ada@Example.com",
+			"email_verified": true,
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	userRows := func(verified bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "tenant_id", "email", "email_verified", "status"}).
+			AddRow(userID, tenant.ID, "ada@example.com", verified, "active")
+	}
+
+	// The ID token names an upstream subject nobody is linked to yet
+	newSubject := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "external_identities" WHERE provider_id = \$1 AND subject = \$2`).
+			WithArgs(providerID, "upstream-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	existingUser := func(verified bool) func(sqlmock.Sqlmock) {
+		return func(mock sqlmock.Sqlmock) {
+			newSubject(mock)
+			mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND LOWER\(email\) = \$2`).
+				WithArgs(tenant.ID, "ada@example.com", 1).
+				WillReturnRows(userRows(verified))
+		}
+	}
+	noUser := func(mock sqlmock.Sqlmock) {
+		newSubject(mock)
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND LOWER\(email\) = \$2`).
+			WithArgs(tenant.ID, "ada@example.com", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	linkIdentity := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`INSERT INTO "external_identities"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name        string
+		idToken     string
+		verifier    string // the PKCE verifier kept with the state
+		allowSignup bool
+		database    func(sqlmock.Sqlmock) // statements after the provider answered
+		want        error
+		wantNewUser bool
+	}{
+		{
+			name:    "verified email links the existing account and verifies it",
+			idToken: upstreamIDToken(claims(nil), nil),
+			database: func(mock sqlmock.Sqlmock) {
+				existingUser(false)(mock)
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "users" SET "email_verified"=\$1`).
+					WithArgs(true, sqlmock.AnyArg(), userID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				linkIdentity(mock)
+			},
+		},
+		{
+			name:    `email_verified "true" as a string`,
+			idToken: upstreamIDToken(claims(func(c map[string]any) { c["email_verified"] = "true" }), nil),
+			database: func(mock sqlmock.Sqlmock) {
+				existingUser(true)(mock)
+				mock.ExpectBegin()
+				linkIdentity(mock)
+			},
+		},
+		{
+			name:     "unverified email does not link the existing account",
+			idToken:  upstreamIDToken(claims(func(c map[string]any) { c["email_verified"] = false }), nil),
+			database: existingUser(true),
+			want:     ErrFederationAccountExists,
+		},
+		{
+			name:     "missing email_verified does not link the existing account",
+			idToken:  upstreamIDToken(claims(func(c map[string]any) { delete(c, "email_verified") }), nil),
+			database: existingUser(true),
+			want:     ErrFederationAccountExists,
+		},
+		{
+			name:        "unverified email of a new user signs up unverified",
+			idToken:     upstreamIDToken(claims(func(c map[string]any) { c["email_verified"] = "false" }), nil),
+			allowSignup: true,
+			database: func(mock sqlmock.Sqlmock) {
+				noUser(mock)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO "users"`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+				linkIdentity(mock)
+			},
+			wantNewUser: true,
+		},
+		{
+			name:     "no account and sign-up disabled",
+			idToken:  upstreamIDToken(claims(nil), nil),
+			database: noUser,
+			want:     ErrFederationSignupDisabled,
+		},
+		{
+			name:    "returning identity",
+			idToken: upstreamIDToken(claims(func(c map[string]any) { c["email_verified"] = false }), nil),
+			database: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "external_identities" WHERE provider_id = \$1 AND subject = \$2`).
+					WithArgs(providerID, "upstream-1", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "provider_id", "subject"}).AddRow(uuid.New(), userID, providerID, "upstream-1"))
+				mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`).
+					WithArgs(tenant.ID, userID, 1).
+					WillReturnRows(userRows(true))
+				mock.ExpectExec(`UPDATE "external_identities" SET`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+
+		{name: "other nonce", idToken: upstreamIDToken(claims(func(c map[string]any) { c["nonce"] = "nonce-2" }), nil), want: ErrFederationFailed},
+		{name: "other audience", idToken: upstreamIDToken(claims(func(c map[string]any) { c["aud"] = "another-client" }), nil), want: ErrFederationFailed},
+		{name: "other issuer", idToken: upstreamIDToken(claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" }), nil), want: ErrFederationFailed},
+		{name: "expired", idToken: upstreamIDToken(claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), nil), want: ErrFederationFailed},
+		{name: "HS256 keyed with the provider's public key", idToken: upstreamIDToken(claims(nil), publicDER), want: ErrFederationFailed},
+		{name: "PKCE verifier of another sign-in", idToken: upstreamIDToken(claims(nil), nil), verifier: "verifier-2", want: ErrFederationFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider.idToken = test.idToken
+			verifier := test.verifier
+			if verifier == "" {
+				verifier = "verifier-1"
+			}
+
+			db, mock := mockDB(t)
+			stateID := uuid.New()
+			mock.ExpectQuery(`SELECT \* FROM "federation_states" WHERE state_hash = \$1 AND tenant_id = \$2`).
+				WithArgs(tokens.Hash("state-1"), tenant.ID, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "provider_id", "nonce", "code_verifier", "expires_at"}).
+					AddRow(stateID, tenant.ID, providerID, "nonce-1", verifier, time.Now().Add(5*time.Minute)))
+			mock.ExpectExec(`DELETE FROM "federation_states" WHERE id = \$1`).
+				WithArgs(stateID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(`SELECT \* FROM "identity_providers" WHERE id = \$1 AND tenant_id = \$2`).
+				WithArgs(providerID, tenant.ID, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "type", "issuer", "client_id", "allow_signup", "enabled"}).
+					AddRow(providerID, tenant.ID, "oidc", provider.server.URL, "relying-party", test.allowSignup, true))
+			if test.database != nil {
+				test.database(mock)
+			}
+
+			service := NewFederationService(db, provider.server.Client())
+			user, pending, err := service.Callback(context.Background(), tenant, "state-1", "code-1", "https://id.example.com/t/acme/federation/callback")
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("Callback = %+v, %v, want %v", user, err, test.want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Callback = %v", err)
+			}
+			if pending.ID != stateID {
+				t.Fatalf("pending = %+v", pending)
+			}
+			if test.wantNewUser {
+				if user.ID == userID || user.Email != "ada@example.com" || user.EmailVerified {
+					t.Fatalf("user = %+v, want a new unverified user", user)
+				}
+				return
+			}
+			if user.ID != userID {
+				t.Fatalf("user = %+v, want the existing account", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GroupService struct {
+	db *gorm.DB
+}
+
+func NewGroupService(db *gorm.DB) *GroupService {
+	return &GroupService{db: db}
+}
+
+// SyncMemberships makes the user a member of exactly the named groups of a directory.
+// Missing groups are created for the provider; a group of the same name managed here
+// or by another directory is left alone rather than taken over.
+func (s *GroupService) SyncMemberships(tenantID uuid.UUID, providerID uuid.UUID, userID uuid.UUID, names []string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var keep []uuid.UUID
+
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" || len(name) > 255 {
+				continue
+			}
+
+			var group models.Group
+			err := tx.Where("tenant_id = ? AND name = ?", tenantID, name).First(&group).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// Another sign-in may create it first
+				group = models.Group{TenantID: tenantID, Name: name, ProviderID: &providerID}
+				result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&group)
+				err = result.Error
+				if err == nil && result.RowsAffected == 0 {
+					group = models.Group{}
+					err = tx.Where("tenant_id = ? AND name = ?", tenantID, name).First(&group).Error
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			if group.ProviderID == nil || *group.ProviderID != providerID {
+				continue
+			}
+
+			keep = append(keep, group.ID)
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.GroupMember{GroupID: group.ID, UserID: userID}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		removed := tx.Where("user_id = ? AND group_id IN (?)", userID, tx.Model(&models.Group{}).Select("id").Where("provider_id = ?", providerID))
+		if len(keep) > 0 {
+			removed = removed.Where("group_id NOT IN ?", keep)
+		}
+		return removed.Delete(&models.GroupMember{}).Error
+	})
+}
+
+// GroupInput creates or updates a group, nil fields are left unchanged on update
+type GroupInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+func (s *GroupService) ListGroups(tenantID uuid.UUID) ([]models.Group, error) {
+	var groups []models.Group
+	err := s.db.Where("tenant_id = ?", tenantID).Order("name").Find(&groups).Error
+
+	return groups, err
+}
+
+func (s *GroupService) GetGroup(tenantID uuid.UUID, id uuid.UUID) (*models.Group, error) {
+	var group models.Group
+	err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (s *GroupService) CreateGroup(tenantID uuid.UUID, input GroupInput) (*models.Group, error) {
+	group := &models.Group{TenantID: tenantID}
+	if err := applyGroupInput(group, input); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureGroupNameAvailable(tx, group); err != nil {
+			return err
+		}
+		return tx.Create(group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// UpdateGroup renames or describes a group. A group synced from a directory keeps
+// its name, the next sign-in would otherwise create it again.
+func (s *GroupService) UpdateGroup(tenantID uuid.UUID, id uuid.UUID, input GroupInput) (*models.Group, error) {
+	group, err := s.GetGroup(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if group.ProviderID != nil && input.Name != nil && strings.TrimSpace(*input.Name) != group.Name {
+		return nil, ErrGroupManagedByDirectory
+	}
+	if err := applyGroupInput(group, input); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureGroupNameAvailable(tx, group); err != nil {
+			return err
+		}
+		return tx.Save(group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *GroupService) DeleteGroup(tenantID uuid.UUID, id uuid.UUID) error {
+	result := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).Delete(&models.Group{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *GroupService) ListMembers(tenantID uuid.UUID, id uuid.UUID) ([]models.User, error) {
+	if _, err := s.GetGroup(tenantID, id); err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	err := s.db.Where("id IN (?)", s.db.Model(&models.GroupMember{}).Select("user_id").Where("group_id = ?", id)).
+		Order("email").
+		Find(&users).Error
+
+	return users, err
+}
+
+// SetMembers makes userIDs the exact membership of the group. Memberships of a
+// directory group are replaced at each sign-in so they cannot be edited here.
+func (s *GroupService) SetMembers(tenantID uuid.UUID, id uuid.UUID, userIDs []uuid.UUID) error {
+	group, err := s.GetGroup(tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	if group.ProviderID != nil {
+		return ErrGroupManagedByDirectory
+	}
+
+	members := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		members[userID] = true
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		return saveSCIMMembers(tx, group, members)
+	})
+	if errors.Is(err, ErrSCIMInvalidValue) {
+		return fmt.Errorf("%w: members must be users of this tenant", ErrGroupInvalid)
+	}
+	return err
+}
+
+func applyGroupInput(group *models.Group, input GroupInput) error {
+	if input.Name != nil {
+		group.Name = strings.TrimSpace(*input.Name)
+	}
+	if group.Name == "" || len(group.Name) > 255 {
+		return fmt.Errorf("%w: name is required and at most 255 characters", ErrGroupInvalid)
+	}
+
+	if input.Description != nil {
+		group.Description = *input.Description
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/ldap"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/oidc"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// defaultLDAPMapping covers inetOrgPerson and Active Directory users
+var defaultLDAPMapping = models.StringMap{
+	"mail":              "email",
+	"givenName":         "given_name",
+	"sn":                "family_name",
+	"preferredLanguage": "locale",
+}
+
+const (
+	defaultLDAPUserFilter       = "(&(objectClass=person)(mail={username}))"
+	defaultLDAPSubjectAttribute = "entryUUID"
+	defaultLDAPGroupFilter      = "(|(member={dn})(uniqueMember={dn}))"
+)
+
+// ldapTimeout bounds each directory operation, a sign-in waits for all of them
+const ldapTimeout = 10 * time.Second
+
+// maxSyncedGroups caps the memberships copied from the directory at each sign-in
+const maxSyncedGroups = 500
+
+// applyLDAPInput sets the fields of a directory and checks that the service account
+// can bind whenever the connection settings change
+func (s *FederationService) applyLDAPInput(ctx context.Context, provider *models.IdentityProvider, input IdentityProviderInput) error {
+	connectionChanged := provider.ID == uuid.Nil
+
+	if input.Issuer != "" && input.Issuer != provider.Issuer {
+		parsed, err := url.Parse(strings.TrimSpace(input.Issuer))
+		if err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Hostname() == "" || (parsed.Path != "" && parsed.Path != "/") {
+			return fmt.Errorf("%w: issuer must be an ldap:// or ldaps:// URL", ErrIdentityProviderInvalid)
+		}
+		provider.Issuer = parsed.Scheme + "://" + parsed.Host
+		connectionChanged = true
+	}
+	if input.StartTLS != nil {
+		provider.StartTLS = *input.StartTLS
+		connectionChanged = true
+	}
+	if input.Certificates != nil {
+		provider.Certificates = models.StringArray{}
+		for _, certificate := range input.Certificates {
+			if _, err := parseCertificatePEM(certificate); err != nil {
+				return fmt.Errorf("%w: certificates must be PEM encoded X.509 certificates", ErrIdentityProviderInvalid)
+			}
+			provider.Certificates = append(provider.Certificates, certificate)
+		}
+		connectionChanged = true
+	}
+	if input.BindDN != "" {
+		provider.BindDN = strings.TrimSpace(input.BindDN)
+		connectionChanged = true
+	}
+	if input.BindPassword != "" {
+		sealed, err := tokens.Seal(input.BindPassword)
+		if err != nil {
+			return err
+		}
+		provider.BindPasswordSealed = sealed
+		connectionChanged = true
+	}
+	if input.BaseDN != "" {
+		provider.BaseDN = strings.TrimSpace(input.BaseDN)
+	}
+	if input.UserFilter != "" {
+		if !strings.Contains(input.UserFilter, "{username}") || ldap.ValidateFilter(strings.ReplaceAll(input.UserFilter, "{username}", "x")) != nil {
+			return fmt.Errorf("%w: user_filter must be a valid LDAP filter with {username}", ErrIdentityProviderInvalid)
+		}
+		provider.UserFilter = input.UserFilter
+	}
+	if input.SubjectAttribute != "" {
+		provider.SubjectAttribute = strings.TrimSpace(input.SubjectAttribute)
+	}
+	if input.SyncGroups != nil {
+		provider.SyncGroups = *input.SyncGroups
+	}
+	if input.GroupBaseDN != "" {
+		provider.GroupBaseDN = strings.TrimSpace(input.GroupBaseDN)
+	}
+	if input.GroupFilter != "" {
+		if !strings.Contains(input.GroupFilter, "{dn}") || ldap.ValidateFilter(strings.ReplaceAll(input.GroupFilter, "{dn}", "x")) != nil {
+			return fmt.Errorf("%w: group_filter must be a valid LDAP filter with {dn}", ErrIdentityProviderInvalid)
+		}
+		provider.GroupFilter = input.GroupFilter
+	}
+	if input.AttributeMapping != nil {
+		provider.AttributeMapping = models.StringMap{}
+		for name, field := range input.AttributeMapping {
+			if name == "" || !slices.Contains(mappedUserFields, field) {
+				return fmt.Errorf("%w: attribute_mapping values must be one of %s", ErrIdentityProviderInvalid, strings.Join(mappedUserFields, ", "))
+			}
+			provider.AttributeMapping[name] = field
+		}
+	}
+
+	if provider.Name == "" || provider.Issuer == "" || provider.BaseDN == "" {
+		return fmt.Errorf("%w: name, issuer and base_dn are required", ErrIdentityProviderInvalid)
+	}
+
+	// The routed domains are what sends a sign-in to the directory
+	if len(provider.Domains) == 0 {
+		return fmt.Errorf("%w: domains are required for a directory", ErrIdentityProviderInvalid)
+	}
+
+	// Passwords must not cross the network in clear
+	parsed, _ := url.Parse(provider.Issuer)
+	if parsed.Scheme == "ldap" && !provider.StartTLS && !isLocalHost(parsed.Hostname()) {
+		return fmt.Errorf("%w: use ldaps:// or start_tls", ErrIdentityProviderInvalid)
+	}
+
+	if connectionChanged {
+		conn, err := s.dialDirectory(ctx, provider)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// dialDirectory connects to the directory and binds as the service account, or
+// anonymously when there is none
+func (s *FederationService) dialDirectory(ctx context.Context, provider *models.IdentityProvider) (*ldap.Conn, error) {
+	var roots *x509.CertPool
+	if len(provider.Certificates) > 0 {
+		roots = x509.NewCertPool()
+		for _, encoded := range provider.Certificates {
+			certificate, err := parseCertificatePEM(encoded)
+			if err != nil {
+				return nil, err
+			}
+			roots.AddCert(certificate)
+		}
+	}
+
+	conn, err := ldap.Dial(ctx, ldap.Config{
+		URL:      provider.Issuer,
+		StartTLS: provider.StartTLS,
+		RootCAs:  roots,
+		Timeout:  ldapTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentityProviderUnreachable, err)
+	}
+
+	if err := s.bindServiceAccount(conn, provider); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *FederationService) bindServiceAccount(conn *ldap.Conn, provider *models.IdentityProvider) error {
+	var password string
+	if provider.BindPasswordSealed != "" {
+		opened, err := tokens.Open(provider.BindPasswordSealed)
+		if err != nil {
+			return err
+		}
+		password = opened
+	}
+
+	if err := conn.Bind(provider.BindDN, password); err != nil {
+		return fmt.Errorf("%w: service account bind: %v", ErrIdentityProviderUnreachable, err)
+	}
+	return nil
+}
+
+// AuthenticateLDAP signs a user in against a directory: the service account finds the
+// single entry matching the email, then a bind as that entry checks the password.
+// Neither the password nor the entry is kept, only the mapped user fields and, when
+// syncing, the group memberships.
+func (s *FederationService) AuthenticateLDAP(ctx context.Context, tenant *models.Tenant, provider *models.IdentityProvider, email string, password string) (*models.User, error) {
+	if email == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := s.dialDirectory(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attributes := []string{provider.SubjectAttribute}
+	for name := range provider.AttributeMapping {
+		attributes = append(attributes, name)
+	}
+
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     provider.BaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(provider.UserFilter, "{username}", ldap.EscapeFilter(email)),
+		Attributes: attributes,
+		SizeLimit:  2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: user search: %v", ErrIdentityProviderUnreachable, err)
+	}
+
+	// Nobody, or an ambiguous filter, both look like a wrong password
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+
+	if err := conn.Bind(entry.DN, password); ldap.IsInvalidCredentials(err) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: user bind: %v", ErrIdentityProviderUnreachable, err)
+	}
+
+	var groups []string
+	if provider.SyncGroups {
+		groups, err = s.directoryGroups(conn, provider, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := s.resolveUser(tenant, provider, ldapClaims(provider, &entry, email))
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.SyncGroups {
+		if err := NewGroupService(s.db).SyncMemberships(tenant.ID, provider.ID, user.ID, groups); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// directoryGroups lists the names of the groups dn is a member of, searched as the
+// service account since users often cannot read groups
+func (s *FederationService) directoryGroups(conn *ldap.Conn, provider *models.IdentityProvider, dn string) ([]string, error) {
+	if err := s.bindServiceAccount(conn, provider); err != nil {
+		return nil, err
+	}
+
+	baseDN := provider.GroupBaseDN
+	if baseDN == "" {
+		baseDN = provider.BaseDN
+	}
+
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     baseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(provider.GroupFilter, "{dn}", ldap.EscapeFilter(dn)),
+		Attributes: []string{"cn"},
+		SizeLimit:  maxSyncedGroups,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: group search: %v", ErrIdentityProviderUnreachable, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, group := range entries {
+		if name := group.Get("cn"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ldapClaims maps the entry to the claims an ID token would carry. The subject is the
+// configured ID attribute, hex encoded when binary like objectGUID, or else the DN.
+func ldapClaims(provider *models.IdentityProvider, entry *ldap.Entry, email string) *oidc.Claims {
+	subject := entry.Get(provider.SubjectAttribute)
+	if subject != "" && !utf8.ValidString(subject) {
+		subject = hex.EncodeToString([]byte(subject))
+	}
+	if subject == "" {
+		subject = strings.ToLower(entry.DN)
+	}
+
+	claims := &oidc.Claims{Subject: subject}
+	for name, field := range provider.AttributeMapping {
+		value := entry.Get(name)
+		if value == "" {
+			continue
+		}
+
+		switch field {
+		case "email":
+			claims.Email = value
+		case "given_name":
+			claims.GivenName = value
+		case "family_name":
+			claims.FamilyName = value
+		case "locale":
+			claims.Locale = value
+		case "picture_url":
+			claims.Picture = value
+		}
+	}
+
+	// The filter matched the typed email, it stands in when no attribute is mapped
+	if claims.Email == "" {
+		claims.Email = email
+	}
+
+	claims.EmailVerified = vouchesFor(provider, claims.Email)
+	return claims
+}
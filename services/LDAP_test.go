@@ -0,0 +1,65 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/ldap"
+	"DigiPassAuthenticationApi/packages/models"
+	"testing"
+)
+
+func TestLDAPClaims(t *testing.T) {
+	provider := &models.IdentityProvider{
+		SubjectAttribute: "objectGUID",
+		AttributeMapping: defaultLDAPMapping,
+		Domains:          models.StringArray{"example.com"},
+	}
+
+	tests := []struct {
+		name        string
+		entry       ldap.Entry
+		email       string
+		wantSubject string
+		wantEmail   string
+		wantVouched bool
+	}{
+		{
+			name:        "binary objectGUID is hex encoded",
+			entry:       ldap.Entry{DN: "CN=Ada,OU=People,DC=example,DC=com", Attributes: map[string][]string{"objectGUID": {"\x8d\x2a\x1b\x4e\xff\x00"}, "mail": {"ada@example.com"}}},
+			email:       "ada@example.com",
+			wantSubject: "8d2a1b4eff00",
+			wantEmail:   "ada@example.com",
+			wantVouched: true,
+		},
+		{
+			name:        "without the ID attribute the DN is the subject",
+			entry:       ldap.Entry{DN: "CN=Ada,OU=People,DC=example,DC=com", Attributes: map[string][]string{"mail": {"ada@example.com"}}},
+			email:       "ada@example.com",
+			wantSubject: "cn=ada,ou=people,dc=example,dc=com",
+			wantEmail:   "ada@example.com",
+			wantVouched: true,
+		},
+		{
+			name:        "without a mail attribute the typed email stands in",
+			entry:       ldap.Entry{DN: "uid=ada,dc=example,dc=com", Attributes: map[string][]string{"objectGUID": {"ada-1"}}},
+			email:       "ada@example.com",
+			wantSubject: "ada-1",
+			wantEmail:   "ada@example.com",
+			wantVouched: true,
+		},
+		{
+			name:        "mail outside the provider's domains is not vouched for",
+			entry:       ldap.Entry{DN: "uid=ada,dc=example,dc=com", Attributes: map[string][]string{"objectGUID": {"ada-1"}, "MAIL": {"ada@elsewhere.example"}}},
+			email:       "ada@example.com",
+			wantSubject: "ada-1",
+			wantEmail:   "ada@elsewhere.example",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := ldapClaims(provider, &test.entry, test.email)
+			if claims.Subject != test.wantSubject || claims.Email != test.wantEmail || bool(claims.EmailVerified) != test.wantVouched {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+}
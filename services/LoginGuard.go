@@ -0,0 +1,250 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LockedError is returned while an account is locked after too many failures.
+// NewlyLocked is set on the failure that caused the lock, callers use it to send
+// the unlock email once.
+type LockedError struct {
+	Until       time.Time
+	NewlyLocked bool
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrAccountLocked, e.Until.UTC().Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// ThrottledError asks the client to wait before trying again
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// LoginAttempt identifies who is trying to sign in and from where. TenantID is nil
+// for console (AccountUser) logins, which always use the default lockout settings.
+type LoginAttempt struct {
+	TenantID  *uuid.UUID
+	Email     string
+	IPAddress string
+	UserAgent string
+	Settings  models.LockoutSettings
+}
+
+// Failures are counted by email rather than by user so unknown emails behave exactly like real ones
+func (a LoginAttempt) userKey() string {
+	email := strings.ToLower(strings.TrimSpace(a.Email))
+	if a.TenantID == nil {
+		return "console:" + email
+	}
+	return "user:" + a.TenantID.String() + ":" + email
+}
+
+func (a LoginAttempt) ipKey() string {
+	if a.TenantID == nil {
+		return "ip:console:" + a.IPAddress
+	}
+	return "ip:" + a.TenantID.String() + ":" + a.IPAddress
+}
+
+func (a LoginAttempt) tenantKey() string {
+	if a.TenantID == nil {
+		return ""
+	}
+	return "tenant:" + a.TenantID.String()
+}
+
+// LoginGuard applies brute-force protection around password checks: progressive
+// delays after a few failures, temporary locks per user and per IP, and delays
+// for every user of a tenant under a credential stuffing wave.
+type LoginGuard struct {
+	db    *gorm.DB
+	store throttle.Store
+}
+
+func NewLoginGuard(db *gorm.DB, store throttle.Store) *LoginGuard {
+	return &LoginGuard{db: db, store: store}
+}
+
+// throttleStore picks the optional store a service was given, Postgres by default
+func throttleStore(db *gorm.DB, store []throttle.Store) throttle.Store {
+	if len(store) > 0 && store[0] != nil {
+		return store[0]
+	}
+	return throttle.NewPostgresStore(db)
+}
+
+// Check runs before the password is verified and refuses locked or too early attempts
+func (g *LoginGuard) Check(attempt LoginAttempt, now time.Time) error {
+	user, err := g.store.Get(attempt.userKey())
+	if err != nil {
+		return err
+	}
+	if user.Locked(now) {
+		return &LockedError{Until: *user.LockedUntil}
+	}
+
+	ip, err := g.store.Get(attempt.ipKey())
+	if err != nil {
+		return err
+	}
+	if ip.Locked(now) {
+		return &ThrottledError{RetryAfter: ip.LockedUntil.Sub(now)}
+	}
+
+	settings := attempt.Settings
+	delayAfter := settings.DelayAfter
+
+	// While the tenant is under attack every user is delayed from the first failure
+	if key := attempt.tenantKey(); key != "" && settings.TenantDelayThreshold > 0 {
+		tenant, err := g.store.Get(key)
+		if err != nil {
+			return err
+		}
+		window := time.Duration(settings.Window) * time.Second
+		if tenant.Failures >= settings.TenantDelayThreshold && now.Sub(tenant.LastFailureAt) <= window {
+			delayAfter = 1
+		}
+	}
+
+	if delay := progressiveDelay(user.Failures, delayAfter, settings.MaxDelay); delay > 0 {
+		if next := user.LastFailureAt.Add(delay); now.Before(next) {
+			return &ThrottledError{RetryAfter: next.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// Failed records a failed attempt. It returns a LockedError when this failure locked
+// the account. userID or accountUserID identify the account for the audit log when
+// the email exists.
+func (g *LoginGuard) Failed(attempt LoginAttempt, userID *uuid.UUID, accountUserID *uuid.UUID, now time.Time) error {
+	settings := attempt.Settings
+	window := time.Duration(settings.Window) * time.Second
+	duration := time.Duration(settings.Duration) * time.Second
+
+	user, err := g.store.RecordFailure(attempt.userKey(), window, now)
+	if err != nil {
+		return err
+	}
+
+	ip, err := g.store.RecordFailure(attempt.ipKey(), window, now)
+	if err != nil {
+		return err
+	}
+
+	if key := attempt.tenantKey(); key != "" {
+		if _, err := g.store.RecordFailure(key, window, now); err != nil {
+			return err
+		}
+	}
+
+	if settings.MaxFailuresPerIP > 0 && ip.Failures >= settings.MaxFailuresPerIP && !ip.Locked(now) {
+		until := now.Add(duration)
+		if err := g.store.Lock(attempt.ipKey(), until); err != nil {
+			return err
+		}
+		err := g.audit(attempt, "login.ip_locked", nil, nil, map[string]any{
+			"failures":     ip.Failures,
+			"locked_until": until,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.MaxFailures > 0 && user.Failures >= settings.MaxFailures && !user.Locked(now) {
+		until := now.Add(duration)
+		if err := g.store.Lock(attempt.userKey(), until); err != nil {
+			return err
+		}
+		err := g.audit(attempt, "login.locked", userID, accountUserID, map[string]any{
+			"email":        attempt.Email,
+			"failures":     user.Failures,
+			"locked_until": until,
+		})
+		if err != nil {
+			return err
+		}
+		return &LockedError{Until: until, NewlyLocked: true}
+	}
+
+	return nil
+}
+
+// Succeeded clears the user's failures, IP and tenant counters age out on their own
+func (g *LoginGuard) Succeeded(attempt LoginAttempt) error {
+	return g.store.Reset(attempt.userKey())
+}
+
+// Unlock lifts a lock before it expires, after the owner followed the unlock email
+func (g *LoginGuard) Unlock(attempt LoginAttempt, userID *uuid.UUID, accountUserID *uuid.UUID) error {
+	if err := g.store.Reset(attempt.userKey()); err != nil {
+		return err
+	}
+
+	return g.audit(attempt, "login.unlocked", userID, accountUserID, map[string]any{
+		"email": attempt.Email,
+	})
+}
+
+// PurgeStale drops counters that no longer affect any login
+func (g *LoginGuard) PurgeStale(now time.Time) (int64, error) {
+	return g.store.Cleanup(now.Add(-24 * time.Hour))
+}
+
+func (g *LoginGuard) audit(attempt LoginAttempt, action string, userID *uuid.UUID, accountUserID *uuid.UUID, metadata map[string]any) error {
+	entry := &models.AuditLog{
+		TenantID:      attempt.TenantID,
+		UserID:        userID,
+		AccountUserID: accountUserID,
+		Action:        action,
+		IPAddress:     attempt.IPAddress,
+		UserAgent:     attempt.UserAgent,
+	}
+
+	switch {
+	case userID != nil:
+		entry.ResourceType = "user"
+		entry.ResourceID = userID
+	case accountUserID != nil:
+		entry.ResourceType = "account_user"
+		entry.ResourceID = accountUserID
+	}
+
+	return NewAuditService(g.db).Record(entry, metadata)
+}
+
+// progressiveDelay doubles from one second once failures reach delayAfter, capped at maxDelay seconds
+func progressiveDelay(failures int, delayAfter int, maxDelay uint32) time.Duration {
+	if delayAfter < 1 || failures < delayAfter || maxDelay == 0 {
+		return 0
+	}
+
+	limit := time.Duration(maxDelay) * time.Second
+	delay := time.Second
+	for i := delayAfter; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
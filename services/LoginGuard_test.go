@@ -0,0 +1,189 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestProgressiveDelay(t *testing.T) {
+	tests := []struct {
+		failures   int
+		delayAfter int
+		maxDelay   uint32
+		want       time.Duration
+	}{
+		{failures: 0, delayAfter: 3, maxDelay: 30, want: 0},
+		{failures: 2, delayAfter: 3, maxDelay: 30, want: 0},
+		{failures: 3, delayAfter: 3, maxDelay: 30, want: time.Second},
+		{failures: 4, delayAfter: 3, maxDelay: 30, want: 2 * time.Second},
+		{failures: 7, delayAfter: 3, maxDelay: 30, want: 16 * time.Second},
+		{failures: 8, delayAfter: 3, maxDelay: 30, want: 30 * time.Second},
+		{failures: 1000, delayAfter: 3, maxDelay: 30, want: 30 * time.Second},
+		{failures: 5, delayAfter: 0, maxDelay: 30, want: 0},
+		{failures: 5, delayAfter: 3, maxDelay: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := progressiveDelay(tt.failures, tt.delayAfter, tt.maxDelay); got != tt.want {
+			t.Errorf("progressiveDelay(%d, %d, %d) = %s, want %s", tt.failures, tt.delayAfter, tt.maxDelay, got, tt.want)
+		}
+	}
+}
+
+func newTestGuard(t *testing.T) (*LoginGuard, *throttle.MemoryStore) {
+	store := throttle.NewMemoryStore()
+	return NewLoginGuard(dryRunDB(t), store), store
+}
+
+func testAttempt(settings models.LockoutSettings) LoginAttempt {
+	tenantID := uuid.New()
+	return LoginAttempt{
+		TenantID:  &tenantID,
+		Email:     "Alice@Example.com ",
+		IPAddress: "203.0.113.7",
+		Settings:  settings,
+	}
+}
+
+func TestLoginGuardLocksAfterMaxFailures(t *testing.T) {
+	guard, _ := newTestGuard(t)
+	settings := models.LockoutSettings{MaxFailures: 3, Duration: 600, Window: 900}
+	attempt := testAttempt(settings)
+	userID := uuid.New()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i < settings.MaxFailures; i++ {
+		if err := guard.Failed(attempt, &userID, nil, now); err != nil {
+			t.Fatalf("failure %d: %v", i, err)
+		}
+	}
+
+	var locked *LockedError
+	err := guard.Failed(attempt, &userID, nil, now)
+	if !errors.As(err, &locked) || !locked.NewlyLocked {
+		t.Fatalf("failure %d = %v, want a new LockedError", settings.MaxFailures, err)
+	}
+	if want := now.Add(10 * time.Minute); !locked.Until.Equal(want) {
+		t.Fatalf("locked until %s, want %s", locked.Until, want)
+	}
+
+	// The email is matched case and whitespace insensitively
+	attempt.Email = "alice@example.com"
+	err = guard.Check(attempt, now.Add(time.Minute))
+	if !errors.As(err, &locked) || locked.NewlyLocked {
+		t.Fatalf("Check while locked = %v, want an existing LockedError", err)
+	}
+
+	if err := guard.Check(attempt, now.Add(11*time.Minute)); err != nil {
+		t.Fatalf("Check after the lock expired = %v", err)
+	}
+}
+
+func TestLoginGuardUnlockAndSuccessReset(t *testing.T) {
+	guard, _ := newTestGuard(t)
+	settings := models.LockoutSettings{MaxFailures: 2, Duration: 600, Window: 900, DelayAfter: 1, MaxDelay: 30}
+	attempt := testAttempt(settings)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	guard.Failed(attempt, nil, nil, now)
+	if err := guard.Failed(attempt, nil, nil, now); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("second failure = %v, want ErrAccountLocked", err)
+	}
+
+	if err := guard.Unlock(attempt, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Check(attempt, now); err != nil {
+		t.Fatalf("Check after Unlock = %v", err)
+	}
+
+	guard.Failed(attempt, nil, nil, now)
+	if err := guard.Check(attempt, now); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Check right after a failure = %v, want ErrTooManyAttempts", err)
+	}
+	if err := guard.Succeeded(attempt); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Check(attempt, now); err != nil {
+		t.Fatalf("Check after Succeeded = %v", err)
+	}
+}
+
+func TestLoginGuardDelays(t *testing.T) {
+	guard, _ := newTestGuard(t)
+	settings := models.LockoutSettings{Window: 900, DelayAfter: 2, MaxDelay: 30}
+	attempt := testAttempt(settings)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	guard.Failed(attempt, nil, nil, now)
+	if err := guard.Check(attempt, now); err != nil {
+		t.Fatalf("Check below DelayAfter = %v", err)
+	}
+
+	guard.Failed(attempt, nil, nil, now)
+	var throttled *ThrottledError
+	if err := guard.Check(attempt, now.Add(500*time.Millisecond)); !errors.As(err, &throttled) {
+		t.Fatalf("Check inside the delay = %v, want ThrottledError", err)
+	}
+	if throttled.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("RetryAfter = %s, want 500ms", throttled.RetryAfter)
+	}
+	if err := guard.Check(attempt, now.Add(time.Second)); err != nil {
+		t.Fatalf("Check after the delay = %v", err)
+	}
+}
+
+func TestLoginGuardLocksIP(t *testing.T) {
+	guard, _ := newTestGuard(t)
+	settings := models.LockoutSettings{Duration: 600, Window: 900, MaxFailuresPerIP: 3}
+	attempt := testAttempt(settings)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Spraying different emails from one IP locks the IP, not the accounts
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		attempt.Email = email
+		if err := guard.Failed(attempt, nil, nil, now); err != nil {
+			t.Fatalf("%s: %v", email, err)
+		}
+	}
+
+	attempt.Email = "d@example.com"
+	if err := guard.Check(attempt, now); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Check from a locked IP = %v, want ErrTooManyAttempts", err)
+	}
+
+	attempt.IPAddress = "198.51.100.1"
+	if err := guard.Check(attempt, now); err != nil {
+		t.Fatalf("Check from another IP = %v", err)
+	}
+}
+
+func TestLoginGuardTenantWideDelay(t *testing.T) {
+	guard, _ := newTestGuard(t)
+	settings := models.LockoutSettings{Window: 900, DelayAfter: 5, MaxDelay: 30, TenantDelayThreshold: 3}
+	attempt := testAttempt(settings)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		attempt.Email = email
+		attempt.IPAddress = "203.0.113." + string(rune('1'+i))
+		guard.Failed(attempt, nil, nil, now)
+	}
+
+	// A user with a single failure is delayed once the tenant crossed the threshold
+	attempt.Email = "a@example.com"
+	if err := guard.Check(attempt, now); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Check under a tenant wide wave = %v, want ErrTooManyAttempts", err)
+	}
+
+	// Console logins have no tenant counter
+	attempt.TenantID = nil
+	if err := guard.Check(attempt, now); err != nil {
+		t.Fatalf("console Check = %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/packages/totp"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// MFAChallengeLifetime is how long the second step of a sign-in may take
+	MFAChallengeLifetime = 5 * time.Minute
+
+	RecoveryCodeCount = 10
+
+	// TOTPSkew accepts codes from one period before or after now for clock drift
+	TOTPSkew = 1
+
+	mfaChallengeUser    = "mfa-user"
+	mfaChallengeEnroll  = "mfa-enroll"
+	mfaChallengeConsole = "mfa-console"
+)
+
+// Recovery codes avoid look-alike characters, they are typed from paper
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// MFARequiredError is returned by a password login when a second factor is still
+// needed. Token is the signed challenge that the verify step exchanges for a session.
+// Enroll is set when the tenant requires a second factor the user does not have yet,
+// the token then goes to the enrollment steps instead.
+type MFARequiredError struct {
+	Token  string
+	Enroll bool
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrMFARequired
+}
+
+// MFAOwner is either a tenant User or an AccountUser, exactly one ID is set
+type MFAOwner struct {
+	UserID        *uuid.UUID
+	AccountUserID *uuid.UUID
+}
+
+func UserMFAOwner(userID uuid.UUID) MFAOwner {
+	return MFAOwner{UserID: &userID}
+}
+
+func AccountUserMFAOwner(accountUserID uuid.UUID) MFAOwner {
+	return MFAOwner{AccountUserID: &accountUserID}
+}
+
+func (o MFAOwner) scope(db *gorm.DB) *gorm.DB {
+	if o.UserID != nil {
+		return db.Where("user_id = ?", *o.UserID)
+	}
+	return db.Where("account_user_id = ?", *o.AccountUserID)
+}
+
+// Failed codes are throttled per owner, the password step has its own counters
+func (o MFAOwner) throttleKey() string {
+	if o.UserID != nil {
+		return "mfa:user:" + o.UserID.String()
+	}
+	return "mfa:console:" + o.AccountUserID.String()
+}
+
+// TOTPEnrollment is shown once to the user, URI is rendered as a QR code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAService manages second factors: TOTP authenticators and recovery codes
+type MFAService struct {
+	db    *gorm.DB
+	store throttle.Store
+}
+
+// NewMFAService optionally takes the login throttle store, Postgres by default
+func NewMFAService(db *gorm.DB, store ...throttle.Store) *MFAService {
+	return &MFAService{db: db, store: throttleStore(db, store)}
+}
+
+// TOTPAllowed reports whether the tenant lets its users enroll an authenticator app
+func TOTPAllowed(tenant *models.Tenant) bool {
+	return slices.Contains(tenant.Settings.MFA.Methods, "totp")
+}
+
+// MFASatisfied reports whether a sign-in with these methods meets the tenant's MFA
+// policy: a TOTP or recovery code, or a user verifying passkey
+func MFASatisfied(tenant *models.Tenant, amr []string) bool {
+	return !tenant.Settings.MFA.Required || slices.Contains(amr, models.AMROTP) || slices.Contains(amr, models.AMRMultiFactor)
+}
+
+// StartTOTPEnrollment creates a new unconfirmed secret, replacing any earlier
+// unconfirmed one. issuer and account label the entry in the authenticator app.
+func (s *MFAService) StartTOTPEnrollment(owner MFAOwner, issuer string, account string) (*TOTPEnrollment, error) {
+	enabled, err := s.HasTOTP(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := tokens.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &models.TOTPCredential{
+		UserID:        owner.UserID,
+		AccountUserID: owner.AccountUserID,
+		SecretSealed:  sealed,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := owner.scope(tx).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(credential).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totp.URI(issuer, account, secret)}, nil
+}
+
+// ConfirmTOTP proves the authenticator works, enables it and returns the recovery
+// codes. They are only ever available in plain text here.
+func (s *MFAService) ConfirmTOTP(owner MFAOwner, code string) ([]string, error) {
+	var credential models.TOTPCredential
+	err := owner.scope(s.db).Where("confirmed_at IS NULL").First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnabled
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := s.checkTOTP(&credential, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&credential).
+			Where("confirmed_at IS NULL").
+			Updates(map[string]any{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrMFANotEnabled
+		}
+
+		codes, err = replaceRecoveryCodes(tx, owner)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// HasTOTP reports whether the owner has a confirmed authenticator
+func (s *MFAService) HasTOTP(owner MFAOwner) (bool, error) {
+	var count int64
+	err := owner.scope(s.db.Model(&models.TOTPCredential{})).
+		Where("confirmed_at IS NOT NULL").
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Verify accepts a current TOTP code or an unused recovery code. Every code is
+// single use and failures lock the second factor like a password would, with the
+// same settings: the tenant's lockout for users, the defaults for the console.
+func (s *MFAService) Verify(owner MFAOwner, code string, settings models.LockoutSettings) error {
+	now := time.Now()
+	key := owner.throttleKey()
+
+	counter, err := s.store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if counter.Locked(now) {
+		return &LockedError{Until: *counter.LockedUntil}
+	}
+
+	err = s.verifyCode(owner, code, now)
+	if err == nil {
+		return s.store.Reset(key)
+	}
+
+	if !errors.Is(err, ErrMFAInvalidCode) {
+		return err
+	}
+
+	counter, recordErr := s.store.RecordFailure(key, time.Duration(settings.Window)*time.Second, now)
+	if recordErr != nil {
+		return recordErr
+	}
+
+	if settings.MaxFailures > 0 && counter.Failures >= settings.MaxFailures {
+		until := now.Add(time.Duration(settings.Duration) * time.Second)
+		if lockErr := s.store.Lock(key, until); lockErr != nil {
+			return lockErr
+		}
+		return &LockedError{Until: until}
+	}
+
+	return err
+}
+
+func (s *MFAService) verifyCode(owner MFAOwner, code string, now time.Time) error {
+	var credential models.TOTPCredential
+	err := owner.scope(s.db).Where("confirmed_at IS NOT NULL").First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMFANotEnabled
+	}
+
+	if err != nil {
+		return err
+	}
+
+	code = totp.Normalize(code)
+	if len(code) != totp.Digits {
+		return s.useRecoveryCode(owner, code, now)
+	}
+
+	step, err := s.checkTOTP(&credential, code, now)
+	if err != nil {
+		return err
+	}
+
+	// Only one request may move the step forward, a replayed code matches no row
+	result := s.db.Model(&credential).
+		Where("last_used_step < ?", step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrMFAInvalidCode
+	}
+
+	return nil
+}
+
+// checkTOTP returns the matched time step, refusing steps already used
+func (s *MFAService) checkTOTP(credential *models.TOTPCredential, code string, now time.Time) (int64, error) {
+	secret, err := tokens.Open(credential.SecretSealed)
+	if err != nil {
+		return 0, err
+	}
+
+	step, ok := totp.Validate(secret, code, now, TOTPSkew)
+	if !ok || step <= credential.LastUsedStep {
+		return 0, ErrMFAInvalidCode
+	}
+
+	return step, nil
+}
+
+func (s *MFAService) useRecoveryCode(owner MFAOwner, code string, now time.Time) error {
+	result := owner.scope(s.db.Model(&models.RecoveryCode{})).
+		Where("code_hash = ? AND used_at IS NULL", tokens.Hash(normalizeRecoveryCode(code))).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrMFAInvalidCode
+	}
+
+	return nil
+}
+
+// DisableTOTP removes the authenticator and recovery codes. Callers re-authenticate
+// the owner first, code proves they still hold the second factor.
+func (s *MFAService) DisableTOTP(owner MFAOwner, code string, settings models.LockoutSettings) error {
+	if err := s.Verify(owner, code, settings); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := owner.scope(tx).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		return owner.scope(tx).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes invalidates every previous recovery code
+func (s *MFAService) RegenerateRecoveryCodes(owner MFAOwner, code string, settings models.LockoutSettings) ([]string, error) {
+	if err := s.Verify(owner, code, settings); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, owner)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RecoveryCodesRemaining counts the unused recovery codes
+func (s *MFAService) RecoveryCodesRemaining(owner MFAOwner) (int64, error) {
+	var count int64
+	err := owner.scope(s.db.Model(&models.RecoveryCode{})).
+		Where("used_at IS NULL").
+		Count(&count).Error
+	return count, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, owner MFAOwner) ([]string, error) {
+	if err := owner.scope(tx).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	records := make([]models.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+		records[i] = models.RecoveryCode{
+			UserID:        owner.UserID,
+			AccountUserID: owner.AccountUserID,
+			CodeHash:      tokens.Hash(normalizeRecoveryCode(code)),
+		}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns ten characters grouped as xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	// Bytes from the incomplete last round of the alphabet are dropped, a plain modulo
+	// would make the first 256 % 31 characters more likely than the rest
+	limit := 256 - 256%len(recoveryAlphabet)
+
+	var b strings.Builder
+	buf := make([]byte, 16)
+	for n := 0; n < 10; {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, c := range buf {
+			if int(c) >= limit || n == 10 {
+				continue
+			}
+			if n == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryAlphabet[c%byte(len(recoveryAlphabet))])
+			n++
+		}
+	}
+	return b.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// newMFAChallenge binds the second step to the subject that passed the password step
+func newMFAChallenge(purpose string, subject string) error {
+	token, err := tokens.Sign(purpose, subject, MFAChallengeLifetime)
+	if err != nil {
+		return err
+	}
+	return &MFARequiredError{Token: token, Enroll: purpose == mfaChallengeEnroll}
+}
+
+// verifyMFAChallenge returns the subject fields of a challenge, ":" separated
+func verifyMFAChallenge(purpose string, token string) ([]string, error) {
+	subject, err := tokens.VerifySigned(purpose, token)
+	if err != nil {
+		return nil, ErrMFAChallengeInvalid
+	}
+	return strings.Split(subject, ":"), nil
+}
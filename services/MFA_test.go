@@ -0,0 +1,91 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/packages/totp"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestVerifyCodeIgnoresWhitespace(t *testing.T) {
+	t.Setenv("TOKEN_SIGNING_SECRET", "test-secret")
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := tokens.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	code, err := totp.Code(secret, totp.Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+
+	expectCredential := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "totp_credentials" WHERE user_id = \$1 AND confirmed_at IS NOT NULL`).
+			WithArgs(userID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret_sealed", "last_used_step"}).
+				AddRow(uuid.New(), userID, sealed, 0))
+	}
+
+	// A code typed the way apps display it is checked as a code, not as a recovery code
+	t.Run("valid", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectCredential(mock)
+		mock.ExpectExec(`UPDATE "totp_credentials" SET "last_used_step"=\$1 WHERE last_used_step < \$2`).
+			WithArgs(totp.Step(now), totp.Step(now), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := NewMFAService(db).verifyCode(UserMFAOwner(userID), " "+code[:3]+" "+code[3:]+"\n", now); err != nil {
+			t.Fatalf("verifyCode = %v", err)
+		}
+	})
+
+	t.Run("wrong", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectCredential(mock)
+
+		wrong := "000 000"
+		if code == "000000" {
+			wrong = "111 111"
+		}
+		if err := NewMFAService(db).verifyCode(UserMFAOwner(userID), wrong, now); !errors.Is(err, ErrMFAInvalidCode) {
+			t.Fatalf("verifyCode = %v, want ErrMFAInvalidCode", err)
+		}
+	})
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	format := regexp.MustCompile(`^[` + recoveryAlphabet + `]{5}-[` + recoveryAlphabet + `]{5}$`)
+
+	// Every character of the alphabet is drawn about as often as the others
+	counts := map[rune]int{}
+	const rounds = 3000
+	for i := 0; i < rounds; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil || !format.MatchString(code) {
+			t.Fatalf("generateRecoveryCode = %s, %v", code, err)
+		}
+		for _, c := range strings.ReplaceAll(code, "-", "") {
+			counts[c]++
+		}
+	}
+
+	expected := rounds * 10 / len(recoveryAlphabet)
+	for _, c := range recoveryAlphabet {
+		if counts[c] < expected*3/4 || counts[c] > expected*5/4 {
+			t.Errorf("%c drawn %d times, want about %d", c, counts[c], expected)
+		}
+	}
+}
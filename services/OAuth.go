@@ -0,0 +1,500 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/oidc"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OAuthError is an error response of the authorization or token endpoint, Code is
+// one of the error codes of RFC 6749 4.1.2.1 and 5.2 or OpenID Connect Core 3.1.2.6
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code string, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// AuthorizeRequest is an authorization request of the code flow
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+// TokenResponse is the successful answer of the token endpoint (RFC 6749 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthService runs the authorization code flow: codes for signed in users, and
+// access, ID and refresh tokens in exchange for them
+type OAuthService struct {
+	db *gorm.DB
+}
+
+func NewOAuthService(db *gorm.DB) *OAuthService {
+	return &OAuthService{db: db}
+}
+
+// AuthorizeClient finds the client and checks the redirect_uri. Until both are known
+// to be good errors are shown to the user, never sent to the redirect_uri.
+func (s *OAuthService) AuthorizeClient(tenant *models.Tenant, request AuthorizeRequest) (*models.Client, error) {
+	client, err := NewClientService(s.db).GetClientByClientID(tenant.ID, request.ClientID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, ErrInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Status != "active" {
+		return nil, ErrInvalidClient
+	}
+
+	if !client.HasRedirectURI(request.RedirectURI) {
+		return nil, oauthError("invalid_request", "redirect_uri is not registered for the client")
+	}
+	return client, nil
+}
+
+// ValidateAuthorizeRequest checks the rest of the request, its errors go back to the client
+func (s *OAuthService) ValidateAuthorizeRequest(tenant *models.Tenant, client *models.Client, request AuthorizeRequest) error {
+	if request.ResponseType != "code" || !client.AllowsResponseType("code") {
+		return oauthError("unsupported_response_type", "only the code response type is supported")
+	}
+
+	if !client.AllowsGrantType("authorization_code") || !slices.Contains(tenant.Settings.AllowedGrantTypes, "authorization_code") {
+		return oauthError("unauthorized_client", "the client may not use the authorization code flow")
+	}
+
+	scopes := strings.Fields(request.Scope)
+	if len(scopes) == 0 {
+		return oauthError("invalid_scope", "scope is required")
+	}
+	for _, scope := range scopes {
+		if !client.Scopes.Contains(scope) {
+			return oauthError("invalid_scope", "scope "+scope+" is not allowed for the client")
+		}
+	}
+
+	// PKCE is required from public clients, and only S256 is accepted from anyone
+	if request.CodeChallenge == "" {
+		if !client.IsConfidential {
+			return oauthError("invalid_request", "code_challenge is required")
+		}
+	} else if request.CodeChallengeMethod != "S256" {
+		return oauthError("invalid_request", "code_challenge_method must be S256")
+	}
+
+	return nil
+}
+
+// CreateCode issues the authorization code for the signed in session. Only its hash is stored.
+func (s *OAuthService) CreateCode(tenant *models.Tenant, client *models.Client, session *models.Session, request AuthorizeRequest) (string, error) {
+	code, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	record := &models.AuthorizationCode{
+		Code:                tokens.Hash(code),
+		ClientID:            client.ID,
+		UserID:              session.UserID,
+		RedirectURI:         request.RedirectURI,
+		Scopes:              strings.Join(strings.Fields(request.Scope), " "),
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		ExpiresAt:           time.Now().Add(time.Duration(tenant.Settings.Tokens.AuthorizationCodeTTL) * time.Second),
+		Nonce:               request.Nonce,
+		SessionID:           &session.ID,
+	}
+
+	if err := s.db.Create(record).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// AuthenticateClient checks the credentials sent to the token endpoint. Confidential
+// clients need their secret, public clients must not send one.
+func (s *OAuthService) AuthenticateClient(tenant *models.Tenant, clientID string, secret string) (*models.Client, error) {
+	clientService := NewClientService(s.db)
+	client, err := clientService.GetClientByClientID(tenant.ID, clientID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Status != "active" {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+
+	if client.IsConfidential && !clientService.VerifySecret(client, secret) {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+
+	if !client.IsConfidential && secret != "" {
+		return nil, oauthError("invalid_client", "public clients do not have a secret")
+	}
+
+	return client, nil
+}
+
+// ExchangeCode redeems an authorization code once. The redirect_uri must be the one
+// of the authorization request and verifier must match its PKCE challenge.
+func (s *OAuthService) ExchangeCode(tenant *models.Tenant, issuer string, client *models.Client, code string, redirectURI string, verifier string) (*TokenResponse, error) {
+	if !client.AllowsGrantType("authorization_code") {
+		return nil, oauthError("unauthorized_client", "the client may not use the authorization code grant")
+	}
+
+	var record models.AuthorizationCode
+	err := s.db.Where("code = ?", tokens.Hash(code)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, oauthError("invalid_grant", "authorization code is invalid")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.ClientID != client.ID || record.UsedAt != nil || now.After(record.ExpiresAt) || record.SessionID == nil {
+		return nil, oauthError("invalid_grant", "authorization code is invalid, expired or already used")
+	}
+
+	if record.RedirectURI != redirectURI {
+		return nil, oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+
+	if record.CodeChallenge != "" {
+		if verifier == "" || subtle.ConstantTimeCompare([]byte(oidc.CodeChallenge(verifier)), []byte(record.CodeChallenge)) != 1 {
+			return nil, oauthError("invalid_grant", "code_verifier does not match the code_challenge")
+		}
+	} else if verifier != "" {
+		return nil, oauthError("invalid_grant", "the authorization request had no code_challenge")
+	}
+
+	// Only one exchange can mark the code used
+	result := s.db.Model(&record).Where("used_at IS NULL").Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, oauthError("invalid_grant", "authorization code is invalid, expired or already used")
+	}
+
+	session, user, err := s.activeSession(tenant, *record.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The code starts a new family of refresh tokens
+	return s.issueTokens(tenant, issuer, client, user, session, record.Scopes, record.Nonce, &record.ID, uuid.New())
+}
+
+// Refresh rotates a refresh token: the old one is revoked and a new one issued with
+// the access token. It stops working once the session it came from ended. A rotated
+// out token that comes back was copied, so the whole family is revoked (RFC 9700 4.14).
+func (s *OAuthService) Refresh(tenant *models.Tenant, issuer string, client *models.Client, refreshToken string) (*TokenResponse, error) {
+	if !client.AllowsGrantType("refresh_token") || !slices.Contains(tenant.Settings.AllowedGrantTypes, "refresh_token") {
+		return nil, oauthError("unauthorized_client", "the client may not use refresh tokens")
+	}
+
+	var record models.RefreshToken
+	err := s.db.Where("token_hash = ?", tokens.Hash(refreshToken)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, oauthError("invalid_grant", "refresh token is invalid")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.ClientID != client.ID || now.After(record.ExpiresAt) || record.SessionID == nil {
+		return nil, oauthError("invalid_grant", "refresh token is invalid, expired or revoked")
+	}
+
+	if record.RevokedAt != nil {
+		return nil, s.revokeFamily(record.FamilyID, now)
+	}
+
+	result := s.db.Model(&record).Where("revoked_at IS NULL").Update("revoked_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// Revoked in the meantime by a concurrent request with the same token
+	if result.RowsAffected == 0 {
+		return nil, s.revokeFamily(record.FamilyID, now)
+	}
+
+	session, user, err := s.activeSession(tenant, *record.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(tenant, issuer, client, user, session, record.Scopes, "", nil, record.FamilyID)
+}
+
+// revokeFamily revokes every refresh token of the family and the access tokens issued
+// with them, then answers invalid_grant
+func (s *OAuthService) revokeFamily(familyID uuid.UUID, now time.Time) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		family := tx.Model(&models.RefreshToken{}).Select("access_token_id").Where("family_id = ? AND access_token_id IS NOT NULL", familyID)
+
+		err := tx.Model(&models.AccessToken{}).
+			Where("id IN (?) AND revoked_at IS NULL", family).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+	return oauthError("invalid_grant", "refresh token is invalid, expired or revoked")
+}
+
+// AuthenticateAccessToken checks a bearer access token issued by the tenant and
+// returns its record with the Client and User loaded
+func (s *OAuthService) AuthenticateAccessToken(tenant *models.Tenant, issuer string, raw string) (*models.AccessToken, error) {
+	keys, err := NewSigningKeyService(s.db).VerificationKeys(tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims jwt.AccessTokenClaims
+	if err := jwt.Verify(raw, jwt.TypeAccessToken, keys, time.Now(), &claims); err != nil {
+		return nil, ErrAccessTokenInvalid
+	}
+	if claims.Iss != issuer {
+		return nil, ErrAccessTokenInvalid
+	}
+
+	var token models.AccessToken
+	err = s.db.Preload("Client").Preload("User").Where("token_hash = ?", tokens.Hash(raw)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAccessTokenInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) || token.Client.TenantID != tenant.ID ||
+		token.User == nil || token.User.TenantID != tenant.ID || token.User.Status != "active" {
+		return nil, ErrAccessTokenInvalid
+	}
+	return &token, nil
+}
+
+// activeSession loads the sign-in tokens are issued from, signing out ends their issuance
+func (s *OAuthService) activeSession(tenant *models.Tenant, sessionID uuid.UUID) (*models.Session, *models.User, error) {
+	var session models.Session
+	err := s.db.Preload("User").Where("id = ?", sessionID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, oauthError("invalid_grant", "the sign-in has ended")
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) ||
+		session.User.TenantID != tenant.ID || session.User.Status != "active" {
+		return nil, nil, oauthError("invalid_grant", "the sign-in has ended")
+	}
+	return &session, &session.User, nil
+}
+
+// issueTokens signs the access token, and the ID token when openid was granted. A
+// refresh token is added for clients allowed to use them. Codes and refresh tokens
+// are already spent at this point, so a failure here means signing in again.
+func (s *OAuthService) issueTokens(tenant *models.Tenant, issuer string, client *models.Client, user *models.User, session *models.Session, scope string, nonce string, codeID *uuid.UUID, familyID uuid.UUID) (*TokenResponse, error) {
+	key, err := NewSigningKeyService(s.db).TokenKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	settings := tenant.Settings.Tokens
+	accessExpiresAt := now.Add(time.Duration(settings.AccessTokenTTL) * time.Second)
+	scopes := strings.Fields(scope)
+
+	authorization, err := NewAuthorizationService(s.db).TokenClaims(tenant.ID, issuer, client, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := jwt.Sign(key, jwt.TypeAccessToken, jwt.AccessTokenClaims{
+		PublicClaims: jwt.PublicClaims{
+			Iss: issuer,
+			Sub: user.ID.String(),
+			Aud: client.ClientID,
+			Exp: accessExpiresAt.Unix(),
+			Iat: now.Unix(),
+			Jti: uuid.NewString(),
+		},
+		AuthorizationClaims: authorization,
+		ClientID:            client.ClientID,
+		Scope:               scope,
+		AuthTime:            session.CreatedAt.Unix(),
+		ACR:                 session.ACR,
+		AMR:                 session.AMR,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(settings.AccessTokenTTL),
+		Scope:       scope,
+	}
+
+	var idToken *models.IDToken
+	if slices.Contains(scopes, "openid") {
+		var claims jwt.IDTokenClaims
+		idToken, claims = newIDToken(issuer, client, user, session, scopes, nonce, accessToken, now, settings.IDTokenTTL)
+		claims.AuthorizationClaims = authorization
+
+		response.IDToken, err = jwt.Sign(key, jwt.TypeIDToken, claims)
+		if err != nil {
+			return nil, err
+		}
+		idToken.TokenHash = tokens.Hash(response.IDToken)
+		idToken.AuthorizationCodeID = codeID
+	}
+
+	var refreshToken string
+	if client.AllowsGrantType("refresh_token") && slices.Contains(tenant.Settings.AllowedGrantTypes, "refresh_token") && settings.RefreshTokenTTL > 0 {
+		refreshToken, err = tokens.Generate()
+		if err != nil {
+			return nil, err
+		}
+		response.RefreshToken = refreshToken
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		access := &models.AccessToken{
+			TokenHash: tokens.Hash(accessToken),
+			ClientID:  client.ID,
+			UserID:    &user.ID,
+			Scopes:    scope,
+			ExpiresAt: accessExpiresAt,
+			SessionID: &session.ID,
+		}
+		if err := tx.Create(access).Error; err != nil {
+			return err
+		}
+
+		if idToken != nil {
+			if err := tx.Create(idToken).Error; err != nil {
+				return err
+			}
+		}
+
+		if refreshToken == "" {
+			return nil
+		}
+		return tx.Create(&models.RefreshToken{
+			TokenHash:     tokens.Hash(refreshToken),
+			AccessTokenID: &access.ID,
+			ClientID:      client.ID,
+			UserID:        user.ID,
+			Scopes:        scope,
+			ExpiresAt:     now.Add(time.Duration(settings.RefreshTokenTTL) * time.Second),
+			SessionID:     &session.ID,
+			FamilyID:      familyID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// newIDToken builds the ID token record and its claims. acr and amr come from the
+// session, so a sign-in with a second factor or by email link shows in the token.
+func newIDToken(issuer string, client *models.Client, user *models.User, session *models.Session, scopes []string, nonce string, accessToken string, now time.Time, ttl uint32) (*models.IDToken, jwt.IDTokenClaims) {
+	idToken := &models.IDToken{
+		ClientID:  client.ID,
+		UserID:    user.ID,
+		Nonce:     nonce,
+		AZP:       client.ClientID,
+		ATHash:    jwt.HalfHash(accessToken),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Duration(ttl) * time.Second),
+	}
+	idToken.ApplySession(session)
+
+	claims := jwt.IDTokenClaims{
+		PublicClaims: jwt.PublicClaims{
+			Iss: issuer,
+			Sub: user.ID.String(),
+			Aud: client.ClientID,
+			Exp: idToken.ExpiresAt.Unix(),
+			Iat: now.Unix(),
+			Jti: uuid.NewString(),
+		},
+		AuthTime: session.CreatedAt.Unix(),
+		Nonce:    nonce,
+		ACR:      idToken.ACR,
+		AMR:      idToken.AMRClaim(),
+		AZP:      idToken.AZP,
+		ATHash:   idToken.ATHash,
+	}
+
+	if slices.Contains(scopes, "email") {
+		claims.Email = user.Email
+		claims.EmailVerified = &user.EmailVerified
+	}
+
+	if slices.Contains(scopes, "profile") {
+		claims.GivenName = user.GivenName
+		claims.FamilyName = user.FamilyName
+		claims.Picture = user.PictureURL
+		claims.Locale = user.Locale
+	}
+
+	return idToken, claims
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func testClient(confidential bool) *models.Client {
+	return &models.Client{
+		ID:             uuid.New(),
+		ClientID:       "app",
+		GrantTypes:     models.StringArray{"authorization_code", "refresh_token"},
+		ResponseTypes:  models.StringArray{"code"},
+		Scopes:         models.StringArray{"openid", "email", "profile"},
+		IsConfidential: confidential,
+	}
+}
+
+func TestValidateAuthorizeRequest(t *testing.T) {
+	tenant := &models.Tenant{Settings: models.DefaultTenantSettings()}
+	noCodeFlow := &models.Tenant{Settings: models.DefaultTenantSettings()}
+	noCodeFlow.Settings.AllowedGrantTypes = []string{"refresh_token"}
+
+	valid := AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "app",
+		RedirectURI:         "https://app.example/callback",
+		Scope:               "openid email",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+	}
+	with := func(change func(*AuthorizeRequest)) AuthorizeRequest {
+		request := valid
+		change(&request)
+		return request
+	}
+
+	tests := []struct {
+		name    string
+		tenant  *models.Tenant
+		client  *models.Client
+		request AuthorizeRequest
+		want    string
+	}{
+		{name: "valid public", tenant: tenant, client: testClient(false), request: valid},
+		{name: "confidential without pkce", tenant: tenant, client: testClient(true), request: with(func(r *AuthorizeRequest) { r.CodeChallenge, r.CodeChallengeMethod = "", "" })},
+		{name: "public without pkce", tenant: tenant, client: testClient(false), request: with(func(r *AuthorizeRequest) { r.CodeChallenge, r.CodeChallengeMethod = "", "" }), want: "invalid_request"},
+		{name: "plain pkce", tenant: tenant, client: testClient(true), request: with(func(r *AuthorizeRequest) { r.CodeChallengeMethod = "plain" }), want: "invalid_request"},
+		{name: "token response type", tenant: tenant, client: testClient(false), request: with(func(r *AuthorizeRequest) { r.ResponseType = "token" }), want: "unsupported_response_type"},
+		{name: "tenant disallows code flow", tenant: noCodeFlow, client: testClient(false), request: valid, want: "unauthorized_client"},
+		{name: "no scope", tenant: tenant, client: testClient(false), request: with(func(r *AuthorizeRequest) { r.Scope = " " }), want: "invalid_scope"},
+		{name: "scope not granted to client", tenant: tenant, client: testClient(false), request: with(func(r *AuthorizeRequest) { r.Scope = "openid admin" }), want: "invalid_scope"},
+	}
+
+	service := NewOAuthService(nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := service.ValidateAuthorizeRequest(test.tenant, test.client, test.request)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("ValidateAuthorizeRequest() = %v", err)
+				}
+				return
+			}
+
+			var oauthErr *OAuthError
+			if !errors.As(err, &oauthErr) || oauthErr.Code != test.want {
+				t.Fatalf("ValidateAuthorizeRequest() = %v, want %s", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewIDTokenCarriesSessionAuthentication(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", EmailVerified: true, GivenName: "Ada"}
+	client := testClient(false)
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		amr  []string
+	}{
+		{name: "password and totp", amr: []string{"pwd", "otp"}},
+		{name: "email link", amr: []string{"email"}},
+		{name: "federated", amr: []string{"fed"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := &models.Session{ID: uuid.New(), AMR: test.amr, ACR: models.ACRForAMR(test.amr), CreatedAt: now.Add(-time.Minute)}
+
+			record, claims := newIDToken("https://id.example/t", client, user, session, []string{"openid"}, "n-0S6", "access", now, 3600)
+
+			if !slices.Equal(claims.AMR, test.amr) || claims.ACR != session.ACR {
+				t.Fatalf("amr, acr = %v, %q, want %v, %q", claims.AMR, claims.ACR, test.amr, session.ACR)
+			}
+			if record.SessionID == nil || *record.SessionID != session.ID {
+				t.Fatal("ID token is not linked to the session")
+			}
+			if claims.AuthTime != session.CreatedAt.Unix() || claims.Nonce != "n-0S6" || claims.ATHash != jwt.HalfHash("access") {
+				t.Fatalf("claims = %+v", claims)
+			}
+			if claims.Exp != now.Add(time.Hour).Unix() || claims.Aud != client.ClientID || claims.Sub != user.ID.String() {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+
+	t.Run("multi factor acr", func(t *testing.T) {
+		session := &models.Session{ID: uuid.New(), AMR: []string{"pwd", "otp"}, ACR: models.ACRForAMR([]string{"pwd", "otp"})}
+		_, claims := newIDToken("https://id.example/t", client, user, session, []string{"openid"}, "", "access", now, 3600)
+		if claims.ACR != models.ACRMultiFactor {
+			t.Fatalf("acr = %q, want %q", claims.ACR, models.ACRMultiFactor)
+		}
+	})
+}
+
+func TestNewIDTokenScopes(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "ada@example.com", EmailVerified: true, GivenName: "Ada"}
+	session := &models.Session{ID: uuid.New(), AMR: []string{"pwd"}}
+
+	_, claims := newIDToken("https://id.example/t", testClient(false), user, session, []string{"openid"}, "", "access", time.Now(), 3600)
+	if claims.Email != "" || claims.EmailVerified != nil || claims.GivenName != "" {
+		t.Fatalf("openid alone released %+v", claims)
+	}
+
+	_, claims = newIDToken("https://id.example/t", testClient(false), user, session, []string{"openid", "email", "profile"}, "", "access", time.Now(), 3600)
+	if claims.Email != user.Email || claims.EmailVerified == nil || !*claims.EmailVerified || claims.GivenName != "Ada" {
+		t.Fatalf("email and profile claims = %+v", claims)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	client := testClient(true)
+	tokenID, familyID, sessionID := uuid.New(), uuid.New(), uuid.New()
+	rotated := time.Now().Add(-time.Minute)
+
+	tokenRows := func(revokedAt *time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "token_hash", "client_id", "expires_at", "revoked_at", "session_id", "family_id"}).
+			AddRow(tokenID, tokens.Hash("refresh-1"), client.ID, time.Now().Add(time.Hour), revokedAt, sessionID, familyID)
+	}
+
+	tests := []struct {
+		name   string
+		rows   *sqlmock.Rows
+		rotate bool
+	}{
+		{name: "rotated out token presented again", rows: tokenRows(&rotated)},
+		{name: "rotated by a concurrent request", rows: tokenRows(nil), rotate: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			mock.ExpectQuery(`SELECT \* FROM "refresh_tokens" WHERE token_hash = \$1`).
+				WithArgs(tokens.Hash("refresh-1"), 1).
+				WillReturnRows(test.rows)
+			if test.rotate {
+				mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE revoked_at IS NULL AND "id" = \$2`).
+					WithArgs(sqlmock.AnyArg(), tokenID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			// Every token of the family stops working, including the ones the rightful client holds
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "access_tokens" SET "revoked_at"=\$1 WHERE id IN \(SELECT "access_token_id" FROM "refresh_tokens" WHERE family_id = \$2 AND access_token_id IS NOT NULL\) AND revoked_at IS NULL`).
+				WithArgs(sqlmock.AnyArg(), familyID).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE family_id = \$2 AND revoked_at IS NULL`).
+				WithArgs(sqlmock.AnyArg(), familyID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			response, err := NewOAuthService(db).Refresh(tenant, "https://id.example/t", client, "refresh-1")
+
+			var oauthErr *OAuthError
+			if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+				t.Fatalf("Refresh = %+v, %v, want invalid_grant", response, err)
+			}
+		})
+	}
+}
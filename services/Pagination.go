@@ -0,0 +1,32 @@
+package services
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+type Page struct {
+	Number int
+	Size   int
+}
+
+// NewPage clamps user supplied pagination values to sane bounds
+func NewPage(number int, size int) Page {
+	if number < 1 {
+		number = 1
+	}
+
+	if size < 1 {
+		size = DefaultPageSize
+	}
+
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+
+	return Page{Number: number, Size: size}
+}
+
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.Size
+}
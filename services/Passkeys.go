@@ -0,0 +1,421 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/packages/webauthn"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PasskeyCeremonyTimeout is how long the browser has to answer a passkey prompt
+const PasskeyCeremonyTimeout = 5 * time.Minute
+
+const (
+	PasskeyPurposeRegister = "register"
+	PasskeyPurposeSignup   = "signup"
+	PasskeyPurposeLogin    = "login"
+)
+
+// PasskeyService runs WebAuthn ceremonies for tenant Users. Every ceremony starts
+// with stored options and its challenge is consumed by the first response.
+type PasskeyService struct {
+	db *gorm.DB
+}
+
+func NewPasskeyService(db *gorm.DB) *PasskeyService {
+	return &PasskeyService{db: db}
+}
+
+// PasskeysAllowed reports whether the tenant enabled passkeys ("webauthn" in mfa.methods)
+func PasskeysAllowed(tenant *models.Tenant) bool {
+	return slices.Contains(tenant.Settings.MFA.Methods, "webauthn")
+}
+
+// SignupInput starts a passwordless registration
+type SignupInput struct {
+	Email      string `json:"email"`
+	GivenName  string `json:"given_name"`
+	FamilyName string `json:"family_name"`
+	Locale     string `json:"locale"`
+}
+
+// RegistrationOptions lets a signed in user add a passkey
+func (s *PasskeyService) RegistrationOptions(cfg webauthn.Config, tenant *models.Tenant, user *models.User) (*webauthn.CreationOptions, error) {
+	credentials, err := s.ListCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptor, err := credentialDescriptor(credential)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, descriptor)
+	}
+
+	challenge, err := s.newChallenge(tenant.ID, PasskeyPurposeRegister, &user.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	options := cfg.CreationOptions(challenge, userEntity(user.ID, user.Email, displayName(user.GivenName, user.FamilyName, user.Email)), exclude, PasskeyCeremonyTimeout.Milliseconds())
+	return &options, nil
+}
+
+// SignupOptions starts a registration for a new passkey only user, the user ID is
+// reserved now because it is the user handle stored on the authenticator
+func (s *PasskeyService) SignupOptions(cfg webauthn.Config, tenant *models.Tenant, input SignupInput) (*webauthn.CreationOptions, error) {
+	email, err := normalizeEmail(input.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, _ := NewUsersService(s.db).GetUserByEmail(tenant.ID, email); existing != nil {
+		return nil, ErrUserAlreadyExists
+	}
+
+	userID := uuid.New()
+	challenge, err := s.newChallenge(tenant.ID, PasskeyPurposeSignup, &userID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	options := cfg.CreationOptions(challenge, userEntity(userID, email, displayName(input.GivenName, input.FamilyName, email)), nil, PasskeyCeremonyTimeout.Milliseconds())
+	return &options, nil
+}
+
+// Register stores the passkey created for RegistrationOptions
+func (s *PasskeyService) Register(cfg webauthn.Config, tenant *models.Tenant, user *models.User, response webauthn.RegistrationResponse, name string) (*models.WebAuthnCredential, error) {
+	ceremony, challenge, err := s.consumeChallenge(tenant.ID, PasskeyPurposeRegister, response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if ceremony.UserID == nil || *ceremony.UserID != user.ID {
+		return nil, ErrPasskeyInvalid
+	}
+
+	credential, err := s.verifyRegistration(cfg, ceremony, challenge, response, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(credential).Error; err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// Signup creates a user without a password together with their first passkey
+func (s *PasskeyService) Signup(cfg webauthn.Config, tenant *models.Tenant, input SignupInput, response webauthn.RegistrationResponse) (*models.User, error) {
+	ceremony, challenge, err := s.consumeChallenge(tenant.ID, PasskeyPurposeSignup, response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if ceremony.UserID == nil || ceremony.Email == "" {
+		return nil, ErrPasskeyInvalid
+	}
+
+	credential, err := s.verifyRegistration(cfg, ceremony, challenge, response, "")
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		ID:         *ceremony.UserID,
+		TenantID:   tenant.ID,
+		Email:      ceremony.Email,
+		GivenName:  strings.TrimSpace(input.GivenName),
+		FamilyName: strings.TrimSpace(input.FamilyName),
+		Locale:     strings.TrimSpace(input.Locale),
+		Status:     "active",
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(credential).Error
+	})
+	if err != nil {
+		// Someone registered the email since the options were issued
+		if existing, _ := NewUsersService(s.db).GetUserByEmail(tenant.ID, user.Email); existing != nil {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
+
+// LoginOptions starts a passkey sign-in. Without an email the browser offers the
+// discoverable credentials it holds for the RP, with one only that user's passkeys
+// are allowed. Unknown emails get an empty list so they look like a discoverable login.
+func (s *PasskeyService) LoginOptions(cfg webauthn.Config, tenant *models.Tenant, email string) (*webauthn.RequestOptions, error) {
+	var allow []webauthn.CredentialDescriptor
+
+	if email != "" {
+		user, err := NewUsersService(s.db).GetUserByEmail(tenant.ID, email)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return nil, err
+		}
+
+		if user != nil {
+			credentials, err := s.ListCredentials(user.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, credential := range credentials {
+				descriptor, err := credentialDescriptor(credential)
+				if err != nil {
+					return nil, err
+				}
+				allow = append(allow, descriptor)
+			}
+		}
+	}
+
+	challenge, err := s.newChallenge(tenant.ID, PasskeyPurposeLogin, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	options := cfg.RequestOptions(challenge, allow, PasskeyCeremonyTimeout.Milliseconds())
+	return &options, nil
+}
+
+// Authenticate verifies a passkey assertion and returns its active user. User
+// verification is always required, so the passkey alone is multi-factor.
+func (s *PasskeyService) Authenticate(cfg webauthn.Config, tenant *models.Tenant, response webauthn.AssertionResponse) (*models.User, error) {
+	_, challenge, err := s.consumeChallenge(tenant.ID, PasskeyPurposeLogin, response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var credential models.WebAuthnCredential
+	err = s.db.Preload("User").
+		Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(response.RawID)).
+		First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPasskeyInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.User.TenantID != tenant.ID {
+		return nil, ErrPasskeyInvalid
+	}
+
+	// Discoverable credentials return the user handle, it must be the owner's
+	if len(response.Response.UserHandle) > 0 && !bytes.Equal(response.Response.UserHandle, credential.UserID[:]) {
+		return nil, ErrPasskeyInvalid
+	}
+
+	assertion, err := cfg.VerifyAssertion(
+		challenge, credential.PublicKey, uint32(credential.SignCount),
+		response.Response.ClientDataJSON, response.Response.AuthenticatorData, response.Response.Signature,
+		true,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+
+	// A concurrent use of the same counter value loses, as a clone would
+	now := time.Now()
+	result := s.db.Model(&credential).
+		Where("sign_count = ?", credential.SignCount).
+		Updates(map[string]any{"sign_count": int64(assertion.SignCount), "backup_state": assertion.BackupState, "last_used_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrPasskeyInvalid
+	}
+
+	switch credential.User.Status {
+	case "active":
+		return &credential.User, nil
+	case "suspended":
+		return nil, ErrUserSuspended
+	}
+	return nil, ErrPasskeyInvalid
+}
+
+func (s *PasskeyService) ListCredentials(userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+// DeleteCredential removes a passkey, unless it is the only way the user can sign in
+func (s *PasskeyService) DeleteCredential(user *models.User, credentialID uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count, identities int64
+		if err := tx.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.ExternalIdentity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+			return err
+		}
+
+		if user.PasswordHash == "" && identities == 0 && count <= 1 {
+			return ErrLastSignInMethod
+		}
+
+		result := tx.Where("id = ? AND user_id = ?", credentialID, user.ID).Delete(&models.WebAuthnCredential{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// PurgeExpiredChallenges removes ceremonies nobody finished
+func (s *PasskeyService) PurgeExpiredChallenges(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.WebAuthnChallenge{})
+
+	return result.RowsAffected, result.Error
+}
+
+func (s *PasskeyService) verifyRegistration(cfg webauthn.Config, ceremony *models.WebAuthnChallenge, challenge []byte, response webauthn.RegistrationResponse, name string) (*models.WebAuthnCredential, error) {
+	verified, err := cfg.VerifyRegistration(challenge, response.Response.ClientDataJSON, response.Response.AttestationObject, true)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(verified.ID)
+
+	var count int64
+	if err := s.db.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", credentialID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, ErrPasskeyAlreadyRegistered
+	}
+
+	aaguid, err := uuid.FromBytes(verified.AAGUID)
+	if err != nil {
+		return nil, ErrPasskeyInvalid
+	}
+
+	transports := response.Response.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	return &models.WebAuthnCredential{
+		UserID:            *ceremony.UserID,
+		CredentialID:      credentialID,
+		PublicKey:         verified.PublicKey,
+		SignCount:         int64(verified.SignCount),
+		AAGUID:            aaguid,
+		Transports:        transports,
+		AttestationFormat: verified.AttestationFormat,
+		BackupEligible:    verified.BackupEligible,
+		BackupState:       verified.BackupState,
+		Name:              name,
+	}, nil
+}
+
+func (s *PasskeyService) newChallenge(tenantID uuid.UUID, purpose string, userID *uuid.UUID, email string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	ceremony := &models.WebAuthnChallenge{
+		TenantID:      tenantID,
+		UserID:        userID,
+		Purpose:       purpose,
+		ChallengeHash: tokens.Hash(base64.RawURLEncoding.EncodeToString(challenge)),
+		Email:         email,
+		ExpiresAt:     time.Now().Add(PasskeyCeremonyTimeout),
+	}
+
+	if err := s.db.Create(ceremony).Error; err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// consumeChallenge finds the ceremony the client data answers and deletes it, so a
+// response can only be tried once whether or not it verifies
+func (s *PasskeyService) consumeChallenge(tenantID uuid.UUID, purpose string, clientDataJSON []byte) (*models.WebAuthnChallenge, []byte, error) {
+	challenge, err := webauthn.ChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, ErrPasskeyInvalid
+	}
+
+	var ceremony models.WebAuthnChallenge
+	err = s.db.
+		Where("challenge_hash = ? AND tenant_id = ? AND purpose = ?", tokens.Hash(base64.RawURLEncoding.EncodeToString(challenge)), tenantID, purpose).
+		First(&ceremony).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrPasskeyInvalid
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := s.db.Delete(&models.WebAuthnChallenge{}, "id = ?", ceremony.ID)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	if result.RowsAffected == 0 || !time.Now().Before(ceremony.ExpiresAt) {
+		return nil, nil, ErrPasskeyInvalid
+	}
+
+	return &ceremony, challenge, nil
+}
+
+func credentialDescriptor(credential models.WebAuthnCredential) (webauthn.CredentialDescriptor, error) {
+	id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+	if err != nil {
+		return webauthn.CredentialDescriptor{}, err
+	}
+	return webauthn.CredentialDescriptor{Type: "public-key", ID: id, Transports: credential.Transports}, nil
+}
+
+// userEntity uses the raw user ID as the WebAuthn user handle
+func userEntity(userID uuid.UUID, email string, name string) webauthn.UserEntity {
+	return webauthn.UserEntity{ID: userID[:], Name: email, DisplayName: name}
+}
+
+func displayName(givenName string, familyName string, email string) string {
+	name := strings.TrimSpace(strings.TrimSpace(givenName) + " " + strings.TrimSpace(familyName))
+	if name == "" {
+		return email
+	}
+	return name
+}
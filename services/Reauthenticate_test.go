@@ -0,0 +1,61 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/password"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReauthenticatePasswordlessNeedsFreshSession(t *testing.T) {
+	us := NewUsersService(dryRunDB(t), throttle.NewMemoryStore())
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	user := &models.User{ID: uuid.New(), Email: "alice@example.com"}
+
+	fresh := &models.Session{AMR: models.StringArray{models.AMRMultiFactor}, CreatedAt: time.Now().Add(-time.Minute)}
+	if err := us.Reauthenticate(tenant, user, fresh, "", "", "203.0.113.7"); err != nil {
+		t.Fatalf("fresh passkey session = %v", err)
+	}
+
+	stale := &models.Session{AMR: models.StringArray{models.AMREmail}, CreatedAt: time.Now().Add(-time.Hour)}
+	if err := us.Reauthenticate(tenant, user, stale, "guess", "", "203.0.113.7"); !errors.Is(err, ErrReauthenticationRequired) {
+		t.Fatalf("stale session = %v, want ErrReauthenticationRequired", err)
+	}
+}
+
+func TestConsoleReauthenticateCountsFailures(t *testing.T) {
+	// Hashed with the current parameters so a successful check does not rehash
+	hash, err := password.Hash("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := throttle.NewMemoryStore()
+	sessions := NewConsoleSessionService(dryRunDB(t), store)
+	user := &models.AccountUser{ID: uuid.New(), Email: "owner@example.com", PasswordHash: hash}
+
+	for _, guess := range []string{"wrong", "", "also wrong"} {
+		if err := sessions.Reauthenticate(user, guess, "", "203.0.113.7"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("password %q = %v, want ErrInvalidCredentials", guess, err)
+		}
+	}
+
+	attempt := consoleLoginAttempt(user.Email, "", "203.0.113.7")
+	if counter, _ := store.Get(attempt.userKey()); counter.Failures != 3 {
+		t.Fatalf("failures = %d, want 3", counter.Failures)
+	}
+
+	// The progressive delay of the console login applies here too
+	if err := sessions.Reauthenticate(user, "correct horse battery", "", "203.0.113.7"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("right password inside the delay = %v, want ErrTooManyAttempts", err)
+	}
+
+	store.Reset(attempt.userKey())
+	if err := sessions.Reauthenticate(user, "correct horse battery", "", "203.0.113.7"); err != nil {
+		t.Fatalf("right password = %v", err)
+	}
+}
@@ -0,0 +1,692 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/saml"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SAMLAssertionLifetime is how long a service provider may take to consume an assertion
+const SAMLAssertionLifetime = 5 * time.Minute
+
+// SAMLRequestLifetime is how long the user has to sign in before a pending SSO request expires
+const SAMLRequestLifetime = 10 * time.Minute
+
+// samlRequestPurpose binds the signed pending request token to SSO
+const samlRequestPurpose = "saml-sso"
+
+// samlUserFields are the user fields an attribute mapping can release
+var samlUserFields = []string{"email", "email_verified", "given_name", "family_name", "name", "user_id", "locale", "picture_url"}
+
+var defaultSAMLAttributeMapping = models.StringMap{
+	"email":       "email",
+	"given_name":  "given_name",
+	"family_name": "family_name",
+}
+
+var samlNameIDFormats = []string{saml.NameIDFormatEmail, saml.NameIDFormatPersist, saml.NameIDFormatUnspec}
+
+// SAMLServiceProviderInput creates or updates a service provider. Metadata is the
+// provider's EntityDescriptor XML and fills in the fields left empty; empty fields
+// keep their value on update.
+type SAMLServiceProviderInput struct {
+	Metadata         string            `json:"metadata"`
+	Name             string            `json:"name"`
+	EntityID         string            `json:"entity_id"`
+	ACSURLs          []string          `json:"acs_urls"`
+	SLOURL           string            `json:"slo_url"`
+	SLOBinding       string            `json:"slo_binding"`
+	Certificate      string            `json:"certificate"`
+	NameIDFormat     string            `json:"name_id_format"`
+	AttributeMapping map[string]string `json:"attribute_mapping"`
+	SignResponse     *bool             `json:"sign_response"`
+	Enabled          *bool             `json:"enabled"`
+}
+
+// SAMLRequest is a sign-in to a service provider waiting for the user. It travels
+// through the login page as a signed token, see Token.
+type SAMLRequest struct {
+	ServiceProviderID uuid.UUID `json:"sp"`
+	RequestID         string    `json:"id,omitempty"` // empty for IdP initiated sign-in
+	ACSURL            string    `json:"acs"`
+	RelayState        string    `json:"rs,omitempty"`
+	ForceAuthn        bool      `json:"force,omitempty"`
+	IsPassive         bool      `json:"passive,omitempty"`
+	ReceivedAt        int64     `json:"at"`
+}
+
+// SAMLMessage is a message ready to send through the browser
+type SAMLMessage struct {
+	URL    string            // HTTP-Redirect: the URL to send the browser to, HTTP-POST: the form action
+	Fields map[string]string // HTTP-POST form fields, nil for HTTP-Redirect
+}
+
+// SAMLLogout is the outcome of a LogoutRequest from a service provider
+type SAMLLogout struct {
+	Response *SAMLMessage  // LogoutResponse to the requester, nil when it has no logout endpoint
+	Notify   []SAMLMessage // LogoutRequests to the other service providers the sessions signed in to
+	Sessions []uuid.UUID   // revoked sessions
+}
+
+// SAMLService is the tenant's SAML 2.0 identity provider. AuthnRequests are only
+// answered at a registered assertion consumer URL, so an unsigned or POST bound
+// request (whose XML signature is not checked) cannot send an assertion elsewhere.
+type SAMLService struct {
+	db *gorm.DB
+}
+
+func NewSAMLService(db *gorm.DB) *SAMLService {
+	return &SAMLService{db: db}
+}
+
+func (s *SAMLService) ListServiceProviders(tenantID uuid.UUID) ([]models.SAMLServiceProvider, error) {
+	var providers []models.SAMLServiceProvider
+	err := s.db.Where("tenant_id = ?", tenantID).Order("created_at").Find(&providers).Error
+
+	return providers, err
+}
+
+func (s *SAMLService) GetServiceProvider(tenantID uuid.UUID, providerID uuid.UUID) (*models.SAMLServiceProvider, error) {
+	var provider models.SAMLServiceProvider
+	err := s.db.Where("id = ? AND tenant_id = ?", providerID, tenantID).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+func (s *SAMLService) CreateServiceProvider(tenantID uuid.UUID, input SAMLServiceProviderInput) (*models.SAMLServiceProvider, error) {
+	provider := &models.SAMLServiceProvider{
+		TenantID:         tenantID,
+		NameIDFormat:     saml.NameIDFormatEmail,
+		AttributeMapping: defaultSAMLAttributeMapping,
+		Enabled:          true,
+	}
+
+	if err := s.applyInput(provider, input); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(provider).Error; err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (s *SAMLService) UpdateServiceProvider(tenantID uuid.UUID, providerID uuid.UUID, input SAMLServiceProviderInput) (*models.SAMLServiceProvider, error) {
+	provider, err := s.GetServiceProvider(tenantID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.applyInput(provider, input); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(provider).Error; err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// DeleteServiceProvider removes the provider, its sessions are no longer notified on logout
+func (s *SAMLService) DeleteServiceProvider(tenantID uuid.UUID, providerID uuid.UUID) error {
+	result := s.db.Where("id = ? AND tenant_id = ?", providerID, tenantID).Delete(&models.SAMLServiceProvider{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *SAMLService) applyInput(provider *models.SAMLServiceProvider, input SAMLServiceProviderInput) error {
+	if input.Metadata != "" {
+		if err := applySAMLMetadata(&input); err != nil {
+			return err
+		}
+	}
+
+	if input.Name != "" {
+		provider.Name = strings.TrimSpace(input.Name)
+	}
+	if input.EntityID != "" {
+		provider.EntityID = strings.TrimSpace(input.EntityID)
+	}
+	if input.ACSURLs != nil {
+		provider.ACSURLs = models.StringArray{}
+		for _, acsURL := range input.ACSURLs {
+			if err := validateSAMLURL(acsURL); err != nil {
+				return err
+			}
+			provider.ACSURLs = append(provider.ACSURLs, acsURL)
+		}
+	}
+	if input.SLOURL != "" {
+		if err := validateSAMLURL(input.SLOURL); err != nil {
+			return err
+		}
+		provider.SLOURL = input.SLOURL
+	}
+	if input.SLOBinding != "" {
+		provider.SLOBinding = input.SLOBinding
+	}
+	if input.Certificate != "" {
+		if _, err := parseCertificatePEM(input.Certificate); err != nil {
+			return fmt.Errorf("%w: certificate must be a PEM encoded X.509 certificate", ErrSAMLServiceProviderInvalid)
+		}
+		provider.Certificate = input.Certificate
+	}
+	if input.NameIDFormat != "" {
+		provider.NameIDFormat = input.NameIDFormat
+	}
+	if input.AttributeMapping != nil {
+		provider.AttributeMapping = models.StringMap{}
+		for name, field := range input.AttributeMapping {
+			if name == "" || !slices.Contains(samlUserFields, field) {
+				return fmt.Errorf("%w: attribute_mapping values must be one of %s", ErrSAMLServiceProviderInvalid, strings.Join(samlUserFields, ", "))
+			}
+			provider.AttributeMapping[name] = field
+		}
+	}
+	if input.SignResponse != nil {
+		provider.SignResponse = *input.SignResponse
+	}
+	if input.Enabled != nil {
+		provider.Enabled = *input.Enabled
+	}
+
+	if provider.Name == "" || provider.EntityID == "" || len(provider.ACSURLs) == 0 {
+		return fmt.Errorf("%w: name, entity_id and acs_urls are required", ErrSAMLServiceProviderInvalid)
+	}
+
+	if provider.SLOURL != "" && provider.SLOBinding == "" {
+		provider.SLOBinding = "redirect"
+	}
+	if provider.SLOBinding != "" && provider.SLOBinding != "post" && provider.SLOBinding != "redirect" {
+		return fmt.Errorf("%w: slo_binding must be post or redirect", ErrSAMLServiceProviderInvalid)
+	}
+
+	if !slices.Contains(samlNameIDFormats, provider.NameIDFormat) {
+		return fmt.Errorf("%w: name_id_format is not supported", ErrSAMLServiceProviderInvalid)
+	}
+
+	var taken int64
+	err := s.db.Model(&models.SAMLServiceProvider{}).
+		Where("tenant_id = ? AND entity_id = ? AND id <> ?", provider.TenantID, provider.EntityID, provider.ID).
+		Count(&taken).Error
+	if err != nil {
+		return err
+	}
+
+	if taken > 0 {
+		return ErrSAMLEntityIDTaken
+	}
+	return nil
+}
+
+// applySAMLMetadata fills the input fields left empty from the metadata
+func applySAMLMetadata(input *SAMLServiceProviderInput) error {
+	metadata, err := saml.ParseServiceProviderMetadata([]byte(input.Metadata))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSAMLServiceProviderInvalid, err)
+	}
+
+	if input.EntityID == "" {
+		input.EntityID = metadata.EntityID
+	}
+	if input.Name == "" {
+		input.Name = metadata.EntityID
+	}
+	if input.ACSURLs == nil {
+		input.ACSURLs = metadata.AssertionConsumerURL
+	}
+	if input.SLOURL == "" && metadata.SingleLogoutURL != "" {
+		input.SLOURL = metadata.SingleLogoutURL
+		input.SLOBinding = "redirect"
+		if metadata.SingleLogoutBinding == saml.BindingHTTPPost {
+			input.SLOBinding = "post"
+		}
+	}
+	if input.Certificate == "" {
+		input.Certificate = metadata.SigningCertificate
+	}
+	if input.NameIDFormat == "" && slices.Contains(samlNameIDFormats, metadata.NameIDFormat) {
+		input.NameIDFormat = metadata.NameIDFormat
+	}
+	return nil
+}
+
+func validateSAMLURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" || !isSecureURL(parsed) {
+		return fmt.Errorf("%w: %q must be an absolute https URL", ErrSAMLServiceProviderInvalid, raw)
+	}
+	return nil
+}
+
+// findServiceProvider looks up the enabled provider a message was issued by
+func (s *SAMLService) findServiceProvider(tenantID uuid.UUID, entityID string) (*models.SAMLServiceProvider, error) {
+	var provider models.SAMLServiceProvider
+	err := s.db.Where("tenant_id = ? AND entity_id = ? AND enabled", tenantID, strings.TrimSpace(entityID)).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: unknown service provider %q", ErrSAMLRequestInvalid, entityID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// verifyRedirect checks the query signature of an HTTP-Redirect message when the
+// provider registered a certificate. rawQuery is empty for HTTP-POST.
+func verifyRedirect(provider *models.SAMLServiceProvider, rawQuery string, param string) (bool, error) {
+	if rawQuery == "" || provider.Certificate == "" {
+		return false, nil
+	}
+
+	certificate, err := parseCertificatePEM(provider.Certificate)
+	if err != nil {
+		return false, err
+	}
+
+	signed, err := saml.VerifyRedirectSignature(rawQuery, param, certificate)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrSAMLRequestInvalid, err)
+	}
+	return signed, nil
+}
+
+// ReceiveAuthnRequest validates a service provider's sign-in request. ssoURL is the
+// endpoint it was received at and rawQuery the query of an HTTP-Redirect request.
+func (s *SAMLService) ReceiveAuthnRequest(tenant *models.Tenant, message []byte, rawQuery string, ssoURL string, relayState string) (*SAMLRequest, error) {
+	now := time.Now()
+
+	request, err := saml.ParseAuthnRequest(message, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSAMLRequestInvalid, err)
+	}
+
+	provider, err := s.findServiceProvider(tenant.ID, request.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Destination != "" && request.Destination != ssoURL {
+		return nil, fmt.Errorf("%w: Destination does not match", ErrSAMLRequestInvalid)
+	}
+
+	if request.ProtocolBinding != "" && request.ProtocolBinding != saml.BindingHTTPPost {
+		return nil, fmt.Errorf("%w: only the HTTP-POST response binding is supported", ErrSAMLRequestInvalid)
+	}
+
+	if _, err := verifyRedirect(provider, rawQuery, "SAMLRequest"); err != nil {
+		return nil, err
+	}
+
+	acsURL := provider.ACSURLs[0]
+	if request.AssertionConsumerServiceURL != "" {
+		if !slices.Contains(provider.ACSURLs, request.AssertionConsumerServiceURL) {
+			return nil, fmt.Errorf("%w: AssertionConsumerServiceURL is not registered", ErrSAMLRequestInvalid)
+		}
+		acsURL = request.AssertionConsumerServiceURL
+	}
+
+	return &SAMLRequest{
+		ServiceProviderID: provider.ID,
+		RequestID:         request.ID,
+		ACSURL:            acsURL,
+		RelayState:        relayState,
+		ForceAuthn:        request.ForceAuthn,
+		IsPassive:         request.IsPassive,
+		ReceivedAt:        now.Unix(),
+	}, nil
+}
+
+// StartIdPInitiated signs the user in to a service provider that did not ask for it,
+// the assertion goes to its default assertion consumer URL
+func (s *SAMLService) StartIdPInitiated(tenant *models.Tenant, providerID uuid.UUID, relayState string) (*SAMLRequest, error) {
+	provider, err := s.GetServiceProvider(tenant.ID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !provider.Enabled {
+		return nil, ErrRecordNotFound
+	}
+
+	return &SAMLRequest{
+		ServiceProviderID: provider.ID,
+		ACSURL:            provider.ACSURLs[0],
+		RelayState:        relayState,
+		ReceivedAt:        time.Now().Unix(),
+	}, nil
+}
+
+// Token signs the request so it can be resumed once the user has signed in
+func (r *SAMLRequest) Token() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return tokens.Sign(samlRequestPurpose, base64.RawURLEncoding.EncodeToString(data), SAMLRequestLifetime)
+}
+
+// ParseSAMLRequestToken reads a request signed by Token
+func ParseSAMLRequestToken(token string) (*SAMLRequest, error) {
+	subject, err := tokens.VerifySigned(samlRequestPurpose, token)
+	if err != nil {
+		return nil, ErrSAMLRequestInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(subject)
+	if err != nil {
+		return nil, ErrSAMLRequestInvalid
+	}
+
+	var request SAMLRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, ErrSAMLRequestInvalid
+	}
+	return &request, nil
+}
+
+// IssueResponse answers request with a signed assertion about the session's user
+// and records the session index so single logout can reach the service provider
+func (s *SAMLService) IssueResponse(tenant *models.Tenant, session *models.Session, request *SAMLRequest, issuer string) (*SAMLMessage, error) {
+	provider, err := s.GetServiceProvider(tenant.ID, request.ServiceProviderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !provider.Enabled {
+		return nil, ErrRecordNotFound
+	}
+
+	signer, err := NewSigningKeyService(s.db).ActiveKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &session.User
+	nameID := saml.NameID{Format: provider.NameIDFormat, Value: user.Email}
+	if provider.NameIDFormat == saml.NameIDFormatPersist {
+		nameID.Value = user.ID.String()
+	}
+
+	participant := &models.SAMLSessionParticipant{
+		SessionID:         session.ID,
+		ServiceProviderID: provider.ID,
+		NameID:            nameID.Value,
+		NameIDFormat:      nameID.Format,
+		SessionIndex:      saml.NewID(),
+	}
+	if err := s.db.Create(participant).Error; err != nil {
+		return nil, err
+	}
+
+	authnContext := saml.AuthnContextUnspec
+	if slices.Contains(session.AMR, models.AMRPassword) {
+		authnContext = saml.AuthnContextPassword
+	}
+
+	now := time.Now()
+	assertion := (&saml.Assertion{
+		Issuer:        issuer,
+		Audience:      provider.EntityID,
+		Recipient:     request.ACSURL,
+		InResponseTo:  request.RequestID,
+		NameID:        nameID,
+		SessionIndex:  participant.SessionIndex,
+		AuthnInstant:  session.CreatedAt,
+		AuthnContext:  authnContext,
+		Attributes:    samlAttributes(provider.AttributeMapping, user),
+		IssueInstant:  now,
+		ValidFor:      SAMLAssertionLifetime,
+		SessionExpiry: session.ExpiresAt,
+	}).Element()
+
+	if err := signer.Sign(assertion, 1); err != nil {
+		return nil, err
+	}
+
+	response := saml.NewResponse(request.ACSURL, request.RequestID, issuer, saml.StatusSuccess, assertion, now)
+	return s.postResponse(provider, signer, response, request.RelayState)
+}
+
+// ErrorResponse tells the service provider the sign-in failed, e.g. saml.StatusNoPassive
+func (s *SAMLService) ErrorResponse(tenant *models.Tenant, request *SAMLRequest, issuer string, status string) (*SAMLMessage, error) {
+	provider, err := s.GetServiceProvider(tenant.ID, request.ServiceProviderID)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := NewSigningKeyService(s.db).ActiveKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	response := saml.NewResponse(request.ACSURL, request.RequestID, issuer, status, nil, time.Now())
+	return s.postResponse(provider, signer, response, request.RelayState)
+}
+
+func (s *SAMLService) postResponse(provider *models.SAMLServiceProvider, signer *saml.Signer, response *saml.Element, relayState string) (*SAMLMessage, error) {
+	if provider.SignResponse {
+		if err := signer.Sign(response, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := map[string]string{"SAMLResponse": saml.EncodePost(response)}
+	if relayState != "" {
+		fields["RelayState"] = relayState
+	}
+	return &SAMLMessage{URL: response.Attr("Destination"), Fields: fields}, nil
+}
+
+// samlAttributes releases the mapped user fields, empty values are left out
+func samlAttributes(mapping models.StringMap, user *models.User) []saml.Attribute {
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var attributes []saml.Attribute
+	for _, name := range names {
+		var value string
+		switch mapping[name] {
+		case "email":
+			value = user.Email
+		case "email_verified":
+			value = strconv.FormatBool(user.EmailVerified)
+		case "given_name":
+			value = user.GivenName
+		case "family_name":
+			value = user.FamilyName
+		case "name":
+			value = strings.TrimSpace(user.GivenName + " " + user.FamilyName)
+		case "user_id":
+			value = user.ID.String()
+		case "locale":
+			value = user.Locale
+		case "picture_url":
+			value = user.PictureURL
+		}
+
+		if value != "" {
+			attributes = append(attributes, saml.Attribute{Name: name, Values: []string{value}})
+		}
+	}
+	return attributes
+}
+
+// ReceiveLogoutRequest ends the sessions a service provider logs out of. Sessions
+// are matched by the SessionIndex values of the request; a request without one ends
+// every session of the NameID at that provider, which needs a verified signature.
+func (s *SAMLService) ReceiveLogoutRequest(tenant *models.Tenant, message []byte, rawQuery string, sloURL string, relayState string, issuer string) (*SAMLLogout, error) {
+	request, err := saml.ParseLogoutRequest(message, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSAMLRequestInvalid, err)
+	}
+
+	provider, err := s.findServiceProvider(tenant.ID, request.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Destination != "" && request.Destination != sloURL {
+		return nil, fmt.Errorf("%w: Destination does not match", ErrSAMLRequestInvalid)
+	}
+
+	signed, err := verifyRedirect(provider, rawQuery, "SAMLRequest")
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := NewSigningKeyService(s.db).ActiveKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	logout := &SAMLLogout{}
+	status := saml.StatusSuccess
+
+	if len(request.SessionIndexes) == 0 && !signed {
+		status = saml.StatusRequester
+	} else {
+		query := s.db.Model(&models.SAMLSessionParticipant{}).
+			Where("service_provider_id = ? AND name_id = ?", provider.ID, request.NameID.Value)
+		if len(request.SessionIndexes) > 0 {
+			query = query.Where("session_index IN ?", request.SessionIndexes)
+		}
+
+		var sessionIDs []uuid.UUID
+		if err := query.Distinct().Pluck("session_id", &sessionIDs).Error; err != nil {
+			return nil, err
+		}
+
+		logout.Notify, err = s.endSessions(sessionIDs, provider.ID, signer, issuer)
+		if err != nil {
+			return nil, err
+		}
+		logout.Sessions = sessionIDs
+	}
+
+	if provider.SLOURL != "" {
+		response := saml.NewLogoutResponse(provider.SLOURL, request.ID, issuer, status, time.Now())
+		logout.Response, err = outgoing(provider, signer, response, "SAMLResponse", relayState)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return logout, nil
+}
+
+// LogoutSession is single logout started at the identity provider: it revokes the
+// session and returns the LogoutRequests for the service providers it signed in to
+func (s *SAMLService) LogoutSession(tenant *models.Tenant, sessionID uuid.UUID, issuer string) ([]SAMLMessage, error) {
+	var participants int64
+	if err := s.db.Model(&models.SAMLSessionParticipant{}).Where("session_id = ?", sessionID).Count(&participants).Error; err != nil {
+		return nil, err
+	}
+
+	if participants == 0 {
+		return nil, NewSessionService(s.db).Revoke(sessionID)
+	}
+
+	signer, err := NewSigningKeyService(s.db).ActiveKey(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return s.endSessions([]uuid.UUID{sessionID}, uuid.Nil, signer, issuer)
+}
+
+// endSessions revokes the sessions, forgets their participants and builds a
+// LogoutRequest for each provider other than except that has a logout endpoint
+func (s *SAMLService) endSessions(sessionIDs []uuid.UUID, except uuid.UUID, signer *saml.Signer, issuer string) ([]SAMLMessage, error) {
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+
+	var participants []models.SAMLSessionParticipant
+	err := s.db.Preload("ServiceProvider").
+		Where("session_id IN ? AND service_provider_id <> ?", sessionIDs, except).
+		Order("created_at").
+		Find(&participants).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Session{}).
+			Where("id IN ? AND revoked_at IS NULL", sessionIDs).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("session_id IN ?", sessionIDs).Delete(&models.SAMLSessionParticipant{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []SAMLMessage
+	for _, participant := range participants {
+		provider := &participant.ServiceProvider
+		if provider.SLOURL == "" || !provider.Enabled {
+			continue
+		}
+
+		nameID := saml.NameID{Format: participant.NameIDFormat, Value: participant.NameID}
+		request := saml.NewLogoutRequest(provider.SLOURL, issuer, nameID, participant.SessionIndex, time.Now())
+
+		message, err := outgoing(provider, signer, request, "SAMLRequest", "")
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *message)
+	}
+	return messages, nil
+}
+
+// outgoing signs a logout message for the provider's binding: an enveloped
+// signature for HTTP-POST, a query signature for HTTP-Redirect
+func outgoing(provider *models.SAMLServiceProvider, signer *saml.Signer, message *saml.Element, param string, relayState string) (*SAMLMessage, error) {
+	if provider.SLOBinding == "post" {
+		if err := signer.Sign(message, 1); err != nil {
+			return nil, err
+		}
+
+		fields := map[string]string{param: saml.EncodePost(message)}
+		if relayState != "" {
+			fields["RelayState"] = relayState
+		}
+		return &SAMLMessage{URL: provider.SLOURL, Fields: fields}, nil
+	}
+
+	location, err := saml.RedirectURL(provider.SLOURL, param, message, relayState, signer)
+	if err != nil {
+		return nil, err
+	}
+	return &SAMLMessage{URL: location}, nil
+}
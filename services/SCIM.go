@@ -0,0 +1,316 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/scim"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	SCIMDefaultCount = 100
+	SCIMMaxCount     = 200
+)
+
+// SCIMQuery selects a page of resources. StartIndex is 1-based and a Count of 0 only
+// asks for the total.
+type SCIMQuery struct {
+	Filter     string
+	StartIndex int
+	Count      int
+}
+
+// NewSCIMQuery clamps the startIndex and count parameters the way RFC 7644 section 3.4.2.4 asks
+func NewSCIMQuery(filter string, startIndex int, count int) SCIMQuery {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+
+	if count < 0 {
+		count = 0
+	}
+
+	if count > SCIMMaxCount {
+		count = SCIMMaxCount
+	}
+
+	return SCIMQuery{Filter: strings.TrimSpace(filter), StartIndex: startIndex, Count: count}
+}
+
+// SCIMService provisions the users and groups of a tenant for a SCIM client (RFC 7644)
+type SCIMService struct {
+	db *gorm.DB
+}
+
+func NewSCIMService(db *gorm.DB) *SCIMService {
+	return &SCIMService{db: db}
+}
+
+func (s *SCIMService) ListTokens(tenantID uuid.UUID) ([]models.SCIMToken, error) {
+	var scimTokens []models.SCIMToken
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at ASC").Find(&scimTokens).Error; err != nil {
+		return nil, err
+	}
+	return scimTokens, nil
+}
+
+// CreateToken issues a bearer token for the SCIM API, it is returned once and only its hash is kept
+func (s *SCIMService) CreateToken(tenantID uuid.UUID, name string) (*models.SCIMToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, "", fmt.Errorf("%w: name is required and at most 255 characters", ErrSCIMInvalidValue)
+	}
+
+	secret, err := tokens.Generate(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = "dps_scim_" + secret
+
+	scimToken := &models.SCIMToken{TenantID: tenantID, Name: name, TokenHash: tokens.Hash(secret)}
+	if err := s.db.Create(scimToken).Error; err != nil {
+		return nil, "", err
+	}
+	return scimToken, secret, nil
+}
+
+func (s *SCIMService) DeleteToken(tenantID uuid.UUID, id uuid.UUID) error {
+	result := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).Delete(&models.SCIMToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// AuthenticateToken finds the tenant's SCIM token a bearer token belongs to
+func (s *SCIMService) AuthenticateToken(tenantID uuid.UUID, token string) (*models.SCIMToken, error) {
+	if token == "" {
+		return nil, ErrSCIMTokenInvalid
+	}
+
+	var scimToken models.SCIMToken
+	err := s.db.Where("tenant_id = ? AND token_hash = ?", tenantID, tokens.Hash(token)).First(&scimToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSCIMTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A write per request is wasted on a sync run, to the minute is precise enough
+	now := time.Now()
+	if scimToken.LastUsedAt == nil || now.Sub(*scimToken.LastUsedAt) > time.Minute {
+		if err := s.db.Model(&scimToken).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &scimToken, nil
+}
+
+// scimAttribute is how a filterable attribute is stored
+type scimAttribute struct {
+	column    string // SQL expression compared, for references a condition on the referenced ID
+	kind      string // string, boolean, dateTime or reference
+	caseExact bool
+	present   string // references only: the condition that there is any
+}
+
+// compileSCIMFilter turns a filter into a WHERE condition over the attributes
+func compileSCIMFilter(filter string, attributes map[string]scimAttribute) (string, []any, error) {
+	parsed, err := scim.ParseFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	return scimCondition(parsed, attributes)
+}
+
+func scimCondition(filter *scim.Filter, attributes map[string]scimAttribute) (string, []any, error) {
+	switch filter.Operator {
+	case "and", "or":
+		left, leftArgs, err := scimCondition(filter.Operands[0], attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := scimCondition(filter.Operands[1], attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + left + " " + strings.ToUpper(filter.Operator) + " " + right + ")", append(leftArgs, rightArgs...), nil
+
+	case "not":
+		inner, args, err := scimCondition(filter.Operands[0], attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT COALESCE(" + inner + ", FALSE)", args, nil
+	}
+
+	attribute, ok := attributes[filter.Attribute]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: filtering on %s is not supported", scim.ErrInvalidFilter, filter.Attribute)
+	}
+
+	present := "(" + attribute.column + " IS NOT NULL)"
+	switch attribute.kind {
+	case "string":
+		present = "(" + attribute.column + " IS NOT NULL AND " + attribute.column + " <> '')"
+	case "reference":
+		present = attribute.present
+	}
+
+	// pr, eq null and ne null only ask whether there is a value
+	if filter.Operator == "pr" || filter.Value == nil && filter.Operator == "ne" {
+		return present, nil, nil
+	}
+	if filter.Value == nil && filter.Operator == "eq" {
+		return "NOT " + present, nil, nil
+	}
+	if filter.Value == nil {
+		return "", nil, fmt.Errorf("%w: null can only be compared with eq or ne", scim.ErrInvalidFilter)
+	}
+
+	invalid := fmt.Errorf("%w: %s %s %v is not a valid comparison", scim.ErrInvalidFilter, filter.Attribute, filter.Operator, filter.Value)
+
+	switch attribute.kind {
+	case "boolean":
+		value, ok := filter.Value.(bool)
+		if !ok || filter.Operator != "eq" && filter.Operator != "ne" {
+			return "", nil, invalid
+		}
+		if filter.Operator == "ne" {
+			value = !value
+		}
+		return "COALESCE(" + attribute.column + ", FALSE) = ?", []any{value}, nil
+
+	case "dateTime":
+		text, ok := filter.Value.(string)
+		if !ok {
+			return "", nil, invalid
+		}
+		value, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return "", nil, invalid
+		}
+
+		operators := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+		operator, ok := operators[filter.Operator]
+		if !ok {
+			return "", nil, invalid
+		}
+		return attribute.column + " " + operator + " ?", []any{value}, nil
+
+	case "reference":
+		value, ok := filter.Value.(string)
+		if !ok || filter.Operator != "eq" && filter.Operator != "ne" {
+			return "", nil, invalid
+		}
+		if filter.Operator == "ne" {
+			return "NOT " + attribute.column, []any{strings.ToLower(value)}, nil
+		}
+		return attribute.column, []any{strings.ToLower(value)}, nil
+	}
+
+	value, ok := filter.Value.(string)
+	if !ok {
+		return "", nil, invalid
+	}
+
+	column := "COALESCE(" + attribute.column + ", '')"
+	placeholder := "?"
+	if !attribute.caseExact {
+		column = "LOWER(" + column + ")"
+		placeholder = "LOWER(?)"
+	}
+
+	switch filter.Operator {
+	case "eq":
+		return column + " = " + placeholder, []any{value}, nil
+	case "ne":
+		return column + " <> " + placeholder, []any{value}, nil
+	case "co":
+		return column + " LIKE " + placeholder + ` ESCAPE '\'`, []any{"%" + escapeLike(value) + "%"}, nil
+	case "sw":
+		return column + " LIKE " + placeholder + ` ESCAPE '\'`, []any{escapeLike(value) + "%"}, nil
+	case "ew":
+		return column + " LIKE " + placeholder + ` ESCAPE '\'`, []any{"%" + escapeLike(value)}, nil
+	case "gt":
+		return column + " > " + placeholder, []any{value}, nil
+	case "ge":
+		return column + " >= " + placeholder, []any{value}, nil
+	case "lt":
+		return column + " < " + placeholder, []any{value}, nil
+	case "le":
+		return column + " <= " + placeholder, []any{value}, nil
+	}
+	return "", nil, invalid
+}
+
+// escapeLike makes value match itself in a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// scimString decodes a PATCH string value, a nil value removes the attribute
+func scimString(value json.RawMessage, attribute string, max int) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return "", fmt.Errorf("%w: %s must be a string", ErrSCIMInvalidValue, attribute)
+	}
+
+	text = strings.TrimSpace(text)
+	if len(text) > max {
+		return "", fmt.Errorf("%w: %s is longer than %d characters", ErrSCIMInvalidValue, attribute, max)
+	}
+	return text, nil
+}
+
+// patchValue is the value of an add or replace operation, nil for remove or a JSON null
+func patchValue(operation string, value json.RawMessage) json.RawMessage {
+	if operation == "remove" || value == nil || string(value) == "null" {
+		return nil
+	}
+	return value
+}
+
+// patchTargets lists the attributes an operation changes: its path, or for an add or
+// replace without one each key of the value object
+func patchTargets(operation string, op scim.PatchOperation) ([]scim.Path, []json.RawMessage, error) {
+	if op.Path != "" {
+		path, err := scim.ParsePath(op.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []scim.Path{path}, []json.RawMessage{patchValue(operation, op.Value)}, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &fields); err != nil {
+		return nil, nil, fmt.Errorf("%w: a patch without path needs an object value", ErrSCIMInvalidValue)
+	}
+
+	var paths []scim.Path
+	var values []json.RawMessage
+	for key, value := range fields {
+		path, err := scim.ParsePath(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		paths = append(paths, path)
+		values = append(values, patchValue(operation, value))
+	}
+	return paths, values, nil
+}
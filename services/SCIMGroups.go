@@ -0,0 +1,444 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/scim"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// scimGroupAttributes are the Group attributes a filter can compare. Only groups
+// managed here are visible over SCIM, directory synced ones belong to their directory.
+var scimGroupAttributes = map[string]scimAttribute{
+	"id":                {column: "id::text", kind: "string"},
+	"displayname":       {column: "name", kind: "string"},
+	"externalid":        {column: "external_id", kind: "string", caseExact: true},
+	"meta.created":      {column: "created_at", kind: "dateTime"},
+	"meta.lastmodified": {column: "updated_at", kind: "dateTime"},
+	"members":           {column: "id IN (SELECT group_id FROM group_members WHERE user_id::text = ?)", kind: "reference", present: "id IN (SELECT group_id FROM group_members)"},
+	"members.value":     {column: "id IN (SELECT group_id FROM group_members WHERE user_id::text = ?)", kind: "reference", present: "id IN (SELECT group_id FROM group_members)"},
+}
+
+func (s *SCIMService) ListGroups(tenantID uuid.UUID, query SCIMQuery) ([]models.Group, int64, error) {
+	scope, err := scimScope(tenantID, query.Filter, scimGroupAttributes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := s.db.Model(&models.Group{}).Scopes(scope).Where("provider_id IS NULL").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var groups []models.Group
+	if query.Count == 0 {
+		return groups, total, nil
+	}
+
+	err = s.db.Scopes(scope).
+		Where("provider_id IS NULL").
+		Order("created_at ASC, id ASC").
+		Offset(query.StartIndex - 1).
+		Limit(query.Count).
+		Find(&groups).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+func (s *SCIMService) GetGroup(tenantID uuid.UUID, id uuid.UUID) (*models.Group, error) {
+	var group models.Group
+
+	err := s.db.Where("tenant_id = ? AND id = ? AND provider_id IS NULL", tenantID, id).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// MembersOfGroups returns the users in each of the groups
+func (s *SCIMService) MembersOfGroups(groupIDs []uuid.UUID) (map[uuid.UUID][]models.User, error) {
+	members := map[uuid.UUID][]models.User{}
+	if len(groupIDs) == 0 {
+		return members, nil
+	}
+
+	var memberships []models.GroupMember
+	if err := s.db.Preload("User").Where("group_id IN ?", groupIDs).Order("created_at ASC").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+
+	for _, membership := range memberships {
+		members[membership.GroupID] = append(members[membership.GroupID], membership.User)
+	}
+	return members, nil
+}
+
+func (s *SCIMService) CreateGroup(tenantID uuid.UUID, input scim.Group) (*models.Group, error) {
+	group := &models.Group{TenantID: tenantID}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		members := map[uuid.UUID]bool{}
+		if err := applySCIMGroup(group, members, input); err != nil {
+			return err
+		}
+
+		if err := ensureGroupNameAvailable(tx, group); err != nil {
+			return err
+		}
+
+		if err := tx.Create(group).Error; err != nil {
+			return err
+		}
+		return saveSCIMMembers(tx, group, members)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetGroup(tenantID, group.ID)
+}
+
+// ReplaceGroup is a PUT, the members sent become the only members
+func (s *SCIMService) ReplaceGroup(tenantID uuid.UUID, id uuid.UUID, ifMatch string, input scim.Group) (*models.Group, error) {
+	return s.updateGroup(tenantID, id, ifMatch, func(group *models.Group, members map[uuid.UUID]bool) error {
+		clear(members)
+		group.ExternalID = ""
+		return applySCIMGroup(group, members, input)
+	})
+}
+
+// PatchGroup applies the operations of a PATCH in order, all or none of them. Clients
+// mostly use it to add and remove members without sending the whole list.
+func (s *SCIMService) PatchGroup(tenantID uuid.UUID, id uuid.UUID, ifMatch string, operations []scim.PatchOperation) (*models.Group, error) {
+	return s.updateGroup(tenantID, id, ifMatch, func(group *models.Group, members map[uuid.UUID]bool) error {
+		for _, op := range operations {
+			operation, err := op.Operation()
+			if err != nil {
+				return err
+			}
+
+			paths, values, err := patchTargets(operation, op)
+			if err != nil {
+				return err
+			}
+
+			for i, path := range paths {
+				if err := patchSCIMGroup(group, members, operation, path, values[i], op.Value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SCIMService) DeleteGroup(tenantID uuid.UUID, id uuid.UUID, ifMatch string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		group, err := lockSCIMGroup(tx, tenantID, id, ifMatch)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+}
+
+// updateGroup changes a group and its members under a row lock
+func (s *SCIMService) updateGroup(tenantID uuid.UUID, id uuid.UUID, ifMatch string, change func(group *models.Group, members map[uuid.UUID]bool) error) (*models.Group, error) {
+	var group *models.Group
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		group, err = lockSCIMGroup(tx, tenantID, id, ifMatch)
+		if err != nil {
+			return err
+		}
+
+		var memberIDs []uuid.UUID
+		if err := tx.Model(&models.GroupMember{}).Where("group_id = ?", group.ID).Pluck("user_id", &memberIDs).Error; err != nil {
+			return err
+		}
+
+		members := map[uuid.UUID]bool{}
+		for _, memberID := range memberIDs {
+			members[memberID] = true
+		}
+
+		previousName := group.Name
+		if err := change(group, members); err != nil {
+			return err
+		}
+
+		if group.Name != previousName {
+			if err := ensureGroupNameAvailable(tx, group); err != nil {
+				return err
+			}
+		}
+
+		// Saving also moves updated_at, the version covers the members too
+		if err := tx.Save(group).Error; err != nil {
+			return err
+		}
+
+		if err := saveSCIMMembers(tx, group, members); err != nil {
+			return err
+		}
+		return tx.First(group, "id = ?", group.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func lockSCIMGroup(tx *gorm.DB, tenantID uuid.UUID, id uuid.UUID, ifMatch string) (*models.Group, error) {
+	var group models.Group
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("tenant_id = ? AND id = ? AND provider_id IS NULL", tenantID, id).
+		First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ifMatch != "" && !scim.MatchesVersion(ifMatch, scim.Version(group.UpdatedAt)) {
+		return nil, ErrSCIMVersionMismatch
+	}
+	return &group, nil
+}
+
+// ensureGroupNameAvailable checks the name against every group of the tenant,
+// directory synced ones included
+func ensureGroupNameAvailable(tx *gorm.DB, group *models.Group) error {
+	var taken int64
+	err := tx.Model(&models.Group{}).
+		Where("tenant_id = ? AND name = ? AND id <> ?", group.TenantID, group.Name, group.ID).
+		Count(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrGroupNameTaken
+	}
+	return nil
+}
+
+// saveSCIMMembers makes members the exact membership of the group. Every member must
+// be a user of the tenant, nested groups are not supported.
+func saveSCIMMembers(tx *gorm.DB, group *models.Group, members map[uuid.UUID]bool) error {
+	userIDs := make([]uuid.UUID, 0, len(members))
+	for userID := range members {
+		userIDs = append(userIDs, userID)
+	}
+
+	if len(userIDs) > 0 {
+		var found int64
+		if err := tx.Model(&models.User{}).Where("tenant_id = ? AND id IN ?", group.TenantID, userIDs).Count(&found).Error; err != nil {
+			return err
+		}
+		if found != int64(len(userIDs)) {
+			return fmt.Errorf("%w: members must be users of this tenant", ErrSCIMInvalidValue)
+		}
+	}
+
+	removed := tx.Where("group_id = ?", group.ID)
+	if len(userIDs) > 0 {
+		removed = removed.Where("user_id NOT IN ?", userIDs)
+	}
+	if err := removed.Delete(&models.GroupMember{}).Error; err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.GroupMember{GroupID: group.ID, UserID: userID}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySCIMGroup sets the fields and members of a group from a POSTed or PUT resource
+func applySCIMGroup(group *models.Group, members map[uuid.UUID]bool, input scim.Group) error {
+	name := strings.TrimSpace(input.DisplayName)
+	if name == "" || len(name) > 255 {
+		return fmt.Errorf("%w: displayName is required and at most 255 characters", ErrSCIMInvalidValue)
+	}
+	group.Name = name
+
+	if len(input.ExternalID) > 255 {
+		return fmt.Errorf("%w: externalId is longer than 255 characters", ErrSCIMInvalidValue)
+	}
+	group.ExternalID = strings.TrimSpace(input.ExternalID)
+
+	for _, member := range input.Members {
+		userID, err := scimMemberID(member)
+		if err != nil {
+			return err
+		}
+		members[userID] = true
+	}
+	return nil
+}
+
+// patchSCIMGroup applies one operation to the attribute at path, value is nil for a
+// remove. raw is the operation's own value, which a remove of members may carry to
+// name the members to take out.
+func patchSCIMGroup(group *models.Group, members map[uuid.UUID]bool, operation string, path scim.Path, value json.RawMessage, raw json.RawMessage) error {
+	var err error
+
+	switch path.Attribute {
+	case "displayname":
+		name, err := scimString(value, "displayName", 255)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+		}
+		group.Name = name
+
+	case "externalid":
+		group.ExternalID, err = scimString(value, "externalId", 255)
+
+	case "members":
+		return patchSCIMMembers(members, operation, path, value, raw)
+	}
+
+	return err
+}
+
+// patchSCIMMembers adds, replaces or removes members. A remove takes out the members
+// matching the path filter, those listed in its value or else all of them.
+func patchSCIMMembers(members map[uuid.UUID]bool, operation string, path scim.Path, value json.RawMessage, raw json.RawMessage) error {
+	if operation == "remove" {
+		switch {
+		case path.Filter != nil:
+			for userID := range members {
+				matches, err := scimMemberMatches(path.Filter, userID)
+				if err != nil {
+					return err
+				}
+				if matches {
+					delete(members, userID)
+				}
+			}
+
+		case raw != nil && string(raw) != "null":
+			removed, err := scimMemberList(raw)
+			if err != nil {
+				return err
+			}
+			for _, userID := range removed {
+				delete(members, userID)
+			}
+
+		default:
+			clear(members)
+		}
+		return nil
+	}
+
+	if path.Filter != nil || path.SubAttribute != "" {
+		return fmt.Errorf("%w: members can only be added or replaced as a list", scim.ErrInvalidPath)
+	}
+
+	added, err := scimMemberList(value)
+	if err != nil {
+		return err
+	}
+
+	if operation == "replace" {
+		clear(members)
+	}
+	for _, userID := range added {
+		members[userID] = true
+	}
+	return nil
+}
+
+// scimMemberList decodes a list of members, or a single one
+func scimMemberList(value json.RawMessage) ([]uuid.UUID, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	var list []scim.Reference
+	if err := json.Unmarshal(value, &list); err != nil {
+		var single scim.Reference
+		if err := json.Unmarshal(value, &single); err != nil {
+			return nil, fmt.Errorf("%w: members must be a list of {\"value\": user id}", ErrSCIMInvalidValue)
+		}
+		list = []scim.Reference{single}
+	}
+
+	userIDs := make([]uuid.UUID, 0, len(list))
+	for _, member := range list {
+		userID, err := scimMemberID(member)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+func scimMemberID(member scim.Reference) (uuid.UUID, error) {
+	if member.Type != "" && !strings.EqualFold(member.Type, "User") {
+		return uuid.Nil, fmt.Errorf("%w: only users can be members", ErrSCIMInvalidValue)
+	}
+
+	userID, err := uuid.Parse(member.Value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: member %q is not a user id", ErrSCIMInvalidValue, member.Value)
+	}
+	return userID, nil
+}
+
+// scimMemberMatches evaluates the filter of a members[...] path against one member,
+// which can only be told apart by its value
+func scimMemberMatches(filter *scim.Filter, userID uuid.UUID) (bool, error) {
+	switch filter.Operator {
+	case "and", "or":
+		left, err := scimMemberMatches(filter.Operands[0], userID)
+		if err != nil {
+			return false, err
+		}
+		right, err := scimMemberMatches(filter.Operands[1], userID)
+		if err != nil {
+			return false, err
+		}
+		if filter.Operator == "and" {
+			return left && right, nil
+		}
+		return left || right, nil
+
+	case "not":
+		inner, err := scimMemberMatches(filter.Operands[0], userID)
+		return !inner, err
+	}
+
+	value, ok := filter.Value.(string)
+	if filter.Attribute != "members.value" || !ok || filter.Operator != "eq" && filter.Operator != "ne" {
+		return false, fmt.Errorf("%w: members can only be selected by value eq or ne", scim.ErrInvalidFilter)
+	}
+
+	equal := strings.EqualFold(value, userID.String())
+	if filter.Operator == "ne" {
+		return !equal, nil
+	}
+	return equal, nil
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/scim"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// scimUserAttributes are the User attributes a filter can compare. The userName is the
+// sign-in email and the single work email mirrors it.
+var scimUserAttributes = map[string]scimAttribute{
+	"id":                {column: "id::text", kind: "string"},
+	"username":          {column: "email", kind: "string"},
+	"externalid":        {column: "external_id", kind: "string", caseExact: true},
+	"name.givenname":    {column: "given_name", kind: "string"},
+	"name.familyname":   {column: "family_name", kind: "string"},
+	"emails":            {column: "email", kind: "string"},
+	"emails.value":      {column: "email", kind: "string"},
+	"emails.type":       {column: "'work'", kind: "string"},
+	"emails.primary":    {column: "TRUE", kind: "boolean"},
+	"locale":            {column: "locale", kind: "string"},
+	"preferredlanguage": {column: "locale", kind: "string"},
+	"active":            {column: "status = 'active'", kind: "boolean"},
+	"meta.created":      {column: "created_at", kind: "dateTime"},
+	"meta.lastmodified": {column: "updated_at", kind: "dateTime"},
+	"groups":            {column: "id IN (SELECT user_id FROM group_members WHERE group_id::text = ?)", kind: "reference", present: "id IN (SELECT user_id FROM group_members)"},
+	"groups.value":      {column: "id IN (SELECT user_id FROM group_members WHERE group_id::text = ?)", kind: "reference", present: "id IN (SELECT user_id FROM group_members)"},
+}
+
+func (s *SCIMService) ListUsers(tenantID uuid.UUID, query SCIMQuery) ([]models.User, int64, error) {
+	scope, err := scimScope(tenantID, query.Filter, scimUserAttributes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := s.db.Model(&models.User{}).Scopes(scope).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	if query.Count == 0 {
+		return users, total, nil
+	}
+
+	err = s.db.Scopes(scope).
+		Order("created_at ASC, id ASC").
+		Offset(query.StartIndex - 1).
+		Limit(query.Count).
+		Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *SCIMService) GetUser(tenantID uuid.UUID, id uuid.UUID) (*models.User, error) {
+	return NewUsersService(s.db).GetUser(tenantID, id)
+}
+
+// GroupsOfUsers returns the SCIM managed groups each of the users is a member of
+func (s *SCIMService) GroupsOfUsers(userIDs []uuid.UUID) (map[uuid.UUID][]models.Group, error) {
+	groups := map[uuid.UUID][]models.Group{}
+	if len(userIDs) == 0 {
+		return groups, nil
+	}
+
+	var memberships []models.GroupMember
+	err := s.db.Preload("Group").
+		Where("user_id IN ? AND group_id IN (?)", userIDs, s.db.Model(&models.Group{}).Select("id").Where("provider_id IS NULL")).
+		Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, membership := range memberships {
+		groups[membership.UserID] = append(groups[membership.UserID], membership.Group)
+	}
+	return groups, nil
+}
+
+// CreateUser provisions a user. There is no password, the user signs in through
+// the tenant's identity providers or an emailed link.
+func (s *SCIMService) CreateUser(tenant *models.Tenant, input scim.User) (*models.User, error) {
+	user := &models.User{TenantID: tenant.ID, Status: "active"}
+	if err := applySCIMUser(user, input); err != nil {
+		return nil, err
+	}
+
+	usersService := NewUsersService(s.db)
+	if existing, _ := usersService.GetUserByEmail(tenant.ID, user.Email); existing != nil {
+		return nil, ErrUserAlreadyExists
+	}
+
+	if err := s.db.Create(user).Error; err != nil {
+		// Lost a race against another request, the unique (tenant_id, email) index caught it
+		if existing, _ := usersService.GetUserByEmail(tenant.ID, user.Email); existing != nil {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	// Read back so meta.version matches what a later GET returns
+	return s.GetUser(tenant.ID, user.ID)
+}
+
+// ReplaceUser is a PUT: every writable attribute takes the value sent, leaving one
+// out clears it. ifMatch is the If-Match header, empty to skip the version check.
+func (s *SCIMService) ReplaceUser(tenantID uuid.UUID, id uuid.UUID, ifMatch string, input scim.User) (*models.User, error) {
+	return s.updateUser(tenantID, id, ifMatch, func(user *models.User) error {
+		user.ExternalID, user.GivenName, user.FamilyName, user.Locale = "", "", "", ""
+		return applySCIMUser(user, input)
+	})
+}
+
+// PatchUser applies the operations of a PATCH in order, all or none of them
+func (s *SCIMService) PatchUser(tenantID uuid.UUID, id uuid.UUID, ifMatch string, operations []scim.PatchOperation) (*models.User, error) {
+	return s.updateUser(tenantID, id, ifMatch, func(user *models.User) error {
+		for _, op := range operations {
+			operation, err := op.Operation()
+			if err != nil {
+				return err
+			}
+
+			paths, values, err := patchTargets(operation, op)
+			if err != nil {
+				return err
+			}
+
+			for i, path := range paths {
+				if err := patchSCIMUser(user, path, values[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// DeprovisionUser answers a DELETE. The user is suspended rather than deleted, so the
+// record and its audit trail stay and a later PATCH can reactivate it.
+func (s *SCIMService) DeprovisionUser(tenantID uuid.UUID, id uuid.UUID, ifMatch string) error {
+	_, err := s.updateUser(tenantID, id, ifMatch, func(user *models.User) error {
+		user.Status = "suspended"
+		return nil
+	})
+	return err
+}
+
+// updateUser changes a user under a row lock. Moving from active to suspended ends
+// the user's sessions and refresh tokens, which is what deprovisioning means here.
+func (s *SCIMService) updateUser(tenantID uuid.UUID, id uuid.UUID, ifMatch string, change func(user *models.User) error) (*models.User, error) {
+	var user models.User
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("tenant_id = ? AND id = ?", tenantID, id).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if ifMatch != "" && !scim.MatchesVersion(ifMatch, scim.Version(user.UpdatedAt)) {
+			return ErrSCIMVersionMismatch
+		}
+
+		previousEmail, previousStatus := user.Email, user.Status
+		if err := change(&user); err != nil {
+			return err
+		}
+
+		if user.Email != previousEmail {
+			var taken int64
+			err := tx.Model(&models.User{}).
+				Where("tenant_id = ? AND LOWER(email) = ? AND id <> ?", tenantID, user.Email, user.ID).
+				Count(&taken).Error
+			if err != nil {
+				return err
+			}
+			if taken > 0 {
+				return ErrUserAlreadyExists
+			}
+		}
+
+		if previousStatus == "active" && user.Status == "suspended" {
+			if err := revokeUserCredentials(tx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return tx.First(&user, "id = ?", user.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// applySCIMUser sets the fields of a user from a POSTed or PUT resource
+func applySCIMUser(user *models.User, input scim.User) error {
+	email, err := normalizeEmail(input.UserName)
+	if err != nil {
+		return fmt.Errorf("%w: userName must be an email address", ErrSCIMInvalidValue)
+	}
+	user.Email = email
+
+	// The tenant's own provisioning vouches for the address
+	user.EmailVerified = true
+
+	fields := map[scim.Path]string{{Attribute: "externalid"}: input.ExternalID}
+	if input.Name != nil {
+		fields[scim.Path{Attribute: "name", SubAttribute: "givenname"}] = input.Name.GivenName
+		fields[scim.Path{Attribute: "name", SubAttribute: "familyname"}] = input.Name.FamilyName
+	}
+	if input.Locale != "" {
+		fields[scim.Path{Attribute: "locale"}] = input.Locale
+	} else if input.PreferredLanguage != "" {
+		fields[scim.Path{Attribute: "locale"}] = input.PreferredLanguage
+	}
+
+	for path, value := range fields {
+		encoded, _ := json.Marshal(value)
+		if err := patchSCIMUser(user, path, encoded); err != nil {
+			return err
+		}
+	}
+
+	if input.Active != nil {
+		setSCIMUserActive(user, bool(*input.Active))
+	}
+	return nil
+}
+
+// patchSCIMUser sets the attribute at path, value nil removes it. Attributes the tenant
+// does not keep are dropped as they are on create, clients send all they have mapped.
+func patchSCIMUser(user *models.User, path scim.Path, value json.RawMessage) error {
+	var err error
+
+	switch path.Name() {
+	case "username":
+		if value == nil {
+			return fmt.Errorf("%w: userName is required", ErrSCIMInvalidValue)
+		}
+		var userName string
+		if err := json.Unmarshal(value, &userName); err != nil {
+			return fmt.Errorf("%w: userName must be a string", ErrSCIMInvalidValue)
+		}
+		user.Email, err = normalizeEmail(userName)
+		if err != nil {
+			return fmt.Errorf("%w: userName must be an email address", ErrSCIMInvalidValue)
+		}
+
+	case "externalid":
+		user.ExternalID, err = scimString(value, "externalId", 255)
+
+	case "name":
+		if value == nil {
+			user.GivenName, user.FamilyName = "", ""
+			return nil
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return fmt.Errorf("%w: name must be an object", ErrSCIMInvalidValue)
+		}
+		for key, field := range fields {
+			if err := patchSCIMUser(user, scim.Path{Attribute: "name", SubAttribute: strings.ToLower(key)}, patchValue("replace", field)); err != nil {
+				return err
+			}
+		}
+
+	case "name.givenname":
+		user.GivenName, err = scimString(value, "name.givenName", 255)
+
+	case "name.familyname":
+		user.FamilyName, err = scimString(value, "name.familyName", 255)
+
+	case "locale", "preferredlanguage":
+		user.Locale, err = scimString(value, "locale", 10)
+
+	case "active":
+		var active scim.Bool
+		if value == nil || json.Unmarshal(value, &active) != nil {
+			return fmt.Errorf("%w: active must be true or false", ErrSCIMInvalidValue)
+		}
+		setSCIMUserActive(user, bool(active))
+	}
+
+	return err
+}
+
+func setSCIMUserActive(user *models.User, active bool) {
+	if active {
+		user.Status = "active"
+	} else {
+		user.Status = "suspended"
+	}
+}
+
+// scimScope limits a query to the tenant and the filter
+func scimScope(tenantID uuid.UUID, filter string, attributes map[string]scimAttribute) (func(*gorm.DB) *gorm.DB, error) {
+	condition, args := "TRUE", []any(nil)
+	if filter != "" {
+		var err error
+		condition, args, err = compileSCIMFilter(filter, attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantID).Where(condition, args...)
+	}, nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/scim"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestCompileSCIMFilter(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		filter    string
+		condition string
+		args      []any
+	}{
+		{filter: `userName eq "Ada@Example.com"`, condition: `LOWER(COALESCE(email, '')) = LOWER(?)`, args: []any{"Ada@Example.com"}},
+		{filter: `externalId eq "E1"`, condition: `COALESCE(external_id, '') = ?`, args: []any{"E1"}},
+		{filter: `userName co "50%_off"`, condition: `LOWER(COALESCE(email, '')) LIKE LOWER(?) ESCAPE '\'`, args: []any{`%50\%\_off%`}},
+		{filter: `userName sw "ada"`, condition: `LOWER(COALESCE(email, '')) LIKE LOWER(?) ESCAPE '\'`, args: []any{"ada%"}},
+		{filter: `name.familyName pr`, condition: `(family_name IS NOT NULL AND family_name <> '')`},
+		{filter: `externalId eq null`, condition: `NOT (external_id IS NOT NULL AND external_id <> '')`},
+		{filter: `active eq false`, condition: `COALESCE(status = 'active', FALSE) = ?`, args: []any{false}},
+		{filter: `meta.created gt "2024-01-01T00:00:00Z"`, condition: `created_at > ?`, args: []any{created}},
+		{
+			filter:    `not (active eq true) or userName eq "a" and name.givenName sw "b"`,
+			condition: `(NOT COALESCE(COALESCE(status = 'active', FALSE) = ?, FALSE) OR (LOWER(COALESCE(email, '')) = LOWER(?) AND LOWER(COALESCE(given_name, '')) LIKE LOWER(?) ESCAPE '\'))`,
+			args:      []any{true, "a", "b%"},
+		},
+	}
+
+	for _, test := range tests {
+		condition, args, err := compileSCIMFilter(test.filter, scimUserAttributes)
+		if err != nil || condition != test.condition || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("compileSCIMFilter(%s) = %s %v, %v, want %s %v", test.filter, condition, args, err, test.condition, test.args)
+		}
+	}
+}
+
+func TestCompileSCIMFilterRejectsUnsupported(t *testing.T) {
+	filters := []string{
+		// Attributes that are not stored, or not filterable
+		`title eq "x"`,
+		`password eq "x"`,
+		`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber eq "7"`,
+		`userName eq "x" and nickName pr`,
+		`not (addresses.country eq "NL")`,
+		// Comparisons that do not fit the attribute type
+		`active gt true`,
+		`active eq "yes"`,
+		`userName eq 1`,
+		`userName co null`,
+		`meta.created eq "yesterday"`,
+		`meta.created co "2024"`,
+		`groups.value sw "a"`,
+		// Malformed
+		`userName eq`,
+		`(userName eq "x"`,
+	}
+
+	for _, filter := range filters {
+		if condition, _, err := compileSCIMFilter(filter, scimUserAttributes); !errors.Is(err, scim.ErrInvalidFilter) {
+			t.Errorf("compileSCIMFilter(%s) = %s, %v, want ErrInvalidFilter", filter, condition, err)
+		}
+	}
+}
+
+func TestDeprovisionUser(t *testing.T) {
+	db, mock := mockDB(t)
+	tenantID, userID := uuid.New(), uuid.New()
+
+	// The user is kept, suspended, and signed out everywhere in the same transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2 .* FOR UPDATE`).
+		WithArgs(tenantID, userID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "status"}).
+			AddRow(userID, tenantID, "ada@example.com", "active"))
+	mock.ExpectExec(`UPDATE "sessions" SET "revoked_at"=\$1 WHERE user_id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE user_id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	arg := sqlmock.AnyArg()
+	status := &captured{}
+	mock.ExpectExec(`UPDATE "users" SET .*"status"=\$13,"external_id"=\$14 WHERE "id" = \$15`).
+		WithArgs(tenantID, arg, arg, arg, arg, arg, arg, arg, arg, arg, arg, arg, status, arg, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE id = \$1 AND "users"."id" = \$2`).
+		WithArgs(userID, userID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "status"}).
+			AddRow(userID, tenantID, "ada@example.com", "suspended"))
+	mock.ExpectCommit()
+
+	if err := NewSCIMService(db).DeprovisionUser(tenantID, userID, ""); err != nil {
+		t.Fatalf("DeprovisionUser = %v", err)
+	}
+	if status.value != "suspended" {
+		t.Fatalf("saved status %v, want suspended", status.value)
+	}
+}
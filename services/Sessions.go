@@ -0,0 +1,328 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/packages/webauthn"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SessionService manages end user sign-in sessions on a tenant issuer, their
+// lifetime and idle timeout come from the tenant session settings
+type SessionService struct {
+	db    *gorm.DB
+	store throttle.Store
+}
+
+// NewSessionService optionally takes the login throttle store, Postgres by default
+func NewSessionService(db *gorm.DB, store ...throttle.Store) *SessionService {
+	return &SessionService{db: db, store: throttleStore(db, store)}
+}
+
+// Login verifies the end user's password and starts a session. clientID is the
+// client that sent the user to sign in, if any. The returned token is the cookie
+// value and is never stored in plain text.
+func (s *SessionService) Login(tenant *models.Tenant, email string, plainPassword string, clientID *uuid.UUID, userAgent string, ipAddress string) (*models.Session, string, error) {
+	us := NewUsersService(s.db, s.store)
+	user, err := us.checkCredentials(tenant, email, plainPassword, userAgent, ipAddress)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if us.PasswordExpired(tenant, user, now) {
+		return nil, "", ErrPasswordExpired
+	}
+
+	return s.firstFactorPassed(tenant, user, clientID, models.AMRPassword, userAgent, ipAddress)
+}
+
+// firstFactorPassed starts the session, or asks for the authenticator of users who have
+// one. When the tenant requires MFA, users without one have to set it up first.
+func (s *SessionService) firstFactorPassed(tenant *models.Tenant, user *models.User, clientID *uuid.UUID, method string, userAgent string, ipAddress string) (*models.Session, string, error) {
+	enabled, err := NewMFAService(s.db, s.store).HasTOTP(UserMFAOwner(user.ID))
+	if err != nil {
+		return nil, "", err
+	}
+
+	subject := userChallengeSubject(user.ID, clientID, method)
+	if enabled {
+		return nil, "", newMFAChallenge(mfaChallengeUser, subject)
+	}
+
+	if tenant.Settings.MFA.Required {
+		if !TOTPAllowed(tenant) {
+			return nil, "", ErrMFAPasskeyRequired
+		}
+		return nil, "", newMFAChallenge(mfaChallengeEnroll, subject)
+	}
+
+	return s.completeLogin(tenant, user, clientID, []string{method}, userAgent, ipAddress)
+}
+
+// VerifyMFA finishes a login that returned an MFARequiredError. The session
+// records both factors so tokens issued from it carry the otp amr.
+func (s *SessionService) VerifyMFA(tenant *models.Tenant, challenge string, code string, userAgent string, ipAddress string) (*models.Session, string, error) {
+	user, clientID, method, err := s.challengeUser(tenant, mfaChallengeUser, challenge)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := NewMFAService(s.db, s.store).Verify(UserMFAOwner(user.ID), code, tenant.Settings.Lockout); err != nil {
+		return nil, "", err
+	}
+
+	return s.completeLogin(tenant, user, clientID, []string{method, models.AMROTP}, userAgent, ipAddress)
+}
+
+// StartMFAEnrollment returns a new authenticator secret during a login that returned
+// an MFARequiredError with Enroll set. issuer labels the entry in the app.
+func (s *SessionService) StartMFAEnrollment(tenant *models.Tenant, challenge string, issuer string) (*TOTPEnrollment, error) {
+	user, _, _, err := s.challengeUser(tenant, mfaChallengeEnroll, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if !TOTPAllowed(tenant) {
+		return nil, ErrMFAMethodNotAllowed
+	}
+
+	return NewMFAService(s.db, s.store).StartTOTPEnrollment(UserMFAOwner(user.ID), issuer, user.Email)
+}
+
+// CompleteMFAEnrollment confirms the authenticator and finishes the login with both
+// factors. The recovery codes are only ever returned here.
+func (s *SessionService) CompleteMFAEnrollment(tenant *models.Tenant, challenge string, code string, userAgent string, ipAddress string) (*models.Session, string, []string, error) {
+	user, clientID, method, err := s.challengeUser(tenant, mfaChallengeEnroll, challenge)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	codes, err := NewMFAService(s.db, s.store).ConfirmTOTP(UserMFAOwner(user.ID), code)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	session, token, err := s.completeLogin(tenant, user, clientID, []string{method, models.AMROTP}, userAgent, ipAddress)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return session, token, codes, nil
+}
+
+// challengeUser checks a login challenge and returns the still active user who passed
+// the first factor, the client they were sent by and the first factor method
+func (s *SessionService) challengeUser(tenant *models.Tenant, purpose string, challenge string) (*models.User, *uuid.UUID, string, error) {
+	fields, err := verifyMFAChallenge(purpose, challenge)
+	if err != nil || len(fields) != 3 || fields[2] == "" {
+		return nil, nil, "", ErrMFAChallengeInvalid
+	}
+
+	userID, err := uuid.Parse(fields[0])
+	if err != nil {
+		return nil, nil, "", ErrMFAChallengeInvalid
+	}
+
+	var clientID *uuid.UUID
+	if fields[1] != "" {
+		id, err := uuid.Parse(fields[1])
+		if err != nil {
+			return nil, nil, "", ErrMFAChallengeInvalid
+		}
+		clientID = &id
+	}
+
+	user, err := NewUsersService(s.db, s.store).GetUser(tenant.ID, userID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, nil, "", ErrMFAChallengeInvalid
+	}
+
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if user.Status != "active" {
+		return nil, nil, "", ErrUserSuspended
+	}
+
+	return user, clientID, fields[2], nil
+}
+
+// LoginWithPasskey starts a session from a passkey assertion. User verification is
+// required for passkeys, so no further factor is asked for.
+func (s *SessionService) LoginWithPasskey(tenant *models.Tenant, cfg webauthn.Config, response webauthn.AssertionResponse, clientID *uuid.UUID, userAgent string, ipAddress string) (*models.Session, string, error) {
+	user, err := NewPasskeyService(s.db).Authenticate(cfg, tenant, response)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s.PasskeySession(tenant, user, clientID, userAgent, ipAddress)
+}
+
+// LoginWithEmail starts a session for a user who proved their email address with a
+// magic link or code. Users with an authenticator still get an MFA challenge.
+func (s *SessionService) LoginWithEmail(tenant *models.Tenant, user *models.User, clientID *uuid.UUID, userAgent string, ipAddress string) (*models.Session, string, error) {
+	return s.firstFactorPassed(tenant, user, clientID, models.AMREmail, userAgent, ipAddress)
+}
+
+// LoginWithFederation starts a session for a user who signed in at an upstream
+// identity provider. Its MFA is not visible here, so a TOTP challenge still applies.
+func (s *SessionService) LoginWithFederation(tenant *models.Tenant, user *models.User, clientID *uuid.UUID, userAgent string, ipAddress string) (*models.Session, string, error) {
+	return s.firstFactorPassed(tenant, user, clientID, models.AMRFederated, userAgent, ipAddress)
+}
+
+// LoginWithLDAP checks the password against the directory the email's domain is
+// routed to. Failures count towards the same lockout as local passwords, so the
+// directory is not used to guess passwords either.
+func (s *SessionService) LoginWithLDAP(ctx context.Context, tenant *models.Tenant, provider *models.IdentityProvider, email string, plainPassword string, clientID *uuid.UUID, userAgent string, ipAddress string) (*models.Session, string, error) {
+	if email == "" || plainPassword == "" {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	guard := NewLoginGuard(s.db, s.store)
+	attempt := LoginAttempt{
+		TenantID:  &tenant.ID,
+		Email:     email,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Settings:  tenant.Settings.Lockout,
+	}
+
+	now := time.Now()
+	if err := guard.Check(attempt, now); err != nil {
+		return nil, "", err
+	}
+
+	user, err := NewFederationService(s.db).AuthenticateLDAP(ctx, tenant, provider, email, plainPassword)
+	if errors.Is(err, ErrInvalidCredentials) {
+		if lockErr := guard.Failed(attempt, nil, nil, now); lockErr != nil {
+			return nil, "", lockErr
+		}
+		return nil, "", ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := guard.Succeeded(attempt); err != nil {
+		return nil, "", err
+	}
+
+	return s.firstFactorPassed(tenant, user, clientID, models.AMRPassword, userAgent, ipAddress)
+}
+
+// PasskeySession signs in a user who just proved a passkey, e.g. right after a passkey signup
+func (s *SessionService) PasskeySession(tenant *models.Tenant, user *models.User, clientID *uuid.UUID, userAgent string, ipAddress string) (*models.Session, string, error) {
+	return s.completeLogin(tenant, user, clientID, []string{models.AMRHardwareKey, models.AMRMultiFactor}, userAgent, ipAddress)
+}
+
+// userChallengeSubject is userID:clientID:first factor method, clientID may be empty
+func userChallengeSubject(userID uuid.UUID, clientID *uuid.UUID, method string) string {
+	subject := userID.String() + ":"
+	if clientID != nil {
+		subject += clientID.String()
+	}
+	return subject + ":" + method
+}
+
+func (s *SessionService) completeLogin(tenant *models.Tenant, user *models.User, clientID *uuid.UUID, amr []string, userAgent string, ipAddress string) (*models.Session, string, error) {
+	if err := s.db.Model(user).Update("last_login_at", time.Now()).Error; err != nil {
+		return nil, "", err
+	}
+
+	return s.createSession(tenant, user.ID, clientID, amr, userAgent, ipAddress)
+}
+
+func (s *SessionService) createSession(tenant *models.Tenant, userID uuid.UUID, clientID *uuid.UUID, amr []string, userAgent string, ipAddress string) (*models.Session, string, error) {
+	token, err := tokens.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	csrfToken, err := tokens.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:         userID,
+		ClientID:       clientID,
+		TokenHash:      tokens.Hash(token),
+		CSRFToken:      csrfToken,
+		AMR:            amr,
+		ACR:            models.ACRForAMR(amr),
+		UserAgent:      userAgent,
+		IPAddress:      ipAddress,
+		LastActivityAt: now,
+		ExpiresAt:      now.Add(time.Duration(tenant.Settings.Session.AbsoluteTimeout) * time.Second),
+	}
+
+	if err := s.db.Create(session).Error; err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// Authenticate resolves a cookie token to an active session of a user in the tenant
+func (s *SessionService) Authenticate(tenant *models.Tenant, token string) (*models.Session, error) {
+	if token == "" {
+		return nil, ErrSessionInvalid
+	}
+
+	var session models.Session
+	err := s.db.Preload("User").
+		Where("token_hash = ? AND revoked_at IS NULL", tokens.Hash(token)).
+		First(&session).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A cookie from another tenant's issuer is never valid here
+	if session.User.TenantID != tenant.ID || session.User.Status != "active" {
+		return nil, ErrSessionInvalid
+	}
+
+	now := time.Now()
+	idle := time.Duration(tenant.Settings.Session.IdleTimeout) * time.Second
+	if now.After(session.ExpiresAt) || now.Sub(session.LastActivityAt) > idle {
+		return nil, ErrSessionInvalid
+	}
+
+	// Avoid a write on every request, a minute of precision is plenty for idle tracking
+	if now.Sub(session.LastActivityAt) > time.Minute {
+		session.LastActivityAt = now
+		if err := s.db.Model(&session).Update("last_activity_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+func (s *SessionService) Revoke(sessionID uuid.UUID) error {
+	return s.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllForUser ends every session of the user, e.g. after a password change
+func (s *SessionService) RevokeAllForUser(userID uuid.UUID) error {
+	return s.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
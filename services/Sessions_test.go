@@ -0,0 +1,150 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"DigiPassAuthenticationApi/packages/totp"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestMFARequiredEnrollment(t *testing.T) {
+	t.Setenv("TOKEN_SIGNING_SECRET", "test-secret")
+
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	tenant.Settings.MFA = models.MFASettings{Required: true, Methods: []string{"totp", "webauthn"}}
+	user := &models.User{ID: uuid.New(), TenantID: tenant.ID, Email: "ada@example.com", Status: "active"}
+
+	expectHasTOTP := func(mock sqlmock.Sqlmock, count int) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "totp_credentials" WHERE user_id = \$1 AND confirmed_at IS NOT NULL`).
+			WithArgs(user.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+	}
+	expectUser := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE tenant_id = \$1 AND id = \$2`).
+			WithArgs(tenant.ID, user.ID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "status"}).
+				AddRow(user.ID, tenant.ID, user.Email, user.Status))
+	}
+
+	db, mock := mockDB(t)
+	sessions := NewSessionService(db, throttle.NewMemoryStore())
+
+	// No session yet, only a challenge that leads to the enrollment steps
+	expectHasTOTP(mock, 0)
+	session, _, err := sessions.LoginWithEmail(tenant, user, nil, "", "203.0.113.7")
+
+	var mfaErr *MFARequiredError
+	if !errors.As(err, &mfaErr) || !mfaErr.Enroll || session != nil {
+		t.Fatalf("LoginWithEmail = %+v, %v, want an enrollment challenge", session, err)
+	}
+
+	// The challenge does not stand in for a code at the verify step
+	if _, _, err := sessions.VerifyMFA(tenant, mfaErr.Token, "123456", "", "203.0.113.7"); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("VerifyMFA with an enrollment challenge = %v, want ErrMFAChallengeInvalid", err)
+	}
+
+	sealed := &captured{}
+	expectUser(mock)
+	expectHasTOTP(mock, 0)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "totp_credentials" WHERE user_id = \$1`).
+		WithArgs(user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "totp_credentials"`).
+		WithArgs(user.ID, nil, sealed, 0, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "last_used_step"}).AddRow(uuid.New(), 0))
+	mock.ExpectCommit()
+
+	enrollment, err := sessions.StartMFAEnrollment(tenant, mfaErr.Token, "Acme")
+	if err != nil || enrollment.Secret == "" {
+		t.Fatalf("StartMFAEnrollment = %+v, %v", enrollment, err)
+	}
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUser(mock)
+	mock.ExpectQuery(`SELECT \* FROM "totp_credentials" WHERE user_id = \$1 AND confirmed_at IS NULL`).
+		WithArgs(user.ID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret_sealed", "last_used_step"}).
+			AddRow(uuid.New(), user.ID, sealed.value, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "totp_credentials" SET "confirmed_at"=\$1,"last_used_step"=\$2 WHERE confirmed_at IS NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "recovery_codes" WHERE user_id = \$1`).
+		WithArgs(user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "recovery_codes"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE "users" SET "last_login_at"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "sessions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+
+	session, token, codes, err := sessions.CompleteMFAEnrollment(tenant, mfaErr.Token, code, "", "203.0.113.7")
+	if err != nil || token == "" || len(codes) != RecoveryCodeCount {
+		t.Fatalf("CompleteMFAEnrollment = %+v, %v, %v", session, codes, err)
+	}
+	if !slices.Equal(session.AMR, []string{models.AMREmail, models.AMROTP}) || !MFASatisfied(tenant, session.AMR) {
+		t.Fatalf("session amr = %v, want email and otp", session.AMR)
+	}
+	if sealed.value == enrollment.Secret {
+		t.Fatalf("stored the authenticator secret in plain text")
+	}
+	if opened, _ := tokens.Open(sealed.value.(string)); opened != enrollment.Secret {
+		t.Fatalf("stored secret opens to %q, want %q", opened, enrollment.Secret)
+	}
+}
+
+func TestMFARequiredWithoutTOTP(t *testing.T) {
+	db, mock := mockDB(t)
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	tenant.Settings.MFA = models.MFASettings{Required: true, Methods: []string{"webauthn"}}
+	user := &models.User{ID: uuid.New(), TenantID: tenant.ID, Status: "active"}
+
+	// Nothing to enroll here, the user has to come back with a passkey
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "totp_credentials" WHERE user_id = \$1 AND confirmed_at IS NOT NULL`).
+		WithArgs(user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	if session, _, err := NewSessionService(db, throttle.NewMemoryStore()).LoginWithFederation(tenant, user, nil, "", "203.0.113.7"); !errors.Is(err, ErrMFAPasskeyRequired) {
+		t.Fatalf("LoginWithFederation = %+v, %v, want ErrMFAPasskeyRequired", session, err)
+	}
+}
+
+func TestMFASatisfied(t *testing.T) {
+	required := &models.Tenant{Settings: models.DefaultTenantSettings()}
+	required.Settings.MFA = models.MFASettings{Required: true, Methods: []string{"totp"}}
+	optional := &models.Tenant{Settings: models.DefaultTenantSettings()}
+	optional.Settings.MFA.Required = false
+
+	tests := []struct {
+		name   string
+		tenant *models.Tenant
+		amr    []string
+		want   bool
+	}{
+		{name: "password and code", tenant: required, amr: []string{models.AMRPassword, models.AMROTP}, want: true},
+		{name: "passkey", tenant: required, amr: []string{models.AMRHardwareKey, models.AMRMultiFactor}, want: true},
+		{name: "password only", tenant: required, amr: []string{models.AMRPassword}},
+		{name: "email and federation", tenant: required, amr: []string{models.AMREmail, models.AMRFederated}},
+		{name: "password only, mfa optional", tenant: optional, amr: []string{models.AMRPassword}, want: true},
+	}
+
+	for _, test := range tests {
+		if got := MFASatisfied(test.tenant, test.amr); got != test.want {
+			t.Errorf("%s: MFASatisfied(%v) = %v, want %v", test.name, test.amr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/jwt"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/saml"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SigningKeyLifetime is how long a tenant signing certificate is valid. Service
+// providers pin the certificate from the metadata, so it is long lived.
+const SigningKeyLifetime = 10 * 365 * 24 * time.Hour
+
+// SigningKeyService manages the per tenant key pairs that sign SAML messages and tokens
+type SigningKeyService struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyService(db *gorm.DB) *SigningKeyService {
+	return &SigningKeyService{db: db}
+}
+
+// ActiveKey returns the tenant's active signing key, generating one on first use
+func (s *SigningKeyService) ActiveKey(tenant *models.Tenant) (*saml.Signer, error) {
+	var key models.TenantSigningKey
+	err := s.db.Where("tenant_id = ? AND active AND expires_at > ?", tenant.ID, time.Now()).
+		Order("created_at DESC").
+		First(&key).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.generate(tenant)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return openSigningKey(&key)
+}
+
+// TokenKey is the active key as a JWT signing key
+func (s *SigningKeyService) TokenKey(tenant *models.Tenant) (jwt.Key, error) {
+	signer, err := s.ActiveKey(tenant)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	return jwt.Key{ID: jwt.KeyID(signer.Certificate), Private: signer.Key}, nil
+}
+
+// VerificationKeys are the public keys tokens of the tenant may be signed with, by
+// kid. Keys that were rotated out still verify until their certificate expires.
+func (s *SigningKeyService) VerificationKeys(tenantID uuid.UUID) (map[string]*rsa.PublicKey, error) {
+	var keys []models.TenantSigningKey
+	err := s.db.Where("tenant_id = ? AND expires_at > ?", tenantID, time.Now()).Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	public := make(map[string]*rsa.PublicKey, len(keys))
+	for _, key := range keys {
+		certificate, err := parseCertificatePEM(key.Certificate)
+		if err != nil {
+			return nil, err
+		}
+
+		rsaKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not an RSA key", key.ID)
+		}
+		public[jwt.KeyID(certificate)] = rsaKey
+	}
+	return public, nil
+}
+
+func (s *SigningKeyService) generate(tenant *models.Tenant) (*saml.Signer, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: tenant.Name, SerialNumber: tenant.ID.String()},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SigningKeyLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := tokens.Seal(base64.StdEncoding.EncodeToString(pkcs8))
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Create(&models.TenantSigningKey{
+		TenantID:         tenant.ID,
+		Algorithm:        "RS256",
+		PrivateKeySealed: sealed,
+		Certificate:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Active:           true,
+		ExpiresAt:        certificate.NotAfter,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.Signer{Key: privateKey, Certificate: certificate}, nil
+}
+
+func openSigningKey(key *models.TenantSigningKey) (*saml.Signer, error) {
+	encoded, err := tokens.Open(key.PrivateKeySealed)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcs8, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an RSA key", key.ID)
+	}
+
+	certificate, err := parseCertificatePEM(key.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	return &saml.Signer{Key: privateKey, Certificate: certificate}, nil
+}
+
+func parseCertificatePEM(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -3,9 +3,19 @@ package services
 import (
 	"DigiPassAuthenticationApi/packages/models"
 	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"strings"
+	"time"
 )
 
+// TenantDeletionGracePeriod is how long a deleted tenant can be restored before it is purged
+const TenantDeletionGracePeriod = 30 * 24 * time.Hour
+
+// TenantSlugAliasPeriod is how long an old slug keeps redirecting after a change
+const TenantSlugAliasPeriod = 90 * 24 * time.Hour
+
 type TenantService struct {
 	db *gorm.DB
 }
@@ -20,15 +30,312 @@ func (s *TenantService) CreateUniqueTenantSlug() (string, error) {
 	for range maxAttempts {
 		slug := models.Tenant{}.CreateSlug()
 
-		var count int64
-		err := s.db.Model(&models.Tenant{}).Where("slug = ?", slug).Count(&count).Error
+		inUse, err := s.slugInUse(slug, uuid.Nil)
 		if err != nil {
 			return "", err
 		}
 
-		if count == 0 {
+		if !inUse {
 			return slug, nil
 		}
 	}
 	return "", errors.New("Failed to generate unique slug after multiple attempts")
 }
+
+// CreateTenant adds another environment (dev/staging/prod) to an account
+func (s *TenantService) CreateTenant(accountID uuid.UUID, name string) (*models.Tenant, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrTenantInvalid)
+	}
+
+	if err := s.ensureNameAvailable(accountID, name, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	slug, err := s.CreateUniqueTenantSlug()
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := &models.Tenant{
+		AccountID: accountID,
+		Slug:      slug,
+		Name:      name,
+		Status:    "active",
+	}
+
+	if err := s.db.Create(tenant).Error; err != nil {
+		return nil, err
+	}
+
+	return tenant, nil
+}
+
+func (s *TenantService) ListTenants(accountID uuid.UUID) ([]models.Tenant, error) {
+	var tenants []models.Tenant
+
+	err := s.db.Where("account_id = ?", accountID).Order("created_at ASC").Find(&tenants).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
+// GetTenant looks up a tenant scoped to the caller's account
+func (s *TenantService) GetTenant(accountID uuid.UUID, id uuid.UUID) (*models.Tenant, error) {
+	var tenant models.Tenant
+
+	err := s.db.Where("account_id = ? AND id = ?", accountID, id).First(&tenant).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenant, nil
+}
+
+func (s *TenantService) RenameTenant(accountID uuid.UUID, id uuid.UUID, name string) (*models.Tenant, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrTenantInvalid)
+	}
+
+	tenant, err := s.GetTenant(accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant.Status == "deleted" {
+		return nil, ErrInvalidStatusTransition
+	}
+
+	if err := s.ensureNameAvailable(accountID, name, tenant.ID); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(tenant).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+
+	return tenant, nil
+}
+
+// UpdateStatus moves a tenant between active, suspended and deleted.
+// A deleted tenant can only be restored to active within the grace period.
+func (s *TenantService) UpdateStatus(accountID uuid.UUID, id uuid.UUID, status string) (*models.Tenant, error) {
+	tenant, err := s.GetTenant(accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant.Status == status {
+		return tenant, nil
+	}
+
+	updates := map[string]any{"status": status}
+
+	switch status {
+	case "active":
+		if tenant.Status == "deleted" {
+			if tenant.DeletedAt == nil || time.Since(*tenant.DeletedAt) > TenantDeletionGracePeriod {
+				return nil, ErrInvalidStatusTransition
+			}
+			updates["deleted_at"] = nil
+		}
+	case "suspended":
+		if tenant.Status == "deleted" {
+			return nil, ErrInvalidStatusTransition
+		}
+	case "deleted":
+		updates["deleted_at"] = time.Now()
+	default:
+		return nil, fmt.Errorf("%w: status must be one of active, suspended, deleted", ErrTenantInvalid)
+	}
+
+	if err := s.db.Model(tenant).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetTenant(accountID, id)
+}
+
+// DeleteTenant soft deletes, the tenant and its data are purged after the grace period
+func (s *TenantService) DeleteTenant(accountID uuid.UUID, id uuid.UUID) (*models.Tenant, error) {
+	return s.UpdateStatus(accountID, id, "deleted")
+}
+
+// EnsureActive is the gate for logins and token issuance, only active tenants of
+// active accounts may authenticate. Account suspension cascades to every tenant here.
+func (s *TenantService) EnsureActive(tenant *models.Tenant) error {
+	switch tenant.Status {
+	case "suspended":
+		return ErrTenantSuspended
+	case "deleted":
+		return ErrRecordNotFound
+	}
+
+	var accountStatus string
+	err := s.db.Model(&models.Account{}).Select("status").Where("id = ?", tenant.AccountID).Scan(&accountStatus).Error
+	if err != nil {
+		return err
+	}
+
+	switch accountStatus {
+	case "active":
+		return nil
+	case "suspended":
+		return ErrAccountSuspended
+	case "pending_verification":
+		return ErrAccountNotVerified
+	}
+	return ErrRecordNotFound
+}
+
+// PurgeDeletedTenants hard deletes tenants past the grace period, relying on
+// OnDelete:CASCADE to remove their clients, users and tokens
+func (s *TenantService) PurgeDeletedTenants(now time.Time) (int64, error) {
+	result := s.db.Where("status = ? AND deleted_at < ?", "deleted", now.Add(-TenantDeletionGracePeriod)).
+		Delete(&models.Tenant{})
+
+	return result.RowsAffected, result.Error
+}
+
+func (s *TenantService) ensureNameAvailable(accountID uuid.UUID, name string, excludingID uuid.UUID) error {
+	var count int64
+	err := s.db.Model(&models.Tenant{}).
+		Where("account_id = ? AND name = ? AND id <> ?", accountID, name, excludingID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrTenantNameTaken
+	}
+
+	return nil
+}
+
+func (s *TenantService) GetTenantBySlug(slug string) (*models.Tenant, error) {
+	var tenant models.Tenant
+
+	err := s.db.Where("slug = ?", strings.ToLower(slug)).First(&tenant).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenant, nil
+}
+
+// ChangeSlug sets a custom slug, the previous one is kept as a redirecting alias
+// for TenantSlugAliasPeriod so existing issuer URLs keep working
+func (s *TenantService) ChangeSlug(accountID uuid.UUID, id uuid.UUID, slug string) (*models.Tenant, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if err := models.ValidateSlug(slug); err != nil {
+		return nil, err
+	}
+
+	tenant, err := s.GetTenant(accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant.Status == "deleted" {
+		return nil, ErrInvalidStatusTransition
+	}
+
+	if tenant.Slug == slug {
+		return tenant, nil
+	}
+
+	inUse, err := s.slugInUse(slug, tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		return nil, ErrSlugTaken
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Switching back to one of our own old slugs reclaims it from the alias table,
+		// expired aliases not purged yet would otherwise still hold the unique slug
+		err := tx.Where("slug IN ? AND (tenant_id = ? OR expires_at <= ?)", []string{slug, tenant.Slug}, tenant.ID, time.Now()).
+			Delete(&models.TenantSlugAlias{}).Error
+		if err != nil {
+			return err
+		}
+
+		alias := &models.TenantSlugAlias{
+			TenantID:  tenant.ID,
+			Slug:      tenant.Slug,
+			ExpiresAt: time.Now().Add(TenantSlugAliasPeriod),
+		}
+		if err := tx.Create(alias).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(tenant).Update("slug", slug).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tenant, nil
+}
+
+// ResolveSlugAlias finds the tenant that used to own slug, if the alias is still live
+func (s *TenantService) ResolveSlugAlias(slug string) (*models.Tenant, error) {
+	var alias models.TenantSlugAlias
+
+	err := s.db.Preload("Tenant").
+		Where("slug = ? AND expires_at > ?", strings.ToLower(slug), time.Now()).
+		First(&alias).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &alias.Tenant, nil
+}
+
+func (s *TenantService) PurgeExpiredSlugAliases(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.TenantSlugAlias{})
+
+	return result.RowsAffected, result.Error
+}
+
+// slugInUse checks live tenants and unexpired aliases, ownerID's own aliases don't count
+func (s *TenantService) slugInUse(slug string, ownerID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Tenant{}).Where("slug = ?", slug).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	err = s.db.Model(&models.TenantSlugAlias{}).
+		Where("slug = ? AND tenant_id <> ? AND expires_at > ?", slug, ownerID, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/domains"
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TenantBaseDomain is the parent domain for {slug} subdomains, set with TENANT_BASE_DOMAIN
+func TenantBaseDomain() string {
+	return strings.ToLower(strings.TrimSuffix(os.Getenv("TENANT_BASE_DOMAIN"), "."))
+}
+
+// SlugFromHost extracts {slug} from {slug}.TENANT_BASE_DOMAIN
+func SlugFromHost(host string) (string, bool) {
+	base := TenantBaseDomain()
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if base == "" || !strings.HasSuffix(host, "."+base) {
+		return "", false
+	}
+
+	slug := strings.TrimSuffix(host, "."+base)
+	if slug == "" || strings.Contains(slug, ".") {
+		return "", false
+	}
+	return slug, true
+}
+
+// PublicBaseURL is the URL the service is reachable at, set with PUBLIC_BASE_URL
+func PublicBaseURL() string {
+	base := os.Getenv("PUBLIC_BASE_URL")
+	if base == "" {
+		base = "http://localhost:1323"
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// IssuerURL is the tenant's issuer, built from configuration rather than the request
+// Host, which the caller controls. host is empty for tenants resolved by path, giving
+// PUBLIC_BASE_URL/t/{slug}. Otherwise it is the verified custom domain or {slug}
+// subdomain the tenant was resolved by, served with the scheme and port of
+// PUBLIC_BASE_URL.
+func IssuerURL(tenant *models.Tenant, host string) string {
+	base := PublicBaseURL()
+	if host == "" {
+		return base + "/t/" + tenant.Slug
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if public, err := url.Parse(base); err == nil && public.Host != "" {
+		if port := public.Port(); port != "" {
+			host += ":" + port
+		}
+		return public.Scheme + "://" + host
+	}
+	return "https://" + host
+}
+
+type TenantDomainService struct {
+	db       *gorm.DB
+	resolver domains.TXTResolver
+}
+
+func NewTenantDomainService(db *gorm.DB, resolver ...domains.TXTResolver) *TenantDomainService {
+	s := &TenantDomainService{db: db, resolver: domains.DefaultResolver}
+	if len(resolver) > 0 {
+		s.resolver = resolver[0]
+	}
+	return s
+}
+
+// AddDomain registers an unverified custom domain, it does not resolve until Verify
+// succeeds. Several tenants may claim a domain, it is only taken once one verifies it.
+func (s *TenantDomainService) AddDomain(tenantID uuid.UUID, domain string) (*models.TenantDomain, error) {
+	domain, err := domains.Normalize(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if base := TenantBaseDomain(); base != "" && (domain == base || strings.HasSuffix(domain, "."+base)) {
+		return nil, domains.ErrInvalidDomain
+	}
+
+	var count int64
+	err = s.db.Model(&models.TenantDomain{}).
+		Where("domain = ? AND (verified_at IS NOT NULL OR tenant_id = ?)", domain, tenantID).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrDomainTaken
+	}
+
+	token, err := tokens.Generate(16)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantDomain := &models.TenantDomain{
+		TenantID:          tenantID,
+		Domain:            domain,
+		VerificationToken: token,
+	}
+
+	if err := s.db.Create(tenantDomain).Error; err != nil {
+		return nil, err
+	}
+
+	return tenantDomain, nil
+}
+
+func (s *TenantDomainService) ListDomains(tenantID uuid.UUID) ([]models.TenantDomain, error) {
+	var tenantDomains []models.TenantDomain
+
+	err := s.db.Where("tenant_id = ?", tenantID).Order("created_at ASC").Find(&tenantDomains).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tenantDomains, nil
+}
+
+func (s *TenantDomainService) GetDomain(tenantID uuid.UUID, id uuid.UUID) (*models.TenantDomain, error) {
+	var tenantDomain models.TenantDomain
+
+	err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&tenantDomain).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenantDomain, nil
+}
+
+// VerifyDomain checks the TXT record and marks the domain verified when it matches.
+// The claims other tenants made on the domain are dropped.
+func (s *TenantDomainService) VerifyDomain(ctx context.Context, tenantID uuid.UUID, id uuid.UUID) (*models.TenantDomain, error) {
+	tenantDomain, err := s.GetDomain(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenantDomain.VerifiedAt != nil {
+		return tenantDomain, nil
+	}
+
+	ok, err := domains.Verify(ctx, s.resolver, tenantDomain.Domain, tenantDomain.VerificationToken)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDomainNotVerified
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&models.TenantDomain{}).
+			Where("domain = ? AND verified_at IS NOT NULL", tenantDomain.Domain).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDomainTaken
+		}
+
+		if err := tx.Model(tenantDomain).Update("verified_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("domain = ? AND id <> ?", tenantDomain.Domain, tenantDomain.ID).
+			Delete(&models.TenantDomain{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tenantDomain, nil
+}
+
+func (s *TenantDomainService) RemoveDomain(tenantID uuid.UUID, id uuid.UUID) error {
+	tenantDomain, err := s.GetDomain(tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Delete(tenantDomain).Error
+}
+
+// ResolveHost maps a request host to a tenant, either a {slug} label under
+// TenantBaseDomain or a verified custom domain
+func (s *TenantDomainService) ResolveHost(host string) (*models.Tenant, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if base := TenantBaseDomain(); base != "" && strings.HasSuffix(host, "."+base) {
+		slug, ok := SlugFromHost(host)
+		if !ok {
+			return nil, ErrRecordNotFound
+		}
+		return NewTenantService(s.db).GetTenantBySlug(slug)
+	}
+
+	var tenantDomain models.TenantDomain
+	err := s.db.Preload("Tenant").
+		Where("domain = ? AND verified_at IS NOT NULL", host).
+		First(&tenantDomain).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenantDomain.Tenant, nil
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/domains"
+	"DigiPassAuthenticationApi/packages/models"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// unreachableResolver fails every lookup the way a DNS timeout does
+type unreachableResolver struct{}
+
+func (unreachableResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+}
+
+func TestVerifyDomain(t *testing.T) {
+	tenantID, domainID := uuid.New(), uuid.New()
+	verifiedAt := time.Now().Add(-time.Hour)
+
+	published := domains.NewStubResolver()
+	published.SetTXT(domains.RecordName("login.acme.com"), domains.RecordValue("token-1"))
+
+	stale := domains.NewStubResolver()
+	stale.SetTXT(domains.RecordName("login.acme.com"), domains.RecordValue("token-0"))
+
+	domainRows := func(verifiedAt *time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "tenant_id", "domain", "verification_token", "verified_at"}).
+			AddRow(domainID, tenantID, "login.acme.com", "token-1", verifiedAt)
+	}
+
+	tests := []struct {
+		name     string
+		resolver domains.TXTResolver
+		rows     *sqlmock.Rows
+		verified int64
+		update   bool
+		want     error
+	}{
+		{name: "record published", resolver: published, rows: domainRows(nil), update: true},
+		{name: "verified by another tenant first", resolver: published, rows: domainRows(nil), verified: 1, want: ErrDomainTaken},
+		{name: "already verified does not look the record up again", resolver: domains.NewStubResolver(), rows: domainRows(&verifiedAt)},
+		{name: "no record", resolver: domains.NewStubResolver(), rows: domainRows(nil), want: ErrDomainNotVerified},
+		{name: "record from an earlier attempt", resolver: stale, rows: domainRows(nil), want: ErrDomainNotVerified},
+		{name: "domain of another tenant", resolver: published, rows: sqlmock.NewRows([]string{"id"}), want: ErrRecordNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			mock.ExpectQuery(`SELECT \* FROM "tenant_domains" WHERE tenant_id = \$1 AND id = \$2`).
+				WithArgs(tenantID, domainID, 1).
+				WillReturnRows(test.rows)
+			if test.update || test.verified > 0 {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "tenant_domains" WHERE domain = \$1 AND verified_at IS NOT NULL`).
+					WithArgs("login.acme.com").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.verified))
+			}
+			if test.update {
+				// The claims of other tenants go away with the verification
+				mock.ExpectExec(`UPDATE "tenant_domains" SET "verified_at"=\$1 WHERE "id" = \$2`).
+					WithArgs(sqlmock.AnyArg(), domainID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM "tenant_domains" WHERE domain = \$1 AND id <> \$2`).
+					WithArgs("login.acme.com", domainID).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			} else if test.verified > 0 {
+				mock.ExpectRollback()
+			}
+
+			tenantDomain, err := NewTenantDomainService(db, test.resolver).VerifyDomain(context.Background(), tenantID, domainID)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("VerifyDomain = %+v, %v, want %v", tenantDomain, err, test.want)
+				}
+				return
+			}
+
+			if err != nil || tenantDomain.VerifiedAt == nil {
+				t.Fatalf("VerifyDomain = %+v, %v", tenantDomain, err)
+			}
+		})
+	}
+}
+
+func TestVerifyDomainResolverFailure(t *testing.T) {
+	tenantID, domainID := uuid.New(), uuid.New()
+	db, mock := mockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "tenant_domains" WHERE tenant_id = \$1 AND id = \$2`).
+		WithArgs(tenantID, domainID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "domain", "verification_token"}).
+			AddRow(domainID, tenantID, "login.acme.com", "token-1"))
+
+	// The lookup error is returned as is rather than reported as a missing record
+	_, err := NewTenantDomainService(db, unreachableResolver{}).VerifyDomain(context.Background(), tenantID, domainID)
+
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || errors.Is(err, ErrDomainNotVerified) {
+		t.Fatalf("VerifyDomain = %v, want the DNS error", err)
+	}
+}
+
+func TestAddDomain(t *testing.T) {
+	t.Setenv("TENANT_BASE_DOMAIN", "auth.digipass.test")
+	tenantID := uuid.New()
+
+	// Names under the base domain are reserved for {slug} subdomains
+	for _, domain := range []string{"auth.digipass.test", "other.auth.digipass.test", "login_1.acme.com"} {
+		if _, err := NewTenantDomainService(dryRunDB(t)).AddDomain(tenantID, domain); !errors.Is(err, domains.ErrInvalidDomain) {
+			t.Fatalf("AddDomain(%q) = %v, want ErrInvalidDomain", domain, err)
+		}
+	}
+
+	// Only a verified domain, or a claim of the same tenant, counts: pending claims of
+	// other tenants cannot block the owner
+	db, mock := mockDB(t)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tenant_domains" WHERE domain = \$1 AND \(verified_at IS NOT NULL OR tenant_id = \$2\)`).
+		WithArgs("login.acme.com", tenantID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if _, err := NewTenantDomainService(db).AddDomain(tenantID, "login.acme.com"); !errors.Is(err, ErrDomainTaken) {
+		t.Fatalf("AddDomain of a registered domain = %v, want ErrDomainTaken", err)
+	}
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tenant_domains" WHERE domain = \$1 AND \(verified_at IS NOT NULL OR tenant_id = \$2\)`).
+		WithArgs("login.acme.com", tenantID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`INSERT INTO "tenant_domains"`).
+		WithArgs(tenantID, "login.acme.com", sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+
+	tenantDomain, err := NewTenantDomainService(db).AddDomain(tenantID, "Login.ACME.com.")
+	if err != nil || tenantDomain.Domain != "login.acme.com" || tenantDomain.VerificationToken == "" || tenantDomain.VerifiedAt != nil {
+		t.Fatalf("AddDomain = %+v, %v", tenantDomain, err)
+	}
+}
+
+func TestResolveHost(t *testing.T) {
+	t.Setenv("TENANT_BASE_DOMAIN", "auth.digipass.test")
+	tenantID := uuid.New()
+
+	tests := []struct {
+		name   string
+		host   string
+		expect func(mock sqlmock.Sqlmock)
+		want   error
+	}{
+		{
+			name: "slug subdomain",
+			host: "Acme.auth.digipass.test.",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "tenants" WHERE slug = \$1`).
+					WithArgs("acme", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(tenantID, "acme"))
+			},
+		},
+		{
+			name: "nested label under the base domain",
+			host: "login.acme.auth.digipass.test",
+			want: ErrRecordNotFound,
+		},
+		{
+			name: "verified custom domain",
+			host: "login.acme.com",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "tenant_domains" WHERE domain = \$1 AND verified_at IS NOT NULL`).
+					WithArgs("login.acme.com", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "domain", "verified_at"}).
+						AddRow(uuid.New(), tenantID, "login.acme.com", time.Now()))
+				mock.ExpectQuery(`SELECT \* FROM "tenants" WHERE "tenants"."id" = \$1`).
+					WithArgs(tenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(tenantID, "acme"))
+			},
+		},
+		{
+			name: "custom domain that is not verified yet",
+			host: "pending.acme.com",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "tenant_domains" WHERE domain = \$1 AND verified_at IS NOT NULL`).
+					WithArgs("pending.acme.com", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			want: ErrRecordNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			if test.expect != nil {
+				test.expect(mock)
+			}
+
+			tenant, err := NewTenantDomainService(db).ResolveHost(test.host)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("ResolveHost = %+v, %v, want %v", tenant, err, test.want)
+				}
+				return
+			}
+
+			if err != nil || tenant.ID != tenantID {
+				t.Fatalf("ResolveHost = %+v, %v", tenant, err)
+			}
+		})
+	}
+}
+
+func TestSlugFromHost(t *testing.T) {
+	t.Setenv("TENANT_BASE_DOMAIN", "Auth.DigiPass.test.")
+
+	tests := []struct {
+		host string
+		slug string
+	}{
+		{host: "acme.auth.digipass.test", slug: "acme"},
+		{host: "ACME.auth.digipass.test.", slug: "acme"},
+		{host: "auth.digipass.test"},
+		{host: ".auth.digipass.test"},
+		{host: "login.acme.auth.digipass.test"},
+		{host: "acme.evilauth.digipass.test"},
+		{host: "acme.auth.digipass.test.evil.com"},
+	}
+
+	for _, test := range tests {
+		slug, ok := SlugFromHost(test.host)
+		if slug != test.slug || ok != (test.slug != "") {
+			t.Errorf("SlugFromHost(%q) = %q, %v, want %q", test.host, slug, ok, test.slug)
+		}
+	}
+
+	// Without a base domain nothing resolves by subdomain
+	t.Setenv("TENANT_BASE_DOMAIN", "")
+	if slug, ok := SlugFromHost("acme.auth.digipass.test"); ok {
+		t.Errorf("SlugFromHost without a base domain = %q", slug)
+	}
+}
+
+func TestIssuerURL(t *testing.T) {
+	tenant := &models.Tenant{Slug: "acme"}
+
+	tests := []struct {
+		base string
+		host string
+		want string
+	}{
+		{base: "https://auth.example.com/", want: "https://auth.example.com/t/acme"},
+		{base: "https://auth.example.com", host: "Login.ACME.com.", want: "https://login.acme.com"},
+		{base: "http://localhost:1323", host: "acme.auth.localhost", want: "http://acme.auth.localhost:1323"},
+		{base: "", want: "http://localhost:1323/t/acme"},
+	}
+
+	for _, test := range tests {
+		t.Setenv("PUBLIC_BASE_URL", test.base)
+		if got := IssuerURL(tenant, test.host); got != test.want {
+			t.Errorf("IssuerURL(%q) with %q = %q, want %q", test.host, test.base, got, test.want)
+		}
+	}
+}
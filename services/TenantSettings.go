@@ -0,0 +1,44 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+func (s *TenantService) GetSettings(accountID uuid.UUID, tenantID uuid.UUID) (*models.TenantSettings, error) {
+	tenant, err := s.GetTenant(accountID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenant.Settings, nil
+}
+
+// UpdateSettings applies a JSON merge patch, validates the result and stores it at the current version
+func (s *TenantService) UpdateSettings(accountID uuid.UUID, tenantID uuid.UUID, patch []byte) (*models.TenantSettings, error) {
+	tenant, err := s.GetTenant(accountID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant.Status == "deleted" {
+		return nil, ErrInvalidStatusTransition
+	}
+
+	settings, err := tenant.Settings.ApplyPatch(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrInvalidTenantSettings, err)
+	}
+
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(tenant).Update("settings", settings).Error; err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
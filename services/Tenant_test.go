@@ -0,0 +1,205 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestEnsureActive(t *testing.T) {
+	tests := []struct {
+		status        string
+		accountStatus string
+		want          error
+	}{
+		{status: "active", accountStatus: "active"},
+		{status: "suspended", want: ErrTenantSuspended},
+		{status: "deleted", want: ErrRecordNotFound},
+		// Suspending the account stops every one of its tenants
+		{status: "active", accountStatus: "suspended", want: ErrAccountSuspended},
+		{status: "active", accountStatus: "pending_verification", want: ErrAccountNotVerified},
+		{status: "active", accountStatus: "deleted", want: ErrRecordNotFound},
+	}
+
+	for _, test := range tests {
+		db, mock := mockDB(t)
+		tenant := &models.Tenant{ID: uuid.New(), AccountID: uuid.New(), Status: test.status}
+
+		if test.accountStatus != "" {
+			mock.ExpectQuery(`SELECT "status" FROM "accounts" WHERE id = \$1`).
+				WithArgs(tenant.AccountID).
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(test.accountStatus))
+		}
+
+		if err := NewTenantService(db).EnsureActive(tenant); !errors.Is(err, test.want) {
+			t.Errorf("EnsureActive(%s tenant, %s account) = %v, want %v", test.status, test.accountStatus, err, test.want)
+		}
+	}
+}
+
+func TestUpdateTenantStatus(t *testing.T) {
+	accountID, id := uuid.New(), uuid.New()
+	recently, longAgo := time.Now().Add(-time.Hour), time.Now().Add(-TenantDeletionGracePeriod-time.Hour)
+
+	expectTenant := func(mock sqlmock.Sqlmock, status string, deletedAt *time.Time) {
+		mock.ExpectQuery(`SELECT \* FROM "tenants" WHERE account_id = \$1 AND id = \$2`).
+			WithArgs(accountID, id, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "status", "deleted_at"}).
+				AddRow(id, accountID, status, deletedAt))
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectTenant(mock, "active", nil)
+		mock.ExpectExec(`UPDATE "tenants" SET "deleted_at"=\$1,"status"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+			WithArgs(sqlmock.AnyArg(), "deleted", sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectTenant(mock, "deleted", &recently)
+
+		if tenant, err := NewTenantService(db).DeleteTenant(accountID, id); err != nil || tenant.Status != "deleted" {
+			t.Fatalf("DeleteTenant = %+v, %v", tenant, err)
+		}
+	})
+
+	t.Run("restore within grace", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectTenant(mock, "deleted", &recently)
+		mock.ExpectExec(`UPDATE "tenants" SET "deleted_at"=\$1,"status"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+			WithArgs(nil, "active", sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectTenant(mock, "active", nil)
+
+		if tenant, err := NewTenantService(db).UpdateStatus(accountID, id, "active"); err != nil || tenant.Status != "active" {
+			t.Fatalf("UpdateStatus = %+v, %v", tenant, err)
+		}
+	})
+
+	// None of these reach an UPDATE
+	rejected := []struct {
+		name      string
+		from      string
+		deletedAt *time.Time
+		to        string
+		want      error
+	}{
+		{name: "restore after grace", from: "deleted", deletedAt: &longAgo, to: "active", want: ErrInvalidStatusTransition},
+		{name: "suspend deleted", from: "deleted", deletedAt: &recently, to: "suspended", want: ErrInvalidStatusTransition},
+		{name: "unknown status", from: "active", to: "archived", want: ErrTenantInvalid},
+	}
+
+	for _, test := range rejected {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			expectTenant(mock, test.from, test.deletedAt)
+
+			if tenant, err := NewTenantService(db).UpdateStatus(accountID, id, test.to); !errors.Is(err, test.want) {
+				t.Fatalf("UpdateStatus = %+v, %v, want %v", tenant, err, test.want)
+			}
+		})
+	}
+}
+
+func TestChangeSlug(t *testing.T) {
+	accountID, id := uuid.New(), uuid.New()
+
+	expectTenant := func(mock sqlmock.Sqlmock, status string) {
+		mock.ExpectQuery(`SELECT \* FROM "tenants" WHERE account_id = \$1 AND id = \$2`).
+			WithArgs(accountID, id, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "slug", "status"}).
+				AddRow(id, accountID, "brave-otter-42", status))
+	}
+	expectInUse := func(mock sqlmock.Sqlmock, tenants int, aliases int) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "tenants" WHERE slug = \$1`).
+			WithArgs("acme").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tenants))
+		if tenants == 0 {
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "tenant_slug_aliases" WHERE slug = \$1 AND tenant_id <> \$2 AND expires_at > \$3`).
+				WithArgs("acme", id, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(aliases))
+		}
+	}
+
+	t.Run("change", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectTenant(mock, "active")
+		expectInUse(mock, 0, 0)
+
+		// The old slug keeps redirecting, both writes commit together
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "tenant_slug_aliases" WHERE slug IN \(\$1,\$2\) AND \(tenant_id = \$3 OR expires_at <= \$4\)`).
+			WithArgs("acme", "brave-otter-42", id, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`INSERT INTO "tenant_slug_aliases"`).
+			WithArgs(id, "brave-otter-42", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+		mock.ExpectExec(`UPDATE "tenants" SET "slug"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+			WithArgs("acme", sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		tenant, err := NewTenantService(db).ChangeSlug(accountID, id, "  Acme ")
+		if err != nil || tenant.Slug != "acme" {
+			t.Fatalf("ChangeSlug = %+v, %v", tenant, err)
+		}
+	})
+
+	t.Run("alias write fails", func(t *testing.T) {
+		db, mock := mockDB(t)
+		expectTenant(mock, "active")
+		expectInUse(mock, 0, 0)
+
+		// The slug is not changed without its alias
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "tenant_slug_aliases"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`INSERT INTO "tenant_slug_aliases"`).WillReturnError(errors.New("duplicate key"))
+		mock.ExpectRollback()
+
+		if tenant, err := NewTenantService(db).ChangeSlug(accountID, id, "acme"); err == nil {
+			t.Fatalf("ChangeSlug = %+v, want the insert error", tenant)
+		}
+	})
+
+	rejected := []struct {
+		name   string
+		slug   string
+		expect func(mock sqlmock.Sqlmock)
+		want   error
+	}{
+		{name: "reserved", slug: "console", expect: func(mock sqlmock.Sqlmock) {}, want: models.ErrSlugReserved},
+		{name: "malformed", slug: "a--b", expect: func(mock sqlmock.Sqlmock) {}, want: models.ErrSlugFormat},
+		{name: "deleted tenant", slug: "acme", expect: func(mock sqlmock.Sqlmock) { expectTenant(mock, "deleted") }, want: ErrInvalidStatusTransition},
+		{
+			name: "another tenant's slug",
+			slug: "acme",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTenant(mock, "active")
+				expectInUse(mock, 1, 0)
+			},
+			want: ErrSlugTaken,
+		},
+		{
+			name: "another tenant's live alias",
+			slug: "acme",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTenant(mock, "active")
+				expectInUse(mock, 0, 1)
+			},
+			want: ErrSlugTaken,
+		},
+	}
+
+	for _, test := range rejected {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			test.expect(mock)
+
+			if tenant, err := NewTenantService(db).ChangeSlug(accountID, id, test.slug); !errors.Is(err, test.want) {
+				t.Fatalf("ChangeSlug = %+v, %v, want %v", tenant, err, test.want)
+			}
+		})
+	}
+}
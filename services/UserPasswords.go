@@ -0,0 +1,171 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/password"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChangePassword replaces the password of a User who knows the current one. It does
+// not need a session so users whose password expired can still change it. Every
+// session and refresh token is revoked afterwards.
+func (s *UsersService) ChangePassword(tenant *models.Tenant, email string, currentPassword string, newPassword string, userAgent string, ipAddress string) (*models.User, error) {
+	user, err := s.checkCredentials(tenant, email, currentPassword, userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNewPassword(tenant, user, newPassword); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := NewUsersService(tx, s.store).setPassword(user, newPassword); err != nil {
+			return err
+		}
+		return revokeUserCredentials(tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Unlock lifts a lockout from the emailed unlock link
+func (s *UsersService) Unlock(tenant *models.Tenant, token string, userAgent string, ipAddress string) (*models.User, error) {
+	user, err := NewUserTokenService(s.db).Consume(tenant.ID, TokenPurposeUnlockAccount, token)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := LoginAttempt{
+		TenantID:  &tenant.ID,
+		Email:     user.Email,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Settings:  tenant.Settings.Lockout,
+	}
+	if err := NewLoginGuard(s.db, s.store).Unlock(attempt, &user.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// PasswordExpired reports whether the tenant's max age has passed since the password was set.
+// Users created before the change date was tracked count from their creation.
+func (s *UsersService) PasswordExpired(tenant *models.Tenant, user *models.User, now time.Time) bool {
+	maxAge := tenant.Settings.PasswordPolicy.MaxAgeDays
+	if maxAge == 0 || user.PasswordHash == "" {
+		return false
+	}
+
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+
+	return now.Sub(changedAt) > time.Duration(maxAge)*24*time.Hour
+}
+
+// checkNewPassword applies every rule of the tenant password policy and reports all
+// broken rules together. user is nil on registration, when there is no history yet.
+func (s *UsersService) checkNewPassword(tenant *models.Tenant, user *models.User, plainPassword string) error {
+	policy := tenant.Settings.PasswordPolicy
+	problems := password.CheckPolicy(policy, plainPassword)
+
+	if policy.CheckBreached {
+		breached, err := password.IsBreached(plainPassword)
+		if err != nil {
+			return err
+		}
+		if breached {
+			problems = append(problems, "has appeared in a data breach, choose a different one")
+		}
+	}
+
+	if user != nil && policy.HistorySize > 0 {
+		reused, err := s.isRecentPassword(user, plainPassword, policy.HistorySize)
+		if err != nil {
+			return err
+		}
+		if reused {
+			problems = append(problems, fmt.Sprintf("must not be one of your last %d passwords", policy.HistorySize))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+// isRecentPassword checks the current password and the most recent history entries, n in total
+func (s *UsersService) isRecentPassword(user *models.User, plainPassword string, n int) (bool, error) {
+	hashes := make([]string, 0, n)
+	if user.PasswordHash != "" {
+		hashes = append(hashes, user.PasswordHash)
+	}
+
+	if remaining := n - len(hashes); remaining > 0 {
+		var history []models.UserPasswordHistory
+		err := s.db.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(remaining).Find(&history).Error
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range history {
+			hashes = append(hashes, entry.PasswordHash)
+		}
+	}
+
+	for _, hash := range hashes {
+		match, _, err := password.Verify(plainPassword, hash)
+		if err != nil && !errors.Is(err, password.ErrUnsupportedHash) {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// setPassword stores the new password and moves the current hash into the history. The
+// history is kept at MaxPasswordHistorySize regardless of the tenant setting so raising
+// history_size later takes effect immediately.
+func (s *UsersService) setPassword(user *models.User, plainPassword string) error {
+	passwordHash, err := password.Hash(plainPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if user.PasswordHash != "" {
+		if err := s.db.Create(&models.UserPasswordHistory{UserID: user.ID, PasswordHash: user.PasswordHash}).Error; err != nil {
+			return err
+		}
+
+		err := s.db.Where("user_id = ? AND id NOT IN (?)", user.ID,
+			s.db.Model(&models.UserPasswordHistory{}).Select("id").Where("user_id = ?", user.ID).
+				Order("created_at DESC").Limit(models.MaxPasswordHistorySize)).
+			Delete(&models.UserPasswordHistory{}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	err = s.db.Model(user).Updates(map[string]any{"password_hash": passwordHash, "password_changed_at": now}).Error
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = passwordHash
+	user.PasswordChangedAt = &now
+	return nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/password"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// cheapHash keeps the history checks fast, Verify accepts any supported parameters
+func cheapHash(t *testing.T, plainPassword string) string {
+	t.Helper()
+	hash, err := password.HashWithParams(plainPassword, password.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestCheckNewPasswordHistory(t *testing.T) {
+	t.Setenv("BREACHED_PASSWORDS_DIR", "")
+
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	tenant.Settings.PasswordPolicy.HistorySize = 3
+	user := &models.User{ID: uuid.New(), PasswordHash: cheapHash(t, "current passphrase")}
+
+	tests := []struct {
+		password string
+		reused   bool
+	}{
+		{password: "current passphrase", reused: true},
+		{password: "previous passphrase", reused: true},
+		{password: "oldest kept passphrase", reused: true},
+		{password: "brand new passphrase", reused: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			// The current hash counts towards the history size, two more come from the table
+			mock.ExpectQuery(`SELECT \* FROM "user_password_history" WHERE user_id = \$1 ORDER BY created_at DESC LIMIT \$2`).
+				WithArgs(user.ID, 2).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "password_hash"}).
+					AddRow(uuid.New(), user.ID, cheapHash(t, "previous passphrase")).
+					AddRow(uuid.New(), user.ID, cheapHash(t, "oldest kept passphrase")))
+
+			err := NewUsersService(db).checkNewPassword(tenant, user, test.password)
+
+			var policyErr *PasswordPolicyError
+			if test.reused {
+				if !errors.As(err, &policyErr) || !reflect.DeepEqual(policyErr.Problems, []string{"must not be one of your last 3 passwords"}) {
+					t.Fatalf("checkNewPassword = %v, want the history problem", err)
+				}
+			} else if err != nil {
+				t.Fatalf("checkNewPassword = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckNewPasswordBreached(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("password1234"))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(dir, digest[:5]), []byte(digest[5:]+":1000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BREACHED_PASSWORDS_DIR", dir)
+
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	db, _ := mockDB(t)
+	users := NewUsersService(db)
+
+	// Registration has no history, only the breach check and the length rule apply
+	var policyErr *PasswordPolicyError
+	if err := users.checkNewPassword(tenant, nil, "password1234"); !errors.As(err, &policyErr) ||
+		!reflect.DeepEqual(policyErr.Problems, []string{"has appeared in a data breach, choose a different one"}) {
+		t.Fatalf("checkNewPassword breached = %v", err)
+	}
+	if err := users.checkNewPassword(tenant, nil, "correct horse battery"); err != nil {
+		t.Fatalf("checkNewPassword = %v", err)
+	}
+
+	// Tenants can opt out of the check
+	tenant.Settings.PasswordPolicy.CheckBreached = false
+	if err := users.checkNewPassword(tenant, nil, "password1234"); err != nil {
+		t.Fatalf("checkNewPassword with check_breached off = %v", err)
+	}
+}
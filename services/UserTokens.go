@@ -0,0 +1,106 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeUnlockAccount = "unlock_account"
+
+	EmailVerificationTokenLifetime = 24 * time.Hour
+	PasswordResetTokenLifetime     = time.Hour
+	UnlockTokenLifetime            = time.Hour
+)
+
+// UserTokenService issues the one time tokens emailed to tenant Users. Only the
+// hash is stored, a token works once and issuing a new one for the same purpose
+// invalidates the previous ones.
+type UserTokenService struct {
+	db *gorm.DB
+}
+
+func NewUserTokenService(db *gorm.DB) *UserTokenService {
+	return &UserTokenService{db: db}
+}
+
+func (s *UserTokenService) Issue(userID uuid.UUID, purpose string, lifetime time.Duration) (string, error) {
+	token, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("expires_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: tokens.Hash(token),
+			ExpiresAt: now.Add(lifetime),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Consume marks the token used and returns its User, the token must belong to a User of the tenant.
+// The conditional update makes concurrent attempts with the same token succeed only once.
+func (s *UserTokenService) Consume(tenantID uuid.UUID, purpose string, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrTokenInvalid
+	}
+
+	var userToken models.UserToken
+	err := s.db.Preload("User").
+		Where("token_hash = ? AND purpose = ?", tokens.Hash(token), purpose).
+		First(&userToken).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if userToken.User.TenantID != tenantID || userToken.UsedAt != nil || !now.Before(userToken.ExpiresAt) {
+		return nil, ErrTokenInvalid
+	}
+
+	result := s.db.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", userToken.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTokenInvalid
+	}
+
+	return &userToken.User, nil
+}
+
+// PurgeExpiredTokens removes tokens that can no longer be used
+func (s *UserTokenService) PurgeExpiredTokens(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.UserToken{})
+
+	return result.RowsAffected, result.Error
+}
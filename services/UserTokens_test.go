@@ -0,0 +1,213 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/tokens"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// captured matches any argument and keeps it for the test to inspect
+type captured struct {
+	value driver.Value
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestIssueUserToken(t *testing.T) {
+	db, mock := mockDB(t)
+	userID := uuid.New()
+	hash, expiresAt := &captured{}, &captured{}
+
+	// Earlier links for the same purpose stop working, the new one is stored hashed
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "user_tokens" SET "expires_at"=\$1 WHERE user_id = \$2 AND purpose = \$3 AND used_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), userID, TokenPurposeResetPassword).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`INSERT INTO "user_tokens" \("user_id","purpose","token_hash","expires_at","used_at","created_at"\)`).
+		WithArgs(userID, TokenPurposeResetPassword, hash, expiresAt, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	issued := time.Now()
+	token, err := NewUserTokenService(db).Issue(userID, TokenPurposeResetPassword, PasswordResetTokenLifetime)
+	if err != nil {
+		t.Fatalf("Issue = %v", err)
+	}
+
+	if hash.value != tokens.Hash(token) || hash.value == token {
+		t.Fatalf("stored %v for %s, want its hash", hash.value, token)
+	}
+	if expires, _ := expiresAt.value.(time.Time); expires.Sub(issued.Add(PasswordResetTokenLifetime)).Abs() > time.Second {
+		t.Fatalf("expires_at = %v, want an hour from now", expiresAt.value)
+	}
+}
+
+func TestConsumeUserToken(t *testing.T) {
+	tenantID, userID, tokenID := uuid.New(), uuid.New(), uuid.New()
+	used := time.Now().Add(-time.Minute)
+
+	tokenRows := func(expiresAt time.Time, usedAt *time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "purpose", "token_hash", "expires_at", "used_at"}).
+			AddRow(tokenID, userID, TokenPurposeVerifyEmail, tokens.Hash("token-1"), expiresAt, usedAt)
+	}
+	userRows := func(tenantID uuid.UUID) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "tenant_id", "email"}).AddRow(userID, tenantID, "ada@example.com")
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		tokens   *sqlmock.Rows
+		users    *sqlmock.Rows
+		update   bool
+		consumed int64
+		want     error
+	}{
+		{name: "valid", token: "token-1", tokens: tokenRows(time.Now().Add(time.Hour), nil), users: userRows(tenantID), update: true, consumed: 1},
+		{name: "already used", token: "token-1", tokens: tokenRows(time.Now().Add(time.Hour), &used), users: userRows(tenantID), want: ErrTokenInvalid},
+		{name: "expired", token: "token-1", tokens: tokenRows(time.Now().Add(-time.Second), nil), users: userRows(tenantID), want: ErrTokenInvalid},
+		{name: "user of another tenant", token: "token-1", tokens: tokenRows(time.Now().Add(time.Hour), nil), users: userRows(uuid.New()), want: ErrTokenInvalid},
+		{name: "used by a concurrent request", token: "token-1", tokens: tokenRows(time.Now().Add(time.Hour), nil), users: userRows(tenantID), update: true, want: ErrTokenInvalid},
+		{name: "unknown, or issued for another purpose", token: "token-1", tokens: sqlmock.NewRows([]string{"id"}), want: ErrTokenInvalid},
+		{name: "empty", token: "", want: ErrTokenInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+
+			if test.tokens != nil {
+				mock.ExpectQuery(`SELECT \* FROM "user_tokens" WHERE token_hash = \$1 AND purpose = \$2`).
+					WithArgs(tokens.Hash(test.token), TokenPurposeVerifyEmail, 1).
+					WillReturnRows(test.tokens)
+			}
+			if test.users != nil {
+				mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+					WithArgs(userID).
+					WillReturnRows(test.users)
+			}
+			if test.update {
+				mock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+					WithArgs(sqlmock.AnyArg(), tokenID).
+					WillReturnResult(sqlmock.NewResult(0, test.consumed))
+			}
+
+			user, err := NewUserTokenService(db).Consume(tenantID, TokenPurposeVerifyEmail, test.token)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("Consume = %+v, %v, want %v", user, err, test.want)
+				}
+				return
+			}
+
+			if err != nil || user.ID != userID {
+				t.Fatalf("Consume = %+v, %v", user, err)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	tenant := &models.Tenant{ID: uuid.New(), Settings: models.DefaultTenantSettings()}
+	tenant.Settings.PasswordPolicy.CheckBreached = false
+	userID, tokenID := uuid.New(), uuid.New()
+
+	expectConsume := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "user_tokens" WHERE token_hash = \$1 AND purpose = \$2`).
+			WithArgs(tokens.Hash("token-1"), TokenPurposeResetPassword, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "purpose", "expires_at"}).
+				AddRow(tokenID, userID, TokenPurposeResetPassword, time.Now().Add(time.Hour)))
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "status"}).
+				AddRow(userID, tenant.ID, "ada@example.com", "active"))
+		mock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), tokenID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("signs the user out everywhere", func(t *testing.T) {
+		db, mock := mockDB(t)
+
+		mock.ExpectBegin()
+		expectConsume(mock)
+		mock.ExpectExec(`UPDATE "users" SET "password_changed_at"=\$1,"password_hash"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "users" SET "email_verified"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+			WithArgs(true, sqlmock.AnyArg(), userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "sessions" SET "revoked_at"=\$1 WHERE user_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), userID).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE user_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), userID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		user, err := NewUsersService(db).ResetPassword(tenant, "token-1", "a long new passphrase")
+		if err != nil || user.ID != userID || !user.EmailVerified || user.PasswordHash == "" {
+			t.Fatalf("ResetPassword = %+v, %v", user, err)
+		}
+	})
+
+	t.Run("a rejected password does not burn the link", func(t *testing.T) {
+		db, mock := mockDB(t)
+
+		mock.ExpectBegin()
+		expectConsume(mock)
+		mock.ExpectRollback()
+
+		var policyErr *PasswordPolicyError
+		if _, err := NewUsersService(db).ResetPassword(tenant, "token-1", "short"); !errors.As(err, &policyErr) {
+			t.Fatalf("ResetPassword = %v, want a PasswordPolicyError", err)
+		}
+	})
+
+	t.Run("used link", func(t *testing.T) {
+		db, mock := mockDB(t)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "user_tokens" WHERE token_hash = \$1 AND purpose = \$2`).
+			WithArgs(tokens.Hash("token-1"), TokenPurposeResetPassword, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		if _, err := NewUsersService(db).ResetPassword(tenant, "token-1", "a long new passphrase"); !errors.Is(err, ErrTokenInvalid) {
+			t.Fatalf("ResetPassword = %v, want ErrTokenInvalid", err)
+		}
+	})
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db, mock := mockDB(t)
+	tenantID, userID, tokenID := uuid.New(), uuid.New(), uuid.New()
+
+	mock.ExpectQuery(`SELECT \* FROM "user_tokens" WHERE token_hash = \$1 AND purpose = \$2`).
+		WithArgs(tokens.Hash("token-1"), TokenPurposeVerifyEmail, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "purpose", "expires_at"}).
+			AddRow(tokenID, userID, TokenPurposeVerifyEmail, time.Now().Add(time.Hour)))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email"}).AddRow(userID, tenantID, "ada@example.com"))
+	mock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), tokenID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "users" SET "email_verified"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+		WithArgs(true, sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	user, err := NewUsersService(db).VerifyEmail(tenantID, "token-1")
+	if err != nil || !user.EmailVerified {
+		t.Fatalf("VerifyEmail = %+v, %v", user, err)
+	}
+}
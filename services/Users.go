@@ -0,0 +1,311 @@
+package services
+
+import (
+	"DigiPassAuthenticationApi/packages/models"
+	"DigiPassAuthenticationApi/packages/password"
+	"DigiPassAuthenticationApi/packages/throttle"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// RegisterInput is what an end user provides when signing up to a tenant
+type RegisterInput struct {
+	Email      string `json:"email" form:"email"`
+	Password   string `json:"password" form:"password"`
+	GivenName  string `json:"given_name" form:"given_name"`
+	FamilyName string `json:"family_name" form:"family_name"`
+	Locale     string `json:"locale" form:"locale"`
+}
+
+// PasswordPolicyError lists every rule of the tenant password policy a password broke
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return ErrWeakPassword.Error() + ": password " + strings.Join(e.Problems, ", password ")
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+type UsersService struct {
+	db    *gorm.DB
+	store throttle.Store
+}
+
+// NewUsersService optionally takes the login throttle store, Postgres by default
+func NewUsersService(db *gorm.DB, store ...throttle.Store) *UsersService {
+	return &UsersService{db: db, store: throttleStore(db, store)}
+}
+
+// Register creates an end user in the tenant. Emails are unique per tenant and
+// compared case insensitively, the password must satisfy the tenant password policy.
+func (s *UsersService) Register(tenant *models.Tenant, input RegisterInput) (*models.User, error) {
+	email, err := normalizeEmail(input.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNewPassword(tenant, nil, input.Password); err != nil {
+		return nil, err
+	}
+
+	if existing, _ := s.GetUserByEmail(tenant.ID, email); existing != nil {
+		return nil, ErrUserAlreadyExists
+	}
+
+	passwordHash, err := password.Hash(input.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	user := &models.User{
+		TenantID:          tenant.ID,
+		Email:             email,
+		PasswordHash:      passwordHash,
+		PasswordChangedAt: &now,
+		GivenName:         strings.TrimSpace(input.GivenName),
+		FamilyName:        strings.TrimSpace(input.FamilyName),
+		Locale:            strings.TrimSpace(input.Locale),
+		Status:            "active",
+	}
+
+	if err := s.db.Create(user).Error; err != nil {
+		// Lost a race against a concurrent signup, the unique (tenant_id, email) index caught it
+		if existing, _ := s.GetUserByEmail(tenant.ID, email); existing != nil {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *UsersService) GetUserByEmail(tenantID uuid.UUID, email string) (*models.User, error) {
+	var user models.User
+
+	err := s.db.Where("tenant_id = ? AND LOWER(email) = ?", tenantID, strings.ToLower(strings.TrimSpace(email))).First(&user).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *UsersService) GetUser(tenantID uuid.UUID, id uuid.UUID) (*models.User, error) {
+	var user models.User
+
+	err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&user).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// VerifyEmail consumes an email verification token and marks the User's email verified
+func (s *UsersService) VerifyEmail(tenantID uuid.UUID, token string) (*models.User, error) {
+	user, err := NewUserTokenService(s.db).Consume(tenantID, TokenPurposeVerifyEmail, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(user).Update("email_verified", true).Error; err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ResetPassword sets a new password from a reset token and signs the User out
+// everywhere by revoking every session and refresh token. A password rejected by
+// the policy rolls the transaction back so it does not burn the link.
+func (s *UsersService) ResetPassword(tenant *models.Tenant, token string, plainPassword string) (*models.User, error) {
+	var user *models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = NewUserTokenService(tx).Consume(tenant.ID, TokenPurposeResetPassword, token)
+		if err != nil {
+			return err
+		}
+
+		txUsers := NewUsersService(tx)
+		if err := txUsers.checkNewPassword(tenant, user, plainPassword); err != nil {
+			return err
+		}
+
+		if err := txUsers.setPassword(user, plainPassword); err != nil {
+			return err
+		}
+
+		// Receiving the reset email proves ownership of the address
+		if err := tx.Model(user).Update("email_verified", true).Error; err != nil {
+			return err
+		}
+
+		return revokeUserCredentials(tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// revokeUserCredentials ends every session and invalidates every refresh token of the User
+func revokeUserCredentials(tx *gorm.DB, userID uuid.UUID) error {
+	if err := NewSessionService(tx).RevokeAllForUser(userID); err != nil {
+		return err
+	}
+
+	return tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// checkCredentials verifies an end user's email and password behind the tenant's
+// brute-force protection and returns the active User they belong to
+func (s *UsersService) checkCredentials(tenant *models.Tenant, email string, plainPassword string, userAgent string, ipAddress string) (*models.User, error) {
+	if email == "" || plainPassword == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	guard := NewLoginGuard(s.db, s.store)
+	attempt := LoginAttempt{
+		TenantID:  &tenant.ID,
+		Email:     email,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Settings:  tenant.Settings.Lockout,
+	}
+
+	now := time.Now()
+	if err := guard.Check(attempt, now); err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUserByEmail(tenant.ID, email)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		password.Verify(plainPassword, dummyPasswordHash)
+	} else {
+		err = s.VerifyPassword(user, plainPassword)
+		if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+	}
+
+	if user == nil || err != nil {
+		var userID *uuid.UUID
+		if user != nil {
+			userID = &user.ID
+		}
+		if lockErr := guard.Failed(attempt, userID, nil, now); lockErr != nil {
+			return nil, lockErr
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := guard.Succeeded(attempt); err != nil {
+		return nil, err
+	}
+
+	// Only reported after the password matched so it does not reveal which emails exist
+	switch user.Status {
+	case "active":
+		return user, nil
+	case "suspended":
+		return nil, ErrUserSuspended
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// ReauthenticationWindow is how recently a passwordless user must have signed in
+// to confirm a sensitive change without a password
+const ReauthenticationWindow = 5 * time.Minute
+
+// Reauthenticate confirms a sensitive change by the signed in user. Users with a
+// password enter it again, behind the same brute-force protection as a login.
+// Passkey, email and federated only users sign in again instead, so the session
+// has to be recent.
+func (s *UsersService) Reauthenticate(tenant *models.Tenant, user *models.User, session *models.Session, plainPassword string, userAgent string, ipAddress string) error {
+	if user.PasswordHash == "" {
+		if time.Since(session.CreatedAt) > ReauthenticationWindow {
+			return ErrReauthenticationRequired
+		}
+		return nil
+	}
+
+	verified, err := s.checkCredentials(tenant, user.Email, plainPassword, userAgent, ipAddress)
+	if err != nil {
+		return err
+	}
+
+	if verified.ID != user.ID {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// VerifyPassword checks the password and transparently upgrades outdated hashes
+func (s *UsersService) VerifyPassword(user *models.User, plainPassword string) error {
+	// Social and passkey only users have no password to check
+	if user.PasswordHash == "" {
+		return ErrInvalidCredentials
+	}
+
+	match, needsRehash, err := password.Verify(plainPassword, user.PasswordHash)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		return ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		newHash, err := password.Hash(plainPassword)
+		if err != nil {
+			return fmt.Errorf("failed to rehash password: %w", err)
+		}
+
+		err = s.db.Model(user).Update("password_hash", newHash).Error
+		if err != nil {
+			return fmt.Errorf("failed to store rehashed password: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 255 {
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}